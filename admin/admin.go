@@ -0,0 +1,287 @@
+/*
+Package admin is the control plane of a running UserAgent: operators list
+its registrations, calls and transactions, place and hang up calls, and
+switch tracing on and off without restarting it.
+
+Server implements the Admin service of protobuf/admin.proto. A gRPC
+server generated from that file can delegate to its methods, and Server
+is itself an http.Handler serving the service with Twirp's JSON protocol,
+so that slurp needs no gRPC dependency:
+
+	curl -d '{}' -H 'Content-Type: application/json' \
+		http://127.0.0.1:8081/twirp/slurp.Admin/ListDialogs
+*/
+package admin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/sdp"
+	"github.com/qmuloadmin/slurp/trace"
+)
+
+// DefaultCallTimeout is how long PlaceCall waits for an answer when
+// neither the request nor the Server sets a timeout
+const DefaultCallTimeout = time.Minute
+
+// Server is the Admin service of a UserAgent. It is safe for concurrent
+// use; expose it to operators only, as it places calls
+type Server struct {
+	UA *slurp.UserAgent
+	// Locations are the registrations listed, none when nil. Listing
+	// every address-of-record needs a store with an AORs method, as
+	// MemoryLocations and FileLocations have
+	Locations slurp.LocationStore
+	// Tracer is switched by SetTracing, which fails when it is nil
+	Tracer *trace.Tracer
+	// Proxy is where PlaceCall sends INVITEs without an address
+	Proxy string
+	// CallTimeout is how long PlaceCall waits for an answer,
+	// DefaultCallTimeout when zero
+	CallTimeout time.Duration
+}
+
+// Error is a failed call, with a Twirp error code such as not_found
+type Error struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e Error) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+// The error codes the service fails with
+const (
+	InvalidArgument    = "invalid_argument"
+	NotFound           = "not_found"
+	FailedPrecondition = "failed_precondition"
+	Unimplemented      = "unimplemented"
+	Internal           = "internal"
+)
+
+type ListRegistrationsRequest struct {
+	AOR string `json:"aor,omitempty"`
+}
+
+type Binding struct {
+	Contact string   `json:"contact"`
+	Expires int64    `json:"expires"`
+	CallId  string   `json:"call_id,omitempty"`
+	Path    []string `json:"path,omitempty"`
+}
+
+type Registration struct {
+	AOR      string    `json:"aor"`
+	Bindings []Binding `json:"bindings"`
+}
+
+type ListRegistrationsResponse struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+type ListDialogsRequest struct{}
+
+type DialogInfo struct {
+	CallId    string `json:"call_id"`
+	LocalTag  string `json:"local_tag"`
+	RemoteTag string `json:"remote_tag"`
+	LocalUri  string `json:"local_uri"`
+	RemoteUri string `json:"remote_uri"`
+	Caller    bool   `json:"caller"`
+	State     string `json:"state"`
+	Addr      string `json:"addr"`
+}
+
+type ListDialogsResponse struct {
+	Dialogs []DialogInfo `json:"dialogs"`
+}
+
+type ListTransactionsRequest struct{}
+
+type Transaction struct {
+	Branch string `json:"branch"`
+	Method string `json:"method"`
+}
+
+type ListTransactionsResponse struct {
+	Transactions []Transaction `json:"transactions"`
+}
+
+type PlaceCallRequest struct {
+	To   string `json:"to"`
+	Addr string `json:"addr,omitempty"`
+	SDP  string `json:"sdp,omitempty"`
+	// Timeout is in seconds
+	Timeout int32 `json:"timeout,omitempty"`
+}
+
+type HangupRequest struct {
+	CallId    string `json:"call_id"`
+	LocalTag  string `json:"local_tag"`
+	RemoteTag string `json:"remote_tag"`
+}
+
+type HangupResponse struct{}
+
+type SetTracingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type SetTracingResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// aorLister is a LocationStore able to list its addresses-of-record
+type aorLister interface {
+	AORs() []string
+}
+
+// ListRegistrations returns the bindings of every address-of-record, or
+// of the one requested
+func (s *Server) ListRegistrations(ctx context.Context, request *ListRegistrationsRequest) (*ListRegistrationsResponse, error) {
+	response := &ListRegistrationsResponse{Registrations: []Registration{}}
+	if s.Locations == nil {
+		return response, nil
+	}
+	aors := []string{request.AOR}
+	if request.AOR == "" {
+		lister, ok := s.Locations.(aorLister)
+		if !ok {
+			return nil, Error{Code: Unimplemented, Msg: "the location store can't list addresses-of-record, request one"}
+		}
+		aors = lister.AORs()
+	}
+	for _, aor := range aors {
+		bindings, err := s.Locations.Bindings(aor)
+		if err != nil {
+			return nil, Error{Code: Internal, Msg: err.Error()}
+		}
+		if len(bindings) == 0 {
+			continue
+		}
+		registration := Registration{AOR: aor}
+		for _, binding := range bindings {
+			registration.Bindings = append(registration.Bindings, Binding{
+				Contact: binding.Contact,
+				Expires: binding.Expires.Unix(),
+				CallId:  binding.CallId,
+				Path:    binding.Path,
+			})
+		}
+		response.Registrations = append(response.Registrations, registration)
+	}
+	return response, nil
+}
+
+func dialogInfo(call *slurp.Call) DialogInfo {
+	id := call.Dialog.ID()
+	info := DialogInfo{
+		CallId:    id.CallId,
+		LocalTag:  id.LocalTag,
+		RemoteTag: id.RemoteTag,
+		LocalUri:  call.Dialog.LocalUri,
+		RemoteUri: call.Dialog.RemoteUri,
+		Caller:    call.Dialog.Caller,
+		State:     "confirmed",
+		Addr:      call.Addr,
+	}
+	if call.Dialog.Ended() {
+		info.State = "terminated"
+	}
+	return info
+}
+
+// ListDialogs returns the calls in progress, sorted by Call-ID
+func (s *Server) ListDialogs(ctx context.Context, request *ListDialogsRequest) (*ListDialogsResponse, error) {
+	response := &ListDialogsResponse{Dialogs: []DialogInfo{}}
+	s.UA.Dialogs().Range(func(id slurp.DialogID, call *slurp.Call) bool {
+		response.Dialogs = append(response.Dialogs, dialogInfo(call))
+		return true
+	})
+	sort.Slice(response.Dialogs, func(i, j int) bool {
+		return response.Dialogs[i].CallId < response.Dialogs[j].CallId
+	})
+	return response, nil
+}
+
+// ListTransactions returns the client transactions waiting for a final
+// response
+func (s *Server) ListTransactions(ctx context.Context, request *ListTransactionsRequest) (*ListTransactionsResponse, error) {
+	response := &ListTransactionsResponse{Transactions: []Transaction{}}
+	for _, transaction := range s.UA.Transactions() {
+		response.Transactions = append(response.Transactions, Transaction{Branch: transaction.Branch, Method: transaction.Method})
+	}
+	return response, nil
+}
+
+// PlaceCall calls a URI from the UserAgent's Profile, returning the call
+// once answered
+func (s *Server) PlaceCall(ctx context.Context, request *PlaceCallRequest) (*DialogInfo, error) {
+	if request.To == "" {
+		return nil, Error{Code: InvalidArgument, Msg: "to is required"}
+	}
+	profile := s.UA.Profile()
+	if profile == nil {
+		return nil, Error{Code: FailedPrecondition, Msg: "the UserAgent has no profile to call from"}
+	}
+	addr := request.Addr
+	if addr == "" {
+		addr = s.Proxy
+	}
+	builder := slurp.NewInvite().To(request.To).From(profile)
+	if request.SDP != "" {
+		offer, err := sdp.Parse(request.SDP)
+		if err != nil {
+			return nil, Error{Code: InvalidArgument, Msg: "sdp: " + err.Error()}
+		}
+		builder.WithSDP(offer)
+	}
+	invite, err := builder.Build()
+	if err != nil {
+		return nil, Error{Code: InvalidArgument, Msg: err.Error()}
+	}
+	timeout := time.Duration(request.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = s.CallTimeout
+	}
+	if timeout == 0 {
+		timeout = DefaultCallTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	call, err := s.UA.Dial(ctx, addr, invite.(*slurp.Invite), nil)
+	if err != nil {
+		return nil, Error{Code: FailedPrecondition, Msg: err.Error()}
+	}
+	info := dialogInfo(call)
+	return &info, nil
+}
+
+// Hangup sends a BYE in a call in progress
+func (s *Server) Hangup(ctx context.Context, request *HangupRequest) (*HangupResponse, error) {
+	call := s.UA.Dialogs().Lookup(slurp.DialogID{CallId: request.CallId, LocalTag: request.LocalTag, RemoteTag: request.RemoteTag})
+	if call == nil || call.Dialog.Ended() {
+		return nil, Error{Code: NotFound, Msg: "no such call"}
+	}
+	if err := call.Hangup(ctx); err != nil {
+		return nil, Error{Code: Internal, Msg: err.Error()}
+	}
+	return &HangupResponse{}, nil
+}
+
+// SetTracing switches the Tracer on or off
+func (s *Server) SetTracing(ctx context.Context, request *SetTracingRequest) (*SetTracingResponse, error) {
+	if s.Tracer == nil {
+		return nil, Error{Code: FailedPrecondition, Msg: "the server has no tracer"}
+	}
+	if request.Enabled {
+		s.Tracer.Enable()
+	} else {
+		s.Tracer.Disable()
+	}
+	return &SetTracingResponse{Enabled: s.Tracer.Enabled()}, nil
+}
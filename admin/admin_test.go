@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/testkit"
+	"github.com/qmuloadmin/slurp/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+func post(t *testing.T, url, method, body string, response interface{}) int {
+	r, err := http.Post(url+PathPrefix+method, "application/json", bytes.NewBufferString(body))
+	assert.Nil(t, err)
+	defer r.Body.Close()
+	assert.Nil(t, json.NewDecoder(r.Body).Decode(response))
+	return r.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	transport, err := testkit.NewMockTransport("udp", "127.0.0.1:5060")
+	assert.Nil(t, err)
+	ua := slurp.NewUserAgent(transport)
+	defer transport.Close()
+	locations := slurp.NewMemoryLocations()
+	locations.Store("sip:bob@biloxi.com", slurp.Binding{Contact: "sip:bob@192.0.2.4", Expires: time.Now().Add(time.Hour)})
+	locations.Store("sip:carol@chicago.com", slurp.Binding{Contact: "sip:carol@192.0.2.5", Expires: time.Now().Add(-time.Hour)})
+	slurp.NewCall(ua, &slurp.Dialog{CallId: "a84b4c76e66710", LocalTag: "1928301774", RemoteTag: "314159", Caller: true}, "192.0.2.4:5060")
+	tracer := trace.New(trace.NewTextWriter(&bytes.Buffer{}))
+	server := httptest.NewServer(&Server{UA: ua, Locations: locations, Tracer: tracer})
+	defer server.Close()
+
+	var registrations ListRegistrationsResponse
+	assert.Equal(t, http.StatusOK, post(t, server.URL, "ListRegistrations", "{}", &registrations))
+	assert.Len(t, registrations.Registrations, 1)
+	assert.Equal(t, "sip:bob@192.0.2.4", registrations.Registrations[0].Bindings[0].Contact)
+
+	var dialogs ListDialogsResponse
+	assert.Equal(t, http.StatusOK, post(t, server.URL, "ListDialogs", "", &dialogs))
+	assert.Equal(t, []DialogInfo{{CallId: "a84b4c76e66710", LocalTag: "1928301774", RemoteTag: "314159", Caller: true, State: "confirmed", Addr: "192.0.2.4:5060"}}, dialogs.Dialogs)
+
+	var transactions ListTransactionsResponse
+	assert.Equal(t, http.StatusOK, post(t, server.URL, "ListTransactions", "{}", &transactions))
+	assert.Empty(t, transactions.Transactions)
+
+	var tracing SetTracingResponse
+	assert.Equal(t, http.StatusOK, post(t, server.URL, "SetTracing", `{"enabled":false}`, &tracing))
+	assert.False(t, tracing.Enabled)
+	assert.False(t, tracer.Enabled())
+
+	var e Error
+	assert.Equal(t, http.StatusNotFound, post(t, server.URL, "Hangup", `{"call_id":"unknown"}`, &e))
+	assert.Equal(t, NotFound, e.Code)
+	assert.Equal(t, http.StatusBadRequest, post(t, server.URL, "PlaceCall", `{}`, &e))
+	assert.Equal(t, InvalidArgument, e.Code)
+	assert.Equal(t, http.StatusNotFound, post(t, server.URL, "Reboot", `{}`, &e))
+	assert.Equal(t, "bad_route", e.Code)
+}
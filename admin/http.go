@@ -0,0 +1,126 @@
+package admin
+
+/*
+Twirp's JSON protocol: every method is a POST to
+/twirp/slurp.Admin/<Method> with the request message as a JSON body,
+answered with the response message, or an Error and an HTTP status
+matching its code.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// PathPrefix is where Server serves the Admin service
+const PathPrefix = "/twirp/slurp.Admin/"
+
+var statuses = map[string]int{
+	InvalidArgument:    http.StatusBadRequest,
+	NotFound:           http.StatusNotFound,
+	FailedPrecondition: http.StatusPreconditionFailed,
+	Unimplemented:      http.StatusNotImplemented,
+	Internal:           http.StatusInternalServerError,
+	"bad_route":        http.StatusNotFound,
+	"malformed":        http.StatusBadRequest,
+}
+
+// method calls one method of the service with its request decoded
+type method func(ctx context.Context, s *Server, decode func(request interface{}) error) (interface{}, error)
+
+var methods = map[string]method{
+	"ListRegistrations": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &ListRegistrationsRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.ListRegistrations(ctx, request)
+	},
+	"ListDialogs": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &ListDialogsRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.ListDialogs(ctx, request)
+	},
+	"ListTransactions": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &ListTransactionsRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.ListTransactions(ctx, request)
+	},
+	"PlaceCall": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &PlaceCallRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.PlaceCall(ctx, request)
+	},
+	"Hangup": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &HangupRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.Hangup(ctx, request)
+	},
+	"SetTracing": func(ctx context.Context, s *Server, decode func(interface{}) error) (interface{}, error) {
+		request := &SetTracingRequest{}
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+		return s.SetTracing(ctx, request)
+	},
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(Error)
+	if !ok {
+		e = Error{Code: Internal, Msg: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statuses[e.Code])
+	json.NewEncoder(w).Encode(e)
+}
+
+// ServeHTTP serves the Admin service with Twirp's JSON protocol
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok := methods[strings.TrimPrefix(r.URL.Path, PathPrefix)]
+	if !ok || !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		writeError(w, Error{Code: "bad_route", Msg: "no such method: " + r.URL.Path})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, Error{Code: "bad_route", Msg: "methods are called with POST"})
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		writeError(w, Error{Code: "bad_route", Msg: "only application/json is served"})
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, Error{Code: "malformed", Msg: err.Error()})
+		return
+	}
+	decode := func(request interface{}) error {
+		if len(strings.TrimSpace(string(body))) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(body, request); err != nil {
+			return Error{Code: "malformed", Msg: err.Error()}
+		}
+		return nil
+	}
+	response, err := f(r.Context(), s, decode)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
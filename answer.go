@@ -0,0 +1,82 @@
+package slurp
+
+/*
+Answering a call is the UAS side of Dial. The 2xx to an INVITE isn't
+retransmitted by the transaction layer, which ends with it, but by the
+dialog until the caller's ACK arrives (RFC 3261 13.3.1.4), so that a lost
+2xx or ACK doesn't leave the call half set up.
+*/
+
+import (
+	"context"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// Answer sends response, a 2xx to invite received from addr, and returns
+// the call it establishes. The response gets the profile's Contact when
+// it has none. Over UDP, the 2xx is retransmitted, T1 apart at first and
+// doubling up to T2, until the ACK arrives. When none comes within 64*T1,
+// the call is hung up with a BYE
+func (ua *UserAgent) Answer(ctx context.Context, addr string, invite Message, response *Response) (*Call, error) {
+	if !IsSuccess(response.StatusCode()) {
+		return nil, Violation{Header: "Status-Line", Reason: "only a 2xx answers a call"}
+	}
+	if profile := ua.Profile(); profile != nil && len(response.Headers().Contacts) == 0 {
+		response.Headers().Contacts = []AddressHeader{profile.Contact()}
+	}
+	dialog := newDialog(invite, response, false, ua.Clock, ua.RecordSink)
+	m, err := ua.intercept(response, Outbound)
+	if err != nil || m == nil {
+		dialog.Terminate("answer dropped")
+		return nil, err
+	}
+	// render once, retransmissions must be identical
+	data := []byte(m.Render())
+	if err := ua.transport.Send(ctx, addr, data); err != nil {
+		dialog.Terminate("answer failed")
+		return nil, TransportError{Method: "INVITE", Addr: addr, Err: err}
+	}
+	call := NewCall(ua, dialog, addr)
+	go ua.awaitAck(call, data)
+	return call, nil
+}
+
+// awaitAck retransmits the 2xx answering the call until it is
+// acknowledged, hanging up once Timer H passed without an ACK
+func (ua *UserAgent) awaitAck(call *Call, data []byte) {
+	timers := ua.Timers()
+	clock := clockOrDefault(ua.Clock)
+	acked := call.Dialog.Acked()
+	deadline := clock.Now().Add(64 * timers.T1)
+	retransmit := ua.transport.Network() == "UDP"
+	interval := timers.T1
+	for {
+		wait := deadline.Sub(clock.Now())
+		if retransmit && interval < wait {
+			wait = interval
+		}
+		fired := make(chan struct{})
+		timer := clock.AfterFunc(wait, func() { close(fired) })
+		select {
+		case <-acked:
+			timer.Stop()
+			return
+		case <-fired:
+		}
+		if call.Dialog.Ended() {
+			return
+		}
+		if !clock.Now().Before(deadline) {
+			break
+		}
+		ua.transport.Send(context.Background(), call.Addr, data)
+		CountRetransmission("INVITE")
+		if interval *= 2; interval > timers.T2 {
+			interval = timers.T2
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timers.F)
+	defer cancel()
+	call.Hangup(ctx)
+}
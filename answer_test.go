@@ -0,0 +1,113 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnswer(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	caller.SetTimers(Timers{M: 10 * time.Millisecond})
+	setup := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	callee.Clock = NewFakeClock(setup)
+	calls := make(chan *Call, 1)
+	go func() {
+		for in := range callee.Receive() {
+			if in.Message.Method() == "INVITE" {
+				call, err := callee.Answer(context.Background(), in.Source.String(), in.Message, NewResponse(in.Message, 200))
+				assert.Nil(t, err)
+				calls <- call
+			}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = caller.Dial(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"), nil)
+	assert.Nil(t, err)
+	call := <-calls
+	assert.Equal(t, setup, call.Dialog.SetupTime, "the call is timed by the UserAgent's clock")
+	select {
+	case <-call.Dialog.Acked():
+	case <-ctx.Done():
+		t.Fatal("2xx not acknowledged")
+	}
+
+	// a 2xx retransmitted once the INVITE transaction ended is acknowledged
+	// again by the dialog
+	time.Sleep(50 * time.Millisecond)
+	call.Dialog.mu.Lock()
+	call.Dialog.acked = nil
+	call.Dialog.mu.Unlock()
+	invite := newTestInvite("sip:bob@biloxi.com")
+	invite.Headers().From.SetParam("tag", call.Dialog.RemoteTag)
+	invite.Control().CallId = call.Dialog.CallId
+	ok := NewResponse(invite, 200)
+	ok.Headers().To.SetParam("tag", call.Dialog.LocalTag)
+	callee.Send(ctx, a.LocalAddr().String(), ok)
+	select {
+	case <-call.Dialog.Acked():
+	case <-ctx.Done():
+		t.Fatal("retransmitted 2xx not acknowledged")
+	}
+}
+
+func TestAnswerUnacknowledged(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	callee.SetTimers(Timers{T1: 10 * time.Millisecond, T2: 40 * time.Millisecond})
+	// the dialogs of the UserAgent emit their records to its sink
+	records := make(chan CallRecord, 1)
+	callee.RecordSink = RecordSinkFunc(func(record CallRecord) {
+		records <- record
+	})
+	calls := make(chan *Call, 1)
+	go func() {
+		for in := range callee.Receive() {
+			if in.Message.Method() == "INVITE" {
+				call, _ := callee.Answer(context.Background(), in.Source.String(), in.Message, NewResponse(in.Message, 200))
+				calls <- call
+			}
+		}
+	}()
+	// the caller never acknowledges the 2xx
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, caller.Send(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com")))
+	answers := 0
+	for in := range caller.Receive() {
+		if in.Message.Method() == "BYE" {
+			caller.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+			break
+		}
+		answers++
+	}
+	// sent at 0, then retransmitted at 10, 30, 70, 110... until 640ms
+	assert.Greater(t, answers, 10)
+	call := <-calls
+	assert.Eventually(t, func() bool {
+		call.Dialog.mu.Lock()
+		defer call.Dialog.mu.Unlock()
+		return call.Dialog.State == Terminated
+	}, time.Second, 10*time.Millisecond)
+	record := <-records
+	assert.Equal(t, call.Dialog.CallId, record.CallId)
+	assert.Equal(t, 200, record.FinalCode)
+}
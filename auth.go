@@ -0,0 +1,306 @@
+package slurp
+
+/*
+Digest authentication for servers, per RFC 3261 22 and RFC 2617. An
+Authenticator challenges requests, manages the lifecycle of the nonces it
+hands out, and verifies the credentials clients answer with against a
+CredentialStore (see store.go).
+*/
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HA1 computes the hash stored for a user, MD5(username:realm:password)
+func HA1(username, realm, password string) string {
+	return md5Hex(username + ":" + realm + ":" + password)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// DigestCredentials are the parameters of an Authorization or
+// Proxy-Authorization header using the Digest scheme
+type DigestCredentials struct {
+	Username   string
+	Realm      string
+	Nonce      string
+	Uri        string
+	Response   string
+	Algorithm  string
+	Opaque     string
+	Qop        string
+	NonceCount string
+	Cnonce     string
+}
+
+// parseDigestParams splits the parameters of a Digest challenge or
+// credentials, unquoting values. Names are lower case
+func parseDigestParams(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 7 || !strings.EqualFold(value[:7], "digest ") {
+		return nil, fmt.Errorf("unsupported authentication scheme: %q", value)
+	}
+	params := make(map[string]string)
+	for _, param := range splitHeaderValues(value[7:]) {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) == 2 {
+			params[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		}
+	}
+	return params, nil
+}
+
+// ParseDigestCredentials parses the value of an Authorization header
+func ParseDigestCredentials(value string) (DigestCredentials, error) {
+	var c DigestCredentials
+	params, err := parseDigestParams(value)
+	if err != nil {
+		return c, err
+	}
+	for k, v := range params {
+		switch k {
+		case "username":
+			c.Username = v
+		case "realm":
+			c.Realm = v
+		case "nonce":
+			c.Nonce = v
+		case "uri":
+			c.Uri = v
+		case "response":
+			c.Response = v
+		case "algorithm":
+			c.Algorithm = v
+		case "opaque":
+			c.Opaque = v
+		case "qop":
+			c.Qop = v
+		case "nc":
+			c.NonceCount = v
+		case "cnonce":
+			c.Cnonce = v
+		}
+	}
+	if c.Username == "" || c.Nonce == "" || c.Response == "" {
+		return c, fmt.Errorf("incomplete digest credentials: %q", value)
+	}
+	return c, nil
+}
+
+func (c DigestCredentials) String() string {
+	value := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s", algorithm=MD5`,
+		c.Username, c.Realm, c.Nonce, c.Uri, c.Response)
+	if c.Opaque != "" {
+		value += fmt.Sprintf(`, opaque="%s"`, c.Opaque)
+	}
+	if c.Qop != "" {
+		value += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, c.Qop, c.NonceCount, c.Cnonce)
+	}
+	return value
+}
+
+// Expected computes the response a client knowing ha1 would send for
+// these credentials with the given request method
+func (c DigestCredentials) Expected(ha1, method string) string {
+	ha2 := md5Hex(method + ":" + c.Uri)
+	if c.Qop == "auth" {
+		return md5Hex(strings.Join([]string{ha1, c.Nonce, c.NonceCount, c.Cnonce, c.Qop, ha2}, ":"))
+	}
+	return md5Hex(ha1 + ":" + c.Nonce + ":" + ha2)
+}
+
+// Challenge is the value of a WWW-Authenticate or Proxy-Authenticate header
+type Challenge struct {
+	Realm  string
+	Nonce  string
+	Opaque string
+	// Qop lists the qualities of protection offered, e.g. auth
+	Qop string
+	// Stale tells the client its credentials were right but the nonce wasn't,
+	// so it may retry without asking the user again
+	Stale bool
+}
+
+// ParseChallenge parses the value of a WWW-Authenticate or Proxy-Authenticate header
+func ParseChallenge(value string) (Challenge, error) {
+	params, err := parseDigestParams(value)
+	if err != nil {
+		return Challenge{}, err
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return Challenge{}, fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+	return Challenge{
+		Realm:  params["realm"],
+		Nonce:  params["nonce"],
+		Opaque: params["opaque"],
+		Qop:    params["qop"],
+		Stale:  strings.EqualFold(params["stale"], "true"),
+	}, nil
+}
+
+func (c Challenge) String() string {
+	value := fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5`, c.Realm, c.Nonce)
+	if c.Qop != "" {
+		value += fmt.Sprintf(`, qop="%s"`, c.Qop)
+	}
+	if c.Opaque != "" {
+		value += fmt.Sprintf(`, opaque="%s"`, c.Opaque)
+	}
+	if c.Stale {
+		value += ", stale=true"
+	}
+	return value
+}
+
+type nonce struct {
+	issued time.Time
+	uses   int
+	count  uint64
+}
+
+// Authenticator challenges and verifies requests for one realm
+type Authenticator struct {
+	Realm string
+	Store CredentialStore
+	// Proxy makes the Authenticator use 407 and the Proxy-* headers
+	Proxy bool
+	// NonceLifetime is how long a nonce may be used for
+	NonceLifetime time.Duration
+	// MaxNonceUses is how many requests may use one nonce, unlimited if zero
+	MaxNonceUses int
+	// Clock used to expire nonces, DefaultClock when nil
+	Clock  Clock
+	mu     sync.Mutex
+	nonces map[string]*nonce
+}
+
+// NewAuthenticator creates an Authenticator with nonces valid for 5 minutes
+func NewAuthenticator(realm string, store CredentialStore) *Authenticator {
+	return &Authenticator{
+		Realm:         realm,
+		Store:         store,
+		NonceLifetime: 5 * time.Minute,
+		nonces:        make(map[string]*nonce),
+	}
+}
+
+func (a *Authenticator) headerNames() (challenge, credentials string) {
+	if a.Proxy {
+		return "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	return "WWW-Authenticate", "Authorization"
+}
+
+// challenge builds the 401 or 407 for request with a new nonce
+func (a *Authenticator) challenge(request Message, stale bool) *Response {
+	data := make([]byte, 16)
+	rand.Read(data)
+	value := hex.EncodeToString(data)
+	now := clockOrDefault(a.Clock).Now()
+	a.mu.Lock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]*nonce)
+	}
+	// forget expired nonces so unanswered challenges don't pile up
+	for k, n := range a.nonces {
+		if now.Sub(n.issued) > a.NonceLifetime {
+			delete(a.nonces, k)
+		}
+	}
+	a.nonces[value] = &nonce{issued: now}
+	a.mu.Unlock()
+
+	code := 401
+	if a.Proxy {
+		code = 407
+	}
+	name, _ := a.headerNames()
+	response := NewResponse(request, code)
+	response.Headers().Extensions.Add(name, Challenge{Realm: a.Realm, Nonce: value, Qop: "auth", Stale: stale}.String())
+	return response
+}
+
+// useNonce checks that a nonce is ours, current, not used up, and that the
+// nonce count isn't replayed
+func (a *Authenticator) useNonce(c DigestCredentials) bool {
+	now := clockOrDefault(a.Clock).Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, ok := a.nonces[c.Nonce]
+	if !ok {
+		return false
+	}
+	if now.Sub(n.issued) > a.NonceLifetime || (a.MaxNonceUses > 0 && n.uses >= a.MaxNonceUses) {
+		delete(a.nonces, c.Nonce)
+		return false
+	}
+	if c.Qop != "" {
+		count, err := strconv.ParseUint(c.NonceCount, 16, 64)
+		if err != nil || count <= n.count {
+			return false
+		}
+		n.count = count
+	}
+	n.uses++
+	return true
+}
+
+// Authenticate verifies the credentials of request. If they are valid, the
+// authenticated username is returned with a nil response. Otherwise the
+// response to send is returned: a challenge when credentials are missing
+// or the nonce is stale, 403 when they are wrong, 400 when malformed or
+// computed for another Request-URI, as they could be replayed otherwise
+// (RFC 2617 3.2.2.5)
+func (a *Authenticator) Authenticate(request Message) (string, *Response) {
+	_, header := a.headerNames()
+	value := request.HeaderValue(header)
+	if value == "" {
+		return "", a.challenge(request, false)
+	}
+	credentials, err := ParseDigestCredentials(value)
+	if err != nil {
+		return "", NewResponse(request, 400)
+	}
+	if credentials.Realm != a.Realm {
+		return "", a.challenge(request, false)
+	}
+	if !EqualUris(credentials.Uri, request.Uri()) {
+		return "", NewResponse(request, 400)
+	}
+	ha1, ok, err := a.Store.HA1(credentials.Username, credentials.Realm)
+	if err != nil {
+		return "", NewResponse(request, 500)
+	}
+	expected := credentials.Expected(ha1, request.Method())
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(credentials.Response)) != 1 {
+		return "", NewResponse(request, 403)
+	}
+	if !a.useNonce(credentials) {
+		return "", a.challenge(request, true)
+	}
+	return credentials.Username, nil
+}
+
+// Filter returns a RequestFilter that stops requests that fail to
+// authenticate. ACK and CANCEL can't be challenged and always pass
+func (a *Authenticator) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if method := in.Message.Method(); method == "ACK" || method == "CANCEL" {
+			return nil, true
+		}
+		_, response := a.Authenticate(in.Message)
+		return response, response == nil
+	}
+}
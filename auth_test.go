@@ -0,0 +1,94 @@
+package slurp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCredentials map[string]string
+
+func (c testCredentials) HA1(username, realm string) (string, bool, error) {
+	password, ok := c[username]
+	return HA1(username, realm, password), ok, nil
+}
+
+func authorizedRequest(t *testing.T, header string) Message {
+	return authorizedRequestTo(t, "sip:biloxi.com", header)
+}
+
+func authorizedRequestTo(t *testing.T, uri, header string) Message {
+	message := "REGISTER " + uri + " SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7\r\n" +
+		"To: Bob <sip:bob@biloxi.com>\r\n" +
+		"From: Bob <sip:bob@biloxi.com>;tag=456248\r\n" +
+		"Call-ID: 843817637684230@998sdasdh09\r\n" +
+		"CSeq: 1826 REGISTER\r\n"
+	if header != "" {
+		message += "Authorization: " + header + "\r\n"
+	}
+	m, err := ParseMessage(message + "\r\n")
+	assert.Nil(t, err)
+	return m
+}
+
+func answer(challenge, password, nc string) string {
+	nonce := strings.SplitN(strings.SplitN(challenge, `nonce="`, 2)[1], `"`, 2)[0]
+	c := DigestCredentials{
+		Username: "bob", Realm: "biloxi.com", Nonce: nonce, Uri: "sip:biloxi.com",
+		Qop: "auth", NonceCount: nc, Cnonce: "0a4f113b",
+	}
+	return fmt.Sprintf(`Digest username="bob", realm="biloxi.com", nonce="%s", uri="sip:biloxi.com", response="%s", qop=auth, nc=%s, cnonce="0a4f113b"`,
+		nonce, c.Expected(HA1("bob", "biloxi.com", password), "REGISTER"), nc)
+}
+
+func TestAuthenticate(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	auth := NewAuthenticator("biloxi.com", testCredentials{"bob": "zanzibar"})
+	auth.Clock = clock
+
+	_, response := auth.Authenticate(authorizedRequest(t, ""))
+	if !assert.NotNil(t, response) {
+		return
+	}
+	assert.Equal(t, 401, response.StatusCode())
+	challenge := response.Headers().Extensions.Get("WWW-Authenticate")
+	assert.Contains(t, challenge, `realm="biloxi.com"`)
+
+	username, response := auth.Authenticate(authorizedRequest(t, answer(challenge, "zanzibar", "00000001")))
+	assert.Nil(t, response)
+	assert.Equal(t, "bob", username)
+
+	// replaying the same nonce count is refused
+	_, response = auth.Authenticate(authorizedRequest(t, answer(challenge, "zanzibar", "00000001")))
+	assert.Equal(t, 401, response.StatusCode())
+
+	_, response = auth.Authenticate(authorizedRequest(t, answer(challenge, "wrong", "00000002")))
+	assert.Equal(t, 403, response.StatusCode())
+
+	// valid credentials for another Request-URI can't be replayed
+	_, response = auth.Authenticate(authorizedRequestTo(t, "sip:chicago.com", answer(challenge, "zanzibar", "00000002")))
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 400, response.StatusCode())
+	}
+	// the URIs are compared as URIs, not strings
+	username, response = auth.Authenticate(authorizedRequestTo(t, "SIP:BILOXI.COM", answer(challenge, "zanzibar", "00000002")))
+	assert.Nil(t, response)
+	assert.Equal(t, "bob", username)
+
+	clock.Advance(6 * time.Minute)
+	_, response = auth.Authenticate(authorizedRequest(t, answer(challenge, "zanzibar", "00000003")))
+	assert.Equal(t, 401, response.StatusCode())
+	assert.Contains(t, response.Headers().Extensions.Get("WWW-Authenticate"), "stale=true")
+}
+
+func TestProxyAuthenticate(t *testing.T) {
+	auth := NewAuthenticator("biloxi.com", testCredentials{})
+	auth.Proxy = true
+	_, response := auth.Authenticate(authorizedRequest(t, ""))
+	assert.Equal(t, 407, response.StatusCode())
+	assert.NotEqual(t, "", response.Headers().Extensions.Get("Proxy-Authenticate"))
+}
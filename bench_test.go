@@ -0,0 +1,126 @@
+package slurp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Benchmarks for the parse, render and transaction hot paths. Compare a
+// change against a baseline with scripts/bench.sh
+
+func readExample(b *testing.B, name string) string {
+	data, err := ioutil.ReadFile("examples/" + name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return string(data)
+}
+
+// manyVias returns the example INVITE as if it had gone through n proxies
+func manyVias(b *testing.B, n int) string {
+	message := readExample(b, "invite.sip")
+	var vias []string
+	for i := 0; i < n; i++ {
+		vias = append(vias, fmt.Sprintf("Via: SIP/2.0/UDP proxy%d.example.com;branch=z9hG4bK%d", i, i))
+	}
+	return strings.Replace(message, "Via:", strings.Join(vias, "\r\n")+"\r\nVia:", 1)
+}
+
+type namedMessage struct {
+	name    string
+	message string
+}
+
+func exampleMessages(b *testing.B) []namedMessage {
+	invite := readExample(b, "invite.sip")
+	var request Invite
+	if err := request.Parse(invite); err != nil {
+		b.Fatal(err)
+	}
+	bye := strings.Replace(strings.Replace(invite, "INVITE sip", "BYE sip", 1), "INVITE\r\n", "BYE\r\n", 1)
+	return []namedMessage{
+		{"Invite", invite},
+		{"Register", readExample(b, "register.sip")},
+		{"Request", bye},
+		{"Response", NewResponse(&request, 200).Render()},
+		{"ManyVias", manyVias(b, 20)},
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, example := range exampleMessages(b) {
+		b.Run(example.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(example.message)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseMessage(example.message); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseRelease(b *testing.B) {
+	message := readExample(b, "invite.sip")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m, err := ParseMessage(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		Release(m)
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	for _, example := range exampleMessages(b) {
+		m, err := ParseMessage(example.message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(example.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.Render()
+			}
+		})
+	}
+}
+
+// BenchmarkTransaction measures a non-INVITE transaction over loopback UDP,
+// from sending the request to receiving the final response
+func BenchmarkTransaction(b *testing.B) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		b.Skip("can't listen on UDP")
+	}
+	s, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	client, server := NewUserAgent(a), NewUserAgent(s)
+	defer a.Close()
+	defer s.Close()
+	go func() {
+		for in := range server.Receive() {
+			server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 200))
+		}
+	}()
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+		request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+		request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1")
+		request.Control().Via = [][2]string{{"UDP", a.LocalAddr().String()}}
+		request.Control().CallId = fmt.Sprintf("bench%d", i)
+		request.Control().Sequence = 1
+		if _, err := client.Request(ctx, s.LocalAddr().String(), request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
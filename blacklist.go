@@ -0,0 +1,134 @@
+package slurp
+
+/*
+During a partial outage, every new request would otherwise wait for the
+same dead server to time out before the next one is tried. A Blacklist
+remembers the targets that recently failed, with a transport error or a
+503, so that they are skipped until their entry expires.
+*/
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons a target is blacklisted, used as metric labels
+const (
+	BlacklistedTransport = "transport"
+	BlacklistedOverload  = "503"
+)
+
+// Metric names reported by a Blacklist
+const (
+	// Counter of targets blacklisted, labeled by reason
+	MetricBlacklisted = "slurp_blacklisted_total"
+	// Counter of targets skipped as they were blacklisted, labeled by the
+	// reason they were
+	MetricBlacklistSkips = "slurp_blacklist_skips_total"
+)
+
+// DefaultBlacklistTTL is how long targets stay blacklisted unless
+// configured otherwise
+const DefaultBlacklistTTL = time.Minute
+
+type blacklistEntry struct {
+	until  time.Time
+	reason string
+}
+
+// Blacklist is a set of targets (host:port) that recently failed, each
+// until its entry expires. It is safe for concurrent use
+type Blacklist struct {
+	// TTL is how long a target stays blacklisted, DefaultBlacklistTTL
+	// when zero. A 503 with a Retry-After keeps it for that long instead
+	TTL time.Duration
+	// Clock used for expiry, DefaultClock when nil
+	Clock   Clock
+	mu      sync.Mutex
+	entries map[string]blacklistEntry
+}
+
+// NewBlacklist creates a Blacklist keeping targets for ttl
+func NewBlacklist(ttl time.Duration) *Blacklist {
+	return &Blacklist{TTL: ttl, entries: make(map[string]blacklistEntry)}
+}
+
+// Add blacklists target for ttl, or the Blacklist's TTL when zero. An
+// entry that lasts longer already is kept
+func (b *Blacklist) Add(target, reason string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = b.TTL
+	}
+	if ttl <= 0 {
+		ttl = DefaultBlacklistTTL
+	}
+	until := clockOrDefault(b.Clock).Now().Add(ttl)
+	key := strings.ToLower(target)
+	b.mu.Lock()
+	if b.entries == nil {
+		b.entries = make(map[string]blacklistEntry)
+	}
+	if entry, ok := b.entries[key]; !ok || entry.until.Before(until) {
+		b.entries[key] = blacklistEntry{until: until, reason: reason}
+	}
+	b.mu.Unlock()
+	Metrics().IncCounter(MetricBlacklisted, map[string]string{"reason": reason})
+}
+
+// Remove takes target off the Blacklist, e.g. once it answered again
+func (b *Blacklist) Remove(target string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, strings.ToLower(target))
+}
+
+// lookup returns the entry of target, removing it once expired
+func (b *Blacklist) lookup(target string) (blacklistEntry, bool) {
+	now := clockOrDefault(b.Clock).Now()
+	key := strings.ToLower(target)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[key]
+	if ok && !now.Before(entry.until) {
+		delete(b.entries, key)
+		return entry, false
+	}
+	return entry, ok
+}
+
+// Listed reports whether target is blacklisted
+func (b *Blacklist) Listed(target string) bool {
+	_, ok := b.lookup(target)
+	return ok
+}
+
+// Skip reports whether target is blacklisted, counting it as skipped if so
+func (b *Blacklist) Skip(target string) bool {
+	entry, ok := b.lookup(target)
+	if ok {
+		Metrics().IncCounter(MetricBlacklistSkips, map[string]string{"reason": entry.reason})
+	}
+	return ok
+}
+
+// Usable returns the targets that aren't blacklisted, in the order given,
+// counting the others as skipped
+func (b *Blacklist) Usable(targets []string) []string {
+	usable := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !b.Skip(target) {
+			usable = append(usable, target)
+		}
+	}
+	return usable
+}
+
+// Observe blacklists target when response is a 503, for as long as its
+// Retry-After says, as the server is overloaded or in maintenance
+func (b *Blacklist) Observe(target string, response *Response) {
+	if response.StatusCode() != 503 {
+		return
+	}
+	b.Add(target, BlacklistedOverload, time.Duration(response.Headers().RetryAfter)*time.Second)
+}
@@ -0,0 +1,72 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlacklist(t *testing.T) {
+	recorder := &recordingMetrics{counters: map[string]int{}, gauges: map[string]float64{}}
+	SetMetrics(recorder)
+	defer SetMetrics(nil)
+	clock := NewFakeClock(time.Unix(0, 0))
+	blacklist := NewBlacklist(30 * time.Second)
+	blacklist.Clock = clock
+
+	blacklist.Add("192.0.2.1:5060", BlacklistedTransport, 0)
+	assert.True(t, blacklist.Listed("192.0.2.1:5060"))
+	assert.Equal(t, []string{"192.0.2.2:5060"}, blacklist.Usable([]string{"192.0.2.1:5060", "192.0.2.2:5060"}))
+	assert.Equal(t, 1, recorder.counters[MetricBlacklistSkips+"{reason=transport}"])
+
+	// a 503 keeps the target for as long as its Retry-After says
+	response := &Response{}
+	response.Parse("SIP/2.0 503 Service Unavailable\r\nRetry-After: 120\r\n\r\n")
+	blacklist.Observe("192.0.2.2:5060", response)
+	assert.Equal(t, 1, recorder.counters[MetricBlacklisted+"{reason=503}"])
+	clock.Advance(30 * time.Second)
+	assert.False(t, blacklist.Listed("192.0.2.1:5060"))
+	assert.True(t, blacklist.Listed("192.0.2.2:5060"))
+	blacklist.Remove("192.0.2.2:5060")
+	assert.False(t, blacklist.Listed("192.0.2.2:5060"))
+}
+
+func TestBlacklistedRequest(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	blacklist := NewBlacklist(0)
+	client.SetBlacklist(blacklist)
+	go func() {
+		for in := range server.Receive() {
+			response := NewResponse(in.Message, 503)
+			response.Headers().RetryAfter = 60
+			server.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr := b.LocalAddr().String()
+	request := func() Message {
+		options, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+		return options
+	}
+	response, err := client.Request(ctx, addr, request())
+	assert.Nil(t, err)
+	assert.Equal(t, 503, response.StatusCode())
+	assert.True(t, blacklist.Listed(addr))
+
+	// the overloaded server isn't tried again until it is off the list
+	_, err = client.Request(ctx, addr, request())
+	assert.Equal(t, BlacklistedError{Addr: addr}, err)
+}
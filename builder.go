@@ -0,0 +1,169 @@
+package slurp
+
+/*
+Builders assemble outgoing requests, filling in what every request needs
+(Call-ID, CSeq, branch and From tag) and checking the mandatory headers,
+instead of setting header structs field by field:
+
+	invite, err := NewInvite().To("sip:bob@biloxi.com").From(profile).WithSDP(offer).Build()
+*/
+
+import (
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// RequestBuilder builds a request. Its methods return the builder, so
+// calls can be chained, and Build returns the request
+type RequestBuilder struct {
+	method      string
+	uri         string
+	to          AddressHeader
+	from        AddressHeader
+	profile     *Profile
+	callId      string
+	sequence    int
+	contentType string
+	payload     []byte
+	extensions  HeaderList
+	// location is added by value when set, see WithLocation
+	location        *Location
+	locationRouting bool
+}
+
+// NewRequestBuilder starts building a request for method
+func NewRequestBuilder(method string) *RequestBuilder {
+	return &RequestBuilder{method: NewRequest(method, "").method}
+}
+
+// NewInvite starts building an INVITE
+func NewInvite() *RequestBuilder {
+	return NewRequestBuilder("INVITE")
+}
+
+// NewRegister starts building a REGISTER. Its Request-URI is always the
+// domain of the To URI, so RequestUri has no effect
+func NewRegister() *RequestBuilder {
+	return NewRequestBuilder("REGISTER")
+}
+
+// To sets the recipient, which is also the Request-URI unless RequestUri is used
+func (b *RequestBuilder) To(uri string) *RequestBuilder {
+	b.to = NewHeader(&ToFrom{}).SetUri(uri)
+	return b
+}
+
+// ToHeader sets the recipient from a header, e.g. one with a display name
+func (b *RequestBuilder) ToHeader(to AddressHeader) *RequestBuilder {
+	b.to = to
+	return b
+}
+
+// RequestUri sets a Request-URI other than the To URI, e.g. a GRUU
+func (b *RequestBuilder) RequestUri(uri string) *RequestBuilder {
+	b.uri = uri
+	return b
+}
+
+// From sets the sender from a profile, which also provides the Contact,
+// Via and User-Agent
+func (b *RequestBuilder) From(profile *Profile) *RequestBuilder {
+	b.profile = profile
+	b.from = profile.From()
+	return b
+}
+
+// FromUri sets the sender without a profile
+func (b *RequestBuilder) FromUri(uri string) *RequestBuilder {
+	b.from = NewHeader(&ToFrom{}).SetUri(uri)
+	return b
+}
+
+// CallId sets the Call-ID instead of generating one
+func (b *RequestBuilder) CallId(id string) *RequestBuilder {
+	b.callId = id
+	return b
+}
+
+// Sequence sets the CSeq number instead of starting at 1
+func (b *RequestBuilder) Sequence(sequence int) *RequestBuilder {
+	b.sequence = sequence
+	return b
+}
+
+// Header adds an extension header
+func (b *RequestBuilder) Header(name, value string) *RequestBuilder {
+	b.extensions.Add(name, value)
+	return b
+}
+
+// WithBody sets the body and its Content-Type
+func (b *RequestBuilder) WithBody(contentType string, body []byte) *RequestBuilder {
+	b.contentType, b.payload = contentType, body
+	return b
+}
+
+// WithSDP sets an SDP session description as the body
+func (b *RequestBuilder) WithSDP(session *sdp.Session) *RequestBuilder {
+	return b.WithBody("application/sdp", []byte(session.Render()))
+}
+
+// Build checks the request has a recipient and a sender, and returns it
+// with a generated Call-ID, branch and From tag where they weren't given.
+// INVITE and REGISTER are returned as *Invite and *Register, anything else
+// as *Request
+func (b *RequestBuilder) Build() (Message, error) {
+	if b.to == nil || b.to.Uri() == "" {
+		return nil, Violation{Header: "To", Reason: "missing mandatory header"}
+	}
+	if b.from == nil || b.from.Uri() == "" {
+		return nil, Violation{Header: "From", Reason: "missing mandatory header"}
+	}
+	uri := b.uri
+	if uri == "" {
+		uri = b.to.Uri()
+	}
+	var m Message
+	switch b.method {
+	case "INVITE":
+		invite := &Invite{}
+		invite.SetUri(uri)
+		m = invite
+	case "REGISTER":
+		m = &Register{}
+	default:
+		m = NewRequest(b.method, uri)
+	}
+	if err := checkUriScheme(uri); err != nil {
+		return nil, err
+	}
+	headers, control := m.Headers(), m.Control()
+	headers.To = CloneHeader(b.to)
+	headers.From = CloneHeader(b.from)
+	if headers.From.Param("tag") == "" {
+		headers.From.SetParam("tag", generateTag())
+	}
+	headers.Extensions.fields = append([]HeaderField(nil), b.extensions.fields...)
+	if b.profile != nil {
+		b.profile.Apply(m)
+	}
+	control.CallId = b.callId
+	if control.CallId == "" {
+		control.CallId = generateTag() + generateTag()
+	}
+	control.Sequence = b.sequence
+	if control.Sequence == 0 {
+		control.Sequence = 1
+	}
+	control.ViaBranch = NewBranch()
+	if b.payload != nil {
+		headers.ContentType = b.contentType
+		m.SetPayload(append([]byte(nil), b.payload...))
+	}
+	if b.location != nil {
+		if err := attachLocation(m, b.location, b.locationRouting); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
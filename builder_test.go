@@ -0,0 +1,34 @@
+package slurp
+
+import (
+	"testing"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	offer, err := sdp.Parse("v=0\r\no=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\n")
+	assert.Nil(t, err)
+	m, err := NewInvite().To("sip:bob@biloxi.com").From(exampleProfile()).WithSDP(offer).Build()
+	assert.Nil(t, err)
+	invite := m.(*Invite)
+	assert.Equal(t, "sip:bob@biloxi.com", invite.Uri())
+	assert.NotEqual(t, "", invite.Control().CallId)
+	assert.Equal(t, 1, invite.Control().Sequence)
+	assert.Equal(t, BranchPrefix, invite.Control().ViaBranch[:len(BranchPrefix)])
+	assert.NotEqual(t, "", invite.Headers().From.Param("tag"))
+	assert.Equal(t, "application/sdp", invite.Headers().ContentType)
+	assert.Empty(t, invite.Validate())
+
+	parsed, err := ParseMessage(invite.Render())
+	assert.Nil(t, err)
+	assert.Equal(t, offer.Render(), parsed.StringPayload())
+
+	_, err = NewRequestBuilder("OPTIONS").FromUri("sip:alice@atlanta.com").Build()
+	assert.Equal(t, Violation{Header: "To", Reason: "missing mandatory header"}, err)
+	_, err = NewRequestBuilder("OPTIONS").To("mailto:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	assert.IsType(t, UnsupportedUriSchemeError{}, err)
+}
@@ -0,0 +1,233 @@
+package slurp
+
+/*
+Calls tie a dialog to the UserAgent it runs on, offering high level
+operations such as hanging up and transferring (RFC 3515, RFC 5589)
+without exposing the REFER and sipfrag machinery underneath.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// Call is an established dialog on a UserAgent
+type Call struct {
+	Dialog *Dialog
+	// Addr is the host:port in-dialog requests are sent to
+	Addr     string
+	ua       *UserAgent
+	mu       sync.Mutex
+	transfer *Transfer
+}
+
+// NewCall creates a Call for dialog, sending requests through ua to addr.
+// The UserAgent won't finish shutting down until the call has ended
+func NewCall(ua *UserAgent, dialog *Dialog, addr string) *Call {
+	call := &Call{Dialog: dialog, Addr: addr, ua: ua}
+	ua.track(call)
+	return call
+}
+
+// Hangup sends a BYE and terminates the dialog
+func (c *Call) Hangup(ctx context.Context) error {
+	_, err := c.ua.Request(ctx, c.Addr, c.Dialog.NewRequest("BYE"))
+	c.Dialog.Terminate("BYE")
+	return err
+}
+
+// TransferProgress is the status of a transfer, as reported by the
+// transferee in NOTIFY requests
+type TransferProgress struct {
+	StatusCode int
+	Reason     string
+}
+
+// Transfer is a transfer in progress
+type Transfer struct {
+	// Progress receives each status reported by the transferee. It is
+	// closed after the final one, once the transfer succeeded or failed
+	Progress <-chan TransferProgress
+	progress chan TransferProgress
+}
+
+// BlindTransfer asks the remote party to call target instead. The call is
+// hung up once the transferee reports that target answered
+func (c *Call) BlindTransfer(ctx context.Context, target string) (*Transfer, error) {
+	return c.refer(ctx, "<"+target+">")
+}
+
+// AttendedTransfer connects the remote party to the remote party of other,
+// replacing other's dialog (RFC 3891). The call is hung up once the
+// transferee reports success, and other is ended by the target, whose BYE
+// Handle answers
+func (c *Call) AttendedTransfer(ctx context.Context, other *Call) (*Transfer, error) {
+	replaces := RefTo(other.Dialog).Replaces()
+	d := other.Dialog
+	d.mu.Lock()
+	target := d.RemoteTarget
+	d.mu.Unlock()
+	return c.refer(ctx, fmt.Sprintf("<%s?Replaces=%s>", target, url.QueryEscape(replaces)))
+}
+
+func (c *Call) refer(ctx context.Context, referTo string) (*Transfer, error) {
+	request := c.Dialog.NewRequest("REFER")
+	request.Headers().Extensions.Add("Refer-To", referTo)
+	request.Headers().Extensions.Add("Referred-By", "<"+c.Dialog.LocalUri+">")
+	progress := make(chan TransferProgress, 8)
+	transfer := &Transfer{Progress: progress, progress: progress}
+	// NOTIFYs may arrive before the response to the REFER
+	c.mu.Lock()
+	c.transfer = transfer
+	c.mu.Unlock()
+	response, err := c.ua.Request(ctx, c.Addr, request)
+	if err == nil && !IsSuccess(response.StatusCode()) {
+		err = TransferError{StatusCode: response.StatusCode(), Reason: response.Reason()}
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.transfer = nil
+		c.mu.Unlock()
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// Reinvite changes the session of the call with a re-INVITE offering
+// offer, returning the peer's answer. When the peer's own re-INVITE
+// crossed ours and it answered 491, ours is retried after the interval
+// RFC 3261 14.1 requires, until ctx ends. Any other failure, e.g. 488 for
+// an unacceptable offer, leaves the session as it was and is returned as
+// a RejectedError, though a 481 or 408 means the call is gone and ends it,
+// as does a re-INVITE that can't be sent or isn't answered
+func (c *Call) Reinvite(ctx context.Context, offer *sdp.Session) (*sdp.Session, error) {
+	for {
+		invite, err := c.Dialog.Reinvite(offer)
+		if err != nil {
+			return nil, err
+		}
+		// retransmissions of the 2xx are acknowledged again
+		var mu sync.Mutex
+		var ack *Request
+		resend := func(*Response) {
+			mu.Lock()
+			defer mu.Unlock()
+			if ack != nil {
+				c.ua.Send(context.Background(), c.Addr, ack)
+			}
+		}
+		response, err := c.ua.request(ctx, c.Addr, invite, transactionHooks{accepted: resend})
+		if err != nil {
+			c.Dialog.EndReinvite()
+			if isLost(err) {
+				c.Dialog.Terminate(ReasonPhrase(408))
+			}
+			return nil, err
+		}
+		code := response.StatusCode()
+		switch {
+		case IsSuccess(code):
+			c.Dialog.modified(offer, response)
+			c.Dialog.EndReinvite()
+			mu.Lock()
+			ack = c.Dialog.NewAck(invite.control.Sequence)
+			c.ua.addVia(ack)
+			c.Dialog.SentAck(ack)
+			mu.Unlock()
+			if err := c.ua.Send(ctx, c.Addr, ack); err != nil {
+				return nil, err
+			}
+			return messageSDP(response)
+		case code == 491:
+			retry := make(chan struct{})
+			timer := c.Dialog.RetryReinvite(func() { close(retry) })
+			select {
+			case <-retry:
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		case code == 481 || code == 408:
+			c.Dialog.EndReinvite()
+			c.Dialog.Terminate(response.Reason())
+		default:
+			c.Dialog.EndReinvite()
+		}
+		return nil, RejectedError{StatusCode: code, Reason: response.Reason()}
+	}
+}
+
+// ParseSipfrag returns the status of a message/sipfrag body, which is the
+// status line of a response (RFC 3420)
+func ParseSipfrag(body string) (int, string, error) {
+	line := strings.TrimSpace(strings.SplitN(body, "\n", 2)[0])
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "SIP/") {
+		return 0, "", InvalidMessageFormatError(line)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", InvalidMessageFormatError(line)
+	}
+	reason := ""
+	if len(fields) == 3 {
+		reason = fields[2]
+	}
+	return code, reason, nil
+}
+
+// Handle processes a request received within the call, returning the
+// response to send, or nil if the request isn't one the call handles.
+// It handles BYE, and the NOTIFYs reporting on a transfer
+func (c *Call) Handle(request Message) *Response {
+	switch request.Method() {
+	case "ACK":
+		c.Dialog.ReceiveAck(request)
+		return nil
+	case "BYE":
+		c.Dialog.Terminate("BYE")
+		return NewResponse(request, 200)
+	case "NOTIFY":
+		if !strings.HasPrefix(strings.ToLower(request.HeaderValue("Event")), "refer") {
+			return nil
+		}
+		code, reason, err := ParseSipfrag(request.StringPayload())
+		if err != nil {
+			return NewResponse(request, 400)
+		}
+		c.mu.Lock()
+		transfer := c.transfer
+		if IsFinal(code) {
+			c.transfer = nil
+		}
+		c.mu.Unlock()
+		if transfer == nil {
+			return NewResponse(request, 481)
+		}
+		// intermediate statuses are dropped rather than block when nobody
+		// is reading, always leaving room for the final one
+		if IsFinal(code) || len(transfer.progress) < cap(transfer.progress)-1 {
+			transfer.progress <- TransferProgress{StatusCode: code, Reason: reason}
+		}
+		if IsFinal(code) {
+			close(transfer.progress)
+			if IsSuccess(code) {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), c.ua.Timers().F)
+					defer cancel()
+					c.Hangup(ctx)
+				}()
+			}
+		}
+		return NewResponse(request, 200)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package slurp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSipfrag(t *testing.T) {
+	code, reason, err := ParseSipfrag("SIP/2.0 180 Ringing\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, 180, code)
+	assert.Equal(t, "Ringing", reason)
+	_, _, err = ParseSipfrag("INVITE sip:bob@biloxi.com SIP/2.0")
+	assert.NotNil(t, err)
+}
+
+func TestBlindTransfer(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	transferor, transferee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	received := make(chan Message, 4)
+	go func() {
+		for in := range transferee.Receive() {
+			received <- in.Message
+			code := 200
+			if in.Message.Method() == "REFER" {
+				code = 202
+			}
+			transferee.Send(context.Background(), in.Source.String(), NewResponse(in.Message, code))
+		}
+	}()
+
+	invite, dialog := exampleDialog(t, true)
+	call := NewCall(transferor, dialog, b.LocalAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	transfer, err := call.BlindTransfer(ctx, "sip:carol@chicago.com")
+	assert.Nil(t, err)
+	refer := <-received
+	assert.Equal(t, "REFER", refer.Method())
+	assert.Equal(t, "<sip:carol@chicago.com>", refer.HeaderValue("Refer-To"))
+	assert.Equal(t, invite.Control().CallId, refer.Control().CallId)
+
+	notify := func(frag string) *Response {
+		m, err := ParseMessage(strings.Join([]string{
+			"NOTIFY sip:alice@pc33.atlanta.com SIP/2.0",
+			"Via: SIP/2.0/UDP " + b.LocalAddr().String() + ";branch=z9hG4bK" + generateTag(),
+			"To: <sip:alice@atlanta.com>;tag=1928301774",
+			"From: <sip:bob@biloxi.com>;tag=" + dialog.RemoteTag,
+			"Call-ID: " + dialog.CallId,
+			"CSeq: 1 NOTIFY",
+			"Event: refer",
+			"Subscription-State: active",
+			"Content-Type: message/sipfrag",
+			"", frag,
+		}, "\r\n"))
+		assert.Nil(t, err)
+		return call.Handle(m)
+	}
+	assert.Equal(t, 200, notify("SIP/2.0 100 Trying").StatusCode())
+	assert.Equal(t, 200, notify("SIP/2.0 200 OK").StatusCode())
+	assert.Equal(t, TransferProgress{100, "Trying"}, <-transfer.Progress)
+	assert.Equal(t, TransferProgress{200, "OK"}, <-transfer.Progress)
+	_, open := <-transfer.Progress
+	assert.False(t, open)
+
+	// the transferor hangs up once the transfer succeeded
+	select {
+	case bye := <-received:
+		assert.Equal(t, "BYE", bye.Method())
+	case <-ctx.Done():
+		t.Fatal("no BYE after the transfer")
+	}
+	assert.Equal(t, 481, notify("SIP/2.0 200 OK").StatusCode())
+}
+
+func TestTransferRefused(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	transferor, transferee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for in := range transferee.Receive() {
+			transferee.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 603))
+		}
+	}()
+	_, dialog := exampleDialog(t, true)
+	_, other := exampleDialog(t, true)
+	other.RemoteTarget = "sip:carol@192.0.2.7"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = NewCall(transferor, dialog, b.LocalAddr().String()).AttendedTransfer(ctx, NewCall(transferor, other, ""))
+	assert.Equal(t, TransferError{StatusCode: 603, Reason: "Decline"}, err)
+}
+
+func TestReinvite(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	local, remote := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	const answer = "v=0\r\no=bob 1 2 IN IP4 192.0.2.2\r\ns=-\r\nc=IN IP4 192.0.2.2\r\nt=0 0\r\nm=audio 49172 RTP/AVP 0\r\na=recvonly\r\n"
+	received := make(chan Message, 8)
+	glare := make(chan struct{})
+	go func() {
+		invites := 0
+		for in := range remote.Receive() {
+			received <- in.Message
+			if in.Message.Method() != "INVITE" {
+				continue
+			}
+			invites++
+			var response *Response
+			switch invites {
+			case 1:
+				response = NewResponse(in.Message, 491)
+			case 2:
+				response = NewResponse(in.Message, 200)
+				response.Headers().Contacts = []AddressHeader{NewHeader(&ToFrom{}).SetUri("sip:bob@192.0.2.2:5070")}
+				response.Headers().ContentType = "application/sdp"
+				response.SetPayload([]byte(answer))
+			default:
+				response = NewResponse(in.Message, 488)
+			}
+			remote.Send(context.Background(), in.Source.String(), response)
+			if invites == 1 {
+				close(glare)
+			}
+		}
+	}()
+
+	_, dialog := exampleDialog(t, true)
+	clock := NewFakeClock(time.Unix(0, 0))
+	dialog.Clock = clock
+	dialog.RouteSet = []string{"<sip:proxy.atlanta.com;lr>"}
+	call := NewCall(local, dialog, b.LocalAddr().String())
+	sequence := dialog.LocalSeq
+	offer, err := sdp.Parse("v=0\r\no=alice 1 2 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\na=sendonly\r\n")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan *sdp.Session)
+	go func() {
+		session, err := call.Reinvite(ctx, offer)
+		assert.Nil(t, err)
+		done <- session
+	}()
+	<-glare
+	var session *sdp.Session
+	for session == nil {
+		select {
+		case session = <-done:
+		case <-time.After(10 * time.Millisecond):
+			clock.Advance(5 * time.Second)
+		}
+	}
+	assert.Equal(t, answer, session.Render())
+	assert.Equal(t, "sip:bob@192.0.2.2:5070", dialog.RemoteTarget)
+	assert.Equal(t, answer, dialog.RemoteSDP.Render())
+
+	// the 491 is acknowledged by the transaction, the 2xx by the call
+	var methods []string
+	var invites []Message
+	for i := 0; i < 4; i++ {
+		m := <-received
+		methods = append(methods, m.Method())
+		assert.Equal(t, []string{"<sip:proxy.atlanta.com;lr>"}, RouteSet(m), m.Method())
+		if m.Method() == "INVITE" {
+			invites = append(invites, m)
+		}
+	}
+	assert.Equal(t, []string{"INVITE", "ACK", "INVITE", "ACK"}, methods)
+	assert.Equal(t, sequence+1, invites[0].Control().Sequence)
+	retried := invites[1]
+	assert.Equal(t, sequence+2, retried.Control().Sequence)
+	assert.Equal(t, dialog.LocalTag, retried.Headers().From.Param("tag"))
+	assert.Equal(t, dialog.RemoteTag, retried.Headers().To.Param("tag"))
+
+	// a rejected offer leaves the session as it was
+	_, err = call.Reinvite(ctx, offer)
+	assert.Equal(t, RejectedError{StatusCode: 488, Reason: "Not Acceptable Here"}, err)
+	assert.Equal(t, answer, dialog.RemoteSDP.Render())
+	assert.Equal(t, Confirmed, dialog.State)
+	assert.Nil(t, dialog.BeginReinvite())
+}
+
+func TestModify(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	local, remote := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	const answer = "v=0\r\no=bob 1 2 IN IP4 192.0.2.2\r\ns=-\r\nc=IN IP4 192.0.2.2\r\nt=0 0\r\nm=audio 49172 RTP/AVP 0\r\n"
+	received := make(chan Message, 8)
+	go func() {
+		for in := range remote.Receive() {
+			received <- in.Message
+			if in.Message.Method() == "ACK" {
+				continue
+			}
+			response := NewResponse(in.Message, 200)
+			if len(in.Message.Payload()) > 0 {
+				response.Headers().ContentType = "application/sdp"
+				response.SetPayload([]byte(answer))
+			}
+			remote.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+
+	_, dialog := exampleDialog(t, true)
+	call := NewCall(local, dialog, b.LocalAddr().String())
+	offer, err := sdp.Parse("v=0\r\no=alice 1 2 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\n")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// UPDATE is used when preferred and allowed
+	local.SetPreferUpdate(true)
+	dialog.RemoteAllow = []string{"INVITE", "ACK", "BYE", "UPDATE"}
+	session, err := call.Modify(ctx, offer)
+	assert.Nil(t, err)
+	assert.Equal(t, answer, session.Render())
+	assert.Equal(t, "UPDATE", (<-received).Method())
+	assert.Equal(t, offer, dialog.LocalSDP)
+	assert.Nil(t, call.Refresh(ctx))
+	update := <-received
+	assert.Equal(t, "UPDATE", update.Method())
+	assert.Empty(t, update.Payload())
+
+	// and re-INVITE when the peer doesn't allow it, offering the session
+	// in use again to refresh it
+	dialog.RemoteAllow = []string{"INVITE", "ACK", "BYE"}
+	assert.Nil(t, call.Refresh(ctx))
+	invite := <-received
+	assert.Equal(t, "INVITE", invite.Method())
+	assert.Equal(t, offer.Render(), string(invite.Payload()))
+	assert.Equal(t, "ACK", (<-received).Method())
+}
@@ -0,0 +1,111 @@
+package slurp
+
+/*
+Cancelling an INVITE races with the callee: a CANCEL may only be sent once
+the INVITE got a provisional response (RFC 3261 9.1), the INVITE is then
+answered with 487 besides the 200 to the CANCEL, and the callee may have
+answered the INVITE before the CANCEL arrived. The call it answered is
+hung up right away, as the caller no longer wants it.
+*/
+
+import (
+	"context"
+)
+
+// inviteCancellation is an INVITE transaction whose caller gave up on it
+type inviteCancellation struct {
+	addr       string
+	invite     Message
+	data       []byte
+	transport  Transport
+	responses  chan *Response
+	proceeding bool
+	forget     func()
+}
+
+// cancelInvite completes a cancelled INVITE transaction: it sends the
+// CANCEL once the INVITE got a provisional response, retransmitting the
+// INVITE over UDP until then, acknowledges the final response, and hangs
+// up the calls answered despite the CANCEL. The transaction is given up
+// 64*T1 after the caller gave up, if no final response came
+func (ua *UserAgent) cancelInvite(c inviteCancellation) {
+	defer c.forget()
+	timers := ua.Timers()
+	clock := clockOrDefault(ua.Clock)
+	timeout := make(chan struct{})
+	timeoutTimer := clock.AfterFunc(64*timers.T1, func() { close(timeout) })
+	defer timeoutTimer.Stop()
+	retransmit := make(chan struct{}, 1)
+	interval := timers.T1
+	var timer Timer
+	if !c.proceeding && c.transport.Network() == "UDP" {
+		timer = clock.AfterFunc(interval, func() {
+			select {
+			case retransmit <- struct{}{}:
+			default:
+			}
+		})
+		defer func() { timer.Stop() }()
+	}
+	cancelled := false
+	cancel := func() {
+		if cancelled {
+			return
+		}
+		cancelled = true
+		if timer != nil {
+			timer.Stop()
+		}
+		ctx, stop := context.WithTimeout(context.Background(), timers.F)
+		go func() {
+			defer stop()
+			ua.Request(ctx, c.addr, NewCancel(c.invite))
+		}()
+	}
+	if c.proceeding {
+		cancel()
+	}
+	// the ACK sent for each answer, by To tag, resent with its retransmissions
+	acks := make(map[string]*Request)
+	for {
+		select {
+		case <-retransmit:
+			if cancelled {
+				continue
+			}
+			c.transport.Send(context.Background(), c.addr, c.data)
+			CountRetransmission("INVITE")
+			interval *= 2
+			timer.Reset(interval)
+		case response := <-c.responses:
+			code := response.StatusCode()
+			switch {
+			case !IsFinal(code):
+				cancel()
+			case IsSuccess(code):
+				tag := response.Headers().To.Param("tag")
+				ack, answered := acks[tag]
+				if !answered {
+					dialog := newDialog(c.invite, response, true, ua.Clock, ua.RecordSink)
+					ack = dialog.NewAck(c.invite.Control().Sequence)
+					ua.addVia(ack)
+					dialog.SentAck(ack)
+					acks[tag] = ack
+					call := NewCall(ua, dialog, c.addr)
+					go func() {
+						ctx, stop := context.WithTimeout(context.Background(), timers.F)
+						defer stop()
+						call.Hangup(ctx)
+					}()
+				}
+				ua.Send(context.Background(), c.addr, ack)
+			default:
+				// a 487, or a failure that crossed the CANCEL
+				c.transport.Send(context.Background(), c.addr, []byte(newFailureAck(c.invite, response).Render()))
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package slurp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cancelledCallee answers INVITEs with 180 after delay, and CANCELs with
+// 200 and then the INVITE with final, recording the other requests
+func cancelledCallee(t *testing.T, delay time.Duration, final int) (string, chan Message) {
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { b.Close() })
+	callee := NewUserAgent(b)
+	received := make(chan Message, 16)
+	var ringing atomic.Bool
+	go func() {
+		ctx := context.Background()
+		var invite Message
+		var tag string
+		for in := range callee.Receive() {
+			switch in.Message.Method() {
+			case "INVITE":
+				if invite != nil {
+					// a retransmission
+					continue
+				}
+				invite = in.Message
+				source := in.Source.String()
+				go func() {
+					time.Sleep(delay)
+					progress := NewResponse(invite, 180)
+					tag = progress.Headers().To.Param("tag")
+					ringing.Store(true)
+					callee.Send(ctx, source, progress)
+				}()
+			case "CANCEL":
+				// the CANCEL may only come once the INVITE rang
+				assert.True(t, ringing.Load())
+				callee.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+				response := NewResponse(invite, final)
+				response.Headers().To.SetParam("tag", tag)
+				callee.Send(ctx, in.Source.String(), response)
+			default:
+				received <- in.Message
+				if in.Message.Method() == "BYE" {
+					callee.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+				}
+			}
+		}
+	}()
+	return b.LocalAddr().String(), received
+}
+
+func TestCancelInvite(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	caller := NewUserAgent(a)
+	caller.SetTimers(Timers{T1: 20 * time.Millisecond})
+
+	for _, test := range []struct {
+		name  string
+		delay time.Duration
+		final int
+		sent  []string
+	}{
+		{"cancelled", 0, 487, []string{"ACK"}},
+		// the CANCEL waits for the INVITE to ring
+		{"before ringing", 200 * time.Millisecond, 487, []string{"ACK"}},
+		// the callee answered before the CANCEL arrived
+		{"answered", 0, 200, []string{"ACK", "BYE"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr, received := cancelledCallee(t, test.delay, test.final)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := caller.Dial(ctx, addr, newTestInvite("sip:bob@biloxi.com"), nil)
+			assert.Equal(t, context.DeadlineExceeded, err)
+			for _, method := range test.sent {
+				select {
+				case m := <-received:
+					assert.Equal(t, method, m.Method())
+				case <-time.After(2 * time.Second):
+					t.Fatalf("no %s", method)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package slurp
+
+/*
+Call detail records describe a call once its dialog terminates, for
+billing and reporting. Records are emitted to the RecordSink of the
+UserAgent the dialog belongs to.
+*/
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// CallRecord is the call detail record of a single dialog
+type CallRecord struct {
+	CallId string `json:"call_id"`
+	// From and To are the caller's and callee's URIs, regardless of
+	// which side of the call we were on
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	SetupTime   time.Time `json:"setup_time"`
+	AnswerTime  time.Time `json:"answer_time,omitempty"`
+	ReleaseTime time.Time `json:"release_time"`
+	// Why the call ended, e.g. BYE, CANCEL or a timeout
+	ReleaseReason string `json:"release_reason"`
+	// The final response code to the initial INVITE
+	FinalCode int `json:"final_code"`
+}
+
+// Duration returns how long the call was answered for
+func (r CallRecord) Duration() time.Duration {
+	if r.AnswerTime.IsZero() {
+		return 0
+	}
+	return r.ReleaseTime.Sub(r.AnswerTime)
+}
+
+// RecordSink receives call detail records, e.g. to forward them to a
+// billing system. Record may be called from multiple goroutines
+type RecordSink interface {
+	Record(CallRecord)
+}
+
+// RecordSinkFunc adapts a function to a RecordSink
+type RecordSinkFunc func(CallRecord)
+
+func (f RecordSinkFunc) Record(record CallRecord) {
+	f(record)
+}
+
+// MultiSink sends each record to every sink in order
+type MultiSink []RecordSink
+
+func (m MultiSink) Record(record CallRecord) {
+	for _, sink := range m {
+		sink.Record(record)
+	}
+}
+
+// JSONRecordSink writes each record as a line of JSON
+type JSONRecordSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONRecordSink creates a JSONRecordSink writing to w
+func NewJSONRecordSink(w io.Writer) *JSONRecordSink {
+	return &JSONRecordSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *JSONRecordSink) Record(record CallRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoder.Encode(record)
+}
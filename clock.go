@@ -0,0 +1,140 @@
+package slurp
+
+/*
+Clocks abstract time for everything that needs timers: transactions,
+registrations, session timers. Components default to the real clock,
+but accept a FakeClock so timeouts can be unit-tested deterministically.
+*/
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single pending call created by a Clock
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the
+	// timer already fired or was stopped
+	Stop() bool
+	// Reset changes the timer to fire after d. It returns false if the
+	// timer already fired or was stopped
+	Reset(d time.Duration) bool
+}
+
+// DefaultClock is used by components that weren't given a Clock
+var DefaultClock Clock = realClock{}
+
+// clockOrDefault returns c, or DefaultClock when c is nil
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return DefaultClock
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is a Clock that only moves when Advance is called.
+// Timers fire synchronously, in order, from within Advance
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock starting at the given time
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer that is due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].when.Before(c.timers[j].when)
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.when
+		// timers may create or stop other timers, so don't hold the lock
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Pending returns the number of timers that haven't fired or been stopped
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	f     func()
+}
+
+// remove takes the timer out of the clock, the lock must be held
+func (t *fakeTimer) remove() bool {
+	for i, each := range t.clock.timers {
+		if each == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.remove()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.remove()
+	t.when = t.clock.now.Add(d)
+	t.clock.timers = append(t.clock.timers, t)
+	return active
+}
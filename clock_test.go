@@ -0,0 +1,41 @@
+package slurp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	first := clock.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Empty(t, fired)
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, []string{"first", "second"}, fired)
+	assert.Equal(t, start.Add(2500*time.Millisecond), clock.Now())
+	assert.False(t, first.Reset(time.Second))
+	clock.Advance(time.Second)
+	assert.Equal(t, []string{"first", "second", "first"}, fired)
+	assert.Equal(t, 0, clock.Pending())
+}
+
+func TestReinviteRetryWithFakeClock(t *testing.T) {
+	_, dialog := exampleDialog(t, false)
+	clock := NewFakeClock(time.Now())
+	dialog.Clock = clock
+	assert.Nil(t, dialog.BeginReinvite())
+	retried := false
+	dialog.RetryReinvite(func() { retried = true })
+	assert.Nil(t, dialog.BeginReinvite())
+	clock.Advance(2 * time.Second)
+	assert.True(t, retried)
+}
@@ -0,0 +1,98 @@
+package slurp
+
+/*
+Messages and their headers are plain values and are not safe for
+concurrent mutation. A message shared between goroutines, e.g. a request
+held by a retransmission timer while a dialog refresh builds the next one,
+must either be treated as read-only by everyone, or be cloned first so each
+goroutine owns its copy. Clone returns such a snapshot: nothing is shared
+with the original, so either may be changed freely.
+
+The stack follows the same rule: client transactions render a request once
+and never touch it again, and Dialog guards its own state with a mutex.
+*/
+
+// CloneHeader returns a copy of h that shares nothing with it. Header
+// implementations outside this package can't be copied and are returned as-is
+func CloneHeader(h AddressHeader) AddressHeader {
+	switch h := h.(type) {
+	case *Contact:
+		clone := *h
+		clone.params = h.params.clone()
+		return &clone
+	case *Info:
+		clone := *h
+		clone.params = h.params.clone()
+		return &clone
+	case *ToFrom:
+		clone := *h
+		return &clone
+	case *Address:
+		clone := *h
+		clone.params = h.params.clone()
+		return &clone
+	}
+	return h
+}
+
+func cloneHeaders(headers []AddressHeader) []AddressHeader {
+	if headers == nil {
+		return nil
+	}
+	clones := make([]AddressHeader, len(headers))
+	for i, h := range headers {
+		clones[i] = CloneHeader(h)
+	}
+	return clones
+}
+
+func (h CommonHeaders) clone() CommonHeaders {
+	if h.To != nil {
+		h.To = CloneHeader(h.To)
+	}
+	if h.From != nil {
+		h.From = CloneHeader(h.From)
+	}
+	h.Contacts = cloneHeaders(h.Contacts)
+	h.AlertInfo = cloneHeaders(h.AlertInfo)
+	h.CallInfo = cloneHeaders(h.CallInfo)
+	h.ErrorInfo = cloneHeaders(h.ErrorInfo)
+	h.InReplyTo = append([]string(nil), h.InReplyTo...)
+	h.Extensions.fields = append([]HeaderField(nil), h.Extensions.fields...)
+	return h
+}
+
+func (c CallControlHeaders) clone() CallControlHeaders {
+	c.Via = append([][2]string(nil), c.Via...)
+	c.ViaParams = append([]string(nil), c.ViaParams...)
+	return c
+}
+
+// Clone returns a deep copy of m, which may be modified without affecting
+// m, and from another goroutine than the one using m. Messages implemented
+// outside this package can't be copied, and Clone returns them as-is
+func Clone(m Message) Message {
+	switch m := m.(type) {
+	case *Invite:
+		clone := *m
+		clone.headers, clone.control = m.headers.clone(), m.control.clone()
+		clone.payload = append([]byte(nil), m.payload...)
+		return &clone
+	case *Register:
+		clone := *m
+		clone.headers, clone.control = m.headers.clone(), m.control.clone()
+		clone.payload = append([]byte(nil), m.payload...)
+		return &clone
+	case *Request:
+		clone := *m
+		clone.headers, clone.control = m.headers.clone(), m.control.clone()
+		clone.payload = append([]byte(nil), m.payload...)
+		return &clone
+	case *Response:
+		clone := *m
+		clone.headers, clone.control = m.headers.clone(), m.control.clone()
+		clone.payload = append([]byte(nil), m.payload...)
+		return &clone
+	}
+	return m
+}
@@ -0,0 +1,58 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	assert.Nil(t, err)
+	var invite Invite
+	assert.Nil(t, invite.Parse(string(data)))
+	clone := Clone(&invite)
+	assert.Empty(t, Compare(&invite, clone))
+
+	clone.Headers().To.SetParam("tag", "changed")
+	clone.Headers().Contacts[0].SetUri("sip:changed@192.0.2.1")
+	clone.Headers().Extensions.Set("X-Changed", "1")
+	clone.Control().Via[0][1] = "changed"
+	assert.NotEqual(t, "changed", invite.Headers().To.Param("tag"))
+	assert.NotEqual(t, "sip:changed@192.0.2.1", invite.Headers().Contacts[0].Uri())
+	assert.Equal(t, "", invite.Headers().Extensions.Get("X-Changed"))
+	assert.NotEqual(t, "changed", invite.Control().Via[0][1])
+
+	// an Address, e.g. in Call-Info, gets parameters of its own
+	address := NewAddress("Carol", "sip:carol@chicago.com")
+	address.SetParam("purpose", "info")
+	cloned := CloneHeader(address)
+	cloned.SetParam("purpose", "icon")
+	assert.Equal(t, "info", address.Param("purpose"))
+	assert.Equal(t, "icon", cloned.Param("purpose"))
+}
+
+// Run with -race: clones may be mutated concurrently with the original
+func TestCloneConcurrent(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	assert.Nil(t, err)
+	var invite Invite
+	assert.Nil(t, invite.Parse(string(data)))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		clone := Clone(&invite)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone.Headers().From.SetParam("tag", generateTag())
+			clone.Headers().Contacts[0].SetParam("expires", "60")
+			clone.Control().Sequence++
+			clone.Render()
+		}()
+	}
+	invite.Headers().From.SetParam("tag", "original")
+	invite.Render()
+	wg.Wait()
+}
@@ -0,0 +1,209 @@
+/*
+Echo is a SIP answering server: it answers every call and sends the
+caller's audio back to them, answers OPTIONS, and acknowledges MESSAGEs
+by sending their text back. It shows the UAS side of slurp, and makes a
+peer for smoke tests of SIP endpoints.
+
+	echo -listen 0.0.0.0:5060 -media 192.0.2.10
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/rtp"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5060", "address to listen for SIP on, over UDP")
+	media := flag.String("media", "127.0.0.1", "IP address to receive audio on")
+	minPort := flag.Int("rtp-min", 20000, "lowest RTP port")
+	maxPort := flag.Int("rtp-max", 20999, "highest RTP port")
+	flag.Parse()
+
+	transport, err := slurp.ListenUDP(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ua := slurp.NewUserAgent(transport)
+	// retransmitted requests get the response already sent, rather than
+	// answering a call twice
+	cache := slurp.NewResponseCache(1024)
+	ua.Use(cache.Interceptor())
+	ua.AddFilter(cache.Filter())
+	host, port, _ := net.SplitHostPort(transport.LocalAddr().String())
+	if net.ParseIP(host).IsUnspecified() {
+		host = *media
+	}
+	contactPort, _ := strconv.Atoi(port)
+	ua.SetProfile(&slurp.Profile{DisplayName: "Echo", AOR: "sip:echo@" + host, ContactHost: host, ContactPort: contactPort})
+	log.Printf("echo listening on %s", transport.LocalAddr())
+
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		ua.Shutdown(ctx)
+	}()
+
+	server := &echo{ua: ua, media: *media, minPort: *minPort, maxPort: *maxPort}
+	server.serve()
+}
+
+type echo struct {
+	ua               *slurp.UserAgent
+	media            string
+	minPort, maxPort int
+}
+
+// serve handles the requests the UserAgent receives, until it is shut down
+func (e *echo) serve() {
+	for in := range e.ua.Receive() {
+		if _, ok := in.Message.(*slurp.Response); ok {
+			continue
+		}
+		go e.handle(in)
+	}
+}
+
+func (e *echo) respond(in slurp.Incoming, response *slurp.Response) {
+	if err := e.ua.Send(context.Background(), in.Source.String(), response); err != nil {
+		log.Printf("sending %d: %v", response.StatusCode(), err)
+	}
+}
+
+func (e *echo) handle(in slurp.Incoming) {
+	request := in.Message
+	if call := e.ua.Dialogs().Match(request); call != nil {
+		if response := call.Handle(request); response != nil {
+			e.respond(in, response)
+		}
+		return
+	}
+	switch request.Method() {
+	case "INVITE":
+		e.answer(in)
+	case "ACK":
+	case "OPTIONS":
+		response := slurp.NewResponse(request, 200)
+		response.Headers().Extensions.Set("Allow", "INVITE, ACK, BYE, CANCEL, OPTIONS, MESSAGE")
+		e.respond(in, response)
+	case "MESSAGE":
+		e.respond(in, slurp.NewResponse(request, 200))
+		e.reply(in)
+	default:
+		e.respond(in, slurp.NewResponse(request, 501))
+	}
+}
+
+// answer accepts a call, echoing its audio until it ends
+func (e *echo) answer(in slurp.Incoming) {
+	invite := in.Message
+	offer, err := sdp.Parse(invite.StringPayload())
+	if err != nil || len(offer.Media) == 0 {
+		e.respond(in, slurp.NewResponse(invite, 488))
+		return
+	}
+	session, err := rtp.Listen(e.media, e.minPort, e.maxPort, 0, 8000)
+	if err != nil {
+		e.respond(in, slurp.NewResponse(invite, 503))
+		return
+	}
+	audio := sdp.DefaultRegistry.Answer(offer.Media[0], session.LocalPort())
+	if audio.Port == 0 || session.UseFormats(sdp.DefaultRegistry.Negotiate(offer.Media[0])) != nil {
+		session.Close()
+		e.respond(in, slurp.NewResponse(invite, 488))
+		return
+	}
+	session.Describe(audio)
+	answer := &sdp.Session{
+		Origin:     sdp.Origin{Username: "echo", SessionId: strconv.FormatInt(time.Now().Unix(), 10), SessionVersion: "1", NetType: "IN", AddrType: "IP4", Address: e.media},
+		Name:       "echo",
+		Connection: &sdp.Connection{NetType: "IN", AddrType: "IP4", Address: e.media},
+		Timing:     "0 0",
+		Media:      []*sdp.Media{audio},
+	}
+	response := slurp.NewResponse(invite, 200)
+	response.Headers().ContentType = "application/sdp"
+	response.SetPayload([]byte(answer.Render()))
+	call, err := e.ua.Answer(context.Background(), in.Source.String(), invite, response)
+	if err != nil {
+		session.Close()
+		log.Printf("answering %s: %v", invite.Headers().From.Uri(), err)
+		return
+	}
+	log.Printf("answered %s", invite.Headers().From.Uri())
+	if remote := remoteMedia(offer); remote != nil {
+		session.SetRemote(remote)
+	}
+	go e.echo(call, session)
+}
+
+// echo sends every packet received back, until the call ends
+func (e *echo) echo(call *slurp.Call, session *rtp.Session) {
+	defer session.Close()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var previous uint32
+	for {
+		select {
+		case packet, ok := <-session.Receive():
+			if !ok {
+				return
+			}
+			samples := packet.Timestamp - previous
+			if previous == 0 || samples > session.ClockRate {
+				samples = uint32(len(packet.Payload))
+			}
+			previous = packet.Timestamp
+			session.Send(packet.Payload, samples, packet.Marker)
+		case <-ticker.C:
+			if call.Dialog.Ended() {
+				log.Printf("call %s ended", call.Dialog.CallId)
+				return
+			}
+		}
+	}
+}
+
+// remoteMedia returns where the caller receives audio
+func remoteMedia(offer *sdp.Session) *net.UDPAddr {
+	media := offer.Media[0]
+	connection := media.Connection
+	if connection == nil {
+		connection = offer.Connection
+	}
+	if connection == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: net.ParseIP(connection.Address), Port: media.Port}
+}
+
+// reply sends the text of a MESSAGE back to its sender
+func (e *echo) reply(in slurp.Incoming) {
+	from := in.Message.Headers().From
+	request, err := slurp.NewRequestBuilder("MESSAGE").
+		To(from.Uri()).
+		From(e.ua.Profile()).
+		WithBody(in.Message.Headers().ContentType, in.Message.Payload()).
+		Build()
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 32*time.Second)
+	defer cancel()
+	if _, err := e.ua.Request(ctx, in.Source.String(), request); err != nil {
+		log.Printf("echoing MESSAGE to %s: %v", from.Uri(), err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/testkit"
+	"github.com/stretchr/testify/assert"
+)
+
+const invite = `INVITE sip:echo@{{.Remote}} SIP/2.0
+Via: SIP/2.0/UDP {{.Local}};branch={{.Branch}}
+Max-Forwards: 70
+From: <sip:alice@{{.Local}}>;tag={{.FromTag}}
+To: <sip:echo@{{.Remote}}>
+Call-ID: {{.CallID}}
+CSeq: {{.CSeq}} INVITE
+Contact: <sip:alice@{{.Local}}>
+Content-Type: application/sdp
+Content-Length: 0
+
+v=0
+o=alice 1 1 IN IP4 127.0.0.1
+s=-
+c=IN IP4 127.0.0.1
+t=0 0
+m=audio 49170 RTP/AVP 0
+`
+
+const request = `{{.Vars.method}} sip:echo@{{.Remote}} SIP/2.0
+Via: SIP/2.0/UDP {{.Local}};branch={{.Branch}}
+Max-Forwards: 70
+From: <sip:alice@{{.Local}}>;tag={{.FromTag}}
+To: <sip:echo@{{.Remote}}>{{if .ToTag}};tag={{.ToTag}}{{end}}
+Call-ID: {{.CallID}}
+CSeq: {{.CSeq}} {{.Vars.method}}
+Content-Length: 0
+`
+
+func method(name string) testkit.Do {
+	return func(ctx context.Context, s *testkit.Session) error {
+		s.Vars = map[string]string{"method": name}
+		return nil
+	}
+}
+
+func TestEcho(t *testing.T) {
+	a, err := slurp.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := slurp.ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+	server := &echo{ua: slurp.NewUserAgent(b), media: "127.0.0.1", minPort: 20000, maxPort: 20999}
+	go server.serve()
+
+	scenario := &testkit.Scenario{Name: "echo", Steps: []testkit.Step{
+		method("OPTIONS"),
+		testkit.Send{Template: request},
+		testkit.Expect{Status: 200, Method: "OPTIONS", Checks: []testkit.Check{testkit.HeaderContains("Allow", "INVITE")}},
+		testkit.Send{Template: invite},
+		testkit.Expect{Status: 200, Method: "INVITE", Checks: []testkit.Check{testkit.BodyContains("m=audio")}},
+		method("ACK"),
+		testkit.Send{Template: request},
+		method("BYE"),
+		testkit.Send{Template: request},
+		testkit.Expect{Status: 200, Method: "BYE"},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = scenario.Run(ctx, a, b.LocalAddr().String())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,224 @@
+/*
+Slurplint parses a raw SIP message with slurp, prints its fields as the
+library understands them and reports what doesn't conform to the RFC.
+It helps triaging captures: copy a message out of a trace into a file,
+or pipe it in.
+
+	slurplint invite.sip
+	pbx-capture | slurplint -json
+
+Lines may end with LF only, as they often do once copied out of a trace,
+in which case every line, body included, is taken to end with CRLF.
+The exit status is 1 when the message has violations, and 2 when it
+can't be parsed at all.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/qmuloadmin/slurp"
+)
+
+// Report is what slurplint finds in a message
+type Report struct {
+	StartLine  string              `json:"start_line"`
+	Method     string              `json:"method,omitempty"`
+	Uri        string              `json:"uri,omitempty"`
+	StatusCode int                 `json:"status_code,omitempty"`
+	Reason     string              `json:"reason,omitempty"`
+	From       *Address            `json:"from,omitempty"`
+	To         *Address            `json:"to,omitempty"`
+	CallId     string              `json:"call_id"`
+	CSeq       string              `json:"cseq"`
+	Via        []string            `json:"via"`
+	Branch     string              `json:"branch"`
+	Contacts   []Address           `json:"contacts,omitempty"`
+	Headers    []slurp.HeaderField `json:"headers,omitempty"`
+	BodyType   string              `json:"body_type,omitempty"`
+	BodyLength int                 `json:"body_length"`
+	Violations []string            `json:"violations"`
+}
+
+// Address is a From, To or Contact
+type Address struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Uri         string            `json:"uri"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+func address(h slurp.AddressHeader) *Address {
+	if h == nil {
+		return nil
+	}
+	a := &Address{DisplayName: slurp.DisplayName(h), Uri: h.Uri()}
+	if a.Uri == "" {
+		a.Uri = h.Value()
+		a.DisplayName = ""
+	}
+	for _, param := range strings.Split(h.ParamString(), ";") {
+		if name, value, _ := strings.Cut(strings.TrimSpace(param), "="); name != "" && value != "" {
+			if a.Params == nil {
+				a.Params = make(map[string]string)
+			}
+			a.Params[name] = value
+		}
+	}
+	return a
+}
+
+// normalize ends every line with CRLF when the message has LFs only, as
+// it does once copied out of a trace, body included
+func normalize(raw string) string {
+	raw = strings.TrimLeft(raw, "\r\n")
+	if strings.Contains(raw, "\r") {
+		return raw
+	}
+	return strings.ReplaceAll(raw, "\n", "\r\n")
+}
+
+// lint parses a raw message and reports on it
+func lint(raw string) (*Report, error) {
+	text := normalize(raw)
+	m, err := slurp.ParseMessage(text)
+	if m == nil {
+		return nil, err
+	}
+	report := &Report{
+		StartLine:  strings.TrimSpace(strings.SplitN(text, "\n", 2)[0]),
+		From:       address(m.Headers().From),
+		To:         address(m.Headers().To),
+		CallId:     m.Control().CallId,
+		CSeq:       strconv.Itoa(m.Control().Sequence) + " " + m.Control().CSeqMethod,
+		Branch:     m.Control().ViaBranch,
+		Headers:    m.Headers().Extensions.Fields(),
+		BodyType:   m.Headers().ContentType,
+		BodyLength: len(m.Payload()),
+		Violations: []string{},
+	}
+	if response, ok := m.(*slurp.Response); ok {
+		report.StatusCode, report.Reason = response.StatusCode(), response.Reason()
+	} else {
+		report.Method, report.Uri = m.Method(), m.Uri()
+	}
+	for _, via := range m.Control().Via {
+		report.Via = append(report.Via, via[0]+" "+via[1])
+	}
+	for _, contact := range m.Headers().Contacts {
+		report.Contacts = append(report.Contacts, *address(contact))
+	}
+	if err != nil {
+		// parsed, but e.g. with a URI scheme we don't support
+		report.Violations = append(report.Violations, err.Error())
+	}
+	for _, violation := range m.Validate() {
+		report.Violations = append(report.Violations, violation.Error())
+	}
+	return report, nil
+}
+
+// printReport writes the report as a table of fields followed by violations
+func printReport(w io.Writer, r *Report) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(table, "%s\t%s\n", name, value)
+		}
+	}
+	addr := func(name string, a *Address) {
+		if a == nil {
+			return
+		}
+		value := a.Uri
+		if a.DisplayName != "" {
+			value = strconv.Quote(a.DisplayName) + " " + value
+		}
+		params := make([]string, 0, len(a.Params))
+		for param, v := range a.Params {
+			params = append(params, param+"="+v)
+		}
+		sort.Strings(params)
+		if len(params) > 0 {
+			value += " " + strings.Join(params, " ")
+		}
+		field(name, value)
+	}
+	field("Start line", r.StartLine)
+	field("Method", r.Method)
+	field("Request-URI", r.Uri)
+	if r.StatusCode != 0 {
+		field("Status", strconv.Itoa(r.StatusCode)+" "+r.Reason)
+	}
+	addr("From", r.From)
+	addr("To", r.To)
+	field("Call-ID", r.CallId)
+	field("CSeq", r.CSeq)
+	for _, via := range r.Via {
+		field("Via", via)
+	}
+	field("Branch", r.Branch)
+	for i := range r.Contacts {
+		addr("Contact", &r.Contacts[i])
+	}
+	for _, header := range r.Headers {
+		field(header.Name, header.Value)
+	}
+	field("Body", r.BodyType)
+	field("Body length", strconv.Itoa(r.BodyLength))
+	table.Flush()
+	if len(r.Violations) == 0 {
+		fmt.Fprintln(w, "\nno violations")
+		return
+	}
+	fmt.Fprintf(w, "\n%d violations:\n", len(r.Violations))
+	for _, violation := range r.Violations {
+		fmt.Fprintln(w, "  "+violation)
+	}
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: slurplint [-json] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	input := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer file.Close()
+		input = file
+	}
+	raw, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	report, err := lint(string(raw))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't parse the message: %v\n", err)
+		os.Exit(2)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(os.Stdout, report)
+	}
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
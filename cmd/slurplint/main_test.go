@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	data, err := os.ReadFile("../../examples/invite.sip")
+	if err != nil {
+		t.Skip("example missing")
+	}
+	// copied out of a trace, without the CRs
+	report, err := lint(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "INVITE", report.Method)
+	assert.Equal(t, "sip:bob@biloxi.com", report.Uri)
+	assert.Equal(t, "1928301774", report.From.Params["tag"])
+	assert.Equal(t, "314159 INVITE", report.CSeq)
+	assert.Empty(t, report.Violations)
+	var out bytes.Buffer
+	printReport(&out, report)
+	assert.Contains(t, out.String(), "Call-ID")
+	assert.Contains(t, out.String(), "no violations")
+
+	report, err = lint("OPTIONS sip:bob@biloxi.com SIP/2.0\nVia: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\nCall-ID: a84b4c76e66710\n\n")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, report.Violations)
+
+	_, err = lint("not SIP")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,300 @@
+/*
+Softphone is a minimal command line SIP phone: it registers with a
+registrar, places and answers calls with a static SDP, and hangs them
+up. It carries no audio; it shows the UAC and UAS sides of slurp and
+makes a quick peer for smoke tests. Commands are read from stdin:
+
+	call <uri>    place a call, e.g. call sip:bob@biloxi.com
+	answer        answer the ringing call
+	reject        reject the ringing call with 486
+	hangup        end the call in progress
+	quit          unregister and exit
+
+For example:
+
+	softphone -aor sip:alice@atlanta.com -password secret -registrar 192.0.2.10:5060
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5062", "address to listen for SIP on, over UDP")
+	aor := flag.String("aor", "", "address-of-record, e.g. sip:alice@atlanta.com")
+	display := flag.String("display", "", "display name")
+	username := flag.String("username", "", "username to authenticate with, the AOR's user by default")
+	password := flag.String("password", "", "password to authenticate with")
+	registrar := flag.String("registrar", "", "host:port of the registrar, none to skip registering")
+	proxy := flag.String("proxy", "", "host:port calls are sent to, the registrar by default")
+	media := flag.String("media", "", "IP address advertised in SDP and Contact, the listen address by default")
+	flag.Parse()
+	if *aor == "" {
+		log.Fatal("-aor is required")
+	}
+	if *proxy == "" {
+		*proxy = *registrar
+	}
+
+	transport, err := slurp.ListenUDP(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ua := slurp.NewUserAgent(transport)
+	host, port, _ := net.SplitHostPort(transport.LocalAddr().String())
+	if *media != "" {
+		host = *media
+	}
+	contactPort, _ := strconv.Atoi(port)
+	profile := &slurp.Profile{
+		DisplayName: *display,
+		AOR:         *aor,
+		ContactHost: host,
+		ContactPort: contactPort,
+		Username:    *username,
+		Password:    *password,
+		UserAgent:   "slurp softphone",
+	}
+	ua.SetProfile(profile)
+	phone := &softphone{ua: ua, profile: profile, proxy: *proxy, media: host}
+
+	if *registrar != "" {
+		if err := phone.register(*registrar, time.Hour); err != nil {
+			log.Fatalf("registering: %v", err)
+		}
+		fmt.Printf("registered %s with %s\n", *aor, *registrar)
+	}
+	go phone.receive()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "call":
+			if len(fields) < 2 {
+				fmt.Println("usage: call <uri>")
+				continue
+			}
+			phone.call(fields[1])
+		case "answer":
+			phone.answer()
+		case "reject":
+			phone.reject()
+		case "hangup":
+			phone.hangup()
+		case "quit":
+			phone.hangup()
+			if *registrar != "" {
+				phone.register(*registrar, 0)
+			}
+			return
+		default:
+			fmt.Println("commands: call <uri>, answer, reject, hangup, quit")
+		}
+	}
+}
+
+type softphone struct {
+	ua      *slurp.UserAgent
+	profile *slurp.Profile
+	proxy   string
+	media   string
+	mu      sync.Mutex
+	// ringing is the INVITE of the call waiting to be answered, and
+	// current the call in progress
+	ringing *slurp.Incoming
+	current *slurp.Call
+}
+
+// request sends a request, answering an authentication challenge once
+func (p *softphone) request(ctx context.Context, addr string, request slurp.Message) (*slurp.Response, error) {
+	response, err := p.ua.Request(ctx, addr, request)
+	if err == nil && (response.StatusCode() == 401 || response.StatusCode() == 407) {
+		if err := p.profile.Authorize(request, response); err != nil {
+			return nil, err
+		}
+		response, err = p.ua.Request(ctx, addr, request)
+	}
+	return response, err
+}
+
+// register binds our Contact to the AOR for expires, or removes the
+// binding when it is zero
+func (p *softphone) register(registrar string, expires time.Duration) error {
+	request, err := slurp.NewRegister().
+		To(p.profile.AOR).
+		From(p.profile).
+		Header("Expires", strconv.Itoa(int(expires.Seconds()))).
+		Build()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 32*time.Second)
+	defer cancel()
+	response, err := p.request(ctx, registrar, request)
+	if err != nil {
+		return err
+	}
+	if !slurp.IsSuccess(response.StatusCode()) {
+		return fmt.Errorf("%d %s", response.StatusCode(), response.Reason())
+	}
+	return nil
+}
+
+// offer is the static session description of our calls
+func (p *softphone) offer() *sdp.Session {
+	audio := &sdp.Media{Type: "audio", Port: 49170, Protocol: "RTP/AVP"}
+	sdp.NewRegistry(sdp.PCMU, sdp.PCMA, sdp.TelephoneEvent).Offer(audio)
+	return &sdp.Session{
+		Origin:     sdp.Origin{Username: "-", SessionId: strconv.FormatInt(time.Now().Unix(), 10), SessionVersion: "1", NetType: "IN", AddrType: "IP4", Address: p.media},
+		Name:       "slurp softphone",
+		Connection: &sdp.Connection{NetType: "IN", AddrType: "IP4", Address: p.media},
+		Timing:     "0 0",
+		Media:      []*sdp.Media{audio},
+	}
+}
+
+func (p *softphone) call(uri string) {
+	if p.proxy == "" {
+		fmt.Println("no -proxy or -registrar to send calls to")
+		return
+	}
+	m, err := slurp.NewInvite().To(uri).From(p.profile).WithSDP(p.offer()).Build()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("calling %s\n", uri)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	call, err := p.ua.Dial(ctx, p.proxy, m.(*slurp.Invite), func(dialog *slurp.Dialog, response *slurp.Response) {
+		fmt.Printf("%d %s\n", response.StatusCode(), response.Reason())
+	})
+	if err != nil {
+		fmt.Printf("call failed: %v\n", err)
+		return
+	}
+	fmt.Println("call established, type hangup to end it")
+	p.mu.Lock()
+	p.current = call
+	p.mu.Unlock()
+}
+
+func (p *softphone) answer() {
+	p.mu.Lock()
+	in := p.ringing
+	p.ringing = nil
+	p.mu.Unlock()
+	if in == nil {
+		fmt.Println("no call is ringing")
+		return
+	}
+	response := slurp.NewResponse(in.Message, 200)
+	response.Headers().ContentType = "application/sdp"
+	response.SetPayload([]byte(p.offer().Render()))
+	call, err := p.ua.Answer(context.Background(), in.Source.String(), in.Message, response)
+	if err != nil {
+		fmt.Printf("answering failed: %v\n", err)
+		return
+	}
+	fmt.Println("call answered, type hangup to end it")
+	p.mu.Lock()
+	p.current = call
+	p.mu.Unlock()
+}
+
+func (p *softphone) reject() {
+	p.mu.Lock()
+	in := p.ringing
+	p.ringing = nil
+	p.mu.Unlock()
+	if in != nil {
+		p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(in.Message, 486))
+	}
+}
+
+func (p *softphone) hangup() {
+	p.mu.Lock()
+	call := p.current
+	p.current = nil
+	p.mu.Unlock()
+	if call == nil || call.Dialog.Ended() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 32*time.Second)
+	defer cancel()
+	if err := call.Hangup(ctx); err != nil {
+		fmt.Printf("hanging up: %v\n", err)
+		return
+	}
+	fmt.Println("call ended")
+}
+
+// receive handles the requests the UserAgent receives
+func (p *softphone) receive() {
+	for in := range p.ua.Receive() {
+		request := in.Message
+		if _, ok := request.(*slurp.Response); ok {
+			continue
+		}
+		if call := p.ua.Dialogs().Match(request); call != nil {
+			if response := call.Handle(request); response != nil {
+				p.ua.Send(context.Background(), in.Source.String(), response)
+			}
+			if request.Method() == "BYE" {
+				fmt.Println("\nthe other party hung up")
+			}
+			continue
+		}
+		switch request.Method() {
+		case "INVITE":
+			p.mu.Lock()
+			busy := p.ringing != nil || p.current != nil && !p.current.Dialog.Ended()
+			if !busy {
+				incoming := in
+				p.ringing = &incoming
+			}
+			p.mu.Unlock()
+			if busy {
+				p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(request, 486))
+				continue
+			}
+			p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(request, 180))
+			fmt.Printf("\nincoming call from %s, answer or reject?\n", request.Headers().From.Uri())
+		case "CANCEL":
+			p.mu.Lock()
+			ringing := p.ringing
+			if ringing != nil && ringing.Message.Control().CallId == request.Control().CallId {
+				p.ringing = nil
+			}
+			p.mu.Unlock()
+			p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(request, 200))
+			if ringing != nil {
+				p.ua.Send(context.Background(), ringing.Source.String(), slurp.NewResponse(ringing.Message, 487))
+				fmt.Println("\nthe caller gave up")
+			}
+		case "ACK":
+		case "OPTIONS":
+			p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(request, 200))
+		default:
+			p.ua.Send(context.Background(), in.Source.String(), slurp.NewResponse(request, 501))
+		}
+	}
+}
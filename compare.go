@@ -0,0 +1,164 @@
+package slurp
+
+/*
+Comparing two messages field by field tells what differs between them:
+which headers one has and the other lacks, which values changed and
+where the bodies part. Test assertions and interop debugging read these
+differences rather than two rendered messages side by side.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DifferenceKind is what differs between two messages
+type DifferenceKind int
+
+const (
+	// StartLineChanged is a different request or status line
+	StartLineChanged DifferenceKind = iota
+	// HeaderMissing is a header of the first message the second lacks
+	HeaderMissing
+	// HeaderAdded is a header of the second message the first lacks
+	HeaderAdded
+	// HeaderChanged is a header both messages have with other values
+	HeaderChanged
+	// BodyChanged is a different body
+	BodyChanged
+)
+
+func (k DifferenceKind) String() string {
+	switch k {
+	case StartLineChanged:
+		return "start line changed"
+	case HeaderMissing:
+		return "header missing"
+	case HeaderAdded:
+		return "header added"
+	case HeaderChanged:
+		return "header changed"
+	case BodyChanged:
+		return "body changed"
+	}
+	return "unknown"
+}
+
+// Difference is one difference between two messages
+type Difference struct {
+	Kind DifferenceKind
+	// Header is the name of the header that differs, as rendered
+	Header string
+	// A and B are the values in each message, the values of a header
+	// joined with commas when it is repeated, or the whole bodies
+	A, B string
+	// Offset is where the bodies start to differ, for BodyChanged
+	Offset int
+}
+
+func (d Difference) String() string {
+	switch d.Kind {
+	case StartLineChanged:
+		return fmt.Sprintf("start line: %q != %q", d.A, d.B)
+	case HeaderMissing:
+		return fmt.Sprintf("%s: missing, was %q", d.Header, d.A)
+	case HeaderAdded:
+		return fmt.Sprintf("%s: added %q", d.Header, d.B)
+	case HeaderChanged:
+		return fmt.Sprintf("%s: %q != %q", d.Header, d.A, d.B)
+	case BodyChanged:
+		return fmt.Sprintf("body: differs at byte %d of %d and %d", d.Offset, len(d.A), len(d.B))
+	}
+	return d.Kind.String()
+}
+
+// Differences are the differences between two messages, in the order the
+// headers of the first message are rendered in
+type Differences []Difference
+
+func (d Differences) String() string {
+	lines := make([]string, len(d))
+	for i, each := range d {
+		lines[i] = each.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Compare returns the differences between messages a and b, which are
+// none when they are equivalent. Headers are compared by name regardless
+// of their spelling or form, and the order of their parameters doesn't
+// count. The headers named in ignore, e.g. Via or Call-ID for messages
+// from different transactions, aren't compared
+func Compare(a, b Message, ignore ...string) Differences {
+	skip := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		skip[canonicalName(name)] = true
+	}
+	startA, orderA, headersA := groupRendered(a.Render())
+	startB, orderB, headersB := groupRendered(b.Render())
+	var differences Differences
+	if startA != startB {
+		differences = append(differences, Difference{Kind: StartLineChanged, A: startA, B: startB})
+	}
+	for _, name := range append(orderA, orderB...) {
+		if skip[name] {
+			continue
+		}
+		// each name is compared once, when first seen
+		skip[name] = true
+		valuesA, inA := headerValues(headersA[name])
+		valuesB, inB := headerValues(headersB[name])
+		difference := Difference{Header: HeaderName(name), A: valuesA, B: valuesB}
+		switch {
+		case !inB:
+			difference.Kind = HeaderMissing
+		case !inA:
+			difference.Kind = HeaderAdded
+		case normalizeValues(headersA[name]) != normalizeValues(headersB[name]):
+			difference.Kind = HeaderChanged
+		default:
+			continue
+		}
+		differences = append(differences, difference)
+	}
+	bodyA, bodyB := a.StringPayload(), b.StringPayload()
+	if bodyA != bodyB {
+		offset := 0
+		for offset < len(bodyA) && offset < len(bodyB) && bodyA[offset] == bodyB[offset] {
+			offset++
+		}
+		differences = append(differences, Difference{Kind: BodyChanged, A: bodyA, B: bodyB, Offset: offset})
+	}
+	return differences
+}
+
+// headerValues returns the values of rendered header lines joined with
+// commas, and whether there were any
+func headerValues(lines []string) (string, bool) {
+	values := make([]string, len(lines))
+	for i, line := range lines {
+		values[i] = headerLineValue(line)
+	}
+	return strings.Join(values, ", "), len(lines) > 0
+}
+
+func headerLineValue(line string) string {
+	_, value, _ := strings.Cut(line, ":")
+	return strings.TrimSpace(value)
+}
+
+// normalizeValues returns the values of rendered header lines with their
+// parameters sorted, and spaces around the semicolons removed
+func normalizeValues(lines []string) string {
+	values := make([]string, len(lines))
+	for i, line := range lines {
+		parts := splitUnquoted(headerLineValue(line), ';')
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+		}
+		sort.Strings(parts[1:])
+		values[i] = strings.Join(parts, ";")
+	}
+	return strings.Join(values, "\n")
+}
@@ -0,0 +1,47 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	if err != nil {
+		t.Skip("example missing")
+	}
+	invite := &Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+	assert.Empty(t, Compare(invite, Clone(invite)))
+
+	other := Clone(invite)
+	other.Headers().To.SetParam("tag", "314")
+	other.Headers().Extensions.Set("X-Added", "1")
+	other.Headers().Contacts = nil
+	other.SetPayload([]byte(invite.StringPayload() + "a=sendonly\r\n"))
+	differences := Compare(invite, other, "Content-Length")
+	kinds := map[string]DifferenceKind{}
+	for _, difference := range differences {
+		kinds[difference.Header] = difference.Kind
+	}
+	assert.Equal(t, HeaderChanged, kinds["To"])
+	assert.Equal(t, HeaderAdded, kinds["X-Added"])
+	assert.Equal(t, HeaderChanged, kinds["Contact"])
+	if assert.NotEmpty(t, differences) {
+		body := differences[len(differences)-1]
+		assert.Equal(t, BodyChanged, body.Kind)
+		assert.Equal(t, len(invite.StringPayload()), body.Offset)
+	}
+	assert.Contains(t, differences.String(), "To: \"Bob <sip:bob@biloxi.com>\" != \"Bob <sip:bob@biloxi.com>;tag=314\"")
+
+	// the order of parameters doesn't count
+	contact := NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4").SetParam("expires", "60").SetParam("q", "0.5")
+	invite.Headers().Contacts = []AddressHeader{contact}
+	other = Clone(invite)
+	other.Headers().Contacts = []AddressHeader{NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4").SetParam("q", "0.5").SetParam("expires", "60")}
+	assert.Empty(t, Compare(invite, other))
+	invite.Headers().Extensions.Set("X-Removed", "1")
+	assert.Equal(t, Differences{{Kind: HeaderMissing, Header: "X-Removed", A: "1"}}, Compare(invite, other))
+}
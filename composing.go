@@ -0,0 +1,249 @@
+package slurp
+
+/*
+Instant messaging clients show that the other party is typing with
+isComposing indications (RFC 3994), sent in the body of a MESSAGE, or a
+PUBLISH, as the user starts and stops composing a message. An active
+indication is refreshed while typing goes on, and lapses into idle when it
+isn't, so a lost idle indication doesn't leave the peer waiting.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// ComposingContentType is the content type of isComposing bodies
+const ComposingContentType = "application/im-iscomposing+xml"
+
+// The states of an isComposing indication
+const (
+	ComposingActive = "active"
+	ComposingIdle   = "idle"
+)
+
+const composingNamespace = "urn:ietf:params:xml:ns:im-iscomposing"
+
+// IsComposing is an isComposing indication
+type IsComposing struct {
+	// State is ComposingActive or ComposingIdle
+	State string
+	// LastActive is when the user was last typing, rendered unless zero
+	LastActive time.Time
+	// ContentType is the type of the message being composed, e.g. text/plain
+	ContentType string
+	// Refresh is how long an active indication lasts unless refreshed,
+	// which the receiver takes to be 120 seconds when zero
+	Refresh time.Duration
+}
+
+// Render returns the indication as an isComposing document
+func (c *IsComposing) Render() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<isComposing xmlns=%q>\n <state>%s</state>\n", composingNamespace, xmlEscape(c.State))
+	if !c.LastActive.IsZero() {
+		fmt.Fprintf(&b, " <lastactive>%s</lastactive>\n", c.LastActive.UTC().Format(time.RFC3339))
+	}
+	if c.ContentType != "" {
+		fmt.Fprintf(&b, " <contenttype>%s</contenttype>\n", xmlEscape(c.ContentType))
+	}
+	if c.State == ComposingActive && c.Refresh > 0 {
+		fmt.Fprintf(&b, " <refresh>%d</refresh>\n", int(c.Refresh/time.Second))
+	}
+	b.WriteString("</isComposing>\n")
+	return b.Bytes()
+}
+
+// ParseIsComposing reads an isComposing document
+func ParseIsComposing(body []byte) (*IsComposing, error) {
+	var doc struct {
+		XMLName     xml.Name `xml:"isComposing"`
+		State       string   `xml:"state"`
+		LastActive  string   `xml:"lastactive"`
+		ContentType string   `xml:"contenttype"`
+		Refresh     string   `xml:"refresh"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	c := &IsComposing{State: strings.TrimSpace(doc.State), ContentType: strings.TrimSpace(doc.ContentType)}
+	if c.State != ComposingActive && c.State != ComposingIdle {
+		return nil, fmt.Errorf("invalid isComposing state %q", c.State)
+	}
+	if value := strings.TrimSpace(doc.LastActive); value != "" {
+		lastActive, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lastactive: %w", err)
+		}
+		c.LastActive = lastActive
+	}
+	if value := strings.TrimSpace(doc.Refresh); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid refresh %q", value)
+		}
+		c.Refresh = time.Duration(seconds) * time.Second
+	}
+	return c, nil
+}
+
+// MessageComposing returns the indication the message carries, and false
+// when it has none, e.g. because it carries the composed message
+func MessageComposing(m Message) (*IsComposing, bool) {
+	body, _, ok := BodyPart(m, ComposingContentType)
+	if !ok {
+		return nil, false
+	}
+	c, err := ParseIsComposing(body)
+	return c, err == nil
+}
+
+// ActiveUntil returns when an active indication received at received
+// lapses into idle, unless refreshed. Idle indications lapse when received
+func (c *IsComposing) ActiveUntil(received time.Time) time.Time {
+	if c.State != ComposingActive {
+		return received
+	}
+	refresh := c.Refresh
+	if refresh == 0 {
+		refresh = 120 * time.Second
+	}
+	return received.Add(refresh)
+}
+
+// Composer sends the isComposing indications of a user composing messages
+// to one peer. It is safe for concurrent use
+type Composer struct {
+	// ContentType is the type of the messages composed, text/plain when
+	// empty
+	ContentType string
+	// IdleTimeout is how long after the last keystroke the user is idle,
+	// 15 seconds when zero, and Refresh how often an active indication is
+	// sent again while typing goes on, 90 seconds when zero
+	IdleTimeout time.Duration
+	Refresh     time.Duration
+	// Clock used for the idle and refresh timers, DefaultClock when nil
+	Clock   Clock
+	ua      *UserAgent
+	addr    string
+	request func() *RequestBuilder
+	mu      sync.Mutex
+	active  bool
+	typed   time.Time
+	sent    time.Time
+	idle    Timer
+}
+
+// NewComposer creates a Composer sending its indications through ua to
+// addr, in the requests request builds, e.g.
+//
+//	NewComposer(ua, addr, func() *RequestBuilder {
+//		return NewRequestBuilder("MESSAGE").To("sip:bob@biloxi.com").From(profile)
+//	})
+func NewComposer(ua *UserAgent, addr string, request func() *RequestBuilder) *Composer {
+	return &Composer{ua: ua, addr: addr, request: request}
+}
+
+func (c *Composer) durations() (idle, refresh time.Duration) {
+	idle, refresh = c.IdleTimeout, c.Refresh
+	if idle == 0 {
+		idle = 15 * time.Second
+	}
+	if refresh == 0 {
+		refresh = 90 * time.Second
+	}
+	return
+}
+
+// Typing records a keystroke. An active indication is sent when the user
+// was idle, or when the last one is due for a refresh
+func (c *Composer) Typing(ctx context.Context) error {
+	idle, refresh := c.durations()
+	now := clockOrDefault(c.Clock).Now()
+	c.mu.Lock()
+	c.typed = now
+	if c.idle != nil {
+		c.idle.Reset(idle)
+	} else {
+		c.idle = clockOrDefault(c.Clock).AfterFunc(idle, c.timeout)
+	}
+	send := !c.active || now.Sub(c.sent) >= refresh
+	if send {
+		c.active, c.sent = true, now
+	}
+	c.mu.Unlock()
+	if !send {
+		return nil
+	}
+	return c.send(ctx, &IsComposing{State: ComposingActive, ContentType: c.contentType(), Refresh: refresh})
+}
+
+// Sent records that the composed message was sent, which makes the peer
+// consider the user idle without an indication
+func (c *Composer) Sent() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+}
+
+// Idle sends an idle indication right away, e.g. when the user cleared
+// the message, unless the user is idle already
+func (c *Composer) Idle(ctx context.Context) error {
+	c.mu.Lock()
+	active := c.active
+	typed := c.typed
+	c.stop()
+	c.mu.Unlock()
+	if !active {
+		return nil
+	}
+	return c.send(ctx, &IsComposing{State: ComposingIdle, LastActive: typed, ContentType: c.contentType()})
+}
+
+// stop makes the user idle, the lock must be held
+func (c *Composer) stop() {
+	c.active = false
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+}
+
+// timeout makes the user idle once IdleTimeout passed without typing
+func (c *Composer) timeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.ua.Timers().F)
+	defer cancel()
+	c.Idle(ctx)
+}
+
+func (c *Composer) contentType() string {
+	if c.ContentType == "" {
+		return "text/plain"
+	}
+	return c.ContentType
+}
+
+// send sends an indication, returning a failure response as a RejectedError
+func (c *Composer) send(ctx context.Context, indication *IsComposing) error {
+	request, err := c.request().WithBody(ComposingContentType, indication.Render()).Build()
+	if err != nil {
+		return err
+	}
+	response, err := c.ua.Request(ctx, c.addr, request)
+	if err != nil {
+		return err
+	}
+	if !IsSuccess(response.StatusCode()) {
+		return RejectedError{StatusCode: response.StatusCode(), Reason: response.Reason()}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsComposing(t *testing.T) {
+	c, err := ParseIsComposing([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<isComposing xmlns="urn:ietf:params:xml:ns:im-iscomposing">
+  <state>active</state>
+  <contenttype>text/html</contenttype>
+  <refresh>60</refresh>
+</isComposing>`))
+	assert.Nil(t, err)
+	assert.Equal(t, &IsComposing{State: ComposingActive, ContentType: "text/html", Refresh: time.Minute}, c)
+	assert.Equal(t, time.Unix(60, 0), c.ActiveUntil(time.Unix(0, 0)))
+
+	idle := &IsComposing{State: ComposingIdle, LastActive: time.Date(2003, 1, 27, 10, 43, 0, 0, time.UTC)}
+	parsed, err := ParseIsComposing(idle.Render())
+	assert.Nil(t, err)
+	assert.Equal(t, idle, parsed)
+
+	_, err = ParseIsComposing([]byte(`<isComposing><state>typing</state></isComposing>`))
+	assert.NotNil(t, err)
+}
+
+func TestComposer(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	sender, receiver := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	indications := make(chan *IsComposing, 8)
+	go func() {
+		for in := range receiver.Receive() {
+			if c, ok := MessageComposing(in.Message); ok {
+				indications <- c
+			}
+			receiver.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 200))
+		}
+	}()
+
+	composer := NewComposer(sender, b.LocalAddr().String(), func() *RequestBuilder {
+		return NewRequestBuilder("MESSAGE").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com")
+	})
+	clock := NewFakeClock(time.Unix(0, 0))
+	composer.Clock = clock
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.Nil(t, composer.Typing(ctx))
+	active := <-indications
+	assert.Equal(t, ComposingActive, active.State)
+	assert.Equal(t, "text/plain", active.ContentType)
+	assert.Equal(t, 90*time.Second, active.Refresh)
+
+	// keystrokes in between are not indicated, until the refresh is due
+	clock.Advance(10 * time.Second)
+	assert.Nil(t, composer.Typing(ctx))
+	assert.Len(t, indications, 0)
+
+	// the user goes idle after 15 seconds without typing
+	clock.Advance(14 * time.Second)
+	assert.Len(t, indications, 0)
+	clock.Advance(time.Second)
+	idle := <-indications
+	assert.Equal(t, ComposingIdle, idle.State)
+	assert.Equal(t, time.Unix(10, 0), idle.LastActive.Local())
+
+	// sending the message makes the user idle without an indication
+	assert.Nil(t, composer.Typing(ctx))
+	<-indications
+	composer.Sent()
+	clock.Advance(time.Minute)
+	assert.Nil(t, composer.Idle(ctx))
+	assert.Len(t, indications, 0)
+}
@@ -0,0 +1,403 @@
+package slurp
+
+/*
+A conference is hosted by a focus (RFC 4353), which holds a dialog with
+each participant and advertises itself with the isfocus Contact parameter
+(RFC 4579 3). Who is in the conference is published with the conference
+event package (RFC 4575), as conference-info documents. The Focus here
+does the signaling only: the media of its participants are mixed by
+something else, e.g. a media server, which provides the session
+descriptions the Focus answers and offers.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// The conference event package and the content type of its documents
+const (
+	ConferencePackage     = "conference"
+	ConferenceContentType = "application/conference-info+xml"
+)
+
+const conferenceNamespace = "urn:ietf:params:xml:ns:conference-info"
+
+// IsFocus reports whether the contact has the isfocus parameter, which
+// makes its URI a conference URI
+func IsFocus(contact AddressHeader) bool {
+	if c, ok := contact.(*Contact); ok {
+		_, found := c.params.Get("isfocus")
+		return found
+	}
+	return false
+}
+
+// SetFocus adds the isfocus parameter to the contact
+func SetFocus(contact AddressHeader) AddressHeader {
+	return contact.SetParam("isfocus", "")
+}
+
+// MessageFromFocus reports whether the message was sent by a focus, its
+// Contact having the isfocus parameter
+func MessageFromFocus(m Message) bool {
+	for _, contact := range m.Headers().Contacts {
+		if IsFocus(contact) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConferenceEndpoint is a device a user is in the conference with
+type ConferenceEndpoint struct {
+	Entity string
+	// Status is e.g. connected, alerting, on-hold or disconnected
+	Status string
+}
+
+// ConferenceUser is a participant of a conference
+type ConferenceUser struct {
+	// Entity is the URI of the user, e.g. their AOR
+	Entity      string
+	DisplayText string
+	// State is full, partial or deleted, when the user left
+	State     string
+	Endpoints []ConferenceEndpoint
+}
+
+// ConferenceInfo is a conference-info document
+type ConferenceInfo struct {
+	// Entity is the conference URI
+	Entity string
+	// Version increases with every document of a subscription
+	Version int
+	// State is full or partial
+	State   string
+	Subject string
+	Users   []ConferenceUser
+}
+
+// Render returns the conference information as a conference-info document
+func (c *ConferenceInfo) Render() []byte {
+	state := c.State
+	if state == "" {
+		state = "full"
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<conference-info xmlns=%q entity=%q state=%q version=\"%d\">\n",
+		conferenceNamespace, xmlEscape(c.Entity), state, c.Version)
+	if c.Subject != "" {
+		fmt.Fprintf(&b, " <conference-description>\n  <subject>%s</subject>\n </conference-description>\n", xmlEscape(c.Subject))
+	}
+	b.WriteString(" <users>\n")
+	for _, user := range c.Users {
+		userState := user.State
+		if userState == "" {
+			userState = "full"
+		}
+		fmt.Fprintf(&b, "  <user entity=%q state=%q>\n", xmlEscape(user.Entity), userState)
+		if user.DisplayText != "" {
+			fmt.Fprintf(&b, "   <display-text>%s</display-text>\n", xmlEscape(user.DisplayText))
+		}
+		for _, endpoint := range user.Endpoints {
+			fmt.Fprintf(&b, "   <endpoint entity=%q>\n", xmlEscape(endpoint.Entity))
+			if endpoint.Status != "" {
+				fmt.Fprintf(&b, "    <status>%s</status>\n", xmlEscape(endpoint.Status))
+			}
+			b.WriteString("   </endpoint>\n")
+		}
+		b.WriteString("  </user>\n")
+	}
+	b.WriteString(" </users>\n</conference-info>\n")
+	return b.Bytes()
+}
+
+// ParseConferenceInfo reads a conference-info document
+func ParseConferenceInfo(body []byte) (*ConferenceInfo, error) {
+	var doc struct {
+		XMLName xml.Name `xml:"conference-info"`
+		Entity  string   `xml:"entity,attr"`
+		State   string   `xml:"state,attr"`
+		Version string   `xml:"version,attr"`
+		Subject string   `xml:"conference-description>subject"`
+		Users   []struct {
+			Entity      string `xml:"entity,attr"`
+			State       string `xml:"state,attr"`
+			DisplayText string `xml:"display-text"`
+			Endpoints   []struct {
+				Entity string `xml:"entity,attr"`
+				Status string `xml:"status"`
+			} `xml:"endpoint"`
+		} `xml:"users>user"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	info := &ConferenceInfo{Entity: doc.Entity, State: doc.State, Subject: strings.TrimSpace(doc.Subject)}
+	if doc.Version != "" {
+		version, err := strconv.Atoi(doc.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", doc.Version)
+		}
+		info.Version = version
+	}
+	for _, u := range doc.Users {
+		user := ConferenceUser{Entity: u.Entity, State: u.State, DisplayText: strings.TrimSpace(u.DisplayText)}
+		for _, e := range u.Endpoints {
+			user.Endpoints = append(user.Endpoints, ConferenceEndpoint{Entity: e.Entity, Status: strings.TrimSpace(e.Status)})
+		}
+		info.Users = append(info.Users, user)
+	}
+	return info, nil
+}
+
+// participant is a call in a conference
+type participant struct {
+	call        *Call
+	contact     string
+	displayName string
+}
+
+// Focus hosts a conference at its Uri, which routes to its UserAgent:
+// it answers the INVITEs for it, invites others, and notifies subscribers
+// to the conference event package of who is in it. It is safe for
+// concurrent use
+type Focus struct {
+	// Uri is the conference URI, which is also the Contact of the Focus
+	Uri     string
+	Subject string
+	// Answer returns the session description answering the offer of a
+	// participant joining, e.g. from a mixer. Participants are rejected
+	// with 488 when it fails, or is nil
+	Answer func(offer *sdp.Session) (*sdp.Session, error)
+	// Offer returns the session description offered to uri when the
+	// Focus invites it
+	Offer func(uri string) *sdp.Session
+	// Notifier sends the conference-info documents to subscribers
+	Notifier     *Notifier
+	ua           *UserAgent
+	mu           sync.Mutex
+	participants []*participant
+	version      int
+}
+
+// NewFocus creates a Focus for the conference at uri on ua
+func NewFocus(ua *UserAgent, uri string) *Focus {
+	f := &Focus{Uri: uri, ua: ua}
+	f.Notifier = NewNotifier(ua, ConferencePackage, f.state)
+	f.Notifier.Authorize = func(subscribe Message) bool {
+		return f.isConference(subscribe)
+	}
+	return f
+}
+
+func (f *Focus) isConference(request Message) bool {
+	return AddressOfRecord(request.Uri()) == AddressOfRecord(f.Uri)
+}
+
+// contact returns the Contact of the Focus
+func (f *Focus) contact() AddressHeader {
+	return SetFocus(NewHeader(&Contact{}).SetUri(f.Uri))
+}
+
+// Info returns the conference as a full conference-info document
+func (f *Focus) Info() *ConferenceInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info := &ConferenceInfo{Entity: f.Uri, Version: f.version, State: "full", Subject: f.Subject, Users: []ConferenceUser{}}
+	for _, p := range f.participants {
+		info.Users = append(info.Users, ConferenceUser{
+			Entity:      p.call.Dialog.RemoteUri,
+			DisplayText: p.displayName,
+			Endpoints:   []ConferenceEndpoint{{Entity: p.contact, Status: "connected"}},
+		})
+	}
+	return info
+}
+
+// state is the conference-info document of the Notifier, with a new version
+func (f *Focus) state(resource string) EventBody {
+	f.mu.Lock()
+	f.version++
+	f.mu.Unlock()
+	return EventBody{ContentType: ConferenceContentType, Body: f.Info().Render()}
+}
+
+// Participants returns the calls in the conference
+func (f *Focus) Participants() []*Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([]*Call, len(f.participants))
+	for i, p := range f.participants {
+		calls[i] = p.call
+	}
+	return calls
+}
+
+// add puts a call in the conference and notifies the subscribers
+func (f *Focus) add(call *Call, m Message) {
+	p := &participant{call: call, contact: call.Dialog.RemoteUri, displayName: DisplayName(m.Headers().From)}
+	if call.Dialog.Caller {
+		p.displayName = DisplayName(m.Headers().To)
+	}
+	if contacts := m.Headers().Contacts; len(contacts) > 0 {
+		p.contact = contacts[0].Uri()
+	}
+	f.mu.Lock()
+	f.participants = append(f.participants, p)
+	f.mu.Unlock()
+	go f.Notifier.Changed(f.Uri, nil)
+}
+
+// remove takes a call out of the conference and notifies the subscribers
+func (f *Focus) remove(call *Call) bool {
+	f.mu.Lock()
+	found := false
+	for i, p := range f.participants {
+		if p.call == call {
+			f.participants = append(f.participants[:i], f.participants[i+1:]...)
+			found = true
+			break
+		}
+	}
+	f.mu.Unlock()
+	if found {
+		go f.Notifier.Changed(f.Uri, nil)
+	}
+	return found
+}
+
+// participant returns the call in the conference the request belongs to
+func (f *Focus) participant(request Message) *Call {
+	id := DialogIDOf(request)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.participants {
+		if p.call.Dialog.ID() == id {
+			return p.call
+		}
+	}
+	return nil
+}
+
+// join answers an INVITE for the conference
+func (f *Focus) join(in Incoming) {
+	invite := in.Message
+	offer, err := messageSDP(invite)
+	var answer *sdp.Session
+	if err == nil && f.Answer != nil {
+		answer, err = f.Answer(offer)
+	}
+	if f.Answer == nil || err != nil {
+		f.ua.Send(context.Background(), in.Source.String(), NewResponse(invite, 488))
+		return
+	}
+	response := NewResponse(invite, 200)
+	response.Headers().Contacts = []AddressHeader{f.contact()}
+	response.Headers().ContentType = "application/sdp"
+	response.SetPayload([]byte(answer.Render()))
+	call, err := f.ua.Answer(context.Background(), in.Source.String(), invite, response)
+	if err != nil {
+		return
+	}
+	f.add(call, invite)
+}
+
+// Invite calls uri, sending the INVITE to addr, and adds the call to the
+// conference once answered
+func (f *Focus) Invite(ctx context.Context, addr, uri string) (*Call, error) {
+	if f.Offer == nil {
+		return nil, fmt.Errorf("the focus has no Offer to invite with")
+	}
+	builder := NewInvite().To(uri).WithSDP(f.Offer(uri))
+	if profile := f.ua.Profile(); profile != nil {
+		builder.From(profile)
+	} else {
+		builder.FromUri(f.Uri)
+	}
+	m, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	m.Headers().Contacts = []AddressHeader{f.contact()}
+	call, err := f.ua.Dial(ctx, addr, m.(*Invite), nil)
+	if err != nil {
+		return nil, err
+	}
+	f.add(call, m)
+	return call, nil
+}
+
+// Kick hangs up a participant
+func (f *Focus) Kick(ctx context.Context, call *Call) error {
+	if !f.remove(call) {
+		return fmt.Errorf("not a participant")
+	}
+	return call.Hangup(ctx)
+}
+
+// End hangs up every participant and ends the subscriptions to the
+// conference
+func (f *Focus) End(ctx context.Context) {
+	for _, call := range f.Participants() {
+		f.Kick(ctx, call)
+	}
+	f.Notifier.Terminate(f.Uri, "noresource")
+}
+
+// Handle processes the requests for the conference: INVITEs to join it,
+// SUBSCRIBEs to its event package, and the requests within the dialogs of
+// its participants. It returns the response to send, or nil, and false
+// for requests that aren't for the conference
+func (f *Focus) Handle(in Incoming) (*Response, bool) {
+	request := in.Message
+	if call := f.participant(request); call != nil {
+		response := call.Handle(request)
+		if request.Method() == "BYE" {
+			f.remove(call)
+		}
+		return response, true
+	}
+	if !f.isConference(request) {
+		return nil, false
+	}
+	switch request.Method() {
+	case "INVITE":
+		if request.Headers().To.Param("tag") != "" {
+			return NewResponse(request, 481), true
+		}
+		go f.join(in)
+		return NewResponse(request, 100), true
+	case "SUBSCRIBE":
+		if response := f.Notifier.Handle(in); response != nil {
+			return response, true
+		}
+		return NewResponse(request, 489), true
+	case "ACK":
+		// the ACK of a participant joining may overtake it
+		if call := f.ua.Dialogs().Match(request); call != nil {
+			call.Handle(request)
+		}
+		return nil, true
+	}
+	return NewResponse(request, 405), true
+}
+
+// Filter answers the requests Handle processes, for UserAgent.AddFilter
+func (f *Focus) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if response, ok := f.Handle(in); ok {
+			return response, false
+		}
+		return nil, true
+	}
+}
@@ -0,0 +1,68 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp/sdp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConferenceInfo(t *testing.T) {
+	contact := SetFocus(NewHeader(&Contact{}).SetUri("sip:conf@example.com"))
+	assert.True(t, IsFocus(contact))
+	assert.Equal(t, ";isfocus", contact.ParamString())
+	assert.False(t, IsFocus(NewHeader(&Contact{}).SetUri("sip:bob@biloxi.com")))
+
+	info := &ConferenceInfo{Entity: "sip:conf@example.com", Version: 3, Subject: "Weekly <sync>", Users: []ConferenceUser{
+		{Entity: "sip:bob@biloxi.com", DisplayText: "Bob", Endpoints: []ConferenceEndpoint{{Entity: "sip:bob@192.0.2.4", Status: "connected"}}},
+	}}
+	parsed, err := ParseConferenceInfo(info.Render())
+	assert.Nil(t, err)
+	info.State, info.Users[0].State = "full", "full"
+	assert.Equal(t, info, parsed)
+}
+
+func TestFocus(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	participant, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	mixer := &sdp.Session{
+		Origin:     sdp.Origin{Username: "-", SessionId: "1", SessionVersion: "1", NetType: "IN", AddrType: "IP4", Address: "127.0.0.1"},
+		Name:       "-",
+		Connection: &sdp.Connection{NetType: "IN", AddrType: "IP4", Address: "127.0.0.1"},
+		Timing:     "0 0",
+		Media:      []*sdp.Media{{Type: "audio", Port: 30000, Protocol: "RTP/AVP", Formats: []string{"0"}}},
+	}
+	focus := NewFocus(server, "sip:conf@"+b.LocalAddr().String())
+	focus.Answer = func(offer *sdp.Session) (*sdp.Session, error) {
+		return mixer, nil
+	}
+	server.AddFilter(focus.Filter())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	invite, err := NewInvite().To(focus.Uri).FromUri("sip:alice@atlanta.com").WithSDP(mixer).Build()
+	assert.Nil(t, err)
+	call, err := participant.Dial(ctx, b.LocalAddr().String(), invite.(*Invite), nil)
+	assert.Nil(t, err)
+	// the focus is reached at the conference URI
+	assert.Equal(t, focus.Uri, call.Dialog.RemoteTarget)
+	for len(focus.Participants()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	info := focus.Info()
+	if assert.Len(t, info.Users, 1) {
+		assert.Equal(t, "sip:alice@atlanta.com", info.Users[0].Entity)
+	}
+
+	assert.Nil(t, call.Hangup(ctx))
+	assert.Empty(t, focus.Participants())
+}
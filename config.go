@@ -0,0 +1,223 @@
+package slurp
+
+/*
+Configuration gathers the settings of the stack in one place, so that they
+can be loaded from a YAML or JSON file, overridden from the environment,
+and applied to a running UserAgent when the file changes.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the stack
+type Config struct {
+	Timers Timers `json:"timers" yaml:"timers"`
+	// Listen is the address to listen on for each transport, e.g.
+	// {"udp": "0.0.0.0:5060"}
+	Listen map[string]string `json:"listen" yaml:"listen"`
+	// Identity is the profile requests are sent with
+	Identity Profile `json:"identity" yaml:"identity"`
+	// Strict rejects received messages that don't pass validation
+	Strict bool `json:"strict" yaml:"strict"`
+	// PreferUpdate modifies and refreshes sessions with UPDATE rather than
+	// re-INVITE when the peer allows it
+	PreferUpdate bool `json:"prefer_update" yaml:"prefer_update"`
+	// Failover tries the next address of a name when a request to one
+	// fails, see UserAgent.SetFailover
+	Failover bool `json:"failover" yaml:"failover"`
+	// Rewrite are the rules of a Rewriter, see NewRewriter
+	Rewrite []RewriteRule `json:"rewrite" yaml:"rewrite"`
+}
+
+// UnmarshalJSON accepts durations as strings, e.g. "500ms", or as numbers
+// of nanoseconds
+func (t *Timers) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	fields := map[string]*time.Duration{
+		"t1": &t.T1, "t2": &t.T2, "t4": &t.T4, "b": &t.B, "c": &t.C, "f": &t.F, "m": &t.M,
+	}
+	for name, value := range raw {
+		field, ok := fields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("timer %s: %w", name, err)
+			}
+			*field = d
+		case float64:
+			*field = time.Duration(v)
+		default:
+			return fmt.Errorf("timer %s: invalid duration %v", name, value)
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the configuration from a file, as JSON when its
+// extension is .json and YAML otherwise
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// FromEnv overrides the configuration with the environment variables
+// starting with prefix, e.g. with prefix SLURP: SLURP_T1, SLURP_T2,
+// SLURP_T4, SLURP_TIMER_B, SLURP_TIMER_C, SLURP_TIMER_F, SLURP_TIMER_M,
+// SLURP_LISTEN_UDP, SLURP_AOR, SLURP_DISPLAY_NAME,
+// SLURP_CONTACT_HOST, SLURP_CONTACT_PORT, SLURP_TRANSPORT,
+// SLURP_USERNAME, SLURP_PASSWORD, SLURP_USER_AGENT, SLURP_STRICT,
+// SLURP_PREFER_UPDATE and SLURP_FAILOVER
+func (c *Config) FromEnv(prefix string) error {
+	lookup := func(name string) (string, bool) {
+		return os.LookupEnv(prefix + "_" + name)
+	}
+	durations := map[string]*time.Duration{
+		"T1": &c.Timers.T1, "T2": &c.Timers.T2, "T4": &c.Timers.T4,
+		"TIMER_B": &c.Timers.B, "TIMER_C": &c.Timers.C, "TIMER_F": &c.Timers.F, "TIMER_M": &c.Timers.M,
+	}
+	for name, field := range durations {
+		if value, ok := lookup(name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s_%s: %w", prefix, name, err)
+			}
+			*field = d
+		}
+	}
+	strs := map[string]*string{
+		"AOR":          &c.Identity.AOR,
+		"DISPLAY_NAME": &c.Identity.DisplayName,
+		"CONTACT_HOST": &c.Identity.ContactHost,
+		"TRANSPORT":    &c.Identity.Transport,
+		"USERNAME":     &c.Identity.Username,
+		"PASSWORD":     &c.Identity.Password,
+		"USER_AGENT":   &c.Identity.UserAgent,
+	}
+	for name, field := range strs {
+		if value, ok := lookup(name); ok {
+			*field = value
+		}
+	}
+	if value, ok := lookup("CONTACT_PORT"); ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s_CONTACT_PORT: %w", prefix, err)
+		}
+		c.Identity.ContactPort = port
+	}
+	if value, ok := lookup("STRICT"); ok {
+		strict, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s_STRICT: %w", prefix, err)
+		}
+		c.Strict = strict
+	}
+	if value, ok := lookup("PREFER_UPDATE"); ok {
+		prefer, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s_PREFER_UPDATE: %w", prefix, err)
+		}
+		c.PreferUpdate = prefer
+	}
+	if value, ok := lookup("FAILOVER"); ok {
+		failover, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s_FAILOVER: %w", prefix, err)
+		}
+		c.Failover = failover
+	}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if transport, ok := strings.CutPrefix(name, prefix+"_LISTEN_"); ok && transport != "" {
+			if c.Listen == nil {
+				c.Listen = make(map[string]string)
+			}
+			c.Listen[strings.ToLower(transport)] = value
+		}
+	}
+	return nil
+}
+
+// transportNetworks are the networks Transport opens, the one it
+// prefers first
+var transportNetworks = []string{"udp", "tcp"}
+
+// Transport opens the transport configured in Listen: UDP when it has an
+// address for it, or else TCP. Other networks are unsupported, and only
+// reported when neither is configured
+func (c *Config) Transport() (Transport, error) {
+	listen := make(map[string]string, len(c.Listen))
+	for network, address := range c.Listen {
+		listen[strings.ToLower(network)] = address
+	}
+	for _, network := range transportNetworks {
+		address, ok := listen[network]
+		if !ok {
+			continue
+		}
+		if network == "udp" {
+			return ListenUDP(address)
+		}
+		return ListenTCP(address)
+	}
+	if len(listen) == 0 {
+		return nil, fmt.Errorf("no transport configured")
+	}
+	unsupported := make([]string, 0, len(listen))
+	for network := range listen {
+		unsupported = append(unsupported, network)
+	}
+	sort.Strings(unsupported)
+	return nil, fmt.Errorf("unsupported transport %s", strings.Join(unsupported, ", "))
+}
+
+// Apply reconfigures a running UserAgent with the timers, identity and
+// strictness of the configuration. Transactions already running keep
+// the timers they started with. Listen isn't applied, as changing it
+// means replacing the transport, nor is Rewrite, as the Rewriter is in
+// the interceptor chain: reload it with SetRules
+func (c *Config) Apply(ua *UserAgent) {
+	ua.SetTimers(c.Timers)
+	if c.Identity.AOR != "" {
+		identity := c.Identity
+		if current := ua.Profile(); current != nil && current.AOR == identity.AOR {
+			// the registration still holds
+			identity.ServiceRoute = current.ServiceRoute
+		}
+		ua.SetProfile(&identity)
+	} else {
+		ua.SetProfile(nil)
+	}
+	ua.SetStrict(c.Strict)
+	ua.SetPreferUpdate(c.PreferUpdate)
+	ua.SetFailover(c.Failover)
+}
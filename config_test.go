@@ -0,0 +1,146 @@
+package slurp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"stack.json": `{
+			"timers": {"t1": "100ms", "t2": "2s"},
+			"listen": {"udp": "127.0.0.1:5060"},
+			"identity": {"aor": "sip:alice@atlanta.com", "contact_port": 5062},
+			"strict": true
+		}`,
+		"stack.yaml": `
+timers:
+  t1: 100ms
+  t2: 2s
+listen:
+  udp: 127.0.0.1:5060
+identity:
+  aor: sip:alice@atlanta.com
+  contact_port: 5062
+strict: true
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+		config, err := LoadConfig(path)
+		assert.Nil(t, err, name)
+		assert.Equal(t, Timers{T1: 100 * time.Millisecond, T2: 2 * time.Second}, config.Timers, name)
+		assert.Equal(t, "127.0.0.1:5060", config.Listen["udp"], name)
+		assert.Equal(t, "sip:alice@atlanta.com", config.Identity.AOR, name)
+		assert.Equal(t, 5062, config.Identity.ContactPort, name)
+		assert.True(t, config.Strict, name)
+	}
+
+	path := filepath.Join(dir, "bad.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"timers": {"t1": "soon"}}`), 0o644))
+	_, err := LoadConfig(path)
+	assert.NotNil(t, err)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("SLURPTEST_T1", "250ms")
+	t.Setenv("SLURPTEST_AOR", "sip:bob@biloxi.com")
+	t.Setenv("SLURPTEST_CONTACT_PORT", "5070")
+	t.Setenv("SLURPTEST_LISTEN_UDP", "0.0.0.0:5070")
+	t.Setenv("SLURPTEST_STRICT", "true")
+	t.Setenv("SLURPTEST_PREFER_UPDATE", "1")
+	t.Setenv("SLURPTEST_FAILOVER", "true")
+	config := &Config{Timers: Timers{T2: time.Second}}
+	assert.Nil(t, config.FromEnv("SLURPTEST"))
+	assert.Equal(t, Timers{T1: 250 * time.Millisecond, T2: time.Second}, config.Timers)
+	assert.Equal(t, "sip:bob@biloxi.com", config.Identity.AOR)
+	assert.Equal(t, 5070, config.Identity.ContactPort)
+	assert.Equal(t, "0.0.0.0:5070", config.Listen["udp"])
+	assert.True(t, config.Strict)
+	assert.True(t, config.PreferUpdate)
+	assert.True(t, config.Failover)
+
+	t.Setenv("SLURPTEST_STRICT", "maybe")
+	assert.NotNil(t, config.FromEnv("SLURPTEST"))
+}
+
+func TestConfigTransport(t *testing.T) {
+	config := &Config{Listen: map[string]string{"sctp": "127.0.0.1:0", "TCP": "127.0.0.1:0", "udp": "127.0.0.1:0"}}
+	for i := 0; i < 5; i++ {
+		// UDP whatever order the map is ranged in
+		transport, err := config.Transport()
+		if err != nil {
+			t.Skip("can't listen on UDP")
+		}
+		assert.Equal(t, "UDP", transport.Network())
+		transport.Close()
+	}
+	delete(config.Listen, "udp")
+	transport, err := config.Transport()
+	if assert.Nil(t, err) {
+		assert.Equal(t, "TCP", transport.Network())
+		transport.Close()
+	}
+	delete(config.Listen, "TCP")
+	config.Listen["ws"] = "127.0.0.1:0"
+	_, err = config.Transport()
+	assert.EqualError(t, err, "unsupported transport sctp, ws")
+	_, err = (&Config{}).Transport()
+	assert.EqualError(t, err, "no transport configured")
+}
+
+func TestConfigApply(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	assert.Equal(t, DefaultTimers, server.Timers())
+
+	config := &Config{Timers: Timers{T1: 100 * time.Millisecond}, Strict: true}
+	config.Apply(server)
+	assert.Equal(t, 100*time.Millisecond, server.Timers().T1)
+	assert.Equal(t, 6400*time.Millisecond, server.Timers().B)
+
+	// a request without a From tag is rejected by a strict UserAgent
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com")
+	request.Control().CallId = "a84b4c76e66710"
+	request.Control().Sequence = 1
+	response, err := client.Request(ctx, b.LocalAddr().String(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, response.StatusCode())
+
+	// requests sent by the client take its identity, and pass once the
+	// server is reconfigured
+	config = &Config{Identity: *exampleProfile()}
+	config.Apply(client)
+	(&Config{}).Apply(server)
+	go func() {
+		if in, ok := <-server.Receive(); ok {
+			server.Send(context.Background(), a.LocalAddr().String(), NewResponse(in.Message, 200))
+		}
+	}()
+	request = NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	request.Control().CallId = "a84b4c76e66711"
+	request.Control().Sequence = 1
+	response, err = client.Request(ctx, b.LocalAddr().String(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, exampleProfile().AOR, response.Headers().From.Uri())
+}
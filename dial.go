@@ -0,0 +1,205 @@
+package slurp
+
+/*
+Dialing places a call: it sends an INVITE, tracks the early dialogs its
+provisional responses create, acknowledges reliable provisional responses
+with PRACK (RFC 3262), and confirms the call with an ACK once answered.
+*/
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// ForkPolicy decides what happens when a forked INVITE is answered more
+// than once. Dial returns the first answer, and the policy applies to the rest
+type ForkPolicy int
+
+const (
+	// HangupForks acknowledges later answers and hangs them up right away
+	HangupForks ForkPolicy = iota
+	// KeepForks acknowledges later answers and hands their calls to the
+	// UserAgent's OnFork
+	KeepForks
+)
+
+// EarlyFunc is called for each provisional response that creates or
+// updates an early dialog. When the response has an SDP payload, the
+// application may start playing early media, e.g. from a 183
+type EarlyFunc func(dialog *Dialog, response *Response)
+
+// AnswerFunc answers the offer of a 2xx to a delayed-offer INVITE. The
+// answer is sent in the ACK
+type AnswerFunc func(offer *sdp.Session) (*sdp.Session, error)
+
+// requiresReliable reports whether a provisional response must be PRACKed
+func requiresReliable(response *Response) bool {
+	for _, tag := range splitHeaderValues(response.HeaderValue("Require")) {
+		if strings.EqualFold(tag, "100rel") {
+			return response.HeaderValue("RSeq") != ""
+		}
+	}
+	return false
+}
+
+// Dial sends invite to addr (host:port) and waits for it to be answered,
+// returning the established call. A From tag, Call-ID and CSeq are
+// generated when missing. early, when set, is called with every early
+// dialog as provisional responses arrive. A failure response is returned
+// as a RejectedError. Answers from other forks of the INVITE are handled
+// according to the UserAgent's ForkPolicy
+func (ua *UserAgent) Dial(ctx context.Context, addr string, invite *Invite, early EarlyFunc) (*Call, error) {
+	return ua.dial(ctx, addr, invite, early, nil)
+}
+
+// DialDelayed is Dial for an INVITE without an offer, e.g. from a
+// third-party call controller that gets the offer from the callee to
+// hand it to the other party. answer is called with the offer of the 2xx
+// and its answer sent in the ACK. When it fails, the call is
+// acknowledged and hung up, and its error returned
+func (ua *UserAgent) DialDelayed(ctx context.Context, addr string, invite *Invite, early EarlyFunc, answer AnswerFunc) (*Call, error) {
+	invite.SetPayload(nil)
+	invite.Headers().ContentType = ""
+	return ua.dial(ctx, addr, invite, early, answer)
+}
+
+func (ua *UserAgent) dial(ctx context.Context, addr string, invite *Invite, early EarlyFunc, answer AnswerFunc) (*Call, error) {
+	if ua.Draining() {
+		return nil, ShuttingDownError{}
+	}
+	headers, control := invite.Headers(), invite.Control()
+	if headers.From.Param("tag") == "" {
+		headers.From.SetParam("tag", generateTag())
+	}
+	if control.CallId == "" {
+		control.CallId = generateTag() + generateTag()
+	}
+	if control.Sequence == 0 {
+		control.Sequence = 1
+	}
+	if headers.Extensions.Get("Supported") == "" {
+		headers.Extensions.Add("Supported", "100rel")
+	}
+
+	// early dialogs and the last RSeq acknowledged, by To tag
+	dialogs := make(map[string]*Dialog)
+	rseqs := make(map[string]int)
+	// the ACK sent for each answer, by To tag, resent with retransmitted 2xx
+	var mu sync.Mutex
+	acks := make(map[string]*Request)
+	// newAck builds the ACK for the 2xx creating dialog, answering its
+	// offer when the INVITE had none
+	newAck := func(dialog *Dialog, response *Response) (*Request, error) {
+		if answer == nil {
+			return dialog.NewAck(control.Sequence), nil
+		}
+		offer, err := messageSDP(response)
+		if err == nil {
+			var session *sdp.Session
+			if session, err = answer(offer); err == nil {
+				return dialog.NewAckWithAnswer(control.Sequence, session), nil
+			}
+		}
+		return dialog.NewAck(control.Sequence), err
+	}
+	forked := func(response *Response) {
+		tag := response.Headers().To.Param("tag")
+		mu.Lock()
+		ack, answered := acks[tag]
+		if !answered {
+			dialog := newDialog(invite, response, true, ua.Clock, ua.RecordSink)
+			ack, _ = newAck(dialog, response)
+			ua.addVia(ack)
+			acks[tag] = ack
+			dialog.SentAck(ack)
+			call := NewCall(ua, dialog, addr)
+			if ua.ForkPolicy == KeepForks && ua.OnFork != nil {
+				go ua.OnFork(call)
+			} else {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), ua.Timers().F)
+					defer cancel()
+					call.Hangup(ctx)
+				}()
+			}
+		}
+		mu.Unlock()
+		ua.Send(context.Background(), addr, ack)
+	}
+	provisional := func(response *Response) {
+		tag := response.Headers().To.Param("tag")
+		if tag == "" {
+			return
+		}
+		dialog, ok := dialogs[tag]
+		if ok {
+			dialog.Update(response)
+		} else {
+			dialog = newDialog(invite, response, true, ua.Clock, ua.RecordSink)
+			dialogs[tag] = dialog
+		}
+		if requiresReliable(response) {
+			rseq, _ := strconv.Atoi(response.HeaderValue("RSeq"))
+			if rseq <= rseqs[tag] {
+				// a retransmission, already acknowledged
+				return
+			}
+			rseqs[tag] = rseq
+			prack := dialog.NewRequest("PRACK")
+			prack.Headers().Extensions.Add("RAck", fmt.Sprintf("%d %d INVITE", rseq, control.Sequence))
+			go ua.Request(ctx, addr, prack)
+		}
+		if early != nil {
+			early(dialog, response)
+		}
+	}
+	// later answers wait until the first is acknowledged, so that its
+	// retransmissions aren't mistaken for another fork
+	mu.Lock()
+	redirected := func(target string) {
+		// the answer comes from the target, and its ACK and PRACKs go there
+		addr = target
+	}
+	response, err := ua.request(ctx, addr, invite, transactionHooks{provisional: provisional, accepted: forked, redirected: redirected})
+	var answered *Dialog
+	var ack *Request
+	var answerErr error
+	if err == nil && IsSuccess(response.StatusCode()) {
+		tag := response.Headers().To.Param("tag")
+		if answered = dialogs[tag]; answered != nil {
+			answered.Update(response)
+		} else {
+			answered = newDialog(invite, response, true, ua.Clock, ua.RecordSink)
+		}
+		delete(dialogs, tag)
+		ack, answerErr = newAck(answered, response)
+		ua.addVia(ack)
+		acks[tag] = ack
+		answered.SentAck(ack)
+	}
+	mu.Unlock()
+	for _, dialog := range dialogs {
+		dialog.Terminate("early dialog ended")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if answered == nil {
+		return nil, RejectedError{StatusCode: response.StatusCode(), Reason: response.Reason()}
+	}
+	if err := ua.Send(ctx, addr, ack); err != nil {
+		return nil, err
+	}
+	call := NewCall(ua, answered, addr)
+	if answerErr != nil {
+		call.Hangup(ctx)
+		return nil, answerErr
+	}
+	return call, nil
+}
@@ -0,0 +1,234 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInvite(uri string) *Invite {
+	invite, _ := NewInvite().To(uri).FromUri("sip:alice@atlanta.com").Build()
+	return invite.(*Invite)
+}
+
+func TestDialEarlyMedia(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	received := make(chan Message, 8)
+	go func() {
+		ctx := context.Background()
+		var invite Message
+		var tag string
+		for in := range callee.Receive() {
+			received <- in.Message
+			switch in.Message.Method() {
+			case "INVITE":
+				invite = in.Message
+				progress := NewResponse(invite, 183)
+				tag = progress.Headers().To.Param("tag")
+				progress.Headers().Extensions.Add("Require", "100rel")
+				progress.Headers().Extensions.Add("RSeq", "1")
+				progress.Headers().ContentType = "application/sdp"
+				progress.SetPayload([]byte("v=0\r\n"))
+				// the second is a retransmission, which must not be PRACKed again
+				callee.Send(ctx, in.Source.String(), progress)
+				callee.Send(ctx, in.Source.String(), progress)
+			case "PRACK":
+				callee.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+				ok := NewResponse(invite, 200)
+				ok.Headers().To.SetParam("tag", tag)
+				callee.Send(ctx, in.Source.String(), ok)
+			}
+		}
+	}()
+
+	var early []*Response
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := caller.Dial(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"), func(d *Dialog, r *Response) {
+		assert.Equal(t, Early, d.State)
+		early = append(early, r)
+	})
+	assert.Nil(t, err)
+	if !assert.NotNil(t, call) {
+		return
+	}
+	assert.Equal(t, Confirmed, call.Dialog.State)
+	assert.Equal(t, "v=0\r\n", early[0].StringPayload())
+
+	assert.Equal(t, "INVITE", (<-received).Method())
+	prack := <-received
+	assert.Equal(t, "PRACK", prack.Method())
+	assert.Equal(t, "1 1 INVITE", prack.HeaderValue("RAck"))
+	ack := <-received
+	assert.Equal(t, "ACK", ack.Method())
+	assert.Equal(t, 1, ack.Control().Sequence)
+}
+
+func TestDialRejected(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	acks := make(chan Message, 1)
+	go func() {
+		for in := range callee.Receive() {
+			if in.Message.Method() == "ACK" {
+				acks <- in.Message
+				continue
+			}
+			callee.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 486))
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = caller.Dial(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"), nil)
+	assert.Equal(t, RejectedError{StatusCode: 486, Reason: "Busy Here"}, err)
+	select {
+	case ack := <-acks:
+		assert.NotEqual(t, "", ack.Headers().To.Param("tag"))
+	case <-ctx.Done():
+		t.Fatal("486 not acknowledged")
+	}
+}
+
+func TestDialDropped(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	caller := NewUserAgent(a)
+	caller.Use(func(m Message, direction Direction) (Message, error) {
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = caller.Dial(ctx, "127.0.0.1:9", newTestInvite("sip:bob@biloxi.com"), nil)
+	assert.Equal(t, DroppedError{Method: "INVITE"}, err)
+}
+
+func TestDialForked(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	received := make(chan Message, 8)
+	go func() {
+		ctx := context.Background()
+		for in := range callee.Receive() {
+			received <- in.Message
+			if in.Message.Method() == "INVITE" {
+				// two forks answer, and the first retransmits its 2xx
+				first := NewResponse(in.Message, 200)
+				callee.Send(ctx, in.Source.String(), first)
+				callee.Send(ctx, in.Source.String(), first)
+				callee.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+			} else if in.Message.Method() == "BYE" {
+				callee.Send(ctx, in.Source.String(), NewResponse(in.Message, 200))
+			}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := caller.Dial(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"), nil)
+	assert.Nil(t, err)
+	if !assert.NotNil(t, call) {
+		return
+	}
+	assert.Equal(t, "INVITE", (<-received).Method())
+	var methods []string
+	for len(methods) < 4 {
+		select {
+		case m := <-received:
+			methods = append(methods, m.Method())
+			if m.Method() == "BYE" {
+				assert.NotEqual(t, call.Dialog.RemoteTag, m.Headers().To.Param("tag"))
+			}
+		case <-ctx.Done():
+			t.Fatalf("only received %v", methods)
+		}
+	}
+	// an ACK for each 2xx, and a BYE for the losing fork
+	assert.ElementsMatch(t, []string{"ACK", "ACK", "ACK", "BYE"}, methods)
+	assert.Equal(t, Confirmed, call.Dialog.State)
+}
+
+func TestDialDelayedOffer(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	caller, callee := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	const offer = "v=0\r\no=bob 1 1 IN IP4 192.0.2.2\r\ns=-\r\nc=IN IP4 192.0.2.2\r\nt=0 0\r\nm=audio 49172 RTP/AVP 0\r\n"
+	answered := make(chan *Call, 1)
+	go func() {
+		var invite Message
+		var ok *Response
+		for in := range callee.Receive() {
+			switch in.Message.Method() {
+			case "INVITE":
+				invite = in.Message
+				assert.Empty(t, invite.Payload())
+				assert.Contains(t, invite.Render(), "Content-Length: 0\r\n")
+				ok = NewResponse(invite, 200)
+				ok.Headers().ContentType = "application/sdp"
+				ok.SetPayload([]byte(offer))
+				callee.Send(context.Background(), in.Source.String(), ok)
+			case "ACK":
+				call := NewCall(callee, NewDialog(invite, ok, false), in.Source.String())
+				call.Handle(in.Message)
+				answered <- call
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	invite := newTestInvite("sip:bob@biloxi.com")
+	call, err := caller.DialDelayed(ctx, b.LocalAddr().String(), invite, nil, func(o *sdp.Session) (*sdp.Session, error) {
+		assert.Equal(t, offer, o.Render())
+		answer := *o
+		answer.Origin.Username = "alice"
+		return &answer, nil
+	})
+	assert.Nil(t, err)
+	if !assert.NotNil(t, call) {
+		return
+	}
+	assert.Equal(t, offer, call.Dialog.RemoteSDP.Render())
+	select {
+	case remote := <-answered:
+		if assert.NotNil(t, remote.Dialog.RemoteSDP) {
+			assert.Equal(t, "alice", remote.Dialog.RemoteSDP.Origin.Username)
+		}
+	case <-ctx.Done():
+		t.Fatal("ACK not received")
+	}
+}
@@ -1 +1,426 @@
-package slurp
\ No newline at end of file
+package slurp
+
+/*
+Dialogs are peer-to-peer SIP relationships between two UAs that persist
+for some time, e.g. the duration of a call. They are identified by the
+Call-ID, the local tag and the remote tag.
+*/
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// DialogState is the state of a dialog, per RFC 3261 12
+type DialogState int
+
+const (
+	// Early dialogs are created by provisional responses with a To tag
+	Early DialogState = iota
+	Confirmed
+	Terminated
+)
+
+type Dialog struct {
+	CallId    string
+	LocalTag  string
+	RemoteTag string
+	LocalUri  string
+	RemoteUri string
+	// The Contact of the remote party, where in-dialog requests are sent
+	RemoteTarget string
+	// RouteSet is the Record-Route of the request or response that
+	// created the dialog, in the order in-dialog requests follow it
+	RouteSet  []string
+	LocalSeq  int
+	RemoteSeq int
+	// Caller is true when we sent the request that created the dialog,
+	// which means we own the Call-ID
+	Caller bool
+	State  DialogState
+	// RemoteSDP is the remote party's session description in use, from the
+	// messages that created the dialog. Applications accepting a new
+	// offer replace it
+	RemoteSDP *sdp.Session
+	// LocalSDP is our session description in use, once we made an offer
+	// on the dialog
+	LocalSDP *sdp.Session
+	// RemoteAllow is the methods the remote party allows, from the Allow
+	// of the messages that created the dialog, empty when it sent none
+	RemoteAllow []string
+	// Call detail record fields, emitted when the dialog terminates
+	SetupTime  time.Time
+	AnswerTime time.Time
+	FinalCode  int
+	// Clock used for retry timers, DefaultClock when nil
+	Clock Clock
+	// RecordSink receives the call detail record when the dialog
+	// terminates, which is discarded when it is nil
+	RecordSink     RecordSink
+	mu             sync.Mutex
+	outgoingInvite bool
+	incomingInvite bool
+	// ack is the ACK we sent for the last 2xx to our INVITE, and acked is
+	// closed once the ACK for our 2xx arrives
+	ack   *Request
+	acked chan struct{}
+}
+
+// NewDialog creates a dialog from the request that established it and
+// the response to it. caller is true when we sent the request (UAC side)
+func NewDialog(request Message, response *Response, caller bool) *Dialog {
+	return newDialog(request, response, caller, nil, nil)
+}
+
+// newDialog creates a dialog timed by clock, which stamps its SetupTime,
+// emitting its call detail record to sink
+func newDialog(request Message, response *Response, caller bool, clock Clock, sink RecordSink) *Dialog {
+	d := &Dialog{
+		CallId:     request.Control().CallId,
+		Caller:     caller,
+		SetupTime:  clockOrDefault(clock).Now(),
+		Clock:      clock,
+		RecordSink: sink,
+	}
+	from := request.Headers().From
+	to := response.Headers().To
+	remote := Message(request)
+	if caller {
+		remote = response
+	}
+	d.RemoteSDP, _ = messageSDP(remote)
+	d.RemoteAllow = remote.Headers().Extensions.GetAll("Allow")
+	if caller {
+		d.LocalTag, d.LocalUri = from.Param("tag"), from.Uri()
+		d.RemoteTag, d.RemoteUri = to.Param("tag"), to.Uri()
+		d.LocalSeq = request.Control().Sequence
+		// the caller follows the Record-Route of the response in reverse
+		recorded := response.Headers().Extensions.GetAll("Record-Route")
+		for i := len(recorded) - 1; i >= 0; i-- {
+			d.RouteSet = append(d.RouteSet, recorded[i])
+		}
+		if len(response.Headers().Contacts) > 0 {
+			d.RemoteTarget = response.Headers().Contacts[0].Uri()
+		}
+	} else {
+		d.LocalTag, d.LocalUri = to.Param("tag"), to.Uri()
+		d.RemoteTag, d.RemoteUri = from.Param("tag"), from.Uri()
+		d.RemoteSeq = request.Control().Sequence
+		d.RouteSet = request.Headers().Extensions.GetAll("Record-Route")
+		if len(request.Headers().Contacts) > 0 {
+			d.RemoteTarget = request.Headers().Contacts[0].Uri()
+		}
+	}
+	d.update(response)
+	Metrics().AddGauge(MetricActiveDialogs, 1, nil)
+	return d
+}
+
+// Update applies a later response to the initial request, e.g. the 200
+// that confirms an early dialog, or the failure that ends it
+func (d *Dialog) Update(response *Response) {
+	d.mu.Lock()
+	d.update(response)
+	d.mu.Unlock()
+	if IsFailure(response.StatusCode()) {
+		d.Terminate(response.Reason())
+	}
+}
+
+func (d *Dialog) update(response *Response) {
+	if allow := response.Headers().Extensions.GetAll("Allow"); len(allow) > 0 && d.Caller {
+		d.RemoteAllow = allow
+	}
+	if IsFinal(response.StatusCode()) {
+		d.FinalCode = response.StatusCode()
+	}
+	if IsSuccess(response.StatusCode()) && d.State == Early {
+		d.State = Confirmed
+		d.AnswerTime = clockOrDefault(d.Clock).Now()
+	}
+	if len(response.Headers().Contacts) > 0 && d.Caller {
+		d.RemoteTarget = response.Headers().Contacts[0].Uri()
+	}
+}
+
+// messageSDP parses the session description in the body of the message,
+// which may be a part of a multipart one
+func messageSDP(m Message) (*sdp.Session, error) {
+	body, _, ok := BodyPart(m, "application/sdp")
+	if !ok {
+		return nil, fmt.Errorf("no session description in %s", m.Method())
+	}
+	return sdp.Parse(string(body))
+}
+
+// MediaChanges reports how the offer of a re-INVITE differs from the
+// remote session description in use, so the application can tell
+// whether the media has to be set up again, or only e.g. put on hold
+func (d *Dialog) MediaChanges(offer Message) (sdp.Changes, error) {
+	session, err := messageSDP(offer)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	current := d.RemoteSDP
+	d.mu.Unlock()
+	if current == nil {
+		current = &sdp.Session{}
+	}
+	return sdp.Compare(current, session), nil
+}
+
+// Terminate ends the dialog, emitting its call detail record to its
+// RecordSink. Terminating a dialog more than once has no effect
+func (d *Dialog) Terminate(reason string) {
+	d.mu.Lock()
+	if d.State == Terminated {
+		d.mu.Unlock()
+		return
+	}
+	d.State = Terminated
+	Metrics().AddGauge(MetricActiveDialogs, -1, nil)
+	record := CallRecord{
+		CallId:        d.CallId,
+		From:          d.LocalUri,
+		To:            d.RemoteUri,
+		SetupTime:     d.SetupTime,
+		AnswerTime:    d.AnswerTime,
+		ReleaseTime:   clockOrDefault(d.Clock).Now(),
+		ReleaseReason: reason,
+		FinalCode:     d.FinalCode,
+	}
+	d.mu.Unlock()
+	if !d.Caller {
+		record.From, record.To = record.To, record.From
+	}
+	if d.RecordSink != nil {
+		d.RecordSink.Record(record)
+	}
+}
+
+// Ended reports whether the dialog was terminated
+func (d *Dialog) Ended() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.State == Terminated
+}
+
+// NewRequest builds a request within the dialog, sent to the remote
+// target with the next local sequence number
+func (d *Dialog) NewRequest(method string) *Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.LocalSeq++
+	return d.newRequest(method, d.LocalSeq)
+}
+
+// NewAck builds the ACK for a 2xx to the INVITE with sequence number seq.
+// It is a request of its own, but shares the INVITE's sequence number
+func (d *Dialog) NewAck(seq int) *Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.newRequest("ACK", seq)
+}
+
+// NewAckWithAnswer builds the ACK for a 2xx carrying the offer, when the
+// INVITE had none (a delayed offer). The ACK carries the answer
+func (d *Dialog) NewAckWithAnswer(seq int, answer *sdp.Session) *Request {
+	ack := d.NewAck(seq)
+	ack.headers.ContentType = "application/sdp"
+	ack.SetPayload([]byte(answer.Render()))
+	return ack
+}
+
+// ReceiveAck takes the remote session description from the ACK to our
+// 2xx, which carries the answer when we made the offer in the 2xx, and
+// stops the 2xx from being retransmitted
+func (d *Dialog) ReceiveAck(ack Message) {
+	session, err := messageSDP(ack)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.RemoteSDP = session
+	}
+	d.awaitAck()
+	select {
+	case <-d.acked:
+	default:
+		close(d.acked)
+	}
+}
+
+// awaitAck returns the channel closed once our 2xx is acknowledged, the
+// lock must be held
+func (d *Dialog) awaitAck() chan struct{} {
+	if d.acked == nil {
+		d.acked = make(chan struct{})
+	}
+	return d.acked
+}
+
+// Acked returns a channel closed once the ACK for our 2xx arrived
+func (d *Dialog) Acked() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.awaitAck()
+}
+
+// SentAck records the ACK sent for the 2xx to our INVITE, which
+// retransmissions of the 2xx are answered with again
+func (d *Dialog) SentAck(ack *Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ack = ack
+}
+
+// AckFor returns the ACK to send again for a retransmission of the 2xx to
+// our INVITE, or nil when response isn't one we acknowledged
+func (d *Dialog) AckFor(response *Response) *Request {
+	if response.Method() != "INVITE" || !IsSuccess(response.StatusCode()) {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ack == nil || d.ack.control.Sequence != response.Control().Sequence {
+		return nil
+	}
+	return d.ack
+}
+
+// newRequest builds an in-dialog request, the lock must be held
+func (d *Dialog) newRequest(method string, seq int) *Request {
+	request := NewRequest(method, d.RemoteTarget)
+	if request.uri == "" {
+		request.uri = d.RemoteUri
+	}
+	request.headers.From = NewHeader(&ToFrom{}).SetUri(d.LocalUri).SetParam("tag", d.LocalTag)
+	request.headers.To = NewHeader(&ToFrom{}).SetUri(d.RemoteUri).SetParam("tag", d.RemoteTag)
+	request.control.CallId = d.CallId
+	request.control.Sequence = seq
+	if len(d.RouteSet) > 0 {
+		setRoute(request, d.RouteSet)
+	}
+	return request
+}
+
+// Reinvite builds a re-INVITE offering offer, with the next local
+// sequence number, and marks it pending as BeginReinvite does. EndReinvite
+// must be called once it completes
+func (d *Dialog) Reinvite(offer *sdp.Session) (*Invite, error) {
+	if err := d.BeginReinvite(); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	request := d.newRequest("INVITE", d.LocalSeq)
+	d.mu.Unlock()
+	invite := &Invite{headers: request.headers, control: request.control, uri: request.uri}
+	invite.headers.ContentType = "application/sdp"
+	invite.SetPayload([]byte(offer.Render()))
+	return invite, nil
+}
+
+// Allows reports whether the remote party allows method, as far as its
+// Allow header tells
+func (d *Dialog) Allows(method string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, allowed := range d.RemoteAllow {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewUpdate builds an UPDATE (RFC 3311) offering offer, or without a body
+// to only refresh the session when offer is nil
+func (d *Dialog) NewUpdate(offer *sdp.Session) *Request {
+	update := d.NewRequest("UPDATE")
+	if offer != nil {
+		update.headers.ContentType = "application/sdp"
+		update.SetPayload([]byte(offer.Render()))
+	}
+	return update
+}
+
+// modified applies the 2xx to our re-INVITE or UPDATE: the remote target
+// moves to its Contact, and when we made an offer, it and its answer
+// become the sessions in use
+func (d *Dialog) modified(offer *sdp.Session, response *Response) {
+	answer, err := messageSDP(response)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(response.Headers().Contacts) > 0 {
+		d.RemoteTarget = response.Headers().Contacts[0].Uri()
+	}
+	if offer != nil && err == nil {
+		d.LocalSDP, d.RemoteSDP = offer, answer
+	}
+}
+
+// BeginReinvite marks an outgoing re-INVITE as pending. A UA must not
+// start a re-INVITE while another INVITE transaction is in progress in
+// either direction, in which case a RequestPendingError is returned
+func (d *Dialog) BeginReinvite() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.outgoingInvite || d.incomingInvite {
+		return RequestPendingError{CallId: d.CallId}
+	}
+	d.outgoingInvite = true
+	d.LocalSeq++
+	return nil
+}
+
+// EndReinvite marks the outgoing re-INVITE as completed
+func (d *Dialog) EndReinvite() {
+	d.mu.Lock()
+	d.outgoingInvite = false
+	d.mu.Unlock()
+}
+
+// ReceiveReinvite handles an incoming re-INVITE. If we have a re-INVITE
+// of our own pending, the requests crossed (glare) and the 491 response
+// to send is returned. Otherwise the incoming re-INVITE is marked pending
+// until EndIncomingReinvite is called and nil is returned
+func (d *Dialog) ReceiveReinvite(request Message) *Response {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.outgoingInvite || d.incomingInvite {
+		return NewResponse(request, 491)
+	}
+	d.incomingInvite = true
+	d.RemoteSeq = request.Control().Sequence
+	return nil
+}
+
+// EndIncomingReinvite marks the incoming re-INVITE as completed
+func (d *Dialog) EndIncomingReinvite() {
+	d.mu.Lock()
+	d.incomingInvite = false
+	d.mu.Unlock()
+}
+
+// GlareRetryInterval returns how long to wait before retrying a re-INVITE
+// that was answered with 491. Per RFC 3261 14.1, the owner of the Call-ID
+// waits 2.1 to 4 seconds, and the other side 0 to 2 seconds, in units of 10ms
+func (d *Dialog) GlareRetryInterval() time.Duration {
+	if d.Caller {
+		return 2100*time.Millisecond + time.Duration(rand.Intn(191))*10*time.Millisecond
+	}
+	return time.Duration(rand.Intn(201)) * 10 * time.Millisecond
+}
+
+// RetryReinvite ends the pending outgoing re-INVITE that was answered with
+// 491 and calls retry after the randomized interval the RFC requires
+func (d *Dialog) RetryReinvite(retry func()) Timer {
+	d.EndReinvite()
+	return clockOrDefault(d.Clock).AfterFunc(d.GlareRetryInterval(), retry)
+}
@@ -0,0 +1,100 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exampleDialog(t *testing.T, caller bool) (*Invite, *Dialog) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	if err != nil {
+		t.Skip("example invite not available")
+	}
+	invite := &Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+	response := NewResponse(invite, 200)
+	return invite, NewDialog(invite, response, caller)
+}
+
+func TestNewDialog(t *testing.T) {
+	invite, dialog := exampleDialog(t, true)
+	assert.Equal(t, invite.Control().CallId, dialog.CallId)
+	assert.Equal(t, "1928301774", dialog.LocalTag)
+	assert.NotEqual(t, "", dialog.RemoteTag)
+	assert.Equal(t, 314159, dialog.LocalSeq)
+}
+
+func TestReinviteGlare(t *testing.T) {
+	invite, dialog := exampleDialog(t, true)
+	assert.Nil(t, dialog.BeginReinvite())
+	assert.IsType(t, RequestPendingError{}, dialog.BeginReinvite())
+	response := dialog.ReceiveReinvite(invite)
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 491, response.StatusCode())
+	}
+	for i := 0; i < 10; i++ {
+		interval := dialog.GlareRetryInterval()
+		assert.True(t, interval >= 2100*time.Millisecond && interval <= 4*time.Second)
+	}
+	dialog.Caller = false
+	for i := 0; i < 10; i++ {
+		assert.True(t, dialog.GlareRetryInterval() <= 2*time.Second)
+	}
+	dialog.EndReinvite()
+	assert.Nil(t, dialog.ReceiveReinvite(invite))
+}
+
+func TestCallRecord(t *testing.T) {
+	var records []CallRecord
+	_, dialog := exampleDialog(t, false)
+	dialog.RecordSink = RecordSinkFunc(func(record CallRecord) {
+		records = append(records, record)
+	})
+	assert.Equal(t, Confirmed, dialog.State)
+	assert.Equal(t, 200, dialog.FinalCode)
+	dialog.Terminate("BYE")
+	dialog.Terminate("BYE")
+	if assert.Equal(t, 1, len(records)) {
+		record := records[0]
+		assert.Equal(t, "sip:alice@atlanta.com", record.From)
+		assert.Equal(t, "sip:bob@biloxi.com", record.To)
+		assert.Equal(t, "BYE", record.ReleaseReason)
+		assert.Equal(t, 200, record.FinalCode)
+		assert.False(t, record.AnswerTime.IsZero())
+		assert.True(t, record.Duration() >= 0)
+	}
+}
+
+func TestMediaChanges(t *testing.T) {
+	offer, err := sdp.Parse("v=0\r\no=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\n")
+	assert.Nil(t, err)
+	invite, err := NewInvite().To("sip:bob@biloxi.com").From(exampleProfile()).WithSDP(offer).Build()
+	assert.Nil(t, err)
+	response := NewResponse(invite, 200)
+	response.Headers().To.SetParam("tag", "a6c85cf")
+	dialog := NewDialog(invite, response, false)
+	assert.Equal(t, offer, dialog.RemoteSDP)
+
+	reinvite := Clone(invite)
+	changes, err := dialog.MediaChanges(reinvite)
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+
+	offer.Attributes = append(offer.Attributes, sdp.Attribute{Key: "inactive"})
+	reinvite.SetPayload([]byte(offer.Render()))
+	changes, err = dialog.MediaChanges(reinvite)
+	assert.Nil(t, err)
+	assert.Equal(t, sdp.Changes{{Kind: sdp.DirectionChanged, Old: "sendrecv", New: "inactive"}}, changes)
+	assert.False(t, changes.NeedsRebuild())
+
+	reinvite.SetPayload(nil)
+	reinvite.Headers().ContentType = ""
+	_, err = dialog.MediaChanges(reinvite)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,159 @@
+package slurp
+
+/*
+Some headers point at another dialog of the UA receiving the request:
+Replaces (RFC 3891) to take its place, Join (RFC 3911) to join it, e.g.
+for a supervisor barging in on a call-center agent, and Target-Dialog
+(RFC 4538) to show the request is related to it, e.g. a consultation.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DialogRef identifies a dialog of the UA receiving the header that
+// carries it, so LocalTag is that UA's tag
+type DialogRef struct {
+	CallId    string
+	LocalTag  string
+	RemoteTag string
+	// EarlyOnly restricts Replaces to early dialogs
+	EarlyOnly bool
+}
+
+// RefTo returns a reference to the dialog for its remote party, whose
+// local tag is our remote tag
+func RefTo(d *Dialog) DialogRef {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DialogRef{CallId: d.CallId, LocalTag: d.RemoteTag, RemoteTag: d.LocalTag}
+}
+
+// parseDialogRef parses a Call-ID followed by parameters, taking the tags
+// from the parameters named local and remote
+func parseDialogRef(value, local, remote string) (DialogRef, error) {
+	parts := strings.Split(strings.TrimSpace(value), ";")
+	ref := DialogRef{CallId: strings.TrimSpace(parts[0])}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch strings.ToLower(name) {
+		case local:
+			ref.LocalTag = value
+		case remote:
+			ref.RemoteTag = value
+		case "early-only":
+			ref.EarlyOnly = true
+		}
+	}
+	if ref.CallId == "" || ref.LocalTag == "" || ref.RemoteTag == "" {
+		return ref, fmt.Errorf("invalid dialog reference %q", value)
+	}
+	return ref, nil
+}
+
+// ParseReplaces parses a Replaces header value
+func ParseReplaces(value string) (DialogRef, error) {
+	return parseDialogRef(value, "to-tag", "from-tag")
+}
+
+// ParseJoin parses a Join header value
+func ParseJoin(value string) (DialogRef, error) {
+	return parseDialogRef(value, "to-tag", "from-tag")
+}
+
+// ParseTargetDialog parses a Target-Dialog header value
+func ParseTargetDialog(value string) (DialogRef, error) {
+	return parseDialogRef(value, "local-tag", "remote-tag")
+}
+
+// Replaces renders the reference as a Replaces header value
+func (r DialogRef) Replaces() string {
+	value := fmt.Sprintf("%s;to-tag=%s;from-tag=%s", r.CallId, r.LocalTag, r.RemoteTag)
+	if r.EarlyOnly {
+		value += ";early-only"
+	}
+	return value
+}
+
+// Join renders the reference as a Join header value
+func (r DialogRef) Join() string {
+	return fmt.Sprintf("%s;to-tag=%s;from-tag=%s", r.CallId, r.LocalTag, r.RemoteTag)
+}
+
+// TargetDialog renders the reference as a Target-Dialog header value
+func (r DialogRef) TargetDialog() string {
+	return fmt.Sprintf("%s;local-tag=%s;remote-tag=%s", r.CallId, r.LocalTag, r.RemoteTag)
+}
+
+// Matches reports whether the reference points at the dialog
+func (r DialogRef) Matches(d *Dialog) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.CallId == r.CallId && d.LocalTag == r.LocalTag && d.RemoteTag == r.RemoteTag &&
+		d.State != Terminated && (!r.EarlyOnly || d.State == Early)
+}
+
+// FindCall returns the call of the UserAgent the reference points at, or nil
+func (ua *UserAgent) FindCall(ref DialogRef) *Call {
+	call := ua.dialogs.Lookup(DialogID{CallId: ref.CallId, LocalTag: ref.LocalTag, RemoteTag: ref.RemoteTag})
+	if call == nil || !ref.Matches(call.Dialog) {
+		return nil
+	}
+	return call
+}
+
+// DialogHooks are called with requests referring to one of our calls.
+// Each returns the response to stop the request with, or nil to let it
+// through to the application. Hooks that aren't set let requests through
+type DialogHooks struct {
+	OnReplaces     func(in Incoming, call *Call) *Response
+	OnJoin         func(in Incoming, call *Call) *Response
+	OnTargetDialog func(in Incoming, call *Call) *Response
+}
+
+// Filter matches the Replaces, Join and Target-Dialog of requests to the
+// calls of ua. A request with both Replaces and Join is answered with
+// 400, and one whose Replaces or Join matches no call with 481. A
+// Target-Dialog matching no call is ignored
+func (h DialogHooks) Filter(ua *UserAgent) RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		extensions := &in.Message.Headers().Extensions
+		replaces, join := extensions.Get("Replaces"), extensions.Get("Join")
+		if replaces != "" && join != "" {
+			return NewResponse(in.Message, 400), false
+		}
+		type reference struct {
+			value  string
+			parse  func(string) (DialogRef, error)
+			hook   func(Incoming, *Call) *Response
+			strict bool
+		}
+		for _, each := range []reference{
+			{replaces, ParseReplaces, h.OnReplaces, true},
+			{join, ParseJoin, h.OnJoin, true},
+			{extensions.Get("Target-Dialog"), ParseTargetDialog, h.OnTargetDialog, false},
+		} {
+			if each.value == "" {
+				continue
+			}
+			ref, err := each.parse(each.value)
+			if err != nil {
+				return NewResponse(in.Message, 400), false
+			}
+			call := ua.FindCall(ref)
+			if call == nil {
+				if each.strict {
+					return NewResponse(in.Message, 481), false
+				}
+				continue
+			}
+			if each.hook != nil {
+				if response := each.hook(in, call); response != nil {
+					return response, false
+				}
+			}
+		}
+		return nil, true
+	}
+}
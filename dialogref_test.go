@@ -0,0 +1,73 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialogRef(t *testing.T) {
+	ref, err := ParseReplaces("425928@bobster.example.org;to-tag=7743;from-tag=6472;early-only")
+	assert.Nil(t, err)
+	assert.Equal(t, DialogRef{CallId: "425928@bobster.example.org", LocalTag: "7743", RemoteTag: "6472", EarlyOnly: true}, ref)
+	assert.Equal(t, "425928@bobster.example.org;to-tag=7743;from-tag=6472;early-only", ref.Replaces())
+
+	ref, err = ParseTargetDialog("fa77as7dad8-sd98ajzz@host.example.com;local-tag=989;remote-tag=1234")
+	assert.Nil(t, err)
+	assert.Equal(t, "989", ref.LocalTag)
+	assert.Equal(t, "1234", ref.RemoteTag)
+	assert.Equal(t, "fa77as7dad8-sd98ajzz@host.example.com;local-tag=989;remote-tag=1234", ref.TargetDialog())
+
+	_, err = ParseJoin("425928@bobster.example.org;to-tag=7743")
+	assert.NotNil(t, err)
+}
+
+func TestDialogHooks(t *testing.T) {
+	_, dialog := exampleDialog(t, true)
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	ua := NewUserAgent(a)
+	call := NewCall(ua, dialog, "127.0.0.1:5060")
+	// the reference the remote party hands out points at our side of the call
+	ours := DialogRef{CallId: dialog.CallId, LocalTag: dialog.LocalTag, RemoteTag: dialog.RemoteTag}
+	assert.Equal(t, call, ua.FindCall(ours))
+	assert.Nil(t, ua.FindCall(RefTo(dialog)))
+
+	var joined *Call
+	filter := DialogHooks{OnJoin: func(in Incoming, c *Call) *Response {
+		joined = c
+		return nil
+	}}.Filter(ua)
+
+	barge := newTestInvite("sip:agent@atlanta.com")
+	barge.Headers().Extensions.Add("Join", ours.Join())
+	_, ok := filter(Incoming{Message: barge})
+	assert.True(t, ok)
+	assert.Equal(t, call, joined)
+
+	barge.Headers().Extensions.Add("Replaces", ours.Replaces())
+	response, ok := filter(Incoming{Message: barge})
+	assert.False(t, ok)
+	assert.Equal(t, 400, response.StatusCode())
+
+	stale := newTestInvite("sip:agent@atlanta.com")
+	stale.Headers().Extensions.Add("Replaces", RefTo(dialog).Replaces())
+	response, ok = filter(Incoming{Message: stale})
+	assert.False(t, ok)
+	assert.Equal(t, 481, response.StatusCode())
+
+	// an unknown Target-Dialog doesn't stop the request
+	consult := newTestInvite("sip:agent@atlanta.com")
+	consult.Headers().Extensions.Add("Target-Dialog", RefTo(dialog).TargetDialog())
+	_, ok = filter(Incoming{Message: consult})
+	assert.True(t, ok)
+
+	early := ours
+	early.EarlyOnly = true
+	assert.Nil(t, ua.FindCall(early))
+	dialog.Terminate("test")
+	assert.Nil(t, ua.FindCall(ours))
+}
@@ -0,0 +1,137 @@
+package slurp
+
+/*
+A UserAgent keeps its calls in a DialogStore, keyed by the identifier of
+their dialog: the Call-ID, the local tag and the remote tag (RFC 3261
+12). Requests received within a dialog are routed to their call by it,
+and administrative tools inspect the calls in progress through it.
+*/
+
+import (
+	"sync"
+)
+
+// DialogID identifies a dialog, from our side
+type DialogID struct {
+	CallId    string
+	LocalTag  string
+	RemoteTag string
+}
+
+// String formats the identifier like the Target-Dialog header does
+func (id DialogID) String() string {
+	return id.CallId + ";local-tag=" + id.LocalTag + ";remote-tag=" + id.RemoteTag
+}
+
+// ID returns the identifier of the dialog
+func (d *Dialog) ID() DialogID {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DialogID{CallId: d.CallId, LocalTag: d.LocalTag, RemoteTag: d.RemoteTag}
+}
+
+// DialogIDOf returns the identifier of the dialog a message we received
+// belongs to. Our tag is in the To of requests and the From of responses
+func DialogIDOf(m Message) DialogID {
+	id := DialogID{CallId: m.Control().CallId}
+	if to := m.Headers().To; to != nil {
+		id.LocalTag = to.Param("tag")
+	}
+	if from := m.Headers().From; from != nil {
+		id.RemoteTag = from.Param("tag")
+	}
+	if _, ok := m.(*Response); ok {
+		id.LocalTag, id.RemoteTag = id.RemoteTag, id.LocalTag
+	}
+	return id
+}
+
+// DialogStore is a set of calls by the identifier of their dialog. It is
+// safe for concurrent use
+type DialogStore struct {
+	mu    sync.RWMutex
+	calls map[DialogID]*Call
+}
+
+// NewDialogStore creates an empty DialogStore
+func NewDialogStore() *DialogStore {
+	return &DialogStore{calls: make(map[DialogID]*Call)}
+}
+
+// Insert adds call, replacing the one with the same dialog identifier
+func (s *DialogStore) Insert(call *Call) {
+	id := call.Dialog.ID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls == nil {
+		s.calls = make(map[DialogID]*Call)
+	}
+	s.calls[id] = call
+}
+
+// Lookup returns the call of the dialog identified by id, or nil
+func (s *DialogStore) Lookup(id DialogID) *Call {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.calls[id]
+}
+
+// Match returns the call a received message belongs to, or nil
+func (s *DialogStore) Match(m Message) *Call {
+	if m.Headers().To == nil || m.Headers().From == nil {
+		return nil
+	}
+	return s.Lookup(DialogIDOf(m))
+}
+
+// Remove takes the call of the dialog identified by id out of the store,
+// returning it, or nil when there was none
+func (s *DialogStore) Remove(id DialogID) *Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := s.calls[id]
+	delete(s.calls, id)
+	return call
+}
+
+// Len returns the number of calls in the store
+func (s *DialogStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.calls)
+}
+
+// Range calls f with each call in the store, in no particular order,
+// until it returns false. The store isn't locked while f runs, so f may
+// insert or remove calls
+func (s *DialogStore) Range(f func(id DialogID, call *Call) bool) {
+	s.mu.RLock()
+	calls := make(map[DialogID]*Call, len(s.calls))
+	for id, call := range s.calls {
+		calls[id] = call
+	}
+	s.mu.RUnlock()
+	for id, call := range calls {
+		if !f(id, call) {
+			return
+		}
+	}
+}
+
+// Prune removes the calls whose dialog has terminated, returning how
+// many calls remain
+func (s *DialogStore) Prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, call := range s.calls {
+		if call.Dialog.Ended() {
+			delete(s.calls, id)
+		}
+	}
+	return len(s.calls)
+}
+
+// Dialogs returns the store of the calls in progress on the UserAgent
+func (ua *UserAgent) Dialogs() *DialogStore {
+	return ua.dialogs
+}
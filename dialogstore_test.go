@@ -0,0 +1,36 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialogStore(t *testing.T) {
+	invite, _ := exampleDialog(t, false)
+	response := NewResponse(invite, 200)
+	uas, uac := NewDialog(invite, response, false), NewDialog(invite, response, true)
+	store := NewDialogStore()
+	call := &Call{Dialog: uas}
+	store.Insert(call)
+	assert.Equal(t, 1, store.Len())
+
+	// requests from the remote party are routed to the call, in either
+	// direction of the dialog
+	assert.Equal(t, call, store.Match(uac.NewRequest("BYE")))
+	assert.Equal(t, call, store.Lookup(DialogID{CallId: uas.CallId, LocalTag: uas.LocalTag, RemoteTag: uas.RemoteTag}))
+	other := uac.NewRequest("BYE")
+	other.Headers().From.SetParam("tag", "other")
+	assert.Nil(t, store.Match(other))
+
+	ids := []DialogID{}
+	store.Range(func(id DialogID, each *Call) bool {
+		ids = append(ids, id)
+		return true
+	})
+	assert.Equal(t, []DialogID{uas.ID()}, ids)
+
+	uas.Terminate("BYE")
+	assert.Equal(t, 0, store.Prune())
+	assert.Nil(t, store.Remove(uas.ID()))
+}
@@ -0,0 +1,238 @@
+package slurp
+
+/*
+Users dial numbers the way their country does: 020 7946 0000 in London,
+00 33 1 23 45 67 89 for Paris. A NumberPlan normalizes them to E.164,
++442079460000, so that routing only deals with one form, and a DialPlan
+picks the route of a number by its longest matching prefix, or a regular
+expression, translating it into what the next hop expects.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NumberPlan is how numbers are dialed in a country
+type NumberPlan struct {
+	// CountryCode is the calling code, without +, e.g. 44
+	CountryCode string `json:"country_code" yaml:"country_code"`
+	// InternationalPrefix is dialed before a country code, e.g. 00
+	InternationalPrefix string `json:"international_prefix" yaml:"international_prefix"`
+	// TrunkPrefix is dialed before a national number, e.g. 0
+	TrunkPrefix string `json:"trunk_prefix" yaml:"trunk_prefix"`
+	// AreaCode, when set, is added to numbers dialed without TrunkPrefix
+	// that are SubscriberLength digits long, as within an area
+	AreaCode         string `json:"area_code,omitempty" yaml:"area_code,omitempty"`
+	SubscriberLength int    `json:"subscriber_length,omitempty" yaml:"subscriber_length,omitempty"`
+}
+
+// dialedNumber returns the number of a tel URI, or of a SIP URI whose
+// user part is one, without visual separators but with a leading + when
+// it has one
+func dialedNumber(uri string) (string, bool) {
+	uri = addrSpec(uri)
+	var user string
+	switch UriScheme(uri) {
+	case "tel":
+		user = strings.SplitN(uri[len("tel:"):], ";", 2)[0]
+	case "sip", "sips":
+		user, _ = uriUserHost(uri)
+		user = strings.SplitN(user, ";", 2)[0]
+	default:
+		return "", false
+	}
+	var number strings.Builder
+	for i, c := range user {
+		switch {
+		case c >= '0' && c <= '9', c == '+' && i == 0:
+			number.WriteRune(c)
+		case strings.ContainsRune("-.() ", c):
+		default:
+			return "", false
+		}
+	}
+	return number.String(), strings.TrimPrefix(number.String(), "+") != ""
+}
+
+// Normalize returns number in E.164, with its leading +. Numbers it
+// can't make sense of, e.g. short codes, are returned as they are, with
+// ok false
+func (p *NumberPlan) Normalize(number string) (e164 string, ok bool) {
+	digits := strings.Map(func(c rune) rune {
+		if strings.ContainsRune("-.() ", c) {
+			return -1
+		}
+		return c
+	}, number)
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		return digits, len(digits) > 1
+	case p.InternationalPrefix != "" && strings.HasPrefix(digits, p.InternationalPrefix):
+		return "+" + digits[len(p.InternationalPrefix):], len(digits) > len(p.InternationalPrefix)
+	case p.TrunkPrefix != "" && strings.HasPrefix(digits, p.TrunkPrefix):
+		return "+" + p.CountryCode + digits[len(p.TrunkPrefix):], p.CountryCode != ""
+	case p.AreaCode != "" && len(digits) == p.SubscriberLength:
+		return "+" + p.CountryCode + p.AreaCode + digits, p.CountryCode != ""
+	}
+	return number, false
+}
+
+// NormalizeUri returns uri with its number in E.164, or as it is when it
+// has none the plan can normalize. SIP URIs get user=phone
+func (p *NumberPlan) NormalizeUri(uri string) string {
+	number, ok := dialedNumber(uri)
+	if !ok {
+		return uri
+	}
+	e164, ok := p.Normalize(number)
+	if !ok {
+		return uri
+	}
+	start, end, ok := uriPart(uri, "user")
+	if !ok {
+		return uri
+	}
+	normalized := uri[:start] + e164 + uri[end:]
+	if UriScheme(uri) == "tel" || strings.Contains(strings.ToLower(normalized), ";user=phone") {
+		return normalized
+	}
+	// user=phone goes after the host and port, before other parameters
+	_, end, _ = uriPart(normalized, "host")
+	if stop := strings.IndexAny(normalized[end:], ";?>"); stop >= 0 {
+		end += stop
+	} else {
+		end = len(normalized)
+	}
+	return normalized[:end] + ";user=phone" + normalized[end:]
+}
+
+// DialRule routes the numbers it matches
+type DialRule struct {
+	Name string `json:"name" yaml:"name"`
+	// Prefix matches the E.164 numbers starting with it, e.g. +331, and
+	// Pattern those matching a regular expression. A rule with both
+	// must match both; one with neither matches every number
+	Prefix  string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// CallerPrefix, when set, only matches calls from numbers starting
+	// with it, e.g. to route a branch's calls out of its own trunk
+	CallerPrefix string `json:"caller_prefix,omitempty" yaml:"caller_prefix,omitempty"`
+	// The number sent is translated: Strip digits are removed from its
+	// start, then Prepend is added, e.g. to dial +44 numbers as national
+	// ones with Strip 3 and Prepend 0. With Pattern, Replace substitutes
+	// for it instead, and may refer to its groups as $1
+	Strip   int    `json:"strip,omitempty" yaml:"strip,omitempty"`
+	Prepend string `json:"prepend,omitempty" yaml:"prepend,omitempty"`
+	Replace string `json:"replace,omitempty" yaml:"replace,omitempty"`
+	// Route is where matching calls go, e.g. the host:port of a gateway
+	// or the name of a RoutingTable route
+	Route string `json:"route" yaml:"route"`
+}
+
+// DialMatch is the outcome of routing a number
+type DialMatch struct {
+	// Rule is the name of the rule matched, and Route its route
+	Rule  string
+	Route string
+	// Number is the number normalized, and Translated as it is sent
+	Number     string
+	Translated string
+}
+
+// DialPlan selects the route of numbers, normalizing them with its
+// Numbers plan first. It is safe for concurrent use once created
+type DialPlan struct {
+	Numbers  NumberPlan
+	rules    []DialRule
+	patterns []*regexp.Regexp
+}
+
+// NewDialPlan creates a DialPlan with rules, failing when a pattern
+// doesn't compile
+func NewDialPlan(numbers NumberPlan, rules []DialRule) (*DialPlan, error) {
+	d := &DialPlan{Numbers: numbers, rules: rules}
+	for _, rule := range rules {
+		pattern, err := compilePattern(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("dial rule %q: %v", rule.Name, err)
+		}
+		if pattern == nil && rule.Replace != "" {
+			return nil, fmt.Errorf("dial rule %q: replace needs a pattern", rule.Name)
+		}
+		d.patterns = append(d.patterns, pattern)
+	}
+	return d, nil
+}
+
+// Match routes a number dialed by caller, who may be empty when unknown.
+// Of the rules matching, the one with the longest Prefix wins, then the
+// one with the longest CallerPrefix, then the first given
+func (d *DialPlan) Match(number, caller string) (DialMatch, bool) {
+	number, _ = d.Numbers.Normalize(number)
+	if caller != "" {
+		caller, _ = d.Numbers.Normalize(caller)
+	}
+	best := -1
+	for i, rule := range d.rules {
+		if !strings.HasPrefix(number, rule.Prefix) || !strings.HasPrefix(caller, rule.CallerPrefix) {
+			continue
+		}
+		if d.patterns[i] != nil && !d.patterns[i].MatchString(number) {
+			continue
+		}
+		if best < 0 || len(rule.Prefix) > len(d.rules[best].Prefix) ||
+			len(rule.Prefix) == len(d.rules[best].Prefix) && len(rule.CallerPrefix) > len(d.rules[best].CallerPrefix) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return DialMatch{Number: number}, false
+	}
+	rule := d.rules[best]
+	translated := number
+	if d.patterns[best] != nil && rule.Replace != "" {
+		translated = d.patterns[best].ReplaceAllString(number, rule.Replace)
+	} else {
+		if rule.Strip >= len(translated) {
+			translated = ""
+		} else {
+			translated = translated[rule.Strip:]
+		}
+		translated = rule.Prepend + translated
+	}
+	return DialMatch{Rule: rule.Name, Route: rule.Route, Number: number, Translated: translated}, true
+}
+
+// Route routes a request by the number of its Request-URI, dialed by the
+// number of its From, and rewrites it for the route: the Request-URI gets
+// the translated number, the To the number normalized, and a From with a
+// number is normalized too. Requests whose Request-URI has no number, or
+// that no rule matches, are left as they are, with ok false
+func (d *DialPlan) Route(request Message) (match DialMatch, ok bool) {
+	number, ok := dialedNumber(request.Uri())
+	if !ok {
+		return match, false
+	}
+	headers := request.Headers()
+	caller := ""
+	if headers.From != nil {
+		caller, _ = dialedNumber(headers.From.Uri())
+	}
+	if match, ok = d.Match(number, caller); !ok {
+		return match, false
+	}
+	if setter, ok := request.(interface{ SetUri(string) }); ok {
+		if start, end, ok := uriPart(request.Uri(), "user"); ok {
+			setter.SetUri(request.Uri()[:start] + match.Translated + request.Uri()[end:])
+		}
+	}
+	if headers.To != nil {
+		headers.To.SetUri(d.Numbers.NormalizeUri(headers.To.Uri()))
+	}
+	if headers.From != nil && caller != "" {
+		headers.From.SetUri(d.Numbers.NormalizeUri(headers.From.Uri()))
+	}
+	return match, true
+}
@@ -0,0 +1,63 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberPlan(t *testing.T) {
+	plan := NumberPlan{CountryCode: "44", InternationalPrefix: "00", TrunkPrefix: "0", AreaCode: "20", SubscriberLength: 8}
+	for dialed, expected := range map[string]string{
+		"020 7946 0000":   "+442079460000",
+		"0033 1 23456789": "+33123456789",
+		"+1 (555) 0100":   "+15550100",
+		"7946-0000":       "+442079460000",
+		"999":             "999",
+		"+":               "+",
+	} {
+		e164, ok := plan.Normalize(dialed)
+		assert.Equal(t, expected, e164, dialed)
+		assert.Equal(t, expected != dialed && expected != "+", ok, dialed)
+	}
+	assert.Equal(t, "sip:+442079460000@gw.example.com:5060;user=phone;transport=udp", plan.NormalizeUri("sip:02079460000@gw.example.com:5060;transport=udp"))
+	assert.Equal(t, "tel:+442079460000;phone-context=example.com", plan.NormalizeUri("tel:020-7946-0000;phone-context=example.com"))
+	assert.Equal(t, "sip:alice@atlanta.com", plan.NormalizeUri("sip:alice@atlanta.com"))
+}
+
+func TestDialPlan(t *testing.T) {
+	_, err := NewDialPlan(NumberPlan{}, []DialRule{{Name: "bad", Pattern: "("}})
+	assert.NotNil(t, err)
+	plan, err := NewDialPlan(NumberPlan{CountryCode: "44", InternationalPrefix: "00", TrunkPrefix: "0"}, []DialRule{
+		{Name: "international", Prefix: "+", Route: "carrier-a"},
+		{Name: "national", Prefix: "+44", Strip: 3, Prepend: "0", Route: "carrier-b"},
+		{Name: "mobile", Prefix: "+447", Route: "mobile-gw"},
+		{Name: "emergency", Pattern: `^(999|112)$`, Replace: "sos-$1", Route: "psap"},
+		{Name: "branch", Prefix: "+44", CallerPrefix: "+44161", Route: "manchester-gw"},
+	})
+	assert.Nil(t, err)
+
+	match, ok := plan.Match("00 33 1 23456789", "")
+	assert.True(t, ok)
+	assert.Equal(t, DialMatch{Rule: "international", Route: "carrier-a", Number: "+33123456789", Translated: "+33123456789"}, match)
+	match, _ = plan.Match("020 7946 0000", "")
+	assert.Equal(t, DialMatch{Rule: "national", Route: "carrier-b", Number: "+442079460000", Translated: "02079460000"}, match)
+	match, _ = plan.Match("07700 900123", "")
+	assert.Equal(t, "mobile", match.Rule)
+	match, _ = plan.Match("999", "")
+	assert.Equal(t, DialMatch{Rule: "emergency", Route: "psap", Number: "999", Translated: "sos-999"}, match)
+	_, ok = plan.Match("123", "")
+	assert.False(t, ok)
+
+	// routing rewrites the request for the route chosen
+	invite, _ := NewInvite().To("sip:02079460000@pbx.example.com").FromUri("sip:01614960000@pbx.example.com").Build()
+	match, ok = plan.Route(invite)
+	assert.True(t, ok)
+	assert.Equal(t, DialMatch{Rule: "branch", Route: "manchester-gw", Number: "+442079460000", Translated: "+442079460000"}, match)
+	assert.Equal(t, "sip:+442079460000@pbx.example.com", invite.Uri())
+	assert.Equal(t, "sip:+442079460000@pbx.example.com;user=phone", invite.Headers().To.Uri())
+	assert.Equal(t, "sip:+441614960000@pbx.example.com;user=phone", invite.Headers().From.Uri())
+	invite, _ = NewInvite().To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	_, ok = plan.Route(invite)
+	assert.False(t, ok)
+}
@@ -0,0 +1,190 @@
+package slurp
+
+/*
+Display names are the friendly part of the From, To and Contact, e.g.
+"Bob" in "Bob" <sip:bob@biloxi.com>. Inside quotes they may hold any
+UTF-8 (RFC 3261 25.1), which is kept as received and sent as given.
+International deployments also meet peers sending RFC 2047 encoded words
+instead, e.g. =?UTF-8?B?Sm9zw6k=?=, or 8-bit names in ISO-8859-1; how
+those are decoded, and whether we send encoded words ourselves, is set
+by DisplayNames.
+*/
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// DisplayNameOptions control the decoding and encoding of display names
+type DisplayNameOptions struct {
+	// DecodeWords decodes the RFC 2047 encoded words of received display
+	// names
+	DecodeWords bool
+	// EncodeWords sends display names that aren't plain ASCII as RFC 2047
+	// encoded words, for peers that can't handle UTF-8
+	EncodeWords bool
+	// Latin1 decodes received display names that aren't valid UTF-8 as
+	// ISO-8859-1, which some older peers send
+	Latin1 bool
+}
+
+// DisplayNames applies to DisplayName and SetDisplayName. Messages keep
+// the display names as received either way, so they are relayed unchanged
+var DisplayNames = DisplayNameOptions{}
+
+var wordDecoder = &mime.WordDecoder{}
+
+// DisplayName returns the display name of a From, To or Contact, without
+// its quotes and escapes, decoded as DisplayNames says
+func DisplayName(h AddressHeader) string {
+	name := unquoteDisplayName(h.Value())
+	if DisplayNames.Latin1 && !utf8.ValidString(name) {
+		name = latin1ToUTF8(name)
+	}
+	if DisplayNames.DecodeWords && strings.Contains(name, "=?") {
+		if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+			name = decoded
+		}
+	}
+	return name
+}
+
+// SetDisplayName sets the display name of a From, To or Contact, quoting
+// it when it isn't a sequence of tokens, and encoding it as DisplayNames
+// says. Encoded words are quoted too, as SIP tokens can't hold their = and ?
+func SetDisplayName(h AddressHeader, name string) AddressHeader {
+	if DisplayNames.EncodeWords && !isASCII(name) {
+		name = mime.QEncoding.Encode("utf-8", name)
+	}
+	return h.SetValue(QuoteDisplayName(name))
+}
+
+// QuoteDisplayName returns name as it goes in a name-addr: as it is when
+// it is a sequence of tokens, and as a quoted-string otherwise
+func QuoteDisplayName(name string) string {
+	if name == "" {
+		return ""
+	}
+	if isTokens(name) {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		if name[i] == '"' || name[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(name[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquoteDisplayName removes the quotes and escapes of a display name
+func unquoteDisplayName(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// isTokens reports whether name is tokens separated by spaces, which
+// needn't be quoted (RFC 3261 25.1)
+func isTokens(name string) bool {
+	for _, word := range strings.Split(name, " ") {
+		if word == "" {
+			return false
+		}
+		for i := 0; i < len(word); i++ {
+			c := word[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+				strings.IndexByte("-.!%*_+`'~", c) >= 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// latin1ToUTF8 converts an ISO-8859-1 string, whose bytes are the code
+// points, to UTF-8
+func latin1ToUTF8(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
+
+// splitNameAddr splits a From, To or Contact value into its display name,
+// URI and parameters, which follow the URI without the leading semicolon.
+// Angle brackets and semicolons within the quoted display name don't
+// count. A value without angle brackets is an addr-spec, whose parameters
+// are the header's
+func splitNameAddr(value string) (name, uri, params string) {
+	value = strings.TrimSpace(value)
+	open := indexUnquoted(value, '<')
+	if open < 0 {
+		uri, params, _ = strings.Cut(value, ";")
+		return "", strings.TrimSpace(uri), strings.TrimSpace(params)
+	}
+	name, rest := strings.TrimSpace(value[:open]), value[open+1:]
+	uri, params, _ = strings.Cut(rest, ">")
+	params = strings.TrimPrefix(strings.TrimSpace(params), ";")
+	return name, strings.TrimSpace(uri), strings.TrimSpace(params)
+}
+
+// indexUnquoted returns the index of the first c outside a quoted-string
+// and angle brackets, or -1
+func indexUnquoted(value string, c byte) int {
+	quoted, bracketed := false, false
+	for i := 0; i < len(value); i++ {
+		switch {
+		case quoted && value[i] == '\\':
+			i++
+		case value[i] == '"' && !bracketed:
+			quoted = !quoted
+		case quoted:
+		case value[i] == c:
+			return i
+		case value[i] == '<':
+			bracketed = true
+		case value[i] == '>':
+			bracketed = false
+		}
+	}
+	return -1
+}
+
+// splitUnquoted splits value at each sep outside a quoted-string and
+// angle brackets, e.g. the Contacts of one header line
+func splitUnquoted(value string, sep byte) []string {
+	var parts []string
+	for {
+		i := indexUnquoted(value, sep)
+		if i < 0 {
+			return append(parts, value)
+		}
+		parts = append(parts, value[:i])
+		value = value[i+1:]
+	}
+}
@@ -0,0 +1,48 @@
+package slurp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayNames(t *testing.T) {
+	m, err := ParseMessage(strings.Join([]string{
+		"MESSAGE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"Max-Forwards: 70",
+		"To: \"Bjørn; <Ølsen>\" <sip:bob@biloxi.com>",
+		"From: =?UTF-8?Q?Jos=C3=A9?= <sip:jose@atlanta.com>;tag=1928301774",
+		"Contact: \"Smith, \\\"Al\\\"\" <sip:al@192.0.2.4>;expires=60, sip:al@192.0.2.5",
+		"Call-ID: a84b4c76e66710",
+		"CSeq: 1 MESSAGE",
+		"Content-Length: 0",
+		"", "",
+	}, "\r\n"))
+	assert.Nil(t, err)
+
+	// UTF-8 names survive parsing and rendering unchanged
+	assert.Equal(t, "Bjørn; <Ølsen>", DisplayName(m.Headers().To))
+	assert.Equal(t, "sip:bob@biloxi.com", m.Headers().To.Uri())
+	assert.Contains(t, m.Render(), "\r\nTo: \"Bjørn; <Ølsen>\" <sip:bob@biloxi.com>\r\n")
+	assert.Equal(t, "1928301774", m.Headers().From.Param("tag"))
+	if assert.Len(t, m.Headers().Contacts, 2) {
+		assert.Equal(t, "Smith, \"Al\"", DisplayName(m.Headers().Contacts[0]))
+		assert.Equal(t, "60", m.Headers().Contacts[0].Param("expires"))
+		assert.Equal(t, "sip:al@192.0.2.5", contactUri(m.Headers().Contacts[1]))
+	}
+
+	// encoded words are only decoded when configured
+	assert.Equal(t, "=?UTF-8?Q?Jos=C3=A9?=", DisplayName(m.Headers().From))
+	defer func(options DisplayNameOptions) { DisplayNames = options }(DisplayNames)
+	DisplayNames.DecodeWords = true
+	assert.Equal(t, "José", DisplayName(m.Headers().From))
+	DisplayNames.Latin1 = true
+	assert.Equal(t, "José", DisplayName(NewHeader(&ToFrom{}).SetValue("\"Jos\xe9\"")))
+
+	assert.Equal(t, "Alice Smith", SetDisplayName(NewHeader(&ToFrom{}), "Alice Smith").Value())
+	assert.Equal(t, "\"José\"", SetDisplayName(NewHeader(&ToFrom{}), "José").Value())
+	DisplayNames.EncodeWords = true
+	assert.Equal(t, "\"=?utf-8?q?Jos=C3=A9?=\"", SetDisplayName(NewHeader(&ToFrom{}), "José").Value())
+}
@@ -1,6 +1,407 @@
 package slurp
 
 /*
-Entities are devices, trunks, proxies. 
+Entities are devices, trunks, proxies.
 They are the top level object for use in interacting with other entities.
-*/
\ No newline at end of file
+*/
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// Direction tells interceptors whether a message is being received or sent
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+// Interceptor inspects or rewrites a message passing through a UserAgent.
+// It returns the message to continue with, which may be a different one.
+// Returning a nil message drops it silently, and returning an error drops
+// it as well, failing Send for outbound messages.
+type Interceptor func(Message, Direction) (Message, error)
+
+// RequestFilter screens requests received by a UserAgent before they are
+// delivered. It returns false to stop the request, along with a response
+// to send back to the source, or nil to drop the request silently
+type RequestFilter func(Incoming) (*Response, bool)
+
+// Incoming is a message received by a UserAgent
+type Incoming struct {
+	Message   Message
+	Source    net.Addr
+	Transport Transport
+}
+
+// UserAgent sends and receives parsed messages over a transport, passing
+// every message through its interceptors in the order they were added
+type UserAgent struct {
+	// Clock used for transaction timers, DefaultClock when nil
+	Clock Clock
+	// RecordSink receives the call detail record of every dialog of the
+	// UserAgent that terminates. Records are discarded when it is nil
+	RecordSink RecordSink
+	// ForkPolicy applies to calls answered by more than one fork, and
+	// OnFork receives the extra calls when it is KeepForks
+	ForkPolicy ForkPolicy
+	OnFork     func(*Call)
+	// MTU is the path MTU, 1500 when zero. Requests within 200 bytes of
+	// it go over the stream transport, when there is one (RFC 3261 18.1.1)
+	MTU          int
+	transport    Transport
+	stream       Transport
+	receivers    sync.WaitGroup
+	mu           sync.RWMutex
+	interceptors []Interceptor
+	filters      []RequestFilter
+	firewall     *Firewall
+	blacklist    *Blacklist
+	throttler    *Throttler
+	failover     bool
+	maxRedirects int
+	incoming     chan Incoming
+	tu           TransactionUser
+	transactions map[string]*clientTransaction
+	// settings Config.Apply may change while running
+	timers       Timers
+	profile      *Profile
+	strict       bool
+	preferUpdate bool
+	// client transactions running, and calls Shutdown waits for
+	inflight int
+	dialogs  *DialogStore
+	draining bool
+	// subscriptions NOTIFYs are matched to, see FindSubscription
+	subscriptions map[*Subscription]bool
+}
+
+// NewUserAgent creates a UserAgent and starts receiving from transport
+func NewUserAgent(transport Transport) *UserAgent {
+	ua := &UserAgent{
+		transport:     transport,
+		incoming:      make(chan Incoming, 64),
+		transactions:  make(map[string]*clientTransaction),
+		dialogs:       NewDialogStore(),
+		subscriptions: make(map[*Subscription]bool),
+	}
+	ua.receivers.Add(1)
+	go ua.receive(transport)
+	go func() {
+		ua.receivers.Wait()
+		close(ua.incoming)
+	}()
+	return ua
+}
+
+// SetStreamTransport gives the UserAgent a connection-oriented transport,
+// e.g. TCP, for requests too large for UDP. It receives from it too, and
+// must be called before the main transport is closed
+func (ua *UserAgent) SetStreamTransport(transport Transport) {
+	ua.mu.Lock()
+	ua.stream = transport
+	ua.mu.Unlock()
+	ua.receivers.Add(1)
+	go ua.receive(transport)
+}
+
+// Use adds an interceptor to the end of the chain
+func (ua *UserAgent) Use(interceptor Interceptor) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.interceptors = append(ua.interceptors, interceptor)
+}
+
+// AddFilter adds a request filter, run after the inbound interceptors
+func (ua *UserAgent) AddFilter(filter RequestFilter) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.filters = append(ua.filters, filter)
+}
+
+// SetFirewall makes the UserAgent screen raw messages with f before
+// parsing them. Rejected messages are dropped without a response
+func (ua *UserAgent) SetFirewall(f *Firewall) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.firewall = f
+}
+
+// SetBlacklist makes the UserAgent skip the targets on b, failing requests
+// for them right away, and add those its requests fail to reach or that
+// answer 503
+func (ua *UserAgent) SetBlacklist(b *Blacklist) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.blacklist = b
+}
+
+// SetThrottler enables overload control: the UserAgent's Via shows it
+// supports it, and requests are throttled as the servers they are sent to
+// ask, failing with a ThrottledError
+func (ua *UserAgent) SetThrottler(t *Throttler) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.throttler = t
+}
+
+// SetFailover makes the UserAgent try every address a name resolves to,
+// in turn, when a request can't be sent, gets no answer or is answered
+// 503, rather than giving up on the first (RFC 3263 4.3)
+func (ua *UserAgent) SetFailover(failover bool) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.failover = failover
+}
+
+// SetMaxRedirects makes the UserAgent follow redirects: a request
+// answered 3xx is retried to the Contacts of the response, by descending
+// q-value, at most max times (RFC 3261 8.1.3.4). Zero, the default,
+// returns redirects to the caller instead
+func (ua *UserAgent) SetMaxRedirects(max int) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.maxRedirects = max
+}
+
+// SetTimers changes the timers used by new transactions
+func (ua *UserAgent) SetTimers(timers Timers) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.timers = timers
+}
+
+// Timers returns the timers used by transactions
+func (ua *UserAgent) Timers() Timers {
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	return ua.timers.withDefaults()
+}
+
+// SetProfile makes the UserAgent apply profile to the requests it sends
+// as transactions, filling in what they lack
+func (ua *UserAgent) SetProfile(profile *Profile) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.profile = profile
+}
+
+// Profile returns the profile applied to requests, which carries the
+// Service-Route learned from registering, or nil
+func (ua *UserAgent) Profile() *Profile {
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	return ua.profile
+}
+
+// SetStrict makes the UserAgent reject received messages that don't pass
+// Validate: requests are answered with 400, and responses dropped
+func (ua *UserAgent) SetStrict(strict bool) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.strict = strict
+}
+
+// filter runs the request filters, answering the request if one stops it
+func (ua *UserAgent) filter(in Incoming) bool {
+	if _, ok := in.Message.(*Response); ok {
+		return true
+	}
+	ua.mu.RLock()
+	filters, draining := ua.filters, ua.draining
+	ua.mu.RUnlock()
+	if draining && in.Message.Headers().To != nil && in.Message.Headers().To.Param("tag") == "" {
+		// a new dialog or transaction, which we no longer take on
+		switch in.Message.Method() {
+		case "ACK":
+			return false
+		case "CANCEL":
+		default:
+			in.Transport.Send(context.Background(), in.Source.String(), []byte(NewResponse(in.Message, 503).Render()))
+			return false
+		}
+	}
+	for _, filter := range filters {
+		if response, ok := filter(in); !ok {
+			if response != nil {
+				in.Transport.Send(context.Background(), in.Source.String(), []byte(response.Render()))
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// intercept passes the message through the chain. A nil message means it was dropped
+func (ua *UserAgent) intercept(m Message, direction Direction) (Message, error) {
+	ua.mu.RLock()
+	interceptors := ua.interceptors
+	ua.mu.RUnlock()
+	for _, interceptor := range interceptors {
+		var err error
+		if m, err = interceptor(m, direction); err != nil || m == nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (ua *UserAgent) receive(transport Transport) {
+	defer ua.receivers.Done()
+	for packet := range transport.Receive() {
+		ua.handlePacket(transport, packet)
+	}
+}
+
+// parsePacket parses a received message. One the parser chokes on fails
+// like any other that doesn't parse, rather than taking the UserAgent
+// down with it, as anyone can send one
+func parsePacket(data string) (m Message, err error) {
+	defer func() {
+		if recover() != nil {
+			Metrics().IncCounter(MetricParseErrors, map[string]string{"method": ""})
+			m, err = nil, InvalidMessageFormatError(strings.SplitN(data, "\n", 2)[0])
+		}
+	}()
+	return ParseMessage(data)
+}
+
+// handlePacket parses a packet received on transport and delivers the
+// message. Panics of the interceptors and handlers aren't recovered, as
+// they are bugs of the application
+func (ua *UserAgent) handlePacket(transport Transport, packet Packet) {
+	ua.mu.RLock()
+	firewall := ua.firewall
+	ua.mu.RUnlock()
+	if firewall != nil {
+		if _, ok := firewall.Check(packet.Data); !ok {
+			packet.Release()
+			return
+		}
+	}
+	data := string(packet.Data)
+	packet.Release()
+	m, err := parsePacket(data)
+	if _, ok := err.(UnsupportedUriSchemeError); ok {
+		if m.Method() != "ACK" {
+			transport.Send(context.Background(), packet.Source.String(), []byte(NewResponse(m, 416).Render()))
+		}
+		Release(m)
+		return
+	}
+	if _, ok := err.(MessageTooLargeError); ok {
+		if response := tooLarge(data); response != nil {
+			transport.Send(context.Background(), packet.Source.String(), []byte(response.Render()))
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	ua.mu.RLock()
+	strict := ua.strict
+	ua.mu.RUnlock()
+	if violations := m.Validate(); strict && len(violations) > 0 {
+		if _, ok := m.(*Response); !ok && m.Method() != "ACK" {
+			response := NewResponse(m, 400)
+			response.reason = "Bad Request (" + violations[0].Error() + ")"
+			transport.Send(context.Background(), packet.Source.String(), []byte(response.Render()))
+		}
+		Release(m)
+		return
+	}
+	if m, _ = ua.intercept(m, Inbound); m == nil {
+		return
+	}
+	if _, ok := m.(*Response); !ok {
+		ua.alias(transport, m, packet.Source)
+	}
+	if response, ok := m.(*Response); ok && ua.deliver(response) {
+		return
+	}
+	if response, ok := m.(*Response); ok && m.Method() == "INVITE" && IsSuccess(response.StatusCode()) {
+		// a 2xx retransmitted after its transaction ended
+		if call := ua.findCall(m); call != nil {
+			if ack := call.Dialog.AckFor(response); ack != nil {
+				ua.Send(context.Background(), call.Addr, ack)
+				Release(m)
+				return
+			}
+		}
+	} else if !ok && m.Method() == "ACK" {
+		if call := ua.findCall(m); call != nil {
+			call.Dialog.ReceiveAck(m)
+		}
+	}
+	in := Incoming{Message: m, Source: packet.Source, Transport: transport}
+	if _, ok := m.(*Response); ok {
+		ua.transactionUser().ResponseReceived(in)
+	} else if ua.filter(in) {
+		ua.transactionUser().RequestReceived(in)
+	}
+
+}
+
+// tooLarge returns the 513 answering a request too large to be parsed,
+// from its header section alone when that is within MaxMessageSize, or
+// nil when it can't be answered
+func tooLarge(data string) *Response {
+	head, _ := splitBody(data)
+	if len(head) > MaxMessageSize {
+		return nil
+	}
+	m, err := parsePacket(head + "\r\n\r\n")
+	if err != nil {
+		return nil
+	}
+	defer Release(m)
+	if _, ok := m.(*Response); ok || m.Method() == "ACK" {
+		return nil
+	}
+	return NewResponse(m, 513)
+}
+
+// aliaser is implemented by connection-oriented transports that can
+// reuse a connection a peer opened for requests to it (RFC 5923)
+type aliaser interface {
+	Alias(sentBy string, source net.Addr) bool
+}
+
+// alias asks the transport to reuse the connection a request came in on
+// when its top Via has the alias parameter
+func (ua *UserAgent) alias(received Transport, request Message, source net.Addr) {
+	transport, ok := received.(aliaser)
+	control := request.Control()
+	if !ok || len(control.Via) == 0 || len(control.ViaParams) == 0 {
+		return
+	}
+	if _, ok := viaParam(control.ViaParams[0], "alias"); ok {
+		transport.Alias(control.Via[0][1], source)
+	}
+}
+
+// Receive returns the channel received messages are delivered on, unless
+// a TransactionUser was set
+func (ua *UserAgent) Receive() <-chan Incoming {
+	return ua.incoming
+}
+
+// Send passes the message through the interceptors and sends it to addr (host:port)
+func (ua *UserAgent) Send(ctx context.Context, addr string, m Message) error {
+	m, err := ua.intercept(m, Outbound)
+	if err != nil || m == nil {
+		return err
+	}
+	return ua.transport.Send(ctx, addr, []byte(m.Render()))
+}
+
+// Transport returns the transport the UserAgent uses
+func (ua *UserAgent) Transport() Transport {
+	return ua.transport
+}
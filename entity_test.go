@@ -0,0 +1,216 @@
+package slurp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, err := ParseMessage("BYE sip:bob@192.0.2.4 SIP/2.0\r\nCall-ID: a84b4c76e66710\r\nCSeq: 231 BYE\r\n\r\n")
+	assert.Nil(t, err)
+	assert.IsType(t, &Request{}, m)
+	assert.Equal(t, "BYE", m.Method())
+	assert.Equal(t, "sip:bob@192.0.2.4", m.Uri())
+	m, err = ParseMessage("SIP/2.0 180 Ringing\r\nCSeq: 1 INVITE\r\n\r\n")
+	assert.Nil(t, err)
+	assert.IsType(t, &Response{}, m)
+	_, err = ParseMessage("garbage")
+	assert.NotNil(t, err)
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, message := range []string{
+		"OPTIONS sip:bob@biloxi.com SIP/2.0\r\nNo colon here\r\n\r\n",
+		"OPTIONS sip:bob@biloxi.com SIP/2.0\r\nCSeq:\r\n\r\n",
+		"OPTIONS sip:bob@biloxi.com SIP/2.0\r\nVia: SIP/2.0/UDP\r\n\r\n",
+		"OPTIONS sip:bob@biloxi.com SIP/2.0\r\nTimestamp:\r\n\r\n",
+		"SIP/2.0 503 Service Unavailable\r\nRetry-After:\r\n\r\n",
+		"SIP/2.0 503 Service Unavailable\r\nRetry-After:  \t \r\n\r\n",
+		"INVITE sip:bob@biloxi.com HTTP\r\n\r\n",
+		"REGISTER sip:biloxi.com HTTP/1.1\r\n\r\n",
+		"SIP/2.0 200 OK\r\nVia: SIP/2.0/UDP\r\n\r\n",
+	} {
+		assert.NotPanics(t, func() {
+			_, err := ParseMessage(message)
+			assert.IsType(t, InvalidMessageFormatError(""), err, message)
+		}, message)
+	}
+	for _, m := range []Message{&Invite{}, &Register{}, &Request{}, &Response{}} {
+		assert.NotPanics(t, func() { assert.NotNil(t, m.Parse("INVITE")) })
+	}
+}
+
+func TestReceiveSurvivesMalformed(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	ua := NewUserAgent(a)
+	conn, err := net.Dial("udp", a.LocalAddr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	request := func(method string) []byte {
+		return []byte(method + " sip:bob@biloxi.com SIP/2.0\r\nCall-ID: a84b4c76e66710\r\nCSeq: 1 " + method + "\r\n\r\n")
+	}
+	for _, packet := range [][]byte{[]byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\nCSeq:\r\n\r\n"), request("OPTIONS")} {
+		_, err = conn.Write(packet)
+		assert.Nil(t, err)
+	}
+	select {
+	case in := <-ua.Receive():
+		assert.Equal(t, "OPTIONS", in.Message.Method())
+	case <-time.After(5 * time.Second):
+		t.Fatal("the UserAgent stopped receiving")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	sender, receiver := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+
+	sender.Use(func(m Message, d Direction) (Message, error) {
+		if m.Method() == "MESSAGE" {
+			return nil, errors.New("rejected")
+		}
+		m.Headers().Subject = "rewritten"
+		return m, nil
+	})
+	var directions []Direction
+	receiver.Use(func(m Message, d Direction) (Message, error) {
+		directions = append(directions, d)
+		return m, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1")
+	request.Control().Via = [][2]string{{"UDP", a.LocalAddr().String()}}
+	assert.NotNil(t, sender.Send(ctx, b.LocalAddr().String(), NewRequest("MESSAGE", "sip:bob@biloxi.com")))
+	assert.Nil(t, sender.Send(ctx, b.LocalAddr().String(), request))
+	select {
+	case incoming := <-receiver.Receive():
+		assert.Equal(t, "OPTIONS", incoming.Message.Method())
+		assert.Equal(t, "rewritten", incoming.Message.Headers().Subject)
+		assert.Equal(t, []Direction{Inbound}, directions)
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+}
+
+func TestInterceptorDrops(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	ua := NewUserAgent(a)
+	ua.Use(func(m Message, d Direction) (Message, error) {
+		return m, nil
+	})
+	ua.Use(func(m Message, d Direction) (Message, error) {
+		if m.Method() == "OPTIONS" {
+			return nil, nil
+		}
+		return m, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// a dropped request has no response, and fails rather than return none
+	response, err := ua.Request(ctx, "127.0.0.1:9", NewRequest("OPTIONS", "sip:bob@biloxi.com"))
+	assert.Nil(t, response)
+	assert.Equal(t, DroppedError{Method: "OPTIONS"}, err)
+	handled := false
+	_, err = ua.RequestWith(ctx, "127.0.0.1:9", NewRequest("OPTIONS", "sip:bob@biloxi.com"), ResponseHandlers{
+		OnSuccess: func(*Response) { handled = true },
+	})
+	assert.Equal(t, DroppedError{Method: "OPTIONS"}, err)
+	assert.False(t, handled)
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	defer func(limit int) { MaxMessageSize = limit }(MaxMessageSize)
+	MaxMessageSize = 1000
+	// the limit is checked before anything is parsed
+	m, err := ParseMessage(strings.Repeat("garbage ", 200))
+	assert.Nil(t, m)
+	assert.Equal(t, MessageTooLargeError{Size: 1600, Limit: 1000}, err)
+
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, _ := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+
+	request := newTestInvite("sip:bob@biloxi.com")
+	request.SetPayload(make([]byte, 1000))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.Request(ctx, b.LocalAddr().String(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, 513, response.StatusCode())
+}
+
+func TestLargeRequestOverStream(t *testing.T) {
+	serverTCP, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	// the server listens on the same port for both transports
+	serverUDP, err := ListenUDP(serverTCP.LocalAddr().String())
+	if err != nil {
+		serverTCP.Close()
+		t.Skip("port taken for UDP")
+	}
+	clientUDP, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	clientTCP, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(clientUDP), NewUserAgent(serverUDP)
+	client.SetStreamTransport(clientTCP)
+	server.SetStreamTransport(serverTCP)
+	defer client.Shutdown(context.Background())
+	defer server.Shutdown(context.Background())
+	go func() {
+		for in := range server.Receive() {
+			in.Transport.Send(context.Background(), in.Source.String(), []byte(NewResponse(in.Message, 200).Render()))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request := newTestInvite("sip:bob@biloxi.com")
+	request.Headers().ContentType = "application/sdp"
+	request.SetPayload(make([]byte, 1400))
+	response, err := client.Request(ctx, serverUDP.LocalAddr().String(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "TCP", request.Control().Via[0][0])
+
+	// small requests stay on UDP
+	request = newTestInvite("sip:bob@biloxi.com")
+	response, err = client.Request(ctx, serverUDP.LocalAddr().String(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "UDP", request.Control().Via[0][0])
+}
@@ -52,3 +52,208 @@ func (e HeaderParseError) Error() string {
 		e.Message,
 	)
 }
+
+/*
+TooManyRedirectsError indicates that a request was redirected
+more times than the configured limit allows
+*/
+type TooManyRedirectsError struct {
+	Limit int
+}
+
+func (e TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("Request redirected more than %d times", e.Limit)
+}
+
+/*
+RedirectLoopError indicates that every target of a redirect
+has already been tried for the same request
+*/
+type RedirectLoopError struct {
+	Targets []string
+}
+
+func (e RedirectLoopError) Error() string {
+	return fmt.Sprintf("Redirect loop detected, all targets already tried: %v", e.Targets)
+}
+
+/*
+RequestPendingError indicates that a re-INVITE can't be started because
+another INVITE transaction is still in progress on the dialog
+*/
+type RequestPendingError struct {
+	CallId string
+}
+
+func (e RequestPendingError) Error() string {
+	return fmt.Sprintf("An INVITE transaction is already pending on dialog %s", e.CallId)
+}
+
+/*
+Violation describes one way in which a message breaks the RFC.
+Validation returns every violation found rather than stopping at the first
+*/
+type Violation struct {
+	Header string
+	Reason string
+}
+
+func (e Violation) Error() string {
+	return fmt.Sprintf("%s: %s", e.Header, e.Reason)
+}
+
+/*
+TimeoutError indicates that a transaction got no final response in time
+*/
+type TimeoutError struct {
+	Method string
+	Branch string
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("%s transaction %s timed out", e.Method, e.Branch)
+}
+
+/*
+TransportError indicates that a message couldn't be sent to Addr, e.g. as
+no connection could be opened to it. A request that fails so ends its
+transaction, and is handled as if it was answered with 408
+*/
+type TransportError struct {
+	Method string
+	Addr   string
+	Err    error
+}
+
+func (e TransportError) Error() string {
+	return fmt.Sprintf("Sending %s to %s: %s", e.Method, e.Addr, e.Err)
+}
+
+func (e TransportError) Unwrap() error {
+	return e.Err
+}
+
+/*
+TransferError indicates that the transferee refused a REFER, or reported
+that the transfer failed
+*/
+type TransferError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e TransferError) Error() string {
+	return fmt.Sprintf("Transfer failed: %d %s", e.StatusCode, e.Reason)
+}
+
+/*
+RejectedError indicates that a request was answered with a failure response
+*/
+type RejectedError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e RejectedError) Error() string {
+	return fmt.Sprintf("Request rejected: %d %s", e.StatusCode, e.Reason)
+}
+
+/*
+UnsupportedUriSchemeError indicates that a Request-URI uses a scheme
+other than those supported, which a server answers with 416
+*/
+type UnsupportedUriSchemeError struct {
+	Scheme string
+}
+
+func (e UnsupportedUriSchemeError) Error() string {
+	return fmt.Sprintf("Unsupported URI scheme: %s", e.Scheme)
+}
+
+/*
+ShuttingDownError indicates that a component is shutting down and
+accepts no new work
+*/
+type ShuttingDownError struct{}
+
+func (e ShuttingDownError) Error() string {
+	return "Shutting down"
+}
+
+/*
+KeepAliveError indicates that a peer didn't answer a CRLF keep-alive ping
+with a pong in time, so the connection to it is considered failed
+*/
+type KeepAliveError struct {
+	Addr string
+}
+
+func (e KeepAliveError) Error() string {
+	return fmt.Sprintf("No keep-alive pong from %s", e.Addr)
+}
+
+/*
+MessageTooLargeError indicates that a message is larger than allowed,
+which a server answers with 513
+*/
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e MessageTooLargeError) Error() string {
+	return fmt.Sprintf("Message of %d bytes is larger than the limit of %d", e.Size, e.Limit)
+}
+
+/*
+IdentityError indicates that the Identity header of a request failed
+verification (RFC 8224), with the status code to answer it with: 428 when
+it is missing, 436 when the credential can't be obtained, 437 when it is
+unsupported, 438 when the signature or claims are wrong, and 403 when it
+is stale
+*/
+type IdentityError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e IdentityError) Error() string {
+	return fmt.Sprintf("Identity verification failed: %d %s", e.StatusCode, e.Reason)
+}
+
+/*
+BlacklistedError indicates that a request wasn't sent as its target
+recently failed and is blacklisted
+*/
+type BlacklistedError struct {
+	Addr string
+}
+
+func (e BlacklistedError) Error() string {
+	return fmt.Sprintf("%s is blacklisted", e.Addr)
+}
+
+/*
+ThrottledError indicates that a request wasn't sent as its target asked
+for fewer requests with overload control (RFC 7339)
+*/
+type ThrottledError struct {
+	Addr      string
+	Reduction int
+}
+
+func (e ThrottledError) Error() string {
+	return fmt.Sprintf("%s is overloaded, throttling %d%% of requests", e.Addr, e.Reduction)
+}
+
+/*
+DroppedError indicates that an interceptor dropped a request before it was
+sent, so that it has no response
+*/
+type DroppedError struct {
+	Method string
+}
+
+func (e DroppedError) Error() string {
+	return fmt.Sprintf("%s dropped by an interceptor", e.Method)
+}
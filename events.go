@@ -0,0 +1,167 @@
+package slurp
+
+/*
+SIP events (RFC 6665) are delivered in NOTIFY requests for subscriptions
+created by SUBSCRIBE. The Event header names the event package, e.g.
+presence or dialog, and Allow-Events advertises the packages a UA supports.
+A SUBSCRIBE for any other package is answered with 489 Bad Event.
+*/
+
+import (
+	"strings"
+	"sync"
+)
+
+// Event is the value of an Event header: an event package, e.g. presence
+// or presence.winfo with a template, and the id distinguishing
+// subscriptions to the same package in one dialog
+type Event struct {
+	Package string
+	Id      string
+}
+
+// ParseEvent reads an Event header value, e.g. dialog;id=1
+func ParseEvent(value string) Event {
+	parts := strings.Split(value, ";")
+	event := Event{Package: strings.TrimSpace(parts[0])}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "id") {
+			event.Id = strings.TrimSpace(value)
+		}
+	}
+	return event
+}
+
+func (e Event) String() string {
+	if e.Id == "" {
+		return e.Package
+	}
+	return e.Package + ";id=" + e.Id
+}
+
+// Is reports whether e and other identify the same subscription: the
+// same package, compared case-insensitively, and the same id
+func (e Event) Is(other Event) bool {
+	return strings.EqualFold(e.Package, other.Package) && e.Id == other.Id
+}
+
+// MessageEvent returns the Event of the message, and false when it has none
+func MessageEvent(m Message) (Event, bool) {
+	value := m.Headers().Extensions.Get("Event")
+	if strings.TrimSpace(value) == "" {
+		return Event{}, false
+	}
+	return ParseEvent(value), true
+}
+
+// SetEvent sets the Event header of the message
+func SetEvent(m Message, event Event) {
+	m.Headers().Extensions.Set("Event", event.String())
+}
+
+// AllowEvents returns the event packages the message's Allow-Events
+// headers list
+func AllowEvents(m Message) []string {
+	return m.Headers().Extensions.GetAll("Allow-Events")
+}
+
+// SetAllowEvents replaces the Allow-Events headers of the message by one
+// listing packages, or removes them when packages is empty
+func SetAllowEvents(m Message, packages []string) {
+	if len(packages) == 0 {
+		m.Headers().Extensions.Remove("Allow-Events")
+		return
+	}
+	m.Headers().Extensions.Set("Allow-Events", strings.Join(packages, ", "))
+}
+
+// EventPackages is the set of event packages a UserAgent supports. It is
+// safe for concurrent use
+type EventPackages struct {
+	mu       sync.RWMutex
+	packages []string
+}
+
+// NewEventPackages creates an EventPackages supporting packages
+func NewEventPackages(packages ...string) *EventPackages {
+	e := &EventPackages{}
+	for _, pkg := range packages {
+		e.Register(pkg)
+	}
+	return e
+}
+
+// Register adds support for an event package
+func (e *EventPackages) Register(pkg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, registered := range e.packages {
+		if strings.EqualFold(registered, pkg) {
+			return
+		}
+	}
+	e.packages = append(e.packages, pkg)
+}
+
+// Supports reports whether the event package was registered
+func (e *EventPackages) Supports(pkg string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, registered := range e.packages {
+		if strings.EqualFold(registered, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Packages returns the registered event packages, in the order they were
+// registered
+func (e *EventPackages) Packages() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]string(nil), e.packages...)
+}
+
+// Interceptor advertises the registered packages in an Allow-Events header
+// on the requests the UserAgent sends, other than ACK and CANCEL, and on
+// its responses to OPTIONS. Messages that have one already are left as is
+func (e *EventPackages) Interceptor() Interceptor {
+	return func(m Message, direction Direction) (Message, error) {
+		if direction != Outbound || m.Headers().Extensions.Get("Allow-Events") != "" {
+			return m, nil
+		}
+		advertise := m.Method() != "ACK" && m.Method() != "CANCEL"
+		if _, ok := m.(*Response); ok {
+			advertise = m.Method() == "OPTIONS"
+		}
+		if advertise {
+			SetAllowEvents(m, e.Packages())
+		}
+		return m, nil
+	}
+}
+
+// Filter answers SUBSCRIBE requests for packages that weren't registered
+// with 489, listing the supported ones in Allow-Events, and those without
+// an Event header with 400
+func (e *EventPackages) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if in.Message.Method() != "SUBSCRIBE" {
+			return nil, true
+		}
+		event, ok := MessageEvent(in.Message)
+		if !ok {
+			response := NewResponse(in.Message, 400)
+			response.reason = "Missing Event Header"
+			return response, false
+		}
+		if e.Supports(event.Package) {
+			return nil, true
+		}
+		response := NewResponse(in.Message, 489)
+		SetAllowEvents(response, e.Packages())
+		return response, false
+	}
+}
@@ -0,0 +1,73 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSubscribe(event string) *Request {
+	request := NewRequest("SUBSCRIBE", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1928301774")
+	request.Control().CallId = "a84b4c76e66710"
+	request.Control().Sequence = 1
+	if event != "" {
+		request.Headers().Extensions.Add("Event", event)
+	}
+	return request
+}
+
+func TestEvent(t *testing.T) {
+	event := ParseEvent("dialog ; id=1")
+	assert.Equal(t, Event{Package: "dialog", Id: "1"}, event)
+	assert.Equal(t, "dialog;id=1", event.String())
+	assert.True(t, event.Is(Event{Package: "Dialog", Id: "1"}))
+	assert.False(t, event.Is(Event{Package: "dialog"}))
+
+	var request Request
+	assert.Nil(t, request.Parse("SUBSCRIBE sip:bob@biloxi.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n"+
+		"To: <sip:bob@biloxi.com>\r\nFrom: <sip:alice@atlanta.com>;tag=1928301774\r\n"+
+		"Call-ID: a84b4c76e66710\r\nCSeq: 1 SUBSCRIBE\r\n"+
+		"o: presence\r\nu: presence, dialog\r\nAllow-Events: refer\r\nContent-Length: 0\r\n\r\n"))
+	event, ok := MessageEvent(&request)
+	assert.True(t, ok)
+	assert.Equal(t, "presence", event.Package)
+	assert.Equal(t, []string{"presence", "dialog", "refer"}, AllowEvents(&request))
+
+	SetAllowEvents(&request, []string{"refer", "dialog"})
+	assert.Contains(t, request.Render(), "Allow-Events: refer, dialog\r\n")
+}
+
+func TestEventPackages(t *testing.T) {
+	packages := NewEventPackages("presence", "dialog")
+	packages.Register("Presence")
+	assert.Equal(t, []string{"presence", "dialog"}, packages.Packages())
+	filter := packages.Filter()
+
+	_, ok := filter(Incoming{Message: newTestSubscribe("presence;id=2")})
+	assert.True(t, ok)
+
+	response, ok := filter(Incoming{Message: newTestSubscribe("message-summary")})
+	assert.False(t, ok)
+	assert.Equal(t, 489, response.StatusCode())
+	assert.Equal(t, []string{"presence", "dialog"}, AllowEvents(response))
+
+	response, ok = filter(Incoming{Message: newTestSubscribe("")})
+	assert.False(t, ok)
+	assert.Equal(t, 400, response.StatusCode())
+
+	// the packages are advertised on requests and answers to OPTIONS
+	intercept := packages.Interceptor()
+	invite := newTestInvite("sip:bob@biloxi.com")
+	_, err := intercept(invite, Outbound)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"presence", "dialog"}, AllowEvents(invite))
+	ack := NewRequest("ACK", "sip:bob@biloxi.com")
+	intercept(ack, Outbound)
+	assert.Empty(t, AllowEvents(ack))
+	answer := NewResponse(NewRequest("OPTIONS", "sip:bob@biloxi.com"), 200)
+	intercept(answer, Outbound)
+	assert.Equal(t, []string{"presence", "dialog"}, AllowEvents(answer))
+}
@@ -0,0 +1,154 @@
+package slurp
+
+/*
+Happy Eyeballs (RFC 8305) keeps call setup from stalling on a broken
+address family: connection attempts to the targets of a name start one
+after the other, a short delay apart and alternating between IPv6 and
+IPv4, and the first to connect wins.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultAttemptDelay is how long a connection attempt runs alone before
+// the next target is tried alongside it, as RFC 8305 recommends
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// dialFunc opens a connection to addr (host:port)
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// isIPv6Target reports whether the host of addr is an IPv6 address
+func isIPv6Target(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// interleaveFamilies orders targets alternating between IPv6 and IPv4,
+// starting with the family of the first, and keeping the order within
+// each family, per RFC 8305 4
+func interleaveFamilies(targets []string) []string {
+	if len(targets) == 0 {
+		return targets
+	}
+	var first, second []string
+	for _, target := range targets {
+		if isIPv6Target(target) == isIPv6Target(targets[0]) {
+			first = append(first, target)
+		} else {
+			second = append(second, target)
+		}
+	}
+	ordered := make([]string, 0, len(targets))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// resolveTargets returns the addresses (host:port) addr resolves to, in
+// the order they should be attempted
+func resolveTargets(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, net.JoinHostPort(ip.String(), port))
+	}
+	return interleaveFamilies(targets), nil
+}
+
+// raceDial connects to the first of targets that answers. Each attempt
+// gets delay before the next starts, or less if it fails. Connections
+// completing after the winner are closed. The target connected to is
+// returned with the connection
+func raceDial(ctx context.Context, clock Clock, delay time.Duration, targets []string, dial dialFunc) (net.Conn, string, error) {
+	if len(targets) == 0 {
+		return nil, "", fmt.Errorf("no targets to connect to")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+	results := make(chan result, len(targets))
+	next := make(chan struct{}, 1)
+	var timer Timer
+	started, pending := 0, 0
+	start := func() {
+		addr := targets[started]
+		started++
+		pending++
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- result{conn, addr, err}
+		}()
+		if timer != nil {
+			timer.Stop()
+		}
+		if started < len(targets) {
+			timer = clock.AfterFunc(delay, func() {
+				select {
+				case next <- struct{}{}:
+				default:
+				}
+			})
+		}
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	start()
+	var err error
+	for pending > 0 {
+		select {
+		case <-next:
+			if started < len(targets) {
+				start()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(late int) {
+					for ; late > 0; late-- {
+						if r := <-results; r.err == nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.addr, nil
+			}
+			err = r.err
+			if started < len(targets) {
+				start()
+			}
+		}
+	}
+	return nil, "", err
+}
@@ -0,0 +1,75 @@
+package slurp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	targets := []string{"[2001:db8::1]:5060", "[2001:db8::2]:5060", "[2001:db8::3]:5060", "192.0.2.1:5060", "192.0.2.2:5060"}
+	assert.Equal(t, []string{
+		"[2001:db8::1]:5060", "192.0.2.1:5060", "[2001:db8::2]:5060", "192.0.2.2:5060", "[2001:db8::3]:5060",
+	}, interleaveFamilies(targets))
+}
+
+func TestRaceDial(t *testing.T) {
+	refused := errors.New("connection refused")
+	// IPv6 is broken: attempts hang until abandoned
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		switch {
+		case isIPv6Target(addr):
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case addr == "192.0.2.1:5060":
+			return nil, refused
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	conn, target, err := raceDial(ctx, DefaultClock, 20*time.Millisecond, []string{"[2001:db8::1]:5060", "192.0.2.2:5060"}, dial)
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+	assert.Equal(t, "192.0.2.2:5060", target)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// a failed attempt moves on to the next target right away
+	start = time.Now()
+	_, target, err = raceDial(ctx, DefaultClock, time.Minute, []string{"192.0.2.1:5060", "192.0.2.2:5060"}, dial)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.2:5060", target)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, _, err = raceDial(ctx, DefaultClock, time.Millisecond, []string{"192.0.2.1:5060"}, dial)
+	assert.Equal(t, refused, err)
+}
+
+func TestTCPSendAny(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	b, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+
+	// nothing listens on the first target
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	dead := closed.Addr().String()
+	closed.Close()
+
+	message := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n"
+	target, err := a.SendAny(context.Background(), []string{dead, b.LocalAddr().String()}, []byte(message))
+	assert.Nil(t, err)
+	assert.Equal(t, b.LocalAddr().String(), target)
+	assert.Equal(t, message, string((<-b.Receive()).Data))
+}
@@ -0,0 +1,49 @@
+package slurp
+
+/*
+Fax calls start as audio and switch to T.38 fax relay once a fax tone is
+detected, with a re-INVITE replacing the audio stream by an image/t38 one
+(ITU-T T.38 Annex D). A peer unable to relay fax rejects it, most often
+with 488, and the fax is sent as G.711 audio instead.
+*/
+
+import (
+	"context"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// SwitchToT38 re-INVITEs the call from audio to T.38 fax relay, offering
+// params on the port of the audio stream in use, and returns the peer's
+// T.38 parameters. When the peer rejects the re-INVITE with 488 or 606,
+// or answers it rejecting the T.38 stream, the call falls back to audio,
+// for G.711 pass-through: ok is false and err nil, and in the latter case
+// the audio session is restored with another re-INVITE
+func (c *Call) SwitchToT38(ctx context.Context, params sdp.T38Params) (remote sdp.T38Params, ok bool, err error) {
+	c.Dialog.mu.Lock()
+	local := c.Dialog.LocalSDP
+	c.Dialog.mu.Unlock()
+	if local == nil {
+		return remote, false, Violation{Header: "Content-Type", Reason: "no session description to switch to T.38"}
+	}
+	offer, ok := local.WithT38(params)
+	if !ok {
+		return remote, false, Violation{Header: "Content-Type", Reason: "no audio stream to switch to T.38"}
+	}
+	answer, err := c.Reinvite(ctx, offer)
+	if err != nil {
+		if rejected, is := err.(RejectedError); is && (rejected.StatusCode == 488 || rejected.StatusCode == 606) {
+			return remote, false, nil
+		}
+		return remote, false, err
+	}
+	for _, media := range answer.Media {
+		if media.IsT38() && media.Port != 0 {
+			remote, err = media.T38()
+			return remote, err == nil, err
+		}
+	}
+	_, err = c.Reinvite(ctx, local)
+	return remote, false, err
+}
@@ -0,0 +1,68 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitchToT38(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	local, remote := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	gateway := sdp.T38Params{MaxBitRate: 9600, MaxDatagram: 160, ErrorCorrection: sdp.T38Redundancy}
+	go func() {
+		invites := 0
+		for in := range remote.Receive() {
+			if in.Message.Method() != "INVITE" {
+				continue
+			}
+			invites++
+			response := NewResponse(in.Message, 488)
+			if invites == 1 {
+				offer, _ := messageSDP(in.Message)
+				offer.Media[0] = sdp.AnswerT38(offer.Media[0], 49172, gateway)
+				response = NewResponse(in.Message, 200)
+				response.Headers().ContentType = "application/sdp"
+				response.SetPayload([]byte(offer.Render()))
+			}
+			remote.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+
+	_, dialog := exampleDialog(t, true)
+	call := NewCall(local, dialog, b.LocalAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = call.SwitchToT38(ctx, sdp.DefaultT38Params)
+	assert.NotNil(t, err, "there's no audio session to switch yet")
+
+	audio, err := sdp.Parse("v=0\r\no=alice 1 2 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\n")
+	assert.Nil(t, err)
+	dialog.LocalSDP = audio
+	params, ok, err := call.SwitchToT38(ctx, sdp.DefaultT38Params)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 9600, params.MaxBitRate)
+	assert.Equal(t, sdp.TransferredTCF, params.RateManagement)
+	assert.True(t, dialog.LocalSDP.Media[0].IsT38())
+	assert.Equal(t, "3", dialog.LocalSDP.Origin.SessionVersion)
+	assert.Equal(t, 49172, dialog.RemoteSDP.Media[0].Port)
+
+	// a gateway rejecting T.38 leaves the call on audio
+	dialog.LocalSDP = audio
+	_, ok, err = call.SwitchToT38(ctx, sdp.DefaultT38Params)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, audio, dialog.LocalSDP)
+}
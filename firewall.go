@@ -0,0 +1,110 @@
+package slurp
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// Reasons a Firewall rejects a message, used as counter keys
+const (
+	RejectedSize      = "size"
+	RejectedNullByte  = "null-byte"
+	RejectedHeaders   = "header-count"
+	RejectedUserAgent = "user-agent"
+	RejectedNoHeaders = "malformed"
+)
+
+// MetricFirewallRejected counts messages rejected by a Firewall, labeled by reason
+const MetricFirewallRejected = "slurp_firewall_rejected_total"
+
+// DefaultBlockedUserAgents are User-Agent substrings of well known SIP scanners
+var DefaultBlockedUserAgents = []string{
+	"friendly-scanner", "sipvicious", "sipcli", "sip-scan", "sundayddr",
+	"iWar", "VaxSIPUserAgent", "pplsip", "SIPScan",
+}
+
+// Firewall rejects obviously malicious traffic before it is parsed.
+// The zero value accepts everything; use NewFirewall for sane defaults
+type Firewall struct {
+	// Messages longer than MaxSize bytes are rejected, if non-zero
+	MaxSize int
+	// Messages with more than MaxHeaders header lines are rejected, if non-zero
+	MaxHeaders int
+	// AllowNullBytes disables rejecting messages containing NUL
+	AllowNullBytes bool
+	// Messages whose User-Agent contains any of these (case-insensitive) are rejected
+	BlockedUserAgents []string
+	mu                sync.Mutex
+	counts            map[string]int
+}
+
+// NewFirewall creates a Firewall with limits suitable for most deployments
+func NewFirewall() *Firewall {
+	return &Firewall{
+		MaxSize:           65535,
+		MaxHeaders:        128,
+		BlockedUserAgents: DefaultBlockedUserAgents,
+	}
+}
+
+// Check reports whether a raw message should be accepted. When it is
+// rejected, the reason is returned and counted
+func (f *Firewall) Check(data []byte) (string, bool) {
+	reason := f.check(data)
+	if reason == "" {
+		return "", true
+	}
+	f.mu.Lock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	f.counts[reason]++
+	f.mu.Unlock()
+	Metrics().IncCounter(MetricFirewallRejected, map[string]string{"reason": reason})
+	return reason, false
+}
+
+func (f *Firewall) check(data []byte) string {
+	if f.MaxSize > 0 && len(data) > f.MaxSize {
+		return RejectedSize
+	}
+	if !f.AllowNullBytes && bytes.IndexByte(data, 0) >= 0 {
+		return RejectedNullByte
+	}
+	head := data
+	if end := bytes.Index(data, []byte("\r\n\r\n")); end >= 0 {
+		head = data[:end]
+	}
+	lines := strings.Split(string(head), "\n")
+	if len(lines) < 2 {
+		return RejectedNoHeaders
+	}
+	if f.MaxHeaders > 0 && len(lines)-1 > f.MaxHeaders {
+		return RejectedHeaders
+	}
+	for _, line := range lines[1:] {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "user-agent") {
+			continue
+		}
+		agent := strings.ToLower(parts[1])
+		for _, blocked := range f.BlockedUserAgents {
+			if strings.Contains(agent, strings.ToLower(blocked)) {
+				return RejectedUserAgent
+			}
+		}
+	}
+	return ""
+}
+
+// Counts returns how many messages were rejected, by reason
+func (f *Firewall) Counts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int, len(f.counts))
+	for k, v := range f.counts {
+		counts[k] = v
+	}
+	return counts
+}
@@ -0,0 +1,30 @@
+package slurp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirewall(t *testing.T) {
+	firewall := NewFirewall()
+	firewall.MaxHeaders = 3
+	valid := "OPTIONS sip:100@192.0.2.1 SIP/2.0\r\nVia: SIP/2.0/UDP 192.0.2.9\r\nUser-Agent: slurp\r\n\r\n"
+	_, ok := firewall.Check([]byte(valid))
+	assert.True(t, ok)
+
+	cases := map[string]string{
+		RejectedUserAgent: strings.Replace(valid, "slurp", "Friendly-Scanner", 1),
+		RejectedNullByte:  strings.Replace(valid, "slurp", "slu\x00rp", 1),
+		RejectedHeaders:   strings.Replace(valid, "\r\n\r\n", "\r\nA: 1\r\nB: 2\r\n\r\n", 1),
+		RejectedSize:      valid + strings.Repeat("x", 65535),
+		RejectedNoHeaders: "OPTIONS",
+	}
+	for expected, message := range cases {
+		reason, ok := firewall.Check([]byte(message))
+		assert.False(t, ok)
+		assert.Equal(t, expected, reason)
+	}
+	assert.Equal(t, 1, firewall.Counts()[RejectedUserAgent])
+}
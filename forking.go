@@ -0,0 +1,283 @@
+package slurp
+
+/*
+A proxy forwards a request for an address-of-record to the contacts bound
+to it (RFC 3261 16.6). When there are several, the Forker tries them by
+q-value: every contact of the highest q-value at once, then, if none of
+them accepted, those of the next one, and so on. The best of the final
+responses is returned upstream (RFC 3261 16.7).
+*/
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// ForkGroups groups bindings by q-value, from the highest to the lowest,
+// so that a contact registered with q=0 is tried last. The bindings of a
+// group keep their relative order
+func ForkGroups(bindings []Binding) [][]Binding {
+	sorted := append([]Binding(nil), bindings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Q > sorted[j].Q
+	})
+	var groups [][]Binding
+	for i, binding := range sorted {
+		if i == 0 || binding.Q != sorted[i-1].Q {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], binding)
+	}
+	return groups
+}
+
+// bestResponse chooses the final response to forward among those of the
+// branches: a 6xx when there is one, else one of the lowest class. A 503
+// is forwarded as 500, as it is about the next hop rather than us
+func bestResponse(request Message, responses []*Response) *Response {
+	var best *Response
+	for _, response := range responses {
+		switch {
+		case best == nil:
+			best = response
+		case best.Class() == GlobalFailure:
+		case response.Class() == GlobalFailure || response.Class() < best.Class():
+			best = response
+		}
+	}
+	if best == nil {
+		return NewResponse(request, 480)
+	}
+	if best.StatusCode() == 503 {
+		return NewResponse(request, 500)
+	}
+	return best
+}
+
+// Forker forwards requests for an AOR to its bindings in Locations. It is
+// safe for concurrent use
+type Forker struct {
+	Locations LocationStore
+	// GroupTimeout is how long the contacts of a q-value are tried before
+	// moving on to the next, cancelling them. They are waited for until
+	// they answer when zero
+	GroupTimeout time.Duration
+	// Clock used for GroupTimeout, DefaultClock when nil
+	Clock   Clock
+	ua      *UserAgent
+	mu      sync.Mutex
+	forking map[string]context.CancelFunc
+}
+
+// NewForker creates a Forker forwarding requests through ua to the
+// bindings in locations
+func NewForker(ua *UserAgent, locations LocationStore) *Forker {
+	return &Forker{Locations: locations, ua: ua, forking: make(map[string]context.CancelFunc)}
+}
+
+// pushVia adds our Via on top of those of a received request, with a new
+// branch, keeping the branch of the previous hop with its parameters
+func (ua *UserAgent) pushVia(request Message) {
+	control := request.Control()
+	if len(control.Via) == 0 {
+		// a request of our own, addVia gives it one
+		control.ViaBranch = ""
+		return
+	}
+	params := append([]string(nil), control.ViaParams...)
+	for len(params) < len(control.Via) {
+		params = append(params, "")
+	}
+	previous := "branch=" + control.ViaBranch
+	if params[0] != "" {
+		previous += ";" + params[0]
+	}
+	params[0] = previous
+	control.Via = append([][2]string{{ua.transport.Network(), ua.transport.PublicAddr().String()}}, control.Via...)
+	control.ViaParams = append([]string{""}, params...)
+	control.ViaBranch = NewBranch()
+}
+
+// popVia removes our Via from a response to a request pushVia forwarded,
+// so that it can be sent to the previous hop
+func popVia(response *Response) {
+	control := response.Control()
+	if len(control.Via) < 2 {
+		return
+	}
+	params := control.ViaParams
+	for len(params) < len(control.Via) {
+		params = append(params, "")
+	}
+	control.Via = control.Via[1:]
+	control.ViaParams = params[1:]
+	control.ViaBranch, control.ViaParams[0] = extractBranch(control.ViaParams[0])
+}
+
+// Fork forwards request to the bindings of the AOR in its Request-URI, by
+// q-value, and returns the final response to send upstream. provisional,
+// when not nil, is called with the provisional responses other than 100.
+// Forking stops with the first 2xx, cancelling the other branches, or the
+// first 6xx. Bindings on the UserAgent's Blacklist are skipped, and an AOR
+// without bindings left to try is answered with 480
+func (f *Forker) Fork(ctx context.Context, request Message, provisional func(*Response)) (*Response, error) {
+	bindings, err := f.Locations.Bindings(AddressOfRecord(request.Uri()))
+	if err != nil {
+		return nil, err
+	}
+	var finals []*Response
+	for _, group := range ForkGroups(bindings) {
+		accepted, responses := f.forkGroup(ctx, request, group, provisional)
+		if accepted != nil {
+			return accepted, nil
+		}
+		finals = append(finals, responses...)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, response := range responses {
+			if response.Class() == GlobalFailure {
+				return response, nil
+			}
+		}
+	}
+	return bestResponse(request, finals), nil
+}
+
+// forkGroup forwards request to each binding of a group at once. It
+// returns the first 2xx, or else the final responses of the branches
+func (f *Forker) forkGroup(ctx context.Context, request Message, group []Binding, provisional func(*Response)) (*Response, []*Response) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if f.GroupTimeout > 0 {
+		timer := clockOrDefault(f.Clock).AfterFunc(f.GroupTimeout, cancel)
+		defer timer.Stop()
+	}
+	return f.ua.forward(ctx, request, group, nil, nil, provisional)
+}
+
+// forward sends a copy of request to each binding at once, the i-th
+// cancelled after timeouts[i] unless it is zero or missing, and returns
+// the first 2xx, or else the final responses of the branches. Branches
+// cancelled or unanswered have none. The first 2xx or 6xx cancels the
+// other branches
+func (ua *UserAgent) forward(ctx context.Context, request Message, bindings []Binding, timeouts []time.Duration, clock Clock, provisional func(*Response)) (*Response, []*Response) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var accepted *Response
+	var finals []*Response
+	var wg sync.WaitGroup
+	for i, binding := range bindings {
+		branch := Clone(request)
+		TargetBinding(branch, binding)
+		ua.pushVia(branch)
+		branchCtx, cancelBranch := context.WithCancel(ctx)
+		if i < len(timeouts) && timeouts[i] > 0 {
+			timer := clockOrDefault(clock).AfterFunc(timeouts[i], cancelBranch)
+			defer timer.Stop()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancelBranch()
+			response, err := ua.RequestWith(branchCtx, "", branch, ResponseHandlers{
+				OnProvisional: func(response *Response) {
+					if provisional != nil && response.StatusCode() != 100 {
+						popVia(response)
+						provisional(response)
+					}
+				},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if _, ok := err.(TimeoutError); ok {
+					finals = append(finals, NewResponse(request, 408))
+				}
+				return
+			}
+			popVia(response)
+			switch {
+			case IsSuccess(response.StatusCode()):
+				if accepted == nil {
+					accepted = response
+					cancel()
+				}
+			case response.Class() == GlobalFailure:
+				finals = append(finals, response)
+				cancel()
+			default:
+				finals = append(finals, response)
+			}
+		}()
+	}
+	wg.Wait()
+	return accepted, finals
+}
+
+// Filter forwards the requests whose Request-URI is an AOR with bindings,
+// sending the provisional and final responses back to the sender, and
+// answers the CANCELs for them. Other requests go on to the UserAgent
+func (f *Forker) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		request := in.Message
+		key := ServerTransactionKey(request)
+		switch request.Method() {
+		case "CANCEL":
+			f.mu.Lock()
+			cancel, ok := f.forking[CancelledTransactionKey(request)]
+			f.mu.Unlock()
+			if !ok {
+				return nil, true
+			}
+			cancel()
+			return NewResponse(request, 200), false
+		}
+		bindings, err := f.Locations.Bindings(AddressOfRecord(request.Uri()))
+		if err != nil {
+			return NewResponse(request, 500), false
+		}
+		if len(bindings) == 0 {
+			return nil, true
+		}
+		if request.Method() == "ACK" {
+			// the ACKs for failure responses we forwarded end at us, hop by
+			// hop, while those for 2xx go to the contact that answered
+			return nil, false
+		}
+		if response := DecrementMaxForwards(request); response != nil {
+			return response, false
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		f.mu.Lock()
+		f.forking[key] = cancel
+		f.mu.Unlock()
+		source := in.Source.String()
+		go func() {
+			defer func() {
+				cancel()
+				f.mu.Lock()
+				delete(f.forking, key)
+				f.mu.Unlock()
+			}()
+			response, err := f.Fork(ctx, request, func(response *Response) {
+				f.ua.Send(context.Background(), source, response)
+			})
+			if err == context.Canceled {
+				response = NewResponse(request, 487)
+			} else if err != nil {
+				response = NewResponse(request, 500)
+			}
+			f.ua.Send(context.Background(), source, response)
+		}()
+		if request.Method() == "INVITE" {
+			return NewResponse(request, 100), false
+		}
+		return nil, false
+	}
+}
@@ -0,0 +1,105 @@
+package slurp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkGroups(t *testing.T) {
+	groups := ForkGroups([]Binding{
+		{Contact: "sip:bob@192.0.2.1", Q: 0.5},
+		{Contact: "sip:bob@192.0.2.2", Q: 0},
+		{Contact: "sip:bob@192.0.2.3", Q: 0.5},
+		{Contact: "sip:bob@192.0.2.4", Q: 1},
+		{Contact: "sip:bob@192.0.2.5", Q: 1},
+	})
+	assert.Len(t, groups, 3)
+	assert.Equal(t, []Binding{{Contact: "sip:bob@192.0.2.4", Q: 1}, {Contact: "sip:bob@192.0.2.5", Q: 1}}, groups[0])
+	assert.Equal(t, []Binding{{Contact: "sip:bob@192.0.2.1", Q: 0.5}, {Contact: "sip:bob@192.0.2.3", Q: 0.5}}, groups[1])
+	// q=0 is the lowest preference, not a missing q-value
+	assert.Equal(t, []Binding{{Contact: "sip:bob@192.0.2.2", Q: 0}}, groups[2])
+}
+
+func TestFork(t *testing.T) {
+	p, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer p.Close()
+	proxy := NewUserAgent(p)
+	locations := NewMemoryLocations()
+	forker := NewForker(proxy, locations)
+
+	// each contact answers with its code, recording the order it was tried in
+	tried := make(chan string, 4)
+	contact := func(code int) string {
+		c, err := ListenUDP("127.0.0.1:0")
+		assert.Nil(t, err)
+		t.Cleanup(func() { c.Close() })
+		ua := NewUserAgent(c)
+		uri := "sip:bob@" + c.LocalAddr().String()
+		go func() {
+			for in := range ua.Receive() {
+				tried <- uri
+				ua.Send(context.Background(), in.Source.String(), NewResponse(in.Message, code))
+			}
+		}()
+		return uri
+	}
+	busy, answering, declining := contact(486), contact(200), contact(603)
+
+	message := func() Message {
+		m, err := ParseMessage(strings.Join([]string{
+			"MESSAGE sip:bob@biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds;received=192.0.2.101",
+			"Max-Forwards: 70",
+			"To: <sip:bob@biloxi.com>",
+			"From: <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: " + generateTag(),
+			"CSeq: 1 MESSAGE",
+			"Content-Length: 0",
+			"", "",
+		}, "\r\n"))
+		assert.Nil(t, err)
+		return m
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the higher q-value is tried first, then the next when it fails
+	locations.Store("sip:bob@biloxi.com", Binding{Contact: busy, Q: 1, Expires: time.Now().Add(time.Hour)})
+	locations.Store("sip:bob@biloxi.com", Binding{Contact: answering, Q: 0.5, Expires: time.Now().Add(time.Hour)})
+	response, err := forker.Fork(ctx, message(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, busy, <-tried)
+	assert.Equal(t, answering, <-tried)
+	// the response goes back to the previous hop
+	assert.Len(t, response.Control().Via, 1)
+	assert.Equal(t, "z9hG4bK776asdhds", response.Control().ViaBranch)
+	assert.Equal(t, "received=192.0.2.101", response.Control().ViaParams[0])
+
+	// a 6xx stops the forking
+	locations.Store("sip:bob@biloxi.com", Binding{Contact: declining, Q: 0.8, Expires: time.Now().Add(time.Hour)})
+	locations.Remove("sip:bob@biloxi.com", busy)
+	response, err = forker.Fork(ctx, message(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 603, response.StatusCode())
+	assert.Equal(t, declining, <-tried)
+	select {
+	case uri := <-tried:
+		t.Errorf("%s was tried after a 6xx", uri)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// an AOR without bindings
+	m := message()
+	m.(*Request).SetUri("sip:carol@chicago.com")
+	response, err = forker.Fork(ctx, m, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 480, response.StatusCode())
+}
@@ -0,0 +1,312 @@
+package slurp
+
+/*
+Emergency calls are sent to a service URN, urn:service:sos (RFC 5031),
+rather than a number, and carry the caller's location so they can be
+routed to the right PSAP. The Geolocation header (RFC 6442) points at the
+location, either by reference or by value as a PIDF-LO document (RFC 4119,
+RFC 5491) in the body, and Geolocation-Routing tells proxies whether they
+may route on it.
+*/
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmergencyURN is the service URN of emergency calls
+const EmergencyURN = "urn:service:sos"
+
+// PIDFContentType is the content type of PIDF-LO location bodies
+const PIDFContentType = "application/pidf+xml"
+
+// IsServiceURN reports whether uri is a service URN, e.g. urn:service:sos.police
+func IsServiceURN(uri string) bool {
+	return strings.HasPrefix(strings.ToLower(addrSpec(uri)), "urn:service:")
+}
+
+// IsEmergency reports whether uri is urn:service:sos or one of its
+// sub-services, e.g. urn:service:sos.fire
+func IsEmergency(uri string) bool {
+	uri = strings.ToLower(addrSpec(uri))
+	return uri == EmergencyURN || strings.HasPrefix(uri, EmergencyURN+".")
+}
+
+// Geolocation returns the location URIs of the message's Geolocation
+// headers: cid: URIs for locations in the body, and others, e.g. https:,
+// for locations to be dereferenced
+func Geolocation(m Message) []string {
+	var uris []string
+	for _, value := range m.Headers().Extensions.GetAll("Geolocation") {
+		uris = append(uris, addrSpec(value))
+	}
+	return uris
+}
+
+// GeolocationRouting reports whether the message's location may be used
+// for routing. Without a Geolocation-Routing header it may not be
+func GeolocationRouting(m Message) bool {
+	return strings.EqualFold(strings.TrimSpace(m.Headers().Extensions.Get("Geolocation-Routing")), "yes")
+}
+
+// Point is a WGS 84 position in degrees. A non-zero Radius, in meters,
+// makes it the center of a circle of uncertainty
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// CivicAddress is a postal location, with the elements of RFC 5139
+type CivicAddress struct {
+	// Country is the ISO 3166 code, e.g. US
+	Country string `xml:"country,omitempty"`
+	// A1 is the state or province, A3 the city
+	A1 string `xml:"A1,omitempty"`
+	A3 string `xml:"A3,omitempty"`
+	// RD is the road, HNO the house number, and PC the postal code
+	RD  string `xml:"RD,omitempty"`
+	HNO string `xml:"HNO,omitempty"`
+	PC  string `xml:"PC,omitempty"`
+	// LOC is additional information, e.g. a room
+	LOC string `xml:"LOC,omitempty"`
+}
+
+// Location is a PIDF-LO document, with a geodetic or civic location or both
+type Location struct {
+	// Entity is the target of the location, e.g. pres:alice@atlanta.com
+	Entity string
+	Point  *Point
+	Civic  *CivicAddress
+	// Method is how the location was determined, e.g. GPS or Manual
+	Method string
+	// Timestamp is rendered unless zero
+	Timestamp time.Time
+}
+
+const (
+	pidfNamespace     = "urn:ietf:params:xml:ns:pidf"
+	geoprivNamespace  = "urn:ietf:params:xml:ns:pidf:geopriv10"
+	civicNamespace    = "urn:ietf:params:xml:ns:pidf:geopriv10:civicAddr"
+	geoShapeNamespace = "urn:ietf:params:xml:ns:pidf:geopriv10:geoShape"
+	gmlNamespace      = "http://www.opengis.net/gml"
+	dataNamespace     = "urn:ietf:params:xml:ns:pidf:data-model"
+	wgs84             = "urn:ogc:def:crs:EPSG::4326"
+	meters            = "urn:ogc:def:uom:EPSG::9001"
+)
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func formatDegrees(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Render returns the location as a PIDF-LO document
+func (l *Location) Render() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<presence xmlns=%q xmlns:gp=%q xmlns:ca=%q xmlns:gs=%q xmlns:gml=%q xmlns:dm=%q entity=%q>\n",
+		pidfNamespace, geoprivNamespace, civicNamespace, geoShapeNamespace, gmlNamespace, dataNamespace, xmlEscape(l.Entity))
+	fmt.Fprintf(&b, " <dm:device id=%q>\n  <gp:geopriv>\n   <gp:location-info>\n", generateTag())
+	if p := l.Point; p != nil {
+		pos := formatDegrees(p.Latitude) + " " + formatDegrees(p.Longitude)
+		if p.Radius > 0 {
+			fmt.Fprintf(&b, "    <gs:Circle srsName=%q>\n     <gml:pos>%s</gml:pos>\n     <gs:radius uom=%q>%s</gs:radius>\n    </gs:Circle>\n",
+				wgs84, pos, meters, formatDegrees(p.Radius))
+		} else {
+			fmt.Fprintf(&b, "    <gml:Point srsName=%q>\n     <gml:pos>%s</gml:pos>\n    </gml:Point>\n", wgs84, pos)
+		}
+	}
+	if c := l.Civic; c != nil {
+		b.WriteString("    <ca:civicAddress>\n")
+		for _, element := range [][2]string{
+			{"country", c.Country}, {"A1", c.A1}, {"A3", c.A3}, {"RD", c.RD},
+			{"HNO", c.HNO}, {"LOC", c.LOC}, {"PC", c.PC},
+		} {
+			if element[1] != "" {
+				fmt.Fprintf(&b, "     <ca:%s>%s</ca:%[1]s>\n", element[0], xmlEscape(element[1]))
+			}
+		}
+		b.WriteString("    </ca:civicAddress>\n")
+	}
+	b.WriteString("   </gp:location-info>\n   <gp:usage-rules/>\n")
+	if l.Method != "" {
+		fmt.Fprintf(&b, "   <gp:method>%s</gp:method>\n", xmlEscape(l.Method))
+	}
+	b.WriteString("  </gp:geopriv>\n")
+	if !l.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "  <dm:timestamp>%s</dm:timestamp>\n", l.Timestamp.UTC().Format(time.RFC3339))
+	}
+	b.WriteString(" </dm:device>\n</presence>\n")
+	return b.Bytes()
+}
+
+// geopriv is the location element of PIDF-LO
+type geopriv struct {
+	Info struct {
+		Point *struct {
+			Pos string `xml:"pos"`
+		} `xml:"Point"`
+		Circle *struct {
+			Pos    string  `xml:"pos"`
+			Radius float64 `xml:"radius"`
+		} `xml:"Circle"`
+		Civic *CivicAddress `xml:"civicAddress"`
+	} `xml:"location-info"`
+	Method string `xml:"method"`
+}
+
+// pidf is the part of a PIDF-LO document ParseLocation reads. The
+// location may be in a device or person (RFC 5491), or in the status of
+// a tuple (RFC 4119)
+type pidf struct {
+	Entity   string `xml:"entity,attr"`
+	Elements []struct {
+		Geopriv   *geopriv `xml:"geopriv"`
+		Status    *geopriv `xml:"status>geopriv"`
+		Timestamp string   `xml:"timestamp"`
+	} `xml:",any"`
+}
+
+// ParseLocation reads the first location of a PIDF-LO document
+func ParseLocation(body []byte) (*Location, error) {
+	var doc pidf
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	for _, element := range doc.Elements {
+		geo := element.Geopriv
+		if geo == nil {
+			geo = element.Status
+		}
+		if geo == nil {
+			continue
+		}
+		info := geo.Info
+		if info.Point == nil && info.Circle == nil && info.Civic == nil {
+			continue
+		}
+		location := &Location{Entity: doc.Entity, Civic: info.Civic, Method: strings.TrimSpace(geo.Method)}
+		pos, radius := "", 0.0
+		if info.Point != nil {
+			pos = info.Point.Pos
+		} else if info.Circle != nil {
+			pos, radius = info.Circle.Pos, info.Circle.Radius
+		}
+		if pos != "" {
+			var p Point
+			if _, err := fmt.Sscan(pos, &p.Latitude, &p.Longitude); err != nil {
+				return nil, fmt.Errorf("invalid position %q: %w", pos, err)
+			}
+			p.Radius = radius
+			location.Point = &p
+		}
+		if element.Timestamp != "" {
+			location.Timestamp, _ = time.Parse(time.RFC3339, strings.TrimSpace(element.Timestamp))
+		}
+		return location, nil
+	}
+	return nil, fmt.Errorf("no location in document")
+}
+
+// WithLocation adds the location by value: a Geolocation header points at
+// it in the body, next to any other body in a multipart/mixed one. routing
+// allows proxies to route on the location, as emergency calls need
+func (b *RequestBuilder) WithLocation(location *Location, routing bool) *RequestBuilder {
+	b.location, b.locationRouting = location, routing
+	return b
+}
+
+// attachLocation adds the location to a built request
+func attachLocation(m Message, location *Location, routing bool) error {
+	_, host := uriUserHost(m.Headers().From.Uri())
+	cid := generateTag() + "@" + host
+	headers := m.Headers()
+	headers.Extensions.Add("Geolocation", "<cid:"+cid+">")
+	if routing {
+		headers.Extensions.Set("Geolocation-Routing", "yes")
+	} else {
+		headers.Extensions.Set("Geolocation-Routing", "no")
+	}
+	document := location.Render()
+	if m.Payload() == nil {
+		headers.ContentType = PIDFContentType
+		headers.Extensions.Set("Content-ID", "<"+cid+">")
+		m.SetPayload(document)
+		return nil
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {headers.ContentType}})
+	if err == nil {
+		_, err = part.Write(m.Payload())
+	}
+	if err == nil {
+		part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {PIDFContentType}, "Content-ID": {"<" + cid + ">"}})
+	}
+	if err == nil {
+		_, err = part.Write(document)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+	headers.ContentType = "multipart/mixed;boundary=" + w.Boundary()
+	m.SetPayload(body.Bytes())
+	return nil
+}
+
+// BodyPart returns the body, or the part of a multipart body, with the
+// given content type, and its Content-ID without angle brackets
+func BodyPart(m Message, contentType string) (body []byte, contentId string, ok bool) {
+	headers := m.Headers()
+	mediaType, params, err := mime.ParseMediaType(headers.ContentType)
+	if err != nil {
+		return nil, "", false
+	}
+	if strings.EqualFold(mediaType, contentType) {
+		return m.Payload(), strings.Trim(headers.Extensions.Get("Content-ID"), "<> "), true
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, "", false
+	}
+	r := multipart.NewReader(bytes.NewReader(m.Payload()), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			return nil, "", false
+		}
+		if partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); strings.EqualFold(partType, contentType) {
+			body, err := io.ReadAll(part)
+			return body, strings.Trim(part.Header.Get("Content-ID"), "<> "), err == nil
+		}
+	}
+}
+
+// MessageLocation returns the location the message carries by value
+func MessageLocation(m Message) (*Location, error) {
+	body, cid, ok := BodyPart(m, PIDFContentType)
+	if !ok {
+		return nil, fmt.Errorf("no location in body")
+	}
+	for _, uri := range Geolocation(m) {
+		if strings.EqualFold(uri, "cid:"+cid) {
+			return ParseLocation(body)
+		}
+	}
+	return nil, fmt.Errorf("no Geolocation header refers to the location in the body")
+}
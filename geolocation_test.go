@@ -0,0 +1,77 @@
+package slurp
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmergencyCall(t *testing.T) {
+	assert.True(t, IsEmergency("urn:service:sos"))
+	assert.True(t, IsEmergency("<urn:service:sos.police>"))
+	assert.False(t, IsEmergency("urn:service:counseling"))
+	assert.True(t, IsServiceURN("urn:service:counseling"))
+
+	offer, err := sdp.Parse("v=0\r\no=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\ns=-\r\nc=IN IP4 192.0.2.1\r\nt=0 0\r\nm=audio 49170 RTP/AVP 0\r\n")
+	assert.Nil(t, err)
+	location := &Location{
+		Entity:    "pres:alice@atlanta.com",
+		Point:     &Point{Latitude: 33.7489, Longitude: -84.388, Radius: 50},
+		Civic:     &CivicAddress{Country: "US", A1: "GA", A3: "Atlanta", RD: "Peachtree & Pine", HNO: "100"},
+		Method:    "GPS",
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	m, err := NewInvite().To(EmergencyURN).From(exampleProfile()).WithSDP(offer).WithLocation(location, true).Build()
+	assert.Nil(t, err)
+
+	parsed, err := ParseMessage(m.Render())
+	assert.Nil(t, err)
+	assert.Equal(t, EmergencyURN, parsed.Uri())
+	assert.True(t, GeolocationRouting(parsed))
+	if uris := Geolocation(parsed); assert.Len(t, uris, 1) {
+		assert.Contains(t, uris[0], "cid:")
+	}
+	received, err := MessageLocation(parsed)
+	assert.Nil(t, err)
+	assert.Equal(t, location, received)
+	answer, _, ok := BodyPart(parsed, "application/sdp")
+	assert.True(t, ok)
+	assert.Equal(t, offer.Render(), string(answer))
+
+	// service URNs are routed by the proxies in the route set
+	_, err = NextHop(parsed)
+	assert.NotNil(t, err)
+	_, err = NewRequestBuilder("OPTIONS").To("urn:ietf:params:xml").FromUri("sip:alice@atlanta.com").Build()
+	assert.IsType(t, UnsupportedUriSchemeError{}, err)
+}
+
+func TestParseLocation(t *testing.T) {
+	// a tuple's status, rather than a device
+	location, err := ParseLocation([]byte(`<?xml version="1.0"?>
+<presence xmlns="urn:ietf:params:xml:ns:pidf" xmlns:gp="urn:ietf:params:xml:ns:pidf:geopriv10"
+  xmlns:gml="http://www.opengis.net/gml" entity="pres:point2d@example.com">
+ <tuple id="sg89ae">
+  <status>
+   <gp:geopriv>
+    <gp:location-info>
+     <gml:Point srsName="urn:ogc:def:crs:EPSG::4326"><gml:pos>-34.407 150.883</gml:pos></gml:Point>
+    </gp:location-info>
+    <gp:method>Wiremap</gp:method>
+   </gp:geopriv>
+  </status>
+  <timestamp>2007-06-22T20:57:29Z</timestamp>
+ </tuple>
+</presence>`))
+	assert.Nil(t, err)
+	assert.Equal(t, "pres:point2d@example.com", location.Entity)
+	assert.Equal(t, &Point{Latitude: -34.407, Longitude: 150.883}, location.Point)
+	assert.Equal(t, "Wiremap", location.Method)
+	assert.Equal(t, time.Date(2007, 6, 22, 20, 57, 29, 0, time.UTC), location.Timestamp)
+
+	_, err = ParseLocation([]byte(`<presence xmlns="urn:ietf:params:xml:ns:pidf" entity="pres:bob@biloxi.com"/>`))
+	assert.NotNil(t, err)
+}
@@ -1,75 +1,266 @@
 package slurp
 
 import (
-	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
 )
 
-// Header represents "complicated" headers in the SIP RFC
-// Not all headers are Headers. For instance, MaxForwards is
-// fundamentally too simple to merit so much overhead
+// Header is a header value with a structure, as opposed to the plain
+// values of e.g. Subject. Each kind of header has a type of its own, see
+// AddressHeader, Via and NumericHeader, and renders with String
 type Header interface {
+	String() string
+}
+
+// AddressHeader is a header holding a URI with parameters, and for most a
+// display name: To, From, Contact, or Alert-Info and the like
+type AddressHeader interface {
+	Header
 	Value() string
 	Param(string) string
 	Uri() string
-	SetValue(string) Header
-	SetParam(string, string) Header
-	SetUri(string) Header
+	SetValue(string) AddressHeader
+	SetParam(string, string) AddressHeader
+	SetUri(string) AddressHeader
 	ParamString() string
 	// Do we want to do Init() here or move Render() to each header impl?
-	Init() Header
+	Init() AddressHeader
+}
+
+// renderAddress renders a name-addr, or a bare value when there is no URI
+func renderAddress(value, uri, params string) string {
+	switch {
+	case uri == "":
+		return value + params
+	case value == "":
+		return "<" + uri + ">" + params
+	}
+	return value + " <" + uri + ">" + params
 }
 
-func NewHeader(h Header) Header {
+func NewHeader(h AddressHeader) AddressHeader {
 	return h.Init()
 }
 
+// Params are the parameters of a header, kept in the order they were
+// first set so that headers render the same every time, with an index for
+// lookups by name. The zero value is empty and ready to use
+type Params struct {
+	pairs [][2]string
+	index map[string]int
+}
+
+// Get returns the value of the named parameter, and whether it is present
+func (p *Params) Get(name string) (string, bool) {
+	i, ok := p.index[name]
+	if !ok {
+		return "", false
+	}
+	return p.pairs[i][1], true
+}
+
+// Set sets the named parameter, in its place when it is present already
+// and last otherwise. Flag parameters, e.g. lr, have an empty value
+func (p *Params) Set(name, value string) {
+	if i, ok := p.index[name]; ok {
+		p.pairs[i][1] = value
+		return
+	}
+	if p.index == nil {
+		p.index = make(map[string]int)
+	}
+	p.index[name] = len(p.pairs)
+	p.pairs = append(p.pairs, [2]string{name, value})
+}
+
+// Delete removes the named parameter, keeping the others in order
+func (p *Params) Delete(name string) {
+	i, ok := p.index[name]
+	if !ok {
+		return
+	}
+	delete(p.index, name)
+	p.pairs = append(p.pairs[:i], p.pairs[i+1:]...)
+	for j := i; j < len(p.pairs); j++ {
+		p.index[p.pairs[j][0]] = j
+	}
+}
+
+// Len returns the number of parameters
+func (p *Params) Len() int {
+	return len(p.pairs)
+}
+
+// Pairs returns a copy of the parameters as name and value pairs, in order
+func (p *Params) Pairs() [][2]string {
+	return append([][2]string(nil), p.pairs...)
+}
+
+// String renders the parameters in order, each preceded by a semicolon
+func (p *Params) String() string {
+	var result strings.Builder
+	for _, pair := range p.pairs {
+		result.WriteString(";" + pair[0])
+		if pair[1] != "" {
+			result.WriteString("=" + pair[1])
+		}
+	}
+	return result.String()
+}
+
+// clone returns a copy of the parameters sharing nothing with p
+func (p *Params) clone() Params {
+	clone := Params{pairs: p.Pairs()}
+	if p.index != nil {
+		clone.index = make(map[string]int, len(p.index))
+		for name, i := range p.index {
+			clone.index[name] = i
+		}
+	}
+	return clone
+}
+
+// reset empties the parameters, keeping their storage for reuse
+func (p *Params) reset() {
+	p.pairs = p.pairs[:0]
+	for name := range p.index {
+		delete(p.index, name)
+	}
+}
+
 // The Contact header, which is repeatable
-type Contact map[string]string
+type Contact struct {
+	value  string
+	uri    string
+	params Params
+}
 
-func (h *Contact) Init() Header {
-	*h = make(map[string]string)
+func (h *Contact) Init() AddressHeader {
+	*h = Contact{}
 	return h
 }
 
 func (h *Contact) Value() string {
-	return (*h)["_value"]
+	return h.value
 }
 
 func (h *Contact) Param(name string) string {
-	return (*h)[name]
+	value, _ := h.params.Get(name)
+	return value
+}
+
+// Params returns the parameters of the contact, which may be changed
+func (h *Contact) Params() *Params {
+	return &h.params
 }
 
-func (h *Contact) SetValue(value string) Header {
-	(*h)["_value"] = value
+func (h *Contact) SetValue(value string) AddressHeader {
+	h.value = value
 	return h
 }
 
-func (h *Contact) SetParam(name, value string) Header {
-	(*h)[name] = value
+func (h *Contact) SetParam(name, value string) AddressHeader {
+	h.params.Set(name, value)
 	return h
 }
 
 func (h *Contact) Uri() string {
-	return (*h)["_uri"]
+	return h.uri
 }
 
-func (h *Contact) SetUri(uri string) Header {
-	(*h)["_uri"] = uri
+func (h *Contact) SetUri(uri string) AddressHeader {
+	h.uri = uri
 	return h
 }
 
-func (h *Contact) ParamString() (result string) {
-	for k, v := range *h {
-		if !strings.HasPrefix(k, "_") {
-			result += fmt.Sprintf(
-				"; %s=%s",
-				k,
-				v,
-			)
-		}
+// ParamString renders the parameters in the order they were set. Flag
+// parameters, e.g. the feature tag +sip.src, have no value
+func (h *Contact) ParamString() string {
+	return h.params.String()
+}
+
+func (h *Contact) String() string {
+	return renderAddress(h.value, h.uri, h.ParamString())
+}
+
+// Parse reads a single Contact value. A bare URI, or the wildcard, is kept
+// as the value
+func (h *Contact) Parse(value string) error {
+	h.parse(value)
+	if h.uri == "" && h.value == "" {
+		return InvalidMessageFormatError(value)
 	}
-	return
+	return nil
+}
+
+// parse reads a Contact value, keeping the storage of the parameters
+func (h *Contact) parse(value string) {
+	h.value, h.uri = "", ""
+	h.params.reset()
+	name, uri, params := splitNameAddr(value)
+	if indexUnquoted(value, '<') >= 0 {
+		h.value, h.uri = name, uri
+	} else {
+		h.value = uri
+	}
+	parseParamsInto(params, h)
+}
+
+// parseParamsInto sets the parameters of a raw parameter string on h,
+// with their names lowercased. Flag parameters get an empty value
+func parseParamsInto(params string, h AddressHeader) {
+	if params == "" {
+		return
+	}
+	for _, param := range splitUnquoted(params, ';') {
+		name, value, _ := strings.Cut(param, "=")
+		h.SetParam(strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value))
+	}
+}
+
+// Expires returns the expires parameter of the contact, and false when
+// it has none or it isn't a number of seconds
+func (h *Contact) Expires() (int, bool) {
+	seconds, err := strconv.Atoi(strings.TrimSpace(h.Param("expires")))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// Q returns the q-value of the contact, its relative preference between
+// 0 and 1. Contacts without a valid q-value have the default of 1
+func (h *Contact) Q() float64 {
+	q, err := strconv.ParseFloat(strings.TrimSpace(h.Param("q")), 64)
+	if err != nil || q < 0 || q > 1 {
+		return 1
+	}
+	return q
+}
+
+// SetQ sets the q-value of the contact. The RFC allows at most three decimals
+func (h *Contact) SetQ(q float64) AddressHeader {
+	return h.SetParam("q", strconv.FormatFloat(math.Round(q*1000)/1000, 'f', -1, 64))
+}
+
+// ContactQ returns the q-value of a Contact header, or 1 for other headers
+func ContactQ(h AddressHeader) float64 {
+	if contact, ok := h.(*Contact); ok {
+		return contact.Q()
+	}
+	return 1
+}
+
+// SortByQ sorts contacts by descending q-value, keeping the received
+// order of contacts with equal q-values
+func SortByQ(contacts []AddressHeader) {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return ContactQ(contacts[i]) > ContactQ(contacts[j])
+	})
 }
 
 // Used for both From an To headers as they have the same parameters
@@ -79,7 +270,7 @@ type ToFrom struct {
 	tag   string
 }
 
-func (t *ToFrom) Init() Header {
+func (t *ToFrom) Init() AddressHeader {
 	return t
 }
 
@@ -98,19 +289,19 @@ func (t *ToFrom) Uri() string {
 	return t.uri
 }
 
-func (t *ToFrom) SetValue(value string) Header {
+func (t *ToFrom) SetValue(value string) AddressHeader {
 	t.value = value
 	return t
 }
 
-func (t *ToFrom) SetParam(name, value string) Header {
+func (t *ToFrom) SetParam(name, value string) AddressHeader {
 	if name == "tag" {
 		t.tag = value
 	} // discard everything else. To shouldn't contain any other parameters
 	return t
 }
 
-func (t *ToFrom) SetUri(uri string) Header {
+func (t *ToFrom) SetUri(uri string) AddressHeader {
 	t.uri = uri
 	return t
 }
@@ -118,3 +309,171 @@ func (t *ToFrom) SetUri(uri string) Header {
 func (t *ToFrom) ParamString() string {
 	return "; tag=" + t.tag
 }
+
+func (t *ToFrom) String() string {
+	params := ""
+	if t.tag != "" {
+		params = ";tag=" + t.tag
+	}
+	return renderAddress(t.value, t.uri, params)
+}
+
+// Parse reads a To or From value, keeping its tag
+func (t *ToFrom) Parse(value string) error {
+	*t = ToFrom{}
+	if err := parseFromTo(value, t); err != nil {
+		return err
+	}
+	if t.uri == "" {
+		return InvalidMessageFormatError(value)
+	}
+	return nil
+}
+
+// Info is used for Alert-Info, Call-Info and similar headers, which carry
+// a URI and an arbitrary set of parameters (e.g. purpose=icon) that must
+// be preserved as received
+type Info struct {
+	uri    string
+	params Params
+}
+
+func (h *Info) Init() AddressHeader {
+	*h = Info{}
+	return h
+}
+
+func (h *Info) Value() string {
+	return ""
+}
+
+func (h *Info) Param(name string) string {
+	value, _ := h.params.Get(name)
+	return value
+}
+
+// Params returns the parameters of the header, which may be changed
+func (h *Info) Params() *Params {
+	return &h.params
+}
+
+// Info headers have no display name, so SetValue is a no-op
+func (h *Info) SetValue(value string) AddressHeader {
+	return h
+}
+
+func (h *Info) SetParam(name, value string) AddressHeader {
+	h.params.Set(name, value)
+	return h
+}
+
+func (h *Info) Uri() string {
+	return h.uri
+}
+
+func (h *Info) SetUri(uri string) AddressHeader {
+	h.uri = uri
+	return h
+}
+
+// ParamString renders the parameters in the order they were set. Flag
+// parameters such as ;lr have no value
+func (h *Info) ParamString() string {
+	return h.params.String()
+}
+
+func (h *Info) String() string {
+	return renderAddress("", h.uri, h.ParamString())
+}
+
+// Parse reads a single value in the form <URI> *(;param[=value])
+func (h *Info) Parse(value string) error {
+	infos, err := parseInfo(value)
+	if err != nil || len(infos) != 1 {
+		return InvalidMessageFormatError(value)
+	}
+	*h = *infos[0].(*Info)
+	return nil
+}
+
+// HeaderField is a single header line, as a name and its raw value
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// HeaderList is an ordered collection of header fields. Headers are
+// looked up by canonical name, so lookups are case-insensitive and match
+// compact forms. Names and values are stored as given, so comma-joined
+// values stay folded until GetAll is used to split them, and names are
+// spelled with HeaderName when rendered.
+type HeaderList struct {
+	fields []HeaderField
+}
+
+// Get returns the value of the first header with the given name
+func (l *HeaderList) Get(name string) string {
+	name = canonicalName(name)
+	for _, field := range l.fields {
+		if canonicalName(field.Name) == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// GetAll returns every value of the named header, in order. Comma-joined
+// values are split, as they are equivalent to repeating the header
+func (l *HeaderList) GetAll(name string) (values []string) {
+	name = canonicalName(name)
+	for _, field := range l.fields {
+		if canonicalName(field.Name) == name {
+			values = append(values, splitHeaderValues(field.Value)...)
+		}
+	}
+	return
+}
+
+// Add appends a header, after any others with the same name
+func (l *HeaderList) Add(name, value string) {
+	l.fields = append(l.fields, HeaderField{Name: name, Value: value})
+}
+
+// Set replaces every header with the given name by a single one, kept in
+// the position of the first. The header is appended if it isn't present
+func (l *HeaderList) Set(name, value string) {
+	canonical := canonicalName(name)
+	for i, field := range l.fields {
+		if canonicalName(field.Name) == canonical {
+			l.fields[i] = HeaderField{Name: name, Value: value}
+			l.remove(canonical, i+1)
+			return
+		}
+	}
+	l.Add(name, value)
+}
+
+// Remove deletes every header with the given name
+func (l *HeaderList) Remove(name string) {
+	l.remove(canonicalName(name), 0)
+}
+
+func (l *HeaderList) remove(canonical string, from int) {
+	kept := l.fields[:from]
+	for _, field := range l.fields[from:] {
+		if canonicalName(field.Name) != canonical {
+			kept = append(kept, field)
+		}
+	}
+	l.fields = kept
+}
+
+// Len returns the number of header lines
+func (l *HeaderList) Len() int {
+	return len(l.fields)
+}
+
+// Fields returns a copy of every header, in order
+func (l *HeaderList) Fields() []HeaderField {
+	return append([]HeaderField{}, l.fields...)
+}
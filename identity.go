@@ -0,0 +1,117 @@
+package slurp
+
+/*
+A UAS only answers for its own identities. LocalIdentities decides what
+happens to requests whose Request-URI addresses someone else, e.g. calls
+meant for another device that a misconfigured proxy sent us.
+*/
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// UnknownTargetAction is what happens to requests for unknown identities
+type UnknownTargetAction int
+
+const (
+	// RejectUnknown answers 404 Not Found
+	RejectUnknown UnknownTargetAction = iota
+	// ForwardUnknown passes the request to the UnknownTarget handler
+	ForwardUnknown
+	// AcceptUnknown delivers the request as if it were for us
+	AcceptUnknown
+)
+
+// uriUserHost returns the user and host of a SIP URI, ignoring the scheme,
+// display name, port and parameters. The host is lower case
+func uriUserHost(uri string) (user, host string) {
+	if start := strings.Index(uri, "<"); start >= 0 {
+		uri = strings.TrimSuffix(uri[start+1:], ">")
+	}
+	if colon := strings.Index(uri, ":"); colon >= 0 && !strings.Contains(uri[:colon], "@") {
+		uri = uri[colon+1:]
+	}
+	uri = strings.SplitN(strings.SplitN(uri, ";", 2)[0], "?", 2)[0]
+	if at := strings.LastIndex(uri, "@"); at >= 0 {
+		user, uri = uri[:at], uri[at+1:]
+	}
+	if h, _, err := net.SplitHostPort(uri); err == nil {
+		uri = h
+	}
+	return user, strings.ToLower(strings.Trim(uri, "[]"))
+}
+
+// LocalIdentities is the set of identities a UAS answers for. Each is
+// either a SIP URI for one user (sip:alice@atlanta.com) or a bare host,
+// which matches any user at that host (atlanta.com or 192.0.2.1)
+type LocalIdentities struct {
+	Action UnknownTargetAction
+	// UnknownTarget handles requests for unknown identities when Action is
+	// ForwardUnknown, like a RequestFilter. Without one they are rejected
+	UnknownTarget RequestFilter
+	mu            sync.RWMutex
+	identities    map[string]bool
+}
+
+// NewLocalIdentities creates a LocalIdentities answering for identities
+func NewLocalIdentities(identities ...string) *LocalIdentities {
+	l := &LocalIdentities{identities: make(map[string]bool)}
+	for _, identity := range identities {
+		l.Add(identity)
+	}
+	return l
+}
+
+func identityKey(identity string) string {
+	if !strings.Contains(identity, ":") && !strings.Contains(identity, "@") {
+		return strings.ToLower(identity)
+	}
+	user, host := uriUserHost(identity)
+	return user + "@" + host
+}
+
+// Add adds an identity
+func (l *LocalIdentities) Add(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.identities == nil {
+		l.identities = make(map[string]bool)
+	}
+	l.identities[identityKey(identity)] = true
+}
+
+// Remove removes an identity
+func (l *LocalIdentities) Remove(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.identities, identityKey(identity))
+}
+
+// Matches reports whether uri addresses one of the identities
+func (l *LocalIdentities) Matches(uri string) bool {
+	user, host := uriUserHost(uri)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.identities[host] || l.identities[user+"@"+host]
+}
+
+// Filter returns a RequestFilter applying the Action to requests whose
+// Request-URI doesn't match any identity. ACKs are never answered
+func (l *LocalIdentities) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if l.Matches(in.Message.Uri()) {
+			return nil, true
+		}
+		switch {
+		case l.Action == AcceptUnknown:
+			return nil, true
+		case l.Action == ForwardUnknown && l.UnknownTarget != nil:
+			return l.UnknownTarget(in)
+		case in.Message.Method() == "ACK":
+			return nil, false
+		}
+		return NewResponse(in.Message, 404), false
+	}
+}
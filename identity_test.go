@@ -0,0 +1,48 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUriUserHost(t *testing.T) {
+	user, host := uriUserHost("sip:Alice@Atlanta.com:5060;transport=udp")
+	assert.Equal(t, "Alice", user)
+	assert.Equal(t, "atlanta.com", host)
+	user, host = uriUserHost("Bob <sips:bob@[2001:db8::1]:5061>")
+	assert.Equal(t, "bob", user)
+	assert.Equal(t, "2001:db8::1", host)
+	_, host = uriUserHost("sip:192.0.2.1")
+	assert.Equal(t, "192.0.2.1", host)
+}
+
+func TestLocalIdentities(t *testing.T) {
+	identities := NewLocalIdentities("sip:alice@atlanta.com", "192.0.2.1")
+	assert.True(t, identities.Matches("sip:alice@atlanta.com:5060"))
+	assert.True(t, identities.Matches("sip:anyone@192.0.2.1"))
+	assert.False(t, identities.Matches("sip:bob@atlanta.com"))
+
+	request := NewRequest("OPTIONS", "sip:bob@atlanta.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@atlanta.com")
+	request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:carol@chicago.com")
+	in := Incoming{Message: request}
+
+	response, ok := identities.Filter()(in)
+	assert.False(t, ok)
+	assert.Equal(t, 404, response.StatusCode())
+
+	forwarded := false
+	identities.Action = ForwardUnknown
+	identities.UnknownTarget = func(Incoming) (*Response, bool) {
+		forwarded = true
+		return nil, false
+	}
+	_, ok = identities.Filter()(in)
+	assert.False(t, ok)
+	assert.True(t, forwarded)
+
+	identities.Action = AcceptUnknown
+	_, ok = identities.Filter()(in)
+	assert.True(t, ok)
+}
@@ -0,0 +1,274 @@
+package slurp
+
+/*
+IMS cores (3GPP TS 24.229) rely on private headers between their nodes
+(RFC 7315). P-Access-Network-Info tells the network which access, and
+cell, a UE is on; P-Visited-Network-ID names the network a roaming UE
+registers from; P-Charging-Vector correlates the charging records of a
+session across nodes with an IMS Charging Identifier (ICID), and
+P-Charging-Function-Addresses tells them where to send those records.
+*/
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/google/uuid"
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// The access types of P-Access-Network-Info most often seen
+const (
+	Access3GPPEUTRANFDD = "3GPP-E-UTRAN-FDD"
+	Access3GPPEUTRANTDD = "3GPP-E-UTRAN-TDD"
+	Access3GPPNRFDD     = "3GPP-NR-FDD"
+	AccessIEEE80211     = "IEEE-802.11"
+)
+
+// splitIMSParams splits a header value into its semicolon separated
+// parts, trimmed, keeping quoted strings whole
+func splitIMSParams(value string) []string {
+	var parts []string
+	for _, part := range splitUnquoted(value, ';') {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// imsParam returns the value of the named parameter among params,
+// unquoted
+func imsParam(params []string, name string) (string, bool) {
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return unquoteDisplayName(value), true
+		}
+	}
+	return "", false
+}
+
+// AccessNetworkInfo is a value of P-Access-Network-Info
+type AccessNetworkInfo struct {
+	AccessType string
+	// Params are the raw parameters, e.g. utran-cell-id-3gpp=..., in order
+	Params []string
+}
+
+// ParseAccessNetworkInfo reads a P-Access-Network-Info value
+func ParseAccessNetworkInfo(value string) (AccessNetworkInfo, error) {
+	parts := splitIMSParams(value)
+	if len(parts) == 0 {
+		return AccessNetworkInfo{}, Violation{Header: "P-Access-Network-Info", Reason: "missing access type"}
+	}
+	return AccessNetworkInfo{AccessType: parts[0], Params: parts[1:]}, nil
+}
+
+func (a AccessNetworkInfo) String() string {
+	return strings.Join(append([]string{a.AccessType}, a.Params...), ";")
+}
+
+// Param returns the value of a parameter, unquoted
+func (a AccessNetworkInfo) Param(name string) (string, bool) {
+	return imsParam(a.Params, name)
+}
+
+// CellID returns the cell of a 3GPP access, or the access point of an
+// 802.11 one, empty when the value has neither
+func (a AccessNetworkInfo) CellID() string {
+	for _, name := range []string{"utran-cell-id-3gpp", "cgi-3gpp", "i-wlan-node-id"} {
+		if id, ok := a.Param(name); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// NetworkProvided reports whether the network, rather than the UE,
+// inserted the value, as trusted nodes do
+func (a AccessNetworkInfo) NetworkProvided() bool {
+	_, ok := a.Param("network-provided")
+	return ok
+}
+
+// AccessNetworkInfos returns the values of the message's
+// P-Access-Network-Info headers, the UE's first
+func AccessNetworkInfos(m Message) ([]AccessNetworkInfo, error) {
+	var infos []AccessNetworkInfo
+	for _, value := range m.Headers().Extensions.GetAll("P-Access-Network-Info") {
+		info, err := ParseAccessNetworkInfo(value)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// AddAccessNetworkInfo adds a P-Access-Network-Info value to the message
+func AddAccessNetworkInfo(m Message, info AccessNetworkInfo) {
+	m.Headers().Extensions.Add("P-Access-Network-Info", info.String())
+}
+
+// VisitedNetworkIDs returns the network identifiers of the message's
+// P-Visited-Network-ID headers, unquoted and without parameters
+func VisitedNetworkIDs(m Message) []string {
+	var ids []string
+	for _, value := range m.Headers().Extensions.GetAll("P-Visited-Network-ID") {
+		if parts := splitIMSParams(value); len(parts) > 0 {
+			ids = append(ids, unquoteDisplayName(parts[0]))
+		}
+	}
+	return ids
+}
+
+// AddVisitedNetworkID adds the identifier of the network a proxy is in,
+// e.g. a P-CSCF, to a REGISTER. Identifiers other than tokens, such as
+// "Visited network number 1", are quoted
+func AddVisitedNetworkID(m Message, id string) {
+	m.Headers().Extensions.Add("P-Visited-Network-ID", quoteIMSValue(id))
+}
+
+// NewICID returns a new IMS Charging Identifier, unique across nodes and
+// time as TS 32.260 requires
+func NewICID() string {
+	id := uuid.New()
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// ChargingVector is the value of P-Charging-Vector
+type ChargingVector struct {
+	// ICID is the icid-value, identifying the session for charging
+	ICID string
+	// GeneratedAt is the address of the node that generated the ICID
+	GeneratedAt string
+	// OrigIOI and TermIOI identify the originating and terminating
+	// networks, the Inter Operator Identifiers
+	OrigIOI string
+	TermIOI string
+	// Params are the other parameters, e.g. access network charging
+	// information, raw and in order
+	Params []string
+}
+
+// NewChargingVector returns a charging vector with a new ICID, generated
+// at the node whose address is generatedAt
+func NewChargingVector(generatedAt string) ChargingVector {
+	return ChargingVector{ICID: NewICID(), GeneratedAt: generatedAt}
+}
+
+// ParseChargingVector reads a P-Charging-Vector value, which must have an
+// icid-value
+func ParseChargingVector(value string) (ChargingVector, error) {
+	var v ChargingVector
+	for _, param := range splitIMSParams(value) {
+		key, raw, _ := strings.Cut(param, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "icid-value":
+			v.ICID = unquoteDisplayName(raw)
+		case "icid-generated-at":
+			v.GeneratedAt = unquoteDisplayName(raw)
+		case "orig-ioi":
+			v.OrigIOI = unquoteDisplayName(raw)
+		case "term-ioi":
+			v.TermIOI = unquoteDisplayName(raw)
+		default:
+			v.Params = append(v.Params, param)
+		}
+	}
+	if v.ICID == "" {
+		return v, Violation{Header: "P-Charging-Vector", Reason: "missing icid-value"}
+	}
+	return v, nil
+}
+
+func (v ChargingVector) String() string {
+	params := []string{"icid-value=" + quoteIMSValue(v.ICID)}
+	for _, param := range [][2]string{{"icid-generated-at", v.GeneratedAt}, {"orig-ioi", v.OrigIOI}, {"term-ioi", v.TermIOI}} {
+		if param[1] != "" {
+			params = append(params, param[0]+"="+quoteIMSValue(param[1]))
+		}
+	}
+	return strings.Join(append(params, v.Params...), ";")
+}
+
+// quoteIMSValue quotes a value that isn't a token or host, e.g. one with
+// spaces or separators
+func quoteIMSValue(value string) string {
+	if !strings.ContainsAny(value, " \t;,\"=\\") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// ChargingVectorOf returns the message's P-Charging-Vector, ok being
+// false when it has none
+func ChargingVectorOf(m Message) (v ChargingVector, ok bool, err error) {
+	value := m.Headers().Extensions.Get("P-Charging-Vector")
+	if value == "" {
+		return v, false, nil
+	}
+	v, err = ParseChargingVector(value)
+	return v, err == nil, err
+}
+
+// SetChargingVector replaces the message's P-Charging-Vector
+func SetChargingVector(m Message, v ChargingVector) {
+	m.Headers().Extensions.Set("P-Charging-Vector", v.String())
+}
+
+// ChargingFunctionAddresses is the value of P-Charging-Function-Addresses:
+// the Charging Collection Functions offline records go to, and the Event
+// Charging Functions for online charging, each in order of preference
+type ChargingFunctionAddresses struct {
+	CCF []string
+	ECF []string
+}
+
+// ParseChargingFunctionAddresses reads a P-Charging-Function-Addresses
+// value, which must have at least one address
+func ParseChargingFunctionAddresses(value string) (ChargingFunctionAddresses, error) {
+	var a ChargingFunctionAddresses
+	for _, param := range splitIMSParams(value) {
+		key, raw, _ := strings.Cut(param, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "ccf":
+			a.CCF = append(a.CCF, unquoteDisplayName(raw))
+		case "ecf":
+			a.ECF = append(a.ECF, unquoteDisplayName(raw))
+		}
+	}
+	if len(a.CCF) == 0 && len(a.ECF) == 0 {
+		return a, Violation{Header: "P-Charging-Function-Addresses", Reason: "no ccf or ecf"}
+	}
+	return a, nil
+}
+
+func (a ChargingFunctionAddresses) String() string {
+	var params []string
+	for _, ccf := range a.CCF {
+		params = append(params, "ccf="+quoteIMSValue(ccf))
+	}
+	for _, ecf := range a.ECF {
+		params = append(params, "ecf="+quoteIMSValue(ecf))
+	}
+	return strings.Join(params, ";")
+}
+
+// ChargingFunctionAddressesOf returns the message's
+// P-Charging-Function-Addresses, ok being false when it has none
+func ChargingFunctionAddressesOf(m Message) (a ChargingFunctionAddresses, ok bool, err error) {
+	value := m.Headers().Extensions.Get("P-Charging-Function-Addresses")
+	if value == "" {
+		return a, false, nil
+	}
+	a, err = ParseChargingFunctionAddresses(value)
+	return a, err == nil, err
+}
+
+// SetChargingFunctionAddresses replaces the message's
+// P-Charging-Function-Addresses
+func SetChargingFunctionAddresses(m Message, a ChargingFunctionAddresses) {
+	m.Headers().Extensions.Set("P-Charging-Function-Addresses", a.String())
+}
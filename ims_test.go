@@ -0,0 +1,56 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIMSHeaders(t *testing.T) {
+	register, _ := NewRequestBuilder("REGISTER").To("sip:alice@ims.example.com").FromUri("sip:alice@ims.example.com").
+		Header("P-Access-Network-Info", `3GPP-E-UTRAN-FDD; utran-cell-id-3gpp=234150999999999;network-provided`).
+		Header("P-Access-Network-Info", `IEEE-802.11;i-wlan-node-id="ffeeddccbbaa"`).
+		Build()
+	infos, err := AccessNetworkInfos(register)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(infos))
+	assert.Equal(t, Access3GPPEUTRANFDD, infos[0].AccessType)
+	assert.Equal(t, "234150999999999", infos[0].CellID())
+	assert.True(t, infos[0].NetworkProvided())
+	assert.Equal(t, "ffeeddccbbaa", infos[1].CellID())
+	assert.False(t, infos[1].NetworkProvided())
+	assert.Equal(t, "3GPP-E-UTRAN-FDD;utran-cell-id-3gpp=234150999999999;network-provided", infos[0].String())
+
+	AddVisitedNetworkID(register, "other.net")
+	AddVisitedNetworkID(register, "Visited network, number 1")
+	assert.Equal(t, []string{"other.net", "Visited network, number 1"}, VisitedNetworkIDs(register))
+
+	_, ok, err := ChargingVectorOf(register)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	vector := NewChargingVector("192.0.2.1")
+	assert.Regexp(t, "^[0-9A-F]{32}$", vector.ICID)
+	assert.NotEqual(t, vector.ICID, NewICID())
+	vector.OrigIOI = "home1.net"
+	SetChargingVector(register, vector)
+	parsed, ok, err := ChargingVectorOf(register)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, vector, parsed)
+	parsed, err = ParseChargingVector(`icid-value="AyretyU0dm+6O2IrT5tAFrbHLso=023551024"; orig-ioi=home1.net; ggsn=[5555::4b4:3c3:2d2:1e1]`)
+	assert.Nil(t, err)
+	assert.Equal(t, "AyretyU0dm+6O2IrT5tAFrbHLso=023551024", parsed.ICID)
+	assert.Equal(t, []string{"ggsn=[5555::4b4:3c3:2d2:1e1]"}, parsed.Params)
+	assert.Equal(t, `icid-value="AyretyU0dm+6O2IrT5tAFrbHLso=023551024";orig-ioi=home1.net;ggsn=[5555::4b4:3c3:2d2:1e1]`, parsed.String())
+	_, err = ParseChargingVector("orig-ioi=home1.net")
+	assert.NotNil(t, err)
+
+	SetChargingFunctionAddresses(register, ChargingFunctionAddresses{CCF: []string{"192.1.1.1", "192.1.1.2"}, ECF: []string{"[5555::b99:c88:d77:e66]"}})
+	assert.Equal(t, "ccf=192.1.1.1;ccf=192.1.1.2;ecf=[5555::b99:c88:d77:e66]", register.Headers().Extensions.Get("P-Charging-Function-Addresses"))
+	addresses, ok, err := ChargingFunctionAddressesOf(register)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.1.1.1", "192.1.1.2"}, addresses.CCF)
+	_, err = ParseChargingFunctionAddresses("foo=bar")
+	assert.NotNil(t, err)
+}
@@ -3,41 +3,59 @@ package slurp
 import (
 	"fmt"
 	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
 )
 
 type Invite struct {
 	headers CommonHeaders
 	control CallControlHeaders
 	raw     string
+	wire    *wireFormat
 	payload []byte
 	uri     string
 }
 
 func (i *Invite) Render() string {
-	return fmt.Sprintf(
-		"INVITE sip:%s SIP/2.0\r\n%s\r\n%s\r\n%s\r\n\r\n",
-		i.Headers().To.Uri(),
-		renderHeaders(i.headers, i.control),
+	// Unless the Request-URI was set explicitly, send the request to the To URI
+	uri := i.uri
+	if uri == "" && i.headers.To != nil {
+		uri = "sip:" + i.headers.To.Uri()
+	}
+	return i.wire.apply(fmt.Sprintf(
+		"INVITE %s SIP/2.0\r\n%s\r\n%s%s\r\n\r\n",
+		uri,
+		renderRequestHeaders(i.headers, i.control),
 		// we set CSeq outside of renderHeaders because it's method-dependent
 		"CSeq: "+fmt.Sprintf("%d", i.control.Sequence)+" INVITE",
-		"Supported: SUBSCRIBE, NOTIFY",
-	)
+		defaultSupported(i.headers),
+	)) + string(i.payload)
 }
 
 // Parse takes a string representation of a message and unmarshalls
 // the data into the appropriate struct fields.
 func (i *Invite) Parse(message string) (err error) {
-	// split lines
-	lines := strings.Split(message, "\n")
+	// split the body off, then split lines
+	head, body := splitBody(message)
+	lines := strings.Split(head, "\n")
 	// ensure that the message is an INVITE message
 	// and the the protocol is SIP/2.0
 	err = validateMethod(lines[0], "INVITE")
 	// In an INVITE, URI should immediate follow INVITE
-	// TODO when enough infrastructure exists to accomplish it, add support for checking for unsupported URI schemes and responding with 416
-	i.uri = strings.Split(lines[0], " ")[1]
+	i.uri = requestUri(lines[0])
 	i.headers = CommonHeaders{}
 	i.control = CallControlHeaders{}
-	parseHeaders(lines, &i.headers, &i.control)
+	if headerErr := parseHeaders(lines, &i.headers, &i.control); err == nil {
+		err = headerErr
+	}
+	if err == nil {
+		err = checkUriScheme(i.uri)
+	}
+	i.payload = body
+	i.raw = rawHeaderSection(head)
+	i.wire = nil
+	i.wire = preserveWire(head, i.Render)
+	countParse("INVITE", err)
 	return
 }
 
@@ -45,10 +63,20 @@ func (i *Invite) Uri() string {
 	return i.uri
 }
 
+// SetUri sets the Request-URI, e.g. to retarget the request after a redirect
+func (i *Invite) SetUri(uri string) {
+	i.uri = uri
+}
+
 func (i *Invite) Method() string {
 	return "INVITE"
 }
 
+// Validate checks the message for RFC violations, returning all of them
+func (i *Invite) Validate() []Violation {
+	return validateMessage(i, true)
+}
+
 func (i *Invite) Headers() *CommonHeaders {
 	return &i.headers
 }
@@ -57,6 +85,10 @@ func (i *Invite) RawHeaders() string {
 	return i.raw
 }
 
+func (i *Invite) HeaderValue(name string) string {
+	return headerValue(i.raw, name)
+}
+
 func (i *Invite) Control() *CallControlHeaders {
 	return &i.control
 }
@@ -71,4 +103,5 @@ func (i *Invite) StringPayload() string {
 
 func (i *Invite) SetPayload(data []byte) {
 	i.payload = data
+	i.headers.ContentLength = len(data)
 }
@@ -0,0 +1,241 @@
+package slurp
+
+/*
+Misbehaving peers can keep state alive forever: an INVITE answered with a
+180 every few minutes never times out, and a call whose BYE was lost is
+never hung up. A long-running server would leak them. The Janitor sweeps
+the client transactions and calls of a UserAgent periodically, and ends
+those that outlived their maximum lifetime: a transaction times out, an
+INVITE being cancelled so that it gets its 487, and a call is terminated
+and hung up. Transactions that still don't go away are dropped.
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultJanitorInterval is how often a Janitor sweeps by default
+const DefaultJanitorInterval = 10 * time.Second
+
+// MetricCleanups counts the transactions and dialogs the Janitor ended,
+// labeled by kind
+const MetricCleanups = "slurp_cleanups_total"
+
+// CleanupKind is what the Janitor did
+type CleanupKind int
+
+const (
+	// TransactionExpired is a client transaction ended as if it timed out
+	TransactionExpired CleanupKind = iota
+	// TransactionRemoved is a transaction dropped, as it was still there
+	// twice its maximum lifetime after starting
+	TransactionRemoved
+	// DialogExpired is a call terminated and hung up
+	DialogExpired
+)
+
+func (k CleanupKind) String() string {
+	switch k {
+	case TransactionExpired:
+		return "transaction_expired"
+	case TransactionRemoved:
+		return "transaction_removed"
+	case DialogExpired:
+		return "dialog_expired"
+	}
+	return "unknown"
+}
+
+// CleanupEvent reports a transaction or call ended by the Janitor.
+// Branch and Method identify transactions, and Dialog calls
+type CleanupEvent struct {
+	Kind   CleanupKind
+	Branch string
+	Method string
+	Dialog DialogID
+	// Age is how long the Janitor had seen it for
+	Age time.Duration
+}
+
+// janitorEntry is when the Janitor first saw a transaction or call, and
+// whether it ended it already
+type janitorEntry struct {
+	seen  time.Time
+	ended bool
+}
+
+// Janitor ends the transactions and calls of a UserAgent that outlive
+// their maximum lifetime. Ages count from the sweep that first saw them,
+// so they are only as precise as Interval. It is safe for concurrent use
+type Janitor struct {
+	// MaxTransactionLifetime is how long a client transaction may run,
+	// MaxDialogLifetime how long a call may last. Zero is no limit
+	MaxTransactionLifetime time.Duration
+	MaxDialogLifetime      time.Duration
+	// Interval between sweeps, DefaultJanitorInterval when zero
+	Interval time.Duration
+	// OnCleanup, when not nil, is called with each transaction or call
+	// the Janitor ends
+	OnCleanup func(CleanupEvent)
+	// Clock used for sweeps and ages, DefaultClock when nil
+	Clock        Clock
+	ua           *UserAgent
+	mu           sync.Mutex
+	transactions map[string]*janitorEntry
+	dialogs      map[DialogID]*janitorEntry
+	timer        Timer
+	running      bool
+}
+
+// NewJanitor creates a Janitor for the transactions and calls of ua
+func NewJanitor(ua *UserAgent, maxTransaction, maxDialog time.Duration) *Janitor {
+	return &Janitor{
+		MaxTransactionLifetime: maxTransaction,
+		MaxDialogLifetime:      maxDialog,
+		ua:                     ua,
+		transactions:           make(map[string]*janitorEntry),
+		dialogs:                make(map[DialogID]*janitorEntry),
+	}
+}
+
+// Start sweeps every Interval until Stop is called
+func (j *Janitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return
+	}
+	j.running = true
+	j.schedule()
+}
+
+// schedule arms the timer of the next sweep. j.mu must be held
+func (j *Janitor) schedule() {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = DefaultJanitorInterval
+	}
+	j.timer = clockOrDefault(j.Clock).AfterFunc(interval, func() {
+		j.Sweep()
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.running {
+			j.schedule()
+		}
+	})
+}
+
+// Stop stops the periodic sweeps
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.running = false
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+}
+
+// Sweep ends the transactions and calls that outlived their maximum
+// lifetime, forgets the calls whose dialog terminated, and returns what
+// it did
+func (j *Janitor) Sweep() []CleanupEvent {
+	now := clockOrDefault(j.Clock).Now()
+	j.mu.Lock()
+	events := j.sweepTransactions(now)
+	events = append(events, j.sweepDialogs(now)...)
+	j.mu.Unlock()
+	for _, event := range events {
+		Metrics().IncCounter(MetricCleanups, map[string]string{"kind": event.Kind.String()})
+		if j.OnCleanup != nil {
+			j.OnCleanup(event)
+		}
+	}
+	return events
+}
+
+// sweepTransactions expires the client transactions older than
+// MaxTransactionLifetime, and drops those older than twice that, which
+// didn't end when expired. j.mu must be held
+func (j *Janitor) sweepTransactions(now time.Time) []CleanupEvent {
+	var events []CleanupEvent
+	seen := make(map[string]*janitorEntry)
+	for _, info := range j.ua.Transactions() {
+		key := transactionKey(info.Branch, info.Method)
+		entry, ok := j.transactions[key]
+		if !ok {
+			entry = &janitorEntry{seen: now}
+		}
+		seen[key] = entry
+		age := now.Sub(entry.seen)
+		event := CleanupEvent{Branch: info.Branch, Method: info.Method, Age: age}
+		switch {
+		case j.MaxTransactionLifetime <= 0 || age < j.MaxTransactionLifetime:
+		case age >= 2*j.MaxTransactionLifetime:
+			if j.ua.forgetTransaction(key) {
+				event.Kind = TransactionRemoved
+				events = append(events, event)
+				delete(seen, key)
+			}
+		case !entry.ended:
+			entry.ended = true
+			if j.ua.expireTransaction(key) {
+				event.Kind = TransactionExpired
+				events = append(events, event)
+			}
+		}
+	}
+	j.transactions = seen
+	return events
+}
+
+// sweepDialogs terminates and hangs up the calls older than
+// MaxDialogLifetime, and removes the calls whose dialog terminated from
+// the UserAgent's DialogStore. j.mu must be held
+func (j *Janitor) sweepDialogs(now time.Time) []CleanupEvent {
+	var events []CleanupEvent
+	seen := make(map[DialogID]*janitorEntry)
+	j.ua.dialogs.Range(func(id DialogID, call *Call) bool {
+		if call.Dialog.Ended() {
+			j.ua.dialogs.Remove(id)
+			return true
+		}
+		entry, ok := j.dialogs[id]
+		if !ok {
+			entry = &janitorEntry{seen: now}
+		}
+		seen[id] = entry
+		age := now.Sub(entry.seen)
+		if j.MaxDialogLifetime > 0 && age >= j.MaxDialogLifetime {
+			j.expire(call)
+			j.ua.dialogs.Remove(id)
+			delete(seen, id)
+			events = append(events, CleanupEvent{Kind: DialogExpired, Dialog: id, Age: age})
+		}
+		return true
+	})
+	j.dialogs = seen
+	return events
+}
+
+// expire terminates the dialog of a call, sending a BYE in the
+// background when it was confirmed, in case the peer is still there
+func (j *Janitor) expire(call *Call) {
+	call.Dialog.mu.Lock()
+	confirmed := call.Dialog.State == Confirmed
+	call.Dialog.mu.Unlock()
+	var bye *Request
+	if confirmed {
+		bye = call.Dialog.NewRequest("BYE")
+	}
+	call.Dialog.Terminate("expired")
+	if bye == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), j.ua.Timers().F)
+		defer cancel()
+		j.ua.Request(ctx, call.Addr, bye)
+	}()
+}
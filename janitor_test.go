@@ -0,0 +1,81 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitor(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	caller := NewUserAgent(a)
+	addr, received := cancelledCallee(t, 0, 487)
+	clock := NewFakeClock(time.Now())
+	janitor := NewJanitor(caller, time.Minute, time.Hour)
+	janitor.Clock = clock
+
+	// an INVITE kept ringing is cancelled, and fails as if it timed out
+	ringing := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := caller.RequestWith(context.Background(), addr, newTestInvite("sip:bob@biloxi.com"), ResponseHandlers{
+			OnProvisional: func(*Response) { ringing <- struct{}{} },
+		})
+		done <- err
+	}()
+	select {
+	case <-ringing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the INVITE didn't ring")
+	}
+	assert.Empty(t, janitor.Sweep())
+	clock.Advance(time.Minute)
+	events := janitor.Sweep()
+	if assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, TransactionExpired, events[0].Kind)
+		assert.Equal(t, "INVITE", events[0].Method)
+		assert.Equal(t, time.Minute, events[0].Age)
+	}
+	assert.IsType(t, TimeoutError{}, <-done)
+	assert.Equal(t, "ACK", (<-received).Method(), "the 487 is acknowledged")
+
+	// a transaction that doesn't end when expired is dropped
+	caller.mu.Lock()
+	caller.transactions[transactionKey("z9hG4bKstuck", "OPTIONS")] = &clientTransaction{responses: make(chan *Response, 8), expired: make(chan struct{})}
+	caller.mu.Unlock()
+	janitor.Sweep()
+	clock.Advance(time.Minute)
+	assert.Equal(t, TransactionExpired, janitor.Sweep()[0].Kind)
+	clock.Advance(time.Minute)
+	assert.Equal(t, []CleanupEvent{{Kind: TransactionRemoved, Branch: "z9hG4bKstuck", Method: "OPTIONS", Age: 2 * time.Minute}}, janitor.Sweep())
+	assert.Empty(t, caller.Transactions())
+
+	// a call outliving its lifetime is hung up, and ended ones forgotten
+	_, dialog := exampleDialog(t, true)
+	NewCall(caller, dialog, addr)
+	_, ended := exampleDialog(t, false)
+	NewCall(caller, ended, addr)
+	ended.Terminate("BYE")
+	assert.Empty(t, janitor.Sweep())
+	assert.Equal(t, 1, caller.Dialogs().Len())
+
+	cleaned := make(chan CleanupEvent, 4)
+	janitor.OnCleanup = func(event CleanupEvent) { cleaned <- event }
+	janitor.Interval = 10 * time.Minute
+	janitor.Start()
+	defer janitor.Stop()
+	clock.Advance(2 * time.Hour)
+	event := <-cleaned
+	assert.Equal(t, DialogExpired, event.Kind)
+	assert.Equal(t, dialog.ID(), event.Dialog)
+	assert.True(t, dialog.Ended())
+	assert.Equal(t, 0, caller.Dialogs().Len())
+	assert.Equal(t, "BYE", (<-received).Method())
+}
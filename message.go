@@ -5,10 +5,12 @@ Messages are models for the marshaling and unmarshaling of data from and to raw
 */
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/qmuloadmin/slurp/errors"
 )
@@ -18,17 +20,136 @@ var SupportedMethods = [5]string{
 	"INVITE", "REGISTER", "NOTIFY", "SUBSCRIBE", "ACK",
 }
 
-// SupportedResponses is a mapping of support response codes and their text values
+// SupportedResponses is a mapping of support response codes and their text values.
+// It covers RFC 3261 and the common extensions
 var SupportedResponses = map[int]string{
-	200: "OK",
 	100: "Trying",
 	180: "Ringing",
+	181: "Call Is Being Forwarded",
+	182: "Queued",
 	183: "Session Progress",
+	199: "Early Dialog Terminated",
+	200: "OK",
+	202: "Accepted",
+	204: "No Notification",
+	300: "Multiple Choices",
+	301: "Moved Permanently",
+	302: "Moved Temporarily",
+	305: "Use Proxy",
+	380: "Alternative Service",
+	400: "Bad Request",
 	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
 	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	410: "Gone",
+	412: "Conditional Request Failed",
+	413: "Request Entity Too Large",
+	414: "Request-URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Unsupported URI Scheme",
+	417: "Unknown Resource-Priority",
+	420: "Bad Extension",
+	421: "Extension Required",
+	422: "Session Interval Too Small",
+	423: "Interval Too Brief",
+	428: "Use Identity Header",
+	429: "Provide Referrer Identity",
+	433: "Anonymity Disallowed",
+	436: "Bad Identity Info",
+	437: "Unsupported Credential",
+	438: "Invalid Identity Header",
+	439: "First Hop Lacks Outbound Support",
+	440: "Max-Breadth Exceeded",
+	469: "Bad Info Package",
+	470: "Consent Needed",
+	480: "Temporarily Unavailable",
+	481: "Call/Transaction Does Not Exist",
+	482: "Loop Detected",
+	483: "Too Many Hops",
+	484: "Address Incomplete",
+	485: "Ambiguous",
 	486: "Busy Here",
+	487: "Request Terminated",
+	488: "Not Acceptable Here",
+	489: "Bad Event",
+	491: "Request Pending",
+	493: "Undecipherable",
+	494: "Security Agreement Required",
+	500: "Server Internal Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Server Time-out",
+	505: "Version Not Supported",
+	513: "Message Too Large",
+	580: "Precondition Failure",
+	600: "Busy Everywhere",
+	603: "Decline",
+	604: "Does Not Exist Anywhere",
+	606: "Not Acceptable",
+	607: "Unwanted",
+}
+
+// SupportedUriSchemes are the Request-URI schemes requests may use.
+// Requests for any other scheme fail to parse with an UnsupportedUriSchemeError,
+// except service URNs such as urn:service:sos (RFC 5031)
+var SupportedUriSchemes = []string{"sip", "sips", "tel"}
+
+// UriScheme returns the lower case scheme of a URI, or "" if it has none.
+// A host with a port, e.g. biloxi.com:5060, has no scheme
+func UriScheme(uri string) string {
+	colon := strings.Index(uri, ":")
+	if colon <= 0 || strings.ContainsAny(uri[:colon], ".@") {
+		return ""
+	}
+	return strings.ToLower(uri[:colon])
 }
 
+// checkUriScheme returns an UnsupportedUriSchemeError unless the scheme
+// of uri is one of SupportedUriSchemes. URIs without a scheme are let
+// through, as some clients send a bare host
+func checkUriScheme(uri string) error {
+	scheme := UriScheme(uri)
+	if scheme == "" || scheme == "urn" && IsServiceURN(uri) {
+		return nil
+	}
+	for _, supported := range SupportedUriSchemes {
+		if scheme == supported {
+			return nil
+		}
+	}
+	return UnsupportedUriSchemeError{Scheme: scheme}
+}
+
+// DefaultMaxForwards is the Max-Forwards value the RFC recommends for new requests.
+// A Forward of zero renders as this default unless ForwardSet, and parsed
+// messages without the header are given it too
+const DefaultMaxForwards = 70
+
+// DateFormat is the RFC 1123 date format required by the SIP Date header.
+// SIP only permits GMT, so times are always converted to UTC before formatting
+const DateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// AutoDate controls whether a Date header is added to outgoing requests
+// that don't already have one set
+var AutoDate = false
+
+// Priority is the urgency of a request as perceived by the client
+type Priority string
+
+// Priority values defined by RFC 3261. Other values may be received and are kept as-is
+const (
+	PriorityEmergency Priority = "emergency"
+	PriorityUrgent    Priority = "urgent"
+	PriorityNormal    Priority = "normal"
+	PriorityNonUrgent Priority = "non-urgent"
+)
+
 // Message is the golang model representing an entire SIP message
 type Message interface {
 	Render() string
@@ -38,22 +159,48 @@ type Message interface {
 	Method() string
 	Uri() string
 	Headers() *CommonHeaders
+	// The header section of a parsed message, as received
 	RawHeaders() string
+	// HeaderValue returns the raw value of the first header with the given
+	// name, which may be the long or compact form. Any header can be read,
+	// including those the parser doesn't understand
+	HeaderValue(string) string
 	Control() *CallControlHeaders
 	Payload() []byte
 	StringPayload() string
 	SetPayload([]byte)
+	// Validate checks the message against the RFC, returning every violation found
+	Validate() []Violation
 }
 
 // Contains header information common across all messages
 type CommonHeaders struct {
-	To            Header
-	From          Header
-	Contacts      []Header
-	Forward       int //MaxForwards
+	To       AddressHeader
+	From     AddressHeader
+	Contacts []AddressHeader
+	Forward  int //MaxForwards, see DefaultMaxForwards
+	// ForwardSet is true when Forward holds a value of its own, parsed from
+	// a Max-Forwards header or decremented, so that zero renders as zero
+	ForwardSet    bool
 	UserAgent     string
 	ContentType   string
 	ContentLength int
+	// Date is only rendered when set, or when AutoDate is true
+	Date         time.Time
+	Organization string
+	// Call-IDs of the calls this one refers or returns to
+	InReplyTo []string
+	Subject   string
+	Priority  Priority
+	// Alert-Info and Call-Info are repeatable, and each entry is an *Info
+	AlertInfo []AddressHeader
+	CallInfo  []AddressHeader
+	// Error-Info is only meaningful on failure responses
+	ErrorInfo []AddressHeader
+	// Retry-After in seconds, only rendered when non-zero
+	RetryAfter int
+	// Every header without a typed field above, in the order received
+	Extensions HeaderList
 }
 
 // CallControlHeaders are common headers that are usually only set by the system, not by users
@@ -63,11 +210,22 @@ type CallControlHeaders struct {
 	// [0] = The transport (UDP, TCP)
 	// [1] = The URI
 	Via [][2]string
+	// The raw parameters of each Via, in the same order as Via,
+	// without the leading semicolon. The branch of the topmost Via
+	// is stored separately in ViaBranch
+	ViaParams []string
 	// The branch of the most recent via, or ours if we added it
-	ViaBranch    string
-	CallId       string
-	Sequence     int
+	ViaBranch string
+	CallId    string
+	Sequence  int
+	// The method in the CSeq header. Mostly useful for responses, as
+	// requests always render their own method
+	CSeqMethod   string
 	Authenticate string
+	// Timestamp and TimestampDelay are used for round trip estimates.
+	// Delay is only meaningful on responses, and only rendered when non-zero
+	Timestamp      float64
+	TimestampDelay float64
 }
 
 // Utility functions
@@ -76,21 +234,25 @@ type CallControlHeaders struct {
 // is of the expected type for the given Message implementation
 func validateMethod(line string, method string) (err error) {
 	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return InvalidMessageFormatError(line)
+	}
 	// Make sure that the request's method matches 'method'
 	if !strings.HasPrefix(strings.ToUpper(line), method) {
 
 		err = InvalidMethodError{
 			Expected: method,
-			Actual:   strings.Split(line, " ")[0],
+			Actual:   fields[0],
 		}
 	}
 	// Make sure version is supported. Right now only 2.0 is supported
 	if !strings.HasSuffix(line, "SIP/2.0") {
-		proto := strings.Split(line, " ")[2]
-		version, parseErr := strconv.ParseFloat(
-			strings.Split(proto, "/")[1],
-			32,
-		)
+		name, number, ok := strings.Cut(fields[2], "/")
+		if !ok || !strings.EqualFold(name, "SIP") {
+			return InvalidMessageFormatError(line)
+		}
+		version, parseErr := strconv.ParseFloat(number, 32)
 		if parseErr != nil {
 			return InvalidMessageFormatError(line)
 		}
@@ -101,13 +263,23 @@ func validateMethod(line string, method string) (err error) {
 	return
 }
 
+// requestUri returns the Request-URI of a request line, or "" when it
+// has none
+func requestUri(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
 func parseParams(header string) map[string]string {
 	panic("Not Implemented")
 }
 
 func parseHeaders(lines []string, h *CommonHeaders, c *CallControlHeaders) error {
+	h.Forward = DefaultMaxForwards
 	for i, line := range lines[1:] {
-		var err error
 		// SplitN returns one substring per count, so 2 means "split once"
 		// Go is weird sometimes
 		line = strings.TrimSpace(line)
@@ -116,78 +288,15 @@ func parseHeaders(lines []string, h *CommonHeaders, c *CallControlHeaders) error
 			break
 		}
 		parts := strings.SplitN(line, ":", 2)
-		_type := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		// Match each header with its name, or short form identifier
-		switch strings.ToLower(_type) {
-		// Note: SIP integer values must fit within 32 bit width
-		case "max-forwards":
-			var tempInt int64
-			tempInt, err = strconv.ParseInt(value, 10, 32)
-			h.Forward = int(tempInt)
-		case "contact", "m":
-			// Contact is repeatable. Each Contact can have a friendly name, URI and params
-			// URI parameters are also possible but currently unsupported
-			// split on comma first, which gives us multiple contacts, if present
-			contacts := strings.Split(value, ",")
-			for _, each := range contacts {
-				contact := &Contact{}
-				// split the contact on ; to find params and the value/uri
-				parts := strings.Split(each, ";")
-				nameAndUri := strings.Split(parts[0], "<")
-				contact.SetValue(strings.TrimSpace(nameAndUri[0]))
-				if len(nameAndUri) > 1 {
-					uri := strings.TrimSpace(strings.Replace(nameAndUri[1], ">", "", -1))
-					contact.SetUri(uri)
-				}
-				// Now parse each parameter
-				for _, param := range parts[1:] {
-					parts := strings.SplitN(param, "=", 2)
-					contact.SetParam(strings.ToLower(parts[0]), parts[1])
-				}
-				h.Contacts = append(h.Contacts, contact)
-			}
-		case "content-type", "c":
-
-			h.ContentType = value
-		case "content-length", "l":
-			var tempInt int64
-			tempInt, err = strconv.ParseInt(value, 10, 32)
-			h.ContentLength = int(tempInt)
-		case "via", "v":
-			// strip off all parameters and store them in a slice
-			// at the moment, we ignore them for reading purposes
-			// (we use branch when writing)
-			via := strings.SplitN(value, ";", 2)
-			parts := strings.Split(via[0], " ")
-			transportParts := strings.Split(parts[0], "/")
-			transport := transportParts[len(transportParts)-1]
-			c.Via = append(c.Via, [2]string{
-				transport, parts[1],
-			})
-		case "cseq":
-			var temp int64
-			// NOTE: At the moment, we're going to assume CSeq method is valid
-			parts := strings.Split(value, " ")
-			// CSeq must be 32 bit
-			temp, err = strconv.ParseInt(parts[0], 10, 32)
-			c.Sequence = int(temp)
-		case "call-id", "i":
-			c.CallId = value
-		case "from", "f":
-			if h.From == nil {
-				h.From = NewHeader(&ToFrom{})
-			}
-			err = parseFromTo(value, h.From)
-		case "to", "t":
-			if h.To == nil {
-				h.To = NewHeader(&ToFrom{})
-			}
-			err = parseFromTo(value, h.To)
-		default:
-			log.Printf("Ignoring Unrecognized Header: %s", line)
+		if len(parts) != 2 {
+			return InvalidMessageFormatError(line)
 		}
-		if err != nil {
+		err := parseHeader(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), h, c)
+		switch err.(type) {
+		case nil:
+		case InvalidMessageFormatError:
+			return InvalidMessageFormatError(line)
+		default:
 			message := strings.Join(lines, "")
 			return HeaderParseError{
 				Line:    i,
@@ -198,71 +307,386 @@ func parseHeaders(lines []string, h *CommonHeaders, c *CallControlHeaders) error
 	return nil
 }
 
-func parseFromTo(value string, from Header) (err error) {
-	// split off main header from parameters
-	params := strings.Split(value, ";")
-	// assign the alias/name and uri separately
-	// format is NAME [space] <URI>
-	// but NAME may be in "" to include a space
-	// so for now, split on angle bracket, even though this isn't perfect
-	parts := strings.Split(params[0], "<")
-	from.SetValue(strings.TrimSpace(parts[0]))
-	from.SetUri(strings.Replace(parts[1], ">", "", 1))
+// parseHeader sets the field of the named header from its value, adding
+// it to the Extensions when it has none. Repeatable headers are appended
+// to. It fails with an InvalidMessageFormatError when a part of the value
+// is missing, or the error of the value that doesn't parse
+func parseHeader(name, value string, h *CommonHeaders, c *CallControlHeaders) (err error) {
+	// Match each header with its canonical name, which expands the
+	// compact forms
+	switch canonicalName(name) {
+	// Note: SIP integer values must fit within 32 bit width
+	case "max-forwards":
+		var tempInt int64
+		tempInt, err = strconv.ParseInt(value, 10, 32)
+		h.Forward, h.ForwardSet = int(tempInt), true
+	case "contact":
+		// Contact is repeatable. Each Contact can have a friendly name, URI and params
+		// URI parameters are also possible but currently unsupported
+		// split on comma first, which gives us multiple contacts, if present
+		// commas and semicolons within a quoted display name or the
+		// angle brackets don't separate anything
+		contacts := splitUnquoted(value, ',')
+		for _, each := range contacts {
+			contact := newContact()
+			contact.parse(each)
+			h.Contacts = append(h.Contacts, contact)
+		}
+	case "content-type":
+
+		h.ContentType = value
+	case "content-length":
+		var tempInt int64
+		tempInt, err = strconv.ParseInt(value, 10, 32)
+		h.ContentLength = int(tempInt)
+	case "via":
+		// strip off all parameters and store them separately
+		// so they can be echoed back in responses
+		via := strings.SplitN(value, ";", 2)
+		parts := strings.Fields(via[0])
+		if len(parts) < 2 {
+			// the protocol or sent-by is missing
+			return InvalidMessageFormatError(value)
+		}
+		transportParts := strings.Split(parts[0], "/")
+		transport := transportParts[len(transportParts)-1]
+		c.Via = append(c.Via, [2]string{
+			transport, parts[1],
+		})
+		params := ""
+		if len(via) > 1 {
+			params = strings.TrimSpace(via[1])
+		}
+		if len(c.Via) == 1 {
+			c.ViaBranch, params = extractBranch(params)
+		}
+		c.ViaParams = append(c.ViaParams, params)
+	case "cseq":
+		var temp int64
+		// NOTE: At the moment, we're going to assume CSeq method is valid
+		parts := strings.Fields(value)
+		if len(parts) == 0 {
+			return InvalidMessageFormatError(value)
+		}
+		// CSeq must be 32 bit
+		temp, err = strconv.ParseInt(parts[0], 10, 32)
+		c.Sequence = int(temp)
+		if len(parts) > 1 {
+			c.CSeqMethod = strings.ToUpper(parts[1])
+		}
+	case "call-id":
+		c.CallId = value
+	case "date":
+		h.Date, err = time.Parse(DateFormat, value)
+	case "timestamp":
+		// the timestamp may be followed by an optional delay value
+		parts := strings.Fields(value)
+		if len(parts) == 0 {
+			return InvalidMessageFormatError(value)
+		}
+		c.Timestamp, err = strconv.ParseFloat(parts[0], 64)
+		if err == nil && len(parts) > 1 {
+			c.TimestampDelay, err = strconv.ParseFloat(parts[1], 64)
+		}
+	case "retry-after":
+		// the value may be followed by a comment and parameters
+		var tempInt int64
+		fields := strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ';' || r == '('
+		})
+		if len(fields) == 0 {
+			return InvalidMessageFormatError(value)
+		}
+		tempInt, err = strconv.ParseInt(fields[0], 10, 32)
+		h.RetryAfter = int(tempInt)
+	case "organization":
+		h.Organization = value
+	case "in-reply-to":
+		// In-Reply-To is a comma separated list of Call-IDs and is repeatable
+		for _, id := range strings.Split(value, ",") {
+			h.InReplyTo = append(h.InReplyTo, strings.TrimSpace(id))
+		}
+	case "subject":
+		h.Subject = value
+	case "priority":
+		h.Priority = Priority(strings.ToLower(value))
+	case "alert-info":
+		var infos []AddressHeader
+		infos, err = parseInfo(value)
+		h.AlertInfo = append(h.AlertInfo, infos...)
+	case "call-info":
+		var infos []AddressHeader
+		infos, err = parseInfo(value)
+		h.CallInfo = append(h.CallInfo, infos...)
+	case "error-info":
+		var infos []AddressHeader
+		infos, err = parseInfo(value)
+		h.ErrorInfo = append(h.ErrorInfo, infos...)
+	case "from":
+		if h.From == nil {
+			h.From = newToFrom()
+		}
+		err = parseFromTo(value, h.From)
+	case "to":
+		if h.To == nil {
+			h.To = newToFrom()
+		}
+		err = parseFromTo(value, h.To)
+	default:
+		h.Extensions.Add(name, value)
+	}
+	return
+}
+
+func parseFromTo(value string, from AddressHeader) (err error) {
+	// the display name may be quoted, holding any UTF-8, and the URI is in
+	// angle brackets unless there is no display name
+	name, uri, params := splitNameAddr(value)
+	from.SetValue(name)
+	from.SetUri(uri)
 	// now find the from tag, if present, and store it
-	for _, param := range params[1:] {
+	for _, param := range strings.Split(params, ";") {
 		param = strings.TrimSpace(param)
 		if strings.HasPrefix(param, "tag=") {
-			parts = strings.SplitN(param, "=", 2)
-			from.SetParam("tag", parts[1])
+			from.SetParam("tag", strings.TrimPrefix(param, "tag="))
 		}
 	}
 	return
 }
 
+// clearHeader empties the field of the named header, reporting whether it
+// has one. Headers that don't are Extensions, which are left alone
+func clearHeader(name string, h *CommonHeaders, c *CallControlHeaders) bool {
+	switch canonicalName(name) {
+	case "max-forwards":
+		h.Forward, h.ForwardSet = 0, false
+	case "contact":
+		h.Contacts = nil
+	case "content-type":
+		h.ContentType = ""
+	case "content-length":
+		h.ContentLength = 0
+	case "via":
+		c.Via, c.ViaParams, c.ViaBranch = nil, nil, ""
+	case "cseq":
+		c.Sequence, c.CSeqMethod = 0, ""
+	case "call-id":
+		c.CallId = ""
+	case "date":
+		h.Date = time.Time{}
+	case "timestamp":
+		c.Timestamp, c.TimestampDelay = 0, 0
+	case "retry-after":
+		h.RetryAfter = 0
+	case "organization":
+		h.Organization = ""
+	case "in-reply-to":
+		h.InReplyTo = nil
+	case "subject":
+		h.Subject = ""
+	case "priority":
+		h.Priority = ""
+	case "alert-info":
+		h.AlertInfo = nil
+	case "call-info":
+		h.CallInfo = nil
+	case "error-info":
+		h.ErrorInfo = nil
+	case "from":
+		h.From = nil
+	case "to":
+		h.To = nil
+	default:
+		return false
+	}
+	return true
+}
+
+// rawHeaderSection returns the header lines of a message's head, without the start line
+func rawHeaderSection(head string) string {
+	parts := strings.SplitN(head, "\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// headerValue finds the value of the first header called name in a raw header section
+func headerValue(raw string, name string) string {
+	name = canonicalName(name)
+	for _, line := range strings.Split(raw, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && canonicalName(parts[0]) == name {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// splitBody separates the header section of a message from its body,
+// which follows the first empty line
+func splitBody(message string) (string, []byte) {
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if index := strings.Index(message, separator); index >= 0 {
+			return message[:index], []byte(message[index+len(separator):])
+		}
+	}
+	return message, nil
+}
+
+// extractBranch removes the branch parameter from a raw Via parameter
+// string, returning the branch and the remaining parameters
+func extractBranch(params string) (branch string, rest string) {
+	var kept []string
+	for _, param := range strings.Split(params, ";") {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(strings.ToLower(param), "branch=") {
+			branch = param[len("branch="):]
+		} else if param != "" {
+			kept = append(kept, param)
+		}
+	}
+	return branch, strings.Join(kept, ";")
+}
+
+// viaParam looks up a parameter in a raw Via parameter string. ok is
+// true when it is present, with or without a value
+func viaParam(params, name string) (value string, ok bool) {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// splitHeaderValues splits a comma separated header value, ignoring
+// commas that are within angle brackets or quotes
+func splitHeaderValues(value string) (values []string) {
+	var quoted, bracketed bool
+	start := 0
+	for i, char := range value {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case char == '<' && !quoted:
+			bracketed = true
+		case char == '>' && !quoted:
+			bracketed = false
+		case char == ',' && !quoted && !bracketed:
+			values = append(values, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, strings.TrimSpace(value[start:]))
+}
+
+// parseInfo parses Info style headers, in the form <URI> *(;param[=value])
+func parseInfo(value string) (infos []AddressHeader, err error) {
+	for _, each := range splitHeaderValues(value) {
+		start := strings.Index(each, "<")
+		end := strings.Index(each, ">")
+		if start < 0 || end < start {
+			return nil, InvalidMessageFormatError(value)
+		}
+		info := NewHeader(&Info{}).SetUri(each[start+1 : end])
+		for _, param := range strings.Split(each[end+1:], ";")[1:] {
+			parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(parts) == 1 {
+				info.SetParam(strings.ToLower(parts[0]), "")
+			} else {
+				info.SetParam(strings.ToLower(parts[0]), parts[1])
+			}
+		}
+		infos = append(infos, info)
+	}
+	return
+}
+
+// defaultSupported returns the Supported line INVITE and REGISTER render
+// with, unless they have a Supported header of their own, e.g. parsed
+func defaultSupported(h CommonHeaders) string {
+	for _, field := range h.Extensions.Fields() {
+		if canonicalName(field.Name) == "supported" {
+			return ""
+		}
+	}
+	return "\r\nSupported: SUBSCRIBE, NOTIFY"
+}
+
+// renderRequestHeaders renders the headers of an outgoing request, which
+// are given a Date when AutoDate is set
+func renderRequestHeaders(h CommonHeaders, c CallControlHeaders) string {
+	if h.Date.IsZero() && AutoDate {
+		h.Date = time.Now()
+	}
+	return renderHeaders(h, c)
+}
+
 func renderHeaders(h CommonHeaders, c CallControlHeaders) string {
 	lines := make([]string, 0, 10)
 	// Via, From, Contact, Call-ID and CSeq must always be included
 
 	// For sending a request, as we are a client or a server and not a Proxy
-	// we only should send one Via, ourselves.
-	via := fmt.Sprintf(
-		// TODO need to update transport dynamically once infra is built
-		"Via: SIP/2.0/%s %s;branch=%s",
-		c.Via[0][0], c.Via[0][1], c.ViaBranch,
-	)
-	lines = append(lines, via)
+	// we only should send one Via, ourselves. Responses, however, echo
+	// every Via of the request they answer.
+	for i, each := range c.Via {
+		via := fmt.Sprintf(
+			// TODO need to update transport dynamically once infra is built
+			"Via: SIP/2.0/%s %s",
+			each[0], each[1],
+		)
+		if i == 0 {
+			via += ";branch=" + c.ViaBranch
+		}
+		if i < len(c.ViaParams) && c.ViaParams[i] != "" {
+			via += ";" + c.ViaParams[i]
+		}
+		lines = append(lines, via)
+	}
 
 	// set max forwards. RFC recommends this goes as one of first fields
-	if h.Forward == 0 {
-		// Since we aren't a proxy, we're never forwarding requests. Set it to 70.
-		h.Forward = 70
+	if h.Forward == 0 && !h.ForwardSet {
+		// a new request starts its hop count at 70
+		h.Forward = DefaultMaxForwards
 	}
 	forwards := fmt.Sprintf("Max-Forwards: %d", h.Forward)
 	lines = append(lines, forwards)
 
-	from := fmt.Sprintf(
-		// when rendering, there will always be a tag in From
-		"From: %s <%s>;tag=%s",
-		h.From.Value(), h.From.Uri(), h.From.Param("tag"),
-	)
-	lines = append(lines, from)
+	// From and To are only missing from invalid messages that were
+	// parsed, which still render, e.g. to preserve their wire format
+	if h.From != nil {
+		from := fmt.Sprintf(
+			// when rendering, there will always be a tag in From
+			"From: %s <%s>;tag=%s",
+			h.From.Value(), h.From.Uri(), h.From.Param("tag"),
+		)
+		lines = append(lines, from)
+	}
 
 	// If To is set, populate To next
-	to := fmt.Sprintf(
-		"To: %s <%s>",
-		h.To.Value(), h.To.Uri(),
-	)
-	if h.To.Param("tag") != "" {
-		to += ";tag=" + h.To.Param("tag")
+	if h.To != nil {
+		to := fmt.Sprintf(
+			"To: %s <%s>",
+			h.To.Value(), h.To.Uri(),
+		)
+		if h.To.Param("tag") != "" {
+			to += ";tag=" + h.To.Param("tag")
+		}
+		lines = append(lines, to)
 	}
-	lines = append(lines, to)
 
 	// Set contact always. If Contact is empty, use From
-	if len(h.Contacts) == 0 {
+	if len(h.Contacts) == 0 && h.From != nil {
 		contact := NewHeader(&Contact{}).SetUri(h.From.Uri()).SetValue(h.From.Value())
-		h.Contacts = []Header{contact}
+		h.Contacts = []AddressHeader{contact}
 	}
 	for _, contact := range h.Contacts {
+		if isWildcard(contact) {
+			lines = append(lines, "Contact: *")
+			continue
+		}
 		result := "Contact: " + strings.Join([]string{contact.Value(),
 			fmt.Sprintf("<%s>", contact.Uri())},
 			" ")
@@ -274,18 +698,58 @@ func renderHeaders(h CommonHeaders, c CallControlHeaders) string {
 	id := fmt.Sprintf("Call-ID: %s", c.CallId)
 	lines = append(lines, id)
 
-	// set content type and length, if present
+	if !h.Date.IsZero() {
+		lines = append(lines, "Date: "+h.Date.UTC().Format(DateFormat))
+	}
+	if c.Timestamp != 0 {
+		timestamp := "Timestamp: " + strconv.FormatFloat(c.Timestamp, 'f', -1, 64)
+		if c.TimestampDelay != 0 {
+			timestamp += " " + strconv.FormatFloat(c.TimestampDelay, 'f', -1, 64)
+		}
+		lines = append(lines, timestamp)
+	}
+	if h.Organization != "" {
+		lines = append(lines, "Organization: "+h.Organization)
+	}
+	if h.RetryAfter != 0 {
+		lines = append(lines, fmt.Sprintf("Retry-After: %d", h.RetryAfter))
+	}
+	if len(h.InReplyTo) > 0 {
+		lines = append(lines, "In-Reply-To: "+strings.Join(h.InReplyTo, ", "))
+	}
+	if h.Subject != "" {
+		lines = append(lines, "Subject: "+h.Subject)
+	}
+	if h.Priority != "" {
+		lines = append(lines, "Priority: "+string(h.Priority))
+	}
+	for _, info := range h.AlertInfo {
+		lines = append(lines, fmt.Sprintf("Alert-Info: <%s>%s", info.Uri(), info.ParamString()))
+	}
+	for _, info := range h.CallInfo {
+		lines = append(lines, fmt.Sprintf("Call-Info: <%s>%s", info.Uri(), info.ParamString()))
+	}
+	for _, info := range h.ErrorInfo {
+		lines = append(lines, fmt.Sprintf("Error-Info: <%s>%s", info.Uri(), info.ParamString()))
+	}
+	for _, field := range h.Extensions.Fields() {
+		lines = append(lines, field.Name+": "+field.Value)
+	}
+
+	// set content type, if present, and length, which is 0 for messages
+	// without a body, as stream transports need it to find the end
 	if h.ContentType != "" {
-		_type := fmt.Sprintf("Content-Type: %s", h.ContentType)
-		length := fmt.Sprintf("Content-Length: %d", h.ContentLength)
-		lines = append(lines, _type)
-		lines = append(lines, length)
+		lines = append(lines, fmt.Sprintf("Content-Type: %s", h.ContentType))
 	}
+	lines = append(lines, fmt.Sprintf("Content-Length: %d", h.ContentLength))
 
+	spellHeaders(lines)
 	return strings.Join(lines, "\r\n")
 }
 
-// TODO
-func generateTag() {
-
+// generateTag creates a random tag for use in From and To headers
+func generateTag() string {
+	data := make([]byte, 4)
+	rand.Read(data)
+	return hex.EncodeToString(data)
 }
@@ -5,8 +5,10 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	. "github.com/qmuloadmin/slurp/errors"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -66,6 +68,7 @@ From: Geoff <gharding@test.com>;tag=5gh941c
 To: Sally <sally@nasa.gov>
 Contact: Geoff <gharding@test.com>
 Call-ID: %s
+Content-Length: 0
 CSeq: 4 INVITE
 Supported: SUBSCRIBE, NOTIFY
 
@@ -86,6 +89,29 @@ Supported: SUBSCRIBE, NOTIFY
 	assert.Equal(t, expected, rendered)
 }
 
+func TestRenderInviteSupported(t *testing.T) {
+	// a parsed INVITE renders its own Supported header, not a second one
+	var invite Invite
+	assert.Nil(t, invite.Parse(strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKnashds8",
+		"Max-Forwards: 70",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710",
+		"CSeq: 314159 INVITE",
+		"Supported: 100rel, timer",
+		"Content-Length: 0",
+		"", "",
+	}, "\r\n")))
+	rendered := invite.Render()
+	assert.Equal(t, 1, strings.Count(rendered, "Supported:"))
+	assert.Contains(t, rendered, "Supported: 100rel, timer\r\n")
+	var again Invite
+	assert.Nil(t, again.Parse(rendered))
+	assert.Equal(t, []string{"100rel", "timer"}, again.Headers().Extensions.GetAll("Supported"))
+}
+
 func TestRenderRegister(t *testing.T) {
 	callId := uuid.New()
 	expected := fmt.Sprintf(`REGISTER sip:nasa.gov SIP/2.0
@@ -95,6 +121,7 @@ From: Sally <sally@nasa.gov>;tag=5gh941c
 To: Sally <sally@nasa.gov>
 Contact: Sally <sally@nasa.gov>
 Call-ID: %s
+Content-Length: 0
 CSeq: 4 REGISTER
 Supported: SUBSCRIBE, NOTIFY
 
@@ -114,3 +141,492 @@ Supported: SUBSCRIBE, NOTIFY
 	t.Log("Rendered Register: " + rendered)
 	assert.Equal(t, expected, rendered)
 }
+
+func TestDateTimestampOrganization(t *testing.T) {
+	text := strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"Date: Sat, 13 Nov 2010 23:29:00 GMT",
+		"Timestamp: 54.3 0.25",
+		"Organization: Boxes by Bob",
+	}, "\r\n")
+	message := Invite{}
+	assert.Nil(t, message.Parse(text))
+	expected := time.Date(2010, 11, 13, 23, 29, 0, 0, time.UTC)
+	assert.True(t, expected.Equal(message.Headers().Date))
+	assert.Equal(t, 54.3, message.Control().Timestamp)
+	assert.Equal(t, 0.25, message.Control().TimestampDelay)
+	assert.Equal(t, "Boxes by Bob", message.Headers().Organization)
+
+	message.Control().ViaBranch = "z9hG4bK776asdhds"
+	rendered := message.Render()
+	assert.Contains(t, rendered, "Date: Sat, 13 Nov 2010 23:29:00 GMT\r\n")
+	assert.Contains(t, rendered, "Timestamp: 54.3 0.25\r\n")
+	assert.Contains(t, rendered, "Organization: Boxes by Bob\r\n")
+
+	// an empty Timestamp fails to parse
+	assert.NotNil(t, new(Invite).Parse(strings.Replace(text, "Timestamp: 54.3 0.25", "Timestamp:", 1)))
+
+	// only requests are given a Date automatically
+	AutoDate = true
+	defer func() { AutoDate = false }()
+	message.Headers().Date = time.Time{}
+	assert.Contains(t, message.Render(), "\r\nDate: ")
+	response := NewResponse(&message, 200)
+	assert.NotContains(t, response.Render(), "\r\nDate: ")
+}
+
+func TestCallMetadataHeaders(t *testing.T) {
+	text := strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"In-Reply-To: 70710@saturn.bell-tel.com, 17320@saturn.bell-tel.com",
+		"s: Need more boxes",
+		"Priority: Emergency",
+	}, "\r\n")
+	message := Invite{}
+	assert.Nil(t, message.Parse(text))
+	assert.Equal(t, []string{"70710@saturn.bell-tel.com", "17320@saturn.bell-tel.com"}, message.Headers().InReplyTo)
+	assert.Equal(t, "Need more boxes", message.Headers().Subject)
+	assert.Equal(t, PriorityEmergency, message.Headers().Priority)
+
+	rendered := message.Render()
+	assert.Contains(t, rendered, "In-Reply-To: 70710@saturn.bell-tel.com, 17320@saturn.bell-tel.com\r\n")
+	assert.Contains(t, rendered, "Subject: Need more boxes\r\n")
+	assert.Contains(t, rendered, "Priority: emergency\r\n")
+}
+
+func TestInfoHeaders(t *testing.T) {
+	text := strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"Alert-Info: <http://www.example.com/sounds/moo.wav>",
+		"Call-Info: <http://wwww.example.com/alice/photo.jpg> ;purpose=icon, <http://www.example.com/alice/?a=1,2>;purpose=info",
+	}, "\r\n")
+	message := Invite{}
+	assert.Nil(t, message.Parse(text))
+	headers := message.Headers()
+	assert.Equal(t, 1, len(headers.AlertInfo))
+	assert.Equal(t, "http://www.example.com/sounds/moo.wav", headers.AlertInfo[0].Uri())
+	if assert.Equal(t, 2, len(headers.CallInfo)) {
+		assert.Equal(t, "http://wwww.example.com/alice/photo.jpg", headers.CallInfo[0].Uri())
+		assert.Equal(t, "icon", headers.CallInfo[0].Param("purpose"))
+		assert.Equal(t, "http://www.example.com/alice/?a=1,2", headers.CallInfo[1].Uri())
+		assert.Equal(t, "info", headers.CallInfo[1].Param("purpose"))
+	}
+
+	rendered := message.Render()
+	assert.Contains(t, rendered, "Alert-Info: <http://www.example.com/sounds/moo.wav>\r\n")
+	assert.Contains(t, rendered, "Call-Info: <http://wwww.example.com/alice/photo.jpg>;purpose=icon\r\n")
+}
+
+func TestNewResponse(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		invite := Invite{}
+		assert.Nil(t, invite.Parse(string(data)))
+		ErrorInfoHook = func(request Message, code int) []AddressHeader {
+			return []AddressHeader{NewHeader(&Info{}).SetUri("sip:not-in-service@atlanta.com")}
+		}
+		defer func() { ErrorInfoHook = nil }()
+
+		response := NewResponse(&invite, 486)
+		assert.Equal(t, 486, response.StatusCode())
+		assert.Equal(t, "Busy Here", response.Reason())
+		assert.Equal(t, "INVITE", response.Method())
+		assert.NotEqual(t, "", response.Headers().To.Param("tag"))
+		assert.Equal(t, "", invite.Headers().To.Param("tag"))
+
+		rendered := response.Render()
+		assert.True(t, strings.HasPrefix(rendered, "SIP/2.0 486 Busy Here\r\n"))
+		assert.Contains(t, rendered, "Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n")
+		assert.Contains(t, rendered, "Error-Info: <sip:not-in-service@atlanta.com>\r\n")
+		assert.Contains(t, rendered, "CSeq: 314159 INVITE\r\n")
+
+		parsed := Response{}
+		assert.Nil(t, parsed.Parse(rendered))
+		assert.Equal(t, 486, parsed.StatusCode())
+		assert.Equal(t, "INVITE", parsed.Method())
+		assert.Equal(t, "sip:not-in-service@atlanta.com", parsed.Headers().ErrorInfo[0].Uri())
+	}
+}
+
+func TestRedirector(t *testing.T) {
+	redirect := Response{}
+	text := strings.Join([]string{
+		"SIP/2.0 302 Moved Temporarily",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To: Bob <sip:bob@biloxi.com>;tag=a6c85cf",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"Contact: <sip:bob@office.biloxi.com>;q=0.5, <sip:bob@home.biloxi.com>;q=0.9",
+		"Contact: <sip:bob@old.biloxi.com>;expires=0",
+	}, "\r\n")
+	assert.Nil(t, redirect.Parse(text))
+	assert.True(t, IsRedirect(&redirect))
+
+	redirector := NewRedirector("sip:bob@biloxi.com", 1)
+	assert.Nil(t, redirector.HandleResponse(&redirect))
+	target, ok := redirector.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "sip:bob@home.biloxi.com", target)
+	target, ok = redirector.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "sip:bob@office.biloxi.com", target)
+	_, ok = redirector.Next()
+	assert.False(t, ok)
+
+	// the same targets again are a loop, and the limit is exceeded anyway
+	assert.IsType(t, TooManyRedirectsError{}, redirector.HandleResponse(&redirect))
+	redirector.MaxRedirects = 5
+	assert.IsType(t, RedirectLoopError{}, redirector.HandleResponse(&redirect))
+
+	// Contacts without an expires parameter expire per the Expires header
+	assert.Nil(t, redirect.Parse(strings.Join([]string{
+		"SIP/2.0 302 Moved Temporarily",
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To: Bob <sip:bob@biloxi.com>;tag=a6c85cf",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"Contact: <sip:bob@office.biloxi.com>;q=0.9, <sip:bob@home.biloxi.com>;q=0.5;expires=120",
+		"Expires: 30",
+	}, "\r\n")))
+	clock := NewFakeClock(time.Now())
+	redirector = NewRedirector("sip:bob@biloxi.com", 1)
+	redirector.Clock = clock
+	assert.Nil(t, redirector.HandleResponse(&redirect))
+	clock.Advance(time.Minute)
+	target, ok = redirector.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "sip:bob@home.biloxi.com", target)
+	_, ok = redirector.Next()
+	assert.False(t, ok)
+
+	// and aren't tried at all when it is 0
+	redirect.Headers().Extensions.Set("Expires", "0")
+	redirector = NewRedirector("sip:bob@biloxi.com", 1)
+	assert.Nil(t, redirector.HandleResponse(&redirect))
+	target, ok = redirector.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "sip:bob@home.biloxi.com", target)
+	_, ok = redirector.Next()
+	assert.False(t, ok)
+}
+
+func TestResponseClasses(t *testing.T) {
+	assert.Equal(t, Provisional, ClassOf(183))
+	assert.Equal(t, GlobalFailure, ClassOf(603))
+	assert.Equal(t, UnknownClass, ClassOf(99))
+	assert.True(t, IsProvisional(100))
+	assert.True(t, IsSuccess(202))
+	assert.True(t, IsFinal(487))
+	assert.False(t, IsFinal(180))
+	assert.True(t, IsFailure(503))
+	assert.Equal(t, "Request Pending", ReasonPhrase(491))
+	// unknown codes use the reason of the x00 code of their class
+	assert.Equal(t, "Bad Request", ReasonPhrase(499))
+}
+
+func TestValidate(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		message := Invite{}
+		assert.Nil(t, message.Parse(string(data)))
+		assert.Empty(t, message.Validate())
+	}
+	text := strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>",
+		"CSeq: 1 BYE",
+		"Content-Type: text/plain",
+		"Content-Length: 10",
+		"",
+		"hello",
+	}, "\r\n")
+	message := Invite{}
+	assert.Nil(t, message.Parse(text))
+	assert.Equal(t, "hello", message.StringPayload())
+	headers := []string{}
+	for _, violation := range message.Validate() {
+		headers = append(headers, violation.Header)
+	}
+	assert.Equal(t, []string{"Via", "Call-ID", "CSeq", "Max-Forwards", "Contact", "Content-Length", "From"}, headers)
+}
+
+func TestValidateMandatoryHeaders(t *testing.T) {
+	request := func(method string, extra ...string) string {
+		return strings.Join(append([]string{
+			method + " sip:bob@biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+			"Max-Forwards: 70",
+			"To: Bob <sip:bob@biloxi.com>",
+			"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: a84b4c76e66710",
+			"CSeq: 1 " + method,
+		}, extra...), "\r\n") + "\r\n\r\n"
+	}
+	contact := "Contact: <sip:alice@pc33.atlanta.com>"
+	for _, test := range []struct {
+		method  string
+		headers []string
+		missing []string
+	}{
+		{"OPTIONS", nil, nil},
+		{"BYE", nil, nil},
+		{"INVITE", nil, []string{"Contact"}},
+		{"INVITE", []string{contact}, nil},
+		{"UPDATE", nil, []string{"Contact"}},
+		{"SUBSCRIBE", []string{contact}, []string{"Event"}},
+		{"SUBSCRIBE", []string{contact, "o: presence"}, nil},
+		{"NOTIFY", []string{"Event: presence"}, []string{"Subscription-State", "Contact"}},
+		{"NOTIFY", []string{"Event: presence", "Subscription-State: active", contact}, nil},
+		{"REFER", []string{contact}, []string{"Refer-To"}},
+		{"REFER", []string{contact, "Refer-To: <sip:carol@chicago.com>"}, nil},
+		{"PRACK", nil, []string{"RAck"}},
+		{"PRACK", []string{"RAck: 1 1 INVITE"}, nil},
+	} {
+		m, err := ParseMessage(request(test.method, test.headers...))
+		assert.Nil(t, err)
+		var missing []string
+		for _, violation := range m.Validate() {
+			missing = append(missing, violation.Header)
+		}
+		assert.Equal(t, test.missing, missing, "%s with %v", test.method, test.headers)
+	}
+
+	// a request received without Max-Forwards is invalid, one being built
+	// isn't as it renders the default
+	m, err := ParseMessage(strings.Replace(request("OPTIONS"), "Max-Forwards: 70\r\n", "", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{{Header: "Max-Forwards", Reason: "missing mandatory header"}}, m.Validate())
+	built := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	built.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	built.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1")
+	built.Control().Via = [][2]string{{"UDP", "pc33.atlanta.com"}}
+	built.Control().CallId = "a84b4c76e66710"
+	built.Control().Sequence = 1
+	assert.Empty(t, built.Validate())
+}
+
+func TestPreserveWireFormat(t *testing.T) {
+	PreserveWireFormat = true
+	defer func() { PreserveWireFormat = false }()
+	text := strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"v: SIP/2.0/UDP pc33.atlanta.com ;branch=z9hG4bK776asdhds",
+		"Max-Forwards: 70",
+		"To: \"Bob\" <sip:bob@biloxi.com>",
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"call-id: a84b4c76e66710@pc33.atlanta.com",
+		"CSeq: 314159 INVITE",
+		"X-Custom: kept as is",
+		"Content-Length: 0",
+	}, "\r\n") + "\r\n\r\n"
+	message := Invite{}
+	assert.Nil(t, message.Parse(text))
+	assert.Equal(t, text, message.Render())
+
+	message.Headers().Subject = "added"
+	message.Control().CallId = "changed@pc33.atlanta.com"
+	expected := strings.Replace(text, "call-id: a84b4c76e66710@pc33.atlanta.com", "Call-ID: changed@pc33.atlanta.com", 1)
+	expected = strings.Replace(expected, "\r\n\r\n", "\r\nSubject: added\r\n\r\n", 1)
+	assert.Equal(t, expected, message.Render())
+
+	// messages missing their To or From still parse, and render as received
+	for _, start := range []string{"INVITE sip:bob@biloxi.com SIP/2.0", "REGISTER sip:biloxi.com SIP/2.0", "BYE sip:bob@biloxi.com SIP/2.0", "SIP/2.0 200 OK"} {
+		for _, missing := range []string{"To:", "From:"} {
+			var lines []string
+			for _, line := range strings.Split(text, "\r\n")[1:] {
+				if !strings.HasPrefix(line, missing) {
+					lines = append(lines, line)
+				}
+			}
+			received := start + "\r\n" + strings.Join(lines, "\r\n")
+			m, err := ParseMessage(received)
+			if assert.Nil(t, err, start) {
+				assert.Equal(t, received, m.Render(), start)
+				assert.Equal(t, strings.TrimSuffix(missing, ":"), m.Validate()[0].Header, start)
+			}
+		}
+	}
+}
+
+func TestRawHeaders(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/register.sip"); err == nil {
+		message := Register{}
+		assert.Nil(t, message.Parse(string(data)))
+		assert.True(t, strings.HasPrefix(message.RawHeaders(), "Via: SIP/2.0/TCP"))
+		assert.Equal(t, "a84b4c76e66710@pc33.atlanta.com", message.HeaderValue("i"))
+		assert.Equal(t, "314 REGISTER", message.HeaderValue("cseq"))
+		assert.Equal(t, "", message.HeaderValue("Subject"))
+	}
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		message := Invite{}
+		assert.Nil(t, message.Parse(string(data)))
+		assert.Equal(t, "Should Be Ignored", message.HeaderValue("Fake"))
+	}
+}
+
+func TestHeaderList(t *testing.T) {
+	list := HeaderList{}
+	list.Add("Allow", "INVITE, ACK")
+	list.Add("X-First", "1")
+	list.Add("allow", "BYE")
+	assert.Equal(t, "INVITE, ACK", list.Get("ALLOW"))
+	assert.Equal(t, []string{"INVITE", "ACK", "BYE"}, list.GetAll("Allow"))
+	list.Set("Allow", "OPTIONS")
+	assert.Equal(t, []HeaderField{{"Allow", "OPTIONS"}, {"X-First", "1"}}, list.Fields())
+	list.Add("k", "100rel")
+	assert.Equal(t, "100rel", list.Get("Supported"))
+	list.Remove("x-first")
+	assert.Equal(t, 2, list.Len())
+
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		message := Invite{}
+		assert.Nil(t, message.Parse(string(data)))
+		assert.Equal(t, "Should Be Ignored", message.Headers().Extensions.Get("fake"))
+		assert.Contains(t, message.Render(), "\r\nFake: Should Be Ignored\r\n")
+	}
+}
+
+func TestHeaderName(t *testing.T) {
+	assert.Equal(t, "CSeq", HeaderName("CSEQ"))
+	assert.Equal(t, "P-Asserted-Identity", HeaderName("p-asserted-identity"))
+	for compact, name := range map[string]string{
+		"a": "Accept-Contact",
+		"b": "Referred-By",
+		"c": "Content-Type",
+		"d": "Request-Disposition",
+		"e": "Content-Encoding",
+		"f": "From",
+		"i": "Call-ID",
+		"j": "Reject-Contact",
+		"k": "Supported",
+		"l": "Content-Length",
+		"m": "Contact",
+		"n": "Identity-Info",
+		"o": "Event",
+		"r": "Refer-To",
+		"s": "Subject",
+		"t": "To",
+		"u": "Allow-Events",
+		"v": "Via",
+		"x": "Session-Expires",
+		"y": "Identity",
+	} {
+		assert.Equal(t, name, HeaderName(compact), compact)
+		assert.Equal(t, name, HeaderName(strings.ToUpper(compact)), compact)
+	}
+
+	// headers given in compact form are found by their long name
+	refer, err := ParseMessage(strings.Join([]string{
+		"REFER sip:bob@192.0.2.4 SIP/2.0",
+		"v: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKnashds8",
+		"t: <sip:bob@biloxi.com>;tag=a6c85cf",
+		"f: <sip:alice@atlanta.com>;tag=1928301774",
+		"i: a84b4c76e66710",
+		"CSeq: 2 REFER",
+		"r: <sip:carol@chicago.com>",
+		"b: <sip:alice@atlanta.com>",
+		"x: 1800;refresher=uac",
+		"l: 0",
+		"", "",
+	}, "\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "<sip:carol@chicago.com>", refer.HeaderValue("Refer-To"))
+	assert.Equal(t, "<sip:carol@chicago.com>", refer.Headers().Extensions.Get("Refer-To"))
+	assert.Equal(t, "<sip:alice@atlanta.com>", refer.Headers().Extensions.Get("Referred-By"))
+	assert.Equal(t, "1800;refresher=uac", refer.HeaderValue("Session-Expires"))
+
+	request, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	request.Headers().Extensions.Add("o", "presence")
+	request.Headers().Extensions.Add("x-custom", "1")
+	rendered := request.Render()
+	assert.Contains(t, rendered, "\r\nEvent: presence\r\n")
+	assert.Contains(t, rendered, "\r\nX-Custom: 1\r\n")
+
+	// a quirky peer gets the spelling it expects
+	SetHeaderCasing("call-id", "Call-Id")
+	defer SetHeaderCasing("call-id", "")
+	assert.Contains(t, request.Render(), "\r\nCall-Id: ")
+	SetHeaderCasing("call-id", "")
+	assert.Contains(t, request.Render(), "\r\nCall-ID: ")
+}
+
+func TestContactQ(t *testing.T) {
+	contacts := []AddressHeader{
+		NewHeader(&Contact{}).SetUri("sip:a@biloxi.com"),
+		NewHeader(&Contact{}).SetUri("sip:b@biloxi.com").SetParam("q", "0.1"),
+		NewHeader(&Contact{}).SetUri("sip:c@biloxi.com").SetParam("q", "bogus"),
+	}
+	contacts[0].(*Contact).SetQ(0.7)
+	assert.Equal(t, ";q=0.7", contacts[0].ParamString())
+	assert.Equal(t, 1.0, ContactQ(contacts[2]))
+	SortByQ(contacts)
+	assert.Equal(t, "sip:c@biloxi.com", contacts[0].Uri())
+	assert.Equal(t, "sip:a@biloxi.com", contacts[1].Uri())
+	assert.Equal(t, "sip:b@biloxi.com", contacts[2].Uri())
+}
+
+func TestParamOrder(t *testing.T) {
+	m, err := ParseMessage(strings.Join([]string{
+		"REGISTER sip:registrar.biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: Bob <sip:bob@biloxi.com>;tag=456248",
+		"Call-ID: 843817637684230@998sdasdh09",
+		"CSeq: 1826 REGISTER",
+		"Contact: <sip:bob@192.0.2.4>;reg-id=1;q=0.5;+sip.instance=\"<urn:uuid:00000000-0000-1000-8000-000A95A0E128>\";expires=7200;ob",
+		"Call-Info: <http://www.example.com/bob/photo.jpg>;purpose=icon;m=nc;z=1;a=2",
+		"Content-Length: 0",
+		"", "",
+	}, "\r\n"))
+	assert.Nil(t, err)
+	// parameters render in the order received, every time
+	contact := m.Headers().Contacts[0]
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, ";reg-id=1;q=0.5;+sip.instance=\"<urn:uuid:00000000-0000-1000-8000-000A95A0E128>\";expires=7200;ob", contact.ParamString())
+		assert.Equal(t, ";purpose=icon;m=nc;z=1;a=2", m.Headers().CallInfo[0].ParamString())
+	}
+
+	// changing a parameter keeps its place, new ones go last
+	contact.SetParam("expires", "60").SetParam("pub-gruu", "\"sip:bob@biloxi.com;gr\"")
+	params := contact.(*Contact).Params()
+	params.Delete("reg-id")
+	assert.Equal(t, ";q=0.5;+sip.instance=\"<urn:uuid:00000000-0000-1000-8000-000A95A0E128>\";expires=60;ob;pub-gruu=\"sip:bob@biloxi.com;gr\"", contact.ParamString())
+	value, ok := params.Get("ob")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+	assert.Equal(t, "60", contact.Param("expires"))
+	assert.Equal(t, 5, params.Len())
+
+	clone := CloneHeader(contact)
+	clone.SetParam("q", "1")
+	assert.Equal(t, "0.5", contact.Param("q"))
+	assert.Equal(t, [2]string{"q", "1"}, clone.(*Contact).Params().Pairs()[0])
+}
+
+func TestUnsupportedUriScheme(t *testing.T) {
+	assert.Equal(t, "sips", UriScheme("SIPS:bob@biloxi.com"))
+	assert.Equal(t, "", UriScheme("biloxi.com:5060"))
+	m, err := ParseMessage("INVITE mailto:bob@biloxi.com SIP/2.0\r\nCall-ID: a84b4c76e66710\r\nCSeq: 1 INVITE\r\n\r\n")
+	assert.Equal(t, UnsupportedUriSchemeError{Scheme: "mailto"}, err)
+	if assert.NotNil(t, m) {
+		assert.Equal(t, 416, NewResponse(m, 416).StatusCode())
+	}
+	_, err = ParseMessage("OPTIONS tel:+15551234567 SIP/2.0\r\nCall-ID: a84b4c76e66710\r\nCSeq: 1 OPTIONS\r\n\r\n")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,142 @@
+package slurp
+
+/*
+Metrics instrument the stack for operators. slurp reports through the
+MetricsSink interface, which can be bound to prometheus, expvar or any
+other system.
+*/
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric names reported by slurp
+const (
+	// Counter of messages parsed, labeled by method
+	MetricMessagesParsed = "slurp_messages_parsed_total"
+	// Counter of messages that failed to parse, labeled by method
+	MetricParseErrors = "slurp_parse_errors_total"
+	// Counter of retransmitted messages, labeled by method
+	MetricRetransmissions = "slurp_retransmissions_total"
+	// Histogram of transaction durations in seconds, labeled by method
+	MetricTransactionDuration = "slurp_transaction_duration_seconds"
+	// Gauge of dialogs that haven't terminated
+	MetricActiveDialogs = "slurp_active_dialogs"
+)
+
+// MetricsSink receives metrics from slurp. Implementations must be safe
+// for concurrent use
+type MetricsSink interface {
+	IncCounter(name string, labels map[string]string)
+	ObserveHistogram(name string, value float64, labels map[string]string)
+	AddGauge(name string, delta float64, labels map[string]string)
+}
+
+// metricsSink holds the MetricsSink, boxed as atomic.Value needs every
+// value stored to be of the same type
+type metricsSink struct {
+	MetricsSink
+}
+
+var metricsValue atomic.Value
+
+// SetMetrics sets where slurp reports its metrics, which it discards by
+// default or when sink is nil. It may be called while messages are
+// being handled
+func SetMetrics(sink MetricsSink) {
+	if sink == nil {
+		sink = nopMetrics{}
+	}
+	metricsValue.Store(metricsSink{sink})
+}
+
+// Metrics returns where slurp reports its metrics
+func Metrics() MetricsSink {
+	if sink, ok := metricsValue.Load().(metricsSink); ok {
+		return sink.MetricsSink
+	}
+	return nopMetrics{}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncCounter(string, map[string]string)                {}
+func (nopMetrics) ObserveHistogram(string, float64, map[string]string) {}
+func (nopMetrics) AddGauge(string, float64, map[string]string)         {}
+
+// countParse reports the outcome of parsing a message
+func countParse(method string, err error) {
+	labels := map[string]string{"method": method}
+	if err != nil {
+		Metrics().IncCounter(MetricParseErrors, labels)
+	} else {
+		Metrics().IncCounter(MetricMessagesParsed, labels)
+	}
+}
+
+// ObserveTransaction reports the duration of a completed transaction
+func ObserveTransaction(method string, duration time.Duration) {
+	Metrics().ObserveHistogram(MetricTransactionDuration, duration.Seconds(), map[string]string{"method": method})
+}
+
+// CountRetransmission reports a retransmitted message
+func CountRetransmission(method string) {
+	Metrics().IncCounter(MetricRetransmissions, map[string]string{"method": method})
+}
+
+// ExpvarMetrics publishes metrics through expvar, as one map per metric
+// keyed by its labels. Histograms are published as a count and a sum
+type ExpvarMetrics struct {
+	mu   sync.Mutex
+	maps map[string]*expvar.Map
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics. Only one may be created per
+// process, as expvar names are global
+func NewExpvarMetrics() *ExpvarMetrics {
+	return &ExpvarMetrics{maps: make(map[string]*expvar.Map)}
+}
+
+func (e *ExpvarMetrics) get(name string) *expvar.Map {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m, ok := e.maps[name]
+	if !ok {
+		m = expvar.NewMap(name)
+		e.maps[name] = m
+	}
+	return m
+}
+
+// labelKey renders labels as a stable key, e.g. method=INVITE
+func labelKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	if len(pairs) == 0 {
+		return "total"
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (e *ExpvarMetrics) IncCounter(name string, labels map[string]string) {
+	e.get(name).Add(labelKey(labels), 1)
+}
+
+func (e *ExpvarMetrics) ObserveHistogram(name string, value float64, labels map[string]string) {
+	key := labelKey(labels)
+	m := e.get(name)
+	m.Add(key+",count", 1)
+	m.AddFloat(key+",sum", value)
+}
+
+func (e *ExpvarMetrics) AddGauge(name string, delta float64, labels map[string]string) {
+	e.get(name).AddFloat(labelKey(labels), delta)
+}
@@ -0,0 +1,46 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+	gauges   map[string]float64
+}
+
+func (m *recordingMetrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name+"{"+labelKey(labels)+"}"]++
+}
+
+func (m *recordingMetrics) ObserveHistogram(string, float64, map[string]string) {}
+
+func (m *recordingMetrics) AddGauge(name string, delta float64, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[name] += delta
+}
+
+func TestMetrics(t *testing.T) {
+	recorder := &recordingMetrics{counters: map[string]int{}, gauges: map[string]float64{}}
+	SetMetrics(recorder)
+	defer SetMetrics(nil)
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		invite := Invite{}
+		assert.Nil(t, invite.Parse(string(data)))
+		assert.NotNil(t, (&Response{}).Parse("garbage"))
+		dialog := NewDialog(&invite, NewResponse(&invite, 200), false)
+		assert.Equal(t, 1.0, recorder.gauges[MetricActiveDialogs])
+		dialog.Terminate("BYE")
+		assert.Equal(t, 0.0, recorder.gauges[MetricActiveDialogs])
+		assert.Equal(t, 1, recorder.counters[MetricMessagesParsed+"{method=INVITE}"])
+		assert.Equal(t, 1, recorder.counters[MetricParseErrors+"{method=}"])
+	}
+}
@@ -0,0 +1,114 @@
+package slurp
+
+/*
+Header names are case-insensitive, and the most common have a compact
+form, e.g. i for Call-ID. Headers are looked up by their canonical name,
+the lower case long form, and rendered with the spelling of the RFC that
+defines them. Peers that match names case-sensitively may be given the
+spelling they expect with SetHeaderCasing.
+*/
+
+import (
+	"strings"
+	"sync"
+)
+
+// compactForms maps the compact form of header names to their long form,
+// every one registered with IANA
+var compactForms = map[string]string{
+	"a": "accept-contact",
+	"b": "referred-by",
+	"c": "content-type",
+	"d": "request-disposition",
+	"e": "content-encoding",
+	"f": "from",
+	"i": "call-id",
+	"j": "reject-contact",
+	"k": "supported",
+	"l": "content-length",
+	"m": "contact",
+	"n": "identity-info",
+	"o": "event",
+	"r": "refer-to",
+	"s": "subject",
+	"t": "to",
+	"u": "allow-events",
+	"v": "via",
+	"x": "session-expires",
+	"y": "identity",
+}
+
+// headerSpellings are the names that aren't spelled by capitalizing each
+// word, by canonical name
+var headerSpellings = map[string]string{
+	"call-id":          "Call-ID",
+	"content-id":       "Content-ID",
+	"cseq":             "CSeq",
+	"mime-version":     "MIME-Version",
+	"min-se":           "Min-SE",
+	"rack":             "RAck",
+	"rseq":             "RSeq",
+	"sip-etag":         "SIP-ETag",
+	"sip-if-match":     "SIP-If-Match",
+	"www-authenticate": "WWW-Authenticate",
+}
+
+var (
+	casingMu sync.RWMutex
+	casings  = map[string]string{}
+)
+
+// canonicalName returns the lower case, long form of a header name
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if long, ok := compactForms[name]; ok {
+		return long
+	}
+	return name
+}
+
+// HeaderName returns how the header is spelled in rendered messages: in
+// its long form, as its RFC spells it, e.g. Call-ID for i or call-id,
+// unless SetHeaderCasing changed it. Unknown names have each word
+// capitalized
+func HeaderName(name string) string {
+	canonical := canonicalName(name)
+	casingMu.RLock()
+	casing, ok := casings[canonical]
+	casingMu.RUnlock()
+	if ok {
+		return casing
+	}
+	if spelling, ok := headerSpellings[canonical]; ok {
+		return spelling
+	}
+	words := strings.Split(canonical, "-")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// SetHeaderCasing forces the spelling of a header name in every message
+// rendered afterwards, e.g. Call-Id for a peer that matches names
+// case-sensitively. An empty casing restores the default spelling
+func SetHeaderCasing(name, casing string) {
+	casingMu.Lock()
+	defer casingMu.Unlock()
+	if casing == "" {
+		delete(casings, canonicalName(name))
+		return
+	}
+	casings[canonicalName(name)] = casing
+}
+
+// spellHeaders respells the name of each header line with HeaderName
+func spellHeaders(lines []string) {
+	for i, line := range lines {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			lines[i] = HeaderName(name) + ":" + value
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package slurp
+
+/*
+A Notifier is the notifier's side of event subscriptions (RFC 6665) for
+one event package. It accepts SUBSCRIBEs for resources, sends the state of
+a resource in a NOTIFY when a subscription is created or refreshed and
+whenever the state changes, and ends subscriptions that weren't refreshed
+in time.
+*/
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventBody is the state of a resource carried by a NOTIFY
+type EventBody struct {
+	ContentType string
+	Body        []byte
+}
+
+// StatePolicy is what state the NOTIFYs for a change carry
+type StatePolicy int
+
+const (
+	// FullState sends the whole state of the resource in every NOTIFY
+	FullState StatePolicy = iota
+	// PartialState sends the whole state when a subscription is created
+	// or refreshed, and only what changed otherwise, as packages such as
+	// dialog and reg allow
+	PartialState
+)
+
+// Notifier accepts subscriptions to an event package and notifies the
+// subscribers. It is safe for concurrent use
+type Notifier struct {
+	Package string
+	Policy  StatePolicy
+	// State returns the whole state of a resource, the Request-URI of the
+	// SUBSCRIBEs for it. NOTIFYs carry no body when it is nil
+	State func(resource string) EventBody
+	// Authorize decides whether a new subscription is accepted, and the
+	// SUBSCRIBE answered with 403 otherwise. All are accepted when nil
+	Authorize func(subscribe Message) bool
+	// DefaultExpires is the duration of subscriptions whose SUBSCRIBE has
+	// no Expires, an hour when zero. Longer requests are shortened to
+	// MaxExpires, when set, and shorter ones than MinExpires are answered
+	// with 423
+	DefaultExpires time.Duration
+	MinExpires     time.Duration
+	MaxExpires     time.Duration
+	// SweepInterval is how often expired subscriptions are ended, a
+	// minute when zero
+	SweepInterval time.Duration
+	// Clock used for expiry, DefaultClock when nil
+	Clock         Clock
+	ua            *UserAgent
+	mu            sync.Mutex
+	subscriptions map[string]*notifierSubscription
+	sweep         Timer
+}
+
+// notifierSubscription is a subscription a Notifier accepted
+type notifierSubscription struct {
+	resource string
+	event    Event
+	dialog   *Dialog
+	addr     string
+	expires  time.Time
+	// sending serializes the subscription's NOTIFYs, so that they are
+	// sent in order, and guards full
+	sending sync.Mutex
+	// full is set once the whole state was sent
+	full bool
+}
+
+// NewNotifier creates a Notifier for the event package, sending NOTIFYs
+// through ua with the state returned by state
+func NewNotifier(ua *UserAgent, pkg string, state func(resource string) EventBody) *Notifier {
+	return &Notifier{
+		Package:       pkg,
+		State:         state,
+		ua:            ua,
+		subscriptions: make(map[string]*notifierSubscription),
+	}
+}
+
+func notifierKey(callId, localTag, remoteTag string, event Event) string {
+	return callId + ";" + localTag + ";" + remoteTag + ";" + event.String()
+}
+
+// expires returns the duration the SUBSCRIBE asked for
+func (n *Notifier) expires(subscribe Message) time.Duration {
+	expires, ok := expiresHeader(subscribe)
+	if !ok {
+		expires = n.DefaultExpires
+		if expires == 0 {
+			expires = time.Hour
+		}
+	}
+	if n.MaxExpires > 0 && expires > n.MaxExpires {
+		expires = n.MaxExpires
+	}
+	return expires
+}
+
+// Handle processes a SUBSCRIBE for the Notifier's package, returning the
+// response to send, or nil for any other request. A new subscription is
+// answered with 200 and notified right away, a refresh extends it, and
+// one with Expires: 0 ends it with a final NOTIFY. A SUBSCRIBE in a
+// dialog the Notifier doesn't know is answered with 481
+func (n *Notifier) Handle(in Incoming) *Response {
+	request := in.Message
+	if request.Method() != "SUBSCRIBE" {
+		return nil
+	}
+	event, ok := MessageEvent(request)
+	if !ok || !strings.EqualFold(event.Package, n.Package) {
+		return nil
+	}
+	expires := n.expires(request)
+	if expires > 0 && expires < n.MinExpires {
+		response := NewResponse(request, 423)
+		response.Headers().Extensions.Set("Min-Expires", strconv.Itoa(int(n.MinExpires/time.Second)))
+		return response
+	}
+	now := clockOrDefault(n.Clock).Now()
+	localTag := request.Headers().To.Param("tag")
+	remoteTag := request.Headers().From.Param("tag")
+	var response *Response
+	var s *notifierSubscription
+	created := false
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if localTag != "" {
+		key := notifierKey(request.Control().CallId, localTag, remoteTag, event)
+		if s = n.subscriptions[key]; s == nil {
+			return NewResponse(request, 481)
+		}
+		response = NewResponse(request, 200)
+		s.dialog.mu.Lock()
+		s.dialog.RemoteSeq = request.Control().Sequence
+		s.dialog.mu.Unlock()
+		if expires == 0 {
+			delete(n.subscriptions, key)
+		}
+	} else {
+		if n.Authorize != nil && !n.Authorize(request) {
+			return NewResponse(request, 403)
+		}
+		response = NewResponse(request, 200)
+		if profile := n.ua.Profile(); profile != nil {
+			response.Headers().Contacts = []AddressHeader{profile.Contact()}
+		}
+		dialog := newDialog(request, response, false, n.Clock, n.ua.RecordSink)
+		s = &notifierSubscription{resource: request.Uri(), event: event, dialog: dialog, addr: in.Source.String()}
+		// the first NOTIFY goes before any for a change
+		s.sending.Lock()
+		created = true
+		if expires > 0 {
+			// a SUBSCRIBE with Expires: 0 only fetches the state
+			n.subscriptions[notifierKey(dialog.CallId, dialog.LocalTag, dialog.RemoteTag, event)] = s
+			n.arm()
+		}
+	}
+	response.Headers().Extensions.Set("Expires", strconv.Itoa(int(expires/time.Second)))
+	s.expires = now.Add(expires)
+	status := SubscriptionStatus{State: SubscriptionActive, Expires: expires}
+	if expires == 0 {
+		status = SubscriptionStatus{State: SubscriptionTerminated, Reason: "timeout"}
+	}
+	go func() {
+		if !created {
+			s.sending.Lock()
+		}
+		defer s.sending.Unlock()
+		n.notify(s, status, n.fullState(s.resource))
+		s.full = true
+	}()
+	return response
+}
+
+// Filter answers the SUBSCRIBEs Handle processes, for UserAgent.AddFilter
+func (n *Notifier) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if response := n.Handle(in); response != nil {
+			return response, false
+		}
+		return nil, true
+	}
+}
+
+// Subscribers returns the number of subscriptions to the resource
+func (n *Notifier) Subscribers(resource string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	count := 0
+	for _, s := range n.subscriptions {
+		if s.resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+// fullState returns the whole state of the resource
+func (n *Notifier) fullState(resource string) *EventBody {
+	if n.State == nil {
+		return nil
+	}
+	state := n.State(resource)
+	return &state
+}
+
+// Changed notifies the subscribers to the resource that its state changed.
+// With the PartialState policy, subscribers that were sent the whole state
+// are sent partial, when it isn't nil, and the whole state otherwise. It
+// returns once every subscriber was notified
+func (n *Notifier) Changed(resource string, partial *EventBody) {
+	var full *EventBody
+	var once sync.Once
+	var wg sync.WaitGroup
+	for _, s := range n.subscribed(resource, false) {
+		wg.Add(1)
+		go func(s *notifierSubscription) {
+			defer wg.Done()
+			s.sending.Lock()
+			defer s.sending.Unlock()
+			body := partial
+			if n.Policy == FullState || partial == nil || !s.full {
+				once.Do(func() { full = n.fullState(resource) })
+				body = full
+			}
+			n.mu.Lock()
+			remaining := s.expires.Sub(clockOrDefault(n.Clock).Now())
+			n.mu.Unlock()
+			if remaining > 0 {
+				n.notify(s, SubscriptionStatus{State: SubscriptionActive, Expires: remaining}, body)
+				s.full = true
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Terminate ends every subscription to the resource with a final NOTIFY
+// giving reason, e.g. noresource when the resource is gone or deactivated
+// to have subscribers subscribe again
+func (n *Notifier) Terminate(resource, reason string) {
+	n.end(n.subscribed(resource, true), reason)
+}
+
+// subscribed returns the subscriptions to the resource, removing them
+// when remove is true
+func (n *Notifier) subscribed(resource string, remove bool) []*notifierSubscription {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var subscriptions []*notifierSubscription
+	for key, s := range n.subscriptions {
+		if s.resource == resource {
+			subscriptions = append(subscriptions, s)
+			if remove {
+				delete(n.subscriptions, key)
+			}
+		}
+	}
+	return subscriptions
+}
+
+// end sends the final NOTIFYs of subscriptions that were removed
+func (n *Notifier) end(subscriptions []*notifierSubscription, reason string) {
+	var wg sync.WaitGroup
+	for _, s := range subscriptions {
+		wg.Add(1)
+		go func(s *notifierSubscription) {
+			defer wg.Done()
+			s.sending.Lock()
+			defer s.sending.Unlock()
+			n.notify(s, SubscriptionStatus{State: SubscriptionTerminated, Reason: reason}, n.fullState(s.resource))
+		}(s)
+	}
+	wg.Wait()
+}
+
+// arm schedules the next sweep for expired subscriptions, unless one is
+// pending, the lock must be held
+func (n *Notifier) arm() {
+	if n.sweep != nil || len(n.subscriptions) == 0 {
+		return
+	}
+	interval := n.SweepInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	n.sweep = clockOrDefault(n.Clock).AfterFunc(interval, n.sweepExpired)
+}
+
+// sweepExpired ends the subscriptions that weren't refreshed in time
+func (n *Notifier) sweepExpired() {
+	now := clockOrDefault(n.Clock).Now()
+	n.mu.Lock()
+	var expired []*notifierSubscription
+	for key, s := range n.subscriptions {
+		if !now.Before(s.expires) {
+			expired = append(expired, s)
+			delete(n.subscriptions, key)
+		}
+	}
+	n.sweep = nil
+	n.arm()
+	n.mu.Unlock()
+	n.end(expired, "timeout")
+}
+
+// notify sends a NOTIFY for the subscription, which must be sending. A
+// final one ends the subscription, as does one that gets no answer or a
+// 481 or 408, meaning the subscriber is gone (RFC 6665 4.2.2)
+func (n *Notifier) notify(s *notifierSubscription, status SubscriptionStatus, body *EventBody) {
+	request := s.dialog.NewRequest("NOTIFY")
+	SetEvent(request, s.event)
+	request.Headers().Extensions.Set("Subscription-State", status.String())
+	if profile := n.ua.Profile(); profile != nil {
+		request.Headers().Contacts = []AddressHeader{profile.Contact()}
+	}
+	if body != nil {
+		request.Headers().ContentType = body.ContentType
+		request.SetPayload(body.Body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.ua.Timers().F)
+	defer cancel()
+	response, err := n.ua.Request(ctx, s.addr, request)
+	if status.State != SubscriptionTerminated && err == nil && response.StatusCode() != 481 && response.StatusCode() != 408 {
+		return
+	}
+	n.mu.Lock()
+	for key, each := range n.subscriptions {
+		if each == s {
+			delete(n.subscriptions, key)
+		}
+	}
+	n.mu.Unlock()
+	s.dialog.Terminate("subscription terminated")
+}
@@ -0,0 +1,73 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	subscriber, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for in := range subscriber.Receive() {
+			if s := subscriber.FindSubscription(in.Message); s != nil {
+				subscriber.Send(context.Background(), in.Source.String(), s.Handle(in.Message))
+			}
+		}
+	}()
+
+	notifier := NewNotifier(server, "dialog", func(resource string) EventBody {
+		return EventBody{ContentType: "application/dialog-info+xml", Body: []byte("full " + resource)}
+	})
+	notifier.Policy = PartialState
+	notifier.MinExpires = 30 * time.Second
+	notifier.MaxExpires = time.Minute
+	clock := NewFakeClock(time.Unix(0, 0))
+	notifier.Clock = clock
+	server.AddFilter(notifier.Filter())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	newSubscribe := func() Message {
+		request, err := NewRequestBuilder("SUBSCRIBE").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").
+			Header("Event", "dialog").Build()
+		assert.Nil(t, err)
+		return request
+	}
+	_, err = subscriber.Subscribe(ctx, b.LocalAddr().String(), newSubscribe(), 10*time.Second)
+	assert.Equal(t, 423, err.(RejectedError).StatusCode)
+
+	// the subscription is shortened to a minute and starts with the whole state
+	s, err := subscriber.Subscribe(ctx, b.LocalAddr().String(), newSubscribe(), time.Hour)
+	assert.Nil(t, err)
+	notification := <-s.Notifications
+	assert.Equal(t, SubscriptionStatus{State: SubscriptionActive, Expires: time.Minute}, notification.Status)
+	assert.Equal(t, "full sip:bob@biloxi.com", notification.Request.StringPayload())
+	assert.Equal(t, 1, notifier.Subscribers("sip:bob@biloxi.com"))
+
+	// changes carry only what changed
+	notifier.Changed("sip:bob@biloxi.com", &EventBody{ContentType: "application/dialog-info+xml", Body: []byte("partial")})
+	notification = <-s.Notifications
+	assert.Equal(t, "partial", notification.Request.StringPayload())
+	assert.Equal(t, "application/dialog-info+xml", notification.Request.Headers().ContentType)
+
+	// unrefreshed subscriptions are ended by the sweep
+	clock.Advance(time.Minute)
+	notification = <-s.Notifications
+	assert.Equal(t, SubscriptionTerminated, notification.Status.State)
+	assert.Equal(t, "timeout", notification.Status.Reason)
+	assert.Equal(t, 0, notifier.Subscribers("sip:bob@biloxi.com"))
+	_, ok := <-s.Notifications
+	assert.False(t, ok)
+}
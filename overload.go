@@ -0,0 +1,233 @@
+package slurp
+
+/*
+SIP overload control (RFC 7339) lets an overloaded server ask its clients
+to send it less, rather than answer 503 to requests it already spent work
+on. A client supporting it adds an oc parameter to its Via; the server
+answers with oc=<percent> in the same Via, asking the client to cut the
+requests it sends by that much for oc-validity milliseconds. A Throttler
+keeps track of those reductions per server and drops the requests over
+them before they are sent.
+*/
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LossAlgorithm is the oc-algo of the loss-based algorithm, the one every
+// implementation supports and the only one a Throttler applies
+const LossAlgorithm = "loss"
+
+// DefaultOverloadValidity is how long a reduction holds when the server
+// sends no oc-validity
+const DefaultOverloadValidity = 500 * time.Millisecond
+
+// MetricThrottled is the counter of requests a Throttler dropped, labeled
+// by method
+const MetricThrottled = "slurp_throttled_total"
+
+// OverloadControl are the oc parameters of a Via
+type OverloadControl struct {
+	// Reduction is the percentage of requests to drop, 0 when the server
+	// isn't overloaded any more
+	Reduction int
+	// Algorithm is the oc-algo: what the client supports, a list
+	// separated by commas, in its requests, and the one chosen by the
+	// server in its responses
+	Algorithm string
+	// Validity is how long the reduction holds, 0 ending it
+	Validity time.Duration
+	// Sequence orders the server's reductions, so that stale ones are
+	// ignored; it is a timestamp with a fractional counter
+	Sequence float64
+}
+
+// ParseOverloadControl reads the oc parameters of the raw parameters of a
+// Via, ok being false when it has none
+func ParseOverloadControl(params string) (oc OverloadControl, ok bool) {
+	value, ok := viaParam(params, "oc")
+	if !ok {
+		return oc, false
+	}
+	oc.Reduction, _ = strconv.Atoi(value)
+	if algorithm, ok := viaParam(params, "oc-algo"); ok {
+		oc.Algorithm = strings.Trim(algorithm, `"`)
+	}
+	oc.Validity = DefaultOverloadValidity
+	if validity, ok := viaParam(params, "oc-validity"); ok {
+		if ms, err := strconv.Atoi(validity); err == nil {
+			oc.Validity = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if seq, ok := viaParam(params, "oc-seq"); ok {
+		oc.Sequence, _ = strconv.ParseFloat(seq, 64)
+	}
+	return oc, true
+}
+
+// String renders the oc parameters, without a leading semicolon
+func (oc OverloadControl) String() string {
+	params := []string{"oc=" + strconv.Itoa(oc.Reduction)}
+	if oc.Algorithm != "" {
+		params = append(params, `oc-algo="`+oc.Algorithm+`"`)
+	}
+	params = append(params, "oc-validity="+strconv.FormatInt(oc.Validity.Milliseconds(), 10))
+	if oc.Sequence != 0 {
+		params = append(params, "oc-seq="+strconv.FormatFloat(oc.Sequence, 'f', 3, 64))
+	}
+	return strings.Join(params, ";")
+}
+
+// withoutOverloadControl removes the oc parameters from raw Via parameters
+func withoutOverloadControl(params string) []string {
+	var kept []string
+	for _, param := range strings.Split(params, ";") {
+		param = strings.TrimSpace(param)
+		key, _, _ := strings.Cut(param, "=")
+		key = strings.ToLower(key)
+		if param != "" && key != "oc" && !strings.HasPrefix(key, "oc-") {
+			kept = append(kept, param)
+		}
+	}
+	return kept
+}
+
+// SignalOverload sets oc in the top Via of a response, as an overloaded
+// server does, reporting whether it did: only clients whose Via showed
+// they support overload control are sent it
+func SignalOverload(response *Response, oc OverloadControl) bool {
+	control := response.Control()
+	if len(control.ViaParams) == 0 {
+		return false
+	}
+	if _, ok := viaParam(control.ViaParams[0], "oc"); !ok {
+		return false
+	}
+	if oc.Algorithm == "" {
+		oc.Algorithm = LossAlgorithm
+	}
+	control.ViaParams[0] = strings.Join(append(withoutOverloadControl(control.ViaParams[0]), oc.String()), ";")
+	return true
+}
+
+// throttle is the reduction a server asked for
+type throttle struct {
+	reduction int
+	until     time.Time
+	sequence  float64
+	// credit accumulates the share of requests admitted, one being
+	// admitted each time it reaches 100, so that exactly the reduction is
+	// dropped rather than a random share close to it
+	credit int
+}
+
+// Throttler reduces the requests sent to overloaded servers (host:port)
+// as their oc parameters ask, with the loss-based algorithm. Only new
+// requests are dropped: requests within a dialog, and ACKs and CANCELs,
+// finish work the server already took on. It is safe for concurrent use
+type Throttler struct {
+	// Exempt, when set, spares the requests it reports, e.g. emergency
+	// calls or a PriorityPolicy's Prioritized ones (RFC 7339 5.10.1)
+	Exempt func(Message) bool
+	// Clock used for validity, DefaultClock when nil
+	Clock   Clock
+	mu      sync.Mutex
+	servers map[string]*throttle
+}
+
+// NewThrottler creates a Throttler with no server overloaded
+func NewThrottler() *Throttler {
+	return &Throttler{servers: make(map[string]*throttle)}
+}
+
+// Observe applies the oc parameters of a response from server
+func (t *Throttler) Observe(server string, response *Response) {
+	control := response.Control()
+	if len(control.ViaParams) == 0 {
+		return
+	}
+	oc, ok := ParseOverloadControl(control.ViaParams[0])
+	if !ok || (oc.Algorithm != "" && !strings.EqualFold(oc.Algorithm, LossAlgorithm)) {
+		return
+	}
+	key := strings.ToLower(server)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.servers == nil {
+		t.servers = make(map[string]*throttle)
+	}
+	current, ok := t.servers[key]
+	if ok && oc.Sequence != 0 && oc.Sequence < current.sequence {
+		return
+	}
+	if oc.Reduction <= 0 || oc.Validity <= 0 {
+		delete(t.servers, key)
+		return
+	}
+	if oc.Reduction > 100 {
+		oc.Reduction = 100
+	}
+	if !ok {
+		current = &throttle{}
+		t.servers[key] = current
+	}
+	current.reduction, current.sequence = oc.Reduction, oc.Sequence
+	current.until = clockOrDefault(t.Clock).Now().Add(oc.Validity)
+}
+
+// Reduction returns the percentage of requests to server being dropped
+func (t *Throttler) Reduction(server string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current := t.current(strings.ToLower(server)); current != nil {
+		return current.reduction
+	}
+	return 0
+}
+
+// current returns the reduction in force for a server, removing it once
+// expired. t.mu must be held
+func (t *Throttler) current(key string) *throttle {
+	current, ok := t.servers[key]
+	if !ok {
+		return nil
+	}
+	if !clockOrDefault(t.Clock).Now().Before(current.until) {
+		delete(t.servers, key)
+		return nil
+	}
+	return current
+}
+
+// Admit reports whether request may be sent to server, counting it as
+// throttled if not
+func (t *Throttler) Admit(server string, request Message) bool {
+	switch request.Method() {
+	case "ACK", "CANCEL":
+		return true
+	}
+	if request.Headers().To != nil && request.Headers().To.Param("tag") != "" {
+		return true
+	}
+	if t.Exempt != nil && t.Exempt(request) {
+		return true
+	}
+	t.mu.Lock()
+	current := t.current(strings.ToLower(server))
+	admit := true
+	if current != nil {
+		current.credit += 100 - current.reduction
+		admit = current.credit >= 100
+		if admit {
+			current.credit -= 100
+		}
+	}
+	t.mu.Unlock()
+	if !admit {
+		Metrics().IncCounter(MetricThrottled, map[string]string{"method": request.Method()})
+	}
+	return admit
+}
@@ -0,0 +1,105 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverloadControl(t *testing.T) {
+	oc, ok := ParseOverloadControl(`received=192.0.2.1;oc=20;oc-algo="loss";oc-validity=2000;oc-seq=1282321615.782`)
+	assert.True(t, ok)
+	assert.Equal(t, OverloadControl{Reduction: 20, Algorithm: LossAlgorithm, Validity: 2 * time.Second, Sequence: 1282321615.782}, oc)
+	assert.Equal(t, `oc=20;oc-algo="loss";oc-validity=2000;oc-seq=1282321615.782`, oc.String())
+	oc, ok = ParseOverloadControl("oc")
+	assert.True(t, ok)
+	assert.Equal(t, DefaultOverloadValidity, oc.Validity)
+	_, ok = ParseOverloadControl("rport=5060")
+	assert.False(t, ok)
+
+	// only clients that showed support are signalled
+	response := &Response{}
+	response.Control().ViaParams = []string{"rport"}
+	assert.False(t, SignalOverload(response, OverloadControl{Reduction: 50, Validity: time.Second}))
+	response.Control().ViaParams = []string{"rport;oc"}
+	assert.True(t, SignalOverload(response, OverloadControl{Reduction: 50, Validity: time.Second}))
+	assert.Equal(t, `rport;oc=50;oc-algo="loss";oc-validity=1000`, response.Control().ViaParams[0])
+}
+
+func TestThrottler(t *testing.T) {
+	recorder := &recordingMetrics{counters: map[string]int{}, gauges: map[string]float64{}}
+	SetMetrics(recorder)
+	defer SetMetrics(nil)
+	clock := NewFakeClock(time.Unix(0, 0))
+	throttler := NewThrottler()
+	throttler.Clock = clock
+	overloaded := func(params string) *Response {
+		response := &Response{}
+		response.Control().ViaParams = []string{params}
+		return response
+	}
+	invite, _ := NewInvite().To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+
+	throttler.Observe("192.0.2.1:5060", overloaded("oc=25;oc-validity=1000;oc-seq=2.000"))
+	assert.Equal(t, 25, throttler.Reduction("192.0.2.1:5060"))
+	admitted := 0
+	for i := 0; i < 100; i++ {
+		if throttler.Admit("192.0.2.1:5060", invite) {
+			admitted++
+		}
+	}
+	assert.Equal(t, 75, admitted)
+	assert.Equal(t, 25, recorder.counters[MetricThrottled+"{method=INVITE}"])
+	assert.True(t, throttler.Admit("192.0.2.2:5060", invite))
+
+	// stale reductions are ignored, and one of 0 ends the overload
+	throttler.Observe("192.0.2.1:5060", overloaded("oc=90;oc-validity=1000;oc-seq=1.000"))
+	assert.Equal(t, 25, throttler.Reduction("192.0.2.1:5060"))
+	throttler.Observe("192.0.2.1:5060", overloaded("oc=0;oc-seq=3.000"))
+	assert.Equal(t, 0, throttler.Reduction("192.0.2.1:5060"))
+
+	// a reduction expires after its validity, and requests within a dialog
+	// are never dropped
+	throttler.Observe("192.0.2.1:5060", overloaded("oc=100;oc-validity=1000"))
+	assert.False(t, throttler.Admit("192.0.2.1:5060", invite))
+	_, dialog := exampleDialog(t, true)
+	assert.True(t, throttler.Admit("192.0.2.1:5060", dialog.NewRequest("BYE")))
+	clock.Advance(time.Second)
+	assert.True(t, throttler.Admit("192.0.2.1:5060", invite))
+}
+
+func TestThrottledRequest(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	client.SetThrottler(NewThrottler())
+	go func() {
+		for in := range server.Receive() {
+			response := NewResponse(in.Message, 200)
+			SignalOverload(response, OverloadControl{Reduction: 100, Validity: time.Minute})
+			server.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr := b.LocalAddr().String()
+	request := func() Message {
+		options, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+		return options
+	}
+	response, err := client.Request(ctx, addr, request())
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	_, err = client.Request(ctx, addr, request())
+	assert.Equal(t, ThrottledError{Addr: addr, Reduction: 100}, err)
+}
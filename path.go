@@ -0,0 +1,66 @@
+package slurp
+
+/*
+Path (RFC 3327) records the proxies a REGISTER went through, so that
+requests for the registered contact are routed back through them, e.g.
+through the edge proxy holding the UA's connection.
+*/
+
+import "strings"
+
+// PathSet returns the Path header values of a REGISTER, or of the
+// registrar's response to it, topmost first
+func PathSet(m Message) []string {
+	return m.Headers().Extensions.GetAll("Path")
+}
+
+// hasOptionTag reports whether the named header, e.g. Supported or
+// Require, lists the option tag
+func hasOptionTag(m Message, header, tag string) bool {
+	for _, value := range m.Headers().Extensions.GetAll(header) {
+		if strings.EqualFold(strings.TrimSpace(value), tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeaderValues replaces every header with the name by one per value
+func setHeaderValues(m Message, name string, values []string) {
+	extensions := &m.Headers().Extensions
+	extensions.Remove(name)
+	for _, value := range values {
+		extensions.Add(name, value)
+	}
+}
+
+// InsertPath adds uri as the topmost Path of a REGISTER an edge proxy
+// forwards. It reports false, leaving the request unchanged, when the UA
+// didn't declare support for path, as RFC 3327 5.1 requires
+func InsertPath(register Message, uri string) bool {
+	if !hasOptionTag(register, "Supported", "path") {
+		return false
+	}
+	setHeaderValues(register, "Path", append([]string{"<" + addrSpec(uri) + ">"}, PathSet(register)...))
+	return true
+}
+
+// EchoPath copies the Path of a REGISTER to the registrar's response, as
+// RFC 3327 5.3 requires when the UA supports path
+func EchoPath(register Message, response *Response) {
+	if paths := PathSet(register); len(paths) > 0 && hasOptionTag(register, "Supported", "path") {
+		setHeaderValues(response, "Path", paths)
+	}
+}
+
+// TargetBinding retargets a request for an AOR to one of its bindings:
+// the Request-URI becomes the binding's contact, and the binding's Path
+// is pre-loaded as its route
+func TargetBinding(request Message, binding Binding) {
+	if setter, ok := request.(interface{ SetUri(string) }); ok {
+		setter.SetUri(addrSpec(binding.Contact))
+	}
+	if len(binding.Path) > 0 {
+		setHeaderValues(request, "Route", binding.Path)
+	}
+}
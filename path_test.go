@@ -0,0 +1,33 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	register, err := NewRegister().To("sip:bob@biloxi.com").FromUri("sip:bob@biloxi.com").Build()
+	assert.Nil(t, err)
+	assert.False(t, InsertPath(register, "sip:edge.biloxi.com;lr"))
+	assert.Empty(t, PathSet(register))
+
+	register.Headers().Extensions.Add("Supported", "path")
+	assert.True(t, InsertPath(register, "sip:edge.biloxi.com;lr"))
+	assert.True(t, InsertPath(register, "<sip:p2.biloxi.com;lr>"))
+	assert.Equal(t, []string{"<sip:p2.biloxi.com;lr>", "<sip:edge.biloxi.com;lr>"}, PathSet(register))
+	assert.Contains(t, register.Render(), "Path: <sip:p2.biloxi.com;lr>\r\nPath: <sip:edge.biloxi.com;lr>\r\n")
+
+	response := NewResponse(register, 200)
+	EchoPath(register, response)
+	assert.Equal(t, PathSet(register), PathSet(response))
+
+	// requests for the binding go back through the path
+	binding := Binding{Contact: "<sip:bob@192.0.2.4>", Path: PathSet(register)}
+	invite := newTestInvite("sip:bob@biloxi.com")
+	TargetBinding(invite, binding)
+	assert.Equal(t, "sip:bob@192.0.2.4", invite.Uri())
+	hop, err := NextHop(invite)
+	assert.Nil(t, err)
+	assert.Equal(t, "p2.biloxi.com:5060", hop)
+}
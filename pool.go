@@ -0,0 +1,109 @@
+package slurp
+
+/*
+Pools recycle messages, headers and receive buffers, which keeps garbage
+collection down for proxies handling thousands of calls per second.
+Messages returned by ParseMessage come from the pools. Releasing them is
+optional: a message that is never released is simply garbage collected.
+After Release, neither the message nor any header read from it may be used.
+
+The UserAgent releases the messages it receives and handles itself: those
+it answers or drops as invalid, retransmitted 2xx it acknowledges again,
+and provisional responses a transaction absorbs. Those it hands on, to
+the TransactionUser, a hook or the caller of Request, belong to them.
+*/
+
+import (
+	"net"
+	"sync"
+)
+
+var (
+	invitePool   = sync.Pool{New: func() interface{} { return &Invite{} }}
+	registerPool = sync.Pool{New: func() interface{} { return &Register{} }}
+	requestPool  = sync.Pool{New: func() interface{} { return &Request{} }}
+	responsePool = sync.Pool{New: func() interface{} { return &Response{} }}
+	toFromPool   = sync.Pool{New: func() interface{} { return &ToFrom{} }}
+	contactPool  = sync.Pool{New: func() interface{} { return &Contact{} }}
+	bufferPool   = sync.Pool{New: func() interface{} {
+		buffer := make([]byte, 65535)
+		return &buffer
+	}}
+)
+
+func newToFrom() *ToFrom {
+	return toFromPool.Get().(*ToFrom)
+}
+
+func newContact() *Contact {
+	return contactPool.Get().(*Contact)
+}
+
+// releaseHeaders returns the headers of a released message to their pools
+func releaseHeaders(h *CommonHeaders) {
+	for _, each := range []AddressHeader{h.To, h.From} {
+		if toFrom, ok := each.(*ToFrom); ok {
+			*toFrom = ToFrom{}
+			toFromPool.Put(toFrom)
+		}
+	}
+	for _, each := range h.Contacts {
+		if contact, ok := each.(*Contact); ok {
+			// the parameters keep their storage for the next message
+			params := contact.params
+			params.reset()
+			*contact = Contact{params: params}
+			contactPool.Put(contact)
+		}
+	}
+}
+
+// Release returns the message and its headers to their pools
+func (i *Invite) Release() {
+	releaseHeaders(&i.headers)
+	*i = Invite{}
+	invitePool.Put(i)
+}
+
+// Release returns the message and its headers to their pools
+func (r *Register) Release() {
+	releaseHeaders(&r.headers)
+	*r = Register{}
+	registerPool.Put(r)
+}
+
+// Release returns the message and its headers to their pools
+func (r *Request) Release() {
+	releaseHeaders(&r.headers)
+	*r = Request{}
+	requestPool.Put(r)
+}
+
+// Release returns the message and its headers to their pools
+func (r *Response) Release() {
+	releaseHeaders(&r.headers)
+	*r = Response{}
+	responsePool.Put(r)
+}
+
+// Release returns the message to its pool, if it is one of ours
+func Release(m Message) {
+	if releaser, ok := m.(interface{ Release() }); ok {
+		releaser.Release()
+	}
+}
+
+// newPacket copies data into a pooled buffer
+func newPacket(data []byte, source net.Addr) Packet {
+	buffer := bufferPool.Get().(*[]byte)
+	n := copy(*buffer, data)
+	return Packet{Data: (*buffer)[:n], Source: source, buffer: buffer}
+}
+
+// Release returns the packet's buffer to the pool, if it came from one.
+// Data must not be used afterwards
+func (p Packet) Release() {
+	if p.buffer != nil {
+		bufferPool.Put(p.buffer)
+	}
+}
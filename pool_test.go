@@ -0,0 +1,43 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelease(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	assert.Nil(t, err)
+	first, err := ParseMessage(string(data))
+	assert.Nil(t, err)
+	rendered := first.Render()
+	Release(first)
+	// whether or not the pool hands back the same object, it must be clean
+	second, err := ParseMessage(string(data))
+	assert.Nil(t, err)
+	assert.Equal(t, rendered, second.Render())
+	Release(second)
+
+	// a CANCEL has headers of its own, which outlive the released INVITE
+	invite, err := ParseMessage(string(data))
+	assert.Nil(t, err)
+	cancel := NewCancel(invite)
+	to, from := invite.Headers().To.String(), invite.Headers().From.String()
+	Release(invite)
+	assert.Equal(t, to, cancel.Headers().To.String())
+	assert.Equal(t, from, cancel.Headers().From.String())
+
+	_, err = ParseMessage("SIP/2.0 200 OK\r\nCSeq: x\r\n\r\n")
+	assert.NotNil(t, err)
+}
+
+func TestPacketRelease(t *testing.T) {
+	source := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	packet := newPacket([]byte("OPTIONS"), source)
+	assert.Equal(t, []byte("OPTIONS"), packet.Data)
+	packet.Release()
+	Packet{Data: []byte("unpooled")}.Release()
+}
@@ -0,0 +1,191 @@
+package slurp
+
+/*
+Government and emergency networks give some calls preferential treatment,
+e.g. those of first responders during a disaster. The Resource-Priority
+header (RFC 4412) carries the priority of a request as namespace.priority
+values, e.g. ets.0, and Accept-Resource-Priority lists those a server
+understands. A PriorityPolicy ranks requests by the namespaces it accepts,
+for the queue to serve priority calls first and the throttler to spare
+them.
+*/
+
+import (
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// ResourcePriorityTag is the option tag of a request that must not be
+// handled without its priority being understood
+const ResourcePriorityTag = "resource-priority"
+
+// PriorityNamespaces are the namespaces of RFC 4412 and their priority
+// values, from the lowest to the highest
+var PriorityNamespaces = map[string][]string{
+	"dsn":  {"routine", "priority", "immediate", "flash", "flash-override"},
+	"drsn": {"routine", "priority", "immediate", "flash", "flash-override", "flash-override-override"},
+	"q735": {"4", "3", "2", "1", "0"},
+	"ets":  {"4", "3", "2", "1", "0"},
+	"wps":  {"4", "3", "2", "1", "0"},
+}
+
+// ResourceValue is a namespace.priority value of Resource-Priority
+type ResourceValue struct {
+	Namespace string
+	Priority  string
+}
+
+// ParseResourceValue reads a namespace.priority value. Namespaces and
+// priorities are case-insensitive, and returned in lower case
+func ParseResourceValue(value string) (ResourceValue, error) {
+	namespace, priority, ok := strings.Cut(strings.ToLower(strings.TrimSpace(value)), ".")
+	if !ok || namespace == "" || priority == "" {
+		return ResourceValue{}, Violation{Header: "Resource-Priority", Reason: "expected namespace.priority: " + value}
+	}
+	return ResourceValue{Namespace: namespace, Priority: priority}, nil
+}
+
+func (v ResourceValue) String() string {
+	return v.Namespace + "." + v.Priority
+}
+
+// Rank returns the position of the value in its namespace, 1 for the
+// lowest priority, or 0 when either is unknown
+func (v ResourceValue) Rank() int {
+	for i, priority := range PriorityNamespaces[v.Namespace] {
+		if priority == v.Priority {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func parseResourceValues(values []string) ([]ResourceValue, error) {
+	var parsed []ResourceValue
+	for _, value := range values {
+		v, err := ParseResourceValue(value)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, v)
+	}
+	return parsed, nil
+}
+
+func joinResourceValues(values []ResourceValue) string {
+	rendered := make([]string, len(values))
+	for i, v := range values {
+		rendered[i] = v.String()
+	}
+	return strings.Join(rendered, ", ")
+}
+
+// ResourcePriority returns the values of the message's Resource-Priority
+// headers
+func ResourcePriority(m Message) ([]ResourceValue, error) {
+	return parseResourceValues(m.Headers().Extensions.GetAll("Resource-Priority"))
+}
+
+// SetResourcePriority replaces the message's Resource-Priority header,
+// removing it without values
+func SetResourcePriority(m Message, values ...ResourceValue) {
+	if len(values) == 0 {
+		m.Headers().Extensions.Remove("Resource-Priority")
+		return
+	}
+	m.Headers().Extensions.Set("Resource-Priority", joinResourceValues(values))
+}
+
+// AcceptResourcePriority returns the values of the message's
+// Accept-Resource-Priority headers
+func AcceptResourcePriority(m Message) ([]ResourceValue, error) {
+	return parseResourceValues(m.Headers().Extensions.GetAll("Accept-Resource-Priority"))
+}
+
+// SetAcceptResourcePriority replaces the message's
+// Accept-Resource-Priority header, removing it without values
+func SetAcceptResourcePriority(m Message, values ...ResourceValue) {
+	if len(values) == 0 {
+		m.Headers().Extensions.Remove("Accept-Resource-Priority")
+		return
+	}
+	m.Headers().Extensions.Set("Accept-Resource-Priority", joinResourceValues(values))
+}
+
+// PriorityPolicy decides which Resource-Priority values are honoured
+type PriorityPolicy struct {
+	// Namespaces are those accepted, every one of PriorityNamespaces
+	// when empty
+	Namespaces []string
+}
+
+func (p *PriorityPolicy) accepts(namespace string) bool {
+	if len(p.Namespaces) == 0 {
+		_, ok := PriorityNamespaces[namespace]
+		return ok
+	}
+	for _, accepted := range p.Namespaces {
+		if strings.EqualFold(accepted, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepted returns every value of the namespaces the policy accepts, as
+// Accept-Resource-Priority lists them
+func (p *PriorityPolicy) Accepted() []ResourceValue {
+	namespaces := p.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{"dsn", "drsn", "q735", "ets", "wps"}
+	}
+	var values []ResourceValue
+	for _, namespace := range namespaces {
+		namespace = strings.ToLower(namespace)
+		for _, priority := range PriorityNamespaces[namespace] {
+			values = append(values, ResourceValue{Namespace: namespace, Priority: priority})
+		}
+	}
+	return values
+}
+
+// Rank returns the highest rank of the message's Resource-Priority values
+// in the namespaces accepted, 0 when it has none: the higher the rank,
+// the more preferential the treatment. Ranks of different namespaces
+// compare by position, as deployments seldom mix them
+func (p *PriorityPolicy) Rank(m Message) int {
+	values, _ := ResourcePriority(m)
+	rank := 0
+	for _, v := range values {
+		if p.accepts(v.Namespace) && v.Rank() > rank {
+			rank = v.Rank()
+		}
+	}
+	return rank
+}
+
+// Prioritized reports whether the message has a priority the policy
+// honours, e.g. to exempt it from a Throttler
+func (p *PriorityPolicy) Prioritized(m Message) bool {
+	return p.Rank(m) > 0
+}
+
+// Filter answers 417, with the values accepted, the requests that
+// require resource-priority but have none the policy accepts (RFC 4412
+// 3.4.1). Other requests go on
+func (p *PriorityPolicy) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		request := in.Message
+		required := false
+		for _, tag := range request.Headers().Extensions.GetAll("Require") {
+			required = required || strings.EqualFold(tag, ResourcePriorityTag)
+		}
+		if !required || p.Rank(request) > 0 {
+			return nil, true
+		}
+		response := NewResponse(request, 417)
+		SetAcceptResourcePriority(response, p.Accepted()...)
+		return response, false
+	}
+}
@@ -0,0 +1,75 @@
+package slurp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourcePriority(t *testing.T) {
+	invite, _ := NewInvite().To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").
+		Header("Resource-Priority", "DSN.flash, wps.3").Build()
+	values, err := ResourcePriority(invite)
+	assert.Nil(t, err)
+	assert.Equal(t, []ResourceValue{{Namespace: "dsn", Priority: "flash"}, {Namespace: "wps", Priority: "3"}}, values)
+	assert.Equal(t, 4, values[0].Rank())
+	assert.Equal(t, 0, ResourceValue{Namespace: "dsn", Priority: "urgent"}.Rank())
+	_, err = ParseResourceValue("ets")
+	assert.NotNil(t, err)
+
+	SetResourcePriority(invite, ResourceValue{Namespace: "ets", Priority: "0"})
+	assert.Equal(t, "ets.0", invite.Headers().Extensions.Get("Resource-Priority"))
+	policy := &PriorityPolicy{Namespaces: []string{"ets", "wps"}}
+	assert.Equal(t, 5, policy.Rank(invite))
+	SetResourcePriority(invite)
+	assert.Equal(t, 0, policy.Rank(invite))
+	assert.False(t, policy.Prioritized(invite))
+
+	// requiring a namespace the policy doesn't accept is answered 417
+	invite.Headers().Extensions.Set("Require", ResourcePriorityTag)
+	SetResourcePriority(invite, ResourceValue{Namespace: "dsn", Priority: "flash"})
+	response, next := policy.Filter()(Incoming{Message: invite})
+	assert.False(t, next)
+	assert.Equal(t, 417, response.StatusCode())
+	accepted, err := AcceptResourcePriority(response)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(accepted))
+	assert.Equal(t, ResourceValue{Namespace: "ets", Priority: "4"}, accepted[0])
+	SetResourcePriority(invite, ResourceValue{Namespace: "ets", Priority: "2"})
+	_, next = policy.Filter()(Incoming{Message: invite})
+	assert.True(t, next)
+}
+
+func TestPriorityTreatment(t *testing.T) {
+	policy := &PriorityPolicy{}
+	call := func(values ...ResourceValue) Message {
+		invite, _ := NewInvite().To("sip:queue@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+		SetResourcePriority(invite, values...)
+		return invite
+	}
+	routine, flash := call(), call(ResourceValue{Namespace: "dsn", Priority: "flash"})
+
+	// priority calls wait ahead of others, but behind the call offered
+	queue := &Queue{Priority: policy.Rank}
+	first := queue.join(policy.Rank(routine))
+	second := queue.join(policy.Rank(routine))
+	priority := queue.join(policy.Rank(flash))
+	assert.Equal(t, []chan struct{}{first, priority, second}, []chan struct{}{queue.waiting[0].turn, queue.waiting[1].turn, queue.waiting[2].turn})
+	queue.leave(first)
+	select {
+	case <-priority:
+	default:
+		t.Error("the priority call should be next")
+	}
+
+	// and aren't throttled
+	throttler := NewThrottler()
+	throttler.Clock = NewFakeClock(time.Unix(0, 0))
+	throttler.Exempt = policy.Prioritized
+	response := &Response{}
+	response.Control().ViaParams = []string{"oc=100"}
+	throttler.Observe("192.0.2.1:5060", response)
+	assert.False(t, throttler.Admit("192.0.2.1:5060", routine))
+	assert.True(t, throttler.Admit("192.0.2.1:5060", flash))
+}
@@ -0,0 +1,233 @@
+package slurp
+
+/*
+A B2BUA or proxy providing privacy (RFC 3323, RFC 3325) hides who is
+calling and how the call got to it before the request leaves the trust
+domain: the From becomes anonymous, the asserted identity and headers
+naming the caller's software are removed, and the Vias of the hops
+before it are taken off, so the network behind it stays hidden. The
+responses coming back are matched to what was hidden and get it back, so
+they can be relayed upstream as if nothing had changed.
+*/
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// The values of the Privacy header
+const (
+	PrivacyNone     = "none"
+	PrivacyHeader   = "header"
+	PrivacySession  = "session"
+	PrivacyUser     = "user"
+	PrivacyID       = "id"
+	PrivacyCritical = "critical"
+)
+
+// AnonymousUri is the From of anonymous requests (RFC 3323 4.1.1.3)
+const AnonymousUri = "sip:anonymous@anonymous.invalid"
+
+// DefaultIdentityHeaders are the headers naming the caller that a
+// PrivacyPolicy removes
+var DefaultIdentityHeaders = []string{"P-Asserted-Identity", "P-Preferred-Identity", "Remote-Party-ID"}
+
+// DefaultTopologyHeaders are the headers telling about the caller's
+// software and network that a PrivacyPolicy removes
+var DefaultTopologyHeaders = []string{"User-Agent", "Server", "Organization", "Call-Info", "In-Reply-To", "Warning"}
+
+// Privacy returns the values of the message's Privacy header, lower case
+func Privacy(m Message) []string {
+	var values []string
+	for _, value := range strings.Split(m.Headers().Extensions.Get("Privacy"), ";") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// PrivacyRequested reports whether the message's Privacy header asks for
+// value, e.g. id to hide the asserted identity
+func PrivacyRequested(m Message, value string) bool {
+	for _, requested := range Privacy(m) {
+		if requested == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenRequest is what was taken off a request, to give back to its
+// responses
+type hiddenRequest struct {
+	from      AddressHeader
+	via       [][2]string
+	viaParams []string
+	hidden    time.Time
+}
+
+// PrivacyPolicy anonymizes outgoing requests. Its Interceptor hides what
+// the policy says in the requests sent, and restores it in their
+// responses. It is safe for concurrent use
+type PrivacyPolicy struct {
+	// Always applies the policy to every initial request. Otherwise only
+	// those whose Privacy header asks for it are: header or user for the
+	// From and the headers listed, id for the identity headers
+	Always bool
+	// AnonymizeFrom replaces the From with "Anonymous" AnonymousUri,
+	// keeping its tag
+	AnonymizeFrom bool
+	// IdentityHeaders and Headers are removed, as is the Privacy header
+	// once applied, for the next hop not to apply it again. Nil lists
+	// remove DefaultIdentityHeaders and DefaultTopologyHeaders, empty
+	// ones nothing
+	IdentityHeaders []string
+	Headers         []string
+	// HideVias removes the Vias below ours, of the hops the request came
+	// through
+	HideVias bool
+	// TTL is how long what was hidden is kept for responses,
+	// DefaultTimers.B when zero, as a transaction gets none after that
+	TTL time.Duration
+	// Clock used for the TTL, DefaultClock when nil
+	Clock  Clock
+	mu     sync.Mutex
+	hidden map[string]hiddenRequest
+}
+
+func (p *PrivacyPolicy) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultTimers.B
+}
+
+func (p *PrivacyPolicy) identityHeaders() []string {
+	if p.IdentityHeaders == nil {
+		return DefaultIdentityHeaders
+	}
+	return p.IdentityHeaders
+}
+
+func (p *PrivacyPolicy) headers() []string {
+	if p.Headers == nil {
+		return DefaultTopologyHeaders
+	}
+	return p.Headers
+}
+
+// Anonymize applies the policy to an outgoing request, keeping what it
+// hides to Restore in its responses. It reports whether it changed it
+func (p *PrivacyPolicy) Anonymize(request Message) bool {
+	if to := request.Headers().To; to != nil && to.Param("tag") != "" {
+		// requests within a dialog keep the identities it was set up with
+		return false
+	}
+	user := p.Always || PrivacyRequested(request, PrivacyHeader) || PrivacyRequested(request, PrivacyUser)
+	id := p.Always || PrivacyRequested(request, PrivacyID)
+	if !user && !id {
+		return false
+	}
+	control := request.Control()
+	entry := hiddenRequest{hidden: clockOrDefault(p.Clock).Now()}
+	headers := request.Headers()
+	if user && p.AnonymizeFrom && headers.From != nil {
+		entry.from = CloneHeader(headers.From)
+		headers.From.SetValue(`"Anonymous"`)
+		headers.From.SetUri(AnonymousUri)
+	}
+	if user {
+		for _, name := range p.headers() {
+			headers.Extensions.Remove(name)
+		}
+		if p.HideVias && len(control.Via) > 1 {
+			entry.via = append(entry.via, control.Via[1:]...)
+			control.Via = control.Via[:1]
+			if len(control.ViaParams) > 1 {
+				entry.viaParams = append(entry.viaParams, control.ViaParams[1:]...)
+				control.ViaParams = control.ViaParams[:1]
+			}
+		}
+	}
+	if id {
+		for _, name := range p.identityHeaders() {
+			headers.Extensions.Remove(name)
+		}
+	}
+	headers.Extensions.Remove("Privacy")
+	p.keep(control.ViaBranch, entry)
+	return true
+}
+
+// keep stores what was hidden from the request with the given branch,
+// forgetting what expired
+func (p *PrivacyPolicy) keep(branch string, entry hiddenRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hidden == nil {
+		p.hidden = make(map[string]hiddenRequest)
+	}
+	for key, other := range p.hidden {
+		if entry.hidden.Sub(other.hidden) >= p.ttl() {
+			delete(p.hidden, key)
+		}
+	}
+	p.hidden[branch] = entry
+}
+
+// Restore gives a response back what was hidden from its request: the
+// From, and the Vias, so that it can be relayed upstream. What was hidden
+// is forgotten with the final response. It reports whether the response
+// answered an anonymized request
+func (p *PrivacyPolicy) Restore(response *Response) bool {
+	control := response.Control()
+	p.mu.Lock()
+	entry, ok := p.hidden[control.ViaBranch]
+	if ok && IsFinal(response.StatusCode()) {
+		delete(p.hidden, control.ViaBranch)
+	}
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if entry.from != nil && response.Headers().From != nil {
+		tag := response.Headers().From.Param("tag")
+		response.Headers().From = CloneHeader(entry.from).SetParam("tag", tag)
+	}
+	if len(entry.via) > 0 {
+		for len(control.ViaParams) < len(control.Via) {
+			control.ViaParams = append(control.ViaParams, "")
+		}
+		control.Via = append(control.Via, entry.via...)
+		control.ViaParams = append(control.ViaParams, entry.viaParams...)
+	}
+	return true
+}
+
+// Hidden returns how many requests have something hidden kept for their
+// responses
+func (p *PrivacyPolicy) Hidden() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.hidden)
+}
+
+// Interceptor returns an Interceptor anonymizing outgoing requests and
+// restoring what was hidden in the responses received to them
+func (p *PrivacyPolicy) Interceptor() Interceptor {
+	return func(m Message, direction Direction) (Message, error) {
+		switch response := m.(type) {
+		case *Response:
+			if direction == Inbound {
+				p.Restore(response)
+			}
+		default:
+			if direction == Outbound && m.Method() != "ACK" && m.Method() != "CANCEL" {
+				p.Anonymize(m)
+			}
+		}
+		return m, nil
+	}
+}
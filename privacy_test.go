@@ -0,0 +1,66 @@
+package slurp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivacyPolicy(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	policy := &PrivacyPolicy{AnonymizeFrom: true, HideVias: true, Clock: clock}
+	request := func(privacy string) Message {
+		invite, _ := NewInvite().To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").
+			Header("Privacy", privacy).
+			Header("P-Asserted-Identity", "<sip:alice@atlanta.com>").
+			Header("User-Agent", "softphone/1.0").
+			Build()
+		invite.Headers().From.SetValue(`"Alice"`).SetParam("tag", "1928301774")
+		control := invite.Control()
+		control.Via = [][2]string{{"UDP", "b2bua.example.com:5060"}, {"UDP", "pc33.atlanta.com:5060"}}
+		control.ViaParams = []string{"", "received=192.0.2.1"}
+		control.ViaBranch = NewBranch()
+		return invite
+	}
+
+	invite := request("id")
+	assert.True(t, policy.Anonymize(invite))
+	assert.Equal(t, "", invite.Headers().Extensions.Get("P-Asserted-Identity"))
+	assert.Equal(t, "", invite.Headers().Extensions.Get("Privacy"))
+	assert.Equal(t, "softphone/1.0", invite.Headers().Extensions.Get("User-Agent"))
+	assert.Equal(t, "sip:alice@atlanta.com", invite.Headers().From.Uri())
+	assert.False(t, policy.Anonymize(request("none")))
+
+	invite = request("header;id")
+	assert.True(t, policy.Anonymize(invite))
+	assert.Equal(t, AnonymousUri, invite.Headers().From.Uri())
+	assert.Equal(t, "1928301774", invite.Headers().From.Param("tag"))
+	assert.Equal(t, "", invite.Headers().Extensions.Get("User-Agent"))
+	assert.Equal(t, [][2]string{{"UDP", "b2bua.example.com:5060"}}, invite.Control().Via)
+	assert.NotContains(t, invite.Render(), "pc33.atlanta.com")
+	assert.NotContains(t, invite.Render(), "Alice")
+
+	// the responses get back what was hidden, until the final one
+	response := NewResponse(invite, 180)
+	assert.True(t, policy.Restore(response))
+	assert.Equal(t, "sip:alice@atlanta.com", response.Headers().From.Uri())
+	assert.Equal(t, [][2]string{{"UDP", "b2bua.example.com:5060"}, {"UDP", "pc33.atlanta.com:5060"}}, response.Control().Via)
+	assert.Equal(t, []string{"", "received=192.0.2.1"}, response.Control().ViaParams)
+	assert.Equal(t, 2, policy.Hidden())
+	assert.True(t, policy.Restore(NewResponse(invite, 200)))
+	assert.False(t, policy.Restore(NewResponse(invite, 200)))
+
+	// what was never answered is forgotten after the TTL
+	clock.Advance(DefaultTimers.B)
+	policy.Anonymize(request("user"))
+	assert.Equal(t, 1, policy.Hidden())
+
+	// requests within a dialog are left alone, and Always anonymizes
+	// requests without a Privacy header
+	inDialog := request("id")
+	inDialog.Headers().To.SetParam("tag", "a6c85cf")
+	assert.False(t, policy.Anonymize(inDialog))
+	policy.Always = true
+	assert.True(t, policy.Anonymize(request("")))
+}
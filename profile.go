@@ -0,0 +1,145 @@
+package slurp
+
+/*
+A Profile is the local identity a UA presents: who it is, where it can be
+reached, and how it authenticates. Applying a profile to outgoing requests
+keeps their From, Contact, Via and User-Agent consistent.
+*/
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Profile is a local identity
+type Profile struct {
+	DisplayName string `json:"display_name" yaml:"display_name"`
+	// AOR is the address-of-record, e.g. sip:alice@atlanta.com
+	AOR string `json:"aor" yaml:"aor"`
+	// ContactHost and ContactPort are where we can be reached
+	ContactHost string `json:"contact_host" yaml:"contact_host"`
+	ContactPort int    `json:"contact_port" yaml:"contact_port"`
+	// Transport is the preferred transport, UDP when empty
+	Transport string `json:"transport" yaml:"transport"`
+	// Username and Password answer authentication challenges. Username
+	// defaults to the user part of the AOR
+	Username  string `json:"username" yaml:"username"`
+	Password  string `json:"password" yaml:"password"`
+	UserAgent string `json:"user_agent" yaml:"user_agent"`
+	// Route is the pre-loaded route set for initial requests, e.g. the
+	// outbound proxy <sip:proxy.atlanta.com;lr>
+	Route []string `json:"route" yaml:"route"`
+	// ServiceRoute is the Service-Route the registrar returned for the
+	// AOR (RFC 3608), followed after Route by requests other than REGISTER
+	ServiceRoute []string `json:"-" yaml:"-"`
+}
+
+func (p *Profile) transport() string {
+	if p.Transport == "" {
+		return "UDP"
+	}
+	return strings.ToUpper(p.Transport)
+}
+
+func (p *Profile) hostPort() string {
+	if p.ContactPort == 0 {
+		return p.ContactHost
+	}
+	return net.JoinHostPort(p.ContactHost, strconv.Itoa(p.ContactPort))
+}
+
+// From returns a From header for the profile, with a new tag
+func (p *Profile) From() AddressHeader {
+	return SetDisplayName(NewHeader(&ToFrom{}), p.DisplayName).SetUri(p.AOR).SetParam("tag", generateTag())
+}
+
+// ContactUri returns the URI we can be reached at, which carries the
+// transport when it isn't UDP
+func (p *Profile) ContactUri() string {
+	user, _ := uriUserHost(p.AOR)
+	uri := "sip:"
+	if user != "" {
+		uri += user + "@"
+	}
+	uri += p.hostPort()
+	if transport := p.transport(); transport != "UDP" {
+		uri += ";transport=" + strings.ToLower(transport)
+	}
+	return uri
+}
+
+// Contact returns a Contact header for the profile
+func (p *Profile) Contact() AddressHeader {
+	return SetDisplayName(NewHeader(&Contact{}), p.DisplayName).SetUri(p.ContactUri())
+}
+
+// Apply fills in the From, Contact, Via, User-Agent and, for initial
+// requests, the Route of an outgoing request from the profile. Fields
+// already set are kept, except that a From without a URI is replaced
+func (p *Profile) Apply(request Message) {
+	headers, control := request.Headers(), request.Control()
+	if headers.From == nil || headers.From.Uri() == "" {
+		headers.From = p.From()
+	}
+	if len(headers.Contacts) == 0 && p.ContactHost != "" {
+		headers.Contacts = []AddressHeader{p.Contact()}
+	}
+	if len(control.Via) == 0 && p.ContactHost != "" {
+		control.Via = [][2]string{{p.transport(), p.hostPort()}}
+	}
+	// the typed UserAgent field isn't rendered, so this goes with the extensions
+	if p.UserAgent != "" && headers.Extensions.Get("User-Agent") == "" {
+		headers.Extensions.Add("User-Agent", p.UserAgent)
+	}
+	routes := p.Route
+	if request.Method() != "REGISTER" && len(p.ServiceRoute) > 0 {
+		routes = append(append([]string{}, p.Route...), p.ServiceRoute...)
+	}
+	preloadRoutes(request, routes)
+}
+
+// Authorize answers the 401 or 407 challenge to request with the profile's
+// credentials. The credentials are added to request, which is made a new
+// transaction with the next sequence number, ready to be sent again
+func (p *Profile) Authorize(request Message, challenge *Response) error {
+	challengeHeader, credentialsHeader := "WWW-Authenticate", "Authorization"
+	if challenge.StatusCode() == 407 {
+		challengeHeader, credentialsHeader = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+	value := challenge.HeaderValue(challengeHeader)
+	if value == "" {
+		value = challenge.Headers().Extensions.Get(challengeHeader)
+	}
+	if value == "" {
+		return fmt.Errorf("%d response has no %s", challenge.StatusCode(), challengeHeader)
+	}
+	parsed, err := ParseChallenge(value)
+	if err != nil {
+		return err
+	}
+	username := p.Username
+	if username == "" {
+		username, _ = uriUserHost(p.AOR)
+	}
+	credentials := DigestCredentials{
+		Username: username,
+		Realm:    parsed.Realm,
+		Nonce:    parsed.Nonce,
+		Uri:      request.Uri(),
+		Opaque:   parsed.Opaque,
+	}
+	for _, qop := range strings.Split(parsed.Qop, ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			credentials.Qop = "auth"
+			credentials.NonceCount = "00000001"
+			credentials.Cnonce = generateTag() + generateTag()
+		}
+	}
+	credentials.Response = credentials.Expected(HA1(username, parsed.Realm, p.Password), request.Method())
+	request.Headers().Extensions.Set(credentialsHeader, credentials.String())
+	request.Control().Sequence++
+	request.Control().ViaBranch = ""
+	return nil
+}
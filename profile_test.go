@@ -0,0 +1,57 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exampleProfile() *Profile {
+	return &Profile{
+		DisplayName: "Alice",
+		AOR:         "sip:alice@atlanta.com",
+		ContactHost: "192.0.2.1",
+		ContactPort: 5061,
+		Transport:   "tcp",
+		Password:    "wonderland",
+		UserAgent:   "slurp",
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	exampleProfile().Apply(request)
+	assert.Equal(t, "sip:alice@atlanta.com", request.Headers().From.Uri())
+	assert.NotEqual(t, "", request.Headers().From.Param("tag"))
+	assert.Equal(t, "sip:alice@192.0.2.1:5061;transport=tcp", request.Headers().Contacts[0].Uri())
+	assert.Equal(t, [][2]string{{"TCP", "192.0.2.1:5061"}}, request.Control().Via)
+	assert.Contains(t, request.Render(), "User-Agent: slurp\r\n")
+}
+
+func TestProfileAuthorize(t *testing.T) {
+	profile := exampleProfile()
+	credentials := NewMemoryCredentials()
+	credentials.SetPassword("alice", "atlanta.com", "wonderland")
+	auth := NewAuthenticator("atlanta.com", credentials)
+
+	request := NewRequest("REGISTER", "sip:atlanta.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri(profile.AOR)
+	request.Control().CallId = "843817637684230@998sdasdh09"
+	request.Control().Sequence = 1
+	profile.Apply(request)
+	received, err := ParseMessage(request.Render())
+	assert.Nil(t, err)
+	_, challenge := auth.Authenticate(received)
+	if !assert.NotNil(t, challenge) {
+		return
+	}
+
+	assert.Nil(t, profile.Authorize(request, challenge))
+	assert.Equal(t, 2, request.Control().Sequence)
+	received, err = ParseMessage(request.Render())
+	assert.Nil(t, err)
+	username, response := auth.Authenticate(received)
+	assert.Nil(t, response)
+	assert.Equal(t, "alice", username)
+}
@@ -0,0 +1,146 @@
+/*
+Package protobuf converts the messages slurp parses to and from the
+protobuf representation in message.proto, so that high-volume services
+can forward them over gRPC, Kafka or other buses, and consumers read
+their fields without parsing SIP again. The encoding is written against
+the schema by hand, so slurp needs no protobuf runtime; consumers in
+any language can decode it with code generated from message.proto.
+*/
+package protobuf
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/qmuloadmin/slurp"
+)
+
+// Header is a header line, as rendered
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Via is a Via of a message, topmost first
+type Via struct {
+	Transport string
+	SentBy    string
+	Branch    string
+	// Params are the other parameters, without the leading semicolon
+	Params string
+}
+
+// Address is a From or To
+type Address struct {
+	DisplayName string
+	Uri         string
+	Tag         string
+}
+
+// Message is the SipMessage of message.proto
+type Message struct {
+	// Method is the method of a request, or the CSeq method of a response
+	Method string
+	// RequestUri is only set for requests, and StatusCode and Reason for
+	// responses
+	RequestUri string
+	StatusCode int32
+	Reason     string
+	CallId     string
+	CSeq       uint32
+	From       *Address
+	To         *Address
+	Via        []Via
+	// Headers are every header, in the order rendered
+	Headers []Header
+	Body    []byte
+	// Source is the host:port the message was received from, if it was
+	Source string
+}
+
+// IsResponse reports whether the message is a response
+func (m *Message) IsResponse() bool {
+	return m.StatusCode != 0
+}
+
+func address(h slurp.AddressHeader) *Address {
+	if h == nil {
+		return nil
+	}
+	return &Address{DisplayName: slurp.DisplayName(h), Uri: h.Uri(), Tag: h.Param("tag")}
+}
+
+// FromMessage converts a slurp message
+func FromMessage(m slurp.Message) *Message {
+	control := m.Control()
+	p := &Message{
+		Method: m.Method(),
+		CallId: control.CallId,
+		CSeq:   uint32(control.Sequence),
+		From:   address(m.Headers().From),
+		To:     address(m.Headers().To),
+		Body:   m.Payload(),
+	}
+	if response, ok := m.(*slurp.Response); ok {
+		p.StatusCode, p.Reason = int32(response.StatusCode()), response.Reason()
+	} else {
+		p.RequestUri = m.Uri()
+	}
+	for i, via := range control.Via {
+		v := Via{Transport: via[0], SentBy: via[1]}
+		if i < len(control.ViaParams) {
+			v.Params = control.ViaParams[i]
+		}
+		if i == 0 {
+			v.Branch = control.ViaBranch
+		} else {
+			v.Branch, v.Params = splitBranch(v.Params)
+		}
+		p.Via = append(p.Via, v)
+	}
+	head, _, _ := strings.Cut(m.Render(), "\r\n\r\n")
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			p.Headers = append(p.Headers, Header{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		}
+	}
+	return p
+}
+
+// FromIncoming converts a received message, recording where it came from
+func FromIncoming(in slurp.Incoming) *Message {
+	p := FromMessage(in.Message)
+	if in.Source != nil {
+		p.Source = in.Source.String()
+	}
+	return p
+}
+
+// splitBranch takes the branch out of the parameters of a Via
+func splitBranch(params string) (string, string) {
+	parts := strings.Split(params, ";")
+	for i, part := range parts {
+		if name, value, _ := strings.Cut(strings.TrimSpace(part), "="); strings.EqualFold(name, "branch") {
+			return value, strings.Join(append(parts[:i:i], parts[i+1:]...), ";")
+		}
+	}
+	return "", params
+}
+
+// ToMessage converts the message back to a slurp message, from its start
+// line, headers and body
+func (m *Message) ToMessage() (slurp.Message, error) {
+	var b strings.Builder
+	if m.IsResponse() {
+		b.WriteString("SIP/2.0 " + strconv.Itoa(int(m.StatusCode)) + " " + m.Reason)
+	} else {
+		b.WriteString(m.Method + " " + m.RequestUri + " SIP/2.0")
+	}
+	b.WriteString("\r\n")
+	for _, header := range m.Headers {
+		b.WriteString(header.Name + ": " + header.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(m.Body)
+	return slurp.ParseMessage(b.String())
+}
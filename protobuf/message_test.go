@@ -0,0 +1,52 @@
+package protobuf
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile("../examples/invite.sip")
+	if err != nil {
+		t.Skip("example missing")
+	}
+	invite := &slurp.Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+
+	p := FromMessage(invite)
+	assert.Equal(t, "INVITE", p.Method)
+	assert.Equal(t, invite.Control().CallId, p.CallId)
+	assert.Equal(t, invite.Headers().From.Param("tag"), p.From.Tag)
+	assert.Equal(t, invite.Control().ViaBranch, p.Via[0].Branch)
+
+	decoded, err := Unmarshal(p.Marshal())
+	assert.Nil(t, err)
+	assert.Equal(t, p, decoded)
+	m, err := decoded.ToMessage()
+	assert.Nil(t, err)
+	// an INVITE renders its own Supported besides the one parsed
+	assert.Empty(t, slurp.Compare(invite, m, "Supported"))
+
+	response := slurp.NewResponse(invite, 486)
+	decoded, err = Unmarshal(FromMessage(response).Marshal())
+	assert.Nil(t, err)
+	assert.True(t, decoded.IsResponse())
+	assert.Equal(t, int32(486), decoded.StatusCode)
+	assert.Equal(t, "INVITE", decoded.Method)
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	data := appendString(nil, 5, "a84b4c76e66710")
+	data = appendVarint(data, 99, 7)
+	data = appendString(data, 100, "future")
+	data = append(appendKey(data, 101, wireFixed32), 1, 2, 3, 4)
+	m, err := Unmarshal(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "a84b4c76e66710", m.CallId)
+
+	_, err = Unmarshal(data[:len(data)-2])
+	assert.Equal(t, ErrTruncated, err)
+}
@@ -0,0 +1,226 @@
+package protobuf
+
+/*
+The protobuf wire format (https://protobuf.dev/programming-guides/encoding):
+every field is a varint key, the field number shifted left by three
+bits and or'ed with the wire type, followed by a varint or by a varint
+length and as many bytes for strings, bytes and embedded messages.
+Fields holding their default value aren't encoded, as in proto3.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ErrTruncated indicates data ended in the middle of a field
+var ErrTruncated = errors.New("protobuf: truncated message")
+
+func appendKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendVarint(b []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendKey(b, field, wireVarint), value)
+}
+
+func appendBytes(b []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	return appendEmbedded(b, field, value)
+}
+
+// appendEmbedded appends value even when empty, as embedded messages
+// are present or not regardless of their fields
+func appendEmbedded(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(appendKey(b, field, wireBytes), uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendString(b []byte, field int, value string) []byte {
+	return appendBytes(b, field, []byte(value))
+}
+
+// Marshal encodes the message as a SipMessage
+func (m *Message) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Method)
+	b = appendString(b, 2, m.RequestUri)
+	// int32 is encoded as the sign extended 64 bit value
+	b = appendVarint(b, 3, uint64(int64(m.StatusCode)))
+	b = appendString(b, 4, m.Reason)
+	b = appendString(b, 5, m.CallId)
+	b = appendVarint(b, 6, uint64(m.CSeq))
+	if m.From != nil {
+		b = appendEmbedded(b, 7, m.From.marshal())
+	}
+	if m.To != nil {
+		b = appendEmbedded(b, 8, m.To.marshal())
+	}
+	for _, via := range m.Via {
+		b = appendEmbedded(b, 9, via.marshal())
+	}
+	for _, header := range m.Headers {
+		b = appendEmbedded(b, 10, header.marshal())
+	}
+	b = appendBytes(b, 11, m.Body)
+	b = appendString(b, 12, m.Source)
+	return b
+}
+
+func (a *Address) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, a.DisplayName)
+	b = appendString(b, 2, a.Uri)
+	return appendString(b, 3, a.Tag)
+}
+
+func (v Via) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, v.Transport)
+	b = appendString(b, 2, v.SentBy)
+	b = appendString(b, 3, v.Branch)
+	return appendString(b, 4, v.Params)
+}
+
+func (h Header) marshal() []byte {
+	return appendString(appendString(nil, 1, h.Name), 2, h.Value)
+}
+
+// field is a decoded field, with either its varint or its bytes set
+type field struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// fields decodes the fields of a message, calling f with each. Fields of
+// the wire types f doesn't expect are skipped, so that data from a newer
+// schema decodes
+func fields(data []byte, f func(field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		data = data[n:]
+		current := field{number: int(key >> 3), wire: int(key & 7)}
+		switch current.wire {
+		case wireVarint:
+			if current.varint, n = binary.Uvarint(data); n <= 0 {
+				return ErrTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return ErrTruncated
+			}
+			data = data[8:]
+			continue
+		case wireFixed32:
+			if len(data) < 4 {
+				return ErrTruncated
+			}
+			data = data[4:]
+			continue
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return ErrTruncated
+			}
+			current.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", current.wire)
+		}
+		if err := f(current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes a SipMessage
+func Unmarshal(data []byte) (*Message, error) {
+	m := &Message{}
+	err := fields(data, func(f field) error {
+		switch {
+		case f.wire == wireVarint && f.number == 3:
+			m.StatusCode = int32(f.varint)
+		case f.wire == wireVarint && f.number == 6:
+			m.CSeq = uint32(f.varint)
+		case f.wire != wireBytes:
+		case f.number == 1:
+			m.Method = string(f.bytes)
+		case f.number == 2:
+			m.RequestUri = string(f.bytes)
+		case f.number == 4:
+			m.Reason = string(f.bytes)
+		case f.number == 5:
+			m.CallId = string(f.bytes)
+		case f.number == 7:
+			m.From = &Address{}
+			return m.From.unmarshal(f.bytes)
+		case f.number == 8:
+			m.To = &Address{}
+			return m.To.unmarshal(f.bytes)
+		case f.number == 9:
+			var via Via
+			if err := via.unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Via = append(m.Via, via)
+		case f.number == 10:
+			var header Header
+			if err := header.unmarshal(f.bytes); err != nil {
+				return err
+			}
+			m.Headers = append(m.Headers, header)
+		case f.number == 11:
+			m.Body = append([]byte(nil), f.bytes...)
+		case f.number == 12:
+			m.Source = string(f.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeStrings decodes a message whose fields are all strings into targets,
+// by field number starting at 1
+func decodeStrings(data []byte, targets ...*string) error {
+	return fields(data, func(f field) error {
+		if f.wire == wireBytes && f.number >= 1 && f.number <= len(targets) {
+			*targets[f.number-1] = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *Address) unmarshal(data []byte) error {
+	return decodeStrings(data, &a.DisplayName, &a.Uri, &a.Tag)
+}
+
+func (v *Via) unmarshal(data []byte) error {
+	return decodeStrings(data, &v.Transport, &v.SentBy, &v.Branch, &v.Params)
+}
+
+func (h *Header) unmarshal(data []byte) error {
+	return decodeStrings(data, &h.Name, &h.Value)
+}
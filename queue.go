@@ -0,0 +1,445 @@
+package slurp
+
+/*
+Call distribution on the proxy: a HuntGroup offers a call to its members
+with a strategy, ringing everyone at once, or one at a time in a fixed or
+rotating order, each for its own timeout, and forwards it to an overflow
+target when nobody answers. A Queue holds calls while the members of its
+group are busy, first come first served, sending callers 182 Queued
+until a member answers or they waited too long.
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HuntStrategy is the order a HuntGroup rings its members in
+type HuntStrategy int
+
+const (
+	// RingAll rings every member at once
+	RingAll HuntStrategy = iota
+	// Linear rings the members one at a time, in order
+	Linear
+	// RoundRobin rings the members one at a time, starting with the one
+	// after the member the previous call started with
+	RoundRobin
+)
+
+func (s HuntStrategy) String() string {
+	switch s {
+	case RingAll:
+		return "ring-all"
+	case Linear:
+		return "linear"
+	case RoundRobin:
+		return "round-robin"
+	}
+	return "unknown"
+}
+
+// DefaultMemberTimeout is how long members ring when neither they nor
+// their HuntGroup set a timeout
+const DefaultMemberTimeout = 20 * time.Second
+
+// HuntMember is a member of a HuntGroup
+type HuntMember struct {
+	// Uri is where calls for the member are sent, e.g. their contact
+	Uri string
+	// Timeout is how long the member rings, the group's MemberTimeout
+	// when zero
+	Timeout time.Duration
+}
+
+// HuntGroup offers calls to its members through a UserAgent acting as a
+// proxy. It is safe for concurrent use, though its fields must not be
+// changed while calls are offered
+type HuntGroup struct {
+	Members  []HuntMember
+	Strategy HuntStrategy
+	// MemberTimeout is how long each member rings, DefaultMemberTimeout
+	// when zero
+	MemberTimeout time.Duration
+	// Overflow is the URI calls no member answered are forwarded to, e.g.
+	// voicemail. They are rejected when it is empty
+	Overflow string
+	// Clock used for timeouts, DefaultClock when nil
+	Clock Clock
+	ua    *UserAgent
+	mu    sync.Mutex
+	// next is the member the next RoundRobin call starts with
+	next    int
+	pending pendingCalls
+}
+
+// NewHuntGroup creates a HuntGroup offering calls through ua
+func NewHuntGroup(ua *UserAgent, strategy HuntStrategy, members ...HuntMember) *HuntGroup {
+	return &HuntGroup{Members: members, Strategy: strategy, ua: ua}
+}
+
+func (g *HuntGroup) memberTimeout(member HuntMember) time.Duration {
+	switch {
+	case member.Timeout > 0:
+		return member.Timeout
+	case g.MemberTimeout > 0:
+		return g.MemberTimeout
+	}
+	return DefaultMemberTimeout
+}
+
+// order returns the members in the order they are rung
+func (g *HuntGroup) order() []HuntMember {
+	if g.Strategy != RoundRobin || len(g.Members) == 0 {
+		return g.Members
+	}
+	g.mu.Lock()
+	start := g.next % len(g.Members)
+	g.next = start + 1
+	g.mu.Unlock()
+	return append(append([]HuntMember(nil), g.Members[start:]...), g.Members[:start]...)
+}
+
+// ring offers request to the members, without overflowing. It returns the
+// answer of a member, or else their final responses
+func (g *HuntGroup) ring(ctx context.Context, request Message, provisional func(*Response)) (*Response, []*Response) {
+	members := g.order()
+	if g.Strategy == RingAll {
+		bindings := make([]Binding, len(members))
+		timeouts := make([]time.Duration, len(members))
+		for i, member := range members {
+			bindings[i], timeouts[i] = Binding{Contact: member.Uri}, g.memberTimeout(member)
+		}
+		return g.ua.forward(ctx, request, bindings, timeouts, g.Clock, provisional)
+	}
+	var finals []*Response
+	for _, member := range members {
+		accepted, responses := g.ua.forward(ctx, request, []Binding{{Contact: member.Uri}}, []time.Duration{g.memberTimeout(member)}, g.Clock, provisional)
+		if accepted != nil {
+			return accepted, nil
+		}
+		finals = append(finals, responses...)
+		if ctx.Err() != nil {
+			break
+		}
+		for _, response := range responses {
+			if response.Class() == GlobalFailure {
+				return nil, finals
+			}
+		}
+	}
+	return nil, finals
+}
+
+// overflow forwards request to uri, when there is one, returning its
+// final response, or else the best of finals
+func (ua *UserAgent) overflow(ctx context.Context, request Message, uri string, finals []*Response, provisional func(*Response)) *Response {
+	if uri != "" && ctx.Err() == nil {
+		accepted, responses := ua.forward(ctx, request, []Binding{{Contact: uri}}, nil, nil, provisional)
+		if accepted != nil {
+			return accepted
+		}
+		finals = responses
+	}
+	return bestResponse(request, finals)
+}
+
+// Hunt offers request to the members with the group's strategy, and to
+// the Overflow when none answered, returning the final response to send
+// upstream. provisional, when not nil, is called with the provisional
+// responses other than 100. A 6xx from a member ends the hunt
+func (g *HuntGroup) Hunt(ctx context.Context, request Message, provisional func(*Response)) (*Response, error) {
+	accepted, finals := g.ring(ctx, request, provisional)
+	if accepted != nil {
+		return accepted, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, response := range finals {
+		if response.Class() == GlobalFailure {
+			return response, nil
+		}
+	}
+	return g.ua.overflow(ctx, request, g.Overflow, finals, provisional), nil
+}
+
+// The intervals of a Queue when it doesn't set them
+const (
+	DefaultRetryInterval = 5 * time.Second
+	// DefaultHoldInterval keeps callers' INVITE transactions from timing
+	// out, well within the three minutes of Timer C
+	DefaultHoldInterval = time.Minute
+)
+
+// Queue holds calls for a HuntGroup, offering them to its members first
+// come first served: the call at the head of the queue is offered to the
+// group, again every RetryInterval, until a member answers, and the
+// others wait their turn, behind those of a higher Priority. Callers get
+// 182 Queued when they join, and every HoldInterval while they wait. It
+// is safe for concurrent use
+type Queue struct {
+	// Group's members answer the calls. Its Overflow is ignored, callers
+	// overflow when they waited MaxWait
+	Group *HuntGroup
+	// MaxWaiting is how many calls may wait, MaxWait how long each may,
+	// both unlimited when zero
+	MaxWaiting int
+	MaxWait    time.Duration
+	// RetryInterval is how long to wait before offering the call at the
+	// head of the queue again, DefaultRetryInterval when zero
+	RetryInterval time.Duration
+	// HoldInterval is how often callers get 182 Queued,
+	// DefaultHoldInterval when zero
+	HoldInterval time.Duration
+	// Overflow is the URI calls that can't wait are forwarded to. They are
+	// rejected with 486, or the best response of the members, when it
+	// is empty
+	Overflow string
+	// Priority, when set, ranks calls, e.g. a PriorityPolicy's Rank: a
+	// call waits ahead of those of a lower rank, though never ahead of
+	// the call being offered. All calls rank the same when nil
+	Priority func(Message) int
+	// Clock used for the intervals and MaxWait, DefaultClock when nil
+	Clock Clock
+	mu    sync.Mutex
+	// waiting are the calls waiting, in order. The turn of the first is
+	// closed when it is that call's turn
+	waiting []queuedCall
+	pending pendingCalls
+}
+
+// queuedCall is a call waiting in a Queue
+type queuedCall struct {
+	turn chan struct{}
+	rank int
+}
+
+// Waiting returns how many calls are in the queue
+func (q *Queue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+// join adds a call of the given rank to the queue, behind those of the
+// same rank or higher, returning its turn, or nil when full
+func (q *Queue) join(rank int) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.MaxWaiting > 0 && len(q.waiting) >= q.MaxWaiting {
+		return nil
+	}
+	call := queuedCall{turn: make(chan struct{}), rank: rank}
+	i := len(q.waiting)
+	for i > 1 && q.waiting[i-1].rank < rank {
+		i--
+	}
+	q.waiting = append(q.waiting[:i], append([]queuedCall{call}, q.waiting[i:]...)...)
+	if len(q.waiting) == 1 {
+		close(call.turn)
+	}
+	return call.turn
+}
+
+// leave removes a call from the queue, giving the next its turn
+func (q *Queue) leave(turn chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, other := range q.waiting {
+		if other.turn == turn {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			if i == 0 && len(q.waiting) > 0 {
+				close(q.waiting[0].turn)
+			}
+			return
+		}
+	}
+}
+
+func (q *Queue) interval(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// Enqueue queues request until a member of the Group answers it, and
+// returns the final response to send upstream. provisional, when not nil,
+// is called with 182 Queued while the call waits, and the provisional
+// responses of the members other than 100. A call that can't join the
+// queue, or waited MaxWait, is forwarded to the Overflow
+func (q *Queue) Enqueue(ctx context.Context, request Message, provisional func(*Response)) (*Response, error) {
+	if provisional == nil {
+		provisional = func(*Response) {}
+	}
+	rank := 0
+	if q.Priority != nil {
+		rank = q.Priority(request)
+	}
+	turn := q.join(rank)
+	if turn == nil {
+		return q.Group.ua.overflow(ctx, request, q.Overflow, []*Response{NewResponse(request, 486)}, provisional), nil
+	}
+	defer q.leave(turn)
+	clock := clockOrDefault(q.Clock)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// waited is closed once the call waited MaxWait, which overflows it
+	waited := make(chan struct{})
+	if q.MaxWait > 0 {
+		timer := clock.AfterFunc(q.MaxWait, func() { close(waited) })
+		defer timer.Stop()
+	}
+	hold := make(chan struct{}, 1)
+	holdInterval := q.interval(q.HoldInterval, DefaultHoldInterval)
+	holdTimer := clock.AfterFunc(holdInterval, func() {
+		select {
+		case hold <- struct{}{}:
+		default:
+		}
+	})
+	defer holdTimer.Stop()
+	// every 182 is the same response, with the same To tag, or the caller
+	// would see a new early dialog each HoldInterval
+	queued := NewResponse(request, 182)
+	provisional(Clone(queued).(*Response))
+
+	var finals []*Response
+	var retryTimer Timer
+	defer func() {
+		if retryTimer != nil {
+			retryTimer.Stop()
+		}
+	}()
+	for ready := turn; ; {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-waited:
+			return q.Group.ua.overflow(ctx, request, q.Overflow, finals, provisional), nil
+		case <-hold:
+			provisional(Clone(queued).(*Response))
+			holdTimer.Reset(holdInterval)
+			continue
+		case <-ready:
+		}
+		// the members ring until they answer or the call waited MaxWait
+		ringing, stop := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-waited:
+				stop()
+			case <-ringing.Done():
+			}
+		}()
+		accepted, responses := q.Group.ring(ringing, request, provisional)
+		stop()
+		if accepted != nil {
+			return accepted, nil
+		}
+		if len(responses) > 0 {
+			finals = responses
+		}
+		for _, response := range responses {
+			if response.Class() == GlobalFailure {
+				return response, nil
+			}
+		}
+		retry := make(chan struct{})
+		retryTimer = clock.AfterFunc(q.interval(q.RetryInterval, DefaultRetryInterval), func() { close(retry) })
+		ready = retry
+	}
+}
+
+// pendingCalls are the calls a filter is distributing, by server
+// transaction, so that CANCELs stop them
+type pendingCalls struct {
+	mu    sync.Mutex
+	calls map[string]context.CancelFunc
+}
+
+func (p *pendingCalls) add(key string, cancel context.CancelFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.calls == nil {
+		p.calls = make(map[string]context.CancelFunc)
+	}
+	p.calls[key] = cancel
+}
+
+func (p *pendingCalls) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.calls, key)
+}
+
+func (p *pendingCalls) cancel(key string) bool {
+	p.mu.Lock()
+	cancel, ok := p.calls[key]
+	p.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// distributeFilter passes the INVITEs for uri to distribute, sending the
+// provisional and final responses back to the sender, and answers the
+// CANCELs for them. Other requests go on to the UserAgent
+func (ua *UserAgent) distributeFilter(uri string, pending *pendingCalls, distribute func(context.Context, Message, func(*Response)) (*Response, error)) RequestFilter {
+	aor := AddressOfRecord(uri)
+	return func(in Incoming) (*Response, bool) {
+		request := in.Message
+		switch {
+		case request.Method() == "CANCEL":
+			if !pending.cancel(CancelledTransactionKey(request)) {
+				return nil, true
+			}
+			return NewResponse(request, 200), false
+		case AddressOfRecord(request.Uri()) != aor:
+			return nil, true
+		case request.Method() == "ACK":
+			// the ACKs for the failure responses we sent end at us
+			return nil, false
+		case request.Method() != "INVITE":
+			return nil, true
+		}
+		if response := DecrementMaxForwards(request); response != nil {
+			return response, false
+		}
+		key := ServerTransactionKey(request)
+		ctx, cancel := context.WithCancel(context.Background())
+		pending.add(key, cancel)
+		source := in.Source.String()
+		go func() {
+			defer func() {
+				cancel()
+				pending.remove(key)
+			}()
+			response, err := distribute(ctx, request, func(response *Response) {
+				ua.Send(context.Background(), source, response)
+			})
+			if err == context.Canceled {
+				response = NewResponse(request, 487)
+			} else if err != nil {
+				response = NewResponse(request, 500)
+			}
+			ua.Send(context.Background(), source, response)
+		}()
+		return NewResponse(request, 100), false
+	}
+}
+
+// Filter hunts for the INVITEs whose Request-URI is uri, e.g. the
+// number of a sales line, sending the responses back to the caller
+func (g *HuntGroup) Filter(uri string) RequestFilter {
+	return g.ua.distributeFilter(uri, &g.pending, g.Hunt)
+}
+
+// Filter queues the INVITEs whose Request-URI is uri, sending the
+// responses back to the caller
+func (q *Queue) Filter(uri string) RequestFilter {
+	return q.Group.ua.distributeFilter(uri, &q.pending, q.Enqueue)
+}
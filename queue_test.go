@@ -0,0 +1,146 @@
+package slurp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHuntGroupAndQueue(t *testing.T) {
+	p, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer p.Close()
+	proxy := NewUserAgent(p)
+
+	// each member answers with its code, or not at all when it is zero,
+	// recording the order it was tried in
+	tried := make(chan string, 16)
+	member := func(code int) string {
+		c, err := ListenUDP("127.0.0.1:0")
+		assert.Nil(t, err)
+		t.Cleanup(func() { c.Close() })
+		ua := NewUserAgent(c)
+		uri := "sip:agent@" + c.LocalAddr().String()
+		go func() {
+			seen := map[string]bool{}
+			for in := range ua.Receive() {
+				if branch := in.Message.Control().ViaBranch; !seen[branch] {
+					seen[branch] = true
+					tried <- uri
+				}
+				if code != 0 {
+					ua.Send(context.Background(), in.Source.String(), NewResponse(in.Message, code))
+				}
+			}
+		}()
+		return uri
+	}
+	busy, answering, silent, otherBusy := member(486), member(200), member(0), member(486)
+
+	message := func() Message {
+		m, err := ParseMessage(strings.Join([]string{
+			"MESSAGE sip:sales@biloxi.com SIP/2.0",
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=" + NewBranch(),
+			"Max-Forwards: 70",
+			"To: <sip:sales@biloxi.com>",
+			"From: <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: " + generateTag(),
+			"CSeq: 1 MESSAGE",
+			"Content-Length: 0",
+			"", "",
+		}, "\r\n"))
+		assert.Nil(t, err)
+		return m
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// linear tries the members in order until one answers
+	group := NewHuntGroup(proxy, Linear, HuntMember{Uri: busy}, HuntMember{Uri: answering})
+	response, err := group.Hunt(ctx, message(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, busy, <-tried)
+	assert.Equal(t, answering, <-tried)
+
+	// round-robin starts with the next member on each call, and calls
+	// nobody answered overflow
+	group = NewHuntGroup(proxy, RoundRobin, HuntMember{Uri: busy}, HuntMember{Uri: otherBusy})
+	group.Overflow = answering
+	for _, first := range []string{busy, otherBusy} {
+		response, err = group.Hunt(ctx, message(), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, response.StatusCode())
+		assert.Equal(t, first, <-tried)
+		<-tried
+		assert.Equal(t, answering, <-tried)
+	}
+
+	// ring-all rings everyone at once, each for its own timeout
+	group = NewHuntGroup(proxy, RingAll, HuntMember{Uri: silent, Timeout: 100 * time.Millisecond}, HuntMember{Uri: busy})
+	start := time.Now()
+	response, err = group.Hunt(ctx, message(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 486, response.StatusCode())
+	assert.Less(t, time.Since(start), time.Second)
+	<-tried
+	<-tried
+
+	// queued callers get 182 until a member answers
+	queue := &Queue{Group: NewHuntGroup(proxy, Linear, HuntMember{Uri: answering}), MaxWaiting: 1, MaxWait: 200 * time.Millisecond}
+	var provisionals []int
+	response, err = queue.Enqueue(ctx, message(), func(r *Response) {
+		provisionals = append(provisionals, r.StatusCode())
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, []int{182}, provisionals)
+	<-tried
+
+	// a call beyond MaxWaiting overflows at once, and the one waiting
+	// when it waited MaxWait
+	queue.Group = NewHuntGroup(proxy, Linear, HuntMember{Uri: silent, Timeout: time.Second})
+	waited := make(chan *Response)
+	go func() {
+		response, _ := queue.Enqueue(ctx, message(), nil)
+		waited <- response
+	}()
+	for queue.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	response, err = queue.Enqueue(ctx, message(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 486, response.StatusCode())
+	response = <-waited
+	assert.Equal(t, 480, response.StatusCode())
+	assert.Equal(t, 0, queue.Waiting())
+
+	// the 182s repeated while a call waits behind another all have the
+	// same To tag
+	queue = &Queue{Group: NewHuntGroup(proxy, Linear, HuntMember{Uri: silent, Timeout: time.Second}),
+		MaxWait: 200 * time.Millisecond, HoldInterval: 40 * time.Millisecond}
+	go func() {
+		response, _ := queue.Enqueue(ctx, message(), nil)
+		waited <- response
+	}()
+	for queue.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	tags := map[string]bool{}
+	queued := 0
+	_, err = queue.Enqueue(ctx, message(), func(r *Response) {
+		if r.StatusCode() == 182 {
+			queued++
+			tags[r.Headers().To.Param("tag")] = true
+		}
+	})
+	assert.Nil(t, err)
+	assert.Greater(t, queued, 1)
+	assert.Len(t, tags, 1)
+	<-waited
+}
@@ -0,0 +1,149 @@
+package slurp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimitAction is what happens to requests over the limit
+type RateLimitAction int
+
+const (
+	// DropRequest silently discards the request, which gives scanners nothing
+	DropRequest RateLimitAction = iota
+	// RejectRequest answers 503 with a Retry-After
+	RejectRequest
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits requests per key (by default the source IP) with a
+// token bucket, allowing Burst requests at once and Rate per second after
+type RateLimiter struct {
+	Rate   float64
+	Burst  int
+	Action RateLimitAction
+	// RetryAfter in seconds, sent with 503 responses
+	RetryAfter int
+	// Key returns the key requests are limited by. Defaults to the source IP
+	Key func(Incoming) string
+	// Banned, when set, is consulted first: banned keys are always dropped
+	Banned func(key string) bool
+	// OnLimit, when set, is called for each request over the limit, e.g.
+	// to add the key to a BanList
+	OnLimit func(key string)
+	// Clock used to refill buckets, DefaultClock when nil
+	Clock   Clock
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewRateLimiter creates a RateLimiter allowing rate requests per second per key
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// SourceIP returns the IP a request was received from, without the port
+func SourceIP(in Incoming) string {
+	if host, _, err := net.SplitHostPort(in.Source.String()); err == nil {
+		return host
+	}
+	return in.Source.String()
+}
+
+// Allow takes a token from the key's bucket, reporting whether one was available
+func (l *RateLimiter) Allow(key string) bool {
+	now := clockOrDefault(l.Clock).Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+	// forget idle keys so scans from many addresses don't grow the map forever
+	if len(l.buckets) > 10000 {
+		for k, other := range l.buckets {
+			if now.Sub(other.last).Seconds()*l.Rate >= float64(l.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Filter returns a RequestFilter applying the limiter, for UserAgent.AddFilter
+func (l *RateLimiter) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		key := SourceIP(in)
+		if l.Key != nil {
+			key = l.Key(in)
+		}
+		if l.Banned != nil && l.Banned(key) {
+			return nil, false
+		}
+		if l.Allow(key) {
+			return nil, true
+		}
+		if l.OnLimit != nil {
+			l.OnLimit(key)
+		}
+		if l.Action == RejectRequest && in.Message.Method() != "ACK" {
+			response := NewResponse(in.Message, 503)
+			response.Headers().RetryAfter = l.RetryAfter
+			return response, false
+		}
+		return nil, false
+	}
+}
+
+// BanList is a set of keys banned until an expiry time, usable as the
+// Banned hook of a RateLimiter
+type BanList struct {
+	// Clock used to expire bans, DefaultClock when nil
+	Clock Clock
+	mu    sync.Mutex
+	bans  map[string]time.Time
+}
+
+// Ban bans key for duration
+func (b *BanList) Ban(key string, duration time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bans == nil {
+		b.bans = make(map[string]time.Time)
+	}
+	b.bans[key] = clockOrDefault(b.Clock).Now().Add(duration)
+}
+
+// Unban lifts the ban on key
+func (b *BanList) Unban(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.bans, key)
+}
+
+// Banned reports whether key is currently banned
+func (b *BanList) Banned(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.bans[key]
+	if ok && clockOrDefault(b.Clock).Now().After(until) {
+		delete(b.bans, key)
+		return false
+	}
+	return ok
+}
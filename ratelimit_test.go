@@ -0,0 +1,49 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	if err != nil {
+		t.Skip("example invite not available")
+	}
+	invite := &Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+	in := Incoming{Message: invite, Source: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060}}
+
+	clock := NewFakeClock(time.Now())
+	bans := &BanList{Clock: clock}
+	limiter := NewRateLimiter(1, 2)
+	limiter.Clock = clock
+	limiter.Action = RejectRequest
+	limiter.RetryAfter = 30
+	limiter.Banned = bans.Banned
+	limiter.OnLimit = func(key string) { bans.Ban(key, time.Minute) }
+	filter := limiter.Filter()
+
+	for i := 0; i < 2; i++ {
+		_, ok := filter(in)
+		assert.True(t, ok)
+	}
+	response, ok := filter(in)
+	assert.False(t, ok)
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 503, response.StatusCode())
+		assert.Contains(t, response.Render(), "Retry-After: 30\r\n")
+	}
+	// now banned, so dropped without a response even after refilling
+	clock.Advance(5 * time.Second)
+	response, ok = filter(in)
+	assert.False(t, ok)
+	assert.Nil(t, response)
+	clock.Advance(time.Minute)
+	_, ok = filter(in)
+	assert.True(t, ok)
+}
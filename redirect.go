@@ -0,0 +1,175 @@
+package slurp
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// DefaultMaxRedirects is the number of 3xx responses followed for a single
+// request when no other limit is given
+const DefaultMaxRedirects = 5
+
+// redirectTarget is a Contact from a 3xx response, waiting to be tried
+type redirectTarget struct {
+	uri     string
+	q       float64
+	expires time.Time
+}
+
+// Redirector keeps track of the redirect targets of a single request,
+// so a UAC can retry the request to each of them in order.
+// Targets are tried by descending q-value, and targets that expired
+// before being tried are skipped, per their expires parameter or else the
+// Expires header of the response (RFC 3261 8.1.3.4).
+type Redirector struct {
+	MaxRedirects int
+	// Clock used to expire targets, DefaultClock when nil
+	Clock     Clock
+	redirects int
+	targets   []redirectTarget
+	// the URIs tried or waiting to be, compared per RFC 3261 19.1.4 so
+	// that a target written differently isn't tried twice
+	tried []string
+}
+
+// NewRedirector creates a Redirector for a request sent to the given Request-URI
+func NewRedirector(uri string, maxRedirects int) *Redirector {
+	return &Redirector{
+		MaxRedirects: maxRedirects,
+		tried:        []string{uri},
+	}
+}
+
+// wasTried reports whether uri is equivalent to one tried already
+func (r *Redirector) wasTried(uri string) bool {
+	for _, tried := range r.tried {
+		if EqualUris(tried, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRedirect reports whether the response is a redirect that can be followed
+func IsRedirect(response *Response) bool {
+	code := response.StatusCode()
+	return code >= 300 && code <= 302
+}
+
+// HandleResponse adds the Contacts of a redirect response as targets.
+// It fails when the redirect limit has been exceeded, or when the
+// response only contains targets that were already tried, as that
+// indicates a redirect loop.
+func (r *Redirector) HandleResponse(response *Response) error {
+	r.redirects++
+	if r.redirects > r.MaxRedirects {
+		return TooManyRedirectsError{Limit: r.MaxRedirects}
+	}
+	now := clockOrDefault(r.Clock).Now()
+	// the Expires header applies to the Contacts without a parameter
+	defaultExpires, hasExpires := expiresHeader(response)
+	added := 0
+	var seen []string
+	for _, contact := range response.Headers().Contacts {
+		uri := contact.Uri()
+		seen = append(seen, uri)
+		if r.wasTried(uri) {
+			continue
+		}
+		target := redirectTarget{uri: uri, q: ContactQ(contact)}
+		if param := contact.Param("expires"); param != "" {
+			if expires, err := strconv.Atoi(param); err == nil {
+				if expires == 0 {
+					continue
+				}
+				target.expires = now.Add(time.Duration(expires) * time.Second)
+			}
+		} else if hasExpires {
+			if defaultExpires == 0 {
+				continue
+			}
+			target.expires = now.Add(defaultExpires)
+		}
+		r.tried = append(r.tried, uri)
+		r.targets = append(r.targets, target)
+		added++
+	}
+	if added == 0 && len(seen) > 0 {
+		return RedirectLoopError{Targets: seen}
+	}
+	sort.SliceStable(r.targets, func(i, j int) bool {
+		return r.targets[i].q > r.targets[j].q
+	})
+	return nil
+}
+
+// Next returns the next target the request should be retried to,
+// and false when there are no targets left
+func (r *Redirector) Next() (string, bool) {
+	now := clockOrDefault(r.Clock).Now()
+	for len(r.targets) > 0 {
+		target := r.targets[0]
+		r.targets = r.targets[1:]
+		if !target.expires.IsZero() && now.After(target.expires) {
+			continue
+		}
+		return target.uri, true
+	}
+	return "", false
+}
+
+// followRedirects retries request, answered with the redirect response,
+// to its targets in turn until one answers other than with a failure, or
+// none are left. Each retry is a new transaction, with the Request-URI
+// set to the target and the CSeq incremented. The last response is
+// returned, with a TooManyRedirectsError or RedirectLoopError when the
+// redirects can't be followed any further
+func (ua *UserAgent) followRedirects(ctx context.Context, request Message, response *Response, maxRedirects int, hooks transactionHooks) (*Response, error) {
+	setter, ok := request.(interface{ SetUri(string) })
+	if !ok {
+		return response, nil
+	}
+	uri := request.Uri()
+	if uri == "" {
+		uri = request.Headers().To.Uri()
+	}
+	redirector := NewRedirector(uri, maxRedirects)
+	redirector.Clock = ua.Clock
+	if err := redirector.HandleResponse(response); err != nil {
+		return response, err
+	}
+	var err error
+	for {
+		target, ok := redirector.Next()
+		if !ok {
+			return response, err
+		}
+		setter.SetUri(target)
+		control := request.Control()
+		control.ViaBranch = NewBranch()
+		control.Sequence++
+		addr, hopErr := NextHop(request)
+		if hopErr != nil {
+			continue
+		}
+		if hooks.redirected != nil {
+			hooks.redirected(addr)
+		}
+		response, err = ua.attempt(ctx, addr, request, hooks)
+		switch {
+		case ctx.Err() != nil:
+			return response, err
+		case err == nil && IsRedirect(response):
+			if err = redirector.HandleResponse(response); err != nil {
+				return response, err
+			}
+		case isLost(err):
+		case err != nil || response.StatusCode() < 400:
+			return response, err
+		}
+	}
+}
@@ -3,44 +3,63 @@ package slurp
 import (
 	"fmt"
 	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
 )
 
 type Register struct {
 	headers CommonHeaders
 	control CallControlHeaders
+	raw     string
+	wire    *wireFormat
 	payload []byte
 	uri     string
 }
 
 func (r *Register) Render() string {
 	// REGISTER messages have a different URI structure, per RFC
-	r.uri = r.Headers().To.Uri()
+	if r.headers.To != nil {
+		r.uri = r.headers.To.Uri()
+	} else {
+		// the Request-URI as parsed, which the format adds the scheme to
+		r.uri = strings.TrimPrefix(r.uri, "sip:")
+	}
 	// @ and 'user-info' components should be stripped, leaving only the domain/host
 	r.uri = r.uri[strings.Index(r.uri, "@")+1:]
-	return fmt.Sprintf(
-		"REGISTER sip:%s SIP/2.0\r\n%s\r\n%s\r\n%s\r\n\r\n",
+	return r.wire.apply(fmt.Sprintf(
+		"REGISTER sip:%s SIP/2.0\r\n%s\r\n%s%s\r\n\r\n",
 		r.uri,
-		renderHeaders(r.headers, r.control),
+		renderRequestHeaders(r.headers, r.control),
 		// we set CSeq outside of renderHeaders because it's method-dependent
 		"CSeq: "+fmt.Sprintf("%d", r.control.Sequence)+" REGISTER",
-		"Supported: SUBSCRIBE, NOTIFY",
-	)
+		defaultSupported(r.headers),
+	)) + string(r.payload)
 }
 
 // Parse takes a string representation of a message and unmarshalls
 // the data into the appropriate struct fields.
 func (r *Register) Parse(message string) (err error) {
-	// split lines
-	lines := strings.Split(message, "\n")
+	// split the body off, then split lines
+	head, body := splitBody(message)
+	lines := strings.Split(head, "\n")
 	// ensure that the message is an Register message
 	// and the the protocol is SIP/2.0
 	err = validateMethod(lines[0], "REGISTER")
 	// In a Register, URI should immediately follow Register
-	// TODO when enough infrastructure exists to accomplish it, add support for checking for unsupported URI schemes and responding with 416
-	r.uri = strings.Split(lines[0], " ")[1]
+	r.uri = requestUri(lines[0])
 	r.headers = CommonHeaders{}
 	r.control = CallControlHeaders{}
-	parseHeaders(lines, &r.headers, &r.control)
+	if headerErr := parseHeaders(lines, &r.headers, &r.control); err == nil {
+		err = headerErr
+	}
+	if err == nil {
+		err = checkUriScheme(r.uri)
+	}
+	r.payload = body
+	r.raw = rawHeaderSection(head)
+	r.wire = nil
+	r.wire = preserveWire(head, r.Render)
+	countParse("REGISTER", err)
 	return
 }
 
@@ -52,10 +71,23 @@ func (r *Register) Method() string {
 	return "REGISTER"
 }
 
+// Validate checks the message for RFC violations, returning all of them
+func (r *Register) Validate() []Violation {
+	return validateMessage(r, true)
+}
+
 func (r *Register) Headers() *CommonHeaders {
 	return &r.headers
 }
 
+func (r *Register) RawHeaders() string {
+	return r.raw
+}
+
+func (r *Register) HeaderValue(name string) string {
+	return headerValue(r.raw, name)
+}
+
 func (r *Register) Control() *CallControlHeaders {
 	return &r.control
 }
@@ -70,4 +102,5 @@ func (r *Register) StringPayload() string {
 
 func (r *Register) SetPayload(data []byte) {
 	r.payload = data
+	r.headers.ContentLength = len(data)
 }
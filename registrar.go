@@ -0,0 +1,208 @@
+package slurp
+
+/*
+The Registrar processes REGISTER requests (RFC 3261 10.3), keeping the
+bindings of each address-of-record in a LocationStore. A REGISTER may add,
+refresh or remove several contacts at once, each with its own expiration,
+or remove every binding of the AOR with the wildcard Contact: * and
+Expires: 0.
+*/
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isWildcard reports whether the Contact is the wildcard, *
+func isWildcard(contact AddressHeader) bool {
+	return contact.Uri() == "" && strings.TrimSpace(contact.Value()) == "*"
+}
+
+// WildcardContact returns the Contact: * of a REGISTER removing every
+// binding of the AOR, which must be sent with Expires: 0
+func WildcardContact() AddressHeader {
+	return NewHeader(&Contact{}).SetValue("*")
+}
+
+// contactUri returns the URI of a Contact, which may have been given
+// without angle brackets
+func contactUri(contact AddressHeader) string {
+	if uri := contact.Uri(); uri != "" {
+		return uri
+	}
+	return strings.TrimSpace(contact.Value())
+}
+
+// Registrar answers REGISTER requests, storing the bindings in Locations
+// under the AddressOfRecord of the To
+type Registrar struct {
+	Locations LocationStore
+	// DefaultExpires is how long bindings last when the REGISTER doesn't
+	// say, an hour when zero. Longer requests are shortened to MaxExpires,
+	// when set, and shorter ones than MinExpires are answered with 423
+	DefaultExpires time.Duration
+	MinExpires     time.Duration
+	MaxExpires     time.Duration
+	// Clock used for expiration times, DefaultClock when nil
+	Clock Clock
+}
+
+// NewRegistrar creates a Registrar storing bindings in locations
+func NewRegistrar(locations LocationStore) *Registrar {
+	return &Registrar{Locations: locations}
+}
+
+// contactExpires returns how long the binding for contact should last:
+// its expires parameter, else the Expires header, else the default
+func (r *Registrar) contactExpires(register Message, contact AddressHeader) (time.Duration, bool) {
+	if value := contact.Param("expires"); value != "" {
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if expires, ok := expiresHeader(register); ok {
+		return expires, true
+	}
+	if r.DefaultExpires == 0 {
+		return time.Hour, true
+	}
+	return r.DefaultExpires, true
+}
+
+// outOfOrder returns the 400 for a REGISTER that isn't newer than the one
+// that last changed a binding of the same Call-ID
+func outOfOrder(register Message) *Response {
+	response := NewResponse(register, 400)
+	response.reason = "Out of Order"
+	return response
+}
+
+// Handle processes a REGISTER, returning the response to send, or nil for
+// any other request. The response lists the bindings of the AOR once the
+// contacts were added, refreshed or removed. A wildcard Contact along with
+// other contacts, or without Expires: 0, is answered with 400, as is a
+// REGISTER without a To, or older than the one that last changed a binding
+func (r *Registrar) Handle(register Message) *Response {
+	if register.Method() != "REGISTER" {
+		return nil
+	}
+	if to := register.Headers().To; to == nil || to.Uri() == "" {
+		// there is no address-of-record to bind
+		return NewResponse(register, 400)
+	}
+	aor := AddressOfRecord(register.Headers().To.Uri())
+	callId, sequence := register.Control().CallId, register.Control().Sequence
+	contacts := register.Headers().Contacts
+	wildcard := false
+	for _, contact := range contacts {
+		wildcard = wildcard || isWildcard(contact)
+	}
+	bindings, err := r.Locations.Bindings(aor)
+	if err != nil {
+		return NewResponse(register, 500)
+	}
+	stale := func(contact string) bool {
+		for _, binding := range bindings {
+			if (contact == "" || EqualUris(binding.Contact, contact)) && binding.CallId == callId && binding.Sequence >= sequence {
+				return true
+			}
+		}
+		return false
+	}
+
+	if wildcard {
+		if expires, ok := expiresHeader(register); len(contacts) != 1 || !ok || expires != 0 {
+			response := NewResponse(register, 400)
+			response.reason = "Invalid Wildcard"
+			return response
+		}
+		if stale("") {
+			return outOfOrder(register)
+		}
+		if err := r.Locations.RemoveAll(aor); err != nil {
+			return NewResponse(register, 500)
+		}
+		return r.bindings(register, aor)
+	}
+
+	// every contact is checked before any binding changes
+	durations := make([]time.Duration, len(contacts))
+	for i, contact := range contacts {
+		expires, ok := r.contactExpires(register, contact)
+		if !ok {
+			response := NewResponse(register, 400)
+			response.reason = "Invalid Expires"
+			return response
+		}
+		if expires > 0 && expires < r.MinExpires {
+			response := NewResponse(register, 423)
+			response.Headers().Extensions.Set("Min-Expires", strconv.Itoa(int(r.MinExpires/time.Second)))
+			return response
+		}
+		if r.MaxExpires > 0 && expires > r.MaxExpires {
+			expires = r.MaxExpires
+		}
+		if stale(contactUri(contact)) {
+			return outOfOrder(register)
+		}
+		durations[i] = expires
+	}
+	now := clockOrDefault(r.Clock).Now()
+	var path []string
+	if hasOptionTag(register, "Supported", "path") {
+		path = PathSet(register)
+	}
+	for i, contact := range contacts {
+		uri := contactUri(contact)
+		if durations[i] == 0 {
+			err = r.Locations.Remove(aor, uri)
+		} else {
+			err = r.Locations.Store(aor, Binding{
+				Contact:  uri,
+				Expires:  now.Add(durations[i]),
+				Q:        ContactQ(contact),
+				CallId:   callId,
+				Sequence: sequence,
+				Path:     path,
+			})
+		}
+		if err != nil {
+			return NewResponse(register, 500)
+		}
+	}
+	return r.bindings(register, aor)
+}
+
+// bindings returns the 200 listing the current bindings of aor, with the
+// time left on each
+func (r *Registrar) bindings(register Message, aor string) *Response {
+	bindings, err := r.Locations.Bindings(aor)
+	if err != nil {
+		return NewResponse(register, 500)
+	}
+	now := clockOrDefault(r.Clock).Now()
+	response := NewResponse(register, 200)
+	for _, binding := range bindings {
+		contact := NewHeader(&Contact{}).SetUri(binding.Contact)
+		contact.SetParam("expires", strconv.Itoa(int(binding.Expires.Sub(now).Round(time.Second)/time.Second)))
+		if binding.Q < 1 {
+			contact.SetParam("q", strconv.FormatFloat(binding.Q, 'f', -1, 64))
+		}
+		response.Headers().Contacts = append(response.Headers().Contacts, contact)
+	}
+	EchoPath(register, response)
+	return response
+}
+
+// Filter answers the REGISTERs Handle processes, for UserAgent.AddFilter
+func (r *Registrar) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if response := r.Handle(in.Message); response != nil {
+			return response, false
+		}
+		return nil, true
+	}
+}
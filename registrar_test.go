@@ -0,0 +1,97 @@
+package slurp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegister(sequence int, expires string, contacts ...AddressHeader) Message {
+	register, _ := NewRegister().To("sip:bob@biloxi.com").FromUri("sip:bob@biloxi.com").
+		CallId("843817637684230@998sdasdh09").Sequence(sequence).Build()
+	register.Headers().Contacts = contacts
+	if expires != "" {
+		register.Headers().Extensions.Set("Expires", expires)
+	}
+	return register
+}
+
+func TestRegistrar(t *testing.T) {
+	locations := NewMemoryLocations()
+	clock := NewFakeClock(time.Unix(0, 0))
+	locations.Clock = clock
+	registrar := NewRegistrar(locations)
+	registrar.Clock = clock
+	registrar.MinExpires = time.Minute
+
+	// several contacts, each with its own expiration
+	response := registrar.Handle(newTestRegister(1, "3600",
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4"),
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.5").SetParam("expires", "120").SetParam("q", "0.5")))
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Len(t, response.Headers().Contacts, 2)
+	bindings, _ := locations.Bindings("sip:bob@biloxi.com")
+	assert.Len(t, bindings, 2)
+	for _, binding := range bindings {
+		if binding.Contact == "sip:bob@192.0.2.5" {
+			assert.Equal(t, time.Unix(120, 0), binding.Expires)
+			assert.Equal(t, 0.5, binding.Q)
+		}
+	}
+
+	// a REGISTER without a To has no address-of-record
+	var received Register
+	assert.Nil(t, received.Parse("REGISTER sip:biloxi.com SIP/2.0\r\nCall-ID: 843817637684230@998sdasdh09\r\nCSeq: 2 REGISTER\r\n\r\n"))
+	assert.Equal(t, 400, registrar.Handle(&received).StatusCode())
+
+	// a q-value of 0 is kept as the lowest preference, and one that isn't
+	// valid is taken as the default
+	qs := NewMemoryLocations()
+	qs.Clock = clock
+	response = (&Registrar{Locations: qs, Clock: clock}).Handle(newTestRegister(1, "3600",
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.6").SetParam("q", "0"),
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.7").SetParam("q", "high"),
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.8")))
+	assert.Equal(t, 200, response.StatusCode())
+	qBindings, _ := qs.Bindings("sip:bob@biloxi.com")
+	for _, binding := range qBindings {
+		if binding.Contact == "sip:bob@192.0.2.6" {
+			assert.Equal(t, 0.0, binding.Q)
+		} else {
+			assert.Equal(t, 1.0, binding.Q, binding.Contact)
+		}
+	}
+	assert.Contains(t, response.Render(), "<sip:bob@192.0.2.6>;expires=3600;q=0\r\n")
+
+	response = registrar.Handle(newTestRegister(2, "30", NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4")))
+	assert.Equal(t, 423, response.StatusCode())
+	assert.Equal(t, "60", response.Headers().Extensions.Get("Min-Expires"))
+
+	// a REGISTER that isn't newer than the binding is refused
+	response = registrar.Handle(newTestRegister(1, "0", WildcardContact()))
+	assert.Equal(t, 400, response.StatusCode())
+	assert.Equal(t, "Out of Order", response.Reason())
+
+	// the wildcard stands alone, with Expires: 0
+	for _, register := range []Message{
+		newTestRegister(3, "0", WildcardContact(), NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4")),
+		newTestRegister(3, "3600", WildcardContact()),
+		newTestRegister(3, "", WildcardContact()),
+	} {
+		response = registrar.Handle(register)
+		assert.Equal(t, 400, response.StatusCode())
+		assert.Equal(t, "Invalid Wildcard", response.Reason())
+	}
+	bindings, _ = locations.Bindings("sip:bob@biloxi.com")
+	assert.Len(t, bindings, 2)
+
+	register := newTestRegister(3, "0", WildcardContact())
+	assert.Contains(t, register.Render(), "\r\nContact: *\r\n")
+	var parsed Register
+	assert.Nil(t, parsed.Parse(register.Render()))
+	response = registrar.Handle(&parsed)
+	assert.Equal(t, 200, response.StatusCode())
+	bindings, _ = locations.Bindings("sip:bob@biloxi.com")
+	assert.Empty(t, bindings)
+}
@@ -0,0 +1,151 @@
+package slurp
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// Request is a SIP request for any method that doesn't have a dedicated
+// type, e.g. ACK, BYE, CANCEL or OPTIONS
+type Request struct {
+	headers CommonHeaders
+	control CallControlHeaders
+	raw     string
+	wire    *wireFormat
+	payload []byte
+	method  string
+	uri     string
+}
+
+// NewRequest creates an empty request for the method, sent to uri
+func NewRequest(method, uri string) *Request {
+	return &Request{method: strings.ToUpper(method), uri: uri}
+}
+
+func (r *Request) Render() string {
+	return r.wire.apply(fmt.Sprintf(
+		"%s %s SIP/2.0\r\n%s\r\n%s\r\n\r\n",
+		r.method,
+		r.uri,
+		renderRequestHeaders(r.headers, r.control),
+		// we set CSeq outside of renderHeaders because it's method-dependent
+		"CSeq: "+fmt.Sprintf("%d", r.control.Sequence)+" "+r.method,
+	)) + string(r.payload)
+}
+
+// Parse takes a string representation of a message and unmarshalls
+// the data into the appropriate struct fields.
+func (r *Request) Parse(message string) (err error) {
+	head, body := splitBody(message)
+	lines := strings.Split(head, "\n")
+	fields := strings.Fields(lines[0])
+	if len(fields) != 3 {
+		return InvalidMessageFormatError(lines[0])
+	}
+	r.method = strings.ToUpper(fields[0])
+	err = validateMethod(lines[0], r.method)
+	r.uri = fields[1]
+	r.headers = CommonHeaders{}
+	r.control = CallControlHeaders{}
+	if headerErr := parseHeaders(lines, &r.headers, &r.control); err == nil {
+		err = headerErr
+	}
+	if err == nil {
+		err = checkUriScheme(r.uri)
+	}
+	r.payload = body
+	r.raw = rawHeaderSection(head)
+	r.wire = nil
+	r.wire = preserveWire(head, r.Render)
+	countParse(r.method, err)
+	return
+}
+
+func (r *Request) Uri() string {
+	return r.uri
+}
+
+// SetUri sets the Request-URI
+func (r *Request) SetUri(uri string) {
+	r.uri = uri
+}
+
+func (r *Request) Method() string {
+	return r.method
+}
+
+// Validate checks the message for RFC violations, returning all of them
+func (r *Request) Validate() []Violation {
+	return validateMessage(r, true)
+}
+
+func (r *Request) Headers() *CommonHeaders {
+	return &r.headers
+}
+
+func (r *Request) RawHeaders() string {
+	return r.raw
+}
+
+func (r *Request) HeaderValue(name string) string {
+	return headerValue(r.raw, name)
+}
+
+func (r *Request) Control() *CallControlHeaders {
+	return &r.control
+}
+
+func (r *Request) Payload() []byte {
+	return r.payload
+}
+
+func (r *Request) StringPayload() string {
+	return string(r.payload)
+}
+
+func (r *Request) SetPayload(data []byte) {
+	r.payload = data
+	r.headers.ContentLength = len(data)
+}
+
+// MaxMessageSize is the largest message ParseMessage accepts, in bytes
+var MaxMessageSize = 65535
+
+// ParseMessage parses any SIP message, returning the type matching its
+// start line: *Response, *Invite, *Register, or *Request for other methods.
+// The message comes from a pool, and may be given back with Release.
+// A request for an unsupported URI scheme is returned along with the
+// UnsupportedUriSchemeError, so that servers can answer it with 416. A
+// message larger than MaxMessageSize fails with a MessageTooLargeError
+// before any of it is parsed
+func ParseMessage(message string) (Message, error) {
+	if len(message) > MaxMessageSize {
+		return nil, MessageTooLargeError{Size: len(message), Limit: MaxMessageSize}
+	}
+	line := strings.SplitN(message, "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, InvalidMessageFormatError(line)
+	}
+	var m Message
+	switch {
+	case strings.HasPrefix(fields[0], "SIP/"):
+		m = responsePool.Get().(*Response)
+	case strings.EqualFold(fields[0], "INVITE"):
+		m = invitePool.Get().(*Invite)
+	case strings.EqualFold(fields[0], "REGISTER"):
+		m = registerPool.Get().(*Register)
+	default:
+		m = requestPool.Get().(*Request)
+	}
+	if err := m.Parse(message); err != nil {
+		if _, ok := err.(UnsupportedUriSchemeError); ok {
+			return m, err
+		}
+		Release(m)
+		return nil, err
+	}
+	return m, nil
+}
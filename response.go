@@ -0,0 +1,227 @@
+package slurp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// ErrorInfoHook, when set, is called by NewResponse for every 4xx and 5xx
+// response it builds. Any headers it returns are attached as Error-Info,
+// e.g. to point the caller at an announcement explaining the failure
+var ErrorInfoHook func(request Message, code int) []AddressHeader
+
+// ResponseClass is the class of a response code, given by its first digit
+type ResponseClass int
+
+const (
+	UnknownClass  ResponseClass = iota
+	Provisional                 // 1xx
+	Success                     // 2xx
+	Redirection                 // 3xx
+	ClientError                 // 4xx
+	ServerError                 // 5xx
+	GlobalFailure               // 6xx
+)
+
+// ClassOf returns the class of a response code
+func ClassOf(code int) ResponseClass {
+	if code < 100 || code > 699 {
+		return UnknownClass
+	}
+	return ResponseClass(code / 100)
+}
+
+// ReasonPhrase returns the default reason phrase for a response code.
+// Unknown codes get a generic phrase for their class, as the RFC
+// requires them to be treated as the x00 code of their class
+func ReasonPhrase(code int) string {
+	if reason, ok := SupportedResponses[code]; ok {
+		return reason
+	}
+	if reason, ok := SupportedResponses[code/100*100]; ok {
+		return reason
+	}
+	return "Unknown"
+}
+
+// IsProvisional reports whether the code is a 1xx response
+func IsProvisional(code int) bool {
+	return ClassOf(code) == Provisional
+}
+
+// IsSuccess reports whether the code is a 2xx response
+func IsSuccess(code int) bool {
+	return ClassOf(code) == Success
+}
+
+// IsFinal reports whether the code is a final (2xx-6xx) response
+func IsFinal(code int) bool {
+	return code >= 200 && code <= 699
+}
+
+// IsFailure reports whether the code is a 4xx, 5xx or 6xx response
+func IsFailure(code int) bool {
+	return code >= 400 && code <= 699
+}
+
+// Response is a SIP response to a request
+type Response struct {
+	headers CommonHeaders
+	control CallControlHeaders
+	raw     string
+	wire    *wireFormat
+	payload []byte
+	code    int
+	reason  string
+}
+
+// NewResponse builds a response to the given request, copying the headers
+// the RFC requires to be echoed back (Via, From, To, Call-ID, CSeq, Timestamp).
+// A To tag is generated if the request did not include one, except for 100 Trying.
+func NewResponse(request Message, code int) *Response {
+	r := &Response{
+		code:   code,
+		reason: ReasonPhrase(code),
+	}
+	requestHeaders := request.Headers()
+	requestControl := request.Control()
+	// a malformed request may lack From or To, and still needs an answer
+	r.headers.From = NewHeader(&ToFrom{})
+	if from := requestHeaders.From; from != nil {
+		r.headers.From.SetValue(from.Value()).SetUri(from.Uri()).SetParam("tag", from.Param("tag"))
+	}
+	r.headers.To = NewHeader(&ToFrom{})
+	if to := requestHeaders.To; to != nil {
+		r.headers.To.SetValue(to.Value()).SetUri(to.Uri()).SetParam("tag", to.Param("tag"))
+	}
+	if r.headers.To.Param("tag") == "" && code != 100 {
+		r.headers.To.SetParam("tag", generateTag())
+	}
+	r.control.Via = append([][2]string{}, requestControl.Via...)
+	r.control.ViaParams = append([]string{}, requestControl.ViaParams...)
+	r.control.ViaBranch = requestControl.ViaBranch
+	r.control.CallId = requestControl.CallId
+	r.control.Sequence = requestControl.Sequence
+	r.control.CSeqMethod = request.Method()
+	r.control.Timestamp = requestControl.Timestamp
+	if code >= 400 && code < 600 && ErrorInfoHook != nil {
+		r.headers.ErrorInfo = ErrorInfoHook(request, code)
+	}
+	return r
+}
+
+func (r *Response) Render() string {
+	headers := r.headers
+	if len(headers.Contacts) == 0 && headers.To != nil {
+		// renderHeaders defaults Contact to From, which is the remote party for a response
+		headers.Contacts = []AddressHeader{
+			NewHeader(&Contact{}).SetUri(headers.To.Uri()).SetValue(headers.To.Value()),
+		}
+	}
+	return r.wire.apply(fmt.Sprintf(
+		"SIP/2.0 %d %s\r\n%s\r\n%s\r\n\r\n",
+		r.code,
+		r.reason,
+		renderHeaders(headers, r.control),
+		"CSeq: "+fmt.Sprintf("%d", r.control.Sequence)+" "+r.control.CSeqMethod,
+	)) + string(r.payload)
+}
+
+// Parse takes a string representation of a response and unmarshalls
+// the data into the appropriate struct fields.
+func (r *Response) Parse(message string) (err error) {
+	defer func() { countParse(r.control.CSeqMethod, err) }()
+	head, body := splitBody(message)
+	lines := strings.Split(head, "\n")
+	// the status line is in the form SIP/2.0 CODE REASON
+	status := strings.SplitN(strings.TrimSpace(lines[0]), " ", 3)
+	if len(status) < 2 || !strings.HasPrefix(status[0], "SIP/") {
+		return InvalidMessageFormatError(lines[0])
+	}
+	if status[0] != "SIP/2.0" {
+		version, parseErr := strconv.ParseFloat(strings.TrimPrefix(status[0], "SIP/"), 32)
+		if parseErr != nil {
+			return InvalidMessageFormatError(lines[0])
+		}
+		return UnsupportedSipVersionError{Version: float32(version)}
+	}
+	code, parseErr := strconv.Atoi(status[1])
+	if parseErr != nil {
+		return InvalidMessageFormatError(lines[0])
+	}
+	r.code = code
+	r.reason = ""
+	if len(status) > 2 {
+		r.reason = status[2]
+	}
+	r.headers = CommonHeaders{}
+	r.control = CallControlHeaders{}
+	r.payload = body
+	r.raw = rawHeaderSection(head)
+	err = parseHeaders(lines, &r.headers, &r.control)
+	r.wire = nil
+	r.wire = preserveWire(head, r.Render)
+	return
+}
+
+// StatusCode returns the numeric code of the response, e.g. 200
+func (r *Response) StatusCode() int {
+	return r.code
+}
+
+// Reason returns the reason phrase of the response, e.g. OK
+func (r *Response) Reason() string {
+	return r.reason
+}
+
+// Class returns the class of the response's status code
+func (r *Response) Class() ResponseClass {
+	return ClassOf(r.code)
+}
+
+// Method returns the method of the request this response answers
+func (r *Response) Method() string {
+	return r.control.CSeqMethod
+}
+
+// Responses have no Request-URI
+func (r *Response) Uri() string {
+	return ""
+}
+
+// Validate checks the message for RFC violations, returning all of them
+func (r *Response) Validate() []Violation {
+	return validateMessage(r, false)
+}
+
+func (r *Response) Headers() *CommonHeaders {
+	return &r.headers
+}
+
+func (r *Response) RawHeaders() string {
+	return r.raw
+}
+
+func (r *Response) HeaderValue(name string) string {
+	return headerValue(r.raw, name)
+}
+
+func (r *Response) Control() *CallControlHeaders {
+	return &r.control
+}
+
+func (r *Response) Payload() []byte {
+	return r.payload
+}
+
+func (r *Response) StringPayload() string {
+	return string(r.payload)
+}
+
+func (r *Response) SetPayload(data []byte) {
+	r.payload = data
+	r.headers.ContentLength = len(data)
+}
@@ -0,0 +1,126 @@
+package slurp
+
+/*
+A stateless server keeps no transactions, so a retransmitted request would
+reach the application again. The ResponseCache absorbs retransmissions
+instead, answering them with the response last sent for the transaction.
+*/
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultCacheLifetime is how long a response is kept, the 64*T1 a client
+// transaction may retransmit for
+const DefaultCacheLifetime = 64 * T1
+
+type cachedResponse struct {
+	key      string
+	response *Response
+	stored   time.Time
+}
+
+// ResponseCache remembers the last response sent in each transaction, by
+// its ServerTransactionKey, so retransmitted requests get it again without
+// reaching the application. The least recently used response is evicted
+// once Size are cached. It is safe for concurrent use.
+type ResponseCache struct {
+	// Size is the most responses kept
+	Size int
+	// Lifetime is how long a response is kept, DefaultCacheLifetime when zero
+	Lifetime time.Duration
+	// Clock used to expire responses, DefaultClock when nil
+	Clock   Clock
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewResponseCache creates a ResponseCache keeping up to size responses
+func NewResponseCache(size int) *ResponseCache {
+	return &ResponseCache{
+		Size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *ResponseCache) lifetime() time.Duration {
+	if c.Lifetime == 0 {
+		return DefaultCacheLifetime
+	}
+	return c.Lifetime
+}
+
+// Store remembers response as the last sent for its transaction. A
+// provisional response doesn't replace a final one
+func (c *ResponseCache) Store(response *Response) {
+	key := ServerTransactionKey(response)
+	now := clockOrDefault(c.Clock).Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cachedResponse)
+		if !IsFinal(response.StatusCode()) && IsFinal(entry.response.StatusCode()) {
+			return
+		}
+		entry.response, entry.stored = Clone(response).(*Response), now
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedResponse{key: key, response: Clone(response).(*Response), stored: now})
+	for c.order.Len() > c.Size {
+		c.evict(c.order.Back())
+	}
+}
+
+// Lookup returns the response last sent in the request's transaction, if any
+func (c *ResponseCache) Lookup(request Message) (*Response, bool) {
+	key := ServerTransactionKey(request)
+	now := clockOrDefault(c.Clock).Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cachedResponse)
+	if now.Sub(entry.stored) > c.lifetime() {
+		c.evict(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.response, true
+}
+
+// evict removes an entry, the lock must be held
+func (c *ResponseCache) evict(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cachedResponse).key)
+}
+
+// Interceptor stores every response the UserAgent sends
+func (c *ResponseCache) Interceptor() Interceptor {
+	return func(m Message, direction Direction) (Message, error) {
+		if response, ok := m.(*Response); ok && direction == Outbound {
+			c.Store(response)
+		}
+		return m, nil
+	}
+}
+
+// Filter answers retransmitted requests with their cached response. An
+// ACK for a cached failure response ends at the transaction it belongs to
+func (c *ResponseCache) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		if response, ok := c.Lookup(in.Message); ok {
+			if in.Message.Method() == "ACK" {
+				return nil, false
+			}
+			return response, false
+		}
+		return nil, true
+	}
+}
@@ -0,0 +1,98 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	if err != nil {
+		t.Skip("example missing")
+	}
+	invite := &Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+	clock := NewFakeClock(time.Unix(0, 0))
+	cache := NewResponseCache(2)
+	cache.Clock = clock
+	intercept, filter := cache.Interceptor(), cache.Filter()
+
+	_, ok := filter(Incoming{Message: invite})
+	assert.True(t, ok)
+	intercept(NewResponse(invite, 180), Outbound)
+	response, ok := filter(Incoming{Message: invite})
+	assert.False(t, ok)
+	assert.Equal(t, 180, response.StatusCode())
+
+	// the final response replaces the provisional one, and stays
+	intercept(NewResponse(invite, 486), Outbound)
+	intercept(NewResponse(invite, 180), Outbound)
+	response, _ = cache.Lookup(invite)
+	assert.Equal(t, 486, response.StatusCode())
+
+	// received responses aren't cached
+	other := Clone(invite)
+	other.Control().ViaBranch = "z9hG4bKother"
+	intercept(NewResponse(other, 200), Inbound)
+	_, ok = cache.Lookup(other)
+	assert.False(t, ok)
+
+	// the least recently used response is evicted
+	third := Clone(invite)
+	third.Control().ViaBranch = "z9hG4bKthird"
+	cache.Store(NewResponse(other, 200))
+	cache.Lookup(invite)
+	cache.Store(NewResponse(third, 200))
+	_, ok = cache.Lookup(other)
+	assert.False(t, ok)
+	_, ok = cache.Lookup(invite)
+	assert.True(t, ok)
+
+	clock.Advance(DefaultCacheLifetime + time.Second)
+	_, ok = cache.Lookup(invite)
+	assert.False(t, ok)
+}
+
+func TestServerTransactionKey(t *testing.T) {
+	data, err := ioutil.ReadFile("examples/invite.sip")
+	if err != nil {
+		t.Skip("example missing")
+	}
+	invite := &Invite{}
+	assert.Nil(t, invite.Parse(string(data)))
+	response := NewResponse(invite, 486)
+	response.Headers().To.SetParam("tag", "314")
+	ack := newFailureAck(invite, response)
+	assert.Equal(t, ServerTransactionKey(invite), ServerTransactionKey(response))
+	assert.Equal(t, ServerTransactionKey(invite), ServerTransactionKey(ack))
+	assert.Equal(t, ServerTransactionKey(invite), CancelledTransactionKey(NewCancel(invite)))
+	assert.NotEqual(t, ServerTransactionKey(invite), ServerTransactionKey(NewCancel(invite)))
+
+	// the same branch from another sender is another transaction
+	other := Clone(invite)
+	other.Control().Via[0][1] = "pc34.atlanta.com"
+	assert.NotEqual(t, ServerTransactionKey(invite), ServerTransactionKey(other))
+
+	// RFC 2543 branches aren't unique, so the CSeq tells transactions apart
+	legacy := Clone(invite)
+	legacy.Control().ViaBranch = "0"
+	assert.Equal(t, ServerTransactionKey(legacy), ServerTransactionKey(newFailureAck(legacy, response)))
+	next := Clone(legacy)
+	next.Control().Sequence++
+	assert.NotEqual(t, ServerTransactionKey(legacy), ServerTransactionKey(next))
+	assert.NotEqual(t, ServerTransactionKey(legacy), ServerTransactionKey(invite))
+
+	// the failure response of a legacy transaction absorbs its ACK
+	cache := NewResponseCache(10)
+	cache.Store(NewResponse(legacy, 486))
+	filter := cache.Filter()
+	cached, ok := filter(Incoming{Message: legacy})
+	assert.False(t, ok)
+	assert.Equal(t, 486, cached.StatusCode())
+	cached, ok = filter(Incoming{Message: newFailureAck(legacy, response)})
+	assert.False(t, ok)
+	assert.Nil(t, cached)
+}
@@ -0,0 +1,347 @@
+package slurp
+
+/*
+Carriers each have their quirks: one wants a header another rejects, one
+sends numbers without the country code. Rewrite rules fix them up as
+messages pass through the interceptor chain, and are loaded from the
+configuration, so that a new carrier doesn't need a new build:
+
+	rewrite:
+	  - name: carrier-x
+	    match:
+	      direction: outbound
+	      methods: [INVITE]
+	      uri: '@carrier-x\.example\.com'
+	    actions:
+	      - {op: remove, header: P-Preferred-Identity}
+	      - {op: add, header: X-Carrier-Account, value: "1234"}
+	      - {op: replace, uri: request, part: user, pattern: '^0', value: '+44'}
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The operations of a RewriteAction
+const (
+	RewriteAdd     = "add"
+	RewriteSet     = "set"
+	RewriteRemove  = "remove"
+	RewriteReplace = "replace"
+)
+
+// RewriteMatch selects the messages a rule applies to. Every condition
+// set must hold; a zero RewriteMatch matches every message
+type RewriteMatch struct {
+	// Direction is inbound or outbound, either when empty
+	Direction string `json:"direction,omitempty" yaml:"direction,omitempty"`
+	// Message is request or response, either when empty
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// Methods are those of requests, and of the requests responses
+	// answer, any when empty
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Header must be present, and its value match Pattern when set
+	Header  string `json:"header,omitempty" yaml:"header,omitempty"`
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Uri must match the Request-URI, which responses don't have
+	Uri string `json:"uri,omitempty" yaml:"uri,omitempty"`
+}
+
+// RewriteAction changes a header, or a component of a URI. Header actions
+// apply to the headers slurp doesn't model, e.g. User-Agent or
+// P-Asserted-Identity, not to From, To, Contact, Via, CSeq or Call-ID
+type RewriteAction struct {
+	// Op is add, set, remove or replace. A header is added, or set,
+	// replacing every header with its name, to Value, and replace
+	// substitutes Value for the matches of Pattern, which may refer to
+	// its groups as $1. URIs can only be set or replaced
+	Op     string `json:"op" yaml:"op"`
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	// Uri is request, from or to, when the action rewrites a URI rather
+	// than a header. Part is the user or host of the URI, the whole URI
+	// when empty
+	Uri     string `json:"uri,omitempty" yaml:"uri,omitempty"`
+	Part    string `json:"part,omitempty" yaml:"part,omitempty"`
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Value   string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// RewriteRule applies its actions, in order, to the messages it matches
+type RewriteRule struct {
+	Name    string          `json:"name" yaml:"name"`
+	Match   RewriteMatch    `json:"match" yaml:"match"`
+	Actions []RewriteAction `json:"actions" yaml:"actions"`
+	// Last stops the rules after this one from being applied to the
+	// messages it matched
+	Last bool `json:"last,omitempty" yaml:"last,omitempty"`
+}
+
+// compiledRule is a rule with its patterns compiled
+type compiledRule struct {
+	RewriteRule
+	header  *regexp.Regexp
+	uri     *regexp.Regexp
+	actions []*regexp.Regexp
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// compile checks a rule and compiles its patterns
+func (r RewriteRule) compile() (*compiledRule, error) {
+	fail := func(format string, args ...interface{}) (*compiledRule, error) {
+		return nil, fmt.Errorf("rewrite rule %q: "+format, append([]interface{}{r.Name}, args...)...)
+	}
+	c := &compiledRule{RewriteRule: r}
+	switch strings.ToLower(r.Match.Direction) {
+	case "", "inbound", "outbound":
+	default:
+		return fail("direction must be inbound or outbound, not %q", r.Match.Direction)
+	}
+	switch strings.ToLower(r.Match.Message) {
+	case "", "request", "response":
+	default:
+		return fail("message must be request or response, not %q", r.Match.Message)
+	}
+	var err error
+	if c.header, err = compilePattern(r.Match.Pattern); err != nil {
+		return fail("%v", err)
+	}
+	if c.header != nil && r.Match.Header == "" {
+		return fail("a pattern needs a header to match")
+	}
+	if c.uri, err = compilePattern(r.Match.Uri); err != nil {
+		return fail("%v", err)
+	}
+	for i, action := range r.Actions {
+		pattern, err := compilePattern(action.Pattern)
+		if err != nil {
+			return fail("action %d: %v", i+1, err)
+		}
+		op := strings.ToLower(action.Op)
+		switch {
+		case op != RewriteAdd && op != RewriteSet && op != RewriteRemove && op != RewriteReplace:
+			return fail("action %d: unknown op %q", i+1, action.Op)
+		case (action.Header == "") == (action.Uri == ""):
+			return fail("action %d: needs either a header or a uri", i+1)
+		case action.Uri != "" && op != RewriteSet && op != RewriteReplace:
+			return fail("action %d: a uri can only be set or replaced", i+1)
+		case op == RewriteReplace && pattern == nil:
+			return fail("action %d: replace needs a pattern", i+1)
+		}
+		switch strings.ToLower(action.Uri) {
+		case "", "request", "from", "to":
+		default:
+			return fail("action %d: uri must be request, from or to, not %q", i+1, action.Uri)
+		}
+		switch strings.ToLower(action.Part) {
+		case "", "user", "host":
+		default:
+			return fail("action %d: part must be user or host, not %q", i+1, action.Part)
+		}
+		c.actions = append(c.actions, pattern)
+	}
+	return c, nil
+}
+
+// rewriteHeaderValue returns the value of a header the message was built or
+// received with, ok being false when it has none
+func rewriteHeaderValue(m Message, name string) (string, bool) {
+	if value := m.Headers().Extensions.Get(name); value != "" {
+		return value, true
+	}
+	value := m.HeaderValue(name)
+	return value, value != ""
+}
+
+// matches reports whether the rule applies to m
+func (c *compiledRule) matches(m Message, direction Direction) bool {
+	_, response := m.(*Response)
+	match := c.Match
+	switch {
+	case strings.EqualFold(match.Direction, "inbound") && direction != Inbound,
+		strings.EqualFold(match.Direction, "outbound") && direction != Outbound,
+		strings.EqualFold(match.Message, "request") && response,
+		strings.EqualFold(match.Message, "response") && !response:
+		return false
+	}
+	if len(match.Methods) > 0 {
+		found := false
+		for _, method := range match.Methods {
+			found = found || strings.EqualFold(method, m.Method())
+		}
+		if !found {
+			return false
+		}
+	}
+	if match.Header != "" {
+		value, ok := rewriteHeaderValue(m, match.Header)
+		if !ok || (c.header != nil && !c.header.MatchString(value)) {
+			return false
+		}
+	}
+	return c.uri == nil || (!response && c.uri.MatchString(m.Uri()))
+}
+
+// uriPart returns the bounds of the user or host of a URI, or of all of
+// it. ok is false when it has no such part
+func uriPart(uri, part string) (start, end int, ok bool) {
+	if part == "" {
+		return 0, len(uri), true
+	}
+	colon := strings.Index(uri, ":")
+	if colon < 0 {
+		return 0, 0, false
+	}
+	start = colon + 1
+	at := strings.LastIndex(uri, "@")
+	if strings.EqualFold(part, "user") {
+		if at < start {
+			if strings.EqualFold(uri[:colon], "tel") {
+				// the number of a tel URI, before its parameters
+				if semicolon := strings.Index(uri, ";"); semicolon >= 0 {
+					return start, semicolon, true
+				}
+				return start, len(uri), true
+			}
+			return 0, 0, false
+		}
+		end = at
+		if password := strings.Index(uri[start:at], ":"); password >= 0 {
+			end = start + password
+		}
+		return start, end, true
+	}
+	if at >= start {
+		start = at + 1
+	}
+	end = len(uri)
+	if strings.HasPrefix(uri[start:], "[") {
+		if bracket := strings.Index(uri[start:], "]"); bracket >= 0 {
+			return start, start + bracket + 1, true
+		}
+	}
+	if stop := strings.IndexAny(uri[start:], ":;?>"); stop >= 0 {
+		end = start + stop
+	}
+	return start, end, true
+}
+
+// rewriteUri applies a set or replace action to a URI
+func rewriteUri(uri string, action RewriteAction, pattern *regexp.Regexp) string {
+	start, end, ok := uriPart(uri, action.Part)
+	if !ok {
+		return uri
+	}
+	value := action.Value
+	if pattern != nil {
+		value = pattern.ReplaceAllString(uri[start:end], action.Value)
+	}
+	return uri[:start] + value + uri[end:]
+}
+
+// apply runs the rule's actions on m
+func (c *compiledRule) apply(m Message) {
+	headers := m.Headers()
+	for i, action := range c.Actions {
+		pattern := c.actions[i]
+		op := strings.ToLower(action.Op)
+		if action.Uri != "" {
+			switch strings.ToLower(action.Uri) {
+			case "request":
+				if setter, ok := m.(interface{ SetUri(string) }); ok && m.Uri() != "" {
+					setter.SetUri(rewriteUri(m.Uri(), action, pattern))
+				}
+			case "from":
+				if headers.From != nil {
+					headers.From.SetUri(rewriteUri(headers.From.Uri(), action, pattern))
+				}
+			case "to":
+				if headers.To != nil {
+					headers.To.SetUri(rewriteUri(headers.To.Uri(), action, pattern))
+				}
+			}
+			continue
+		}
+		switch op {
+		case RewriteAdd:
+			headers.Extensions.Add(action.Header, action.Value)
+		case RewriteSet:
+			headers.Extensions.Set(action.Header, action.Value)
+		case RewriteRemove:
+			headers.Extensions.Remove(action.Header)
+		case RewriteReplace:
+			if value, ok := rewriteHeaderValue(m, action.Header); ok {
+				headers.Extensions.Set(action.Header, pattern.ReplaceAllString(value, action.Value))
+			}
+		}
+	}
+}
+
+// Rewriter applies rewrite rules to the messages passing through a
+// UserAgent. Its rules can be replaced while it runs, e.g. when the
+// configuration is reloaded. It is safe for concurrent use
+type Rewriter struct {
+	mu    sync.RWMutex
+	rules []*compiledRule
+}
+
+// NewRewriter creates a Rewriter applying rules, failing when one is
+// invalid
+func NewRewriter(rules []RewriteRule) (*Rewriter, error) {
+	r := &Rewriter{}
+	return r, r.SetRules(rules)
+}
+
+// SetRules replaces the rules applied. When one is invalid, the rules
+// are left as they were
+func (r *Rewriter) SetRules(rules []RewriteRule) error {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := rule.compile()
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, c)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = compiled
+	return nil
+}
+
+// Rewrite applies the rules matching m, in order, returning the names of
+// those applied
+func (r *Rewriter) Rewrite(m Message, direction Direction) []string {
+	r.mu.RLock()
+	rules := r.rules
+	r.mu.RUnlock()
+	var applied []string
+	for _, rule := range rules {
+		if !rule.matches(m, direction) {
+			continue
+		}
+		rule.apply(m)
+		applied = append(applied, rule.Name)
+		if rule.Last {
+			break
+		}
+	}
+	return applied
+}
+
+// Interceptor returns an Interceptor applying the rules to every message
+// sent and received
+func (r *Rewriter) Interceptor() Interceptor {
+	return func(m Message, direction Direction) (Message, error) {
+		r.Rewrite(m, direction)
+		return m, nil
+	}
+}
@@ -0,0 +1,78 @@
+package slurp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stack.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+rewrite:
+  - name: carrier-x
+    match:
+      direction: outbound
+      methods: [INVITE]
+      uri: '@carrier-x\.example\.com'
+    actions:
+      - {op: remove, header: P-Preferred-Identity}
+      - {op: add, header: X-Carrier-Account, value: "1234"}
+      - {op: replace, uri: request, part: user, pattern: '^0', value: '+44'}
+      - {op: set, uri: from, part: host, value: trunk.example.com}
+    last: true
+  - name: user-agent
+    match:
+      header: User-Agent
+      pattern: '^softphone/(\d+)'
+    actions:
+      - {op: replace, header: User-Agent, pattern: '^softphone/(\d+).*', value: 'phone-$1'}
+`), 0o644))
+	config, err := LoadConfig(path)
+	assert.Nil(t, err)
+	rewriter, err := NewRewriter(config.Rewrite)
+	assert.Nil(t, err)
+
+	build := func(uri string) Message {
+		invite, _ := NewInvite().To(uri).FromUri("sip:alice@atlanta.com").
+			Header("P-Preferred-Identity", "<sip:alice@atlanta.com>").
+			Header("User-Agent", "softphone/2.1 (linux)").
+			Build()
+		return invite
+	}
+	invite := build("sip:02079460000@carrier-x.example.com;user=phone")
+	assert.Equal(t, []string{"carrier-x"}, rewriter.Rewrite(invite, Outbound))
+	assert.Equal(t, "sip:+442079460000@carrier-x.example.com;user=phone", invite.Uri())
+	assert.Equal(t, "sip:alice@trunk.example.com", invite.Headers().From.Uri())
+	assert.Equal(t, "", invite.Headers().Extensions.Get("P-Preferred-Identity"))
+	assert.Equal(t, "1234", invite.Headers().Extensions.Get("X-Carrier-Account"))
+	assert.Equal(t, "softphone/2.1 (linux)", invite.Headers().Extensions.Get("User-Agent"))
+
+	// the first rule only applies to outbound requests for the carrier
+	invite = build("sip:02079460000@carrier-x.example.com")
+	assert.Equal(t, []string{"user-agent"}, rewriter.Rewrite(invite, Inbound))
+	assert.Equal(t, "phone-2", invite.Headers().Extensions.Get("User-Agent"))
+	assert.Equal(t, "sip:02079460000@carrier-x.example.com", invite.Uri())
+	_, err = rewriter.Interceptor()(NewResponse(invite, 200), Outbound)
+	assert.Nil(t, err)
+
+	// invalid rules are refused, keeping those in use
+	for _, rule := range []RewriteRule{
+		{Name: "op", Actions: []RewriteAction{{Op: "rename", Header: "X-A"}}},
+		{Name: "uri", Actions: []RewriteAction{{Op: RewriteAdd, Uri: "request"}}},
+		{Name: "pattern", Actions: []RewriteAction{{Op: RewriteReplace, Header: "X-A", Pattern: "("}}},
+		{Name: "direction", Match: RewriteMatch{Direction: "sideways"}},
+	} {
+		assert.NotNil(t, rewriter.SetRules([]RewriteRule{rule}), rule.Name)
+	}
+	invite = build("sip:02079460000@carrier-x.example.com")
+	assert.Equal(t, []string{"carrier-x"}, rewriter.Rewrite(invite, Outbound))
+
+	start, end, ok := uriPart("tel:+15551234567;phone-context=example.com", "user")
+	assert.True(t, ok)
+	assert.Equal(t, "+15551234567", "tel:+15551234567;phone-context=example.com"[start:end])
+	start, end, _ = uriPart("sips:bob:secret@[2001:db8::1]:5061", "host")
+	assert.Equal(t, "[2001:db8::1]", "sips:bob:secret@[2001:db8::1]:5061"[start:end])
+}
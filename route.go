@@ -0,0 +1,146 @@
+package slurp
+
+/*
+Routing sends a request through the proxies listed in its Route headers.
+A pre-loaded route set, e.g. an outbound proxy, is added to initial
+requests, and the next hop is the topmost Route when there is one, or the
+Request-URI otherwise (RFC 3261 8.1.2 and 12.2.1.1).
+*/
+
+import (
+	"net"
+	"strings"
+)
+
+// RouteSet returns the Route header values of the message, topmost first
+func RouteSet(m Message) []string {
+	return m.Headers().Extensions.GetAll("Route")
+}
+
+// SetRouteSet replaces the Route headers of the message by routes
+func SetRouteSet(m Message, routes []string) {
+	setHeaderValues(m, "Route", routes)
+}
+
+// addrSpec returns the URI of a name-addr, e.g. <sip:p1.example.com;lr>,
+// with its parameters. A bare URI is returned as is
+func addrSpec(value string) string {
+	value = strings.TrimSpace(value)
+	if start := strings.Index(value, "<"); start >= 0 {
+		value = value[start+1:]
+		if end := strings.Index(value, ">"); end >= 0 {
+			value = value[:end]
+		}
+	}
+	return value
+}
+
+// uriParam looks up a parameter of a URI, reporting whether it is present
+func uriParam(uri, name string) (string, bool) {
+	uri = strings.SplitN(addrSpec(uri), "?", 2)[0]
+	if semicolon := strings.Index(uri, ";"); semicolon >= 0 {
+		return viaParam(uri[semicolon+1:], name)
+	}
+	return "", false
+}
+
+// isLooseRoute reports whether a Route belongs to a loose router, which
+// marks its URI with the lr parameter
+func isLooseRoute(route string) bool {
+	_, ok := uriParam(route, "lr")
+	return ok
+}
+
+// uriHostPort returns the host:port a SIP URI points to. The port
+// defaults to 5061 for sips and TLS, and 5060 otherwise
+func uriHostPort(uri string) (string, error) {
+	spec := addrSpec(uri)
+	if UriScheme(spec) == "urn" {
+		// service URNs are resolved by the proxies in the route set
+		return "", &net.AddrError{Err: "no host in URN", Addr: uri}
+	}
+	port := "5060"
+	transport, _ := uriParam(spec, "transport")
+	if strings.HasPrefix(strings.ToLower(spec), "sips:") || strings.EqualFold(transport, "tls") {
+		port = "5061"
+	}
+	if scheme := UriScheme(spec); scheme == "sip" || scheme == "sips" {
+		spec = spec[len(scheme)+1:]
+	}
+	spec = strings.SplitN(strings.SplitN(spec, ";", 2)[0], "?", 2)[0]
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		spec = spec[at+1:]
+	}
+	if spec == "" {
+		return "", &net.AddrError{Err: "missing host", Addr: uri}
+	}
+	if host, p, err := net.SplitHostPort(spec); err == nil {
+		return net.JoinHostPort(host, p), nil
+	}
+	return net.JoinHostPort(strings.Trim(spec, "[]"), port), nil
+}
+
+// NextHop returns the address (host:port) the request should be sent to:
+// the topmost Route when there is one, and the Request-URI otherwise
+func NextHop(request Message) (string, error) {
+	if routes := RouteSet(request); len(routes) > 0 {
+		return uriHostPort(routes[0])
+	}
+	return uriHostPort(request.Uri())
+}
+
+// preloadRoutes adds the route set to an initial request without Route
+// headers. When the first hop is a strict router, the request is rewritten
+// for it as RFC 3261 12.2.1.1 requires
+func preloadRoutes(request Message, routes []string) {
+	if len(routes) == 0 || len(RouteSet(request)) > 0 {
+		return
+	}
+	if to := request.Headers().To; to != nil && to.Param("tag") != "" {
+		// in-dialog requests follow the dialog's route set
+		return
+	}
+	setRoute(request, routes)
+}
+
+// setRoute gives the request the route set. When the first hop is a
+// strict router, the route becomes the Request-URI, and the Request-URI
+// the last route
+func setRoute(request Message, routes []string) {
+	setter, ok := request.(interface{ SetUri(string) })
+	if !isLooseRoute(routes[0]) && ok {
+		strict := append(append([]string{}, routes[1:]...), "<"+request.Uri()+">")
+		setter.SetUri(addrSpec(routes[0]))
+		routes = strict
+	}
+	SetRouteSet(request, routes)
+}
+
+// ServiceRoute returns the Service-Route of a response to a REGISTER,
+// topmost first (RFC 3608)
+func ServiceRoute(response *Response) []string {
+	return response.Headers().Extensions.GetAll("Service-Route")
+}
+
+// learnServiceRoute installs the Service-Route of a successful
+// registration of the profile's AOR as its route for later requests. Each
+// registration replaces the previous route, even with none
+func (ua *UserAgent) learnServiceRoute(register Message, response *Response) {
+	if register.Method() != "REGISTER" || !IsSuccess(response.StatusCode()) {
+		return
+	}
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	if ua.profile == nil || register.Headers().To == nil {
+		return
+	}
+	user, host := uriUserHost(register.Headers().To.Uri())
+	aorUser, aorHost := uriUserHost(ua.profile.AOR)
+	if user != aorUser || host != aorHost {
+		return
+	}
+	// the profile may be in use, so it is replaced rather than changed
+	profile := *ua.profile
+	profile.ServiceRoute = ServiceRoute(response)
+	ua.profile = &profile
+}
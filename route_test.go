@@ -0,0 +1,91 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUriHostPort(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"sip:bob@biloxi.com":                 "biloxi.com:5060",
+		"<sip:p1.example.com;lr>":            "p1.example.com:5060",
+		"sips:bob@biloxi.com:5071":           "biloxi.com:5071",
+		"sips:biloxi.com":                    "biloxi.com:5061",
+		"sip:[2001:db8::1];transport=tls":    "[2001:db8::1]:5061",
+		"sip:[2001:db8::1]:5080":             "[2001:db8::1]:5080",
+		"\"Proxy\" <sip:192.0.2.1:5070;lr>":  "192.0.2.1:5070",
+		"sip:alice@atlanta.com?Subject=test": "atlanta.com:5060",
+	} {
+		addr, err := uriHostPort(uri)
+		assert.Nil(t, err, uri)
+		assert.Equal(t, expected, addr, uri)
+	}
+}
+
+func TestPreloadedRoute(t *testing.T) {
+	profile := exampleProfile()
+	profile.Route = []string{"<sip:proxy.atlanta.com;lr>", "<sip:edge.biloxi.com;lr>"}
+	request := newTestInvite("sip:bob@biloxi.com")
+	profile.Apply(request)
+	assert.Equal(t, profile.Route, RouteSet(request))
+	hop, err := NextHop(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "proxy.atlanta.com:5060", hop)
+	assert.Contains(t, request.Render(), "Route: <sip:proxy.atlanta.com;lr>\r\nRoute: <sip:edge.biloxi.com;lr>\r\n")
+
+	// a strict router gets the Request-URI, which moves to the last route
+	profile.Route = []string{"<sip:proxy.atlanta.com>"}
+	request = newTestInvite("sip:bob@biloxi.com")
+	profile.Apply(request)
+	assert.Equal(t, "sip:proxy.atlanta.com", request.Uri())
+	assert.Equal(t, []string{"<sip:bob@biloxi.com>"}, RouteSet(request))
+
+	// in-dialog requests keep their own route
+	request = newTestInvite("sip:bob@biloxi.com")
+	request.Headers().To.SetParam("tag", "a6c85cf")
+	profile.Apply(request)
+	assert.Empty(t, RouteSet(request))
+	hop, err = NextHop(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "biloxi.com:5060", hop)
+}
+
+func TestServiceRoute(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	profile := exampleProfile()
+	profile.ContactHost = ""
+	profile.Route = []string{"<sip:proxy.atlanta.com;lr>"}
+	client.SetProfile(profile)
+	go func() {
+		for in := range server.Receive() {
+			response := NewResponse(in.Message, 200)
+			response.Headers().Extensions.Add("Service-Route", "<sip:orig@scscf.atlanta.com;lr>")
+			server.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+
+	register, err := NewRegister().To(profile.AOR).From(profile).Build()
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Request(ctx, b.LocalAddr().String(), register)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"<sip:orig@scscf.atlanta.com;lr>"}, client.Profile().ServiceRoute)
+	// the profile given isn't changed, as it may be shared
+	assert.Empty(t, profile.ServiceRoute)
+
+	invite := newTestInvite("sip:bob@biloxi.com")
+	client.Profile().Apply(invite)
+	assert.Equal(t, []string{"<sip:proxy.atlanta.com;lr>", "<sip:orig@scscf.atlanta.com;lr>"}, RouteSet(invite))
+}
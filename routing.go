@@ -0,0 +1,332 @@
+package slurp
+
+/*
+Outbound calls leave through trunks to carriers, each reaching some
+destinations, at some cost. A RoutingTable maps the prefixes of E.164
+destinations to the trunks that reach them, cheapest first, sharing the
+calls among trunks of the same cost by weight. Routes may only apply at
+some hours, e.g. a carrier cheaper at night. When a trunk fails, with a
+5xx, a timeout or no answer at all, the call fails over to the next.
+*/
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// Trunk is a gateway calls are sent through
+type Trunk struct {
+	Name string `json:"name" yaml:"name"`
+	// Uri is where calls are sent, e.g. sip:gw1.carrier.example.com:5060;
+	// the Request-URI keeps its user part, the destination, with the host
+	// of the trunk
+	Uri string `json:"uri" yaml:"uri"`
+	// Cost orders the trunks of a route, the cheapest tried first
+	Cost float64 `json:"cost" yaml:"cost"`
+	// Weight shares calls among trunks of the same cost, each getting
+	// calls first in proportion to it; 1 when zero
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// TimeWindow is when a route applies: on its Days, from From until To,
+// both as 15:04. A window whose To is before its From ends the next day.
+// Days are mon to sun, every day when empty
+type TimeWindow struct {
+	Days []string `json:"days,omitempty" yaml:"days,omitempty"`
+	From string   `json:"from" yaml:"from"`
+	To   string   `json:"to" yaml:"to"`
+}
+
+// RoutingEntry routes the destinations starting with its Prefix
+type RoutingEntry struct {
+	Name string `json:"name" yaml:"name"`
+	// Prefix of the E.164 destinations routed, e.g. +44; an empty one
+	// routes every destination
+	Prefix string  `json:"prefix" yaml:"prefix"`
+	Trunks []Trunk `json:"trunks" yaml:"trunks"`
+	// Hours restrict when the route applies, always when empty
+	Hours []TimeWindow `json:"hours,omitempty" yaml:"hours,omitempty"`
+}
+
+// parseClock returns the minutes since midnight of a 15:04 time
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// contains reports whether the window includes t. A window ending the
+// next day belongs to the day it starts on
+func (w TimeWindow) contains(t time.Time) bool {
+	from, _ := parseClock(w.From)
+	to, _ := parseClock(w.To)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case from <= to && (minute < from || minute >= to):
+		return false
+	case from > to && minute >= to && minute < from:
+		return false
+	case from > to && minute < to:
+		// the early hours of a window that started the day before
+		day = (day + 6) % 7
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports whether the route applies at t
+func (e RoutingEntry) active(t time.Time) bool {
+	if len(e.Hours) == 0 {
+		return true
+	}
+	for _, window := range e.Hours {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoutingTable picks the trunks of outbound calls by their destination.
+// It is safe for concurrent use
+type RoutingTable struct {
+	// Numbers normalizes the destinations of requests to E.164
+	Numbers NumberPlan
+	// TrunkTimeout is how long a trunk may take to answer before the
+	// call fails over to the next. Trunks are waited for until they
+	// answer when zero
+	TrunkTimeout time.Duration
+	// Location is the time zone of Hours, UTC when nil
+	Location *time.Location
+	// Clock used for Hours and TrunkTimeout, DefaultClock when nil
+	Clock  Clock
+	mu     sync.RWMutex
+	routes []RoutingEntry
+}
+
+// NewRoutingTable creates a RoutingTable with routes, failing when the
+// hours or days of one are invalid
+func NewRoutingTable(numbers NumberPlan, routes []RoutingEntry) (*RoutingTable, error) {
+	t := &RoutingTable{Numbers: numbers}
+	return t, t.SetRoutes(routes)
+}
+
+// SetRoutes replaces the routes of the table. When one is invalid, the
+// routes are left as they were
+func (t *RoutingTable) SetRoutes(routes []RoutingEntry) error {
+	for _, route := range routes {
+		for _, window := range route.Hours {
+			for _, value := range []string{window.From, window.To} {
+				if _, err := parseClock(value); err != nil {
+					return fmt.Errorf("route %q: invalid time %q", route.Name, value)
+				}
+			}
+			for _, day := range window.Days {
+				if _, ok := weekdays[strings.ToLower(day)]; !ok {
+					return fmt.Errorf("route %q: invalid day %q", route.Name, day)
+				}
+			}
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append([]RoutingEntry(nil), routes...)
+	return nil
+}
+
+// orderTrunks sorts trunks by cost, and those of the same cost in a
+// random order weighted by their Weight (RFC 2782)
+func orderTrunks(trunks []Trunk) []Trunk {
+	sorted := append([]Trunk(nil), trunks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Cost < sorted[j].Cost })
+	ordered := make([]Trunk, 0, len(sorted))
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Cost == sorted[start].Cost {
+			end++
+		}
+		same := sorted[start:end]
+		for len(same) > 0 {
+			total := 0
+			for _, trunk := range same {
+				total += trunkWeight(trunk)
+			}
+			pick, i := rand.Intn(total), 0
+			for ; pick >= trunkWeight(same[i]); i++ {
+				pick -= trunkWeight(same[i])
+			}
+			ordered = append(ordered, same[i])
+			same = append(same[:i:i], same[i+1:]...)
+		}
+		start = end
+	}
+	return ordered
+}
+
+func trunkWeight(trunk Trunk) int {
+	if trunk.Weight > 0 {
+		return trunk.Weight
+	}
+	return 1
+}
+
+// Lookup returns the trunks to try for a destination, in order, from the
+// route with the longest prefix applying now, preferring one restricted
+// by Hours to one that always applies. ok is false when no route applies
+func (t *RoutingTable) Lookup(destination string) (route string, trunks []Trunk, ok bool) {
+	destination, _ = t.Numbers.Normalize(destination)
+	location := t.Location
+	if location == nil {
+		location = time.UTC
+	}
+	now := clockOrDefault(t.Clock).Now().In(location)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	best := -1
+	for i, entry := range t.routes {
+		if !strings.HasPrefix(destination, entry.Prefix) || !entry.active(now) {
+			continue
+		}
+		switch {
+		case best < 0, len(entry.Prefix) > len(t.routes[best].Prefix):
+			best = i
+		case len(entry.Prefix) == len(t.routes[best].Prefix) && len(entry.Hours) > 0 && len(t.routes[best].Hours) == 0:
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", nil, false
+	}
+	return t.routes[best].Name, orderTrunks(t.routes[best].Trunks), true
+}
+
+// trunkTarget returns the Request-URI of a request sent through trunk:
+// the user part of uri, the destination, at the trunk
+func trunkTarget(uri string, trunk Trunk) string {
+	target := addrSpec(trunk.Uri)
+	start, end, ok := uriPart(uri, "user")
+	if !ok {
+		return target
+	}
+	scheme := UriScheme(target)
+	rest := strings.TrimPrefix(target[len(scheme):], ":")
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		rest = rest[at+1:]
+	}
+	return scheme + ":" + uri[start:end] + "@" + rest
+}
+
+// failsOver reports whether the final responses of a trunk mean the next
+// should be tried: it gave none, as it couldn't be reached or didn't
+// answer in time, or a 5xx or 408
+func failsOver(finals []*Response) bool {
+	for _, response := range finals {
+		if code := response.StatusCode(); code != 408 && response.Class() != ServerError {
+			return false
+		}
+	}
+	return true
+}
+
+// Forward routes a request by the number of its Request-URI, as a proxy,
+// trying the trunks of its route in turn until one doesn't fail, and
+// returns the final response to send upstream. provisional, when not
+// nil, is called with the provisional responses other than 100. A
+// request without a route is answered with 404, and one every trunk
+// failed with the best of their responses, or 503
+func (t *RoutingTable) Forward(ctx context.Context, ua *UserAgent, request Message, provisional func(*Response)) (*Response, error) {
+	destination, ok := dialedNumber(request.Uri())
+	if !ok {
+		return NewResponse(request, 404), nil
+	}
+	_, trunks, ok := t.Lookup(destination)
+	if !ok {
+		return NewResponse(request, 404), nil
+	}
+	var timeouts []time.Duration
+	if t.TrunkTimeout > 0 {
+		timeouts = []time.Duration{t.TrunkTimeout}
+	}
+	var finals []*Response
+	for _, trunk := range trunks {
+		binding := Binding{Contact: trunkTarget(request.Uri(), trunk)}
+		accepted, responses := ua.forward(ctx, request, []Binding{binding}, timeouts, t.Clock, provisional)
+		if accepted != nil {
+			return accepted, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		finals = append(finals, responses...)
+		if !failsOver(responses) {
+			return responses[0], nil
+		}
+	}
+	if len(finals) == 0 {
+		return NewResponse(request, 503), nil
+	}
+	return bestResponse(request, finals), nil
+}
+
+// Request sends a request of the UserAgent's own through the trunks of
+// its route in turn, until one doesn't fail, and returns the last
+// response. Each attempt is a new transaction
+func (t *RoutingTable) Request(ctx context.Context, ua *UserAgent, request Message) (*Response, error) {
+	destination, ok := dialedNumber(request.Uri())
+	if !ok {
+		return nil, fmt.Errorf("no number to route in %s", request.Uri())
+	}
+	_, trunks, ok := t.Lookup(destination)
+	if !ok {
+		return nil, fmt.Errorf("no route to %s", destination)
+	}
+	setter, _ := request.(interface{ SetUri(string) })
+	uri := request.Uri()
+	var response *Response
+	var err error
+	for _, trunk := range trunks {
+		target := trunkTarget(uri, trunk)
+		addr, resolveErr := uriHostPort(target)
+		if resolveErr != nil {
+			err = resolveErr
+			continue
+		}
+		if setter != nil {
+			setter.SetUri(target)
+		}
+		request.Control().ViaBranch = NewBranch()
+		response, err = ua.Request(ctx, addr, request)
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil && !failsOver([]*Response{response}) {
+			break
+		}
+		if _, throttled := err.(ThrottledError); err != nil && !throttled && !shouldFailover(nil, err) {
+			break
+		}
+	}
+	return response, err
+}
@@ -0,0 +1,147 @@
+package slurp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingTableLookup(t *testing.T) {
+	_, err := NewRoutingTable(NumberPlan{}, []RoutingEntry{{Name: "bad", Hours: []TimeWindow{{From: "25:00", To: "08:00"}}}})
+	assert.NotNil(t, err)
+	_, err = NewRoutingTable(NumberPlan{}, []RoutingEntry{{Name: "bad", Hours: []TimeWindow{{Days: []string{"someday"}, From: "08:00", To: "18:00"}}}})
+	assert.NotNil(t, err)
+
+	// a Monday, at noon
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	table, err := NewRoutingTable(NumberPlan{CountryCode: "44", TrunkPrefix: "0", InternationalPrefix: "00"}, []RoutingEntry{
+		{Name: "default", Trunks: []Trunk{{Name: "a", Uri: "sip:gw.a.example.com", Cost: 2}, {Name: "b", Uri: "sip:gw.b.example.com", Cost: 1}}},
+		{Name: "uk", Prefix: "+44", Trunks: []Trunk{{Name: "c", Uri: "sip:gw.c.example.com", Cost: 1, Weight: 3}, {Name: "d", Uri: "sip:gw.d.example.com", Cost: 1}}},
+		{Name: "uk-night", Prefix: "+44", Trunks: []Trunk{{Name: "e", Uri: "sip:gw.e.example.com"}},
+			Hours: []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "20:00", To: "06:00"}}},
+	})
+	assert.Nil(t, err)
+	table.Clock = clock
+	names := func(trunks []Trunk) (names []string) {
+		for _, trunk := range trunks {
+			names = append(names, trunk.Name)
+		}
+		return
+	}
+
+	route, trunks, ok := table.Lookup("0033 123456789")
+	assert.True(t, ok)
+	assert.Equal(t, "default", route)
+	assert.Equal(t, []string{"b", "a"}, names(trunks), "the cheapest trunk first")
+
+	// trunks of the same cost share the calls by weight
+	first := map[string]int{}
+	for i := 0; i < 400; i++ {
+		route, trunks, _ = table.Lookup("020 7946 0000")
+		first[trunks[0].Name]++
+	}
+	assert.Equal(t, "uk", route)
+	assert.InDelta(t, 300, first["c"], 60)
+	assert.Equal(t, 400, first["c"]+first["d"])
+
+	// the night route applies from weekday evenings until the next
+	// morning, and wins over the one always applying
+	clock.Advance(12 * time.Hour)
+	route, _, _ = table.Lookup("+442079460000")
+	assert.Equal(t, "uk-night", route)
+	clock.Advance(5 * time.Hour)
+	route, _, _ = table.Lookup("+442079460000")
+	assert.Equal(t, "uk-night", route)
+	clock.Advance(2 * time.Hour)
+	route, _, _ = table.Lookup("+442079460000")
+	assert.Equal(t, "uk", route)
+	clock.Advance(3*24*time.Hour + 18*time.Hour)
+	route, _, _ = table.Lookup("+442079460000")
+	assert.Equal(t, "uk-night", route, "Saturday 1am is still Friday night")
+	clock.Advance(24 * time.Hour)
+	route, _, _ = table.Lookup("+442079460000")
+	assert.Equal(t, "uk", route)
+
+	table.SetRoutes(nil)
+	_, _, ok = table.Lookup("+442079460000")
+	assert.False(t, ok)
+	assert.Equal(t, "sip:+442079460000@gw.example.com:5060;transport=tcp", trunkTarget("sip:+442079460000@pbx.example.com;user=phone", Trunk{Uri: "<sip:gw.example.com:5060;transport=tcp>"}))
+}
+
+func TestRoutingTableFailover(t *testing.T) {
+	p, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer p.Close()
+	proxy := NewUserAgent(p)
+	tried := make(chan string, 8)
+	trunk := func(name string, code int) Trunk {
+		c, err := ListenUDP("127.0.0.1:0")
+		assert.Nil(t, err)
+		t.Cleanup(func() { c.Close() })
+		ua := NewUserAgent(c)
+		go func() {
+			for in := range ua.Receive() {
+				tried <- name + " " + in.Message.Uri()
+				ua.Send(context.Background(), in.Source.String(), NewResponse(in.Message, code))
+			}
+		}()
+		return Trunk{Name: name, Uri: "sip:" + c.LocalAddr().String()}
+	}
+	failing, answering, busy := trunk("failing", 500), trunk("answering", 200), trunk("busy", 486)
+	failing.Cost, answering.Cost, busy.Cost = 1, 2, 3
+	busier, lastResort := busy, answering
+	busier.Cost, lastResort.Cost = 2, 3
+	table, err := NewRoutingTable(NumberPlan{CountryCode: "44", TrunkPrefix: "0"}, []RoutingEntry{
+		{Name: "uk", Prefix: "+44", Trunks: []Trunk{busy, answering, failing}},
+		{Name: "busy", Prefix: "+33", Trunks: []Trunk{failing, busier, lastResort}},
+	})
+	assert.Nil(t, err)
+
+	message := func(uri string) Message {
+		m, err := ParseMessage(strings.Join([]string{
+			"MESSAGE " + uri + " SIP/2.0",
+			"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+			"Max-Forwards: 70",
+			"To: <" + uri + ">",
+			"From: <sip:alice@atlanta.com>;tag=1928301774",
+			"Call-ID: " + generateTag(),
+			"CSeq: 1 MESSAGE",
+			"Content-Length: 0",
+			"", "",
+		}, "\r\n"))
+		assert.Nil(t, err)
+		return m
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a 5xx fails over to the next trunk
+	response, err := table.Forward(ctx, proxy, message("sip:02079460000@pbx.example.com"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "failing sip:02079460000@"+strings.TrimPrefix(failing.Uri, "sip:"), <-tried)
+	assert.Equal(t, "answering sip:02079460000@"+strings.TrimPrefix(answering.Uri, "sip:"), <-tried)
+
+	// but a 4xx doesn't
+	response, err = table.Forward(ctx, proxy, message("sip:+33123456789@pbx.example.com"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 486, response.StatusCode())
+	assert.Equal(t, "failing", strings.Fields(<-tried)[0])
+	assert.Equal(t, "busy", strings.Fields(<-tried)[0])
+	response, err = table.Forward(ctx, proxy, message("sip:+15550100@pbx.example.com"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 404, response.StatusCode())
+
+	// the UserAgent's own requests fail over the same way
+	options, _ := NewRequestBuilder("OPTIONS").To("sip:02079460000@pbx.example.com").FromUri("sip:alice@atlanta.com").Build()
+	response, err = table.Request(ctx, proxy, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "failing", strings.Fields(<-tried)[0])
+	assert.Equal(t, "answering", strings.Fields(<-tried)[0])
+}
@@ -0,0 +1,120 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrUnknownDigit indicates a digit that has no telephone-event code
+var ErrUnknownDigit = errors.New("rtp: unknown DTMF digit")
+
+// dtmfDigits maps RFC 4733 event codes 0-15 to their digit
+const dtmfDigits = "0123456789*#ABCD"
+
+// dtmfInterval is how often event packets are sent while a digit is held
+const dtmfInterval = 50 * time.Millisecond
+
+// Event is the payload of an RFC 4733 telephone-event packet
+type Event struct {
+	Event uint8
+	End   bool
+	// Volume in -dBm0, 0 to 63
+	Volume uint8
+	// Duration in timestamp units since the start of the event
+	Duration uint16
+}
+
+// Marshal returns the event in wire format
+func (e Event) Marshal() []byte {
+	data := make([]byte, 4)
+	data[0] = e.Event
+	data[1] = e.Volume & 0x3F
+	if e.End {
+		data[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(data[2:4], e.Duration)
+	return data
+}
+
+// Unmarshal reads an event from wire format
+func (e *Event) Unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return ErrInvalidPacket
+	}
+	e.Event = data[0]
+	e.End = data[1]&0x80 != 0
+	e.Volume = data[1] & 0x3F
+	e.Duration = binary.BigEndian.Uint16(data[2:4])
+	return nil
+}
+
+// Digit returns the DTMF digit of the event, or 0 for other events
+func (e Event) Digit() rune {
+	if int(e.Event) < len(dtmfDigits) {
+		return rune(dtmfDigits[e.Event])
+	}
+	return 0
+}
+
+// SendDigit sends a DTMF digit as telephone-event packets, blocking for
+// the duration of the digit. The payload type negotiated for
+// telephone-event must be set, see SetTelephoneEventType
+func (s *Session) SendDigit(digit rune, duration time.Duration) error {
+	code := strings.IndexRune(dtmfDigits, digit)
+	if code < 0 {
+		return ErrUnknownDigit
+	}
+	payloadType := s.TelephoneEventType()
+	step := uint16(uint32(dtmfInterval/time.Millisecond) * s.ClockRate / 1000)
+	total := uint16(uint32(duration/time.Millisecond) * s.ClockRate / 1000)
+	event := Event{Event: uint8(code), Volume: 10}
+	// every packet of an event shares its timestamp, and the first has
+	// the marker bit set
+	for event.Duration = step; event.Duration < total; event.Duration += step {
+		if err := s.send(payloadType, event.Marshal(), 0, event.Duration == step); err != nil {
+			return err
+		}
+		time.Sleep(dtmfInterval)
+	}
+	event.Duration = total
+	event.End = true
+	// the end packet is sent three times for reliability
+	for i := 0; i < 3; i++ {
+		samples := uint32(0)
+		if i == 2 {
+			samples = uint32(total)
+		}
+		if err := s.send(payloadType, event.Marshal(), samples, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Digits returns the channel DTMF digits received as telephone-event
+// packets are delivered on. Each digit is delivered once, when it ends
+func (s *Session) Digits() <-chan rune {
+	return s.digits
+}
+
+// handleEvent delivers the digit of a telephone-event packet, ignoring
+// the repeated end packets of an event already delivered
+func (s *Session) handleEvent(packet *Packet) {
+	event := Event{}
+	if event.Unmarshal(packet.Payload) != nil || !event.End {
+		return
+	}
+	if s.lastEvent == packet.Timestamp && s.eventSeen {
+		return
+	}
+	s.lastEvent, s.eventSeen = packet.Timestamp, true
+	if digit := event.Digit(); digit != 0 {
+		select {
+		case s.digits <- digit:
+		default:
+			// nobody is listening, drop the digit rather than block media
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Package rtp carries media for calls set up with slurp: it opens RTP/RTCP
+port pairs and sends and receives RTP packets (RFC 3550) for the payload
+type negotiated in SDP.
+*/
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const headerSize = 12
+
+// ErrInvalidPacket indicates data that isn't a valid RTP version 2 packet
+var ErrInvalidPacket = errors.New("rtp: invalid packet")
+
+// Packet is a single RTP packet
+type Packet struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	Payload        []byte
+}
+
+// Marshal returns the packet in wire format. Extensions and padding aren't
+// generated, as nothing slurp sends needs them
+func (p *Packet) Marshal() []byte {
+	data := make([]byte, headerSize+4*len(p.CSRC)+len(p.Payload))
+	data[0] = 2<<6 | byte(len(p.CSRC))
+	data[1] = p.PayloadType & 0x7F
+	if p.Marker {
+		data[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(data[2:4], p.SequenceNumber)
+	binary.BigEndian.PutUint32(data[4:8], p.Timestamp)
+	binary.BigEndian.PutUint32(data[8:12], p.SSRC)
+	offset := headerSize
+	for _, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(data[offset:offset+4], csrc)
+		offset += 4
+	}
+	copy(data[offset:], p.Payload)
+	return data
+}
+
+// Unmarshal reads a packet from wire format, skipping any header
+// extension and removing padding
+func (p *Packet) Unmarshal(data []byte) error {
+	if len(data) < headerSize || data[0]>>6 != 2 {
+		return ErrInvalidPacket
+	}
+	padding := data[0]&0x20 != 0
+	extension := data[0]&0x10 != 0
+	count := int(data[0] & 0x0F)
+	p.Marker = data[1]&0x80 != 0
+	p.PayloadType = data[1] & 0x7F
+	p.SequenceNumber = binary.BigEndian.Uint16(data[2:4])
+	p.Timestamp = binary.BigEndian.Uint32(data[4:8])
+	p.SSRC = binary.BigEndian.Uint32(data[8:12])
+	offset := headerSize + 4*count
+	if len(data) < offset {
+		return ErrInvalidPacket
+	}
+	p.CSRC = nil
+	for i := 0; i < count; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(data[headerSize+4*i:]))
+	}
+	if extension {
+		if len(data) < offset+4 {
+			return ErrInvalidPacket
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+	}
+	end := len(data)
+	if padding {
+		end -= int(data[end-1])
+	}
+	if end < offset {
+		return ErrInvalidPacket
+	}
+	p.Payload = append([]byte{}, data[offset:end]...)
+	return nil
+}
@@ -0,0 +1,102 @@
+package rtp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp/sdp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacket(t *testing.T) {
+	packet := Packet{
+		Marker:         true,
+		PayloadType:    0,
+		SequenceNumber: 65535,
+		Timestamp:      160,
+		SSRC:           0xdeadbeef,
+		CSRC:           []uint32{1},
+		Payload:        []byte{1, 2, 3},
+	}
+	parsed := Packet{}
+	assert.Nil(t, parsed.Unmarshal(packet.Marshal()))
+	assert.Equal(t, packet, parsed)
+	assert.Equal(t, ErrInvalidPacket, parsed.Unmarshal([]byte{0, 1}))
+}
+
+func TestSession(t *testing.T) {
+	a, err := Listen("127.0.0.1", 20000, 30000, 0, 8000)
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	b, err := Listen("127.0.0.1", a.LocalPort()+2, 30000, 0, 8000)
+	assert.Nil(t, err)
+	defer b.Close()
+	assert.Equal(t, 0, a.LocalPort()%2)
+	assert.Equal(t, ErrNoRemote, a.Send([]byte{0}, 160, false))
+
+	a.SetRemote(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: b.LocalPort()})
+	assert.Nil(t, a.Send(make([]byte, 160), 160, true))
+	assert.Nil(t, a.Send(make([]byte, 160), 160, false))
+	assert.Nil(t, a.SendReport())
+	var first, second *Packet
+	for _, p := range []**Packet{&first, &second} {
+		select {
+		case *p = <-b.Receive():
+		case <-time.After(5 * time.Second):
+			t.Fatal("packet not received")
+		}
+	}
+	assert.Equal(t, a.SSRC, first.SSRC)
+	assert.True(t, first.Marker)
+	assert.Equal(t, first.SequenceNumber+1, second.SequenceNumber)
+	assert.Equal(t, first.Timestamp+160, second.Timestamp)
+
+	media := &sdp.Media{Type: "audio", Protocol: "RTP/AVP", Formats: []string{"0"}}
+	a.Describe(media)
+	assert.Equal(t, a.LocalPort(), media.Port)
+	assert.True(t, media.Attributes.Has("ssrc"))
+
+	assert.Equal(t, ErrNoFormat, a.UseFormats([]sdp.Format{{PayloadType: 101, Codec: sdp.TelephoneEvent}}))
+	assert.Nil(t, a.UseFormats([]sdp.Format{{PayloadType: 111, Codec: sdp.Opus}, {PayloadType: 0, Codec: sdp.PCMU}}))
+	assert.Equal(t, uint8(111), a.PayloadType)
+	assert.Equal(t, uint32(48000), a.ClockRate)
+	assert.Equal(t, uint8(101), a.TelephoneEventType())
+}
+
+func TestDTMF(t *testing.T) {
+	a, err := Listen("127.0.0.1", 30000, 40000, 0, 8000)
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	b, err := Listen("127.0.0.1", a.LocalPort()+2, 40000, 0, 8000)
+	assert.Nil(t, err)
+	defer b.Close()
+	a.SetTelephoneEventType(101)
+	b.SetTelephoneEventType(101)
+	a.SetRemote(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: b.LocalPort()})
+
+	// digits arrive even though nobody drains the media b receives
+	for i := 0; i < 2*cap(b.received); i++ {
+		assert.Nil(t, a.Send(make([]byte, 160), 160, false))
+	}
+	assert.Equal(t, ErrUnknownDigit, a.SendDigit('x', 100*time.Millisecond))
+	assert.Nil(t, a.SendDigit('5', 100*time.Millisecond))
+	assert.Nil(t, a.SendDigit('#', 100*time.Millisecond))
+	for _, expected := range "5#" {
+		select {
+		case digit := <-b.Digits():
+			assert.Equal(t, expected, digit)
+		case <-time.After(5 * time.Second):
+			t.Fatal("digit not received")
+		}
+	}
+	select {
+	case digit := <-b.Digits():
+		t.Fatalf("digit %c delivered twice", digit)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,281 @@
+package rtp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// ErrNoPorts indicates no free RTP/RTCP port pair was found in the range
+var ErrNoPorts = errors.New("rtp: no free port pair in range")
+
+// ErrNoFormat indicates none of the negotiated formats carries media
+var ErrNoFormat = errors.New("rtp: no media format negotiated")
+
+// ErrNoRemote indicates media was sent before the remote address was known
+var ErrNoRemote = errors.New("rtp: remote address not set")
+
+// Session sends and receives a single RTP stream, and its RTCP
+type Session struct {
+	// The payload type and clock rate negotiated in SDP
+	PayloadType uint8
+	ClockRate   uint32
+	SSRC        uint32
+	// CNAME identifies the endpoint in RTCP and SDP
+	CNAME      string
+	rtp        *net.UDPConn
+	rtcp       *net.UDPConn
+	mu         sync.Mutex
+	remote     *net.UDPAddr
+	remoteRtcp *net.UDPAddr
+	sequence   uint16
+	timestamp  uint32
+	packets    uint32
+	octets     uint32
+	received   chan *Packet
+	digits     chan rune
+	lastEvent  uint32
+	eventSeen  bool
+	protector  Protector
+	// the payload type negotiated for telephone-event, see
+	// SetTelephoneEventType
+	telephoneEvent uint8
+}
+
+// Listen opens an RTP/RTCP port pair on ip, with the RTP port being the
+// first free even port between minPort and maxPort, and RTCP the next one
+func Listen(ip string, minPort, maxPort int, payloadType uint8, clockRate uint32) (*Session, error) {
+	address := net.ParseIP(ip)
+	for port := minPort + minPort%2; port+1 <= maxPort; port += 2 {
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: address, Port: port})
+		if err != nil {
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: address, Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+		s := &Session{
+			PayloadType: payloadType,
+			ClockRate:   clockRate,
+			rtp:         rtpConn,
+			rtcp:        rtcpConn,
+			received:    make(chan *Packet, 64),
+			digits:      make(chan rune, 16),
+		}
+		// SSRC, initial sequence number and timestamp are random per RFC 3550
+		random := make([]byte, 10)
+		rand.Read(random)
+		s.SSRC = binary.BigEndian.Uint32(random[0:4])
+		s.sequence = binary.BigEndian.Uint16(random[4:6])
+		s.timestamp = binary.BigEndian.Uint32(random[6:10])
+		s.CNAME = fmt.Sprintf("%08x@%s", s.SSRC, ip)
+		go s.read()
+		return s, nil
+	}
+	return nil, ErrNoPorts
+}
+
+func (s *Session) read() {
+	defer close(s.received)
+	buffer := make([]byte, 1500)
+	for {
+		n, _, err := s.rtp.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		data := buffer[:n]
+		s.mu.Lock()
+		protector, telephoneEvent := s.protector, s.telephoneEvent
+		s.mu.Unlock()
+		if protector != nil {
+			if data, err = protector.Unprotect(data); err != nil {
+				continue
+			}
+		}
+		packet := &Packet{}
+		if packet.Unmarshal(data) != nil {
+			continue
+		}
+		if telephoneEvent != 0 && packet.PayloadType == telephoneEvent {
+			s.handleEvent(packet)
+			continue
+		}
+		select {
+		case s.received <- packet:
+		default:
+			// nobody is draining Receive, drop the packet rather than
+			// stop the digits that follow
+		}
+	}
+}
+
+// LocalPort returns the RTP port; RTCP is on the next port
+func (s *Session) LocalPort() int {
+	return s.rtp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// SetRemote sets where media is sent, usually from the remote SDP.
+// RTCP goes to the next port, as no a=rtcp attribute is supported yet
+func (s *Session) SetRemote(addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remote = addr
+	s.remoteRtcp = &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1}
+}
+
+// Send sends a payload holding the given number of samples. The sequence
+// number is incremented for every packet and the timestamp by samples
+func (s *Session) Send(payload []byte, samples uint32, marker bool) error {
+	return s.send(s.PayloadType, payload, samples, marker)
+}
+
+func (s *Session) send(payloadType uint8, payload []byte, samples uint32, marker bool) error {
+	s.mu.Lock()
+	if s.remote == nil {
+		s.mu.Unlock()
+		return ErrNoRemote
+	}
+	packet := Packet{
+		Marker:         marker,
+		PayloadType:    payloadType,
+		SequenceNumber: s.sequence,
+		Timestamp:      s.timestamp,
+		SSRC:           s.SSRC,
+		Payload:        payload,
+	}
+	s.sequence++
+	s.timestamp += samples
+	s.packets++
+	s.octets += uint32(len(payload))
+	remote := s.remote
+	protector := s.protector
+	s.mu.Unlock()
+	data := packet.Marshal()
+	if protector != nil {
+		var err error
+		if data, err = protector.Protect(data); err != nil {
+			return err
+		}
+	}
+	_, err := s.rtp.WriteToUDP(data, remote)
+	return err
+}
+
+// Receive returns the channel received packets are delivered on.
+// Packets arriving while it is full are dropped. It is closed when the
+// session is closed
+func (s *Session) Receive() <-chan *Packet {
+	return s.received
+}
+
+// SendReport sends an RTCP sender report with the stream's counters
+func (s *Session) SendReport() error {
+	s.mu.Lock()
+	if s.remoteRtcp == nil {
+		s.mu.Unlock()
+		return ErrNoRemote
+	}
+	report := make([]byte, 28)
+	// version 2, no reception reports, PT=200 (SR), length 6 words
+	report[0] = 2 << 6
+	report[1] = 200
+	binary.BigEndian.PutUint16(report[2:4], 6)
+	binary.BigEndian.PutUint32(report[4:8], s.SSRC)
+	// NTP timestamp, seconds since 1900 and a binary fraction
+	now := time.Now()
+	binary.BigEndian.PutUint32(report[8:12], uint32(now.Unix()+2208988800))
+	binary.BigEndian.PutUint32(report[12:16], uint32((uint64(now.Nanosecond())<<32)/1e9))
+	binary.BigEndian.PutUint32(report[16:20], s.timestamp)
+	binary.BigEndian.PutUint32(report[20:24], s.packets)
+	binary.BigEndian.PutUint32(report[24:28], s.octets)
+	remote := s.remoteRtcp
+	s.mu.Unlock()
+	_, err := s.rtcp.WriteToUDP(report, remote)
+	return err
+}
+
+// UseFormats sets the payload type and clock rate from the formats
+// negotiated in SDP, most preferred first: media is sent in the first
+// one that isn't telephone-event or comfort noise, and DTMF as
+// telephone-event when it was negotiated
+func (s *Session) UseFormats(formats []sdp.Format) error {
+	media := false
+	for _, format := range formats {
+		switch {
+		case format.Is(sdp.TelephoneEvent):
+			s.mu.Lock()
+			if s.telephoneEvent == 0 {
+				s.telephoneEvent = format.PayloadType
+			}
+			s.mu.Unlock()
+		case strings.EqualFold(format.Name, "CN"):
+		case !media:
+			s.PayloadType, s.ClockRate, media = format.PayloadType, format.ClockRate, true
+		}
+	}
+	if !media {
+		return ErrNoFormat
+	}
+	return nil
+}
+
+// Describe fills in the session's port and SSRC on a media section of an
+// SDP offer or answer
+func (s *Session) Describe(m *sdp.Media) {
+	m.Port = s.LocalPort()
+	m.Attributes = m.Attributes.Remove("ssrc")
+	m.Attributes = append(m.Attributes, sdp.Attribute{
+		Key:   "ssrc",
+		Value: fmt.Sprintf("%d cname:%s", s.SSRC, s.CNAME),
+	})
+}
+
+// Close closes both sockets
+func (s *Session) Close() error {
+	s.rtcp.Close()
+	return s.rtp.Close()
+}
+
+// Protector encrypts and authenticates packets, e.g. an SRTP context set
+// up with the keys negotiated through SDES crypto attributes. slurp does
+// not implement SRTP itself
+type Protector interface {
+	// Protect turns a marshalled RTP packet into an SRTP packet
+	Protect(packet []byte) ([]byte, error)
+	// Unprotect turns a received SRTP packet into an RTP packet
+	Unprotect(packet []byte) ([]byte, error)
+}
+
+// SetTelephoneEventType sets the payload type negotiated for
+// telephone-event (DTMF), if any. Packets of this type are delivered on
+// Digits instead of Receive, and SendDigit sends them
+func (s *Session) SetTelephoneEventType(payloadType uint8) {
+	s.mu.Lock()
+	s.telephoneEvent = payloadType
+	s.mu.Unlock()
+}
+
+// TelephoneEventType returns the payload type of telephone-event, zero
+// when it wasn't negotiated
+func (s *Session) TelephoneEventType() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.telephoneEvent
+}
+
+// SetProtector makes the session send and receive through p. Packets
+// that fail to unprotect are dropped
+func (s *Session) SetProtector(p Protector) {
+	s.mu.Lock()
+	s.protector = p
+	s.mu.Unlock()
+}
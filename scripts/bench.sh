@@ -0,0 +1,27 @@
+#!/bin/sh
+# Compare benchmarks against a baseline revision.
+#
+#   scripts/bench.sh [baseline-rev] [benchmark-regexp]
+#
+# Runs the benchmarks on the baseline (default: master) and on the working
+# tree, then compares them with benchstat if it's installed
+# (go install golang.org/x/perf/cmd/benchstat@latest).
+set -e
+
+baseline=${1:-master}
+pattern=${2:-.}
+count=${BENCH_COUNT:-6}
+out=$(mktemp -d)
+root=$(git rev-parse --show-toplevel)
+
+git -C "$root" worktree add -q "$out/baseline" "$baseline"
+trap 'git -C "$root" worktree remove --force "$out/baseline"' EXIT
+
+(cd "$out/baseline" && go test -run '^$' -bench "$pattern" -benchmem -count "$count" .) > "$out/old.txt"
+(cd "$root" && go test -run '^$' -bench "$pattern" -benchmem -count "$count" .) > "$out/new.txt"
+
+if command -v benchstat > /dev/null; then
+	benchstat "$out/old.txt" "$out/new.txt"
+else
+	echo "benchstat not found, raw results are in $out"
+fi
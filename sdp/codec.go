@@ -0,0 +1,268 @@
+package sdp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec is an RTP payload format, as an a=rtpmap line describes it
+type Codec struct {
+	// Name is the encoding name, e.g. PCMU or opus
+	Name string
+	// ClockRate is the RTP clock rate, which for some codecs (G722)
+	// differs from the sampling rate
+	ClockRate uint32
+	// Channels is the number of audio channels, one when zero
+	Channels int
+	// Fmtp is the format parameters offered with the codec, e.g. 0-16
+	Fmtp string
+}
+
+// Common codecs
+var (
+	PCMU = Codec{Name: "PCMU", ClockRate: 8000}
+	PCMA = Codec{Name: "PCMA", ClockRate: 8000}
+	// G722 uses an 8000 Hz RTP clock for its 16 kHz samples, an error in
+	// RFC 1890 kept for compatibility
+	G722 = Codec{Name: "G722", ClockRate: 8000}
+	Opus = Codec{Name: "opus", ClockRate: 48000, Channels: 2}
+	// TelephoneEvent carries DTMF digits (RFC 4733)
+	TelephoneEvent = Codec{Name: "telephone-event", ClockRate: 8000, Fmtp: "0-16"}
+)
+
+// StaticPayloadTypes are the audio payload types RFC 3551 assigns, which
+// may be used without an rtpmap
+var StaticPayloadTypes = map[uint8]Codec{
+	0:  PCMU,
+	3:  {Name: "GSM", ClockRate: 8000},
+	4:  {Name: "G723", ClockRate: 8000},
+	8:  PCMA,
+	9:  G722,
+	13: {Name: "CN", ClockRate: 8000},
+	18: {Name: "G729", ClockRate: 8000},
+}
+
+// FirstDynamicPayloadType is where payload types without a static
+// assignment are numbered from, up to 127
+const FirstDynamicPayloadType = 96
+
+// Is reports whether c and other are the same codec. Names are compared
+// case-insensitively and format parameters are ignored
+func (c Codec) Is(other Codec) bool {
+	channels := func(n int) int {
+		if n == 0 {
+			return 1
+		}
+		return n
+	}
+	return strings.EqualFold(c.Name, other.Name) && c.ClockRate == other.ClockRate &&
+		channels(c.Channels) == channels(other.Channels)
+}
+
+// rtpmap returns the value of the codec's rtpmap after the payload type
+func (c Codec) rtpmap() string {
+	value := fmt.Sprintf("%s/%d", c.Name, c.ClockRate)
+	if c.Channels > 1 {
+		value += "/" + strconv.Itoa(c.Channels)
+	}
+	return value
+}
+
+func parseRtpmap(value string) (uint8, Codec, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, Codec{}, fmt.Errorf("rtpmap needs 2 fields")
+	}
+	pt, err := strconv.ParseUint(fields[0], 10, 7)
+	if err != nil {
+		return 0, Codec{}, err
+	}
+	parts := strings.Split(fields[1], "/")
+	if len(parts) < 2 {
+		return 0, Codec{}, fmt.Errorf("rtpmap needs a clock rate")
+	}
+	c := Codec{Name: parts[0]}
+	rate, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, Codec{}, err
+	}
+	c.ClockRate = uint32(rate)
+	if len(parts) > 2 {
+		if c.Channels, err = strconv.Atoi(parts[2]); err != nil {
+			return 0, Codec{}, err
+		}
+	}
+	return uint8(pt), c, nil
+}
+
+// Format is a codec bound to a payload type in a media section
+type Format struct {
+	PayloadType uint8
+	Codec
+}
+
+// Codecs returns the RTP formats of the media section in order of
+// preference, resolved through their rtpmap and fmtp attributes, or the
+// static assignments. Formats that can't be resolved are left out
+func (m *Media) Codecs() []Format {
+	codecs := make(map[uint8]Codec)
+	for pt, codec := range StaticPayloadTypes {
+		codecs[pt] = codec
+	}
+	for _, value := range m.Attributes.GetAll("rtpmap") {
+		if pt, codec, err := parseRtpmap(value); err == nil {
+			codecs[pt] = codec
+		}
+	}
+	fmtps := make(map[uint8]string)
+	for _, value := range m.Attributes.GetAll("fmtp") {
+		parts := strings.SplitN(value, " ", 2)
+		if pt, err := strconv.ParseUint(parts[0], 10, 7); err == nil && len(parts) == 2 {
+			fmtps[uint8(pt)] = strings.TrimSpace(parts[1])
+		}
+	}
+	var formats []Format
+	for _, format := range m.Formats {
+		pt, err := strconv.ParseUint(format, 10, 7)
+		if err != nil {
+			continue
+		}
+		if codec, ok := codecs[uint8(pt)]; ok {
+			codec.Fmtp = fmtps[uint8(pt)]
+			formats = append(formats, Format{PayloadType: uint8(pt), Codec: codec})
+		}
+	}
+	return formats
+}
+
+// AddFormat appends the format to the media section, with its rtpmap,
+// and its fmtp when it has format parameters
+func (m *Media) AddFormat(f Format) {
+	pt := strconv.Itoa(int(f.PayloadType))
+	m.Formats = append(m.Formats, pt)
+	m.Attributes = append(m.Attributes, Attribute{Key: "rtpmap", Value: pt + " " + f.rtpmap()})
+	if f.Fmtp != "" {
+		m.Attributes = append(m.Attributes, Attribute{Key: "fmtp", Value: pt + " " + f.Fmtp})
+	}
+}
+
+// Registry is the set of codecs an application supports, in order of
+// preference
+type Registry struct {
+	mu     sync.RWMutex
+	codecs []Codec
+}
+
+// NewRegistry creates a Registry supporting codecs, most preferred first
+func NewRegistry(codecs ...Codec) *Registry {
+	return &Registry{codecs: append([]Codec(nil), codecs...)}
+}
+
+// DefaultRegistry supports the common codecs and DTMF
+var DefaultRegistry = NewRegistry(Opus, G722, PCMU, PCMA, TelephoneEvent)
+
+// Register adds a codec, least preferred, replacing one that Is the same
+func (r *Registry) Register(c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, codec := range r.codecs {
+		if codec.Is(c) {
+			r.codecs[i] = c
+			return
+		}
+	}
+	r.codecs = append(r.codecs, c)
+}
+
+// Codecs returns the supported codecs, most preferred first
+func (r *Registry) Codecs() []Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Codec(nil), r.codecs...)
+}
+
+// Lookup returns the supported codec with the given name and clock rate
+func (r *Registry) Lookup(name string, clockRate uint32) (Codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, codec := range r.codecs {
+		if strings.EqualFold(codec.Name, name) && codec.ClockRate == clockRate {
+			return codec, true
+		}
+	}
+	return Codec{}, false
+}
+
+// Formats assigns payload types to the supported codecs for an offer:
+// static ones where RFC 3551 has them, and dynamic ones otherwise
+func (r *Registry) Formats() []Format {
+	var formats []Format
+	static := make([]uint8, 0, len(StaticPayloadTypes))
+	for pt := range StaticPayloadTypes {
+		static = append(static, pt)
+	}
+	sort.Slice(static, func(i, j int) bool { return static[i] < static[j] })
+	dynamic := uint8(FirstDynamicPayloadType)
+	for _, codec := range r.Codecs() {
+		format := Format{Codec: codec}
+		assigned := false
+		for _, pt := range static {
+			if StaticPayloadTypes[pt].Is(codec) {
+				format.PayloadType, assigned = pt, true
+				break
+			}
+		}
+		if !assigned {
+			if dynamic > 127 {
+				continue
+			}
+			format.PayloadType = dynamic
+			dynamic++
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// Offer adds every supported codec to the media section
+func (r *Registry) Offer(m *Media) {
+	for _, format := range r.Formats() {
+		m.AddFormat(format)
+	}
+}
+
+// Negotiate returns the formats of an offered media section that are
+// supported, in the offer's order of preference and with its payload
+// types, as the answer must use them
+func (r *Registry) Negotiate(offer *Media) []Format {
+	supported := r.Codecs()
+	var formats []Format
+	for _, format := range offer.Codecs() {
+		for _, codec := range supported {
+			if codec.Is(format.Codec) {
+				formats = append(formats, format)
+				break
+			}
+		}
+	}
+	return formats
+}
+
+// Answer returns the media section answering offer with the supported
+// formats, or with port 0, rejecting it, when none are
+func (r *Registry) Answer(offer *Media, port int) *Media {
+	answer := &Media{Type: offer.Type, Port: port, Protocol: offer.Protocol}
+	formats := r.Negotiate(offer)
+	if len(formats) == 0 {
+		answer.Port = 0
+		answer.Formats = append([]string(nil), offer.Formats...)
+		return answer
+	}
+	for _, format := range formats {
+		answer.AddFormat(format)
+	}
+	return answer
+}
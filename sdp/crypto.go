@@ -0,0 +1,156 @@
+package sdp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AttrCrypto is the SDES (RFC 4568) crypto attribute
+const AttrCrypto = "crypto"
+
+// SuiteKeySizes gives the master key and master salt lengths, in bytes,
+// of the crypto suites slurp knows how to generate keys for
+var SuiteKeySizes = map[string][2]int{
+	"AES_CM_128_HMAC_SHA1_80": {16, 14},
+	"AES_CM_128_HMAC_SHA1_32": {16, 14},
+	"AES_256_CM_HMAC_SHA1_80": {32, 14},
+	"AES_256_CM_HMAC_SHA1_32": {32, 14},
+	"AEAD_AES_128_GCM":        {16, 12},
+	"AEAD_AES_256_GCM":        {32, 12},
+}
+
+// KeyParam is one inline key of a crypto attribute
+type KeyParam struct {
+	// The master key and master salt, concatenated
+	KeySalt []byte
+	// Optional, e.g. 2^20
+	Lifetime string
+	// Optional, in the form value:length
+	MKI string
+}
+
+// Crypto is an SDES crypto attribute, offering keys for one crypto suite
+type Crypto struct {
+	Tag           int
+	Suite         string
+	Keys          []KeyParam
+	SessionParams []string
+}
+
+// NewCrypto creates a crypto attribute for the suite with fresh random key material
+func NewCrypto(tag int, suite string) (Crypto, error) {
+	sizes, ok := SuiteKeySizes[suite]
+	if !ok {
+		return Crypto{}, fmt.Errorf("unsupported crypto suite %s", suite)
+	}
+	key := make([]byte, sizes[0]+sizes[1])
+	if _, err := rand.Read(key); err != nil {
+		return Crypto{}, err
+	}
+	return Crypto{Tag: tag, Suite: suite, Keys: []KeyParam{{KeySalt: key}}}, nil
+}
+
+// ParseCrypto parses the value of an a=crypto attribute
+func ParseCrypto(value string) (c Crypto, err error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return c, ParseError{Line: value, Reason: "malformed crypto attribute"}
+	}
+	if c.Tag, err = strconv.Atoi(fields[0]); err != nil {
+		return c, ParseError{Line: value, Reason: "invalid tag"}
+	}
+	c.Suite = fields[1]
+	// multiple keys are separated by semicolons
+	for _, param := range strings.Split(fields[2], ";") {
+		if !strings.HasPrefix(param, "inline:") {
+			return c, ParseError{Line: value, Reason: "only inline keys are supported"}
+		}
+		parts := strings.Split(strings.TrimPrefix(param, "inline:"), "|")
+		key := KeyParam{}
+		if key.KeySalt, err = base64.StdEncoding.DecodeString(parts[0]); err != nil {
+			// some implementations leave out the base64 padding
+			if key.KeySalt, err = base64.RawStdEncoding.DecodeString(parts[0]); err != nil {
+				return c, ParseError{Line: value, Reason: "invalid key"}
+			}
+		}
+		for _, extra := range parts[1:] {
+			if strings.Contains(extra, ":") {
+				key.MKI = extra
+			} else {
+				key.Lifetime = extra
+			}
+		}
+		c.Keys = append(c.Keys, key)
+	}
+	c.SessionParams = fields[3:]
+	return c, nil
+}
+
+// String returns the crypto as the value of an a=crypto attribute
+func (c Crypto) String() string {
+	var keys []string
+	for _, key := range c.Keys {
+		param := "inline:" + base64.StdEncoding.EncodeToString(key.KeySalt)
+		if key.Lifetime != "" {
+			param += "|" + key.Lifetime
+		}
+		if key.MKI != "" {
+			param += "|" + key.MKI
+		}
+		keys = append(keys, param)
+	}
+	return strings.Join(append([]string{strconv.Itoa(c.Tag), c.Suite, strings.Join(keys, ";")}, c.SessionParams...), " ")
+}
+
+// MasterKey returns the master key of the first key, split from the salt
+// according to the suite. It is nil for unknown suites
+func (c Crypto) MasterKey() []byte {
+	sizes, ok := SuiteKeySizes[c.Suite]
+	if !ok || len(c.Keys) == 0 || len(c.Keys[0].KeySalt) != sizes[0]+sizes[1] {
+		return nil
+	}
+	return c.Keys[0].KeySalt[:sizes[0]]
+}
+
+// MasterSalt returns the master salt of the first key. It is nil for unknown suites
+func (c Crypto) MasterSalt() []byte {
+	sizes, ok := SuiteKeySizes[c.Suite]
+	if !ok || len(c.Keys) == 0 || len(c.Keys[0].KeySalt) != sizes[0]+sizes[1] {
+		return nil
+	}
+	return c.Keys[0].KeySalt[sizes[0]:]
+}
+
+// Cryptos returns the crypto attributes of the media section
+func (m *Media) Cryptos() ([]Crypto, error) {
+	var cryptos []Crypto
+	for _, value := range m.Attributes.GetAll(AttrCrypto) {
+		crypto, err := ParseCrypto(value)
+		if err != nil {
+			return nil, err
+		}
+		cryptos = append(cryptos, crypto)
+	}
+	return cryptos, nil
+}
+
+// AddCrypto adds a crypto attribute to the media section
+func (m *Media) AddCrypto(c Crypto) {
+	m.Attributes = append(m.Attributes, Attribute{Key: AttrCrypto, Value: c.String()})
+}
+
+// SelectCrypto picks the first offered crypto whose suite is in supported,
+// which is how an answerer chooses. It returns false when none match
+func SelectCrypto(offered []Crypto, supported []string) (Crypto, bool) {
+	for _, crypto := range offered {
+		for _, suite := range supported {
+			if crypto.Suite == suite && crypto.MasterKey() != nil {
+				return crypto, true
+			}
+		}
+	}
+	return Crypto{}, false
+}
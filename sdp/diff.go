@@ -0,0 +1,149 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChangeKind is what changed in a media section between two session
+// descriptions
+type ChangeKind int
+
+const (
+	// AddressChanged is a new connection address, e.g. after a handover,
+	// or 0.0.0.0 for an RFC 2543 hold
+	AddressChanged ChangeKind = iota
+	PortChanged
+	// CodecsChanged is a change of the formats offered or their order
+	CodecsChanged
+	// DirectionChanged is a change of sendrecv, sendonly, recvonly or
+	// inactive, e.g. when the call is put on hold
+	DirectionChanged
+	MediaAdded
+	MediaRemoved
+)
+
+var changeNames = []string{"address", "port", "codecs", "direction", "media added", "media removed"}
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeNames) {
+		return changeNames[k]
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change is one difference between two session descriptions
+type Change struct {
+	Kind ChangeKind
+	// Media is the index of the m= section that changed
+	Media int
+	// Old and New are the values before and after, empty for media
+	// added or removed
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("m=%d %s: %q -> %q", c.Media, c.Kind, c.Old, c.New)
+}
+
+// Changes is the differences between two session descriptions, in the
+// order of their media sections
+type Changes []Change
+
+// Has reports whether a change of the given kind is among the changes
+func (c Changes) Has(kind ChangeKind) bool {
+	for _, change := range c {
+		if change.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRebuild reports whether the media streams have to be set up again:
+// anything but a change of direction, which only pauses or resumes them
+func (c Changes) NeedsRebuild() bool {
+	for _, change := range c {
+		if change.Kind != DirectionChanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Direction returns the direction of the media section: its own
+// attribute, that of the session, or sendrecv when neither has one
+func (s *Session) Direction(m *Media) string {
+	for _, attributes := range []Attributes{m.Attributes, s.Attributes} {
+		for _, each := range attributes {
+			switch each.Key {
+			case "sendrecv", "sendonly", "recvonly", "inactive":
+				return each.Key
+			}
+		}
+	}
+	return "sendrecv"
+}
+
+// Address returns the connection address of the media section: its own
+// c= line, or that of the session
+func (s *Session) Address(m *Media) string {
+	if m.Connection != nil {
+		return m.Connection.Address
+	}
+	if s.Connection != nil {
+		return s.Connection.Address
+	}
+	return ""
+}
+
+// codecs describes the formats of the media section by codec, so that
+// renumbered dynamic payload types aren't reported as changes
+func codecs(m *Media) string {
+	resolved := make(map[string]string)
+	for _, format := range m.Codecs() {
+		resolved[strconv.Itoa(int(format.PayloadType))] = strings.ToLower(format.rtpmap())
+	}
+	described := make([]string, len(m.Formats))
+	for i, format := range m.Formats {
+		if codec, ok := resolved[format]; ok {
+			described[i] = codec
+		} else {
+			described[i] = format
+		}
+	}
+	return strings.Join(described, " ")
+}
+
+// Compare reports how offer differs from current, e.g. the session
+// description in use on a dialog and the offer of a re-INVITE. Media
+// sections are matched by position, as offers keep them in place
+func Compare(current, offer *Session) Changes {
+	var changes Changes
+	for i, m := range offer.Media {
+		if i >= len(current.Media) {
+			changes = append(changes, Change{Kind: MediaAdded, Media: i, New: m.Type})
+			continue
+		}
+		old := current.Media[i]
+		for _, each := range []struct {
+			kind     ChangeKind
+			old, new string
+		}{
+			{AddressChanged, current.Address(old), offer.Address(m)},
+			{PortChanged, fmt.Sprint(old.Port), fmt.Sprint(m.Port)},
+			{CodecsChanged, codecs(old), codecs(m)},
+			{DirectionChanged, current.Direction(old), offer.Direction(m)},
+		} {
+			if each.old != each.new {
+				changes = append(changes, Change{Kind: each.kind, Media: i, Old: each.old, New: each.new})
+			}
+		}
+	}
+	for i := len(offer.Media); i < len(current.Media); i++ {
+		changes = append(changes, Change{Kind: MediaRemoved, Media: i, Old: current.Media[i].Type})
+	}
+	return changes
+}
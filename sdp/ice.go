@@ -0,0 +1,143 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ICE attribute names (RFC 8839)
+const (
+	AttrCandidate       = "candidate"
+	AttrIceUfrag        = "ice-ufrag"
+	AttrIcePwd          = "ice-pwd"
+	AttrEndOfCandidates = "end-of-candidates"
+)
+
+// Candidate types
+const (
+	HostCandidate            = "host"
+	ServerReflexiveCandidate = "srflx"
+	PeerReflexiveCandidate   = "prflx"
+	RelayCandidate           = "relay"
+)
+
+// Candidate is an ICE candidate, from an a=candidate attribute
+type Candidate struct {
+	Foundation string
+	Component  int
+	Transport  string
+	Priority   uint32
+	Address    string
+	Port       int
+	Type       string
+	// Only present for reflexive and relayed candidates
+	RelatedAddress string
+	RelatedPort    int
+	// Extension attributes, e.g. generation or tcptype, in order
+	Extensions [][2]string
+}
+
+// ParseCandidate parses the value of an a=candidate attribute
+func ParseCandidate(value string) (c Candidate, err error) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return c, ParseError{Line: value, Reason: "malformed candidate"}
+	}
+	c.Foundation = fields[0]
+	if c.Component, err = strconv.Atoi(fields[1]); err != nil {
+		return c, ParseError{Line: value, Reason: "invalid component"}
+	}
+	c.Transport = fields[2]
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return c, ParseError{Line: value, Reason: "invalid priority"}
+	}
+	c.Priority = uint32(priority)
+	c.Address = fields[4]
+	if c.Port, err = strconv.Atoi(fields[5]); err != nil {
+		return c, ParseError{Line: value, Reason: "invalid port"}
+	}
+	c.Type = fields[7]
+	rest := fields[8:]
+	for len(rest) >= 2 {
+		switch rest[0] {
+		case "raddr":
+			c.RelatedAddress = rest[1]
+		case "rport":
+			if c.RelatedPort, err = strconv.Atoi(rest[1]); err != nil {
+				return c, ParseError{Line: value, Reason: "invalid rport"}
+			}
+		default:
+			c.Extensions = append(c.Extensions, [2]string{rest[0], rest[1]})
+		}
+		rest = rest[2:]
+	}
+	return c, nil
+}
+
+// String returns the candidate as the value of an a=candidate attribute
+func (c Candidate) String() string {
+	result := fmt.Sprintf("%s %d %s %d %s %d typ %s",
+		c.Foundation, c.Component, c.Transport, c.Priority, c.Address, c.Port, c.Type)
+	if c.RelatedAddress != "" {
+		result += fmt.Sprintf(" raddr %s rport %d", c.RelatedAddress, c.RelatedPort)
+	}
+	for _, extension := range c.Extensions {
+		result += " " + extension[0] + " " + extension[1]
+	}
+	return result
+}
+
+// Candidates returns the ICE candidates of the media section
+func (m *Media) Candidates() ([]Candidate, error) {
+	var candidates []Candidate
+	for _, value := range m.Attributes.GetAll(AttrCandidate) {
+		candidate, err := ParseCandidate(value)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// AddCandidate adds a candidate to the media section
+func (m *Media) AddCandidate(c Candidate) {
+	m.Attributes = append(m.Attributes, Attribute{Key: AttrCandidate, Value: c.String()})
+}
+
+// EndOfCandidates reports whether all candidates of the media section were gathered
+func (m *Media) EndOfCandidates() bool {
+	return m.Attributes.Has(AttrEndOfCandidates)
+}
+
+// SetEndOfCandidates marks the media section as having all its candidates
+func (m *Media) SetEndOfCandidates() {
+	if !m.EndOfCandidates() {
+		m.Attributes = append(m.Attributes, Attribute{Key: AttrEndOfCandidates})
+	}
+}
+
+// ICECredentials returns the ICE username fragment and password for a
+// media section. Media level attributes take precedence over session level
+func (s *Session) ICECredentials(m *Media) (ufrag string, pwd string) {
+	ufrag, _ = s.Attributes.Get(AttrIceUfrag)
+	pwd, _ = s.Attributes.Get(AttrIcePwd)
+	if value, ok := m.Attributes.Get(AttrIceUfrag); ok {
+		ufrag = value
+	}
+	if value, ok := m.Attributes.Get(AttrIcePwd); ok {
+		pwd = value
+	}
+	return
+}
+
+// SetICECredentials sets the ICE username fragment and password at session level
+func (s *Session) SetICECredentials(ufrag, pwd string) {
+	s.Attributes = s.Attributes.Remove(AttrIceUfrag).Remove(AttrIcePwd)
+	s.Attributes = append(s.Attributes,
+		Attribute{Key: AttrIceUfrag, Value: ufrag},
+		Attribute{Key: AttrIcePwd, Value: pwd},
+	)
+}
@@ -0,0 +1,149 @@
+package sdp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// MRCPv2 (RFC 6787) control channels are negotiated in SDP, next to the
+// media they control: an m=application section with the TCP/MRCPv2
+// protocol offers a channel to a resource, and the answer gives the
+// channel its identifier. The a=cmid of the channel is the a=mid of the
+// media section it controls, e.g. the audio a synthesizer speaks into
+const (
+	MRCPProtocol    = "TCP/MRCPv2"
+	MRCPTLSProtocol = "TCP/TLS/MRCPv2"
+)
+
+// The MRCP resource types
+const (
+	SpeechSynth = "speechsynth"
+	SpeechRecog = "speechrecog"
+	BasicSynth  = "basicsynth"
+	DTMFRecog   = "dtmfrecog"
+	Recorder    = "recorder"
+	SpeakVerify = "speakverify"
+)
+
+// MRCPChannel is the control channel an m=application section negotiates
+type MRCPChannel struct {
+	// Resource is the type of resource offered, e.g. speechsynth
+	Resource string
+	// Channel is the identifier the answer assigns, e.g.
+	// 32AECB234338@speechsynth, empty in the offer
+	Channel string
+	// Setup is active or passive, which side connects (RFC 4145)
+	Setup string
+	// Connection is new, or existing to share a TCP connection
+	Connection string
+	// CMID is the mid of the media section the channel controls
+	CMID string
+	Port int
+	TLS  bool
+}
+
+// IsMRCP reports whether the media section negotiates an MRCP channel
+func (m *Media) IsMRCP() bool {
+	return m.Type == "application" && (m.Protocol == MRCPProtocol || m.Protocol == MRCPTLSProtocol)
+}
+
+// MRCP returns the control channel the media section negotiates
+func (m *Media) MRCP() (MRCPChannel, error) {
+	if !m.IsMRCP() {
+		return MRCPChannel{}, ParseError{Line: "m=" + m.Type + " " + m.Protocol, Reason: "not an MRCP channel"}
+	}
+	c := MRCPChannel{Port: m.Port, TLS: m.Protocol == MRCPTLSProtocol}
+	c.Resource, _ = m.Attributes.Get("resource")
+	c.Channel, _ = m.Attributes.Get("channel")
+	c.Setup, _ = m.Attributes.Get("setup")
+	c.Connection, _ = m.Attributes.Get("connection")
+	c.CMID, _ = m.Attributes.Get("cmid")
+	if c.Resource == "" && c.Channel != "" {
+		c.Resource = c.Channel[strings.LastIndex(c.Channel, "@")+1:]
+	}
+	if c.Resource == "" {
+		return c, ParseError{Line: "m=application " + m.Protocol, Reason: "MRCP channel without a resource"}
+	}
+	return c, nil
+}
+
+// NewMRCPOffer creates the m=application section offering a channel to
+// resource, controlling the media section with the mid cmid. The client
+// connects to the server, on a new connection unless existing
+func NewMRCPOffer(resource, cmid string, existing bool) *Media {
+	connection := "new"
+	if existing {
+		connection = "existing"
+	}
+	return &Media{
+		Type: "application",
+		// the client connects, so has no port to listen on
+		Port:     9,
+		Protocol: MRCPProtocol,
+		Formats:  []string{"1"},
+		Attributes: Attributes{
+			{Key: "setup", Value: "active"},
+			{Key: "connection", Value: connection},
+			{Key: "resource", Value: resource},
+			{Key: "cmid", Value: cmid},
+		},
+	}
+}
+
+// NewChannelID returns a new channel identifier for resource, as an MRCP
+// server assigns them, e.g. 32AECB234338@speechsynth
+func NewChannelID(resource string) string {
+	data := make([]byte, 6)
+	rand.Read(data)
+	return strings.ToUpper(hex.EncodeToString(data)) + "@" + resource
+}
+
+// MRCPAnswer creates the m=application section answering an offered
+// channel with the identifier channel, listening for its connection on
+// port. A port of 0 rejects the channel, e.g. for a resource the server
+// doesn't have
+func MRCPAnswer(offer *Media, port int, channel string) *Media {
+	answer := &Media{Type: "application", Port: port, Protocol: offer.Protocol, Formats: offer.Formats}
+	if port == 0 {
+		return answer
+	}
+	connection, _ := offer.Attributes.Get("connection")
+	if connection == "" {
+		connection = "new"
+	}
+	cmid, _ := offer.Attributes.Get("cmid")
+	answer.Attributes = Attributes{
+		{Key: "setup", Value: "passive"},
+		{Key: "connection", Value: connection},
+		{Key: "channel", Value: channel},
+		{Key: "cmid", Value: cmid},
+	}
+	return answer
+}
+
+// MediaByMid returns the media section with the given a=mid, e.g. the
+// one an MRCP channel's cmid refers to, or nil
+func (s *Session) MediaByMid(mid string) *Media {
+	for _, media := range s.Media {
+		if value, ok := media.Attributes.Get("mid"); ok && value == mid {
+			return media
+		}
+	}
+	return nil
+}
+
+// MRCPChannels returns the control channels the session negotiates,
+// skipping rejected ones
+func (s *Session) MRCPChannels() []MRCPChannel {
+	var channels []MRCPChannel
+	for _, media := range s.Media {
+		if !media.IsMRCP() || media.Port == 0 {
+			continue
+		}
+		if channel, err := media.MRCP(); err == nil {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
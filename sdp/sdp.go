@@ -0,0 +1,268 @@
+/*
+Package sdp parses and renders session descriptions (RFC 4566), the
+bodies SIP uses to negotiate media in offers and answers.
+*/
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseError indicates a line of a session description could not be parsed
+type ParseError struct {
+	Line   string
+	Reason string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("Invalid SDP line %q: %s", e.Line, e.Reason)
+}
+
+// Origin is the o= line, identifying the session and its version
+type Origin struct {
+	Username       string
+	SessionId      string
+	SessionVersion string
+	NetType        string
+	AddrType       string
+	Address        string
+}
+
+// Connection is a c= line
+type Connection struct {
+	NetType  string
+	AddrType string
+	Address  string
+}
+
+// Attribute is an a= line. Flag attributes, e.g. a=sendrecv, have no Value
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attributes is an ordered list of attributes, which may repeat
+type Attributes []Attribute
+
+// Get returns the value of the first attribute with the given key
+func (a Attributes) Get(key string) (string, bool) {
+	for _, each := range a {
+		if each.Key == key {
+			return each.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns the values of every attribute with the given key
+func (a Attributes) GetAll(key string) (values []string) {
+	for _, each := range a {
+		if each.Key == key {
+			values = append(values, each.Value)
+		}
+	}
+	return
+}
+
+// Has reports whether an attribute with the given key is present
+func (a Attributes) Has(key string) bool {
+	_, ok := a.Get(key)
+	return ok
+}
+
+// Remove returns the attributes without any with the given key
+func (a Attributes) Remove(key string) Attributes {
+	var kept Attributes
+	for _, each := range a {
+		if each.Key != key {
+			kept = append(kept, each)
+		}
+	}
+	return kept
+}
+
+// Media is an m= section
+type Media struct {
+	Type       string
+	Port       int
+	PortCount  int
+	Protocol   string
+	Formats    []string
+	Connection *Connection
+	Bandwidth  []string
+	Attributes Attributes
+}
+
+// Session is a complete session description
+type Session struct {
+	Version    int
+	Origin     Origin
+	Name       string
+	Connection *Connection
+	Bandwidth  []string
+	Timing     string
+	// Lines the model doesn't cover (i=, u=, e=, p=, r=, z=, k=), kept as
+	// type=value and rendered after the session name
+	Other      []string
+	Attributes Attributes
+	Media      []*Media
+}
+
+// Parse reads a session description
+func Parse(text string) (*Session, error) {
+	s := &Session{}
+	var media *Media
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != '=' {
+			return nil, ParseError{Line: line, Reason: "expected type=value"}
+		}
+		value := line[2:]
+		var err error
+		switch line[0] {
+		case 'v':
+			s.Version, err = strconv.Atoi(value)
+		case 'o':
+			fields := strings.Fields(value)
+			if len(fields) != 6 {
+				return nil, ParseError{Line: line, Reason: "origin needs 6 fields"}
+			}
+			s.Origin = Origin{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]}
+		case 's':
+			s.Name = value
+		case 'c':
+			var c *Connection
+			c, err = parseConnection(value)
+			if media != nil {
+				media.Connection = c
+			} else {
+				s.Connection = c
+			}
+		case 'b':
+			if media != nil {
+				media.Bandwidth = append(media.Bandwidth, value)
+			} else {
+				s.Bandwidth = append(s.Bandwidth, value)
+			}
+		case 't':
+			s.Timing = value
+		case 'a':
+			attribute := parseAttribute(value)
+			if media != nil {
+				media.Attributes = append(media.Attributes, attribute)
+			} else {
+				s.Attributes = append(s.Attributes, attribute)
+			}
+		case 'm':
+			media, err = parseMedia(value)
+			if err == nil {
+				s.Media = append(s.Media, media)
+			}
+		default:
+			s.Other = append(s.Other, line)
+		}
+		if err != nil {
+			return nil, ParseError{Line: line, Reason: err.Error()}
+		}
+	}
+	return s, nil
+}
+
+func parseConnection(value string) (*Connection, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("connection needs 3 fields")
+	}
+	return &Connection{fields[0], fields[1], fields[2]}, nil
+}
+
+func parseAttribute(value string) Attribute {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 1 {
+		return Attribute{Key: parts[0]}
+	}
+	return Attribute{Key: parts[0], Value: parts[1]}
+}
+
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("media needs at least 3 fields")
+	}
+	m := &Media{Type: fields[0], Protocol: fields[2], Formats: fields[3:]}
+	// the port may be followed by a port count, e.g. 49170/2
+	ports := strings.SplitN(fields[1], "/", 2)
+	var err error
+	if m.Port, err = strconv.Atoi(ports[0]); err != nil {
+		return nil, err
+	}
+	if len(ports) > 1 {
+		if m.PortCount, err = strconv.Atoi(ports[1]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (c *Connection) String() string {
+	return c.NetType + " " + c.AddrType + " " + c.Address
+}
+
+func (a Attribute) String() string {
+	if a.Value == "" {
+		return a.Key
+	}
+	return a.Key + ":" + a.Value
+}
+
+// Render returns the session description in wire format
+func (s *Session) Render() string {
+	lines := []string{
+		fmt.Sprintf("v=%d", s.Version),
+		fmt.Sprintf("o=%s %s %s %s %s %s", s.Origin.Username, s.Origin.SessionId,
+			s.Origin.SessionVersion, s.Origin.NetType, s.Origin.AddrType, s.Origin.Address),
+		"s=" + s.Name,
+	}
+	lines = append(lines, s.Other...)
+	if s.Connection != nil {
+		lines = append(lines, "c="+s.Connection.String())
+	}
+	for _, bandwidth := range s.Bandwidth {
+		lines = append(lines, "b="+bandwidth)
+	}
+	timing := s.Timing
+	if timing == "" {
+		timing = "0 0"
+	}
+	lines = append(lines, "t="+timing)
+	for _, attribute := range s.Attributes {
+		lines = append(lines, "a="+attribute.String())
+	}
+	for _, m := range s.Media {
+		lines = append(lines, m.render()...)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func (m *Media) render() []string {
+	port := strconv.Itoa(m.Port)
+	if m.PortCount > 0 {
+		port += "/" + strconv.Itoa(m.PortCount)
+	}
+	lines := []string{"m=" + strings.Join(append([]string{m.Type, port, m.Protocol}, m.Formats...), " ")}
+	if m.Connection != nil {
+		lines = append(lines, "c="+m.Connection.String())
+	}
+	for _, bandwidth := range m.Bandwidth {
+		lines = append(lines, "b="+bandwidth)
+	}
+	for _, attribute := range m.Attributes {
+		lines = append(lines, "a="+attribute.String())
+	}
+	return lines
+}
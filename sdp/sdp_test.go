@@ -0,0 +1,208 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var example = strings.Join([]string{
+	"v=0",
+	"o=jdoe 2890844526 2890842807 IN IP4 10.47.16.5",
+	"s=-",
+	"c=IN IP4 10.47.16.5",
+	"t=0 0",
+	"a=ice-ufrag:8hhY",
+	"a=ice-pwd:asd88fgpdd777uzjYhagZg",
+	"m=audio 49170 RTP/AVP 0 101",
+	"a=rtpmap:101 telephone-event/8000",
+	"a=candidate:1 1 UDP 2130706431 10.47.16.5 49170 typ host",
+	"a=candidate:2 1 UDP 1694498815 192.0.2.3 45664 typ srflx raddr 10.47.16.5 rport 49170 generation 0",
+	"a=end-of-candidates",
+	"a=sendrecv",
+}, "\r\n") + "\r\n"
+
+func TestParseRender(t *testing.T) {
+	session, err := Parse(example)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.47.16.5", session.Connection.Address)
+	assert.Equal(t, "2890844526", session.Origin.SessionId)
+	if assert.Equal(t, 1, len(session.Media)) {
+		media := session.Media[0]
+		assert.Equal(t, 49170, media.Port)
+		assert.Equal(t, []string{"0", "101"}, media.Formats)
+		assert.True(t, media.Attributes.Has("sendrecv"))
+	}
+	assert.Equal(t, example, session.Render())
+	_, err = Parse("v=0\r\nbogus\r\n")
+	assert.IsType(t, ParseError{}, err)
+}
+
+func TestICE(t *testing.T) {
+	session, err := Parse(example)
+	assert.Nil(t, err)
+	media := session.Media[0]
+	candidates, err := media.Candidates()
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(candidates)) {
+		assert.Equal(t, HostCandidate, candidates[0].Type)
+		assert.Equal(t, uint32(1694498815), candidates[1].Priority)
+		assert.Equal(t, "10.47.16.5", candidates[1].RelatedAddress)
+		assert.Equal(t, 49170, candidates[1].RelatedPort)
+		assert.Equal(t, [][2]string{{"generation", "0"}}, candidates[1].Extensions)
+		assert.Equal(t, "2 1 UDP 1694498815 192.0.2.3 45664 typ srflx raddr 10.47.16.5 rport 49170 generation 0", candidates[1].String())
+	}
+	assert.True(t, media.EndOfCandidates())
+	ufrag, pwd := session.ICECredentials(media)
+	assert.Equal(t, "8hhY", ufrag)
+	assert.Equal(t, "asd88fgpdd777uzjYhagZg", pwd)
+	media.Attributes = append(media.Attributes, Attribute{Key: AttrIceUfrag, Value: "media"})
+	ufrag, _ = session.ICECredentials(media)
+	assert.Equal(t, "media", ufrag)
+}
+
+func TestCrypto(t *testing.T) {
+	value := "1 AES_CM_128_HMAC_SHA1_80 inline:PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR|2^20|1:32 KDR=1"
+	crypto, err := ParseCrypto(value)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, crypto.Tag)
+	assert.Equal(t, "2^20", crypto.Keys[0].Lifetime)
+	assert.Equal(t, "1:32", crypto.Keys[0].MKI)
+	assert.Equal(t, []string{"KDR=1"}, crypto.SessionParams)
+	assert.Equal(t, 16, len(crypto.MasterKey()))
+	assert.Equal(t, 14, len(crypto.MasterSalt()))
+	assert.Equal(t, value, crypto.String())
+
+	generated, err := NewCrypto(2, "AES_256_CM_HMAC_SHA1_80")
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(generated.MasterKey()))
+	media := &Media{}
+	media.AddCrypto(generated)
+	media.AddCrypto(crypto)
+	offered, err := media.Cryptos()
+	assert.Nil(t, err)
+	selected, ok := SelectCrypto(offered, []string{"AES_CM_128_HMAC_SHA1_80"})
+	assert.True(t, ok)
+	assert.Equal(t, 1, selected.Tag)
+	_, err = NewCrypto(1, "NULL")
+	assert.NotNil(t, err)
+}
+
+func TestMRCP(t *testing.T) {
+	session, err := Parse(example)
+	assert.Nil(t, err)
+	session.Media[0].Attributes = append(session.Media[0].Attributes, Attribute{Key: "mid", Value: "1"})
+	session.Media = append(session.Media, NewMRCPOffer(SpeechSynth, "1", false))
+	parsed, err := Parse(session.Render())
+	assert.Nil(t, err)
+	offer := parsed.Media[len(parsed.Media)-1]
+	assert.True(t, offer.IsMRCP())
+	channel, err := offer.MRCP()
+	assert.Nil(t, err)
+	assert.Equal(t, MRCPChannel{Resource: SpeechSynth, Setup: "active", Connection: "new", CMID: "1", Port: 9}, channel)
+	assert.Equal(t, "audio", parsed.MediaByMid(channel.CMID).Type)
+
+	id := NewChannelID(SpeechSynth)
+	assert.Regexp(t, "^[0-9A-F]{12}@speechsynth$", id)
+	answer := MRCPAnswer(offer, 1544, id)
+	channel, err = answer.MRCP()
+	assert.Nil(t, err)
+	assert.Equal(t, MRCPChannel{Resource: SpeechSynth, Channel: id, Setup: "passive", Connection: "new", CMID: "1", Port: 1544}, channel)
+	assert.Equal(t, 1, len((&Session{Media: []*Media{answer, MRCPAnswer(offer, 0, "")}}).MRCPChannels()))
+	_, err = session.Media[0].MRCP()
+	assert.NotNil(t, err)
+}
+
+func TestT38(t *testing.T) {
+	media := NewT38Media(49170, T38Params{MaxBitRate: 14400, RateManagement: TransferredTCF, MaxBuffer: 262, ErrorCorrection: T38FEC, FillBitRemoval: true})
+	session, err := Parse(example)
+	assert.Nil(t, err)
+	session.Media = []*Media{media}
+	parsed, err := Parse(session.Render())
+	assert.Nil(t, err)
+	assert.True(t, parsed.Media[0].IsT38())
+	params, err := parsed.Media[0].T38()
+	assert.Nil(t, err)
+	assert.Equal(t, T38Params{MaxBitRate: 14400, RateManagement: TransferredTCF, MaxBuffer: 262, ErrorCorrection: T38FEC, FillBitRemoval: true}, params)
+
+	// older implementations set flags to 1, and vary the case
+	legacy := &Media{Type: "image", Protocol: "UDPTL", Attributes: Attributes{{Key: "t38faxversion", Value: "1"}, {Key: AttrT38TranscodingMMR, Value: "1"}}}
+	params, err = legacy.T38()
+	assert.Nil(t, err)
+	assert.Equal(t, T38Params{Version: 1, TranscodingMMR: true}, params)
+
+	answer := AnswerT38(media, 5004, T38Params{MaxBitRate: 9600, MaxDatagram: 160})
+	params, err = answer.T38()
+	assert.Nil(t, err)
+	assert.Equal(t, T38Params{MaxBitRate: 9600, RateManagement: TransferredTCF, MaxDatagram: 160, ErrorCorrection: T38Redundancy}, params)
+	assert.Equal(t, 0, AnswerT38(&Media{Type: "audio", Protocol: "RTP/AVP"}, 5004, DefaultT38Params).Port)
+
+	audio, err := Parse(example)
+	assert.Nil(t, err)
+	fax, ok := audio.WithT38(DefaultT38Params)
+	assert.True(t, ok)
+	assert.True(t, fax.Media[0].IsT38())
+	assert.Equal(t, 49170, fax.Media[0].Port)
+	assert.Equal(t, "2890842808", fax.Origin.SessionVersion)
+	assert.Equal(t, "audio", audio.Media[0].Type)
+	_, ok = fax.WithT38(DefaultT38Params)
+	assert.False(t, ok)
+}
+
+func TestCompare(t *testing.T) {
+	current, err := Parse(example)
+	assert.Nil(t, err)
+	offer, err := Parse(example)
+	assert.Nil(t, err)
+	assert.Empty(t, Compare(current, offer))
+
+	// renumbering telephone-event and going on hold only changes the direction
+	media := offer.Media[0]
+	media.Formats = []string{"0", "96"}
+	media.Attributes = media.Attributes.Remove("rtpmap").Remove("sendrecv")
+	media.Attributes = append(media.Attributes, Attribute{Key: "rtpmap", Value: "96 telephone-event/8000"}, Attribute{Key: "sendonly"})
+	changes := Compare(current, offer)
+	assert.Equal(t, Changes{{Kind: DirectionChanged, Media: 0, Old: "sendrecv", New: "sendonly"}}, changes)
+	assert.False(t, changes.NeedsRebuild())
+
+	media.Port = 50000
+	media.Connection = &Connection{"IN", "IP4", "192.0.2.7"}
+	offer.Media = append(offer.Media, &Media{Type: "video", Port: 51372, Protocol: "RTP/AVP", Formats: []string{"31"}})
+	changes = Compare(current, offer)
+	assert.True(t, changes.Has(AddressChanged))
+	assert.True(t, changes.Has(PortChanged))
+	assert.True(t, changes.Has(MediaAdded))
+	assert.False(t, changes.Has(CodecsChanged))
+	assert.True(t, changes.NeedsRebuild())
+
+	changes = Compare(offer, current)
+	assert.Equal(t, Change{Kind: MediaRemoved, Media: 1, Old: "video"}, changes[len(changes)-1])
+}
+
+func TestCodecs(t *testing.T) {
+	offer := &Media{Type: "audio", Port: 49170, Protocol: "RTP/AVP"}
+	DefaultRegistry.Offer(offer)
+	assert.Equal(t, []string{"96", "9", "0", "8", "97"}, offer.Formats)
+	assert.Equal(t, "m=audio 49170 RTP/AVP 96 9 0 8 97\r\na=rtpmap:96 opus/48000/2\r\na=rtpmap:9 G722/8000\r\n"+
+		"a=rtpmap:0 PCMU/8000\r\na=rtpmap:8 PCMA/8000\r\na=rtpmap:97 telephone-event/8000\r\na=fmtp:97 0-16",
+		strings.Join(offer.render(), "\r\n"))
+
+	session, err := Parse(example)
+	assert.Nil(t, err)
+	received := session.Media[0]
+	assert.Equal(t, []Format{{PayloadType: 0, Codec: PCMU}, {PayloadType: 101, Codec: Codec{Name: "telephone-event", ClockRate: 8000}}}, received.Codecs())
+
+	// the answer keeps the offer's order and payload types
+	narrowband := NewRegistry(PCMA, TelephoneEvent, PCMU)
+	answer := narrowband.Answer(received, 30000)
+	assert.Equal(t, []string{"0", "101"}, answer.Formats)
+	assert.Equal(t, 30000, answer.Port)
+
+	rejected := NewRegistry(Opus).Answer(received, 30000)
+	assert.Equal(t, 0, rejected.Port)
+
+	codec, ok := DefaultRegistry.Lookup("pcmu", 8000)
+	assert.True(t, ok)
+	assert.Equal(t, PCMU, codec)
+}
@@ -0,0 +1,214 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// T38Protocol is the transport of T.38 fax relay media, m=image udptl t38
+const T38Protocol = "udptl"
+
+// The T.38 attributes (ITU-T T.38 Annex D, RFC 3362)
+const (
+	AttrT38Version         = "T38FaxVersion"
+	AttrT38MaxBitRate      = "T38MaxBitRate"
+	AttrT38RateManagement  = "T38FaxRateManagement"
+	AttrT38MaxBuffer       = "T38FaxMaxBuffer"
+	AttrT38MaxDatagram     = "T38FaxMaxDatagram"
+	AttrT38ErrorCorrection = "T38FaxUdpEC"
+	AttrT38FillBitRemoval  = "T38FaxFillBitRemoval"
+	AttrT38TranscodingMMR  = "T38FaxTranscodingMMR"
+	AttrT38TranscodingJBIG = "T38FaxTranscodingJBIG"
+)
+
+// The values of T38FaxRateManagement and T38FaxUdpEC
+const (
+	LocalTCF       = "localTCF"
+	TransferredTCF = "transferredTCF"
+	T38Redundancy  = "t38UDPRedundancy"
+	T38FEC         = "t38UDPFEC"
+)
+
+// T38Params are the parameters of a T.38 media section. Zero numbers and
+// empty strings are left out of it
+type T38Params struct {
+	Version        int
+	MaxBitRate     int
+	RateManagement string
+	// MaxBuffer and MaxDatagram are in bytes
+	MaxBuffer       int
+	MaxDatagram     int
+	ErrorCorrection string
+	FillBitRemoval  bool
+	TranscodingMMR  bool
+	TranscodingJBIG bool
+}
+
+// DefaultT38Params are what most gateways offer: version 0 at 14400 bit/s,
+// with the training check transferred and redundancy for error correction
+var DefaultT38Params = T38Params{
+	MaxBitRate:      14400,
+	RateManagement:  TransferredTCF,
+	MaxDatagram:     400,
+	ErrorCorrection: T38Redundancy,
+}
+
+// Attributes returns the parameters as T.38 attributes
+func (p T38Params) Attributes() Attributes {
+	attributes := Attributes{{Key: AttrT38Version, Value: strconv.Itoa(p.Version)}}
+	if p.MaxBitRate > 0 {
+		attributes = append(attributes, Attribute{Key: AttrT38MaxBitRate, Value: strconv.Itoa(p.MaxBitRate)})
+	}
+	if p.RateManagement != "" {
+		attributes = append(attributes, Attribute{Key: AttrT38RateManagement, Value: p.RateManagement})
+	}
+	if p.MaxBuffer > 0 {
+		attributes = append(attributes, Attribute{Key: AttrT38MaxBuffer, Value: strconv.Itoa(p.MaxBuffer)})
+	}
+	if p.MaxDatagram > 0 {
+		attributes = append(attributes, Attribute{Key: AttrT38MaxDatagram, Value: strconv.Itoa(p.MaxDatagram)})
+	}
+	if p.ErrorCorrection != "" {
+		attributes = append(attributes, Attribute{Key: AttrT38ErrorCorrection, Value: p.ErrorCorrection})
+	}
+	for _, flag := range []struct {
+		key string
+		set bool
+	}{{AttrT38FillBitRemoval, p.FillBitRemoval}, {AttrT38TranscodingMMR, p.TranscodingMMR}, {AttrT38TranscodingJBIG, p.TranscodingJBIG}} {
+		if flag.set {
+			attributes = append(attributes, Attribute{Key: flag.key})
+		}
+	}
+	return attributes
+}
+
+// NewT38Media returns an image/t38 media section on port with params
+func NewT38Media(port int, params T38Params) *Media {
+	return &Media{
+		Type:       "image",
+		Port:       port,
+		Protocol:   T38Protocol,
+		Formats:    []string{"t38"},
+		Attributes: params.Attributes(),
+	}
+}
+
+// IsT38 reports whether the media section is T.38 fax relay
+func (m *Media) IsT38() bool {
+	return m.Type == "image" && strings.EqualFold(m.Protocol, T38Protocol)
+}
+
+// t38Flag reads a boolean T.38 attribute, which is set either as a flag
+// or, by older implementations, with the value 1
+func t38Flag(a Attributes, key string) bool {
+	for _, attribute := range a {
+		if strings.EqualFold(attribute.Key, key) {
+			return attribute.Value == "" || attribute.Value == "1"
+		}
+	}
+	return false
+}
+
+// T38 returns the T.38 parameters of the media section. Attribute names
+// are matched without regard to case, as implementations differ
+func (m *Media) T38() (T38Params, error) {
+	var p T38Params
+	if !m.IsT38() {
+		return p, ParseError{Line: "m=" + m.Type + " " + m.Protocol, Reason: "not T.38 media"}
+	}
+	for _, attribute := range m.Attributes {
+		var number *int
+		switch {
+		case strings.EqualFold(attribute.Key, AttrT38Version):
+			number = &p.Version
+		case strings.EqualFold(attribute.Key, AttrT38MaxBitRate):
+			number = &p.MaxBitRate
+		case strings.EqualFold(attribute.Key, AttrT38MaxBuffer):
+			number = &p.MaxBuffer
+		case strings.EqualFold(attribute.Key, AttrT38MaxDatagram):
+			number = &p.MaxDatagram
+		case strings.EqualFold(attribute.Key, AttrT38RateManagement):
+			p.RateManagement = attribute.Value
+		case strings.EqualFold(attribute.Key, AttrT38ErrorCorrection):
+			p.ErrorCorrection = attribute.Value
+		}
+		if number != nil {
+			value, err := strconv.Atoi(attribute.Value)
+			if err != nil {
+				return p, ParseError{Line: attribute.String(), Reason: "expected a number"}
+			}
+			*number = value
+		}
+	}
+	p.FillBitRemoval = t38Flag(m.Attributes, AttrT38FillBitRemoval)
+	p.TranscodingMMR = t38Flag(m.Attributes, AttrT38TranscodingMMR)
+	p.TranscodingJBIG = t38Flag(m.Attributes, AttrT38TranscodingJBIG)
+	return p, nil
+}
+
+func minPositive(a, b int) int {
+	if a > 0 && (b <= 0 || a < b) {
+		return a
+	}
+	return b
+}
+
+// NegotiateT38 returns the parameters answering offered with what local
+// supports (RFC 3362, T.38 Annex D.2.3): the lower version and bit rate,
+// the offered rate management, the offered error correction unless it's
+// FEC local can't do, local's own buffer and datagram sizes, and only the
+// options both support
+func NegotiateT38(offered, local T38Params) T38Params {
+	answer := T38Params{
+		Version:         offered.Version,
+		MaxBitRate:      minPositive(offered.MaxBitRate, local.MaxBitRate),
+		RateManagement:  offered.RateManagement,
+		MaxBuffer:       local.MaxBuffer,
+		MaxDatagram:     local.MaxDatagram,
+		ErrorCorrection: offered.ErrorCorrection,
+		FillBitRemoval:  offered.FillBitRemoval && local.FillBitRemoval,
+		TranscodingMMR:  offered.TranscodingMMR && local.TranscodingMMR,
+		TranscodingJBIG: offered.TranscodingJBIG && local.TranscodingJBIG,
+	}
+	if local.Version < answer.Version {
+		answer.Version = local.Version
+	}
+	if answer.ErrorCorrection == T38FEC && local.ErrorCorrection != T38FEC {
+		answer.ErrorCorrection = T38Redundancy
+	}
+	return answer
+}
+
+// AnswerT38 returns the media section answering offer with what local
+// supports on port, or with port 0, rejecting it, when the offer isn't
+// T.38
+func AnswerT38(offer *Media, port int, local T38Params) *Media {
+	offered, err := offer.T38()
+	if err != nil {
+		return &Media{Type: offer.Type, Protocol: offer.Protocol, Formats: offer.Formats}
+	}
+	return NewT38Media(port, NegotiateT38(offered, local))
+}
+
+// WithT38 returns a copy of the session switching its first audio stream
+// to T.38 with params, on the same port and connection, with the next
+// session version, as a re-INVITE switching a call to fax relay offers.
+// The session itself is left as it is; ok is false when it has no active
+// audio stream
+func (s *Session) WithT38(params T38Params) (offer *Session, ok bool) {
+	copied := *s
+	copied.Media = append([]*Media(nil), s.Media...)
+	for i, media := range copied.Media {
+		if media.Type != "audio" || media.Port == 0 {
+			continue
+		}
+		t38 := NewT38Media(media.Port, params)
+		t38.Connection = media.Connection
+		copied.Media[i] = t38
+		if version, err := strconv.ParseUint(s.Origin.SessionVersion, 10, 64); err == nil {
+			copied.Origin.SessionVersion = strconv.FormatUint(version+1, 10)
+		}
+		return &copied, true
+	}
+	return s, false
+}
@@ -0,0 +1,244 @@
+package slurp
+
+/*
+Security mechanism agreement (RFC 3329) keeps a man in the middle from
+downgrading the security of the first hop. The client lists the
+mechanisms it supports in Security-Client, e.g. tls or ipsec-3gpp as in
+IMS registration, and the server answers with those it supports in
+Security-Server, most often in a 494 or, for IMS, the 401 challenge. The
+client sets up the mechanism of the highest preference both support, and
+echoes the server's list in Security-Verify on the requests it protects,
+so that the server can tell the list wasn't tampered with.
+*/
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// SecAgreeTag is the option tag of security mechanism agreement, in the
+// Require and Proxy-Require of requests negotiating it
+const SecAgreeTag = "sec-agree"
+
+// The security mechanisms of RFC 3329 and 3GPP TS 33.203
+const (
+	MechanismDigest    = "digest"
+	MechanismTLS       = "tls"
+	MechanismIPsecIKE  = "ipsec-ike"
+	MechanismIPsecMan  = "ipsec-man"
+	MechanismIPsec3GPP = "ipsec-3gpp"
+)
+
+// SecurityMechanism is a value of Security-Client, Security-Server or
+// Security-Verify: a mechanism name and its parameters, e.g.
+// ipsec-3gpp;alg=hmac-sha-1-96;spi-c=1111;spi-s=2222;port-c=5062;port-s=5064
+type SecurityMechanism struct {
+	Name string
+	// Params are the raw parameters, name=value or flags, in order
+	Params []string
+}
+
+// ParseSecurityMechanism reads a security mechanism value
+func ParseSecurityMechanism(value string) (SecurityMechanism, error) {
+	parts := strings.Split(value, ";")
+	m := SecurityMechanism{Name: strings.ToLower(strings.TrimSpace(parts[0]))}
+	if m.Name == "" {
+		return m, Violation{Header: "Security-Client", Reason: "missing mechanism name: " + value}
+	}
+	for _, param := range parts[1:] {
+		if param = strings.TrimSpace(param); param != "" {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m, nil
+}
+
+func (m SecurityMechanism) String() string {
+	return strings.Join(append([]string{m.Name}, m.Params...), ";")
+}
+
+// Param returns the value of a parameter, ok being false when absent
+func (m SecurityMechanism) Param(name string) (value string, ok bool) {
+	return viaParam(strings.Join(m.Params, ";"), name)
+}
+
+// Preference is the q parameter, 0.001 when absent, as the lowest
+// preference a server can set
+func (m SecurityMechanism) Preference() float64 {
+	if value, ok := m.Param("q"); ok {
+		if q, err := strconv.ParseFloat(value, 64); err == nil {
+			return q
+		}
+	}
+	return 0.001
+}
+
+func securityMechanisms(m Message, name string) ([]SecurityMechanism, error) {
+	var mechanisms []SecurityMechanism
+	for _, value := range m.Headers().Extensions.GetAll(name) {
+		mechanism, err := ParseSecurityMechanism(value)
+		if err != nil {
+			return nil, Violation{Header: name, Reason: err.(Violation).Reason}
+		}
+		mechanisms = append(mechanisms, mechanism)
+	}
+	return mechanisms, nil
+}
+
+func setSecurityMechanisms(m Message, name string, mechanisms []SecurityMechanism) {
+	if len(mechanisms) == 0 {
+		m.Headers().Extensions.Remove(name)
+		return
+	}
+	values := make([]string, len(mechanisms))
+	for i, mechanism := range mechanisms {
+		values[i] = mechanism.String()
+	}
+	m.Headers().Extensions.Set(name, strings.Join(values, ", "))
+}
+
+// SecurityClient returns the mechanisms of the message's Security-Client
+func SecurityClient(m Message) ([]SecurityMechanism, error) {
+	return securityMechanisms(m, "Security-Client")
+}
+
+// SecurityServer returns the mechanisms of the message's Security-Server
+func SecurityServer(m Message) ([]SecurityMechanism, error) {
+	return securityMechanisms(m, "Security-Server")
+}
+
+// SecurityVerify returns the mechanisms of the message's Security-Verify
+func SecurityVerify(m Message) ([]SecurityMechanism, error) {
+	return securityMechanisms(m, "Security-Verify")
+}
+
+// SetSecurityClient replaces the message's Security-Client, and requires
+// sec-agree of the next hop as RFC 3329 2.3.1 asks
+func SetSecurityClient(m Message, mechanisms ...SecurityMechanism) {
+	setSecurityMechanisms(m, "Security-Client", mechanisms)
+	requireSecAgree(m)
+}
+
+// SetSecurityServer replaces the message's Security-Server
+func SetSecurityServer(m Message, mechanisms ...SecurityMechanism) {
+	setSecurityMechanisms(m, "Security-Server", mechanisms)
+}
+
+// SetSecurityVerify replaces the message's Security-Verify, and requires
+// sec-agree of the next hop
+func SetSecurityVerify(m Message, mechanisms ...SecurityMechanism) {
+	setSecurityMechanisms(m, "Security-Verify", mechanisms)
+	requireSecAgree(m)
+}
+
+// requireSecAgree adds sec-agree to Require and Proxy-Require, unless
+// already there
+func requireSecAgree(m Message) {
+	for _, name := range []string{"Require", "Proxy-Require"} {
+		if !requiresSecAgree(m, name) {
+			m.Headers().Extensions.Add(name, SecAgreeTag)
+		}
+	}
+}
+
+func requiresSecAgree(m Message, name string) bool {
+	for _, tag := range m.Headers().Extensions.GetAll(name) {
+		if strings.EqualFold(tag, SecAgreeTag) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectSecurityMechanism returns the mechanism of server, as listed in
+// Security-Server, of the highest preference that client supports: the
+// one the client must use (RFC 3329 2.3.1)
+func SelectSecurityMechanism(client, server []SecurityMechanism) (SecurityMechanism, bool) {
+	candidates := append([]SecurityMechanism(nil), server...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Preference() > candidates[j].Preference()
+	})
+	for _, candidate := range candidates {
+		for _, supported := range client {
+			if supported.Name == candidate.Name {
+				return candidate, true
+			}
+		}
+	}
+	return SecurityMechanism{}, false
+}
+
+// sameMechanisms reports whether two lists hold the same mechanisms with
+// the same parameters, in the same order
+func sameMechanisms(a, b []SecurityMechanism) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i].String(), b[i].String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifySecurity checks the Security-Verify of a request protected with
+// the agreed mechanism against the Security-Server list sent to the
+// client, which must be identical (RFC 3329 2.3.2)
+func VerifySecurity(request Message, server []SecurityMechanism) bool {
+	verify, err := SecurityVerify(request)
+	return err == nil && sameMechanisms(verify, server)
+}
+
+// AgreeSecurity prepares request, a retry of one answered with response,
+// to be protected with the mechanism agreed: it returns the mechanism of
+// the highest preference in response's Security-Server that the
+// request's Security-Client offers, and sets Security-Verify. It fails
+// when none is, or the response has no Security-Server
+func AgreeSecurity(request Message, response *Response) (SecurityMechanism, error) {
+	server, err := SecurityServer(response)
+	if err != nil {
+		return SecurityMechanism{}, err
+	}
+	client, err := SecurityClient(request)
+	if err != nil {
+		return SecurityMechanism{}, err
+	}
+	mechanism, ok := SelectSecurityMechanism(client, server)
+	if !ok {
+		return mechanism, Violation{Header: "Security-Server", Reason: "no mechanism in common with Security-Client"}
+	}
+	SetSecurityVerify(request, server...)
+	return mechanism, nil
+}
+
+// SecurityAgreement is the server side of security mechanism agreement,
+// on the first hop of its clients
+type SecurityAgreement struct {
+	// Server are the mechanisms supported, sent in Security-Server
+	Server []SecurityMechanism
+}
+
+// Filter answers 494 with Security-Server the requests that require
+// sec-agree without a Security-Verify, and those whose Security-Verify
+// isn't the Security-Server sent, as they may have been tampered with.
+// Other requests go on; checking that they came over the mechanism
+// agreed is up to the transport
+func (s *SecurityAgreement) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		request := in.Message
+		if !requiresSecAgree(request, "Require") && !requiresSecAgree(request, "Proxy-Require") {
+			return nil, true
+		}
+		if request.Headers().Extensions.Get("Security-Verify") != "" && VerifySecurity(request, s.Server) {
+			return nil, true
+		}
+		response := NewResponse(request, 494)
+		SetSecurityServer(response, s.Server...)
+		response.Headers().Extensions.Set("Require", SecAgreeTag)
+		return response, false
+	}
+}
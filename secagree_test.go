@@ -0,0 +1,54 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityAgreement(t *testing.T) {
+	mechanism, err := ParseSecurityMechanism("ipsec-3gpp; alg=hmac-sha-1-96;spi-c=1111;spi-s=2222;port-c=5062;port-s=5064")
+	assert.Nil(t, err)
+	assert.Equal(t, MechanismIPsec3GPP, mechanism.Name)
+	spi, ok := mechanism.Param("spi-s")
+	assert.True(t, ok)
+	assert.Equal(t, "2222", spi)
+	assert.Equal(t, "ipsec-3gpp;alg=hmac-sha-1-96;spi-c=1111;spi-s=2222;port-c=5062;port-s=5064", mechanism.String())
+	assert.Equal(t, 0.001, mechanism.Preference())
+	_, err = ParseSecurityMechanism(";q=0.1")
+	assert.NotNil(t, err)
+
+	register, _ := NewRequestBuilder("REGISTER").To("sip:alice@atlanta.com").FromUri("sip:alice@atlanta.com").Build()
+	SetSecurityClient(register, SecurityMechanism{Name: MechanismDigest}, mechanism)
+	assert.Equal(t, SecAgreeTag, register.Headers().Extensions.Get("Proxy-Require"))
+
+	// the server asks for the mechanism of its highest preference
+	agreement := &SecurityAgreement{Server: []SecurityMechanism{
+		{Name: MechanismTLS, Params: []string{"q=0.2"}},
+		{Name: MechanismIPsec3GPP, Params: []string{"q=0.1", "alg=hmac-sha-1-96", "spi-c=3333", "spi-s=4444", "port-c=5066", "port-s=5068"}},
+	}}
+	response, next := agreement.Filter()(Incoming{Message: register})
+	assert.False(t, next)
+	assert.Equal(t, 494, response.StatusCode())
+	chosen, err := AgreeSecurity(register, response)
+	assert.Nil(t, err)
+	assert.Equal(t, MechanismIPsec3GPP, chosen.Name)
+	port, _ := chosen.Param("port-s")
+	assert.Equal(t, "5068", port)
+	verify, err := SecurityVerify(register)
+	assert.Nil(t, err)
+	assert.Equal(t, agreement.Server, verify)
+	assert.Equal(t, []string{SecAgreeTag}, register.Headers().Extensions.GetAll("Require"))
+	_, next = agreement.Filter()(Incoming{Message: register})
+	assert.True(t, next)
+
+	// a Security-Verify other than the Security-Server sent is rejected
+	SetSecurityVerify(register, agreement.Server[1])
+	response, next = agreement.Filter()(Incoming{Message: register})
+	assert.False(t, next)
+	assert.Equal(t, 494, response.StatusCode())
+
+	SetSecurityClient(register, SecurityMechanism{Name: MechanismIPsecIKE})
+	_, err = AgreeSecurity(register, response)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,198 @@
+package slurp
+
+/*
+Server helpers implement checks the RFC requires of a UAS before a
+request is handed to the application. Each returns the response that
+should be sent when the check fails, or nil when the request is fine.
+*/
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mergeKey identifies a request independently of the path it took
+type mergeKey struct {
+	fromTag  string
+	callId   string
+	sequence int
+	method   string
+}
+
+type mergeEntry struct {
+	branch string
+	seen   time.Time
+}
+
+// mergeRecord is when a request was recorded, kept in the order received
+// so that expired requests are found without going through them all
+type mergeRecord struct {
+	key  mergeKey
+	seen time.Time
+}
+
+// MergeDetector detects merged requests (RFC 3261 8.2.2.2): requests
+// without a To tag whose From tag, Call-ID and CSeq match a request
+// already received, but which arrived through a different path.
+// It is safe for concurrent use.
+type MergeDetector struct {
+	// How long a request is remembered after it was received
+	Lifetime time.Duration
+	// Clock used to expire requests, DefaultClock when nil
+	Clock    Clock
+	mu       sync.Mutex
+	requests map[mergeKey]mergeEntry
+	// records of the requests, oldest first
+	records []mergeRecord
+}
+
+// NewMergeDetector creates a MergeDetector remembering requests for lifetime
+func NewMergeDetector(lifetime time.Duration) *MergeDetector {
+	return &MergeDetector{
+		Lifetime: lifetime,
+		requests: make(map[mergeKey]mergeEntry),
+	}
+}
+
+// Check records the request and returns a 482 response if it was merged,
+// or a 400 when it has no To or From to tell
+func (d *MergeDetector) Check(request Message) *Response {
+	if request.Headers().To == nil || request.Headers().From == nil {
+		return NewResponse(request, 400)
+	}
+	if request.Headers().To.Param("tag") != "" {
+		// in-dialog requests can't be merged
+		return nil
+	}
+	key := mergeKey{
+		fromTag:  request.Headers().From.Param("tag"),
+		callId:   request.Control().CallId,
+		sequence: request.Control().Sequence,
+		method:   request.Method(),
+	}
+	branch := request.Control().ViaBranch
+	now := clockOrDefault(d.Clock).Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	if entry, ok := d.requests[key]; ok && entry.branch != branch {
+		return NewResponse(request, 482)
+	}
+	d.requests[key] = mergeEntry{branch: branch, seen: now}
+	d.records = append(d.records, mergeRecord{key: key, seen: now})
+	return nil
+}
+
+// expire forgets the requests received more than Lifetime before now,
+// stopping at the first record that isn't expired
+func (d *MergeDetector) expire(now time.Time) {
+	expired := 0
+	for _, record := range d.records {
+		if now.Sub(record.seen) <= d.Lifetime {
+			break
+		}
+		// a request seen again since is remembered from then on
+		if entry, ok := d.requests[record.key]; ok && entry.seen.Equal(record.seen) {
+			delete(d.requests, record.key)
+		}
+		expired++
+	}
+	// the array behind is given back as appending moves the records
+	d.records = d.records[expired:]
+}
+
+// CheckViaLoop returns a 482 response when one of the request's Vias
+// has our own sent-by (host[:port]), meaning the request already passed through us
+func CheckViaLoop(request Message, sentBy string) *Response {
+	for _, via := range request.Control().Via {
+		if sameSentBy(via[1], sentBy, via[0]) {
+			return NewResponse(request, 482)
+		}
+	}
+	return nil
+}
+
+// sameSentBy compares two host[:port] values of a Via over transport,
+// using the default port of the transport when absent
+func sameSentBy(a, b, transport string) bool {
+	hostA, portA := splitSentBy(a, transport)
+	hostB, portB := splitSentBy(b, transport)
+	return strings.EqualFold(hostA, hostB) && portA == portB
+}
+
+// splitSentBy splits a sent-by into its host and port, which defaults to
+// 5061 over TLS and 5060 otherwise (RFC 3261 18)
+func splitSentBy(sentBy, transport string) (string, int) {
+	port := 5060
+	if strings.HasPrefix(strings.ToUpper(transport), "TLS") {
+		port = 5061
+	}
+	index := strings.LastIndex(sentBy, ":")
+	// a colon inside brackets belongs to an IPv6 address, not a port
+	if index < 0 || index < strings.LastIndex(sentBy, "]") {
+		return sentBy, port
+	}
+	if explicit, err := strconv.Atoi(sentBy[index+1:]); err == nil {
+		return sentBy[:index], explicit
+	}
+	return sentBy, port
+}
+
+// DecrementMaxForwards prepares a received request for forwarding by
+// decrementing its Max-Forwards. When the request can't be forwarded
+// any further, as it arrived with Max-Forwards 0, it is left untouched
+// and a 483 response is returned (RFC 3261 16.3)
+func DecrementMaxForwards(request Message) *Response {
+	headers := request.Headers()
+	if headers.ForwardSet && headers.Forward <= 0 {
+		return NewResponse(request, 483)
+	}
+	if headers.Forward == 0 {
+		headers.Forward = DefaultMaxForwards
+	}
+	headers.Forward--
+	headers.ForwardSet = true
+	return nil
+}
+
+// ServerTransactionKey returns the key of the server transaction a
+// received request belongs to, or that a response we send belongs to, per
+// RFC 3261 17.2.3. An ACK is part of the INVITE transaction it
+// acknowledges. When the top Via branch starts with the magic cookie, the
+// branch, the sent-by of the top Via and the method identify the
+// transaction. RFC 2543 clients don't generate unique branches, so their
+// requests are identified by the Call-ID, the From tag, the CSeq number,
+// the sent-by of the top Via and the method instead. The Request-URI and
+// the To tag, which RFC 2543 also compares, are left out as responses
+// don't carry the former and we add our tag to the latter
+func ServerTransactionKey(m Message) string {
+	method := m.Method()
+	if method == "ACK" {
+		method = "INVITE"
+	}
+	return serverTransactionKey(m, method)
+}
+
+// CancelledTransactionKey returns the key of the server transaction a
+// CANCEL cancels, which has the same identifiers but the INVITE method
+func CancelledTransactionKey(cancel Message) string {
+	return serverTransactionKey(cancel, "INVITE")
+}
+
+func serverTransactionKey(m Message, method string) string {
+	control := m.Control()
+	sentBy := ""
+	if len(control.Via) > 0 {
+		sentBy = strings.ToLower(control.Via[0][1])
+	}
+	if strings.HasPrefix(control.ViaBranch, BranchPrefix) {
+		return transactionKey(strings.ToLower(control.ViaBranch)+" "+sentBy, method)
+	}
+	fromTag := ""
+	if from := m.Headers().From; from != nil {
+		fromTag = from.Param("tag")
+	}
+	return transactionKey(strings.Join([]string{control.CallId, fromTag, strconv.Itoa(control.Sequence), sentBy}, " "), method)
+}
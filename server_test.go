@@ -0,0 +1,89 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeDetector(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		invite := Invite{}
+		assert.Nil(t, invite.Parse(string(data)))
+		detector := NewMergeDetector(32 * time.Second)
+		assert.Nil(t, detector.Check(&invite))
+		// a retransmission has the same branch, so isn't merged
+		assert.Nil(t, detector.Check(&invite))
+		invite.Control().ViaBranch = "z9hG4bKother"
+		response := detector.Check(&invite)
+		if assert.NotNil(t, response) {
+			assert.Equal(t, 482, response.StatusCode())
+		}
+
+		// requests are forgotten once their lifetime is over
+		clock := NewFakeClock(time.Now())
+		detector = NewMergeDetector(32 * time.Second)
+		detector.Clock = clock
+		assert.Nil(t, detector.Check(&invite))
+		clock.Advance(20 * time.Second)
+		assert.Nil(t, detector.Check(&invite))
+		clock.Advance(20 * time.Second)
+		invite.Control().ViaBranch = "z9hG4bKthird"
+		assert.NotNil(t, detector.Check(&invite), "the retransmission was remembered from when it was seen")
+		clock.Advance(33 * time.Second)
+		assert.Nil(t, detector.Check(&invite))
+		assert.Equal(t, 1, len(detector.requests))
+		assert.Equal(t, 1, len(detector.records))
+
+		// a request without a To is rejected rather than panic
+		invite.Headers().To = nil
+		response = detector.Check(&invite)
+		if assert.NotNil(t, response) {
+			assert.Equal(t, 400, response.StatusCode())
+		}
+	}
+}
+
+func TestCheckViaLoop(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		invite := Invite{}
+		assert.Nil(t, invite.Parse(string(data)))
+		assert.Nil(t, CheckViaLoop(&invite, "biloxi.com"))
+		response := CheckViaLoop(&invite, "PC33.atlanta.com:5060")
+		if assert.NotNil(t, response) {
+			assert.Equal(t, 482, response.StatusCode())
+		}
+	}
+	// a sent-by without a port is on 5061 over TLS
+	request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Control().Via = [][2]string{{"TLS", "proxy.biloxi.com"}}
+	assert.NotNil(t, CheckViaLoop(request, "proxy.biloxi.com:5061"))
+	assert.Nil(t, CheckViaLoop(request, "proxy.biloxi.com:5060"))
+	request.Control().Via = [][2]string{{"UDP", "proxy.biloxi.com"}}
+	assert.NotNil(t, CheckViaLoop(request, "proxy.biloxi.com:5060"))
+	assert.Nil(t, CheckViaLoop(request, "proxy.biloxi.com:5061"))
+}
+
+func TestDecrementMaxForwards(t *testing.T) {
+	if data, err := ioutil.ReadFile("examples/invite.sip"); err == nil {
+		invite := Invite{}
+		assert.Nil(t, invite.Parse(string(data)))
+		assert.Nil(t, DecrementMaxForwards(&invite))
+		assert.Equal(t, 69, invite.Headers().Forward)
+
+		// the last hop is forwarded with Max-Forwards 0, and no further
+		invite.Headers().Forward = 1
+		assert.Nil(t, DecrementMaxForwards(&invite))
+		assert.Equal(t, 0, invite.Headers().Forward)
+		assert.Contains(t, invite.Render(), "\r\nMax-Forwards: 0\r\n")
+		var forwarded Invite
+		assert.Nil(t, forwarded.Parse(invite.Render()))
+		response := DecrementMaxForwards(&forwarded)
+		if assert.NotNil(t, response) {
+			assert.Equal(t, 483, response.StatusCode())
+		}
+		assert.Equal(t, 0, forwarded.Headers().Forward)
+	}
+}
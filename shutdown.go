@@ -0,0 +1,89 @@
+package slurp
+
+/*
+Shutting down drains a UserAgent: new calls are refused with 503 while
+the transactions and calls in progress finish, then the transport closes.
+*/
+
+import (
+	"context"
+	"time"
+)
+
+// drainPollInterval is how often Shutdown checks for remaining work
+const drainPollInterval = 10 * time.Millisecond
+
+// Shutdown stops the UserAgent from accepting new work and waits for the
+// work in progress to finish before closing the transport. While draining,
+// requests outside a dialog are answered with 503, and Dial fails with a
+// ShuttingDownError. In-dialog requests still flow, so calls can end.
+// When ctx is done first the transport is closed anyway, and ctx.Err()
+// is returned
+func (ua *UserAgent) Shutdown(ctx context.Context) error {
+	ua.mu.Lock()
+	ua.draining = true
+	ua.mu.Unlock()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	var err error
+	for !ua.idle() && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	ua.mu.RLock()
+	transports := []Transport{ua.transport}
+	if ua.stream != nil {
+		transports = append(transports, ua.stream)
+	}
+	ua.mu.RUnlock()
+	for _, transport := range transports {
+		if closeErr := shutdownTransport(ctx, transport); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// shutdownTransport shuts the transport down gracefully if it can,
+// closing it otherwise
+func shutdownTransport(ctx context.Context, transport Transport) error {
+	if shutdowner, ok := transport.(interface{ Shutdown(context.Context) error }); ok {
+		return shutdowner.Shutdown(ctx)
+	}
+	return transport.Close()
+}
+
+// Draining reports whether Shutdown was called
+func (ua *UserAgent) Draining() bool {
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	return ua.draining
+}
+
+// idle reports whether no client transaction is running and every call
+// has ended, forgetting the calls that have
+func (ua *UserAgent) idle() bool {
+	remaining := ua.dialogs.Prune()
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	return ua.inflight == 0 && remaining == 0
+}
+
+// findCall returns the call the message was received in, or nil
+func (ua *UserAgent) findCall(m Message) *Call {
+	return ua.dialogs.Match(m)
+}
+
+// track registers a call so that Shutdown waits for it to end
+func (ua *UserAgent) track(call *Call) {
+	ua.dialogs.Insert(call)
+}
+
+// Shutdown closes the socket. UDP has no connections to drain, so it
+// doesn't wait
+func (t *UDPTransport) Shutdown(ctx context.Context) error {
+	return t.Close()
+}
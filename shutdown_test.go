@@ -0,0 +1,66 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+
+	_, dialog := exampleDialog(t, false)
+	call := NewCall(server, dialog, a.LocalAddr().String())
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+	for !server.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.Request(ctx, b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, 503, response.StatusCode())
+	_, err = server.Dial(ctx, a.LocalAddr().String(), newTestInvite("sip:alice@atlanta.com"), nil)
+	assert.Equal(t, ShuttingDownError{}, err)
+
+	// the call in progress holds the shutdown until it ends
+	select {
+	case <-done:
+		t.Fatal("shut down with a call in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	call.Dialog.Terminate("BYE")
+	assert.Nil(t, <-done)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	ua := NewUserAgent(a)
+	_, dialog := exampleDialog(t, true)
+	NewCall(ua, dialog, "192.0.2.1:5060")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ua.Shutdown(ctx))
+	_, open := <-ua.Receive()
+	assert.False(t, open)
+}
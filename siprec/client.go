@@ -0,0 +1,180 @@
+package siprec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// OptionTag is required by recording sessions, and the feature tag in the
+// Contact of an SRC marks the dialog as one (RFC 7866 6.1)
+const (
+	OptionTag  = "siprec"
+	FeatureTag = "+sip.src"
+)
+
+// disposition is the Content-Disposition of metadata bodies
+const disposition = "recording-session"
+
+// Client is a Session Recording Client, establishing recording sessions
+// from its UserAgent, whose Profile they are sent from
+type Client struct {
+	UA *slurp.UserAgent
+	// SRS is the URI of the recording server, and Addr the host:port its
+	// INVITEs are sent to
+	SRS  string
+	Addr string
+}
+
+// Recording is a recording session established with an SRS
+type Recording struct {
+	Call *slurp.Call
+	ua   *slurp.UserAgent
+	mu   sync.Mutex
+	// metadata is the last sent to the SRS
+	metadata *Metadata
+}
+
+// multipartBody returns a multipart/mixed body of the SDP and metadata,
+// and its content type
+func multipartBody(offer *sdp.Session, metadata *Metadata) (string, []byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/sdp"}})
+	if err == nil {
+		_, err = part.Write([]byte(offer.Render()))
+	}
+	if err == nil {
+		part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {ContentType}, "Content-Disposition": {disposition}})
+	}
+	if err == nil {
+		_, err = part.Write(metadata.Render())
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return "multipart/mixed;boundary=" + w.Boundary(), body.Bytes(), nil
+}
+
+// prepareOffer makes every m= line of the offer sendonly and labelled,
+// numbering those without a label, and adds a stream to the metadata for
+// each label it doesn't describe
+func prepareOffer(offer *sdp.Session, metadata *Metadata) {
+	for i, media := range offer.Media {
+		label, ok := media.Attributes.Get("label")
+		if !ok {
+			label = strconv.Itoa(i + 1)
+			media.Attributes = append(media.Attributes, sdp.Attribute{Key: "label", Value: label})
+		}
+		for _, direction := range []string{"sendrecv", "recvonly", "inactive"} {
+			media.Attributes = media.Attributes.Remove(direction)
+		}
+		if !media.Attributes.Has("sendonly") {
+			media.Attributes = append(media.Attributes, sdp.Attribute{Key: "sendonly"})
+		}
+		if metadata.Stream(label) == nil {
+			metadata.Streams = append(metadata.Streams, &Stream{ID: NewID(), Label: label})
+		}
+	}
+}
+
+// Record establishes a recording session offering the forked media of
+// offer, described by metadata. Media sections are made sendonly, and
+// labelled when they aren't; the SRS's answer is on the Dialog of the
+// Recording's Call
+func (c *Client) Record(ctx context.Context, offer *sdp.Session, metadata *Metadata) (*Recording, error) {
+	profile := c.UA.Profile()
+	if profile == nil {
+		return nil, fmt.Errorf("siprec: the UserAgent has no profile to record from")
+	}
+	prepareOffer(offer, metadata)
+	contentType, body, err := multipartBody(offer, metadata)
+	if err != nil {
+		return nil, err
+	}
+	m, err := slurp.NewInvite().
+		To(c.SRS).
+		From(profile).
+		Header("Require", OptionTag).
+		WithBody(contentType, body).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	invite := m.(*slurp.Invite)
+	for _, contact := range invite.Headers().Contacts {
+		contact.SetParam(FeatureTag, "")
+	}
+	call, err := c.UA.Dial(ctx, c.Addr, invite, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Recording{Call: call, ua: c.UA, metadata: metadata}, nil
+}
+
+// Metadata returns the metadata last sent to the SRS. Change it through
+// Update, not directly
+func (r *Recording) Metadata() *Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metadata
+}
+
+// Update changes the metadata with change and sends it to the SRS in an
+// UPDATE (RFC 7866 7.1.1.1). Updates are sent one at a time
+func (r *Recording) Update(ctx context.Context, change func(*Metadata)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(r.metadata)
+	request := r.Call.Dialog.NewRequest("UPDATE")
+	request.Headers().ContentType = ContentType
+	request.Headers().Extensions.Set("Content-Disposition", disposition)
+	request.SetPayload(r.metadata.Render())
+	response, err := r.ua.Request(ctx, r.Call.Addr, request)
+	if err != nil {
+		return err
+	}
+	if !slurp.IsSuccess(response.StatusCode()) {
+		return fmt.Errorf("siprec: metadata update rejected: %d %s", response.StatusCode(), response.Reason())
+	}
+	return nil
+}
+
+// AddParticipant records that a participant joined the call
+func (r *Recording) AddParticipant(ctx context.Context, p *Participant) error {
+	return r.Update(ctx, func(m *Metadata) {
+		if p.ID == "" {
+			p.ID = NewID()
+		}
+		if p.Joined.IsZero() {
+			p.Joined = time.Now()
+		}
+		m.Participants = append(m.Participants, p)
+	})
+}
+
+// RemoveParticipant records that the participant with the given ID left
+// the call
+func (r *Recording) RemoveParticipant(ctx context.Context, id string) error {
+	return r.Update(ctx, func(m *Metadata) {
+		if p := m.Participant(id); p != nil && p.Left.IsZero() {
+			p.Left = time.Now()
+		}
+	})
+}
+
+// Stop ends the recording session
+func (r *Recording) Stop(ctx context.Context) error {
+	return r.Call.Hangup(ctx)
+}
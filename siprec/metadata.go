@@ -0,0 +1,228 @@
+/*
+Package siprec records calls on a Session Recording Server (SRS) as a
+Session Recording Client (SRC), per RFC 7866. The SRC forks the media of
+the communication session it records, and sends it over a recording
+session: an INVITE to the SRS whose multipart body carries the SDP of the
+forked streams and the recording metadata of RFC 7865, describing the
+participants of the call and which streams carry whose media. When
+participants join or leave, e.g. on a transfer, the metadata is updated
+within the recording session.
+*/
+package siprec
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qmuloadmin/slurp"
+)
+
+// ContentType is the content type of recording metadata
+const ContentType = "application/rs-metadata+xml"
+
+const namespace = "urn:ietf:params:xml:ns:recording:1"
+
+// NewID returns a new identifier for a session, participant or stream, a
+// base64 encoded UUID as RFC 7865 6.10 recommends
+func NewID() string {
+	id := uuid.New()
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// Participant is a party to the communication session recorded
+type Participant struct {
+	ID   string
+	AOR  string
+	Name string
+	// Send and Receive are the IDs of the streams carrying the media the
+	// participant sends and receives
+	Send    []string
+	Receive []string
+	// Joined is when the participant joined the session, and Left when
+	// they left it, zero while they are in it
+	Joined time.Time
+	Left   time.Time
+}
+
+// Stream is a media stream of the recording session, the m= line of its
+// SDP with the same a=label
+type Stream struct {
+	ID    string
+	Label string
+}
+
+// Metadata describes a communication session being recorded
+type Metadata struct {
+	// SessionID identifies the communication session in the metadata
+	SessionID string
+	// CallId is the Call-ID of the communication session
+	CallId       string
+	Start        time.Time
+	Participants []*Participant
+	Streams      []*Stream
+}
+
+// NewMetadata describes the session of a call, with its two parties as
+// participants
+func NewMetadata(dialog *slurp.Dialog) *Metadata {
+	now := time.Now()
+	m := &Metadata{SessionID: NewID(), CallId: dialog.CallId, Start: now}
+	m.Participants = []*Participant{
+		{ID: NewID(), AOR: dialog.LocalUri, Joined: now},
+		{ID: NewID(), AOR: dialog.RemoteUri, Joined: now},
+	}
+	return m
+}
+
+// Participant returns the participant with the given ID, or nil
+func (m *Metadata) Participant(id string) *Participant {
+	for _, p := range m.Participants {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Stream returns the stream with the given label, or nil
+func (m *Metadata) Stream(label string) *Stream {
+	for _, s := range m.Streams {
+		if s.Label == label {
+			return s
+		}
+	}
+	return nil
+}
+
+// AddStream adds a stream carrying the media sender sends, which every
+// other participant in the session receives
+func (m *Metadata) AddStream(label string, sender *Participant) *Stream {
+	stream := &Stream{ID: NewID(), Label: label}
+	m.Streams = append(m.Streams, stream)
+	for _, p := range m.Participants {
+		if p == sender {
+			p.Send = append(p.Send, stream.ID)
+		} else if p.Left.IsZero() {
+			p.Receive = append(p.Receive, stream.ID)
+		}
+	}
+	return stream
+}
+
+// The elements of the metadata document, RFC 7865 6
+type (
+	document struct {
+		XMLName      xml.Name           `xml:"urn:ietf:params:xml:ns:recording:1 recording"`
+		DataMode     string             `xml:"datamode"`
+		Sessions     []session          `xml:"session"`
+		Participants []participant      `xml:"participant"`
+		Streams      []stream           `xml:"stream"`
+		Recording    []association      `xml:"sessionrecordingassoc"`
+		Membership   []association      `xml:"participantsessionassoc"`
+		Media        []participantMedia `xml:"participantstreamassoc"`
+	}
+	session struct {
+		ID           string `xml:"session_id,attr"`
+		SipSessionID string `xml:"sipSessionID,omitempty"`
+		Start        string `xml:"start-time,omitempty"`
+	}
+	participant struct {
+		ID   string `xml:"participant_id,attr"`
+		Name struct {
+			AOR  string `xml:"aor,attr"`
+			Name string `xml:"name,omitempty"`
+		} `xml:"nameID"`
+	}
+	stream struct {
+		ID      string `xml:"stream_id,attr"`
+		Session string `xml:"session_id,attr"`
+		Label   string `xml:"label"`
+	}
+	association struct {
+		Participant  string `xml:"participant_id,attr,omitempty"`
+		Session      string `xml:"session_id,attr"`
+		Associate    string `xml:"associate-time,omitempty"`
+		Disassociate string `xml:"disassociate-time,omitempty"`
+	}
+	participantMedia struct {
+		Participant string   `xml:"participant_id,attr"`
+		Send        []string `xml:"send"`
+		Receive     []string `xml:"recv"`
+	}
+)
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Render returns the metadata as a complete rs-metadata document
+func (m *Metadata) Render() []byte {
+	doc := document{
+		DataMode:  "complete",
+		Sessions:  []session{{ID: m.SessionID, SipSessionID: m.CallId, Start: formatTime(m.Start)}},
+		Recording: []association{{Session: m.SessionID, Associate: formatTime(m.Start)}},
+	}
+	for _, p := range m.Participants {
+		element := participant{ID: p.ID}
+		element.Name.AOR, element.Name.Name = p.AOR, p.Name
+		doc.Participants = append(doc.Participants, element)
+		doc.Membership = append(doc.Membership, association{
+			Participant:  p.ID,
+			Session:      m.SessionID,
+			Associate:    formatTime(p.Joined),
+			Disassociate: formatTime(p.Left),
+		})
+		doc.Media = append(doc.Media, participantMedia{Participant: p.ID, Send: p.Send, Receive: p.Receive})
+	}
+	for _, s := range m.Streams {
+		doc.Streams = append(doc.Streams, stream{ID: s.ID, Session: m.SessionID, Label: s.Label})
+	}
+	// the document only holds strings, so it always marshals
+	data, _ := xml.MarshalIndent(doc, "", " ")
+	return append([]byte(xml.Header), append(data, '\n')...)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// ParseMetadata reads an rs-metadata document describing one session
+func ParseMetadata(body []byte) (*Metadata, error) {
+	var doc document
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Space != namespace {
+		return nil, fmt.Errorf("not recording metadata: %s", doc.XMLName.Space)
+	}
+	m := &Metadata{}
+	if len(doc.Sessions) > 0 {
+		m.SessionID = doc.Sessions[0].ID
+		m.CallId = doc.Sessions[0].SipSessionID
+		m.Start = parseTime(doc.Sessions[0].Start)
+	}
+	for _, element := range doc.Participants {
+		m.Participants = append(m.Participants, &Participant{ID: element.ID, AOR: element.Name.AOR, Name: element.Name.Name})
+	}
+	for _, element := range doc.Streams {
+		m.Streams = append(m.Streams, &Stream{ID: element.ID, Label: element.Label})
+	}
+	for _, assoc := range doc.Membership {
+		if p := m.Participant(assoc.Participant); p != nil {
+			p.Joined, p.Left = parseTime(assoc.Associate), parseTime(assoc.Disassociate)
+		}
+	}
+	for _, media := range doc.Media {
+		if p := m.Participant(media.Participant); p != nil {
+			p.Send, p.Receive = media.Send, media.Receive
+		}
+	}
+	return m, nil
+}
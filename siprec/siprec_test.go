@@ -0,0 +1,150 @@
+package siprec
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/qmuloadmin/slurp/sdp"
+	"github.com/qmuloadmin/slurp/testkit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	metadata := NewMetadata(&slurp.Dialog{CallId: "a84b4c76e66710", LocalUri: "sip:alice@atlanta.com", RemoteUri: "sip:bob@biloxi.com"})
+	alice, bob := metadata.Participants[0], metadata.Participants[1]
+	bob.Name = "Bob"
+	stream := metadata.AddStream("1", alice)
+	bob.Left = metadata.Start.Add(time.Minute)
+
+	body := metadata.Render()
+	assert.Contains(t, string(body), `<recording xmlns="urn:ietf:params:xml:ns:recording:1">`)
+	parsed, err := ParseMetadata(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "a84b4c76e66710", parsed.CallId)
+	assert.Len(t, parsed.Participants, 2)
+	assert.Equal(t, "Bob", parsed.Participant(bob.ID).Name)
+	assert.Equal(t, []string{stream.ID}, parsed.Participant(alice.ID).Send)
+	assert.Equal(t, []string{stream.ID}, parsed.Participant(bob.ID).Receive)
+	assert.Equal(t, bob.Left.Unix(), parsed.Participant(bob.ID).Left.Unix())
+	assert.Equal(t, stream.ID, parsed.Stream("1").ID)
+
+	_, err = ParseMetadata([]byte("<presence/>"))
+	assert.NotNil(t, err)
+}
+
+const ok = `SIP/2.0 200 OK
+{{.LastHeader "Via"}}
+{{.LastHeader "From"}}
+{{.LastHeader "To"}}{{if not .Vars.tagged}};tag=srs{{end}}
+{{.LastHeader "Call-ID"}}
+{{.LastHeader "CSeq"}}
+Contact: <sip:srs@{{.Local}}>
+Content-Length: 0
+`
+
+const answer = `SIP/2.0 200 OK
+{{.LastHeader "Via"}}
+{{.LastHeader "From"}}
+{{.LastHeader "To"}};tag=srs
+{{.LastHeader "Call-ID"}}
+{{.LastHeader "CSeq"}}
+Contact: <sip:srs@{{.Local}}>
+Content-Type: application/sdp
+Content-Length: 0
+
+v=0
+o=srs 1 1 IN IP4 {{.LocalIP}}
+s=-
+c=IN IP4 {{.LocalIP}}
+t=0 0
+m=audio 30000 RTP/AVP 0
+a=label:1
+a=recvonly
+`
+
+func TestRecord(t *testing.T) {
+	srs, err := slurp.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	src, err := slurp.ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer srs.Close()
+	defer src.Close()
+	ua := slurp.NewUserAgent(src)
+	_, port, _ := net.SplitHostPort(src.LocalAddr().String())
+	contactPort, _ := strconv.Atoi(port)
+	ua.SetProfile(&slurp.Profile{AOR: "sip:src@atlanta.com", ContactHost: "127.0.0.1", ContactPort: contactPort})
+	go func() {
+		for range ua.Receive() {
+		}
+	}()
+
+	metadata := NewMetadata(&slurp.Dialog{CallId: "a84b4c76e66710", LocalUri: "sip:alice@atlanta.com", RemoteUri: "sip:bob@biloxi.com"})
+	offer := &sdp.Session{
+		Origin:     sdp.Origin{Username: "-", SessionId: "1", SessionVersion: "1", NetType: "IN", AddrType: "IP4", Address: "127.0.0.1"},
+		Name:       "-",
+		Connection: &sdp.Connection{NetType: "IN", AddrType: "IP4", Address: "127.0.0.1"},
+		Timing:     "0 0",
+		Media:      []*sdp.Media{{Type: "audio", Port: 40000, Protocol: "RTP/AVP", Formats: []string{"0"}, Attributes: sdp.Attributes{{Key: "sendrecv"}}}},
+	}
+	client := &Client{UA: ua, SRS: "sip:srs@127.0.0.1", Addr: srs.LocalAddr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recorded := make(chan *Recording, 1)
+	go func() {
+		recording, err := client.Record(ctx, offer, metadata)
+		assert.Nil(t, err)
+		recorded <- recording
+	}()
+
+	tagged := func(ctx context.Context, s *testkit.Session) error {
+		s.Vars = map[string]string{"tagged": "true"}
+		return nil
+	}
+	scenario := &testkit.Scenario{Name: "srs", Steps: []testkit.Step{
+		testkit.Expect{Method: "INVITE", Checks: []testkit.Check{
+			testkit.HeaderContains("Require", "siprec"),
+			testkit.HeaderContains("Contact", ";+sip.src"),
+			testkit.BodyContains("Content-Disposition: recording-session"),
+			testkit.BodyContains("a=label:1"),
+			testkit.BodyContains("a=sendonly"),
+		}},
+		testkit.Send{Template: answer},
+		testkit.Expect{Method: "ACK"},
+		testkit.Do(tagged),
+		testkit.Expect{Method: "UPDATE", Checks: []testkit.Check{testkit.BodyContains("sip:carol@chicago.com")}},
+		testkit.Send{Template: ok},
+		testkit.Expect{Method: "BYE"},
+		testkit.Send{Template: ok},
+	}}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		recording := <-recorded
+		if recording == nil {
+			return
+		}
+		assert.Nil(t, recording.AddParticipant(ctx, &Participant{AOR: "sip:carol@chicago.com"}))
+		assert.Nil(t, recording.Stop(ctx))
+	}()
+	session, err := scenario.Run(ctx, srs, src.LocalAddr().String())
+	assert.Nil(t, err)
+	<-stopped
+	if assert.NotNil(t, session.Last) {
+		assert.Equal(t, "BYE", session.Last.Method())
+	}
+	invite := session.Received[0]
+	assert.True(t, strings.HasPrefix(invite.Headers().ContentType, "multipart/mixed"))
+	body, _, found := slurp.BodyPart(invite, ContentType)
+	if assert.True(t, found) {
+		sent, err := ParseMetadata(body)
+		assert.Nil(t, err)
+		assert.Equal(t, metadata.Streams[0].ID, sent.Stream("1").ID)
+	}
+}
@@ -0,0 +1,145 @@
+package slurp
+
+/*
+Stats tell operators how much state a UserAgent holds: how many client
+transactions, calls, subscriptions and connections, and roughly how much
+memory each kind takes. Counts that keep growing point at a leak, and the
+sizes help plan capacity, without going through a heap profile. Sizes are
+estimates: the structs themselves, the strings they hold and the messages
+and session descriptions they keep, in their rendered length.
+*/
+
+import (
+	"unsafe"
+
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// streamReadBuffer is the size of the bufio.Reader each connection of a
+// stream transport is read through, bufio's default
+const streamReadBuffer = 4096
+
+// StateStats is the count and estimated size in bytes of a kind of state
+type StateStats struct {
+	Count int `json:"count"`
+	Bytes int `json:"bytes"`
+}
+
+func (s *StateStats) add(bytes int) {
+	s.Count++
+	s.Bytes += bytes
+}
+
+// Stats is the state a UserAgent holds
+type Stats struct {
+	Transactions  StateStats `json:"transactions"`
+	Dialogs       StateStats `json:"dialogs"`
+	Subscriptions StateStats `json:"subscriptions"`
+	// Connections are those of connection-oriented transports, each
+	// counted once whatever the addresses it is used for
+	Connections StateStats `json:"connections"`
+}
+
+// Bytes returns the estimated size of all the state
+func (s Stats) Bytes() int {
+	return s.Transactions.Bytes + s.Dialogs.Bytes + s.Subscriptions.Bytes + s.Connections.Bytes
+}
+
+// connectionCounter is implemented by transports keeping connections,
+// reporting their count and estimated size
+type connectionCounter interface {
+	connectionStats() StateStats
+}
+
+// Stats counts the client transactions, calls, subscriptions and
+// connections of the UserAgent, and estimates their size. Each kind is
+// counted on its own, so they may be a little out of step with each other
+func (ua *UserAgent) Stats() Stats {
+	var stats Stats
+	ua.mu.RLock()
+	for key, tx := range ua.transactions {
+		stats.Transactions.add(int(unsafe.Sizeof(*tx)) + len(key) + cap(tx.responses)*int(unsafe.Sizeof(tx)))
+	}
+	subscriptions := make([]*Subscription, 0, len(ua.subscriptions))
+	for s := range ua.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	transports := []Transport{ua.transport, ua.stream}
+	ua.mu.RUnlock()
+	ua.dialogs.Range(func(id DialogID, call *Call) bool {
+		stats.Dialogs.add(int(unsafe.Sizeof(*call)) + len(call.Addr) + dialogSize(call.Dialog))
+		return true
+	})
+	for _, s := range subscriptions {
+		stats.Subscriptions.add(s.size())
+	}
+	for _, transport := range transports {
+		if counter, ok := transport.(connectionCounter); ok {
+			connections := counter.connectionStats()
+			stats.Connections.Count += connections.Count
+			stats.Connections.Bytes += connections.Bytes
+		}
+	}
+	return stats
+}
+
+// dialogSize estimates the size of a dialog
+func dialogSize(d *Dialog) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	size := int(unsafe.Sizeof(*d)) + len(d.CallId) + len(d.LocalTag) + len(d.RemoteTag) +
+		len(d.LocalUri) + len(d.RemoteUri) + len(d.RemoteTarget) +
+		sessionSize(d.RemoteSDP) + sessionSize(d.LocalSDP)
+	for _, route := range d.RouteSet {
+		size += len(route)
+	}
+	for _, method := range d.RemoteAllow {
+		size += len(method)
+	}
+	if d.ack != nil {
+		size += len(d.ack.Render())
+	}
+	return size
+}
+
+// sessionSize estimates the size of a session description, nil or not
+func sessionSize(session *sdp.Session) int {
+	if session == nil {
+		return 0
+	}
+	return len(session.Render())
+}
+
+// size estimates the size of the subscription, with its dialog
+func (s *Subscription) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := int(unsafe.Sizeof(*s)) + len(s.Event.Package) + len(s.Event.Id) + len(s.Addr) +
+		cap(s.notifications)*int(unsafe.Sizeof(Notification{}))
+	if s.subscribe != nil {
+		size += len(s.subscribe.Render())
+	}
+	if s.Dialog != nil {
+		size += dialogSize(s.Dialog)
+	}
+	return size
+}
+
+// connectionStats counts the connections of the transport, and estimates
+// their size with that of the buffer each is read through
+func (t *TCPTransport) connectionStats() StateStats {
+	var stats StateStats
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := make(map[*streamConn]bool, len(t.conns))
+	for addr, c := range t.conns {
+		if seen[c] {
+			// an alias of a connection counted already
+			stats.Bytes += len(addr)
+			continue
+		}
+		seen[c] = true
+		stats.add(int(unsafe.Sizeof(*c)) + len(addr) + streamReadBuffer)
+	}
+	return stats
+}
@@ -0,0 +1,46 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	ua := NewUserAgent(a)
+	assert.Equal(t, Stats{}, ua.Stats())
+
+	_, dialog := exampleDialog(t, true)
+	NewCall(ua, dialog, "192.0.2.1:5060")
+	ua.mu.Lock()
+	ua.transactions[transactionKey(NewBranch(), "OPTIONS")] = &clientTransaction{responses: make(chan *Response, 8), expired: make(chan struct{})}
+	ua.mu.Unlock()
+	stats := ua.Stats()
+	assert.Equal(t, 1, stats.Dialogs.Count)
+	assert.Equal(t, 1, stats.Transactions.Count)
+	assert.Equal(t, 0, stats.Subscriptions.Count)
+	assert.Greater(t, stats.Dialogs.Bytes, len(dialog.CallId)+len(dialog.RemoteTarget))
+	assert.Greater(t, stats.Transactions.Bytes, 0)
+	assert.Equal(t, stats.Dialogs.Bytes+stats.Transactions.Bytes, stats.Bytes())
+
+	// connections are counted once, whatever their aliases
+	b, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer b.Close()
+	c, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer c.Close()
+	ua.SetStreamTransport(b)
+	assert.Nil(t, b.Send(context.Background(), c.LocalAddr().String(), []byte("\r\n\r\n")))
+	b.AcceptAliases = true
+	assert.True(t, b.Alias("192.0.2.1:5060", c.LocalAddr()))
+	stats = ua.Stats()
+	assert.Equal(t, 1, stats.Connections.Count)
+	assert.Greater(t, stats.Connections.Bytes, streamReadBuffer)
+}
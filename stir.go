@@ -0,0 +1,455 @@
+package slurp
+
+/*
+STIR/SHAKEN caller identity. The authentication service of the
+originating network signs the calling and called numbers in a PASSporT
+(RFC 8225, with the SHAKEN extension of RFC 8588), a JWT carried in the
+Identity header (RFC 8224). The verification service of the terminating
+network checks it and tells the UAS how far the caller can be trusted.
+
+Certificates are managed by the application: an IdentitySigner signs with
+a PASSporTSigner, and an IdentityVerifier checks signatures with a
+PASSporTVerifier, which fetches the certificate the info parameter points
+at. ES256Signer and ES256Verifier implement them with ECDSA keys.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// Attestation is how far the signer vouches for the calling number (RFC 8588)
+type Attestation string
+
+const (
+	// AttestationFull is given for customers authenticated and authorized
+	// to use the number
+	AttestationFull Attestation = "A"
+	// AttestationPartial is given for authenticated customers whose right
+	// to the number isn't established
+	AttestationPartial Attestation = "B"
+	// AttestationGateway is given for calls from a gateway, e.g. an
+	// international one, the signer knows nothing more about
+	AttestationGateway Attestation = "C"
+)
+
+// DefaultIdentityMaxAge is how old a PASSporT may be, per RFC 8224 6.2.1
+const DefaultIdentityMaxAge = time.Minute
+
+// PASSporTHeader is the JOSE header of a PASSporT
+type PASSporTHeader struct {
+	Alg string `json:"alg"`
+	Ppt string `json:"ppt,omitempty"`
+	Typ string `json:"typ"`
+	// X5u is the URL of the signer's certificate
+	X5u string `json:"x5u"`
+}
+
+// PASSporTOrig is the originating identity, a telephone number or a URI
+type PASSporTOrig struct {
+	TN  string `json:"tn,omitempty"`
+	URI string `json:"uri,omitempty"`
+}
+
+// PASSporTDest is the destination identities
+type PASSporTDest struct {
+	TN  []string `json:"tn,omitempty"`
+	URI []string `json:"uri,omitempty"`
+}
+
+// PASSporT is the claims of a PASSporT
+type PASSporT struct {
+	Attest Attestation  `json:"attest,omitempty"`
+	Dest   PASSporTDest `json:"dest"`
+	// Iat is when it was issued, in seconds since the epoch
+	Iat  int64        `json:"iat"`
+	Orig PASSporTOrig `json:"orig"`
+	// OrigId is an opaque UUID identifying where the call entered the
+	// signer's network
+	OrigId string `json:"origid,omitempty"`
+}
+
+// PASSporTSigner signs PASSporTs
+type PASSporTSigner interface {
+	// Algorithm is the JWS algorithm, e.g. ES256
+	Algorithm() string
+	// Sign returns the JWS signature of the signing input
+	Sign(input []byte) ([]byte, error)
+}
+
+// PASSporTVerifier checks the signature of PASSporTs. It returns an
+// IdentityError with 436 when the certificate at info can't be obtained,
+// 437 when it or alg isn't supported, and 438 when the signature is wrong
+type PASSporTVerifier interface {
+	Verify(info, alg string, input, signature []byte) error
+}
+
+// IdentityHeader is the value of an Identity header
+type IdentityHeader struct {
+	// Token is the compact form of the PASSporT JWS
+	Token string
+	// Info is the URI of the certificate, the x5u of the PASSporT
+	Info string
+	Alg  string
+	Ppt  string
+}
+
+func (h IdentityHeader) String() string {
+	value := fmt.Sprintf("%s;info=<%s>;alg=%s", h.Token, h.Info, h.Alg)
+	if h.Ppt != "" {
+		value += ";ppt=" + h.Ppt
+	}
+	return value
+}
+
+// ParseIdentityHeader parses the value of an Identity header
+func ParseIdentityHeader(value string) (IdentityHeader, error) {
+	parts := strings.Split(strings.TrimSpace(value), ";")
+	h := IdentityHeader{Token: strings.TrimSpace(parts[0])}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch strings.ToLower(name) {
+		case "info":
+			h.Info = strings.Trim(value, "<>")
+		case "alg":
+			h.Alg = value
+		case "ppt":
+			h.Ppt = strings.Trim(value, `"`)
+		}
+	}
+	if strings.Count(h.Token, ".") != 2 {
+		return h, fmt.Errorf("invalid Identity token %q", h.Token)
+	}
+	return h, nil
+}
+
+// telephoneNumber returns the number of a tel URI, or of a SIP URI whose
+// user part is one, in the canonical form of RFC 8224 8.3: digits only
+func telephoneNumber(uri string) (string, bool) {
+	uri = addrSpec(uri)
+	var user string
+	switch UriScheme(uri) {
+	case "tel":
+		user = strings.SplitN(uri[len("tel:"):], ";", 2)[0]
+	case "sip", "sips":
+		user, _ = uriUserHost(uri)
+		user = strings.SplitN(user, ";", 2)[0]
+	}
+	var number strings.Builder
+	for _, c := range user {
+		switch {
+		case c >= '0' && c <= '9':
+			number.WriteRune(c)
+		case strings.ContainsRune("+-.()", c):
+			// the leading + and visual separators are dropped
+		default:
+			return "", false
+		}
+	}
+	return number.String(), number.Len() > 0
+}
+
+// callingIdentity is the URI the caller is identified by: the first
+// P-Asserted-Identity when there is one, otherwise the From
+func callingIdentity(m Message) string {
+	if asserted := m.Headers().Extensions.GetAll("P-Asserted-Identity"); len(asserted) > 0 {
+		return addrSpec(asserted[0])
+	}
+	return m.Headers().From.Uri()
+}
+
+var base64url = base64.RawURLEncoding
+
+// IdentitySigner is an authentication service, adding Identity headers
+// to the INVITEs it signs
+type IdentitySigner struct {
+	Signer PASSporTSigner
+	// X5u is the URL of the signer's certificate
+	X5u    string
+	Attest Attestation
+	OrigId string
+	// Clock used for the issue time, DefaultClock when nil
+	Clock Clock
+}
+
+// PASSporT returns the claims for the request
+func (s *IdentitySigner) PASSporT(request Message) PASSporT {
+	p := PASSporT{Attest: s.Attest, OrigId: s.OrigId, Iat: clockOrDefault(s.Clock).Now().Unix()}
+	if tn, ok := telephoneNumber(callingIdentity(request)); ok {
+		p.Orig.TN = tn
+	} else {
+		p.Orig.URI = callingIdentity(request)
+	}
+	if tn, ok := telephoneNumber(request.Headers().To.Uri()); ok {
+		p.Dest.TN = []string{tn}
+	} else {
+		p.Dest.URI = []string{request.Headers().To.Uri()}
+	}
+	return p
+}
+
+// Sign adds an Identity header for the request
+func (s *IdentitySigner) Sign(request Message) error {
+	header := PASSporTHeader{Alg: s.Signer.Algorithm(), Typ: "passport", X5u: s.X5u}
+	if s.Attest != "" {
+		header.Ppt = "shaken"
+	}
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	claims, err := json.Marshal(s.PASSporT(request))
+	if err != nil {
+		return err
+	}
+	input := base64url.EncodeToString(encodedHeader) + "." + base64url.EncodeToString(claims)
+	signature, err := s.Signer.Sign([]byte(input))
+	if err != nil {
+		return err
+	}
+	request.Headers().Extensions.Add("Identity", IdentityHeader{
+		Token: input + "." + base64url.EncodeToString(signature),
+		Info:  s.X5u,
+		Alg:   header.Alg,
+		Ppt:   header.Ppt,
+	}.String())
+	return nil
+}
+
+// Interceptor returns an Interceptor signing outgoing initial INVITEs
+// that don't have an Identity header yet
+func (s *IdentitySigner) Interceptor() Interceptor {
+	return func(m Message, direction Direction) (Message, error) {
+		if direction != Outbound || m.Method() != "INVITE" || m.Headers().Extensions.Get("Identity") != "" {
+			return m, nil
+		}
+		if to := m.Headers().To; to != nil && to.Param("tag") != "" {
+			return m, nil
+		}
+		return m, s.Sign(m)
+	}
+}
+
+// Verstat is the outcome of verification, as reported to the UAS
+type Verstat string
+
+const (
+	VerstatPassed Verstat = "TN-Validation-Passed"
+	VerstatFailed Verstat = "TN-Validation-Failed"
+	// VerstatNone is for requests without an Identity header
+	VerstatNone Verstat = "No-TN-Validation"
+)
+
+// IdentityResult is the outcome of verifying a request
+type IdentityResult struct {
+	Status Verstat
+	// PASSporT is the verified claims, nil unless Status is VerstatPassed
+	PASSporT *PASSporT
+	// Err is why verification failed, an IdentityError
+	Err error
+}
+
+// IdentityVerifier is a verification service
+type IdentityVerifier struct {
+	Verifier PASSporTVerifier
+	// MaxAge is how old a PASSporT may be, DefaultIdentityMaxAge when zero
+	MaxAge time.Duration
+	// Required makes the Filter reject INVITEs failing verification with
+	// the status code of their IdentityError, rather than passing them on
+	Required bool
+	// Clock used to check freshness, DefaultClock when nil
+	Clock Clock
+}
+
+func identityError(code int, reason string) IdentityResult {
+	return IdentityResult{Status: VerstatFailed, Err: IdentityError{StatusCode: code, Reason: reason}}
+}
+
+// Verify checks the first Identity header of the request whose PASSporT
+// verifies, as RFC 8224 6.2 describes
+func (v *IdentityVerifier) Verify(request Message) IdentityResult {
+	values := request.Headers().Extensions.GetAll("Identity")
+	if len(values) == 0 {
+		return IdentityResult{Status: VerstatNone, Err: IdentityError{StatusCode: 428, Reason: ReasonPhrase(428)}}
+	}
+	var result IdentityResult
+	for _, value := range values {
+		if result = v.verify(request, value); result.Status == VerstatPassed {
+			break
+		}
+	}
+	return result
+}
+
+func (v *IdentityVerifier) verify(request Message, value string) IdentityResult {
+	h, err := ParseIdentityHeader(value)
+	if err != nil {
+		return identityError(438, err.Error())
+	}
+	parts := strings.Split(h.Token, ".")
+	var header PASSporTHeader
+	var claims PASSporT
+	encodedHeader, err := base64url.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(encodedHeader, &header)
+	}
+	encodedClaims, claimsErr := base64url.DecodeString(parts[1])
+	if claimsErr == nil {
+		claimsErr = json.Unmarshal(encodedClaims, &claims)
+	}
+	signature, signatureErr := base64url.DecodeString(parts[2])
+	if err != nil || claimsErr != nil || signatureErr != nil {
+		return identityError(438, "malformed PASSporT")
+	}
+	if header.Typ != "passport" || header.Ppt != h.Ppt {
+		return identityError(438, "PASSporT type doesn't match the header")
+	}
+	info := h.Info
+	if info == "" {
+		info = header.X5u
+	}
+	if err := v.Verifier.Verify(info, header.Alg, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		if identity, ok := err.(IdentityError); ok {
+			return IdentityResult{Status: VerstatFailed, Err: identity}
+		}
+		return identityError(438, err.Error())
+	}
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultIdentityMaxAge
+	}
+	if age := clockOrDefault(v.Clock).Now().Sub(time.Unix(claims.Iat, 0)); age > maxAge || age < -maxAge {
+		return identityError(403, "Stale Date")
+	}
+	orig, dest := claims.Orig.URI, claims.Dest.URI
+	if tn, ok := telephoneNumber(callingIdentity(request)); ok {
+		if claims.Orig.TN != tn {
+			return identityError(438, "orig doesn't match the caller")
+		}
+	} else if orig != callingIdentity(request) {
+		return identityError(438, "orig doesn't match the caller")
+	}
+	to := request.Headers().To.Uri()
+	matched := false
+	if tn, ok := telephoneNumber(to); ok {
+		for _, each := range claims.Dest.TN {
+			matched = matched || each == tn
+		}
+	} else {
+		for _, each := range dest {
+			matched = matched || each == to
+		}
+	}
+	if !matched {
+		return identityError(438, "dest doesn't match the callee")
+	}
+	return IdentityResult{Status: VerstatPassed, PASSporT: &claims}
+}
+
+var verstatParam = regexp.MustCompile(`(?i);verstat=[^;@?>]*`)
+
+// withVerstat sets the verstat parameter of a URI: in the user part of
+// SIP URIs with one, as 3GPP TS 24.229 has it, and in the URI otherwise
+func withVerstat(uri string, status Verstat) string {
+	uri = verstatParam.ReplaceAllString(uri, "")
+	param := ";verstat=" + string(status)
+	if UriScheme(uri) != "tel" {
+		if at := strings.Index(uri, "@"); at >= 0 {
+			return uri[:at] + param + uri[at:]
+		}
+	}
+	if question := strings.Index(uri, "?"); question >= 0 {
+		return uri[:question] + param + uri[question:]
+	}
+	return uri + param
+}
+
+// Filter returns a RequestFilter verifying initial INVITEs. The outcome is
+// recorded as the verstat parameter of the From URI, replacing any the
+// request came with, for the UAS to read with RequestVerstat. When
+// Required is set, INVITEs that fail are rejected instead
+func (v *IdentityVerifier) Filter() RequestFilter {
+	return func(in Incoming) (*Response, bool) {
+		request := in.Message
+		if request.Method() != "INVITE" || request.Headers().To.Param("tag") != "" {
+			return nil, true
+		}
+		result := v.Verify(request)
+		if result.Status != VerstatPassed && v.Required {
+			identity := result.Err.(IdentityError)
+			response := NewResponse(request, identity.StatusCode)
+			if identity.StatusCode == 403 {
+				// the reason phrase RFC 8224 gives stale PASSporTs
+				response.reason = identity.Reason
+			}
+			return response, false
+		}
+		from := request.Headers().From
+		from.SetUri(withVerstat(from.Uri(), result.Status))
+		return nil, true
+	}
+}
+
+// RequestVerstat returns the verification status an IdentityVerifier
+// recorded on the request, or "" if it wasn't verified
+func RequestVerstat(request Message) Verstat {
+	match := verstatParam.FindString(request.Headers().From.Uri())
+	if match == "" {
+		return ""
+	}
+	return Verstat(match[len(";verstat="):])
+}
+
+// ES256Signer signs PASSporTs with an ECDSA P-256 key
+type ES256Signer struct {
+	Key *ecdsa.PrivateKey
+}
+
+func (ES256Signer) Algorithm() string {
+	return "ES256"
+}
+
+// Sign returns the signature as the JWS r||s concatenation
+func (s ES256Signer) Sign(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.Key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	ss.FillBytes(signature[32:])
+	return signature, nil
+}
+
+// ES256Verifier verifies ES256 PASSporTs with the key of the certificate
+// at info, as returned by Key
+type ES256Verifier struct {
+	Key func(info string) (*ecdsa.PublicKey, error)
+}
+
+func (v ES256Verifier) Verify(info, alg string, input, signature []byte) error {
+	if alg != "ES256" {
+		return IdentityError{StatusCode: 437, Reason: ReasonPhrase(437)}
+	}
+	key, err := v.Key(info)
+	if err != nil {
+		return IdentityError{StatusCode: 436, Reason: err.Error()}
+	}
+	digest := sha256.Sum256(input)
+	if len(signature) != 64 || !ecdsa.Verify(key, digest[:],
+		new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return IdentityError{StatusCode: 438, Reason: "invalid signature"}
+	}
+	return nil
+}
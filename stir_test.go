@@ -0,0 +1,94 @@
+package slurp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	clock := NewFakeClock(time.Unix(1600000000, 0))
+	const x5u = "https://cert.example.org/passport.cer"
+	signer := &IdentitySigner{
+		Signer: ES256Signer{Key: key},
+		X5u:    x5u,
+		Attest: AttestationFull,
+		OrigId: "123e4567-e89b-12d3-a456-426655440000",
+		Clock:  clock,
+	}
+	verifier := &IdentityVerifier{
+		Verifier: ES256Verifier{Key: func(info string) (*ecdsa.PublicKey, error) {
+			if info != x5u {
+				return nil, fmt.Errorf("unknown certificate %s", info)
+			}
+			return &key.PublicKey, nil
+		}},
+		Required: true,
+		Clock:    clock,
+	}
+	newInvite := func() Message {
+		m, err := NewInvite().To("sip:+1-215-555-1212@biloxi.com;user=phone").FromUri("tel:+12025550100").Build()
+		assert.Nil(t, err)
+		return m
+	}
+
+	invite := newInvite()
+	_, err = signer.Interceptor()(invite, Outbound)
+	assert.Nil(t, err)
+	identity, err := ParseIdentityHeader(invite.Headers().Extensions.Get("Identity"))
+	assert.Nil(t, err)
+	assert.Equal(t, x5u, identity.Info)
+	assert.Equal(t, "shaken", identity.Ppt)
+
+	parsed, err := ParseMessage(invite.Render())
+	assert.Nil(t, err)
+	result := verifier.Verify(parsed)
+	assert.Equal(t, VerstatPassed, result.Status)
+	if assert.NotNil(t, result.PASSporT) {
+		assert.Equal(t, AttestationFull, result.PASSporT.Attest)
+		assert.Equal(t, "12025550100", result.PASSporT.Orig.TN)
+		assert.Equal(t, []string{"12155551212"}, result.PASSporT.Dest.TN)
+	}
+	_, ok := verifier.Filter()(Incoming{Message: parsed})
+	assert.True(t, ok)
+	assert.Equal(t, VerstatPassed, RequestVerstat(parsed))
+	assert.Equal(t, "tel:+12025550100;verstat=TN-Validation-Passed", parsed.Headers().From.Uri())
+	assert.Equal(t, "sip:+1215;verstat=No-TN-Validation@biloxi.com;user=phone",
+		withVerstat("sip:+1215;verstat=TN-Validation-Passed@biloxi.com;user=phone", VerstatNone))
+
+	// a PASSporT signed for another caller
+	spoofed := newInvite()
+	spoofed.Headers().Extensions.Add("Identity", invite.Headers().Extensions.Get("Identity"))
+	spoofed.Headers().From.SetUri("tel:+12025550199")
+	assert.Equal(t, IdentityError{StatusCode: 438, Reason: "orig doesn't match the caller"}, verifier.Verify(spoofed).Err)
+
+	clock.Advance(2 * time.Minute)
+	response, ok := verifier.Filter()(Incoming{Message: parsed})
+	assert.False(t, ok)
+	assert.Equal(t, 403, response.StatusCode())
+	assert.Equal(t, "Stale Date", response.Reason())
+
+	response, _ = verifier.Filter()(Incoming{Message: newInvite()})
+	assert.Equal(t, 428, response.StatusCode())
+
+	// without Required, requests go through and the UAS decides
+	verifier.Required = false
+	unsigned := newInvite()
+	_, ok = verifier.Filter()(Incoming{Message: unsigned})
+	assert.True(t, ok)
+	assert.Equal(t, VerstatNone, RequestVerstat(unsigned))
+
+	signer.X5u = "https://cert.example.org/other.cer"
+	other := newInvite()
+	assert.Nil(t, signer.Sign(other))
+	assert.Equal(t, 436, verifier.Verify(other).Err.(IdentityError).StatusCode)
+}
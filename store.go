@@ -0,0 +1,254 @@
+package slurp
+
+/*
+Stores let the registrar and authentication modules plug into existing
+databases. The in-memory implementations suit tests and small deployments,
+and the file-backed ones persist across restarts.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialStore looks up the HA1 hash, MD5(username:realm:password), of a
+// user. ok is false when the user is unknown
+type CredentialStore interface {
+	HA1(username, realm string) (ha1 string, ok bool, err error)
+}
+
+// Binding maps an address-of-record to one of its contacts, as created by
+// a REGISTER. Q is the q-value of the contact, 1 when it had none
+type Binding struct {
+	Contact  string    `json:"contact"`
+	Expires  time.Time `json:"expires"`
+	Q        float64   `json:"q"`
+	CallId   string    `json:"call_id"`
+	Sequence int       `json:"cseq"`
+	// Path is the Path of the REGISTER, which requests for the contact
+	// are routed through (RFC 3327)
+	Path []string `json:"path,omitempty"`
+}
+
+// LocationStore keeps the current bindings of each address-of-record
+type LocationStore interface {
+	// Bindings returns the unexpired bindings of aor
+	Bindings(aor string) ([]Binding, error)
+	// Store adds a binding, replacing any other for the same contact, as
+	// EqualUris compares them
+	Store(aor string, binding Binding) error
+	// Remove deletes the binding of aor for contact
+	Remove(aor, contact string) error
+	// RemoveAll deletes every binding of aor
+	RemoveAll(aor string) error
+}
+
+// MemoryCredentials is a CredentialStore held in memory
+type MemoryCredentials struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// NewMemoryCredentials creates an empty MemoryCredentials
+func NewMemoryCredentials() *MemoryCredentials {
+	return &MemoryCredentials{users: make(map[string]string)}
+}
+
+func credentialKey(username, realm string) string {
+	return username + ":" + realm
+}
+
+// SetHA1 stores the hash of a user, as produced by HA1
+func (c *MemoryCredentials) SetHA1(username, realm, ha1 string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[credentialKey(username, realm)] = ha1
+}
+
+// SetPassword stores the hash of a user's password
+func (c *MemoryCredentials) SetPassword(username, realm, password string) {
+	c.SetHA1(username, realm, HA1(username, realm, password))
+}
+
+// Delete removes a user
+func (c *MemoryCredentials) Delete(username, realm string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, credentialKey(username, realm))
+}
+
+func (c *MemoryCredentials) HA1(username, realm string) (string, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ha1, ok := c.users[credentialKey(username, realm)]
+	return ha1, ok, nil
+}
+
+// LoadCredentialsFile reads credentials from a file in the htdigest format,
+// one username:realm:ha1 per line. Empty lines and lines starting with #
+// are ignored
+func LoadCredentialsFile(path string) (*MemoryCredentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	credentials := NewMemoryCredentials()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected username:realm:ha1", path, line)
+		}
+		credentials.SetHA1(fields[0], fields[1], fields[2])
+	}
+	return credentials, scanner.Err()
+}
+
+// MemoryLocations is a LocationStore held in memory
+type MemoryLocations struct {
+	// Clock used to expire bindings, DefaultClock when nil
+	Clock    Clock
+	mu       sync.RWMutex
+	bindings map[string][]Binding
+}
+
+// NewMemoryLocations creates an empty MemoryLocations
+func NewMemoryLocations() *MemoryLocations {
+	return &MemoryLocations{bindings: make(map[string][]Binding)}
+}
+
+func (l *MemoryLocations) Bindings(aor string) ([]Binding, error) {
+	now := clockOrDefault(l.Clock).Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var current []Binding
+	for _, binding := range l.bindings[aor] {
+		if binding.Expires.After(now) {
+			current = append(current, binding)
+		}
+	}
+	return current, nil
+}
+
+// AORs returns the addresses-of-record with unexpired bindings, sorted
+func (l *MemoryLocations) AORs() []string {
+	now := clockOrDefault(l.Clock).Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var aors []string
+	for aor, bindings := range l.bindings {
+		for _, binding := range bindings {
+			if binding.Expires.After(now) {
+				aors = append(aors, aor)
+				break
+			}
+		}
+	}
+	sort.Strings(aors)
+	return aors
+}
+
+func (l *MemoryLocations) Store(aor string, binding Binding) error {
+	now := clockOrDefault(l.Clock).Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := []Binding{binding}
+	for _, other := range l.bindings[aor] {
+		if !EqualUris(other.Contact, binding.Contact) && other.Expires.After(now) {
+			kept = append(kept, other)
+		}
+	}
+	l.bindings[aor] = kept
+	return nil
+}
+
+func (l *MemoryLocations) Remove(aor, contact string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var kept []Binding
+	for _, other := range l.bindings[aor] {
+		if !EqualUris(other.Contact, contact) {
+			kept = append(kept, other)
+		}
+	}
+	if len(kept) == 0 {
+		delete(l.bindings, aor)
+	} else {
+		l.bindings[aor] = kept
+	}
+	return nil
+}
+
+func (l *MemoryLocations) RemoveAll(aor string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.bindings, aor)
+	return nil
+}
+
+// FileLocations is a LocationStore kept in memory and saved to a JSON file
+// after every change, so bindings survive restarts
+type FileLocations struct {
+	*MemoryLocations
+	path string
+	save sync.Mutex
+}
+
+// OpenFileLocations loads the bindings saved at path, which need not exist yet
+func OpenFileLocations(path string) (*FileLocations, error) {
+	l := &FileLocations{MemoryLocations: NewMemoryLocations(), path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.bindings); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return l, nil
+}
+
+// flush writes the bindings to a temporary file, then renames it over the
+// old one so a crash never leaves a truncated file behind
+func (l *FileLocations) flush() error {
+	l.save.Lock()
+	defer l.save.Unlock()
+	l.mu.RLock()
+	data, err := json.MarshalIndent(l.bindings, "", "  ")
+	l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(l.path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(l.path+".tmp", l.path)
+}
+
+func (l *FileLocations) Store(aor string, binding Binding) error {
+	l.MemoryLocations.Store(aor, binding)
+	return l.flush()
+}
+
+func (l *FileLocations) Remove(aor, contact string) error {
+	l.MemoryLocations.Remove(aor, contact)
+	return l.flush()
+}
+
+func (l *FileLocations) RemoveAll(aor string) error {
+	l.MemoryLocations.RemoveAll(aor)
+	return l.flush()
+}
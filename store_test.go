@@ -0,0 +1,58 @@
+package slurp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slurp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htdigest")
+	ha1 := HA1("bob", "biloxi.com", "zanzibar")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("# users\nbob:biloxi.com:"+ha1+"\n"), 0600))
+	credentials, err := LoadCredentialsFile(path)
+	assert.Nil(t, err)
+	found, ok, err := credentials.HA1("bob", "biloxi.com")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ha1, found)
+	_, ok, _ = credentials.HA1("alice", "biloxi.com")
+	assert.False(t, ok)
+}
+
+func TestFileLocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slurp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "locations.json")
+	clock := NewFakeClock(time.Unix(1000, 0))
+
+	locations, err := OpenFileLocations(path)
+	assert.Nil(t, err)
+	locations.Clock = clock
+	aor := "sip:bob@biloxi.com"
+	assert.Nil(t, locations.Store(aor, Binding{Contact: "sip:bob@192.0.2.4", Expires: clock.Now().Add(time.Hour)}))
+	assert.Nil(t, locations.Store(aor, Binding{Contact: "sip:bob@192.0.2.5", Expires: clock.Now().Add(time.Minute)}))
+	assert.Nil(t, locations.Store(aor, Binding{Contact: "sip:bob@192.0.2.4", Expires: clock.Now().Add(2 * time.Hour)}))
+
+	reopened, err := OpenFileLocations(path)
+	assert.Nil(t, err)
+	reopened.Clock = clock
+	bindings, err := reopened.Bindings(aor)
+	assert.Nil(t, err)
+	assert.Len(t, bindings, 2)
+
+	clock.Advance(2 * time.Minute)
+	bindings, _ = reopened.Bindings(aor)
+	assert.Len(t, bindings, 1)
+	assert.Nil(t, reopened.Remove(aor, "sip:bob@192.0.2.4"))
+	bindings, _ = reopened.Bindings(aor)
+	assert.Empty(t, bindings)
+}
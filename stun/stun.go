@@ -0,0 +1,168 @@
+/*
+Package stun implements the client side of STUN (RFC 5389) binding
+requests, which a UA behind NAT uses to discover its reflexive address:
+the address and port its packets appear to come from.
+*/
+package stun
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	magicCookie = 0x2112A442
+	headerSize  = 20
+
+	bindingRequest  = 0x0001
+	bindingResponse = 0x0101
+
+	attrMappedAddress    = 0x0001
+	attrXorMappedAddress = 0x0020
+)
+
+// RTO is the initial retransmission timeout of a binding request.
+// It doubles on every retransmission, per RFC 5389 7.2.1
+var RTO = 500 * time.Millisecond
+
+// MaxRetransmissions is the number of times a request is sent before giving up
+var MaxRetransmissions = 7
+
+var (
+	ErrInvalidMessage  = errors.New("stun: invalid message")
+	ErrNoMappedAddress = errors.New("stun: response has no mapped address")
+	ErrTimeout         = errors.New("stun: no response from server")
+)
+
+// TransactionID identifies a request and its response
+type TransactionID [12]byte
+
+// IsMessage reports whether data looks like a STUN message, which allows
+// STUN to be demultiplexed from SIP arriving on the same socket
+func IsMessage(data []byte) bool {
+	return len(data) >= headerSize &&
+		data[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(data[4:8]) == magicCookie
+}
+
+// NewBindingRequest creates a binding request with a random transaction ID
+func NewBindingRequest() (TransactionID, []byte) {
+	var id TransactionID
+	rand.Read(id[:])
+	data := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(data[0:2], bindingRequest)
+	binary.BigEndian.PutUint16(data[2:4], 0)
+	binary.BigEndian.PutUint32(data[4:8], magicCookie)
+	copy(data[8:20], id[:])
+	return id, data
+}
+
+// ParseBindingResponse reads the transaction ID and the reflexive address
+// from a binding success response. XOR-MAPPED-ADDRESS is preferred, with
+// a fallback to MAPPED-ADDRESS for older servers
+func ParseBindingResponse(data []byte) (id TransactionID, addr *net.UDPAddr, err error) {
+	if !IsMessage(data) || binary.BigEndian.Uint16(data[0:2]) != bindingResponse {
+		return id, nil, ErrInvalidMessage
+	}
+	copy(id[:], data[8:20])
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < headerSize+length {
+		return id, nil, ErrInvalidMessage
+	}
+	attributes := data[headerSize : headerSize+length]
+	var mapped *net.UDPAddr
+	for len(attributes) >= 4 {
+		kind := binary.BigEndian.Uint16(attributes[0:2])
+		size := int(binary.BigEndian.Uint16(attributes[2:4]))
+		if len(attributes) < 4+size {
+			return id, nil, ErrInvalidMessage
+		}
+		value := attributes[4 : 4+size]
+		switch kind {
+		case attrXorMappedAddress:
+			if addr, err := parseAddress(value, data[4:20]); err == nil {
+				return id, addr, nil
+			}
+		case attrMappedAddress:
+			mapped, _ = parseAddress(value, nil)
+		}
+		// attributes are padded to a multiple of 4 bytes
+		padded := (size + 3) &^ 3
+		if len(attributes) < 4+padded {
+			break
+		}
+		attributes = attributes[4+padded:]
+	}
+	if mapped == nil {
+		return id, nil, ErrNoMappedAddress
+	}
+	return id, mapped, nil
+}
+
+// parseAddress decodes an address attribute. When xor is set, the port and
+// address are XORed with it (the magic cookie followed by the transaction ID)
+func parseAddress(value []byte, xor []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, ErrInvalidMessage
+	}
+	size := net.IPv4len
+	if value[1] == 0x02 {
+		size = net.IPv6len
+	}
+	if len(value) < 4+size {
+		return nil, ErrInvalidMessage
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xor != nil {
+		port ^= uint16(magicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Query sends a binding request to server over conn and waits for the
+// response, retransmitting as the RFC requires. conn must not be read by
+// anyone else while the query is outstanding
+func Query(ctx context.Context, conn net.PacketConn, server string) (*net.UDPAddr, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	id, request := NewBindingRequest()
+	buffer := make([]byte, 1500)
+	timeout := RTO
+	for i := 0; i < MaxRetransmissions; i++ {
+		if _, err := conn.WriteTo(request, serverAddr); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+			if responseId, addr, err := ParseBindingResponse(buffer[:n]); err == nil && responseId == id {
+				conn.SetReadDeadline(time.Time{})
+				return addr, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		timeout *= 2
+	}
+	conn.SetReadDeadline(time.Time{})
+	return nil, ErrTimeout
+}
@@ -0,0 +1,66 @@
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newBindingResponse builds a success response carrying addr as XOR-MAPPED-ADDRESS
+func newBindingResponse(id TransactionID, addr *net.UDPAddr) []byte {
+	data := make([]byte, headerSize+12)
+	binary.BigEndian.PutUint16(data[0:2], bindingResponse)
+	binary.BigEndian.PutUint16(data[2:4], 12)
+	binary.BigEndian.PutUint32(data[4:8], magicCookie)
+	copy(data[8:20], id[:])
+	binary.BigEndian.PutUint16(data[20:22], attrXorMappedAddress)
+	binary.BigEndian.PutUint16(data[22:24], 8)
+	data[25] = 0x01
+	binary.BigEndian.PutUint16(data[26:28], uint16(addr.Port)^uint16(magicCookie>>16))
+	ip := addr.IP.To4()
+	for i := range ip {
+		data[28+i] = ip[i] ^ data[4+i]
+	}
+	return data
+}
+
+func TestParseBindingResponse(t *testing.T) {
+	id, request := NewBindingRequest()
+	assert.True(t, IsMessage(request))
+	assert.False(t, IsMessage([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n")))
+	expected := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 32853}
+	parsedId, addr, err := ParseBindingResponse(newBindingResponse(id, expected))
+	assert.Nil(t, err)
+	assert.Equal(t, id, parsedId)
+	assert.Equal(t, expected.String(), addr.String())
+}
+
+func TestQuery(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer server.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		n, from, err := server.ReadFrom(buffer)
+		if err != nil || !IsMessage(buffer[:n]) {
+			return
+		}
+		var id TransactionID
+		copy(id[:], buffer[8:20])
+		server.WriteTo(newBindingResponse(id, from.(*net.UDPAddr)), from)
+	}()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr, err := Query(ctx, client, server.LocalAddr().String())
+	assert.Nil(t, err)
+	assert.Equal(t, client.LocalAddr().String(), addr.String())
+}
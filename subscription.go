@@ -0,0 +1,434 @@
+package slurp
+
+/*
+A Subscription is the subscriber's side of an event subscription (RFC
+6665). SUBSCRIBE creates it, the notifier reports the state of the
+resource in NOTIFYs, which may arrive before the 2xx to the SUBSCRIBE, and
+the subscriber refreshes it before it expires or ends it with Expires: 0.
+*/
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// SubscriptionState is the state of a subscription, per RFC 6665 4.1.2
+type SubscriptionState int
+
+const (
+	// SubscriptionPending subscriptions are waiting for the notifier to
+	// authorize them
+	SubscriptionPending SubscriptionState = iota
+	SubscriptionActive
+	SubscriptionTerminated
+)
+
+var subscriptionStateNames = []string{"pending", "active", "terminated"}
+
+func (s SubscriptionState) String() string {
+	if int(s) < len(subscriptionStateNames) {
+		return subscriptionStateNames[s]
+	}
+	return "SubscriptionState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// SubscriptionStatus is the value of a Subscription-State header
+type SubscriptionStatus struct {
+	State SubscriptionState
+	// Expires is the time left on an active or pending subscription, zero
+	// when the notifier didn't say
+	Expires time.Duration
+	// Reason is why a terminated subscription ended, e.g. timeout or
+	// rejected, and RetryAfter when it may be subscribed to again
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// ParseSubscriptionStatus reads a Subscription-State header value, e.g.
+// active;expires=3600 or terminated;reason=giveup;retry-after=60
+func ParseSubscriptionStatus(value string) (SubscriptionStatus, error) {
+	parts := strings.Split(value, ";")
+	var status SubscriptionStatus
+	switch strings.ToLower(strings.TrimSpace(parts[0])) {
+	case "active":
+		status.State = SubscriptionActive
+	case "pending":
+		status.State = SubscriptionPending
+	case "terminated":
+		status.State = SubscriptionTerminated
+	default:
+		return status, Violation{Header: "Subscription-State", Reason: "unknown state " + strconv.Quote(parts[0])}
+	}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "expires":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return status, Violation{Header: "Subscription-State", Reason: "invalid expires"}
+			}
+			status.Expires = time.Duration(seconds) * time.Second
+		case "reason":
+			status.Reason = strings.ToLower(value)
+		case "retry-after":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return status, Violation{Header: "Subscription-State", Reason: "invalid retry-after"}
+			}
+			status.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return status, nil
+}
+
+func (s SubscriptionStatus) String() string {
+	value := s.State.String()
+	if s.State != SubscriptionTerminated && s.Expires > 0 {
+		value += ";expires=" + strconv.Itoa(int(s.Expires/time.Second))
+	}
+	if s.Reason != "" {
+		value += ";reason=" + s.Reason
+	}
+	if s.RetryAfter > 0 {
+		value += ";retry-after=" + strconv.Itoa(int(s.RetryAfter/time.Second))
+	}
+	return value
+}
+
+// Retry reports whether a terminated subscription may be subscribed to
+// again, and after how long, per the reasons of RFC 6665 4.1.3. The
+// resource is gone or refused us for rejected, noresource and invariant
+func (s SubscriptionStatus) Retry() (time.Duration, bool) {
+	switch s.Reason {
+	case "rejected", "noresource", "invariant":
+		return 0, false
+	case "probation", "giveup":
+		return s.RetryAfter, true
+	}
+	return 0, true
+}
+
+// Notification is the content of a NOTIFY received for a subscription
+type Notification struct {
+	Status SubscriptionStatus
+	// Request is the NOTIFY, whose body is the state of the resource
+	Request Message
+}
+
+// Subscription is a subscription to an event package of a resource
+type Subscription struct {
+	Event Event
+	// Dialog is the dialog of the subscription, once the 2xx to the
+	// SUBSCRIBE or the first NOTIFY arrived
+	Dialog *Dialog
+	// Addr is the host:port the SUBSCRIBE was sent to, and refreshes are
+	Addr string
+	// Notifications receives the NOTIFYs of the subscription. It is closed
+	// once the subscription is terminated
+	Notifications <-chan Notification
+	notifications chan Notification
+	ua            *UserAgent
+	subscribe     Message
+	expires       time.Duration
+	mu            sync.Mutex
+	status        SubscriptionStatus
+	granted       time.Duration
+	refresh       Timer
+	// ended stops refreshes, once unsubscribed or terminated
+	ended bool
+}
+
+// expiresHeader reads the Expires header of a message, e.g. a SUBSCRIBE or
+// a REGISTER, or the response to one
+func expiresHeader(m Message) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(strings.TrimSpace(m.Headers().Extensions.Get("Expires")))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// Subscribe sends subscribe, a SUBSCRIBE with an Event header, to addr,
+// asking for a subscription lasting expires, and returns the subscription
+// once it was accepted. The subscription is refreshed when half of the
+// duration the notifier granted has passed, until Unsubscribe is called
+// or the notifier terminates it. Handle must be given the NOTIFYs the
+// UserAgent receives for it, which FindSubscription finds, even before
+// Subscribe returns. A failure response is returned as a RejectedError
+func (ua *UserAgent) Subscribe(ctx context.Context, addr string, subscribe Message, expires time.Duration) (*Subscription, error) {
+	if ua.Draining() {
+		return nil, ShuttingDownError{}
+	}
+	event, ok := MessageEvent(subscribe)
+	if !ok {
+		return nil, Violation{Header: "Event", Reason: "missing mandatory header"}
+	}
+	headers, control := subscribe.Headers(), subscribe.Control()
+	if headers.From.Param("tag") == "" {
+		headers.From.SetParam("tag", generateTag())
+	}
+	if control.CallId == "" {
+		control.CallId = generateTag() + generateTag()
+	}
+	if control.Sequence == 0 {
+		control.Sequence = 1
+	}
+	headers.Extensions.Set("Expires", strconv.Itoa(int(expires/time.Second)))
+	notifications := make(chan Notification, 8)
+	s := &Subscription{
+		Event:         event,
+		Addr:          addr,
+		Notifications: notifications,
+		notifications: notifications,
+		ua:            ua,
+		subscribe:     subscribe,
+		expires:       expires,
+	}
+	// NOTIFYs may arrive before the response to the SUBSCRIBE
+	ua.mu.Lock()
+	ua.subscriptions[s] = true
+	ua.mu.Unlock()
+	response, err := ua.Request(ctx, addr, subscribe)
+	if err == nil && !IsSuccess(response.StatusCode()) {
+		err = RejectedError{StatusCode: response.StatusCode(), Reason: response.Reason()}
+	}
+	if err != nil {
+		s.terminate(SubscriptionStatus{State: SubscriptionTerminated, Reason: "rejected"})
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Dialog == nil {
+		s.Dialog = newDialog(subscribe, response, true, ua.Clock, ua.RecordSink)
+	} else {
+		s.Dialog.Update(response)
+	}
+	if granted, ok := expiresHeader(response); ok {
+		s.schedule(granted)
+	} else {
+		s.schedule(expires)
+	}
+	return s, nil
+}
+
+// FindSubscription returns the subscription the NOTIFY is for, or nil
+func (ua *UserAgent) FindSubscription(notify Message) *Subscription {
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	for s := range ua.subscriptions {
+		if s.Matches(notify) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Status returns the state of the subscription, as last notified
+func (s *Subscription) Status() SubscriptionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// schedule refreshes the subscription when half of granted has passed,
+// the lock must be held
+func (s *Subscription) schedule(granted time.Duration) {
+	if s.ended || granted <= 0 {
+		return
+	}
+	if s.refresh != nil {
+		s.refresh.Stop()
+	}
+	s.granted = granted
+	s.refresh = clockOrDefault(s.ua.Clock).AfterFunc(granted/2, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.ua.Timers().F)
+		defer cancel()
+		s.Refresh(ctx)
+	})
+}
+
+// Refresh sends a SUBSCRIBE in the subscription's dialog asking for the
+// duration it was created with. Failures are returned as a RejectedError.
+// A 481 means the notifier forgot the subscription and terminates it,
+// while after any other the subscription lasts until it expires (RFC 6665
+// 4.1.2.2), when it terminates with reason timeout
+func (s *Subscription) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	ended := s.ended
+	s.mu.Unlock()
+	if ended {
+		return nil
+	}
+	response, err := s.send(ctx, s.expires)
+	if err != nil {
+		if rejected, ok := err.(RejectedError); ok && rejected.StatusCode != 481 {
+			s.mu.Lock()
+			if s.refresh != nil {
+				s.refresh.Stop()
+			}
+			// half of the duration last granted is left
+			s.refresh = clockOrDefault(s.ua.Clock).AfterFunc(s.granted/2, func() {
+				s.terminate(SubscriptionStatus{State: SubscriptionTerminated, Reason: "timeout"})
+			})
+			s.mu.Unlock()
+		}
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	granted, ok := expiresHeader(response)
+	if !ok {
+		granted = s.expires
+	}
+	s.schedule(granted)
+	return nil
+}
+
+// Unsubscribe ends the subscription with a SUBSCRIBE with Expires: 0. The
+// notifier confirms it with a final NOTIFY, which closes Notifications.
+// When the SUBSCRIBE fails, the subscription is terminated right away
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return nil
+	}
+	s.ended = true
+	if s.refresh != nil {
+		s.refresh.Stop()
+	}
+	s.mu.Unlock()
+	_, err := s.send(ctx, 0)
+	if err != nil {
+		s.terminate(SubscriptionStatus{State: SubscriptionTerminated})
+	}
+	return err
+}
+
+// send sends a SUBSCRIBE in the dialog. A 481 terminates the subscription
+func (s *Subscription) send(ctx context.Context, expires time.Duration) (*Response, error) {
+	request := s.Dialog.NewRequest("SUBSCRIBE")
+	SetEvent(request, s.Event)
+	request.Headers().Extensions.Set("Expires", strconv.Itoa(int(expires/time.Second)))
+	if contacts := s.subscribe.Headers().Contacts; len(contacts) > 0 {
+		request.Headers().Contacts = contacts
+	}
+	response, err := s.ua.Request(ctx, s.Addr, request)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSuccess(response.StatusCode()) {
+		if response.StatusCode() == 481 {
+			s.terminate(SubscriptionStatus{State: SubscriptionTerminated})
+		}
+		return nil, RejectedError{StatusCode: response.StatusCode(), Reason: response.Reason()}
+	}
+	return response, nil
+}
+
+// terminate ends the subscription with status
+func (s *Subscription) terminate(status SubscriptionStatus) {
+	s.mu.Lock()
+	dialog := s.terminated(status)
+	s.mu.Unlock()
+	if dialog != nil {
+		dialog.Terminate("subscription terminated")
+	}
+}
+
+// terminated ends the subscription with status and returns its dialog to
+// terminate, the lock must be held
+func (s *Subscription) terminated(status SubscriptionStatus) *Dialog {
+	if s.status.State == SubscriptionTerminated {
+		return nil
+	}
+	s.status = status
+	s.ended = true
+	if s.refresh != nil {
+		s.refresh.Stop()
+	}
+	close(s.notifications)
+	s.ua.mu.Lock()
+	delete(s.ua.subscriptions, s)
+	s.ua.mu.Unlock()
+	return s.Dialog
+}
+
+// Matches reports whether the NOTIFY is for this subscription: it is sent
+// in reply to the SUBSCRIBE, and for the same event
+func (s *Subscription) Matches(notify Message) bool {
+	if notify.Method() != "NOTIFY" || notify.Control().CallId != s.subscribe.Control().CallId ||
+		notify.Headers().To.Param("tag") != s.subscribe.Headers().From.Param("tag") {
+		return false
+	}
+	event, ok := MessageEvent(notify)
+	return ok && event.Is(s.Event)
+}
+
+// Handle processes a request received for the subscription, returning the
+// response to send, or nil if it isn't a NOTIFY the subscription Matches.
+// A NOTIFY that arrives before the 2xx to the SUBSCRIBE creates the dialog
+func (s *Subscription) Handle(request Message) *Response {
+	if !s.Matches(request) {
+		return nil
+	}
+	value := request.Headers().Extensions.Get("Subscription-State")
+	if value == "" {
+		return NewResponse(request, 400)
+	}
+	status, err := ParseSubscriptionStatus(value)
+	if err != nil {
+		return NewResponse(request, 400)
+	}
+	response := NewResponse(request, 200)
+	s.mu.Lock()
+	if s.status.State == SubscriptionTerminated {
+		s.mu.Unlock()
+		return NewResponse(request, 481)
+	}
+	if s.Dialog == nil {
+		// the NOTIFY stands in for the 2xx, from the other direction
+		s.Dialog = newDialog(request, response, false, s.ua.Clock, s.ua.RecordSink)
+		s.Dialog.Caller = true
+		s.Dialog.LocalSeq = s.subscribe.Control().Sequence
+	} else {
+		s.Dialog.mu.Lock()
+		s.Dialog.RemoteSeq = request.Control().Sequence
+		if len(request.Headers().Contacts) > 0 {
+			s.Dialog.RemoteTarget = request.Headers().Contacts[0].Uri()
+		}
+		s.Dialog.mu.Unlock()
+	}
+	notification := Notification{Status: status, Request: request}
+	if status.State == SubscriptionTerminated {
+		s.notify(notification, true)
+		dialog := s.terminated(status)
+		s.mu.Unlock()
+		if dialog != nil {
+			dialog.Terminate("subscription terminated")
+		}
+		return response
+	}
+	s.status = status
+	if status.Expires > 0 {
+		s.schedule(status.Expires)
+	}
+	s.notify(notification, false)
+	s.mu.Unlock()
+	return response
+}
+
+// notify delivers a notification, the lock must be held. Intermediate ones
+// are dropped rather than block when nobody is reading, always leaving
+// room for the final one
+func (s *Subscription) notify(notification Notification, final bool) {
+	if final || len(s.notifications) < cap(s.notifications)-1 {
+		s.notifications <- notification
+	}
+}
@@ -0,0 +1,114 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionStatus(t *testing.T) {
+	status, err := ParseSubscriptionStatus("active;expires=3600")
+	assert.Nil(t, err)
+	assert.Equal(t, SubscriptionStatus{State: SubscriptionActive, Expires: time.Hour}, status)
+	assert.Equal(t, "active;expires=3600", status.String())
+
+	status, err = ParseSubscriptionStatus("terminated; reason=giveup; retry-after=30")
+	assert.Nil(t, err)
+	assert.Equal(t, "terminated;reason=giveup;retry-after=30", status.String())
+	after, retry := status.Retry()
+	assert.True(t, retry)
+	assert.Equal(t, 30*time.Second, after)
+	_, retry = SubscriptionStatus{State: SubscriptionTerminated, Reason: "rejected"}.Retry()
+	assert.False(t, retry)
+
+	_, err = ParseSubscriptionStatus("dormant")
+	assert.NotNil(t, err)
+}
+
+func TestSubscribe(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	local, remote := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	clock := NewFakeClock(time.Unix(0, 0))
+	local.Clock = clock
+	go func() {
+		for in := range local.Receive() {
+			if s := local.FindSubscription(in.Message); s != nil {
+				local.Send(context.Background(), in.Source.String(), s.Handle(in.Message))
+			}
+		}
+	}()
+
+	// the notifier sends its first NOTIFY before the 202
+	received := make(chan Message, 8)
+	go func() {
+		var dialog *Dialog
+		for in := range remote.Receive() {
+			received <- in.Message
+			response := NewResponse(in.Message, 202)
+			response.Headers().Extensions.Set("Expires", "60")
+			if dialog == nil {
+				response.Headers().Contacts = []AddressHeader{NewHeader(&ToFrom{}).SetUri("sip:pa@biloxi.com")}
+				dialog = NewDialog(in.Message, response, false)
+			}
+			var notify *Request
+			if expires, _ := expiresHeader(in.Message); expires == 0 {
+				notify = dialog.NewRequest("NOTIFY")
+				notify.Headers().Extensions.Set("Subscription-State", "terminated;reason=timeout")
+			} else if in.Message.Headers().To.Param("tag") == "" {
+				notify = dialog.NewRequest("NOTIFY")
+				notify.Headers().Extensions.Set("Subscription-State", "active;expires=60")
+				notify.Headers().Contacts = response.Headers().Contacts
+			}
+			if notify != nil {
+				notify.Headers().Extensions.Set("Event", "presence")
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				remote.Request(ctx, in.Source.String(), notify)
+				cancel()
+			}
+			remote.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := NewRequestBuilder("SUBSCRIBE").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").
+		Header("Event", "presence").Build()
+	assert.Nil(t, err)
+	s, err := local.Subscribe(ctx, b.LocalAddr().String(), request, time.Hour)
+	assert.Nil(t, err)
+	subscribe := <-received
+	assert.Equal(t, "3600", subscribe.Headers().Extensions.Get("Expires"))
+	notification := <-s.Notifications
+	assert.Equal(t, SubscriptionActive, notification.Status.State)
+	assert.Equal(t, "sip:pa@biloxi.com", s.Dialog.RemoteTarget)
+	assert.Equal(t, SubscriptionActive, s.Status().State)
+
+	// the refresh is due halfway through the 60 seconds granted
+	clock.Advance(29 * time.Second)
+	assert.Len(t, received, 0)
+	clock.Advance(time.Second)
+	refresh := <-received
+	assert.Equal(t, "SUBSCRIBE", refresh.Method())
+	assert.Equal(t, s.Dialog.RemoteTag, refresh.Headers().To.Param("tag"))
+	assert.Equal(t, "presence", refresh.Headers().Extensions.Get("Event"))
+
+	assert.Nil(t, s.Unsubscribe(ctx))
+	assert.Equal(t, "0", (<-received).Headers().Extensions.Get("Expires"))
+	notification, ok := <-s.Notifications
+	assert.True(t, ok)
+	assert.Equal(t, SubscriptionTerminated, notification.Status.State)
+	assert.Equal(t, "timeout", notification.Status.Reason)
+	_, ok = <-s.Notifications
+	assert.False(t, ok)
+	assert.Equal(t, Terminated, s.Dialog.State)
+	assert.Nil(t, local.FindSubscription(notification.Request))
+}
@@ -0,0 +1,494 @@
+package slurp
+
+/*
+Stream transports carry messages over connections, framed by their
+Content-Length. Idle connections are kept alive with the CRLF ping and
+pong of RFC 5626 4.4.1: a ping is a double CRLF between messages, which
+the peer answers with a single CRLF.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/trace"
+)
+
+var (
+	keepAlivePing = []byte("\r\n\r\n")
+	keepAlivePong = []byte("\r\n")
+)
+
+// DefaultPongTimeout is how long a ping waits for its pong, per RFC 5626
+const DefaultPongTimeout = 10 * time.Second
+
+// maxStreamMessage is the largest message read from a connection, which
+// must fit a pooled packet buffer
+const maxStreamMessage = 65535
+
+// TCPTransport is a Transport over TCP, keeping one connection per peer.
+// Connections opened by peers are used for sending to them as well, and
+// under the peer's advertised address too once aliased (RFC 5923)
+type TCPTransport struct {
+	// KeepAlive, when non-zero, is how long a connection may stay idle
+	// before a keep-alive ping is sent on it
+	KeepAlive time.Duration
+	// PongTimeout is how long to wait for the pong, DefaultPongTimeout
+	// when zero
+	PongTimeout time.Duration
+	// OnFailure, when set, is called with the peer of every connection
+	// lost other than by closing the transport: with a KeepAliveError when
+	// the pong didn't come, or the read error. A client registered over
+	// the connection should register again, which opens a new one
+	OnFailure func(addr string, err error)
+	// AcceptAliases lets peers ask for their connection to be reused with
+	// the Via alias parameter. As anyone may claim an address, RFC 5923
+	// only allows it when peers are authenticated, e.g. through TLS
+	AcceptAliases bool
+	// MaxMessageSize is the largest message read, in bytes. A connection
+	// carrying a larger one is closed, as the stream can't be resynced.
+	// Zero, or more than 65535, means 65535
+	MaxMessageSize int
+	// AttemptDelay is how long each connection attempt runs before the
+	// next target is tried too, DefaultAttemptDelay when zero
+	AttemptDelay time.Duration
+	// Blacklist, when set, holds the targets that connections aren't
+	// attempted to, and gets those no connection could be opened to
+	Blacklist *Blacklist
+	// Tracer, when set, records every message sent and received
+	Tracer   *trace.Tracer
+	Clock    Clock
+	listener net.Listener
+	packets  chan Packet
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[string]*streamConn
+	closed   bool
+}
+
+// streamConn is a connection to a peer, and its keep-alive state
+type streamConn struct {
+	conn net.Conn
+	addr string
+	// other addresses the connection is used for, see Alias. Guarded by
+	// the transport's mu
+	aliases []string
+	// serializes writes, so messages aren't interleaved
+	write    sync.Mutex
+	mu       sync.Mutex
+	active   time.Time
+	awaiting bool
+	timer    Timer
+	closed   bool
+}
+
+// ListenTCP creates a TCPTransport listening on address (host:port)
+func ListenTCP(address string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{
+		listener: listener,
+		packets:  make(chan Packet, 64),
+		conns:    make(map[string]*streamConn),
+	}
+	t.wg.Add(1)
+	go t.accept()
+	go func() {
+		t.wg.Wait()
+		close(t.packets)
+	}()
+	return t, nil
+}
+
+func (t *TCPTransport) accept() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		if _, err := t.add(conn, conn.RemoteAddr().String()); err != nil {
+			conn.Close()
+		}
+	}
+}
+
+// add puts conn in the connection table under addr and starts serving it
+func (t *TCPTransport) add(conn net.Conn, addr string) (*streamConn, error) {
+	c := &streamConn{conn: conn, addr: addr, active: t.clock().Now()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, net.ErrClosed
+	}
+	t.conns[addr] = c
+	t.wg.Add(1)
+	go t.serve(c)
+	if t.KeepAlive > 0 {
+		c.timer = t.clock().AfterFunc(t.KeepAlive, func() { t.keepAlive(c) })
+	}
+	return c, nil
+}
+
+func (t *TCPTransport) clock() Clock {
+	return clockOrDefault(t.Clock)
+}
+
+// serve reads messages and keep-alives from the connection until it fails
+func (t *TCPTransport) serve(c *streamConn) {
+	defer t.wg.Done()
+	r := bufio.NewReader(c.conn)
+	// crlf is true after a CRLF outside a message, which is a pong unless
+	// another follows it right away, making a ping, however it was split
+	crlf := false
+	for {
+		start, err := r.Peek(2)
+		if err != nil {
+			t.drop(c, err)
+			return
+		}
+		c.touch(t.clock().Now())
+		if string(start) == "\r\n" {
+			r.Discard(2)
+			if crlf {
+				crlf = false
+				if err := t.send(context.Background(), c, keepAlivePong); err != nil {
+					t.drop(c, err)
+					return
+				}
+			} else {
+				crlf = true
+				c.pong()
+			}
+			continue
+		}
+		crlf = false
+		data, err := readStreamMessage(r, t.maxMessageSize())
+		if err != nil {
+			t.drop(c, err)
+			return
+		}
+		packet := newPacket(data, c.conn.RemoteAddr())
+		t.Tracer.Trace(trace.Record{
+			Network:     t.Network(),
+			Source:      c.conn.RemoteAddr(),
+			Destination: c.conn.LocalAddr(),
+			Data:        packet.Data,
+		})
+		t.packets <- packet
+	}
+}
+
+func (t *TCPTransport) maxMessageSize() int {
+	if t.MaxMessageSize <= 0 || t.MaxMessageSize > maxStreamMessage {
+		return maxStreamMessage
+	}
+	return t.MaxMessageSize
+}
+
+// readStreamMessage reads one message of at most limit bytes, which ends
+// after the number of body bytes its Content-Length gives
+func readStreamMessage(r *bufio.Reader, limit int) ([]byte, error) {
+	var message bytes.Buffer
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		message.WriteString(line)
+		if message.Len() > limit {
+			return nil, MessageTooLargeError{Size: message.Len(), Limit: limit}
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			name = strings.TrimSpace(name)
+			if canonicalName(name) == "content-length" {
+				if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+					return nil, InvalidMessageFormatError(line)
+				}
+			}
+		}
+	}
+	if message.Len()+length > limit {
+		return nil, MessageTooLargeError{Size: message.Len() + length, Limit: limit}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	message.Write(body)
+	return message.Bytes(), nil
+}
+
+// keepAlive pings the connection if it has been idle for KeepAlive, and
+// fails it if the pong doesn't come within PongTimeout
+func (t *TCPTransport) keepAlive(c *streamConn) {
+	idle := t.clock().Now().Sub(c.lastActive())
+	if idle < t.KeepAlive {
+		c.schedule(t.clock(), t.KeepAlive-idle, func() { t.keepAlive(c) })
+		return
+	}
+	c.mu.Lock()
+	c.awaiting = true
+	c.mu.Unlock()
+	if err := t.send(context.Background(), c, keepAlivePing); err != nil {
+		t.drop(c, err)
+		return
+	}
+	timeout := t.PongTimeout
+	if timeout == 0 {
+		timeout = DefaultPongTimeout
+	}
+	c.schedule(t.clock(), timeout, func() {
+		c.mu.Lock()
+		awaiting := c.awaiting
+		c.mu.Unlock()
+		if awaiting {
+			t.drop(c, KeepAliveError{Addr: c.addr})
+			return
+		}
+		t.keepAlive(c)
+	})
+}
+
+// send writes data to the connection, bounded by ctx's deadline
+func (t *TCPTransport) send(ctx context.Context, c *streamConn, data []byte) error {
+	c.write.Lock()
+	defer c.write.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// drop closes the connection and removes it from the table, reporting
+// the failure unless the transport is closing
+func (t *TCPTransport) drop(c *streamConn, err error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+	c.conn.Close()
+	t.mu.Lock()
+	for _, addr := range append([]string{c.addr}, c.aliases...) {
+		if t.conns[addr] == c {
+			delete(t.conns, addr)
+		}
+	}
+	closing := t.closed
+	t.mu.Unlock()
+	if !closing && t.OnFailure != nil {
+		t.OnFailure(c.addr, err)
+	}
+}
+
+func (c *streamConn) touch(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = now
+}
+
+func (c *streamConn) lastActive() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+func (c *streamConn) pong() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.awaiting = false
+}
+
+// schedule replaces the connection's timer with one calling f after d
+func (c *streamConn) schedule(clock Clock, d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.timer = clock.AfterFunc(d, f)
+}
+
+// Alias makes requests sent to sentBy (host:port), the address a peer
+// gave in its Via, reuse the connection the peer opened from source
+// instead of opening another. It reports false if there is no such
+// connection, or AcceptAliases isn't set
+func (t *TCPTransport) Alias(sentBy string, source net.Addr) bool {
+	if !t.AcceptAliases {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.conns[source.String()]
+	if !ok {
+		return false
+	}
+	if sentBy != c.addr && t.conns[sentBy] != c {
+		c.aliases = append(c.aliases, sentBy)
+		t.conns[sentBy] = c
+	}
+	return true
+}
+
+func (t *TCPTransport) Network() string {
+	return "TCP"
+}
+
+func (t *TCPTransport) LocalAddr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *TCPTransport) PublicAddr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Send writes data to the connection to addr, opening one if there is
+// none. When addr is a name, every address it resolves to is tried with
+// Happy Eyeballs
+func (t *TCPTransport) Send(ctx context.Context, addr string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	c, ok := t.conns[addr]
+	t.mu.Unlock()
+	if !ok {
+		targets, err := resolveTargets(ctx, addr)
+		if err != nil {
+			return err
+		}
+		if c, _, err = t.connect(ctx, addr, targets); err != nil {
+			return err
+		}
+	}
+	return t.write(ctx, c, data)
+}
+
+// SendAny writes data to the first of targets (host:port) that can be
+// connected to, trying them with Happy Eyeballs in the order given, after
+// reusing a connection to any of them. It returns the target used. This
+// is how the ordered fallback list of a resolver is tried
+func (t *TCPTransport) SendAny(ctx context.Context, targets []string, data []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	for _, target := range targets {
+		if c, ok := t.conns[target]; ok {
+			t.mu.Unlock()
+			return target, t.write(ctx, c, data)
+		}
+	}
+	t.mu.Unlock()
+	c, target, err := t.connect(ctx, "", targets)
+	if err != nil {
+		return "", err
+	}
+	return target, t.write(ctx, c, data)
+}
+
+// connect opens a connection to one of targets, adding it to the table
+// under addr, or under the target connected to when addr is empty
+func (t *TCPTransport) connect(ctx context.Context, addr string, targets []string) (*streamConn, string, error) {
+	delay := t.AttemptDelay
+	if delay == 0 {
+		delay = DefaultAttemptDelay
+	}
+	var dialer net.Dialer
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	if t.Blacklist != nil {
+		usable := t.Blacklist.Usable(targets)
+		if len(usable) == 0 {
+			return nil, "", BlacklistedError{Addr: strings.Join(targets, ", ")}
+		}
+		targets = usable
+	}
+	conn, target, err := raceDial(ctx, t.clock(), delay, targets, dial)
+	if err != nil {
+		if t.Blacklist != nil && ctx.Err() == nil {
+			for _, target := range targets {
+				t.Blacklist.Add(target, BlacklistedTransport, 0)
+			}
+		}
+		return nil, "", err
+	}
+	if addr == "" {
+		addr = target
+	}
+	c, err := t.add(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return c, target, nil
+}
+
+// write sends data on the connection, dropping it if that fails
+func (t *TCPTransport) write(ctx context.Context, c *streamConn, data []byte) error {
+	if err := t.send(ctx, c, data); err != nil {
+		t.drop(c, err)
+		return err
+	}
+	c.touch(t.clock().Now())
+	t.Tracer.Trace(trace.Record{
+		Network:     t.Network(),
+		Source:      c.conn.LocalAddr(),
+		Destination: c.conn.RemoteAddr(),
+		Outbound:    true,
+		Data:        data,
+	})
+	return nil
+}
+
+func (t *TCPTransport) Receive() <-chan Packet {
+	return t.packets
+}
+
+// Close stops listening and closes every connection
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	conns := make([]*streamConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	err := t.listener.Close()
+	for _, c := range conns {
+		t.drop(c, net.ErrClosed)
+	}
+	return err
+}
+
+// Shutdown closes the transport. Transactions are drained by the
+// UserAgent before it is called, so nothing is waiting on the connections
+func (t *TCPTransport) Shutdown(ctx context.Context) error {
+	return t.Close()
+}
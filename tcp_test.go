@@ -0,0 +1,197 @@
+package slurp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPTransport(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	b, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+
+	// two messages written back to back are framed by their Content-Length
+	message := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 4\r\n\r\nbody"
+	ctx := context.Background()
+	assert.Nil(t, a.Send(ctx, b.LocalAddr().String(), []byte(message+message)))
+	first, second := <-b.Receive(), <-b.Receive()
+	assert.Equal(t, message, string(first.Data))
+	assert.Equal(t, message, string(second.Data))
+
+	// the answer goes back over the same connection
+	assert.Nil(t, b.Send(ctx, first.Source.String(), []byte(message)))
+	reply := <-a.Receive()
+	assert.Equal(t, message, string(reply.Data))
+	assert.Len(t, a.conns, 1)
+	assert.Len(t, b.conns, 1)
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	b, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+	a.Clock = clock
+	a.KeepAlive = 30 * time.Second
+	failures := make(chan error, 1)
+	a.OnFailure = func(addr string, err error) { failures <- err }
+
+	message := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n"
+	addr := b.LocalAddr().String()
+	assert.Nil(t, a.Send(context.Background(), addr, []byte(message)))
+	<-b.Receive()
+
+	// the idle connection is pinged, and b answers with a pong
+	clock.Advance(30 * time.Second)
+	a.mu.Lock()
+	c := a.conns[addr]
+	a.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return !c.awaiting
+	}, time.Second, time.Millisecond)
+	clock.Advance(DefaultPongTimeout)
+	assert.Empty(t, failures)
+	a.mu.Lock()
+	assert.Equal(t, c, a.conns[addr])
+	a.mu.Unlock()
+}
+
+func TestTCPSplitPing(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	defer a.Close()
+	conn, err := net.Dial("tcp", a.LocalAddr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// a ping split across segments is still answered with a pong
+	_, err = conn.Write([]byte("\r\n"))
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.Write([]byte("\r\n"))
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pong := make([]byte, 2)
+	_, err = io.ReadFull(conn, pong)
+	assert.Nil(t, err)
+	assert.Equal(t, "\r\n", string(pong))
+
+	// the compact Content-Length is recognized whatever its case
+	message := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\nL: 4\r\n\r\nbody"
+	_, err = conn.Write([]byte(message))
+	assert.Nil(t, err)
+	select {
+	case packet := <-a.Receive():
+		assert.Equal(t, message, string(packet.Data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestTCPKeepAliveFailure(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	defer a.Close()
+	a.Clock = clock
+	a.KeepAlive = 30 * time.Second
+	failures := make(chan error, 1)
+	a.OnFailure = func(addr string, err error) { failures <- err }
+
+	// a peer that reads pings but never answers them
+	peer, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer peer.Close()
+	// the blank line ending the message is read like a ping
+	pings := make(chan string, 1)
+	go func() {
+		conn, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				select {
+				case pings <- line:
+				default:
+				}
+			}
+		}
+	}()
+	addr := peer.Addr().String()
+	assert.Nil(t, a.Send(context.Background(), addr, []byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n")))
+	<-pings
+
+	clock.Advance(30 * time.Second)
+	clock.Advance(DefaultPongTimeout)
+	assert.Equal(t, KeepAliveError{Addr: addr}, <-failures)
+	a.mu.Lock()
+	assert.NotContains(t, a.conns, addr)
+	a.mu.Unlock()
+}
+
+func TestTCPAlias(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	b, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+	b.AcceptAliases = true
+	client, server := NewUserAgent(a), NewUserAgent(b)
+
+	// the client's Via asks for its connection to be reused
+	request := NewRequest("OPTIONS", "sip:bob@biloxi.com")
+	request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+	request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1928301774")
+	request.Control().CallId = "a84b4c76e66710"
+	request.Control().Sequence = 1
+	client.addVia(request)
+	assert.Equal(t, []string{"alias"}, request.Control().ViaParams)
+	assert.Nil(t, client.Send(context.Background(), b.LocalAddr().String(), request))
+	in := <-server.Receive()
+
+	// requests to the client's Via address go over that connection
+	listen := a.LocalAddr().String()
+	b.mu.Lock()
+	assert.Equal(t, b.conns[in.Source.String()], b.conns[listen])
+	b.mu.Unlock()
+	assert.Nil(t, b.Send(context.Background(), listen, []byte("OPTIONS sip:alice@atlanta.com SIP/2.0\r\nContent-Length: 0\r\n\r\n")))
+	received := <-client.Receive()
+	assert.Equal(t, b.LocalAddr().String(), received.Source.String())
+	a.mu.Lock()
+	assert.Len(t, a.conns, 1)
+	a.mu.Unlock()
+}
@@ -0,0 +1,187 @@
+package testkit
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/qmuloadmin/slurp"
+)
+
+// SentMessage is a message a MockTransport was asked to send
+type SentMessage struct {
+	Addr string
+	Data []byte
+	// Message is the parsed message, nil when it didn't parse
+	Message slurp.Message
+}
+
+// MockTransport is a slurp.Transport without sockets. It records the
+// messages sent through it, delivers those a test injects as received,
+// and fails sends as told, so transactions and dialogs can be tested
+// in memory. It is safe for concurrent use
+type MockTransport struct {
+	network string
+	local   net.Addr
+	packets chan slurp.Packet
+	// receiving is held while a packet is injected, so that Close
+	// doesn't close the channel under it
+	receiving sync.RWMutex
+	mu        sync.Mutex
+	closed    bool
+	sent      []SentMessage
+	next      int
+	// signal is closed, and replaced, whenever a message is sent
+	signal chan struct{}
+	// failNext are the errors the next sends fail with, in order, and
+	// failTo those the sends to an address fail with until cleared
+	failNext []error
+	failTo   map[string]error
+}
+
+// NewMockTransport creates a MockTransport named network, e.g. UDP, with
+// the local address local (host:port)
+func NewMockTransport(network, local string) (*MockTransport, error) {
+	addr, err := resolve(network, local)
+	if err != nil {
+		return nil, err
+	}
+	return &MockTransport{
+		network: network,
+		local:   addr,
+		packets: make(chan slurp.Packet, 64),
+		signal:  make(chan struct{}),
+		failTo:  make(map[string]error),
+	}, nil
+}
+
+func resolve(network, addr string) (net.Addr, error) {
+	if network == "UDP" || network == "udp" {
+		return net.ResolveUDPAddr("udp", addr)
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+func (t *MockTransport) Network() string {
+	return t.network
+}
+
+func (t *MockTransport) LocalAddr() net.Addr {
+	return t.local
+}
+
+func (t *MockTransport) PublicAddr() net.Addr {
+	return t.local
+}
+
+// Send records the message, unless the send was set to fail or the
+// transport is closed
+func (t *MockTransport) Send(ctx context.Context, addr string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return net.ErrClosed
+	}
+	if len(t.failNext) > 0 {
+		err := t.failNext[0]
+		t.failNext = t.failNext[1:]
+		return err
+	}
+	if err := t.failTo[addr]; err != nil {
+		return err
+	}
+	sent := SentMessage{Addr: addr, Data: append([]byte(nil), data...)}
+	sent.Message, _ = slurp.ParseMessage(string(data))
+	t.sent = append(t.sent, sent)
+	close(t.signal)
+	t.signal = make(chan struct{})
+	return nil
+}
+
+func (t *MockTransport) Receive() <-chan slurp.Packet {
+	return t.packets
+}
+
+// Close closes the channel Receive returns. Sends fail afterwards
+func (t *MockTransport) Close() error {
+	t.receiving.Lock()
+	defer t.receiving.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.packets)
+	}
+	return nil
+}
+
+// Inject delivers data as received from source (host:port). It blocks
+// while the receive buffer is full
+func (t *MockTransport) Inject(source string, data []byte) error {
+	addr, err := resolve(t.network, source)
+	if err != nil {
+		return err
+	}
+	t.receiving.RLock()
+	defer t.receiving.RUnlock()
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return net.ErrClosed
+	}
+	t.packets <- slurp.Packet{Data: data, Source: addr}
+	return nil
+}
+
+// InjectMessage delivers m, rendered, as received from source
+func (t *MockTransport) InjectMessage(source string, m slurp.Message) error {
+	return t.Inject(source, []byte(m.Render()))
+}
+
+// FailNext makes the next send fail with err, after those already set to
+func (t *MockTransport) FailNext(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failNext = append(t.failNext, err)
+}
+
+// FailTo makes sends to addr fail with err, or succeed again when err is
+// nil
+func (t *MockTransport) FailTo(addr string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		delete(t.failTo, addr)
+		return
+	}
+	t.failTo[addr] = err
+}
+
+// Sent returns every message sent so far
+func (t *MockTransport) Sent() []SentMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SentMessage(nil), t.sent...)
+}
+
+// NextSent waits for the next message sent that NextSent didn't return
+// yet, retransmissions included
+func (t *MockTransport) NextSent(ctx context.Context) (SentMessage, error) {
+	for {
+		t.mu.Lock()
+		if t.next < len(t.sent) {
+			sent := t.sent[t.next]
+			t.next++
+			t.mu.Unlock()
+			return sent, nil
+		}
+		signal := t.signal
+		t.mu.Unlock()
+		select {
+		case <-signal:
+		case <-ctx.Done():
+			return SentMessage{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package testkit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func options(uri string) slurp.Message {
+	request, _ := slurp.NewRequestBuilder("OPTIONS").To(uri).FromUri("sip:alice@atlanta.com").Build()
+	return request
+}
+
+func TestMockTransport(t *testing.T) {
+	mock, err := NewMockTransport("UDP", "192.0.2.1:5060")
+	assert.Nil(t, err)
+	ua := slurp.NewUserAgent(mock)
+	defer mock.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the request is sent, and its transaction ends with the injected response
+	responses := make(chan *slurp.Response)
+	go func() {
+		response, err := ua.Request(ctx, "192.0.2.2:5060", options("sip:bob@biloxi.com"))
+		assert.Nil(t, err)
+		responses <- response
+	}()
+	sent, err := mock.NextSent(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.2:5060", sent.Addr)
+	if assert.NotNil(t, sent.Message) {
+		assert.Equal(t, "OPTIONS", sent.Message.Method())
+		assert.Nil(t, mock.InjectMessage("192.0.2.2:5060", slurp.NewResponse(sent.Message, 200)))
+	}
+	assert.Equal(t, 200, (<-responses).StatusCode())
+
+	// injected requests are received
+	assert.Nil(t, mock.Inject("192.0.2.3:5060", sent.Data))
+	in := <-ua.Receive()
+	assert.Equal(t, "OPTIONS", in.Message.Method())
+	assert.Equal(t, "192.0.2.3:5060", in.Source.String())
+
+	// simulated transport errors fail the transaction
+	unreachable := errors.New("network unreachable")
+	mock.FailTo("192.0.2.4:5060", unreachable)
+	_, err = ua.Request(ctx, "192.0.2.4:5060", options("sip:carol@chicago.com"))
+	assert.IsType(t, TransportError{}, err)
+	assert.True(t, errors.Is(err, unreachable))
+	assert.Len(t, mock.Sent(), 1)
+}
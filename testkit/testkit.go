@@ -0,0 +1,382 @@
+/*
+Package testkit plays scripted SIP scenarios, in the manner of SIPp,
+against a slurp stack or any other endpoint: send an INVITE, expect a
+100, a 180 and a 200, send the ACK, pause, send a BYE and expect its 200.
+Messages are text templates, sent as rendered, and received messages
+are checked against what the scenario expects, so applications built on
+slurp can be tested end to end over a real transport. Without one, a
+MockTransport stands in for the network in unit tests.
+*/
+package testkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+)
+
+// DefaultTimeout is how long an Expect waits unless told otherwise
+const DefaultTimeout = 5 * time.Second
+
+// Step is one step of a scenario
+type Step interface {
+	Play(ctx context.Context, s *Session) error
+}
+
+// Scenario is a named sequence of steps
+type Scenario struct {
+	Name  string
+	Steps []Step
+	// Vars are available to the templates as .Vars
+	Vars map[string]string
+}
+
+// StepError is the failure of a step of a scenario
+type StepError struct {
+	Scenario string
+	// Step is the index of the step that failed
+	Step int
+	Err  error
+}
+
+func (e StepError) Error() string {
+	return fmt.Sprintf("scenario %s, step %d: %s", e.Scenario, e.Step, e.Err)
+}
+
+func (e StepError) Unwrap() error {
+	return e.Err
+}
+
+// Session is the state of a scenario being played: where messages go,
+// the identifiers its templates use and the messages seen so far
+type Session struct {
+	Transport slurp.Transport
+	// Remote is the host:port messages are sent to
+	Remote string
+	Vars   map[string]string
+	// CallID and FromTag are generated once per session. ToTag is the
+	// tag of the last response received with one, or the To tag of
+	// the last request received
+	CallID  string
+	FromTag string
+	ToTag   string
+	// Branch is generated for each message sent, and CSeq incremented
+	// for each request other than ACK and CANCEL. Responses sent, e.g.
+	// in UAS scenarios, leave it as it is
+	Branch string
+	CSeq   int
+	// Last is the last message received
+	Last slurp.Message
+	// Sent and Received are the messages in the order they were seen
+	Sent     []string
+	Received []slurp.Message
+	// pending is a message an optional Expect didn't match, which the
+	// next Expect is given first
+	pending *received
+	// seen are the messages received so far, to skip retransmissions
+	seen map[string]bool
+}
+
+type received struct {
+	message slurp.Message
+	data    string
+}
+
+// Run plays the scenario, sending messages over transport to remote,
+// and returns the session once every step passed or when one failed,
+// with a StepError
+func (sc *Scenario) Run(ctx context.Context, transport slurp.Transport, remote string) (*Session, error) {
+	s := &Session{
+		Transport: transport,
+		Remote:    remote,
+		Vars:      sc.Vars,
+		CallID:    randomHex(8) + "@testkit",
+		FromTag:   randomHex(4),
+		seen:      make(map[string]bool),
+	}
+	for i, step := range sc.Steps {
+		if err := step.Play(ctx, s); err != nil {
+			return s, StepError{Scenario: sc.Name, Step: i, Err: err}
+		}
+	}
+	return s, nil
+}
+
+// Local returns the host:port of the transport
+func (s *Session) Local() string {
+	return s.Transport.LocalAddr().String()
+}
+
+// LocalIP returns the host of the transport
+func (s *Session) LocalIP() string {
+	host, _, _ := net.SplitHostPort(s.Local())
+	return host
+}
+
+// LocalPort returns the port of the transport
+func (s *Session) LocalPort() string {
+	_, port, _ := net.SplitHostPort(s.Local())
+	return port
+}
+
+// Network returns the transport name, as it goes in a Via
+func (s *Session) Network() string {
+	return s.Transport.Network()
+}
+
+// LastHeader returns the header lines of the last message received with
+// the given name, e.g. every Via to echo in a response
+func (s *Session) LastHeader(name string) string {
+	if s.Last == nil {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(s.Last.RawHeaders(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if colon := strings.Index(line, ":"); colon > 0 &&
+			slurp.HeaderName(strings.TrimSpace(line[:colon])) == slurp.HeaderName(name) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func randomHex(n int) string {
+	data := make([]byte, n)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// Send renders a message template and sends it. The template is given the
+// Session, so it can use e.g. {{.CallID}}, {{.Branch}}, {{.Local}},
+// {{.Remote}} or {{.LastHeader "Via"}}. Lines may end with LF only, and
+// the Content-Length, when the message has one, is set to the body's
+type Send struct {
+	Template string
+	// To overrides the Session's Remote
+	To string
+}
+
+func (step Send) Play(ctx context.Context, s *Session) error {
+	tmpl, err := template.New("message").Parse(strings.TrimLeft(step.Template, " \t\r\n"))
+	if err != nil {
+		return err
+	}
+	s.Branch = slurp.NewBranch()
+	s.CSeq++
+	rendered, err := execute(tmpl, s)
+	if err != nil {
+		return err
+	}
+	if method := strings.Fields(rendered + " ")[0]; method == "ACK" || method == "CANCEL" || method == "SIP/2.0" {
+		// these share the CSeq number of the request they go with
+		s.CSeq--
+		if rendered, err = execute(tmpl, s); err != nil {
+			return err
+		}
+	}
+	data := wireFormat(rendered)
+	to := step.To
+	if to == "" {
+		to = s.Remote
+	}
+	if err := s.Transport.Send(ctx, to, []byte(data)); err != nil {
+		return err
+	}
+	s.Sent = append(s.Sent, data)
+	return nil
+}
+
+func execute(tmpl *template.Template, s *Session) (string, error) {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, s)
+	return buffer.String(), err
+}
+
+// wireFormat ends every line of a rendered template with CRLF, and sets the
+// Content-Length to the length of the body
+func wireFormat(rendered string) string {
+	rendered = strings.ReplaceAll(rendered, "\r\n", "\n")
+	head, body, _ := strings.Cut(rendered, "\n\n")
+	lines := strings.Split(strings.TrimRight(head, "\n"), "\n")
+	if body != "" {
+		body = strings.ReplaceAll(body, "\n", "\r\n")
+	}
+	for i, line := range lines {
+		name, _, _ := strings.Cut(line, ":")
+		if name = strings.TrimSpace(name); strings.EqualFold(name, "Content-Length") || name == "l" {
+			lines[i] = name + ": " + strconv.Itoa(len(body))
+		}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n\r\n" + body
+}
+
+// Expect waits for a message: a request when Method is set without a
+// Status, or a response with Status, to a request with Method if set.
+// Retransmissions of messages already received are skipped
+type Expect struct {
+	Method string
+	Status int
+	// Optional lets the scenario go on when another message arrives,
+	// which the next Expect is given instead
+	Optional bool
+	// Timeout is DefaultTimeout when zero
+	Timeout time.Duration
+	// Checks are run with the message, any error failing the step
+	Checks []Check
+}
+
+func (step Expect) Play(ctx context.Context, s *Session) error {
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	in, err := s.next(ctx)
+	if err != nil {
+		if step.Optional {
+			return nil
+		}
+		return fmt.Errorf("expected %s: %w", step, err)
+	}
+	if !step.matches(in.message) {
+		if step.Optional {
+			s.pending = in
+			return nil
+		}
+		return fmt.Errorf("expected %s, got %s", step, startLine(in.data))
+	}
+	s.pending = nil
+	s.seen[in.data] = true
+	s.Last = in.message
+	s.Received = append(s.Received, in.message)
+	if to := in.message.Headers().To; to != nil {
+		tag := to.Param("tag")
+		if _, ok := in.message.(*slurp.Response); !ok || tag != "" {
+			s.ToTag = tag
+		}
+	}
+	for _, check := range step.Checks {
+		if err := check(in.message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (step Expect) String() string {
+	if step.Status == 0 {
+		return step.Method
+	}
+	if step.Method == "" {
+		return strconv.Itoa(step.Status)
+	}
+	return strconv.Itoa(step.Status) + " to " + step.Method
+}
+
+func (step Expect) matches(m slurp.Message) bool {
+	response, isResponse := m.(*slurp.Response)
+	if step.Status == 0 {
+		return !isResponse && strings.EqualFold(m.Method(), step.Method)
+	}
+	return isResponse && response.StatusCode() == step.Status &&
+		(step.Method == "" || strings.EqualFold(m.Method(), step.Method))
+}
+
+// next returns the pending message, or the next one received that isn't
+// a retransmission
+func (s *Session) next(ctx context.Context) (*received, error) {
+	if s.pending != nil {
+		return s.pending, nil
+	}
+	for {
+		select {
+		case packet, ok := <-s.Transport.Receive():
+			if !ok {
+				return nil, fmt.Errorf("transport closed")
+			}
+			data := string(packet.Data)
+			packet.Release()
+			if s.seen[data] {
+				continue
+			}
+			m, err := slurp.ParseMessage(data)
+			if err != nil {
+				return nil, fmt.Errorf("unparseable message %q: %w", startLine(data), err)
+			}
+			return &received{message: m, data: data}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func startLine(data string) string {
+	return strings.TrimSpace(strings.SplitN(data, "\n", 2)[0])
+}
+
+// Pause waits for Duration, or until the scenario is cancelled
+type Pause struct {
+	Duration time.Duration
+}
+
+func (step Pause) Play(ctx context.Context, s *Session) error {
+	timer := time.NewTimer(step.Duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Do runs a function as a step, e.g. to set Vars from the last message
+type Do func(ctx context.Context, s *Session) error
+
+func (step Do) Play(ctx context.Context, s *Session) error {
+	return step(ctx, s)
+}
+
+// Check asserts something of a received message
+type Check func(m slurp.Message) error
+
+// HasHeader checks that the message has the header
+func HasHeader(name string) Check {
+	return func(m slurp.Message) error {
+		if m.HeaderValue(name) == "" {
+			return fmt.Errorf("no %s header", name)
+		}
+		return nil
+	}
+}
+
+// HeaderContains checks that the first header with name contains substr
+func HeaderContains(name, substr string) Check {
+	return func(m slurp.Message) error {
+		if value := m.HeaderValue(name); !strings.Contains(value, substr) {
+			return fmt.Errorf("%s is %q, without %q", name, value, substr)
+		}
+		return nil
+	}
+}
+
+// BodyContains checks that the body contains substr
+func BodyContains(substr string) Check {
+	return func(m slurp.Message) error {
+		if !strings.Contains(m.StringPayload(), substr) {
+			return fmt.Errorf("body doesn't contain %q", substr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package testkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qmuloadmin/slurp"
+	"github.com/stretchr/testify/assert"
+)
+
+const invite = `INVITE sip:bob@{{.Remote}} SIP/2.0
+Via: SIP/2.0/{{.Network}} {{.Local}};branch={{.Branch}}
+Max-Forwards: 70
+From: <sip:alice@{{.Local}}>;tag={{.FromTag}}
+To: <sip:bob@{{.Remote}}>
+Call-ID: {{.CallID}}
+CSeq: {{.CSeq}} INVITE
+Contact: <sip:alice@{{.Local}}>
+Content-Type: application/sdp
+Content-Length: 0
+
+v=0
+o=alice 1 1 IN IP4 {{.LocalIP}}
+s=-
+c=IN IP4 {{.LocalIP}}
+t=0 0
+m=audio 49170 RTP/AVP 0
+`
+
+const inDialog = `{{.Vars.method}} sip:bob@{{.Remote}} SIP/2.0
+Via: SIP/2.0/{{.Network}} {{.Local}};branch={{.Branch}}
+Max-Forwards: 70
+From: <sip:alice@{{.Local}}>;tag={{.FromTag}}
+To: <sip:bob@{{.Remote}}>;tag={{.ToTag}}
+Call-ID: {{.CallID}}
+CSeq: {{.CSeq}} {{.Vars.method}}
+Content-Length: 0
+`
+
+func method(name string) Do {
+	return func(ctx context.Context, s *Session) error {
+		s.Vars = map[string]string{"method": name}
+		return nil
+	}
+}
+
+func TestScenario(t *testing.T) {
+	a, err := slurp.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := slurp.ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer a.Close()
+	defer b.Close()
+
+	// the stack under test answers calls, and hangs up when asked to
+	callee := slurp.NewUserAgent(b)
+	go func() {
+		for in := range callee.Receive() {
+			switch in.Message.Method() {
+			case "INVITE":
+				callee.Send(context.Background(), in.Source.String(), slurp.NewResponse(in.Message, 180))
+				callee.Answer(context.Background(), in.Source.String(), in.Message, slurp.NewResponse(in.Message, 200))
+			case "BYE":
+				callee.Send(context.Background(), in.Source.String(), slurp.NewResponse(in.Message, 200))
+			}
+		}
+	}()
+
+	scenario := &Scenario{Name: "call", Steps: []Step{
+		Send{Template: invite},
+		Expect{Status: 100, Optional: true, Timeout: 100 * time.Millisecond},
+		Expect{Status: 180},
+		Expect{Status: 200, Method: "INVITE", Checks: []Check{HasHeader("Contact")}},
+		method("ACK"),
+		Send{Template: inDialog},
+		Pause{Duration: 10 * time.Millisecond},
+		method("BYE"),
+		Send{Template: inDialog},
+		Expect{Status: 200, Method: "BYE"},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	session, err := scenario.Run(ctx, a, b.LocalAddr().String())
+	assert.Nil(t, err)
+	assert.Len(t, session.Sent, 3)
+	assert.Contains(t, session.Sent[0], "\r\nContent-Length: 92\r\n\r\nv=0\r\n")
+	assert.Contains(t, session.Sent[2], "\r\nCSeq: 2 BYE\r\n")
+
+	// a failing step reports where the scenario stopped
+	scenario = &Scenario{Name: "unanswered", Steps: []Step{
+		method("OPTIONS"),
+		Send{Template: inDialog},
+		Expect{Status: 404, Timeout: 200 * time.Millisecond},
+	}}
+	_, err = scenario.Run(ctx, a, b.LocalAddr().String())
+	if assert.IsType(t, StepError{}, err) {
+		assert.Equal(t, 2, err.(StepError).Step)
+	}
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+)
+
+// HEP3 chunk types, all with the generic vendor ID of 0
+const (
+	hepIPFamily     = 0x01
+	hepProtocol     = 0x02
+	hepSrcIPv4      = 0x03
+	hepDstIPv4      = 0x04
+	hepSrcIPv6      = 0x05
+	hepDstIPv6      = 0x06
+	hepSrcPort      = 0x07
+	hepDstPort      = 0x08
+	hepSeconds      = 0x09
+	hepMicroseconds = 0x0a
+	hepProtoType    = 0x0b
+	hepAgentID      = 0x0c
+	hepAuthKey      = 0x0e
+	hepPayload      = 0x0f
+
+	hepProtoSIP = 0x01
+)
+
+// HEPWriter ships every record encapsulated in HEP3 to a capture server
+// such as Homer or heplify-server
+type HEPWriter struct {
+	// AgentID identifies this capture agent to the server
+	AgentID uint32
+	// Password is the optional authentication key expected by the server
+	Password string
+	mu       sync.Mutex
+	conn     net.Conn
+}
+
+// NewHEPWriter connects to a capture server. network is udp or tcp
+func NewHEPWriter(network, address string) (*HEPWriter, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &HEPWriter{conn: conn}, nil
+}
+
+func (h *HEPWriter) Write(record Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(EncodeHEP(record, h.AgentID, h.Password))
+	return err
+}
+
+// Close closes the connection to the capture server
+func (h *HEPWriter) Close() error {
+	return h.conn.Close()
+}
+
+// EncodeHEP encapsulates a record in a HEP3 packet
+func EncodeHEP(record Record, agentID uint32, password string) []byte {
+	srcIP, srcPort := hostPort(record.Source)
+	dstIP, dstPort := hostPort(record.Destination)
+	packet := []byte("HEP3\x00\x00")
+	chunk := func(kind uint16, value []byte) {
+		header := make([]byte, 6)
+		binary.BigEndian.PutUint16(header[2:4], kind)
+		binary.BigEndian.PutUint16(header[4:6], uint16(6+len(value)))
+		packet = append(append(packet, header...), value...)
+	}
+	u16 := func(v uint16) []byte {
+		return binary.BigEndian.AppendUint16(nil, v)
+	}
+	u32 := func(v uint32) []byte {
+		return binary.BigEndian.AppendUint32(nil, v)
+	}
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		chunk(hepIPFamily, []byte{2})
+		chunk(hepSrcIPv4, src4)
+		chunk(hepDstIPv4, dst4)
+	} else {
+		chunk(hepIPFamily, []byte{10})
+		chunk(hepSrcIPv6, srcIP.To16())
+		chunk(hepDstIPv6, dstIP.To16())
+	}
+	protocol := byte(17)
+	if strings.HasPrefix(strings.ToUpper(record.Network), "TCP") || strings.HasPrefix(strings.ToUpper(record.Network), "TLS") {
+		protocol = 6
+	}
+	chunk(hepProtocol, []byte{protocol})
+	chunk(hepSrcPort, u16(uint16(srcPort)))
+	chunk(hepDstPort, u16(uint16(dstPort)))
+	chunk(hepSeconds, u32(uint32(record.Time.Unix())))
+	chunk(hepMicroseconds, u32(uint32(record.Time.Nanosecond()/1000)))
+	chunk(hepProtoType, []byte{hepProtoSIP})
+	chunk(hepAgentID, u32(agentID))
+	if password != "" {
+		chunk(hepAuthKey, []byte(password))
+	}
+	chunk(hepPayload, record.Data)
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)))
+	return packet
+}
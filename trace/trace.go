@@ -0,0 +1,197 @@
+/*
+Package trace records every SIP message a transport sends or receives,
+as a text SIP trace or as a pcap file that can be opened with Wireshark.
+Tracing can be switched on and off at runtime.
+*/
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Record is a single message seen by a transport
+type Record struct {
+	Time        time.Time
+	Network     string
+	Source      net.Addr
+	Destination net.Addr
+	Outbound    bool
+	Data        []byte
+}
+
+// Writer writes trace records somewhere
+type Writer interface {
+	Write(Record) error
+}
+
+// Tracer passes records to a Writer while enabled. A nil Tracer ignores
+// everything, so transports can call it unconditionally
+type Tracer struct {
+	enabled int32
+	mu      sync.Mutex
+	writer  Writer
+}
+
+// New creates an enabled Tracer writing to w
+func New(w Writer) *Tracer {
+	return &Tracer{enabled: 1, writer: w}
+}
+
+// Enable starts writing records
+func (t *Tracer) Enable() {
+	atomic.StoreInt32(&t.enabled, 1)
+}
+
+// Disable stops writing records until Enable is called
+func (t *Tracer) Disable() {
+	atomic.StoreInt32(&t.enabled, 0)
+}
+
+// Enabled reports whether records are being written
+func (t *Tracer) Enabled() bool {
+	return t != nil && atomic.LoadInt32(&t.enabled) == 1
+}
+
+// Trace writes the record if the tracer is enabled. Write errors are
+// returned but shouldn't stop message processing
+func (t *Tracer) Trace(record Record) error {
+	if !t.Enabled() {
+		return nil
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writer.Write(record)
+}
+
+// TextWriter writes records in a readable SIP trace format
+type TextWriter struct {
+	w io.Writer
+}
+
+// NewTextWriter creates a TextWriter writing to w
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: w}
+}
+
+func (t *TextWriter) Write(record Record) error {
+	direction := "received"
+	if record.Outbound {
+		direction = "sent"
+	}
+	_, err := fmt.Fprintf(t.w, "%s %s %s %s -> %s (%d bytes)\n%s\n\n",
+		record.Time.Format("2006-01-02 15:04:05.000000"),
+		record.Network, direction, record.Source, record.Destination,
+		len(record.Data), record.Data)
+	return err
+}
+
+// linkTypeRaw is the pcap link type for packets starting with an IP header
+const linkTypeRaw = 101
+
+// PcapWriter writes records to a pcap file. Every message is wrapped in
+// synthetic IP and UDP headers, even when it was carried over TCP, as
+// Wireshark only needs the addresses and ports to decode the SIP
+type PcapWriter struct {
+	w      io.Writer
+	header bool
+}
+
+// NewPcapWriter creates a PcapWriter writing to w
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	return &PcapWriter{w: w}
+}
+
+func (p *PcapWriter) Write(record Record) error {
+	if !p.header {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+		binary.LittleEndian.PutUint16(header[4:6], 2)
+		binary.LittleEndian.PutUint16(header[6:8], 4)
+		binary.LittleEndian.PutUint32(header[16:20], 65535)
+		binary.LittleEndian.PutUint32(header[20:24], linkTypeRaw)
+		if _, err := p.w.Write(header); err != nil {
+			return err
+		}
+		p.header = true
+	}
+	packet := encapsulate(record)
+	recordHeader := make([]byte, 16)
+	binary.LittleEndian.PutUint32(recordHeader[0:4], uint32(record.Time.Unix()))
+	binary.LittleEndian.PutUint32(recordHeader[4:8], uint32(record.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(recordHeader[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(recordHeader[12:16], uint32(len(packet)))
+	if _, err := p.w.Write(recordHeader); err != nil {
+		return err
+	}
+	_, err := p.w.Write(packet)
+	return err
+}
+
+// hostPort extracts the IP and port of an address
+func hostPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+	if addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			var number int
+			fmt.Sscan(port, &number)
+			return net.ParseIP(host), number
+		}
+	}
+	return net.IPv4zero, 0
+}
+
+// encapsulate builds an IPv4 or IPv6 packet with a UDP header around the record's data
+func encapsulate(record Record) []byte {
+	srcIP, srcPort := hostPort(record.Source)
+	dstIP, dstPort := hostPort(record.Destination)
+	data := record.Data
+	if len(data) > 65000 {
+		data = data[:65000]
+	}
+	udp := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	// a zero checksum means none was computed
+	copy(udp[8:], data)
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp)))
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:16], src4)
+		copy(ip[16:20], dst4)
+		var sum uint32
+		for i := 0; i < 20; i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(ip[i : i+2]))
+		}
+		for sum > 0xffff {
+			sum = sum>>16 + sum&0xffff
+		}
+		binary.BigEndian.PutUint16(ip[10:12], ^uint16(sum))
+		return append(ip, udp...)
+	}
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(udp)))
+	ip[6] = 17
+	ip[7] = 64
+	copy(ip[8:24], srcIP.To16())
+	copy(ip[24:40], dstIP.To16())
+	return append(ip, udp...)
+}
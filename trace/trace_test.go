@@ -0,0 +1,83 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var record = Record{
+	Time:        time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+	Network:     "UDP",
+	Source:      &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5060},
+	Destination: &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5080},
+	Outbound:    true,
+	Data:        []byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\n\r\n"),
+}
+
+func TestTextTrace(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	tracer := New(NewTextWriter(buffer))
+	assert.Nil(t, tracer.Trace(record))
+	tracer.Disable()
+	assert.Nil(t, tracer.Trace(record))
+	assert.Equal(t, 1, strings.Count(buffer.String(), "OPTIONS"))
+	assert.Contains(t, buffer.String(), "UDP sent 10.0.0.1:5060 -> 10.0.0.2:5080")
+	var nilTracer *Tracer
+	assert.Nil(t, nilTracer.Trace(record))
+}
+
+func TestPcapTrace(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := NewPcapWriter(buffer)
+	assert.Nil(t, writer.Write(record))
+	assert.Nil(t, writer.Write(record))
+	data := buffer.Bytes()
+	assert.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data[0:4]))
+	length := int(binary.LittleEndian.Uint32(data[24+8 : 24+12]))
+	assert.Equal(t, 20+8+len(record.Data), length)
+	packet := data[40 : 40+length]
+	assert.Equal(t, byte(0x45), packet[0])
+	assert.Equal(t, uint16(5080), binary.BigEndian.Uint16(packet[22:24]))
+	assert.Equal(t, record.Data, packet[28:])
+	assert.Equal(t, 24+2*(16+length), len(data))
+}
+
+func TestHEP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer server.Close()
+	writer, err := NewHEPWriter("udp", server.LocalAddr().String())
+	assert.Nil(t, err)
+	defer writer.Close()
+	writer.AgentID = 2001
+	tracer := New(writer)
+	assert.Nil(t, tracer.Trace(record))
+
+	buffer := make([]byte, 65535)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buffer)
+	assert.Nil(t, err)
+	packet := buffer[:n]
+	assert.Equal(t, "HEP3", string(packet[:4]))
+	assert.Equal(t, n, int(binary.BigEndian.Uint16(packet[4:6])))
+	// walk the chunks to find the payload and ports
+	chunks := map[uint16][]byte{}
+	for offset := 6; offset < n; {
+		kind := binary.BigEndian.Uint16(packet[offset+2 : offset+4])
+		length := int(binary.BigEndian.Uint16(packet[offset+4 : offset+6]))
+		chunks[kind] = packet[offset+6 : offset+length]
+		offset += length
+	}
+	assert.Equal(t, record.Data, chunks[hepPayload])
+	assert.Equal(t, []byte{10, 0, 0, 2}, chunks[hepDstIPv4])
+	assert.Equal(t, uint16(5060), binary.BigEndian.Uint16(chunks[hepSrcPort]))
+	assert.Equal(t, uint32(2001), binary.BigEndian.Uint32(chunks[hepAgentID]))
+}
@@ -0,0 +1,596 @@
+package slurp
+
+/*
+Client transactions send a request and wait for its final response,
+retransmitting over unreliable transports as RFC 3261 17.1 requires.
+Every blocking call takes a context, so callers can bound or abandon
+a request at any time.
+*/
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// The timer values RFC 3261 recommends. Transactions use the Timers of
+// their UserAgent, which default to these
+const (
+	// T1 is the round-trip time estimate
+	T1 = 500 * time.Millisecond
+	// T2 is the longest retransmit interval for non-INVITE requests
+	T2 = 4 * time.Second
+	// T4 is the longest time a message stays in the network
+	T4 = 5 * time.Second
+	// TimerC is how long an INVITE in proceeding waits for its next response
+	TimerC = 3 * time.Minute
+)
+
+// Timers are the SIP timer values used by transactions, which users on
+// high-latency links or test suites may tune. Zero values take the
+// default, which for the timers derived from T1 is based on the T1 given
+type Timers struct {
+	T1 time.Duration `json:"t1" yaml:"t1"`
+	T2 time.Duration `json:"t2" yaml:"t2"`
+	T4 time.Duration `json:"t4" yaml:"t4"`
+	// B and F are how long INVITE and other requests wait for a response,
+	// 64*T1 by default
+	B time.Duration `json:"b" yaml:"b"`
+	F time.Duration `json:"f" yaml:"f"`
+	// C is how long an INVITE in proceeding waits for its next response
+	C time.Duration `json:"c" yaml:"c"`
+	// M is how long an answered INVITE accepts 2xx from other forks, 64*T1
+	// by default (RFC 6026)
+	M time.Duration `json:"m" yaml:"m"`
+}
+
+// DefaultTimers are the values RFC 3261 recommends
+var DefaultTimers = Timers{T1: T1, T2: T2, T4: T4, B: 64 * T1, F: 64 * T1, C: TimerC, M: 64 * T1}
+
+// withDefaults returns the timers with zero values replaced by the defaults
+func (t Timers) withDefaults() Timers {
+	if t.T1 == 0 {
+		t.T1 = DefaultTimers.T1
+	}
+	if t.T2 == 0 {
+		t.T2 = DefaultTimers.T2
+	}
+	if t.T4 == 0 {
+		t.T4 = DefaultTimers.T4
+	}
+	for _, timer := range []*time.Duration{&t.B, &t.F, &t.M} {
+		if *timer == 0 {
+			*timer = 64 * t.T1
+		}
+	}
+	if t.C == 0 {
+		t.C = DefaultTimers.C
+	}
+	return t
+}
+
+// timeout returns Timer B for INVITE, and Timer F for other methods
+func (t Timers) timeout(method string) time.Duration {
+	if method == "INVITE" {
+		return t.B
+	}
+	return t.F
+}
+
+// BranchPrefix is the magic cookie starting every RFC 3261 branch
+const BranchPrefix = "z9hG4bK"
+
+// NewBranch generates a unique branch for a new transaction
+func NewBranch() string {
+	return BranchPrefix + generateTag()
+}
+
+// NewCancel builds the CANCEL for a pending request, per RFC 3261 9.1. It
+// shares the request's Request-URI, Call-ID, To, From, CSeq number, top
+// Via and Route
+func NewCancel(request Message) *Request {
+	cancel := NewRequest("CANCEL", request.Uri())
+	headers, control := request.Headers(), request.Control()
+	// copies, so that releasing either message leaves the other intact
+	cancel.headers.To = CloneHeader(headers.To)
+	cancel.headers.From = CloneHeader(headers.From)
+	if routes := RouteSet(request); len(routes) > 0 {
+		SetRouteSet(cancel, routes)
+	}
+	if len(control.Via) > 0 {
+		cancel.control.Via = [][2]string{control.Via[0]}
+	}
+	if len(control.ViaParams) > 0 {
+		cancel.control.ViaParams = []string{control.ViaParams[0]}
+	}
+	cancel.control.ViaBranch = control.ViaBranch
+	cancel.control.CallId = control.CallId
+	cancel.control.Sequence = control.Sequence
+	return cancel
+}
+
+// newFailureAck builds the ACK for a non-2xx final response to an INVITE,
+// which is part of the INVITE transaction, per RFC 3261 17.1.1.3
+func newFailureAck(invite Message, response *Response) *Request {
+	ack := NewCancel(invite)
+	ack.method = "ACK"
+	ack.headers.To = CloneHeader(response.Headers().To)
+	return ack
+}
+
+func transactionKey(branch, method string) string {
+	return branch + " " + method
+}
+
+// clientTransaction is a client transaction in progress: where its
+// responses are delivered, and closed to end it early, see Janitor
+type clientTransaction struct {
+	responses chan *Response
+	expired   chan struct{}
+	expire    sync.Once
+}
+
+// expireTransaction ends the client transaction with the given key as if
+// it had timed out, reporting false if there is none
+func (ua *UserAgent) expireTransaction(key string) bool {
+	ua.mu.RLock()
+	tx, ok := ua.transactions[key]
+	ua.mu.RUnlock()
+	if ok {
+		tx.expire.Do(func() { close(tx.expired) })
+	}
+	return ok
+}
+
+// forgetTransaction drops the client transaction with the given key,
+// reporting false if there was none
+func (ua *UserAgent) forgetTransaction(key string) bool {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	_, ok := ua.transactions[key]
+	delete(ua.transactions, key)
+	return ok
+}
+
+// TransactionInfo identifies a client transaction in progress
+type TransactionInfo struct {
+	Branch string
+	Method string
+}
+
+// Transactions returns the client transactions waiting for a final
+// response, sorted by branch
+func (ua *UserAgent) Transactions() []TransactionInfo {
+	ua.mu.RLock()
+	infos := make([]TransactionInfo, 0, len(ua.transactions))
+	for key := range ua.transactions {
+		branch, method, _ := strings.Cut(key, " ")
+		infos = append(infos, TransactionInfo{Branch: branch, Method: method})
+	}
+	ua.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Branch < infos[j].Branch
+	})
+	return infos
+}
+
+// Request sends request to addr (host:port) and waits for its final
+// response. Provisional responses are absorbed. A branch is generated if
+// the request doesn't have one, and a Via for the transport if it has none.
+//
+// Request gives up when ctx is done, returning ctx.Err(). An INVITE is
+// then cancelled in the background: a CANCEL is sent once it got a
+// provisional response, its 487 is acknowledged, and a 2xx that crossed
+// the CANCEL is acknowledged and hung up with a BYE.
+// Without a deadline, Request fails with a TimeoutError after Timer B or
+// F, or for an INVITE being processed, after Timer C without a response.
+// If an interceptor drops the request, it fails with a DroppedError, so
+// that the response is never nil without an error.
+// An empty addr sends the request to its NextHop. A request that can't be
+// sent fails with a TransportError, and one for a target on the
+// UserAgent's Blacklist with a BlacklistedError. With failover, a name is
+// resolved and its addresses tried in turn until one answers other than 503
+func (ua *UserAgent) Request(ctx context.Context, addr string, request Message) (*Response, error) {
+	return ua.request(ctx, addr, request, transactionHooks{})
+}
+
+// ResponseHandlers are called with the responses to a request, each
+// according to its class. Handlers that aren't set are skipped
+type ResponseHandlers struct {
+	OnProvisional   func(*Response)
+	OnSuccess       func(*Response)
+	OnRedirect      func(*Response)
+	OnClientError   func(*Response)
+	OnServerError   func(*Response)
+	OnGlobalFailure func(*Response)
+}
+
+// Handle calls the handler for the class of response
+func (h ResponseHandlers) Handle(response *Response) {
+	var handler func(*Response)
+	switch response.Class() {
+	case Provisional:
+		handler = h.OnProvisional
+	case Success:
+		handler = h.OnSuccess
+	case Redirection:
+		handler = h.OnRedirect
+	case ClientError:
+		handler = h.OnClientError
+	case ServerError:
+		handler = h.OnServerError
+	case GlobalFailure:
+		handler = h.OnGlobalFailure
+	}
+	if handler != nil {
+		handler(response)
+	}
+}
+
+// RequestWith is Request, calling handlers with every response received,
+// provisional and final, in the order they arrive. Handlers run on the
+// transaction's goroutine, before RequestWith returns
+func (ua *UserAgent) RequestWith(ctx context.Context, addr string, request Message, handlers ResponseHandlers) (*Response, error) {
+	response, err := ua.request(ctx, addr, request, transactionHooks{provisional: handlers.Handle})
+	if err == nil {
+		handlers.Handle(response)
+	}
+	return response, err
+}
+
+// transactionHooks let the layers above a client transaction see the
+// responses it doesn't return
+type transactionHooks struct {
+	// provisional is called with every provisional response
+	provisional func(*Response)
+	// accepted is called with every 2xx to an INVITE received after the
+	// first, for Timer M, as forks of the INVITE may answer too (RFC 6026)
+	accepted func(*Response)
+	// redirected is called with the address a redirected request is
+	// sent to next, before it is
+	redirected func(addr string)
+}
+
+// addVia gives the request a Via for our transport if it has none, after
+// applying the profile when there is one
+func (ua *UserAgent) addVia(request Message) {
+	ua.mu.RLock()
+	profile, throttler := ua.profile, ua.throttler
+	ua.mu.RUnlock()
+	if profile != nil {
+		profile.Apply(request)
+	}
+	control := request.Control()
+	if control.ViaBranch == "" {
+		control.ViaBranch = NewBranch()
+	}
+	if len(control.Via) == 0 {
+		control.Via = [][2]string{{ua.transport.Network(), ua.transport.PublicAddr().String()}}
+		if _, ok := ua.transport.(aliaser); ok {
+			// peers may reuse our connection rather than open one back
+			control.ViaParams = []string{"alias"}
+		}
+		if throttler != nil {
+			// RFC 7339 5.1, the servers we send to may ask us to throttle
+			if len(control.ViaParams) == 0 {
+				control.ViaParams = []string{""}
+			}
+			control.ViaParams[0] = strings.TrimPrefix(control.ViaParams[0]+";oc", ";")
+		}
+	}
+}
+
+// request runs a client transaction, calling hooks as responses arrive,
+// or with failover one for each target tried, and follows redirects when
+// the UserAgent does
+func (ua *UserAgent) request(ctx context.Context, addr string, request Message, hooks transactionHooks) (*Response, error) {
+	ua.mu.Lock()
+	ua.inflight++
+	ua.mu.Unlock()
+	defer func() {
+		ua.mu.Lock()
+		ua.inflight--
+		ua.mu.Unlock()
+	}()
+	ua.addVia(request)
+	if addr == "" {
+		var err error
+		if addr, err = NextHop(request); err != nil {
+			return nil, err
+		}
+	}
+	ua.mu.RLock()
+	maxRedirects := ua.maxRedirects
+	ua.mu.RUnlock()
+	response, err := ua.attempt(ctx, addr, request, hooks)
+	if maxRedirects > 0 && err == nil && IsRedirect(response) {
+		return ua.followRedirects(ctx, request, response, maxRedirects, hooks)
+	}
+	return response, err
+}
+
+// attempt runs a client transaction for request sent to addr, or with
+// failover one for each target addr resolves to until one answers
+func (ua *UserAgent) attempt(ctx context.Context, addr string, request Message, hooks transactionHooks) (*Response, error) {
+	ua.mu.RLock()
+	failover := ua.failover
+	ua.mu.RUnlock()
+	if !failover {
+		return ua.transaction(ctx, addr, request, hooks)
+	}
+	targets, err := resolveTargets(ctx, addr)
+	if err != nil || len(targets) == 0 {
+		targets = []string{addr}
+	}
+	var response *Response
+	for i, target := range targets {
+		if i > 0 {
+			// each attempt is a new transaction
+			request.Control().ViaBranch = NewBranch()
+		}
+		response, err = ua.transaction(ctx, target, request, hooks)
+		if ctx.Err() != nil || !shouldFailover(response, err) {
+			break
+		}
+	}
+	return response, err
+}
+
+// shouldFailover reports whether the outcome of a request sent to one of
+// the targets of a name means the next should be tried: it couldn't be
+// sent, got no answer, or was answered 503 (RFC 3263 4.3)
+func shouldFailover(response *Response, err error) bool {
+	switch err.(type) {
+	case TransportError, TimeoutError, BlacklistedError:
+		return true
+	}
+	return err == nil && response.StatusCode() == 503
+}
+
+// isLost reports whether a request failed without any response, as it
+// couldn't be sent or timed out, which a UAC handles as a 408 (RFC 3261
+// 8.1.3.1), e.g. ending the dialog the request was sent in
+func isLost(err error) bool {
+	switch err.(type) {
+	case TransportError, TimeoutError:
+		return true
+	}
+	return false
+}
+
+// transaction runs a client transaction for request sent to addr
+func (ua *UserAgent) transaction(ctx context.Context, addr string, request Message, hooks transactionHooks) (*Response, error) {
+	ua.mu.RLock()
+	blacklist, throttler := ua.blacklist, ua.throttler
+	ua.mu.RUnlock()
+	if blacklist != nil && blacklist.Skip(addr) {
+		return nil, BlacklistedError{Addr: addr}
+	}
+	if throttler != nil && !throttler.Admit(addr, request) {
+		return nil, ThrottledError{Addr: addr, Reduction: throttler.Reduction(addr)}
+	}
+	control := request.Control()
+	method := request.Method()
+	key := transactionKey(control.ViaBranch, method)
+	tx := &clientTransaction{responses: make(chan *Response, 8), expired: make(chan struct{})}
+	responses := tx.responses
+	ua.mu.Lock()
+	ua.transactions[key] = tx
+	ua.mu.Unlock()
+	forget := func() {
+		ua.mu.Lock()
+		if ua.transactions[key] == tx {
+			delete(ua.transactions, key)
+		}
+		ua.mu.Unlock()
+	}
+	accepting := false
+	defer func() {
+		if !accepting {
+			forget()
+		}
+	}()
+
+	request, err := ua.intercept(request, Outbound)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, DroppedError{Method: method}
+	}
+	// render once, retransmissions must be identical
+	data := []byte(request.Render())
+	transport := ua.transport
+	if stream := ua.streamFor(len(data)); stream != nil {
+		transport = stream
+		if control.Via[0] == [2]string{ua.transport.Network(), ua.transport.PublicAddr().String()} {
+			control.Via[0] = [2]string{stream.Network(), stream.PublicAddr().String()}
+			data = []byte(request.Render())
+		}
+	}
+	timers := ua.Timers()
+	clock := clockOrDefault(ua.Clock)
+	start := clock.Now()
+	if err := transport.Send(ctx, addr, data); err != nil {
+		return nil, ua.transportError(ctx, blacklist, request, addr, err)
+	}
+
+	retransmit := make(chan struct{}, 1)
+	interval := timers.T1
+	var timer Timer
+	if transport.Network() == "UDP" {
+		timer = clock.AfterFunc(interval, func() {
+			select {
+			case retransmit <- struct{}{}:
+			default:
+			}
+		})
+		defer timer.Stop()
+	}
+	timeout := make(chan struct{})
+	timeoutTimer := clock.AfterFunc(timers.timeout(method), func() { close(timeout) })
+	defer timeoutTimer.Stop()
+
+	proceeding := false
+	giveUp := func() {
+		if method != "INVITE" {
+			return
+		}
+		// the transaction is cancelled in the background. It gets a copy
+		// of the request, as the caller owns it again once we return
+		accepting = true
+		if timer != nil {
+			timer.Stop()
+		}
+		go ua.cancelInvite(inviteCancellation{
+			addr:       addr,
+			invite:     Clone(request),
+			data:       data,
+			transport:  transport,
+			responses:  responses,
+			proceeding: proceeding,
+			forget:     forget,
+		})
+	}
+	for {
+		select {
+		case <-retransmit:
+			if err := transport.Send(ctx, addr, data); err != nil {
+				return nil, ua.transportError(ctx, blacklist, request, addr, err)
+			}
+			CountRetransmission(method)
+			interval *= 2
+			if method != "INVITE" && interval > timers.T2 {
+				interval = timers.T2
+			}
+			timer.Reset(interval)
+		case response := <-responses:
+			if throttler != nil {
+				throttler.Observe(addr, response)
+			}
+			if IsFinal(response.StatusCode()) {
+				ObserveTransaction(method, clock.Now().Sub(start))
+				if blacklist != nil {
+					blacklist.Observe(addr, response)
+				}
+				ua.learnServiceRoute(request, response)
+				if method == "INVITE" && !IsSuccess(response.StatusCode()) {
+					// the transaction acknowledges failures itself, 2xx are
+					// acknowledged by the dialog
+					ack := []byte(newFailureAck(request, response).Render())
+					transport.Send(ctx, addr, ack)
+				}
+				if method == "INVITE" && IsSuccess(response.StatusCode()) && hooks.accepted != nil {
+					accepting = true
+					go ua.accept(responses, hooks.accepted, forget)
+				}
+				return response, nil
+			}
+			if hooks.provisional != nil {
+				hooks.provisional(response)
+			} else {
+				// absorbed, nobody else sees it
+				Release(response)
+			}
+			if method == "INVITE" {
+				// every provisional response gives the server Timer C more
+				timeoutTimer.Reset(timers.C)
+			}
+			if proceeding {
+				continue
+			}
+			proceeding = true
+			if method == "INVITE" {
+				// the server is working on it, stop retransmitting and wait
+				if timer != nil {
+					timer.Stop()
+				}
+			} else if timer != nil {
+				interval = timers.T2
+				timer.Reset(interval)
+			}
+		case <-timeout:
+			ua.transactionUser().Timeout(request)
+			return nil, TimeoutError{Method: method, Branch: control.ViaBranch}
+		case <-tx.expired:
+			// given up on by the Janitor, as a peer kept it going too long
+			giveUp()
+			ua.transactionUser().Timeout(request)
+			return nil, TimeoutError{Method: method, Branch: control.ViaBranch}
+		case <-ctx.Done():
+			giveUp()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// transportError ends a client transaction whose request couldn't be sent
+// to addr, reporting it to the TransactionUser and blacklisting addr.
+// Errors from ctx ending are returned as they are
+func (ua *UserAgent) transportError(ctx context.Context, blacklist *Blacklist, request Message, addr string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if blacklist != nil {
+		blacklist.Add(addr, BlacklistedTransport, 0)
+	}
+	err = TransportError{Method: request.Method(), Addr: addr, Err: err}
+	ua.transactionUser().TransportError(request, addr, err)
+	return err
+}
+
+// streamFor returns the stream transport a request of size bytes must be
+// sent over instead of UDP, as it is within 200 bytes of the MTU, or nil
+func (ua *UserAgent) streamFor(size int) Transport {
+	ua.mu.RLock()
+	stream := ua.stream
+	ua.mu.RUnlock()
+	mtu := ua.MTU
+	if mtu == 0 {
+		mtu = 1500
+	}
+	if stream == nil || ua.transport.Network() != "UDP" || size <= mtu-200 {
+		return nil
+	}
+	return stream
+}
+
+// accept passes the 2xx responses still arriving for an answered INVITE
+// to accepted for Timer M, then forgets the transaction
+func (ua *UserAgent) accept(responses chan *Response, accepted func(*Response), forget func()) {
+	done := make(chan struct{})
+	timer := clockOrDefault(ua.Clock).AfterFunc(ua.Timers().M, func() { close(done) })
+	defer timer.Stop()
+	defer forget()
+	for {
+		select {
+		case response := <-responses:
+			if IsSuccess(response.StatusCode()) {
+				accepted(response)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// deliver routes a response to the client transaction waiting for it,
+// reporting false if there is none
+func (ua *UserAgent) deliver(response *Response) bool {
+	ua.mu.RLock()
+	tx, ok := ua.transactions[transactionKey(response.Control().ViaBranch, response.Method())]
+	ua.mu.RUnlock()
+	if ok {
+		select {
+		case tx.responses <- response:
+		default:
+			// the transaction is behind, and this one is lost
+			Release(response)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,179 @@
+package slurp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for in := range server.Receive() {
+			switch in.Message.Method() {
+			case "OPTIONS":
+				server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 200))
+			case "INVITE":
+				server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 180))
+			case "CANCEL":
+				server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 200))
+				server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 487))
+			}
+		}
+	}()
+	newRequest := func(method string) *Request {
+		request := NewRequest(method, "sip:bob@biloxi.com")
+		request.Headers().To = NewHeader(&ToFrom{}).SetUri("sip:bob@biloxi.com")
+		request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1")
+		request.Control().Via = [][2]string{{"UDP", a.LocalAddr().String()}}
+		request.Control().CallId = "a84b4c76e66710"
+		request.Control().Sequence = 1
+		return request
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.Request(ctx, b.LocalAddr().String(), newRequest("OPTIONS"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 200, response.StatusCode())
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	invite := newRequest("INVITE")
+	_, err = client.Request(ctx, b.LocalAddr().String(), invite)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, BranchPrefix, invite.Control().ViaBranch[:len(BranchPrefix)])
+}
+
+func TestNewCancel(t *testing.T) {
+	invite := NewRequest("INVITE", "sip:bob@biloxi.com")
+	invite.Control().Via = [][2]string{{"UDP", "192.0.2.1"}, {"UDP", "192.0.2.2"}}
+	invite.Control().ViaBranch = "z9hG4bK776asdhds"
+	invite.Control().Sequence = 314159
+	cancel := NewCancel(invite)
+	assert.Equal(t, "CANCEL", cancel.Method())
+	assert.Equal(t, "sip:bob@biloxi.com", cancel.Uri())
+	assert.Equal(t, [][2]string{{"UDP", "192.0.2.1"}}, cancel.Control().Via)
+	assert.Equal(t, "z9hG4bK776asdhds", cancel.Control().ViaBranch)
+	assert.Equal(t, 314159, cancel.Control().Sequence)
+}
+
+func TestRequestWith(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for in := range server.Receive() {
+			server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 180))
+			server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 302))
+		}
+	}()
+	var calls []string
+	handlers := ResponseHandlers{
+		OnProvisional: func(r *Response) { calls = append(calls, "provisional") },
+		OnRedirect:    func(r *Response) { calls = append(calls, "redirect") },
+		OnSuccess:     func(r *Response) { calls = append(calls, "success") },
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := NewRequestBuilder("MESSAGE").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	assert.Nil(t, err)
+	response, err := client.RequestWith(ctx, b.LocalAddr().String(), request, handlers)
+	assert.Nil(t, err)
+	assert.Equal(t, 302, response.StatusCode())
+	assert.Equal(t, []string{"provisional", "redirect"}, calls)
+}
+
+func TestTimersDefaults(t *testing.T) {
+	assert.Equal(t, DefaultTimers, Timers{}.withDefaults())
+	timers := Timers{T1: 100 * time.Millisecond, C: time.Minute}.withDefaults()
+	assert.Equal(t, 6400*time.Millisecond, timers.B)
+	assert.Equal(t, 6400*time.Millisecond, timers.F)
+	assert.Equal(t, 6400*time.Millisecond, timers.M)
+	assert.Equal(t, time.Minute, timers.C)
+	assert.Equal(t, T2, timers.T2)
+}
+
+func TestFollowRedirects(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	c, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, redirector, target := NewUserAgent(a), NewUserAgent(b), NewUserAgent(c)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+	redirectorUri := "sip:carol@" + b.LocalAddr().String()
+	targetUri := "sip:bob@" + c.LocalAddr().String()
+	go func() {
+		for in := range redirector.Receive() {
+			response := NewResponse(in.Message, 302)
+			if strings.HasPrefix(in.Message.Uri(), "sip:carol") {
+				// back to itself, written differently
+				response.Headers().Contacts = []AddressHeader{NewHeader(&Contact{}).SetUri(redirectorUri + ";foo=bar")}
+			} else {
+				response.Headers().Contacts = []AddressHeader{NewHeader(&Contact{}).SetUri(targetUri)}
+			}
+			redirector.Send(context.Background(), in.Source.String(), response)
+		}
+	}()
+	sequences := make(chan int, 1)
+	go func() {
+		for in := range target.Receive() {
+			sequences <- in.Message.Control().Sequence
+			target.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 200))
+		}
+	}()
+	newRequest := func(uri string) *Request {
+		request := NewRequest("OPTIONS", uri)
+		request.Headers().To = NewHeader(&ToFrom{}).SetUri(uri)
+		request.Headers().From = NewHeader(&ToFrom{}).SetUri("sip:alice@atlanta.com").SetParam("tag", "1")
+		request.Control().CallId = "a84b4c76e66710"
+		request.Control().Sequence = 1
+		return request
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// redirects are returned unless the UserAgent follows them
+	response, err := client.Request(ctx, "", newRequest("sip:bob@"+b.LocalAddr().String()))
+	assert.Nil(t, err)
+	assert.Equal(t, 302, response.StatusCode())
+
+	client.SetMaxRedirects(DefaultMaxRedirects)
+	request := newRequest("sip:bob@" + b.LocalAddr().String())
+	response, err = client.Request(ctx, "", request)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, targetUri, request.Uri())
+	assert.Equal(t, 2, <-sequences)
+
+	// a target equivalent to one tried already isn't tried again
+	response, err = client.Request(ctx, "", newRequest(redirectorUri))
+	assert.IsType(t, RedirectLoopError{}, err)
+	assert.Equal(t, 302, response.StatusCode())
+}
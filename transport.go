@@ -1 +1,203 @@
-package slurp
\ No newline at end of file
+package slurp
+
+/*
+Transports move raw SIP messages between the network and the rest of the
+stack. They know nothing about SIP beyond framing: parsing and
+transactions happen above them.
+*/
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/qmuloadmin/slurp/stun"
+	"github.com/qmuloadmin/slurp/trace"
+)
+
+// Packet is a single message received by a transport
+type Packet struct {
+	Data   []byte
+	Source net.Addr
+	buffer *[]byte
+}
+
+// Transport sends and receives raw SIP messages
+type Transport interface {
+	// Network returns the transport name used in Via, e.g. UDP
+	Network() string
+	LocalAddr() net.Addr
+	// PublicAddr returns the address peers should use to reach us, which
+	// is the reflexive address when one was discovered, e.g. through STUN
+	PublicAddr() net.Addr
+	Send(ctx context.Context, addr string, data []byte) error
+	// Receive returns the channel received messages are delivered on.
+	// It is closed when the transport is closed
+	Receive() <-chan Packet
+	Close() error
+}
+
+// UDPTransport is a Transport over a single UDP socket
+type UDPTransport struct {
+	// STUNServer, when set, is the host:port of the STUN server
+	// DiscoverPublicAddr queries for this transport's reflexive address
+	STUNServer string
+	// Tracer, when set, records every message sent and received
+	Tracer  *trace.Tracer
+	conn    *net.UDPConn
+	packets chan Packet
+	mu      sync.Mutex
+	public  *net.UDPAddr
+	// binding requests awaiting a response, by transaction ID
+	bindings map[stun.TransactionID]chan *net.UDPAddr
+}
+
+// ListenUDP creates a UDPTransport listening on address (host:port)
+func ListenUDP(address string) (*UDPTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &UDPTransport{
+		conn:     conn,
+		packets:  make(chan Packet, 64),
+		bindings: make(map[stun.TransactionID]chan *net.UDPAddr),
+	}
+	go t.read()
+	return t, nil
+}
+
+func (t *UDPTransport) read() {
+	defer close(t.packets)
+	buffer := make([]byte, 65535)
+	for {
+		n, source, err := t.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		// STUN shares the socket with SIP, so responses to our binding
+		// requests are picked out before anything is delivered
+		if stun.IsMessage(buffer[:n]) {
+			t.handleBinding(append([]byte(nil), buffer[:n]...))
+			continue
+		}
+		packet := newPacket(buffer[:n], source)
+		t.Tracer.Trace(trace.Record{
+			Network:     t.Network(),
+			Source:      source,
+			Destination: t.conn.LocalAddr(),
+			Data:        packet.Data,
+		})
+		t.packets <- packet
+	}
+}
+
+func (t *UDPTransport) handleBinding(data []byte) {
+	id, addr, err := stun.ParseBindingResponse(data)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	waiting, ok := t.bindings[id]
+	delete(t.bindings, id)
+	t.mu.Unlock()
+	if ok {
+		waiting <- addr
+	}
+}
+
+func (t *UDPTransport) Network() string {
+	return "UDP"
+}
+
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *UDPTransport) PublicAddr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.public != nil {
+		return t.public
+	}
+	return t.conn.LocalAddr()
+}
+
+func (t *UDPTransport) Send(ctx context.Context, addr string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetWriteDeadline(deadline)
+		defer t.conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err = t.conn.WriteToUDP(data, udpAddr); err != nil {
+		return err
+	}
+	t.Tracer.Trace(trace.Record{
+		Network:     t.Network(),
+		Source:      t.conn.LocalAddr(),
+		Destination: udpAddr,
+		Outbound:    true,
+		Data:        data,
+	})
+	return nil
+}
+
+func (t *UDPTransport) Receive() <-chan Packet {
+	return t.packets
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// DiscoverPublicAddr sends STUN binding requests to STUNServer through
+// the transport's own socket, so the discovered address is the one peers
+// see for our SIP traffic. The result is returned by PublicAddr afterwards.
+func (t *UDPTransport) DiscoverPublicAddr(ctx context.Context) (net.Addr, error) {
+	server, err := net.ResolveUDPAddr("udp", t.STUNServer)
+	if err != nil {
+		return nil, err
+	}
+	id, request := stun.NewBindingRequest()
+	response := make(chan *net.UDPAddr, 1)
+	t.mu.Lock()
+	t.bindings[id] = response
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.bindings, id)
+		t.mu.Unlock()
+	}()
+	timeout := stun.RTO
+	for i := 0; i < stun.MaxRetransmissions; i++ {
+		if _, err := t.conn.WriteToUDP(request, server); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case addr := <-response:
+			timer.Stop()
+			t.mu.Lock()
+			t.public = addr
+			t.mu.Unlock()
+			return addr, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+			timeout *= 2
+		}
+	}
+	return nil, stun.ErrTimeout
+}
@@ -0,0 +1,32 @@
+package slurp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPTransport(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	defer a.Close()
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer b.Close()
+	assert.Equal(t, a.LocalAddr(), a.PublicAddr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, a.Send(ctx, b.LocalAddr().String(), []byte("OPTIONS sip:b SIP/2.0\r\n\r\n")))
+	select {
+	case packet := <-b.Receive():
+		assert.Equal(t, "OPTIONS sip:b SIP/2.0\r\n\r\n", string(packet.Data))
+		assert.Equal(t, a.LocalAddr().String(), packet.Source.String())
+	case <-ctx.Done():
+		t.Fatal("packet not received")
+	}
+}
@@ -0,0 +1,78 @@
+package slurp
+
+/*
+The transaction user (TU) is the layer above the transactions: the
+dialogs, a proxy core, or the application. The UserAgent reports to it
+through the TransactionUser interface, so that another core, e.g. a
+custom proxy, can be built on the transaction layer alone.
+*/
+
+import "context"
+
+// TransactionUser receives the events of the transaction layer. Its
+// methods are called from the UserAgent's goroutines and must not block
+// for long
+type TransactionUser interface {
+	// RequestReceived is called with every request that passed the
+	// filters and isn't absorbed by a transaction
+	RequestReceived(in Incoming)
+	// ResponseReceived is called with the responses of transactions
+	// started with Start, and with responses matching no transaction
+	ResponseReceived(in Incoming)
+	// TransportError is called when sending a request of a client
+	// transaction to addr failed
+	TransportError(request Message, addr string, err error)
+	// Timeout is called when a client transaction got no final response
+	Timeout(request Message)
+}
+
+// channelUser is the default TransactionUser, delivering requests and
+// stray responses on the channel returned by Receive
+type channelUser struct {
+	incoming chan Incoming
+}
+
+func (u channelUser) RequestReceived(in Incoming) {
+	u.incoming <- in
+}
+
+func (u channelUser) ResponseReceived(in Incoming) {
+	u.incoming <- in
+}
+
+func (channelUser) TransportError(Message, string, error) {}
+
+func (channelUser) Timeout(Message) {}
+
+// SetTransactionUser makes tu receive the transaction layer's events in
+// place of the channel returned by Receive
+func (ua *UserAgent) SetTransactionUser(tu TransactionUser) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.tu = tu
+}
+
+// transactionUser returns the TransactionUser events go to
+func (ua *UserAgent) transactionUser() TransactionUser {
+	ua.mu.RLock()
+	defer ua.mu.RUnlock()
+	if ua.tu == nil {
+		return channelUser{ua.incoming}
+	}
+	return ua.tu
+}
+
+// Start runs a client transaction for request in the background, passing
+// each response to the TransactionUser's ResponseReceived as it arrives,
+// and failures to its TransportError or Timeout
+func (ua *UserAgent) Start(ctx context.Context, addr string, request Message) {
+	tu := ua.transactionUser()
+	received := func(response *Response) {
+		tu.ResponseReceived(Incoming{Message: response, Transport: ua.transport})
+	}
+	go func() {
+		if response, err := ua.request(ctx, addr, request, transactionHooks{provisional: received}); err == nil && response != nil {
+			received(response)
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package slurp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingUser is a TransactionUser recording every event
+type recordingUser struct {
+	requests  chan Incoming
+	responses chan Incoming
+	timeouts  chan Message
+	failures  chan error
+}
+
+func newRecordingUser() *recordingUser {
+	return &recordingUser{
+		requests:  make(chan Incoming, 8),
+		responses: make(chan Incoming, 8),
+		timeouts:  make(chan Message, 8),
+		failures:  make(chan error, 8),
+	}
+}
+
+func (u *recordingUser) RequestReceived(in Incoming)                   { u.requests <- in }
+func (u *recordingUser) ResponseReceived(in Incoming)                  { u.responses <- in }
+func (u *recordingUser) TransportError(_ Message, _ string, err error) { u.failures <- err }
+func (u *recordingUser) Timeout(request Message)                       { u.timeouts <- request }
+
+func TestTransactionUser(t *testing.T) {
+	a, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on UDP")
+	}
+	b, err := ListenUDP("127.0.0.1:0")
+	assert.Nil(t, err)
+	client, server := NewUserAgent(a), NewUserAgent(b)
+	defer a.Close()
+	defer b.Close()
+	clientUser, serverUser := newRecordingUser(), newRecordingUser()
+	client.SetTransactionUser(clientUser)
+	server.SetTransactionUser(serverUser)
+
+	client.Start(context.Background(), b.LocalAddr().String(), newTestInvite("sip:bob@biloxi.com"))
+	in := <-serverUser.requests
+	assert.Equal(t, "INVITE", in.Message.Method())
+	server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 180))
+	server.Send(context.Background(), in.Source.String(), NewResponse(in.Message, 486))
+	assert.Equal(t, 180, (<-clientUser.responses).Message.(*Response).StatusCode())
+	assert.Equal(t, 486, (<-clientUser.responses).Message.(*Response).StatusCode())
+
+	// nothing answers, so the transaction times out
+	client.SetTimers(Timers{T1: time.Millisecond})
+	request, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	client.Start(context.Background(), b.LocalAddr().String(), request)
+	<-serverUser.requests
+	select {
+	case timedOut := <-clientUser.timeouts:
+		assert.Equal(t, request, timedOut)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no timeout")
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Skip("can't listen on TCP")
+	}
+	defer a.Close()
+	// a port nothing listens on any more
+	closed, err := ListenTCP("127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := closed.LocalAddr().String()
+	closed.Close()
+
+	client := NewUserAgent(a)
+	user := newRecordingUser()
+	client.SetTransactionUser(user)
+	request, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Request(ctx, addr, request)
+	var transportErr TransportError
+	assert.True(t, errors.As(err, &transportErr))
+	assert.Equal(t, addr, transportErr.Addr)
+	assert.Equal(t, "OPTIONS", transportErr.Method)
+	assert.Equal(t, err, <-user.failures)
+	assert.True(t, isLost(err))
+}
+
+func TestShouldFailover(t *testing.T) {
+	request, _ := NewRequestBuilder("OPTIONS").To("sip:bob@biloxi.com").FromUri("sip:alice@atlanta.com").Build()
+	assert.True(t, shouldFailover(nil, TransportError{Addr: "192.0.2.1:5060"}))
+	assert.True(t, shouldFailover(nil, TimeoutError{Method: "OPTIONS"}))
+	assert.True(t, shouldFailover(NewResponse(request, 503), nil))
+	assert.False(t, shouldFailover(NewResponse(request, 486), nil))
+	assert.False(t, shouldFailover(nil, context.Canceled))
+}
@@ -0,0 +1,349 @@
+package slurp
+
+/*
+Headers with a structure have types of their own, so that callers deal
+with their fields rather than strings, and the compiler checks they do:
+an Address is a name-addr with parameters, e.g. P-Asserted-Identity or
+Refer-To, a Contact adds its q-value and expiry, a Via has its transport,
+sent-by and branch, and a NumericHeader is a number, e.g. Max-Forwards or
+Expires. GetHeader and GetHeaders read any of them out of a message by
+name, and SetHeader sets a header of a message to them.
+*/
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// ParsableHeader is a pointer to a header type T that parses from the
+// value of a header line, e.g. *Via. Types outside this package can
+// implement it to be read with GetHeader
+type ParsableHeader[T any] interface {
+	*T
+	Header
+	Parse(string) error
+}
+
+// messageHeaderValues returns the values of the named header in m, those
+// of repeated or comma-joined headers in order. Headers with a field of
+// their own are read from it, others from the Extensions
+func messageHeaderValues(m Message, name string) []string {
+	h, c := m.Headers(), m.Control()
+	addresses := func(headers ...AddressHeader) (values []string) {
+		for _, header := range headers {
+			if header != nil {
+				values = append(values, header.String())
+			}
+		}
+		return
+	}
+	nonEmpty := func(value string) []string {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+	switch canonicalName(name) {
+	case "via":
+		var values []string
+		for _, via := range Vias(m) {
+			values = append(values, via.String())
+		}
+		return values
+	case "max-forwards":
+		if h.Forward == 0 && !h.ForwardSet {
+			return []string{strconv.Itoa(DefaultMaxForwards)}
+		}
+		return []string{strconv.Itoa(h.Forward)}
+	case "from":
+		return addresses(h.From)
+	case "to":
+		return addresses(h.To)
+	case "contact":
+		return addresses(h.Contacts...)
+	case "alert-info":
+		return addresses(h.AlertInfo...)
+	case "call-info":
+		return addresses(h.CallInfo...)
+	case "error-info":
+		return addresses(h.ErrorInfo...)
+	case "call-id":
+		return nonEmpty(c.CallId)
+	case "cseq":
+		return []string{strconv.Itoa(c.Sequence) + " " + m.Method()}
+	case "date":
+		if h.Date.IsZero() {
+			return nil
+		}
+		return []string{h.Date.UTC().Format(DateFormat)}
+	case "timestamp":
+		if c.Timestamp == 0 {
+			return nil
+		}
+		timestamp := strconv.FormatFloat(c.Timestamp, 'f', -1, 64)
+		if c.TimestampDelay != 0 {
+			timestamp += " " + strconv.FormatFloat(c.TimestampDelay, 'f', -1, 64)
+		}
+		return []string{timestamp}
+	case "retry-after":
+		if h.RetryAfter == 0 {
+			return nil
+		}
+		return []string{strconv.Itoa(h.RetryAfter)}
+	case "organization":
+		return nonEmpty(h.Organization)
+	case "in-reply-to":
+		return append([]string(nil), h.InReplyTo...)
+	case "subject":
+		return nonEmpty(h.Subject)
+	case "priority":
+		return nonEmpty(string(h.Priority))
+	case "content-type":
+		return nonEmpty(h.ContentType)
+	case "content-length":
+		return []string{strconv.Itoa(h.ContentLength)}
+	}
+	return h.Extensions.GetAll(name)
+}
+
+// GetHeaders parses every value of the named header in m, which may be a
+// compact form, as a T, e.g. GetHeaders[Via](m, "Via"). It fails with a
+// Violation when one is invalid. Extension headers whose values hold
+// commas other than between list elements can't be read this way
+func GetHeaders[T any, P ParsableHeader[T]](m Message, name string) ([]P, error) {
+	values := messageHeaderValues(m, name)
+	headers := make([]P, 0, len(values))
+	for _, value := range values {
+		header := P(new(T))
+		if err := header.Parse(value); err != nil {
+			return nil, Violation{Header: HeaderName(name), Reason: "invalid value " + strconv.Quote(value)}
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// GetHeader parses the first value of the named header in m as a T, e.g.
+// GetHeader[NumericHeader](m, "Expires"). ok is false when m has none, or
+// it is invalid
+func GetHeader[T any, P ParsableHeader[T]](m Message, name string) (header P, ok bool) {
+	values := messageHeaderValues(m, name)
+	if len(values) == 0 {
+		return nil, false
+	}
+	header = P(new(T))
+	if err := header.Parse(values[0]); err != nil {
+		return nil, false
+	}
+	return header, true
+}
+
+// SetHeader replaces the named header of m by one listing headers, or
+// removes it when there are none. Headers with a field of their own in
+// CommonHeaders or CallControlHeaders, e.g. Via or Max-Forwards, are set
+// there, and m is left as it was when one of the headers isn't a valid
+// value for it, failing with a Violation. Others are extension headers
+func SetHeader[H Header](m Message, name string, headers ...H) error {
+	common, control := m.Headers().clone(), m.Control().clone()
+	if !clearHeader(name, &common, &control) {
+		if len(headers) == 0 {
+			m.Headers().Extensions.Remove(name)
+			return nil
+		}
+		values := make([]string, len(headers))
+		for i, header := range headers {
+			values[i] = header.String()
+		}
+		m.Headers().Extensions.Set(name, strings.Join(values, ", "))
+		return nil
+	}
+	for _, header := range headers {
+		if err := parseHeader(name, header.String(), &common, &control); err != nil {
+			return Violation{Header: HeaderName(name), Reason: "invalid value " + strconv.Quote(header.String())}
+		}
+	}
+	*m.Headers(), *m.Control() = common, control
+	return nil
+}
+
+// Address is a header holding a name-addr or addr-spec with parameters,
+// such as P-Asserted-Identity, Refer-To or Referred-By
+type Address struct {
+	value  string
+	uri    string
+	params Params
+}
+
+// NewAddress creates an Address for uri, with an optional display name
+// quoted as needed
+func NewAddress(name, uri string) *Address {
+	a := &Address{uri: uri}
+	SetDisplayName(a, name)
+	return a
+}
+
+func (a *Address) Init() AddressHeader {
+	*a = Address{}
+	return a
+}
+
+// Value returns the display name, as it renders
+func (a *Address) Value() string {
+	return a.value
+}
+
+func (a *Address) Param(name string) string {
+	value, _ := a.params.Get(name)
+	return value
+}
+
+// Params returns the parameters of the address, which may be changed
+func (a *Address) Params() *Params {
+	return &a.params
+}
+
+func (a *Address) Uri() string {
+	return a.uri
+}
+
+func (a *Address) SetValue(value string) AddressHeader {
+	a.value = value
+	return a
+}
+
+func (a *Address) SetParam(name, value string) AddressHeader {
+	a.params.Set(name, value)
+	return a
+}
+
+func (a *Address) SetUri(uri string) AddressHeader {
+	a.uri = uri
+	return a
+}
+
+func (a *Address) ParamString() string {
+	return a.params.String()
+}
+
+// String renders the URI in angle brackets, so that its parameters aren't
+// taken for those of the header
+func (a *Address) String() string {
+	return renderAddress(a.value, a.uri, a.ParamString())
+}
+
+// Parse reads an address. Parameters after a URI without angle brackets
+// are those of the header
+func (a *Address) Parse(value string) error {
+	a.Init()
+	name, uri, params := splitNameAddr(value)
+	if uri == "" {
+		return InvalidMessageFormatError(value)
+	}
+	a.value, a.uri = name, uri
+	parseParamsInto(params, a)
+	return nil
+}
+
+// Via is a Via header: the transport and the address a request was sent
+// from, with parameters, the branch among them
+type Via struct {
+	// Transport, e.g. UDP or TLS
+	Transport string
+	// SentBy is the host, and port when there is one
+	SentBy string
+	params Params
+}
+
+// NewVia creates a Via for a request sent over transport from sentBy,
+// with its branch
+func NewVia(transport, sentBy, branch string) *Via {
+	v := &Via{Transport: transport, SentBy: sentBy}
+	v.params.Set("branch", branch)
+	return v
+}
+
+// Branch returns the branch parameter, identifying the transaction
+func (v *Via) Branch() string {
+	return v.Param("branch")
+}
+
+// Param returns the value of the named parameter, compared case
+// insensitively, or "" when it has none or it is a flag, e.g. rport
+func (v *Via) Param(name string) string {
+	value, _ := v.params.Get(strings.ToLower(name))
+	return value
+}
+
+// Params returns the parameters of the Via, which may be changed
+func (v *Via) Params() *Params {
+	return &v.params
+}
+
+func (v *Via) String() string {
+	return "SIP/2.0/" + v.Transport + " " + v.SentBy + v.params.String()
+}
+
+// Parse reads a Via value, e.g. SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds
+func (v *Via) Parse(value string) error {
+	*v = Via{}
+	sent, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+	protocol, sentBy, ok := strings.Cut(strings.TrimSpace(sent), " ")
+	parts := strings.Split(protocol, "/")
+	sentBy = strings.TrimSpace(sentBy)
+	if !ok || len(parts) != 3 || !strings.EqualFold(parts[0], "SIP") || sentBy == "" {
+		return InvalidMessageFormatError(value)
+	}
+	v.Transport, v.SentBy = strings.ToUpper(parts[2]), sentBy
+	v.setParams(params)
+	return nil
+}
+
+// setParams sets the parameters of a raw parameter string
+func (v *Via) setParams(params string) {
+	for _, param := range splitUnquoted(params, ';') {
+		name, value, _ := strings.Cut(param, "=")
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			v.params.Set(name, strings.TrimSpace(value))
+		}
+	}
+}
+
+// Vias returns the Vias of the message, topmost first, from its
+// CallControlHeaders
+func Vias(m Message) []*Via {
+	control := m.Control()
+	vias := make([]*Via, len(control.Via))
+	for i, each := range control.Via {
+		via := &Via{Transport: strings.ToUpper(each[0]), SentBy: each[1]}
+		if i == 0 && control.ViaBranch != "" {
+			via.params.Set("branch", control.ViaBranch)
+		}
+		if i < len(control.ViaParams) {
+			via.setParams(control.ViaParams[i])
+		}
+		vias[i] = via
+	}
+	return vias
+}
+
+// NumericHeader is a header whose value is a number, such as Max-Forwards,
+// Expires or RSeq
+type NumericHeader struct {
+	Value int
+}
+
+func (h *NumericHeader) String() string {
+	return strconv.Itoa(h.Value)
+}
+
+// Parse reads a non-negative decimal number
+func (h *NumericHeader) Parse(value string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return InvalidMessageFormatError(value)
+	}
+	h.Value = n
+	return nil
+}
@@ -0,0 +1,116 @@
+package slurp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedHeaders(t *testing.T) {
+	m, err := ParseMessage(strings.Join([]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0",
+		"Via: SIP/2.0/UDP bigbox3.site3.atlanta.com;branch=z9hG4bK77ef4c2312983.1",
+		"v: SIP/2.0/tcp pc33.atlanta.com;branch=z9hG4bKnashds8;received=192.0.2.1;rport",
+		"Max-Forwards: 69",
+		"To: Bob <sip:bob@biloxi.com>",
+		"From: \"Alice, A.\" <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-ID: a84b4c76e66710",
+		"CSeq: 314159 INVITE",
+		"Contact: <sip:alice@pc33.atlanta.com>;expires=3600;q=0.5, <sip:alice@192.0.2.1>",
+		"P-Asserted-Identity: \"Alice\" <sip:alice@atlanta.com;user=phone>, <tel:+14085551212>",
+		"Expires: 120",
+		"Content-Length: 0",
+		"", "",
+	}, "\r\n"))
+	assert.Nil(t, err)
+
+	vias, err := GetHeaders[Via](m, "Via")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(vias)) {
+		assert.Equal(t, "z9hG4bK77ef4c2312983.1", vias[0].Branch())
+		assert.Equal(t, "TCP", vias[1].Transport)
+		assert.Equal(t, "pc33.atlanta.com", vias[1].SentBy)
+		assert.Equal(t, "192.0.2.1", vias[1].Param("Received"))
+		_, rport := vias[1].Params().Get("rport")
+		assert.True(t, rport)
+	}
+	assert.Equal(t, vias[1].String(), Vias(m)[1].String())
+	assert.Equal(t, "SIP/2.0/UDP bigbox3.site3.atlanta.com;branch=z9hG4bK77ef4c2312983.1", Vias(m)[0].String())
+
+	identities, err := GetHeaders[Address](m, "P-Asserted-Identity")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(identities)) {
+		assert.Equal(t, "Alice", DisplayName(identities[0]))
+		assert.Equal(t, "sip:alice@atlanta.com;user=phone", identities[0].Uri())
+		assert.Equal(t, "tel:+14085551212", identities[1].Uri())
+	}
+	contacts, err := GetHeaders[Contact](m, "m")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(contacts)) {
+		expires, ok := contacts[0].Expires()
+		assert.True(t, ok)
+		assert.Equal(t, 3600, expires)
+		assert.Equal(t, 0.5, contacts[0].Q())
+		_, ok = contacts[1].Expires()
+		assert.False(t, ok)
+	}
+	from, ok := GetHeader[ToFrom](m, "From")
+	assert.True(t, ok)
+	assert.Equal(t, "1928301774", from.Param("tag"))
+	assert.Equal(t, "Alice, A.", DisplayName(from))
+	forwards, ok := GetHeader[NumericHeader](m, "Max-Forwards")
+	assert.True(t, ok)
+	assert.Equal(t, 69, forwards.Value)
+	_, ok = GetHeader[NumericHeader](m, "RSeq")
+	assert.False(t, ok)
+
+	// the headers of a message being built are read from its fields
+	m.Headers().Date = time.Date(2010, 11, 13, 23, 29, 0, 0, time.UTC)
+	assert.Equal(t, []string{"Sat, 13 Nov 2010 23:29:00 GMT"}, messageHeaderValues(m, "Date"))
+	m.Headers().Forward = 5
+	forwards, _ = GetHeader[NumericHeader](m, "Max-Forwards")
+	assert.Equal(t, 5, forwards.Value)
+	SetHeader(m, "Referred-By", NewAddress("Carol, C.", "sip:carol@chicago.com"))
+	SetHeader(m, "Expires", &NumericHeader{Value: 60})
+	referredBy, ok := GetHeader[Address](m, "Referred-By")
+	assert.True(t, ok)
+	assert.Equal(t, "\"Carol, C.\" <sip:carol@chicago.com>", referredBy.String())
+	expires, _ := GetHeader[NumericHeader](m, "Expires")
+	assert.Equal(t, 60, expires.Value)
+	SetHeader[*Address](m, "Referred-By")
+	_, ok = GetHeader[Address](m, "Referred-By")
+	assert.False(t, ok)
+
+	// headers with a field of their own are set there, not duplicated as
+	// extensions
+	assert.Nil(t, SetHeader(m, "Max-Forwards", &NumericHeader{Value: 0}))
+	assert.Equal(t, 0, m.Headers().Forward)
+	forwards, _ = GetHeader[NumericHeader](m, "Max-Forwards")
+	assert.Equal(t, 0, forwards.Value)
+	assert.Nil(t, SetHeader(m, "v", NewVia("TLS", "client.atlanta.com:5061", "z9hG4bK74bf9")))
+	vias, _ = GetHeaders[Via](m, "Via")
+	if assert.Equal(t, 1, len(vias)) {
+		assert.Equal(t, "client.atlanta.com:5061", vias[0].SentBy)
+		assert.Equal(t, "z9hG4bK74bf9", m.Control().ViaBranch)
+	}
+	contact := NewHeader(&Contact{}).SetUri("sip:alice@192.0.2.2").SetParam("q", "0.7")
+	assert.Nil(t, SetHeader(m, "Contact", contact))
+	contacts, _ = GetHeaders[Contact](m, "Contact")
+	if assert.Equal(t, 1, len(contacts)) {
+		assert.Equal(t, 0.7, contacts[0].Q())
+	}
+	for _, name := range []string{"Max-Forwards", "Via", "Contact"} {
+		assert.Equal(t, "", m.Headers().Extensions.Get(name))
+	}
+	// a value the field can't hold is rejected, leaving the message as is
+	assert.IsType(t, Violation{}, SetHeader(m, "Via", NewVia("UDP", "", "z9hG4bK1"), NewVia("UDP", "pc33.atlanta.com", "z9hG4bK2")))
+	assert.Equal(t, "z9hG4bK74bf9", m.Control().ViaBranch)
+
+	m.Headers().Extensions.Set("Expires", "soon")
+	_, err = GetHeaders[NumericHeader](m, "Expires")
+	assert.IsType(t, Violation{}, err)
+	assert.NotNil(t, new(Via).Parse("pc33.atlanta.com;branch=z9hG4bK1"))
+}
@@ -0,0 +1,96 @@
+package slurp
+
+/*
+A session can be modified, or refreshed to show it is still alive, with a
+re-INVITE or with an UPDATE (RFC 3311). UPDATE completes in one round trip
+and works on early dialogs too, but only when the peer allows it, so the
+UserAgent falls back to re-INVITE for peers whose Allow lacks UPDATE.
+*/
+
+import (
+	"context"
+
+	. "github.com/qmuloadmin/slurp/errors"
+	"github.com/qmuloadmin/slurp/sdp"
+)
+
+// SetPreferUpdate makes the UserAgent modify and refresh sessions with
+// UPDATE rather than re-INVITE, for peers that allow it
+func (ua *UserAgent) SetPreferUpdate(prefer bool) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+	ua.preferUpdate = prefer
+}
+
+// modifyMethod returns the method to modify or refresh the dialog's
+// session with: UPDATE when preferred and allowed, or when the dialog is
+// early and re-INVITE can't be used, and INVITE otherwise
+func (ua *UserAgent) modifyMethod(d *Dialog) string {
+	ua.mu.RLock()
+	prefer := ua.preferUpdate
+	ua.mu.RUnlock()
+	d.mu.Lock()
+	early := d.State == Early
+	d.mu.Unlock()
+	if early || prefer && d.Allows("UPDATE") {
+		return "UPDATE"
+	}
+	return "INVITE"
+}
+
+// Update sends an UPDATE on the dialog to addr, offering offer, or only
+// refreshing the session when offer is nil, and returns the answer. It
+// works on early dialogs, e.g. to change early media before the call is
+// answered. Failures leave the session as it was and are returned as a
+// RejectedError, though a 481 or 408 means the dialog is gone and ends it,
+// as does an UPDATE that can't be sent or isn't answered
+func (ua *UserAgent) Update(ctx context.Context, addr string, d *Dialog, offer *sdp.Session) (*sdp.Session, error) {
+	response, err := ua.Request(ctx, addr, d.NewUpdate(offer))
+	if err != nil {
+		if isLost(err) {
+			d.Terminate(ReasonPhrase(408))
+		}
+		return nil, err
+	}
+	code := response.StatusCode()
+	if !IsSuccess(code) {
+		if code == 481 || code == 408 {
+			d.Terminate(response.Reason())
+		}
+		return nil, RejectedError{StatusCode: code, Reason: response.Reason()}
+	}
+	d.modified(offer, response)
+	if offer == nil {
+		return nil, nil
+	}
+	return messageSDP(response)
+}
+
+// Modify changes the session of the call, offering offer, and returns the
+// answer. It uses UPDATE when the UserAgent prefers it and the peer allows
+// it, and a re-INVITE otherwise
+func (c *Call) Modify(ctx context.Context, offer *sdp.Session) (*sdp.Session, error) {
+	if c.ua.modifyMethod(c.Dialog) == "UPDATE" {
+		return c.ua.Update(ctx, c.Addr, c.Dialog, offer)
+	}
+	return c.Reinvite(ctx, offer)
+}
+
+// Refresh shows the peer the session is still alive, e.g. for a session
+// timer, without changing it: with an UPDATE without a body when the
+// UserAgent prefers it and the peer allows it, and otherwise with a
+// re-INVITE offering the session in use again
+func (c *Call) Refresh(ctx context.Context) error {
+	if c.ua.modifyMethod(c.Dialog) == "UPDATE" {
+		_, err := c.ua.Update(ctx, c.Addr, c.Dialog, nil)
+		return err
+	}
+	c.Dialog.mu.Lock()
+	local := c.Dialog.LocalSDP
+	c.Dialog.mu.Unlock()
+	if local == nil {
+		return Violation{Header: "Content-Type", Reason: "no session description to refresh the session with"}
+	}
+	_, err := c.Reinvite(ctx, local)
+	return err
+}
@@ -0,0 +1,183 @@
+package slurp
+
+/*
+SIP URIs are compared component by component rather than as strings
+(RFC 3261 19.1.4): the user part is case-sensitive but the host isn't,
+escaped characters equal their unescaped form, a default port doesn't
+equal an absent one, and only some parameters are significant. Addresses
+of record and Contacts, as a registrar keeps them, are compared on top of
+these rules.
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SipUri is a sip or sips URI, split into its components
+type SipUri struct {
+	// Scheme is sip or sips, in lower case
+	Scheme   string
+	User     string
+	Password string
+	// Host is in lower case, and Port zero when the URI has none
+	Host string
+	Port int
+	// Params are the URI parameters by lower case name, with an empty
+	// value for those without one, such as lr. Headers are the headers
+	// after the ?, by name as given. Both are unescaped
+	Params  map[string]string
+	Headers map[string]string
+}
+
+// unescape decodes the escaped characters of a URI component, leaving
+// malformed escapes as they are
+func unescape(component string) string {
+	if unescaped, err := url.PathUnescape(component); err == nil {
+		return unescaped
+	}
+	return component
+}
+
+// ParseSipUri parses a sip or sips URI, which may be given as a name-addr
+// such as "Bob" <sip:bob@biloxi.com>
+func ParseSipUri(uri string) (*SipUri, error) {
+	spec := addrSpec(uri)
+	scheme := UriScheme(spec)
+	if scheme != "sip" && scheme != "sips" {
+		return nil, fmt.Errorf("not a SIP URI: %q", uri)
+	}
+	u := &SipUri{Scheme: scheme, Params: map[string]string{}, Headers: map[string]string{}}
+	rest := spec[len(scheme)+1:]
+	if question := strings.Index(rest, "?"); question >= 0 {
+		for _, header := range strings.Split(rest[question+1:], "&") {
+			if name, value, _ := strings.Cut(header, "="); name != "" {
+				u.Headers[unescape(name)] = unescape(value)
+			}
+		}
+		rest = rest[:question]
+	}
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		userinfo := rest[:at]
+		rest = rest[at+1:]
+		user, password, _ := strings.Cut(userinfo, ":")
+		u.User, u.Password = unescape(user), unescape(password)
+	}
+	params := strings.Split(rest, ";")
+	for _, param := range params[1:] {
+		if name, value, _ := strings.Cut(param, "="); name != "" {
+			u.Params[strings.ToLower(unescape(name))] = unescape(value)
+		}
+	}
+	hostport := params[0]
+	host, port := hostport, ""
+	if strings.HasPrefix(hostport, "[") {
+		// an IPv6 reference, whose colons aren't the port's
+		if end := strings.Index(hostport, "]"); end >= 0 {
+			host, port = hostport[:end+1], strings.TrimPrefix(hostport[end+1:], ":")
+		}
+	} else if colon := strings.LastIndex(hostport, ":"); colon >= 0 {
+		host, port = hostport[:colon], hostport[colon+1:]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no host in URI %q", uri)
+	}
+	u.Host = strings.ToLower(host)
+	if port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil || number <= 0 || number > 65535 {
+			return nil, fmt.Errorf("invalid port in URI %q", uri)
+		}
+		u.Port = number
+	}
+	return u, nil
+}
+
+// significantParams must match whenever either URI has them
+var significantParams = []string{"user", "ttl", "method", "maddr", "transport"}
+
+// Equal reports whether u and other are equivalent, per RFC 3261 19.1.4.
+// The parameters significantParams lists must be in both or neither;
+// others only need to match when both have them. Every header must be
+// in both
+func (u *SipUri) Equal(other *SipUri) bool {
+	if u.Scheme != other.Scheme || u.User != other.User || u.Password != other.Password ||
+		u.Host != other.Host || u.Port != other.Port {
+		return false
+	}
+	for _, name := range significantParams {
+		_, ok := u.Params[name]
+		_, otherOk := other.Params[name]
+		if ok != otherOk {
+			return false
+		}
+	}
+	for name, value := range u.Params {
+		if otherValue, ok := other.Params[name]; ok && !strings.EqualFold(value, otherValue) {
+			return false
+		}
+	}
+	if len(u.Headers) != len(other.Headers) {
+		return false
+	}
+	for name, value := range u.Headers {
+		otherValue, ok := other.Headers[name]
+		if !ok {
+			// header names are case-insensitive
+			for otherName, each := range other.Headers {
+				if strings.EqualFold(name, otherName) {
+					otherValue, ok = each, true
+				}
+			}
+		}
+		if !ok || value != otherValue {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualUris reports whether two URIs are equivalent. SIP URIs are
+// compared per RFC 3261 19.1.4, and others, e.g. tel URIs, as strings
+// regardless of case
+func EqualUris(a, b string) bool {
+	ua, errA := ParseSipUri(a)
+	ub, errB := ParseSipUri(b)
+	if errA != nil || errB != nil {
+		return errA != nil && errB != nil && strings.EqualFold(addrSpec(a), addrSpec(b))
+	}
+	return ua.Equal(ub)
+}
+
+// AddressOfRecord returns the canonical form of the address-of-record
+// uri is for, as a registrar keys bindings by it (RFC 3261 10.3): the URI
+// without parameters or headers, with escaped characters decoded and the
+// host in lower case. URIs that aren't sip or sips are returned as they are
+func AddressOfRecord(uri string) string {
+	u, err := ParseSipUri(uri)
+	if err != nil {
+		return addrSpec(uri)
+	}
+	aor := u.Scheme + ":" + u.Host
+	if u.User != "" {
+		aor = u.Scheme + ":" + u.User + "@" + u.Host
+	}
+	if u.Port != 0 {
+		aor += ":" + strconv.Itoa(u.Port)
+	}
+	return aor
+}
+
+// SameAOR reports whether the URIs are for the same address-of-record
+func SameAOR(a, b string) bool {
+	return AddressOfRecord(a) == AddressOfRecord(b)
+}
+
+// SameContact reports whether two Contacts point to the same URI, as a
+// registrar decides whether a REGISTER refreshes a binding. The header
+// parameters, e.g. expires and q, don't count
+func SameContact(a, b AddressHeader) bool {
+	return EqualUris(contactUri(a), contactUri(b))
+}
@@ -0,0 +1,58 @@
+package slurp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSipUri(t *testing.T) {
+	u, err := ParseSipUri(`"Bob" <sips:bob:secret@[2001:db8::10]:5061;Transport=TCP;lr?subject=project%20x>`)
+	assert.Nil(t, err)
+	assert.Equal(t, "sips", u.Scheme)
+	assert.Equal(t, "bob", u.User)
+	assert.Equal(t, "secret", u.Password)
+	assert.Equal(t, "[2001:db8::10]", u.Host)
+	assert.Equal(t, 5061, u.Port)
+	assert.Equal(t, map[string]string{"transport": "TCP", "lr": ""}, u.Params)
+	assert.Equal(t, map[string]string{"subject": "project x"}, u.Headers)
+
+	_, err = ParseSipUri("tel:+1-201-555-0123")
+	assert.NotNil(t, err)
+	_, err = ParseSipUri("sip:bob@biloxi.com:99999")
+	assert.NotNil(t, err)
+}
+
+func TestEqualUris(t *testing.T) {
+	// the examples of RFC 3261 19.1.4
+	for _, pair := range [][2]string{
+		{"sip:%61lice@atlanta.com;transport=TCP", "sip:alice@AtLanTa.CoM;Transport=tcp"},
+		{"sip:carol@chicago.com", "sip:carol@chicago.com;newparam=5"},
+		{"sip:carol@chicago.com;security=on", "sip:carol@chicago.com;newparam=5"},
+		{"sip:biloxi.com;transport=tcp;method=REGISTER?to=sip:bob%40biloxi.com", "sip:biloxi.com;method=REGISTER;transport=tcp?to=sip:bob%40biloxi.com"},
+		{"sip:alice@atlanta.com?subject=project%20x&priority=urgent", "sip:alice@atlanta.com?priority=urgent&subject=project%20x"},
+	} {
+		assert.True(t, EqualUris(pair[0], pair[1]), "%s = %s", pair[0], pair[1])
+	}
+	for _, pair := range [][2]string{
+		{"SIP:ALICE@AtLanTa.CoM;Transport=udp", "sip:alice@AtLanTa.CoM;Transport=UDP"},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com:5060"},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com;transport=udp"},
+		{"sip:bob@biloxi.com", "sips:bob@biloxi.com"},
+		{"sip:carol@chicago.com;security=on", "sip:carol@chicago.com;security=off"},
+		{"sip:alice@atlanta.com?subject=project%20x&priority=urgent", "sip:alice@atlanta.com?subject=Lunch&priority=urgent"},
+		{"sip:alice@atlanta.com?subject=project%20x", "sip:alice@atlanta.com"},
+	} {
+		assert.False(t, EqualUris(pair[0], pair[1]), "%s != %s", pair[0], pair[1])
+	}
+	assert.True(t, EqualUris("tel:+12015550123", "TEL:+12015550123"))
+}
+
+func TestAddressOfRecord(t *testing.T) {
+	assert.Equal(t, "sips:bob@biloxi.com:5061", AddressOfRecord("<sips:%62ob@Biloxi.com:5061;transport=tls?subject=hi>"))
+	assert.True(t, SameAOR("sip:bob@biloxi.com;user=phone", "sip:bob@BILOXI.COM"))
+	assert.False(t, SameAOR("sip:bob@biloxi.com", "sip:Bob@biloxi.com"))
+	assert.True(t, SameContact(
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4").SetParam("expires", "60"),
+		NewHeader(&Contact{}).SetUri("sip:bob@192.0.2.4;foo=bar")))
+}
@@ -0,0 +1,84 @@
+package slurp
+
+import (
+	"strings"
+
+	. "github.com/qmuloadmin/slurp/errors"
+)
+
+// methodHeaders are the headers requests of a method must have, besides
+// those every request has (RFC 3261 tables 2 and 3, RFC 3262, 3311, 3515
+// and 6665)
+var methodHeaders = map[string][]string{
+	"INVITE":    {"Contact"},
+	"UPDATE":    {"Contact"},
+	"SUBSCRIBE": {"Event", "Contact"},
+	"NOTIFY":    {"Event", "Subscription-State", "Contact"},
+	"REFER":     {"Refer-To", "Contact"},
+	"PRACK":     {"RAck"},
+}
+
+// validateMessage implements Validate for all message types.
+// request is false for responses, which have slightly different rules
+func validateMessage(m Message, request bool) (violations []Violation) {
+	add := func(header, reason string) {
+		violations = append(violations, Violation{Header: header, Reason: reason})
+	}
+	h := m.Headers()
+	c := m.Control()
+	if len(c.Via) == 0 {
+		add("Via", "missing mandatory header")
+	}
+	if h.To == nil || h.To.Uri() == "" {
+		add("To", "missing mandatory header")
+	}
+	if h.From == nil || h.From.Uri() == "" {
+		add("From", "missing mandatory header")
+	}
+	if c.CallId == "" {
+		add("Call-ID", "missing mandatory header")
+	}
+	if c.Sequence == 0 && c.CSeqMethod == "" {
+		add("CSeq", "missing mandatory header")
+	} else if c.CSeqMethod != "" && !strings.EqualFold(c.CSeqMethod, m.Method()) {
+		add("CSeq", "method "+c.CSeqMethod+" does not match "+m.Method())
+	}
+	if c.Sequence < 0 {
+		add("CSeq", "sequence number must not be negative")
+	}
+	// a request being built renders the default Max-Forwards and a
+	// Contact, so only those received can lack them
+	received := m.RawHeaders() != ""
+	if request && received && !h.ForwardSet {
+		add("Max-Forwards", "missing mandatory header")
+	} else if request && h.Forward < 0 {
+		add("Max-Forwards", "must not be negative")
+	}
+	if request {
+		for _, name := range methodHeaders[m.Method()] {
+			present := m.HeaderValue(name) != "" || h.Extensions.Get(name) != ""
+			if name == "Contact" {
+				present = len(h.Contacts) > 0 || !received
+			}
+			if !present {
+				add(name, "missing mandatory header for "+m.Method())
+			}
+		}
+	}
+	if h.ContentLength != len(m.Payload()) && (h.ContentType != "" || len(m.Payload()) > 0) {
+		add("Content-Length", "does not match the length of the body")
+	}
+	if len(m.Payload()) > 0 && h.ContentType == "" {
+		add("Content-Type", "missing for a message with a body")
+	}
+	// Tag rules: requests always carry a From tag, and every response
+	// but 100 Trying carries a To tag
+	if request && h.From != nil && h.From.Param("tag") == "" {
+		add("From", "missing tag")
+	}
+	if response, ok := m.(*Response); ok && response.StatusCode() > 100 &&
+		h.To != nil && h.To.Param("tag") == "" {
+		add("To", "missing tag")
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package slurp
+
+import (
+	"reflect"
+	"strings"
+)
+
+// PreserveWireFormat enables round-trip fidelity. When true, Parse keeps
+// the exact text of every header line, and Render reproduces each header
+// unchanged unless its value was modified after parsing. This allows slurp
+// to be used for monitoring or relaying without normalizing messages.
+var PreserveWireFormat = false
+
+// wireFormat is the original text of a parsed message, along with how
+// the message rendered right after parsing, so changes can be detected
+type wireFormat struct {
+	start         string
+	lines         []string
+	names         []string
+	renderedStart string
+	rendered      map[string][]string
+}
+
+// newWireFormat records the original header section of a message and
+// its normalized rendering at parse time
+func newWireFormat(head string, rendered string) *wireFormat {
+	w := &wireFormat{}
+	lines := strings.Split(head, "\n")
+	w.start = strings.TrimRight(lines[0], "\r")
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		w.lines = append(w.lines, line)
+		w.names = append(w.names, canonicalName(strings.SplitN(line, ":", 2)[0]))
+	}
+	w.renderedStart, _, w.rendered = groupRendered(rendered)
+	return w
+}
+
+// preserveWire records the wire format of a just parsed message when
+// PreserveWireFormat is enabled. render must not apply a wire format itself
+func preserveWire(head string, render func() string) *wireFormat {
+	if !PreserveWireFormat {
+		return nil
+	}
+	return newWireFormat(head, render())
+}
+
+// groupRendered splits a rendered message into its start line and header
+// lines grouped by canonical name, also returning the order names appeared in
+func groupRendered(rendered string) (string, []string, map[string][]string) {
+	lines := strings.Split(rendered, "\r\n")
+	var order []string
+	groups := make(map[string][]string)
+	for _, line := range lines[1:] {
+		if line == "" {
+			break
+		}
+		name := canonicalName(strings.SplitN(line, ":", 2)[0])
+		if _, ok := groups[name]; !ok {
+			order = append(order, name)
+		}
+		groups[name] = append(groups[name], line)
+	}
+	return lines[0], order, groups
+}
+
+// apply replaces the headers of a freshly rendered message with their
+// original text wherever they are unchanged since parsing. Headers the
+// parser doesn't understand are always kept. It is safe to call on nil
+func (w *wireFormat) apply(rendered string) string {
+	if w == nil {
+		return rendered
+	}
+	start, order, current := groupRendered(rendered)
+	if start == w.renderedStart {
+		start = w.start
+	}
+	lines := []string{start}
+	done := make(map[string]bool)
+	for i, line := range w.lines {
+		name := w.names[i]
+		original, known := w.rendered[name]
+		switch {
+		case !known && current[name] == nil:
+			// not understood by the parser, so it can't have changed
+			lines = append(lines, line)
+		case reflect.DeepEqual(original, current[name]):
+			lines = append(lines, line)
+			done[name] = true
+		case !done[name]:
+			// modified, so render the new value where the header was
+			lines = append(lines, current[name]...)
+			done[name] = true
+		}
+	}
+	for _, name := range order {
+		// headers the renderer adds by default are only new if they changed
+		if !done[name] && !w.has(name) && !reflect.DeepEqual(w.rendered[name], current[name]) {
+			lines = append(lines, current[name]...)
+		}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n\r\n"
+}
+
+// has reports whether the original message contained the header
+func (w *wireFormat) has(name string) bool {
+	for _, each := range w.names {
+		if each == name {
+			return true
+		}
+	}
+	return false
+}